@@ -0,0 +1,231 @@
+package main
+
+import (
+	"cmp"
+	"context"
+	"fmt"
+	"io"
+	"os"
+	"os/signal"
+	"slices"
+	"strings"
+	"syscall"
+	"text/tabwriter"
+	"time"
+
+	"github.com/spf13/cobra"
+	"go.uber.org/zap"
+	gocloudblob "gocloud.dev/blob"
+
+	"github.com/breezewish/gscache/internal/cache/backends/blob"
+	"github.com/breezewish/gscache/internal/log"
+	"github.com/breezewish/gscache/internal/util"
+)
+
+var archiveCmd = &cobra.Command{
+	Use:   "archive",
+	Short: "Inspect BlobArchive files produced by compaction",
+}
+
+type archiveInspectOpts struct {
+	remote  bool
+	sort    string
+	extract string
+	output  string
+}
+
+var archiveSortKeys = []string{"name", "size", "time", "ratio"}
+
+// downloadArchive downloads the archive of the keyspace from the remote into a temp file.
+// The caller must remove the file.
+func downloadArchive(url, keyspace string) (string, error) {
+	if url == "" {
+		return "", fmt.Errorf("blob.url is not set")
+	}
+	ctx, stop := signal.NotifyContext(context.Background(), syscall.SIGINT, syscall.SIGTERM)
+	defer stop()
+	bucket, err := gocloudblob.OpenBucket(ctx, url)
+	if err != nil {
+		return "", fmt.Errorf("failed to open %s: %w", url, err)
+	}
+	defer bucket.Close()
+	r, err := bucket.NewReader(ctx, blob.ArchiveKey(keyspace), nil)
+	if err != nil {
+		return "", fmt.Errorf("failed to download %s: %w", blob.ArchiveKey(keyspace), err)
+	}
+	defer r.Close()
+	f, err := os.CreateTemp("", "gscache-archive-*.zip")
+	if err != nil {
+		return "", err
+	}
+	if _, err := io.Copy(f, r); err != nil {
+		_ = f.Close()
+		_ = os.Remove(f.Name())
+		return "", fmt.Errorf("failed to download %s: %w", blob.ArchiveKey(keyspace), err)
+	}
+	if err := f.Close(); err != nil {
+		_ = os.Remove(f.Name())
+		return "", err
+	}
+	return f.Name(), nil
+}
+
+// resolveArchive returns the archive file of target, which is either a keyspace or a path.
+// cleanup removes the downloaded copy of a remote archive.
+func resolveArchive(target string, remote bool) (path string, cleanup func(), err error) {
+	cleanup = func() {}
+	if !slices.Contains(blob.ArchiveKeyspaces, strings.ToLower(target)) {
+		if remote {
+			return "", cleanup, fmt.Errorf("--remote requires a keyspace (one of %s), got %q", strings.Join(blob.ArchiveKeyspaces, ""), target)
+		}
+		return target, cleanup, nil
+	}
+	keyspace := strings.ToLower(target)
+	if !remote {
+		return blob.ArchiveFilePath(getServerConfig().Dir, keyspace), cleanup, nil
+	}
+	path, err = downloadArchive(getServerConfig().Blob.URL, keyspace)
+	if err != nil {
+		return "", cleanup, err
+	}
+	return path, func() { _ = os.Remove(path) }, nil
+}
+
+func compressionRatio(size int64, compressed uint64) float64 {
+	if size == 0 {
+		return 1
+	}
+	return float64(compressed) / float64(size)
+}
+
+func extractArchiveEntry(ar *blob.ArReader, actionIDHex, output string) error {
+	actionID, err := parseHexID("actionID", actionIDHex)
+	if err != nil {
+		return err
+	}
+	entry := ar.Get(blob.CacheEntityNameInArchive(actionID))
+	if entry == nil {
+		return fmt.Errorf("entry %x is not in the archive", actionID)
+	}
+	r, err := entry.Open()
+	if err != nil {
+		return err
+	}
+	defer r.Close()
+	dst := os.Stdout
+	if output != "" {
+		if dst, err = os.Create(output); err != nil {
+			return err
+		}
+	}
+	n, err := io.Copy(dst, r)
+	if output != "" {
+		if closeErr := dst.Close(); err == nil {
+			err = closeErr
+		}
+	}
+	if err != nil {
+		return err
+	}
+	if n != entry.Size {
+		return fmt.Errorf("entry %x has %d bytes, while its meta says %d", actionID, n, entry.Size)
+	}
+	if output != "" {
+		fmt.Fprintf(os.Stderr, "Extracted %s (OutputID %x) to %s\n", util.FormatBytes(uint64(n)), entry.OutputID, output)
+	}
+	return nil
+}
+
+func runArchiveInspect(target string, opts archiveInspectOpts) error {
+	if !slices.Contains(archiveSortKeys, opts.sort) {
+		return fmt.Errorf("invalid --sort %q, must be one of %s", opts.sort, strings.Join(archiveSortKeys, ", "))
+	}
+	path, cleanup, err := resolveArchive(target, opts.remote)
+	if err != nil {
+		return err
+	}
+	defer cleanup()
+	ar, err := blob.NewArReader(path)
+	if err != nil {
+		return fmt.Errorf("failed to open archive %s: %w", path, err)
+	}
+	defer ar.Close()
+
+	if opts.extract != "" {
+		return extractArchiveEntry(ar, opts.extract, opts.output)
+	}
+
+	entries := make([]*blob.ArEntry, 0)
+	for _, name := range ar.List() {
+		entries = append(entries, ar.Get(name))
+	}
+	slices.SortFunc(entries, func(a, b *blob.ArEntry) int {
+		switch opts.sort {
+		case "size":
+			if c := cmp.Compare(b.Size, a.Size); c != 0 {
+				return c
+			}
+		case "time":
+			if c := b.Time.Compare(a.Time); c != 0 {
+				return c
+			}
+		case "ratio":
+			if c := cmp.Compare(compressionRatio(a.Size, a.CompressedSize()), compressionRatio(b.Size, b.CompressedSize())); c != 0 {
+				return c
+			}
+		}
+		return strings.Compare(fmt.Sprintf("%x", a.ActionID), fmt.Sprintf("%x", b.ActionID))
+	})
+
+	w := tabwriter.NewWriter(os.Stdout, 0, 0, 2, ' ', 0)
+	fmt.Fprintln(w, "ACTION ID\tOUTPUT ID\tSIZE\tCOMPRESSED\tRATIO\tTIME")
+	var total int64
+	var totalCompressed uint64
+	for _, e := range entries {
+		total += e.Size
+		totalCompressed += e.CompressedSize()
+		fmt.Fprintf(w, "%x\t%x\t%s\t%s\t%.0f%%\t%s\n", e.ActionID, e.OutputID,
+			util.FormatBytes(uint64(e.Size)), util.FormatBytes(e.CompressedSize()),
+			compressionRatio(e.Size, e.CompressedSize())*100, e.Time.Local().Format(time.DateTime))
+	}
+	if err := w.Flush(); err != nil {
+		return err
+	}
+	fmt.Printf("\n%s: %d entries, %s, %s compressed (%.0f%%)\n", target, len(entries),
+		util.FormatBytes(uint64(total)), util.FormatBytes(totalCompressed),
+		compressionRatio(total, totalCompressed)*100)
+	if n := ar.InvalidNames(); n > 0 {
+		fmt.Printf("Warning: %d entries have a name not matching their ActionID, and are ignored by the daemon if blob.validate_archive_entry_names is set\n", n)
+	}
+	return nil
+}
+
+func init() {
+	opts := archiveInspectOpts{}
+
+	inspectCmd := &cobra.Command{
+		Use:   "inspect <keyspace|file.zip>",
+		Short: "List entries of an archive with sizes, timestamps and compression ratios, or extract one entry",
+		Long: "List entries of an archive with sizes, timestamps and compression ratios, or extract one entry.\n" +
+			"A keyspace (0-f) refers to the local copy of its archive in the work dir, or the archive in the\n" +
+			"bucket with --remote. Otherwise the argument is the path of an archive file.",
+		Args: cobra.ExactArgs(1),
+		Run: func(cmd *cobra.Command, args []string) {
+			if err := runArchiveInspect(args[0], opts); err != nil {
+				log.Error("Failed to inspect archive", zap.Error(err))
+				os.Exit(1)
+			}
+		},
+	}
+	inspectCmd.Flags().BoolVar(&opts.remote, "remote", false,
+		"Download the archive of the keyspace from blob.url instead of using the local copy")
+	inspectCmd.Flags().StringVar(&opts.sort, "sort", "name",
+		fmt.Sprintf("Sort entries by one of: %s. Size and time are sorted descending", strings.Join(archiveSortKeys, ", ")))
+	inspectCmd.Flags().StringVar(&opts.extract, "extract", "",
+		"Extract the output of the entry with this ActionID (hex) instead of listing")
+	inspectCmd.Flags().StringVarP(&opts.output, "output", "o", "",
+		"With --extract: Write the output to this file instead of stdout")
+
+	archiveCmd.AddCommand(inspectCmd)
+	rootCmd.AddCommand(archiveCmd)
+}
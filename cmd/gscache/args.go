@@ -15,25 +15,49 @@ func init() {
 	server.AddFlags(rootCmd.PersistentFlags())
 }
 
+// newClientConfig must be called in a command execute. Otherwise flags are not initialized yet.
+func newClientConfig() client.Config {
+	cfg := getServerConfig()
+	config := client.Config{
+		DaemonHost: cfg.Host,
+		DaemonPort: cfg.Port,
+		Token:      cfg.Auth.Token,
+	}
+	// The unix socket is only for the local daemon, not a configured host like a remote proxy
+	if cfg.Host == "" {
+		config.SocketPath = cfg.SocketPath()
+	}
+	tlsConfig, err := cfg.TLS.ClientConfig()
+	if err != nil {
+		log.Error("Failed to load TLS config", zap.Error(err))
+		os.Exit(1)
+	}
+	config.TLS = tlsConfig
+	return config
+}
+
 // newClient must be called in a command execute. Otherwise flags are not initialized yet.
 func newClient() *client.Client {
-	return client.NewClient(client.Config{
-		DaemonPort: getServerConfig().Port,
-	})
+	return client.NewClient(newClientConfig())
 }
 
 var serverConfig *server.Config = nil
 
+// configFilePath returns the config file specified by --config or GSCACHE_CONFIG.
+// Empty means the default config path.
+func configFilePath() string {
+	if path := rootCmd.PersistentFlags().Lookup("config").Value.String(); path != "" {
+		return path
+	}
+	return os.Getenv("GSCACHE_CONFIG")
+}
+
 // getServerConfig must be called in a command execute. Otherwise flags are not initialized yet.
 func getServerConfig() *server.Config {
 	if serverConfig != nil {
 		return serverConfig
 	}
-	configFile := os.Getenv("GSCACHE_CONFIG")
-	if rootCmd.PersistentFlags().Lookup("config").Value.String() != "" {
-		configFile = rootCmd.PersistentFlags().Lookup("config").Value.String()
-	}
-	cfg, err := server.LoadConfig(configFile, rootCmd.PersistentFlags())
+	cfg, err := server.LoadConfig(configFilePath(), rootCmd.PersistentFlags())
 	if err != nil {
 		log.Error("Failed to load server config", zap.Error(err))
 		os.Exit(1)
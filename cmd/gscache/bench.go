@@ -0,0 +1,153 @@
+package main
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"io"
+	"os"
+	"os/signal"
+	"strings"
+	"text/tabwriter"
+	"time"
+
+	"github.com/spf13/cobra"
+	"go.uber.org/zap"
+
+	"github.com/breezewish/gscache/internal/bench"
+	"github.com/breezewish/gscache/internal/client"
+	"github.com/breezewish/gscache/internal/log"
+	"github.com/breezewish/gscache/internal/protocol"
+	"github.com/breezewish/gscache/internal/server"
+	"github.com/breezewish/gscache/internal/util"
+)
+
+// benchTarget runs benchmark operations against the daemon.
+type benchTarget struct {
+	client *client.Client
+}
+
+func (t benchTarget) Put(req protocol.PutRequest, body io.Reader) error {
+	_, err := t.client.PutPlain(req, body)
+	return err
+}
+
+func (t benchTarget) Get(req protocol.GetRequest) (*protocol.GetResponse, error) {
+	return t.client.CallGet(req)
+}
+
+// describeDaemonBackend returns the backend of the running daemon, like "blob (s3://bucket)".
+func describeDaemonBackend(ping *protocol.PingResponse) string {
+	data, err := json.Marshal(ping.Config)
+	if err != nil {
+		return "unknown"
+	}
+	var cfg server.Config
+	if err := json.Unmarshal(data, &cfg); err != nil {
+		return "unknown"
+	}
+	name := server.BackendName(cfg)
+	if name == server.BackendBlob && cfg.Blob.URL != "" {
+		return fmt.Sprintf("%s (%s)", name, cfg.Blob.URL)
+	}
+	return name
+}
+
+func printPhaseResult(w io.Writer, name string, r *bench.PhaseResult) {
+	extra := ""
+	if name == "get" && r.Ops > 0 {
+		extra = fmt.Sprintf(", %.1f%% hit", float64(r.Hits)*100/float64(r.Ops))
+	}
+	if r.Errors > 0 {
+		extra += fmt.Sprintf(", %d errors", r.Errors)
+	}
+	fmt.Fprintf(w, "%s\t%d ops in %s\t%.1f ops/s\t%s/s\tp50 %s\tp90 %s\tp99 %s\tmax %s%s\n",
+		name, r.Ops, r.Duration.Round(time.Millisecond), r.OpsPerSecond(),
+		util.FormatBytes(uint64(r.BytesPerSecond())),
+		r.Percentile(50).Round(time.Microsecond),
+		r.Percentile(90).Round(time.Microsecond),
+		r.Percentile(99).Round(time.Microsecond),
+		r.Percentile(100).Round(time.Microsecond),
+		extra)
+}
+
+func init() {
+	cfg := bench.Config{}
+	var sizes []string
+
+	benchCmd := &cobra.Command{
+		Use:   "bench",
+		Short: "Benchmark put and get of the running daemon with synthetic entries",
+		Long: "Benchmark put and get of the running daemon with synthetic entries, to compare backends.\n" +
+			"Entries are put with random action IDs into a dedicated namespace, so that existing cache\n" +
+			"content is never touched. Note that with a remote backend, entries are uploaded as usual.",
+		Run: func(cmd *cobra.Command, args []string) {
+			cfg.Sizes = make([]int64, 0, len(sizes))
+			for _, s := range sizes {
+				size, err := util.ParseBytes(s)
+				if err != nil {
+					log.Error("Invalid --sizes", zap.Error(err))
+					os.Exit(1)
+				}
+				cfg.Sizes = append(cfg.Sizes, int64(size))
+			}
+			if err := cfg.Validate(); err != nil {
+				log.Error("Invalid benchmark options", zap.Error(err))
+				os.Exit(1)
+			}
+			if err := ensureDaemonRunning( /* isExplicitStart */ false); err != nil {
+				log.Error("Failed to start gscache server daemon", zap.Error(err))
+				os.Exit(1)
+			}
+			c := newClient()
+			ping, err := c.CallPing()
+			if err != nil {
+				log.Error("Failed to ping gscache server daemon", zap.Error(err))
+				os.Exit(1)
+			}
+
+			gets := cfg.Gets
+			if gets == 0 {
+				gets = cfg.Entries
+			}
+			fmt.Printf("Backend: %s\n", describeDaemonBackend(ping))
+			fmt.Printf("Workload: %d puts, %d gets (%.0f%% hit), sizes %s, concurrency %d\n",
+				cfg.Entries, gets, cfg.HitRatio*100,
+				strings.Join(sizes, ","), cfg.Concurrency)
+
+			ctx, cancel := signal.NotifyContext(context.Background(), os.Interrupt)
+			defer cancel()
+			result, err := bench.Run(ctx, benchTarget{client: c}, cfg)
+			if err != nil && result == nil {
+				log.Error("Failed to run benchmark", zap.Error(err))
+				os.Exit(1)
+			}
+			w := tabwriter.NewWriter(os.Stdout, 0, 0, 2, ' ', 0)
+			printPhaseResult(w, "put", &result.Put)
+			if result.Get.Ops > 0 {
+				printPhaseResult(w, "get", &result.Get)
+			}
+			_ = w.Flush()
+			for _, r := range []*bench.PhaseResult{&result.Put, &result.Get} {
+				if r.FirstErr != nil {
+					log.Warn("Some operations failed", zap.Error(r.FirstErr))
+				}
+			}
+			if err != nil {
+				log.Error("Benchmark interrupted", zap.Error(err))
+				os.Exit(1)
+			}
+		},
+	}
+	benchCmd.Flags().IntVar(&cfg.Entries, "entries", 200, "Number of entries to put")
+	benchCmd.Flags().IntVar(&cfg.Gets, "gets", 0, "Number of gets, defaults to the number of entries")
+	benchCmd.Flags().StringSliceVar(&sizes, "sizes", []string{"1KB", "64KB", "1MB"},
+		"Entry sizes, used in round robin")
+	benchCmd.Flags().Float64Var(&cfg.HitRatio, "hit-ratio", 0.8,
+		"Fraction of gets for entries put by this benchmark, others are misses")
+	benchCmd.Flags().IntVar(&cfg.Concurrency, "concurrency", 8, "Number of concurrent operations")
+	benchCmd.Flags().StringVar(&cfg.Namespace, "namespace", bench.DefaultNamespace,
+		"Namespace of benchmark entries")
+
+	rootCmd.AddCommand(benchCmd)
+}
@@ -0,0 +1,108 @@
+package main
+
+import (
+	"context"
+	"fmt"
+	"os"
+	"os/signal"
+	"syscall"
+	"time"
+
+	"github.com/spf13/cobra"
+	"go.uber.org/zap"
+	gocloudblob "gocloud.dev/blob"
+
+	"github.com/breezewish/gscache/internal/cache/backends/blob"
+	"github.com/breezewish/gscache/internal/log"
+	"github.com/breezewish/gscache/internal/progress"
+	"github.com/breezewish/gscache/internal/util"
+)
+
+type cleanOpts struct {
+	olderThan   time.Duration
+	dryRun      bool
+	concurrency int
+	yes         bool
+}
+
+// runCleanRemote prunes the remote bucket directly. Like `verify --remote`, it does not open
+// any backend or lock the work dir, so it can run while daemons are serving.
+func runCleanRemote(opts cleanOpts) (*blob.CleanReport, error) {
+	cfg := getServerConfig()
+	if cfg.Blob.URL == "" {
+		return nil, fmt.Errorf("clean is only available when blob.url is set")
+	}
+	keyLayout, err := blob.ParseKeyLayout(cfg.Blob.KeyLayout)
+	if err != nil {
+		return nil, err
+	}
+	if !opts.dryRun && !opts.yes {
+		if !confirm(fmt.Sprintf("This will delete cache entries older than %s from %s. Continue?", opts.olderThan, cfg.Blob.URL)) {
+			return nil, fmt.Errorf("aborted")
+		}
+	}
+	ctx, stop := signal.NotifyContext(context.Background(), syscall.SIGINT, syscall.SIGTERM)
+	defer stop()
+
+	bucket, err := gocloudblob.OpenBucket(ctx, cfg.Blob.URL)
+	if err != nil {
+		return nil, fmt.Errorf("failed to open blob store: %w", err)
+	}
+	defer bucket.Close()
+
+	tracker := progress.NewTracker()
+	bar := startProgress("Cleaning", tracker)
+	defer bar.Stop()
+	return blob.CleanRemote(blob.CleanRemoteOpts{
+		Ctx:         progress.NewContext(ctx, tracker),
+		Remote:      bucket,
+		OlderThan:   opts.olderThan,
+		DryRun:      opts.dryRun,
+		Concurrency: opts.concurrency,
+		KeyLayout:   keyLayout,
+	})
+}
+
+func init() {
+	opts := cleanOpts{}
+
+	cleanCmd := &cobra.Command{
+		Use:   "clean",
+		Short: "Delete old cache entries from the remote blob store",
+		Long: "Delete cache entries older than --older-than from the remote blob store, and rewrite archives without them.\n" +
+			"Useful when bucket lifecycle rules cannot be set. Local stores are not touched.",
+		Run: func(cmd *cobra.Command, args []string) {
+			if opts.olderThan <= 0 {
+				log.Error("--older-than must be set to a positive duration, e.g. 720h")
+				os.Exit(1)
+			}
+			report, err := runCleanRemote(opts)
+			if report != nil {
+				util.PrettyPrintJSON(report)
+			}
+			if err != nil {
+				log.Error("Failed to clean remote blob store", zap.Error(err))
+				os.Exit(1)
+			}
+			if report.ObjectsFailed > 0 {
+				log.Error("Some objects cannot be deleted", zap.Int("failed", report.ObjectsFailed))
+				os.Exit(1)
+			}
+			if opts.dryRun {
+				log.Info("Dry run finished, nothing is deleted")
+			} else {
+				log.Info("Clean finished")
+			}
+		},
+	}
+	cleanCmd.Flags().DurationVar(&opts.olderThan, "older-than", 0,
+		"Delete entries last modified before this duration ago, e.g. 720h. Required")
+	cleanCmd.Flags().BoolVar(&opts.dryRun, "dry-run", false,
+		"Only report what would be deleted")
+	cleanCmd.Flags().IntVar(&opts.concurrency, "concurrency", blob.DefaultCleanConcurrency,
+		"Number of objects deleted concurrently")
+	cleanCmd.Flags().BoolVarP(&opts.yes, "yes", "y", false,
+		"Skip confirmation")
+
+	rootCmd.AddCommand(cleanCmd)
+}
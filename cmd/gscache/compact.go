@@ -0,0 +1,218 @@
+package main
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"math/rand/v2"
+	"os"
+	"os/signal"
+	"syscall"
+	"text/tabwriter"
+	"time"
+
+	"github.com/spf13/cobra"
+	"go.uber.org/zap"
+
+	"github.com/breezewish/gscache/internal/cache/backends/blob"
+	"github.com/breezewish/gscache/internal/log"
+	"github.com/breezewish/gscache/internal/progress"
+	"github.com/breezewish/gscache/internal/protocol"
+	"github.com/breezewish/gscache/internal/server"
+	"github.com/breezewish/gscache/internal/stats"
+	"github.com/breezewish/gscache/internal/util"
+)
+
+type compactOpts struct {
+	keyspaces []string
+	rebuild   bool
+	dryRun    bool
+	daemon    bool
+	interval  time.Duration
+	jitter    time.Duration
+}
+
+// compactViaDaemon runs compaction once in the running daemon. Returns nil response and
+// nil error if the daemon is not running.
+func compactViaDaemon(opts compactOpts) (*protocol.CompactResponse, error) {
+	bar := startProgress("Compacting", nil)
+	resp, err := newClient().CallCompact(protocol.CompactRequest{
+		Keyspaces: opts.keyspaces,
+		Rebuild:   opts.rebuild,
+		DryRun:    opts.dryRun,
+	}, bar.Func())
+	bar.Stop()
+	if err != nil && errors.Is(err, syscall.ECONNREFUSED) {
+		return nil, nil
+	}
+	return resp, err
+}
+
+func printCompactResponse(resp *protocol.CompactResponse, dryRun bool) {
+	w := tabwriter.NewWriter(os.Stdout, 0, 0, 2, ' ', 0)
+	fmt.Fprintln(w, "KEYSPACE\tBLOBS\tADDED\tREMOVED\tRESULT")
+	for _, k := range resp.Keyspaces {
+		result := "compacted"
+		if dryRun {
+			result = "would compact"
+		}
+		switch {
+		case k.Error != "":
+			result = "failed: " + k.Error
+		case k.Skipped && dryRun:
+			result = "would skip: " + k.SkipReason
+		case k.Skipped:
+			result = "skipped: " + k.SkipReason
+		}
+		fmt.Fprintf(w, "%s\t%d\t%d (%s)\t%d\t%s\n", k.Keyspace, k.Blobs,
+			k.AddedBlobs, util.FormatBytes(uint64(k.AddedBytes)), k.RemovedBlobs, result)
+	}
+	_ = w.Flush()
+}
+
+// runCompact opens the blob backend in the current process and runs compaction once,
+// or periodically until SIGINT / SIGTERM in daemon mode.
+// The work dir must not be used by a running daemon.
+func runCompact(opts compactOpts) error {
+	if opts.daemon && opts.interval <= 0 {
+		return fmt.Errorf("--interval must be positive")
+	}
+	if opts.jitter < 0 {
+		return fmt.Errorf("--jitter must not be negative")
+	}
+
+	cfg := getServerConfig()
+	if cfg.Blob.URL == "" {
+		return fmt.Errorf("compaction is only available when blob.url is set")
+	}
+	if err := os.MkdirAll(cfg.Dir, 0755); err != nil {
+		return fmt.Errorf("failed to create work dir: %w", err)
+	}
+	dirLock, err := server.LockWorkDir(cfg.Dir)
+	if err != nil {
+		return fmt.Errorf("%w (stop the daemon or use a different --dir)", err)
+	}
+	defer dirLock.Unlock()
+	if err := server.MigrateWorkDir(*cfg); err != nil {
+		return err
+	}
+
+	stats.Default.LoadFromFileAndAttach(cfg.StatsFilePath())
+	defer stats.Default.ForcePersist()
+
+	blobCfg := cfg.Blob
+	blobCfg.WorkDir = cfg.Dir
+	blobCfg.SlowThreshold = cfg.SlowThreshold
+	blobCfg.SkipCompactionOnOpen = true
+	// Each compaction job syncs its archive before compacting
+	blobCfg.SkipInitialArchiveSync = true
+	backend, err := blob.NewBlobBackend(blobCfg)
+	if err != nil {
+		return fmt.Errorf("failed to create blob backend: %w", err)
+	}
+	if err := backend.Open(context.Background()); err != nil {
+		return fmt.Errorf("failed to open blob backend: %w", err)
+	}
+	defer backend.Close()
+
+	compactOpts := blob.CompactOpts{
+		Keyspaces: opts.keyspaces,
+		Rebuild:   opts.rebuild,
+		DryRun:    opts.dryRun,
+	}
+	if !opts.daemon {
+		compactOpts.Progress = progress.NewTracker()
+		bar := startProgress("Compacting", compactOpts.Progress)
+		resp, err := backend.CompactWithReport(compactOpts)
+		bar.Stop()
+		if resp != nil {
+			printCompactResponse(resp, opts.dryRun)
+		}
+		return err
+	}
+
+	ctx, stop := signal.NotifyContext(context.Background(), syscall.SIGINT, syscall.SIGTERM)
+	defer stop()
+	log.Info("Running compaction periodically", zap.String("interval", opts.interval.String()))
+	if opts.jitter > 0 {
+		// Spread compaction load of a fleet started at the same time (e.g. by a rollout)
+		delay := rand.N(opts.jitter)
+		log.Info("Delaying first compaction", zap.String("delay", delay.Round(time.Second).String()))
+		select {
+		case <-ctx.Done():
+			log.Info("Received shutdown signal, stopping")
+			return nil
+		case <-time.After(delay):
+		}
+	}
+	for {
+		if err := backend.CompactWithOpts(compactOpts); err != nil {
+			// Keep running, next round may succeed
+			log.Error("Compaction failed", zap.Error(err))
+		}
+		stats.Default.ForcePersist()
+		select {
+		case <-ctx.Done():
+			log.Info("Received shutdown signal, stopping")
+			return nil
+		case <-time.After(opts.interval):
+		}
+	}
+}
+
+func init() {
+	opts := compactOpts{}
+
+	compactCmd := &cobra.Command{
+		Use:   "compact",
+		Short: "Compact small blobs into archives, via the running daemon or in the current process",
+		Long: "Compact small blobs into archives. If the daemon is running, compaction runs in the daemon,\n" +
+			"otherwise in the current process. With --daemon, compaction always runs in the current process,\n" +
+			"so the daemon using the same work dir must be stopped.",
+		Run: func(cmd *cobra.Command, args []string) {
+			if !cmd.Flags().Changed("jitter") {
+				opts.jitter = opts.interval
+			}
+			if opts.daemon && opts.dryRun {
+				log.Error("--dry-run cannot be used with --daemon")
+				os.Exit(1)
+			}
+			if !opts.daemon {
+				resp, err := compactViaDaemon(opts)
+				if err != nil {
+					log.Error("Compaction failed", zap.Error(err))
+					os.Exit(1)
+				}
+				if resp != nil {
+					printCompactResponse(resp, opts.dryRun)
+					for _, k := range resp.Keyspaces {
+						if k.Error != "" {
+							os.Exit(1)
+						}
+					}
+					return
+				}
+				log.Info("Server daemon is not running, compact in the current process")
+			}
+			if err := runCompact(opts); err != nil {
+				log.Error("Compaction failed", zap.Error(err))
+				os.Exit(1)
+			}
+			log.Info("Compaction finished")
+		},
+	}
+	compactCmd.Flags().StringSliceVar(&opts.keyspaces, "keyspace", nil,
+		"Only compact these keyspaces (0-f), can be specified multiple times. Default: all keyspaces")
+	compactCmd.Flags().BoolVar(&opts.rebuild, "rebuild", false,
+		"Ignore existing archives and rebuild them from all current small blobs")
+	compactCmd.Flags().BoolVar(&opts.dryRun, "dry-run", false,
+		"Only report how many small blobs would be compacted for each keyspace, without uploading archives")
+	compactCmd.Flags().BoolVar(&opts.daemon, "daemon", false,
+		"Keep running and compact periodically, e.g. as a dedicated compaction sidecar. Stops on SIGINT / SIGTERM")
+	compactCmd.Flags().DurationVar(&opts.interval, "interval", 30*time.Minute,
+		"Daemon mode only: Interval between compactions")
+	compactCmd.Flags().DurationVar(&opts.jitter, "jitter", 0,
+		"Daemon mode only: Delay the first compaction by a random duration up to this bound, 0 to disable. Default: same as --interval")
+
+	rootCmd.AddCommand(compactCmd)
+}
@@ -0,0 +1,66 @@
+package main
+
+import (
+	"fmt"
+	"os"
+	"sort"
+	"text/tabwriter"
+
+	"github.com/knadh/koanf/providers/structs"
+	"github.com/knadh/koanf/v2"
+	"github.com/spf13/cobra"
+	"go.uber.org/zap"
+
+	"github.com/breezewish/gscache/internal/log"
+	"github.com/breezewish/gscache/internal/server"
+	"github.com/breezewish/gscache/internal/util"
+)
+
+var configCmd = &cobra.Command{
+	Use:   "config",
+	Short: "Inspect or create gscache config",
+}
+
+func runConfigShow() error {
+	cfg, sources, err := server.LoadConfigWithSources(configFilePath(), rootCmd.PersistentFlags())
+	if err != nil {
+		return err
+	}
+	// Flatten the merged config, so that keys match the ones in the config file.
+	k := koanf.New(".")
+	if err := k.Load(structs.Provider(util.Redact(cfg), "json"), nil); err != nil {
+		return err
+	}
+	keys := k.Keys()
+	sort.Strings(keys)
+
+	w := tabwriter.NewWriter(os.Stdout, 0, 0, 2, ' ', 0)
+	for _, key := range keys {
+		value := k.Get(key)
+		if s, ok := value.(string); ok {
+			value = fmt.Sprintf("%q", s)
+		}
+		source, ok := sources[key]
+		if !ok {
+			source = server.ConfigSource{Kind: server.ConfigSourceDefault}
+		}
+		fmt.Fprintf(w, "%s = %v\t# %s\n", key, value, source)
+	}
+	return w.Flush()
+}
+
+func init() {
+	configShowCmd := &cobra.Command{
+		Use:   "show",
+		Short: "Print the merged config and where each value comes from (default, file, env or flag)",
+		Run: func(cmd *cobra.Command, args []string) {
+			if err := runConfigShow(); err != nil {
+				log.Error("Failed to load config", zap.Error(err))
+				os.Exit(1)
+			}
+		},
+	}
+
+	configCmd.AddCommand(configShowCmd)
+	rootCmd.AddCommand(configCmd)
+}
@@ -0,0 +1,303 @@
+package main
+
+import (
+	"bufio"
+	"context"
+	"fmt"
+	"io"
+	"os"
+	"path/filepath"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/spf13/cobra"
+	"go.uber.org/zap"
+	gocloudblob "gocloud.dev/blob"
+
+	"github.com/breezewish/gscache/internal/cache/backends/blob"
+	"github.com/breezewish/gscache/internal/log"
+	"github.com/breezewish/gscache/internal/server"
+	"github.com/breezewish/gscache/internal/util"
+)
+
+type configInitOpts struct {
+	force bool
+}
+
+// prompter reads answers from stdin. A single reader is shared by all questions, so that
+// buffered input is not lost when answers are piped in.
+type prompter struct {
+	r *bufio.Reader
+}
+
+// ask prints the question and returns the trimmed answer, or def if the answer is empty.
+func (p *prompter) ask(question, def string) (string, error) {
+	if def != "" {
+		fmt.Fprintf(os.Stderr, "%s [%s]: ", question, def)
+	} else {
+		fmt.Fprintf(os.Stderr, "%s: ", question)
+	}
+	answer, err := p.r.ReadString('\n')
+	if err != nil && (err != io.EOF || answer == "") {
+		return "", fmt.Errorf("no answer: %w", err)
+	}
+	if answer = strings.TrimSpace(answer); answer == "" {
+		return def, nil
+	}
+	return answer, nil
+}
+
+func (p *prompter) askYesNo(question string, def bool) (bool, error) {
+	defStr := "y/N"
+	if def {
+		defStr = "Y/n"
+	}
+	answer, err := p.ask(question, defStr)
+	if err != nil {
+		return false, err
+	}
+	switch strings.ToLower(answer) {
+	case "y", "yes":
+		return true, nil
+	case "n", "no":
+		return false, nil
+	}
+	return def, nil
+}
+
+// askChoice asks until the answer is one of choices.
+func (p *prompter) askChoice(question string, choices []string, def string) (string, error) {
+	for {
+		answer, err := p.ask(fmt.Sprintf("%s (%s)", question, strings.Join(choices, "/")), def)
+		if err != nil {
+			return "", err
+		}
+		for _, c := range choices {
+			if strings.EqualFold(answer, c) {
+				return c, nil
+			}
+		}
+		fmt.Fprintf(os.Stderr, "Please choose one of %s\n", strings.Join(choices, ", "))
+	}
+}
+
+// askRemoteURL asks for the bucket of the chosen backend and returns the blob URL.
+func (p *prompter) askRemoteURL(backend string) (string, error) {
+	what := "Bucket name"
+	if backend == "azure" {
+		what = "Container name"
+		fmt.Fprintln(os.Stderr, "The storage account is read from AZURE_STORAGE_ACCOUNT, with credentials from AZURE_STORAGE_KEY or the default Azure credential chain.")
+	}
+	var name string
+	for name == "" {
+		var err error
+		if name, err = p.ask(what+" (or a full blob URL)", ""); err != nil {
+			return "", err
+		}
+	}
+	if strings.Contains(name, "://") {
+		return name, nil
+	}
+	prefix, err := p.ask("Key prefix in the bucket", "gscache/")
+	if err != nil {
+		return "", err
+	}
+	var params []string
+	if prefix != "" {
+		params = append(params, "prefix="+prefix)
+	}
+	scheme := map[string]string{"s3": "s3", "gcs": "gs", "azure": "azblob"}[backend]
+	if backend == "s3" {
+		region, err := p.ask("Region (empty to use AWS_REGION or ~/.aws/config)", "")
+		if err != nil {
+			return "", err
+		}
+		if region != "" {
+			params = append(params, "region="+region)
+		}
+		endpoint, err := p.ask("Custom endpoint, e.g. http://localhost:9000 for MinIO (empty for AWS)", "")
+		if err != nil {
+			return "", err
+		}
+		if endpoint != "" {
+			params = append(params, "endpoint="+endpoint, "use_path_style=true")
+			if strings.HasPrefix(endpoint, "http://") {
+				params = append(params, "disable_https=true")
+			}
+		}
+	}
+	url := scheme + "://" + name
+	if len(params) > 0 {
+		url += "?" + strings.Join(params, "&")
+	}
+	return url, nil
+}
+
+// probeURL validates the blob URL against the real service. Returns a non-nil error describing
+// the failure and how to fix it.
+func probeURL(url string) error {
+	ctx, cancel := context.WithTimeout(context.Background(), 4*blob.ProbeTimeout)
+	defer cancel()
+	bucket, err := gocloudblob.OpenBucket(ctx, url)
+	if err != nil {
+		return fmt.Errorf("cannot open %s: %w\n  fix: check the URL format, e.g. s3://bucket?region=us-east-1 or gs://bucket", url, err)
+	}
+	defer bucket.Close()
+	report := blob.ProbeRemote(ctx, bucket)
+	if failed := report.Failed(); failed != nil {
+		return fmt.Errorf("%s of a probe object failed: %w\n  fix: %s", failed.Op, failed.Err, probeErrorFix(failed.Err))
+	}
+	return nil
+}
+
+// askWorkDir asks until the work dir is writable, or can be created.
+func (p *prompter) askWorkDir() (string, error) {
+	for {
+		dir, err := p.ask("Work dir for the local cache", server.DefaultWorkDir)
+		if err != nil {
+			return "", err
+		}
+		if rest, ok := strings.CutPrefix(dir, "~/"); ok {
+			if home, err := os.UserHomeDir(); err == nil {
+				dir = filepath.Join(home, rest)
+			}
+		}
+		if dir, err = filepath.Abs(dir); err != nil {
+			return "", err
+		}
+		r := checkWorkDir(&server.Config{Dir: dir})
+		if r.status == doctorOK {
+			return dir, nil
+		}
+		fmt.Fprintf(os.Stderr, "%s\n  fix: %s\n", r.detail, r.fix)
+	}
+}
+
+// configInitValues are the answers written to the config file. Other keys keep their defaults.
+type configInitValues struct {
+	dir      string
+	blobURL  string
+	maxBytes uint64
+	maxAge   time.Duration
+}
+
+func (v configInitValues) toml() string {
+	var b strings.Builder
+	b.WriteString("# Generated by `gscache config init`. See README for all options.\n")
+	fmt.Fprintf(&b, "dir = %s\n\n", strconv.Quote(v.dir))
+	b.WriteString("[blob]\n")
+	fmt.Fprintf(&b, "url = %s  # If not set, a local-only cache will be used.\n\n", strconv.Quote(v.blobURL))
+	b.WriteString("[gc]\n")
+	fmt.Fprintf(&b, "max_age = %s  # `gscache gc` removes local entries not used within this duration. 0 to disable.\n", strconv.Quote(v.maxAge.String()))
+	fmt.Fprintf(&b, "max_bytes = %d  # If > 0, `gscache gc` also removes least recently used local entries until outputs take at most N bytes.\n", v.maxBytes)
+	return b.String()
+}
+
+func runConfigInit(opts configInitOpts) error {
+	path := configFilePath()
+	if path == "" {
+		path = server.DefaultConfigPath
+	}
+	p := &prompter{r: bufio.NewReader(os.Stdin)}
+	if _, err := os.Stat(path); err == nil && !opts.force {
+		overwrite, err := p.askYesNo(fmt.Sprintf("%s already exists. Overwrite?", path), false)
+		if err != nil {
+			return err
+		}
+		if !overwrite {
+			return fmt.Errorf("aborted")
+		}
+	}
+
+	v := configInitValues{}
+	backend, err := p.askChoice("Backend", []string{"local", "s3", "gcs", "azure"}, "local")
+	if err != nil {
+		return err
+	}
+	for backend != "local" {
+		if v.blobURL, err = p.askRemoteURL(backend); err != nil {
+			return err
+		}
+		fmt.Fprintf(os.Stderr, "Checking %s ...\n", v.blobURL)
+		probeErr := probeURL(v.blobURL)
+		if probeErr == nil {
+			fmt.Fprintln(os.Stderr, "The bucket is readable and writable")
+			break
+		}
+		fmt.Fprintln(os.Stderr, probeErr)
+		// Credentials may only be available where the daemon runs, e.g. in CI
+		keep, err := p.askYesNo("Keep this URL anyway?", false)
+		if err != nil {
+			return err
+		}
+		if keep {
+			break
+		}
+	}
+
+	if v.dir, err = p.askWorkDir(); err != nil {
+		return err
+	}
+	for {
+		answer, err := p.ask("Max size of the local cache, e.g. 10GB (0 for no limit)", "0")
+		if err != nil {
+			return err
+		}
+		if v.maxBytes, err = util.ParseBytes(answer); err == nil {
+			break
+		}
+		fmt.Fprintln(os.Stderr, err)
+	}
+	for {
+		answer, err := p.ask("Remove local entries not used within", server.DefaultConfig().GC.MaxAge.String())
+		if err != nil {
+			return err
+		}
+		if v.maxAge, err = time.ParseDuration(answer); err == nil && v.maxAge >= 0 {
+			break
+		}
+		fmt.Fprintf(os.Stderr, "invalid duration %q, e.g. 168h\n", answer)
+	}
+
+	if err := os.MkdirAll(filepath.Dir(path), 0755); err != nil {
+		return err
+	}
+	tmp := path + ".tmp"
+	if err := os.WriteFile(tmp, []byte(v.toml()), 0644); err != nil {
+		return err
+	}
+	// The written file must be loadable, otherwise every command fails afterwards
+	if _, err := server.LoadConfig(tmp, nil); err != nil {
+		_ = os.Remove(tmp)
+		return err
+	}
+	if err := os.Rename(tmp, path); err != nil {
+		_ = os.Remove(tmp)
+		return err
+	}
+	fmt.Fprintf(os.Stderr, "\nConfig written to %s\n", path)
+	fmt.Fprintln(os.Stderr, "Next, set GOCACHEPROG and check the setup:")
+	fmt.Fprintln(os.Stderr, `  export GOCACHEPROG="gscache prog"`)
+	fmt.Fprintln(os.Stderr, "  gscache doctor")
+	return nil
+}
+
+func init() {
+	opts := configInitOpts{}
+
+	configInitCmd := &cobra.Command{
+		Use:   "init",
+		Short: "Interactively choose a backend, bucket, work dir and limits, validate them and write the config file",
+		Run: func(cmd *cobra.Command, args []string) {
+			if err := runConfigInit(opts); err != nil {
+				log.Error("Failed to init config", zap.Error(err))
+				os.Exit(1)
+			}
+		},
+	}
+	configInitCmd.Flags().BoolVar(&opts.force, "force", false,
+		"Overwrite an existing config file without asking")
+
+	configCmd.AddCommand(configInitCmd)
+}
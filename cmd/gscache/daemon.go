@@ -2,15 +2,14 @@ package main
 
 import (
 	"bufio"
-	"bytes"
 	"context"
-	"encoding/json"
 	"errors"
 	"fmt"
 	"io"
 	"os"
 	"path/filepath"
 	"syscall"
+	"text/tabwriter"
 	"time"
 
 	zappretty "github.com/maoueh/zap-pretty"
@@ -20,9 +19,25 @@ import (
 	"go.uber.org/zap"
 
 	"github.com/breezewish/gscache/internal/log"
+	"github.com/breezewish/gscache/internal/protocol"
 	"github.com/breezewish/gscache/internal/util"
 )
 
+// printConfigChanges prints changes of a config reload, one key per line.
+func printConfigChanges(w io.Writer, changes []protocol.ConfigChange) {
+	if len(changes) == 0 {
+		fmt.Fprintln(w, "Config is not changed")
+		return
+	}
+	for _, change := range changes {
+		note := ""
+		if change.RequiresRestart {
+			note = "  (requires restart)"
+		}
+		fmt.Fprintf(w, "%s: %s -> %s%s\n", change.Key, change.Old, change.New, note)
+	}
+}
+
 func rebuildCliArgs() []string {
 	flags := rootCmd.PersistentFlags()
 	args := []string{}
@@ -36,41 +51,11 @@ func rebuildCliArgs() []string {
 }
 
 func tailPrintLogFile(logFile string, n int) error {
-	f, err := os.Open(logFile)
+	// Lines older than 1 minute are from previous runs
+	lines, _, err := log.TailLines(logFile, n, 1*time.Minute)
 	if err != nil {
 		return err
 	}
-	defer f.Close()
-
-	// Seek last 1MB of the file
-	_, _ = f.Seek(-1024*1024, io.SeekEnd)
-	lastBuf, err := io.ReadAll(f)
-	if err != nil {
-		return err
-	}
-
-	// Tail last N lines
-	outputBuf := []string{}
-	lines := bytes.Split(lastBuf, []byte{'\n'})
-	for i := len(lines) - 1; i >= 0; i-- {
-		line := lines[i]
-		if len(line) == 0 {
-			continue
-		}
-		// Try to parse the line as JSON, and stop if it is a valid log line with too old timestamp.
-		var logEntry map[string]interface{}
-		if err := json.Unmarshal([]byte(line), &logEntry); err == nil {
-			if ts, ok := logEntry["ts"].(float64); ok {
-				if time.Since(time.Unix(int64(ts), 0)) > 1*time.Minute {
-					break
-				}
-			}
-		}
-		outputBuf = append(outputBuf, string(line))
-		if len(outputBuf) >= n {
-			break // Stop if we have enough lines
-		}
-	}
 
 	// Pretty print
 	printDone := make(chan struct{})
@@ -81,8 +66,8 @@ func tailPrintLogFile(logFile string, n int) error {
 		processor.Process()
 		close(printDone)
 	}()
-	for i := len(outputBuf) - 1; i >= 0; i-- {
-		pipeW.Write([]byte(outputBuf[i] + "\n"))
+	for _, line := range lines {
+		pipeW.Write([]byte(line + "\n"))
 	}
 	pipeW.Close()
 	<-printDone
@@ -147,16 +132,88 @@ func ensureDaemonRunning(isExplicitStart bool) error {
 	return nil
 }
 
-func init() {
-	daemonCmd := &cobra.Command{
-		Use:   "daemon",
-		Short: "Manage the gscache daemon",
+// runDaemonForeground runs the server in the current process with logs to stdout, for
+// containers where the daemon is PID 1 and the runtime collects stdout. A forked daemon
+// would leave PID 1 exiting right away, which stops the container.
+func runDaemonForeground() {
+	if ping, _ := newClient().CallPing(); ping != nil {
+		log.Error("Server daemon is already running", zap.Int("pid", ping.Pid))
+		os.Exit(1)
+	}
+	cfg := getServerConfig()
+	if !rootCmd.PersistentFlags().Changed("shutdown_after_inactivity") {
+		// The container runtime owns the lifecycle, so the daemon keeps running when idle
+		cfg.ShutdownAfterInactivity = 0
+	}
+	if err := runAsServer("stdout"); err != nil {
+		log.Error("Failed to run as server", zap.Error(err))
+		os.Exit(1)
+	}
+}
+
+// sinceText formats how long ago t was, e.g. "2024-01-02 15:04:05 (3m ago)".
+func sinceText(t time.Time) string {
+	return fmt.Sprintf("%s (%s ago)", t.Local().Format(time.DateTime), time.Since(t).Round(time.Second))
+}
+
+func printDaemonStatus(w io.Writer, st *protocol.StatusResponse) error {
+	health := "healthy"
+	if !st.Healthy {
+		health = "unhealthy"
+	}
+	fmt.Fprintf(w, "Status:           running (pid %d), %s\n", st.Pid, health)
+	for _, problem := range st.Problems {
+		fmt.Fprintf(w, "Problem:          %s\n", problem)
+	}
+	fmt.Fprintf(w, "Uptime:           %s (since %s)\n", st.Uptime, st.StartedAt.Local().Format(time.DateTime))
+	fmt.Fprintf(w, "Backend:          %s\n", st.Backend)
+	fmt.Fprintf(w, "Local disk:       %s in %s\n", util.FormatBytes(uint64(st.LocalBytes)), st.Dir)
+	if st.Status == nil {
+		return nil
+	}
+	fmt.Fprintf(w, "Upload queue:     %d running, %d waiting\n", st.Status.UploadQueueRunning, st.Status.UploadQueueWaiting)
+	if c := st.Status.LastCompaction; c != nil {
+		fmt.Fprintf(w, "Last compaction:  %s, took %s\n", sinceText(c.StartedAt), c.Duration)
+		fmt.Fprintf(w, "                  %d keyspaces compacted, %d skipped, %d failed; %d blobs (%s) added, %d removed\n",
+			c.Compacted, c.Skipped, c.Failed, c.AddedBlobs, util.FormatBytes(uint64(c.AddedBytes)), c.RemovedBlobs)
+		if c.Error != "" {
+			fmt.Fprintf(w, "                  %s\n", c.Error)
+		}
+	} else {
+		fmt.Fprintf(w, "Last compaction:  never\n")
 	}
+	if len(st.Status.Archives) == 0 {
+		return nil
+	}
+	fmt.Fprintf(w, "\n")
+	tw := tabwriter.NewWriter(w, 0, 0, 2, ' ', 0)
+	fmt.Fprintln(tw, "KEYSPACE\tENTRIES\tSIZE\tLAST SYNC")
+	for _, ar := range st.Status.Archives {
+		lastSync := "never"
+		if ar.LastSyncAt != nil {
+			lastSync = sinceText(*ar.LastSyncAt)
+		}
+		fmt.Fprintf(tw, "%s\t%d\t%s\t%s\n", ar.Keyspace, ar.Entries, util.FormatBytes(uint64(ar.SizeBytes)), lastSync)
+	}
+	return tw.Flush()
+}
+
+var daemonCmd = &cobra.Command{
+	Use:   "daemon",
+	Short: "Manage the gscache daemon",
+}
+
+func init() {
+	foreground := false
 
 	startCmd := &cobra.Command{
 		Use:   "start",
 		Short: "Start the gscache server daemon in the background using current environment variables, flags and configs",
 		Run: func(cmd *cobra.Command, args []string) {
+			if foreground {
+				runDaemonForeground()
+				return
+			}
 			if err := ensureDaemonRunning( /* isExplicitStart */ true); err != nil {
 				log.Error("Failed to start gscache server daemon", zap.Error(err))
 				os.Exit(1)
@@ -164,6 +221,20 @@ func init() {
 		},
 	}
 
+	startCmd.Flags().BoolVar(&foreground, "foreground", false,
+		"Same as `gscache daemon run`")
+
+	runCmd := &cobra.Command{
+		Use:   "run",
+		Short: "Run the gscache server in the foreground with logs to stdout, e.g. as PID 1 of a container",
+		Long: "Run the gscache server in the current process with JSON logs to stdout instead of the log file,\n" +
+			"until SIGINT or SIGTERM is received. The server does not shut down for inactivity unless\n" +
+			"--shutdown_after_inactivity is set.",
+		Run: func(cmd *cobra.Command, args []string) {
+			runDaemonForeground()
+		},
+	}
+
 	stopCmd := &cobra.Command{
 		Use:   "stop",
 		Short: "Stop the gscache server daemon if it is running",
@@ -201,26 +272,60 @@ func init() {
 		},
 	}
 
+	statusJSON := false
 	statusCmd := &cobra.Command{
 		Use:   "status",
 		Short: "Check the status of the gscache server daemon",
+		Long: "Show uptime, backend health, upload queue backlog, local disk usage, archive freshness\n" +
+			"per keyspace and the last compaction result of the running daemon. Exits with 1 if the\n" +
+			"daemon is not running.",
+		Run: func(cmd *cobra.Command, args []string) {
+			st, err := newClient().CallStatus()
+			if err != nil {
+				if errors.Is(err, syscall.ECONNREFUSED) {
+					log.Error("Server daemon is not running")
+				} else {
+					log.Error("Failed to get server status", zap.Error(err))
+				}
+				os.Exit(1)
+			}
+			if statusJSON {
+				util.PrettyPrintJSON(st)
+				return
+			}
+			if err := printDaemonStatus(os.Stdout, st); err != nil {
+				log.Error("Failed to print server status", zap.Error(err))
+				os.Exit(1)
+			}
+		},
+	}
+	statusCmd.Flags().BoolVar(&statusJSON, "json", false, "Print the status as JSON, for scripting")
+
+	reloadCmd := &cobra.Command{
+		Use:   "reload",
+		Short: "Make the running daemon re-read its config file",
+		Long: "Apply changes of log.level, blob.upload_concurrency and gc.* without restarting the\n" +
+			"daemon, and print all changed keys. Changes of other keys are applied on the next\n" +
+			"restart. Sending SIGHUP to the daemon does the same.",
 		Run: func(cmd *cobra.Command, args []string) {
-			ping, err := newClient().CallPing()
+			resp, err := newClient().CallConfigReload()
 			if err != nil {
 				if errors.Is(err, syscall.ECONNREFUSED) {
 					log.Error("Server daemon is not running")
 				} else {
-					log.Error("Failed to ping server", zap.Error(err))
+					log.Error("Failed to reload config", zap.Error(err))
 				}
 				os.Exit(1)
 			}
-			util.PrettyPrintJSON(ping)
+			printConfigChanges(os.Stdout, resp.Changes)
 		},
 	}
 
 	rootCmd.AddCommand(daemonCmd)
 	daemonCmd.AddCommand(startCmd)
+	daemonCmd.AddCommand(runCmd)
 	daemonCmd.AddCommand(stopCmd)
 	daemonCmd.AddCommand(restartCmd)
 	daemonCmd.AddCommand(statusCmd)
+	daemonCmd.AddCommand(reloadCmd)
 }
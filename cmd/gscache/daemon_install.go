@@ -0,0 +1,195 @@
+package main
+
+import (
+	"fmt"
+	"os"
+	"os/exec"
+	"path/filepath"
+	"runtime"
+	"strings"
+	"time"
+
+	"github.com/spf13/cobra"
+	"go.uber.org/zap"
+
+	"github.com/breezewish/gscache/internal/log"
+	"github.com/breezewish/gscache/internal/service"
+)
+
+type serviceOpts struct {
+	print   bool
+	noStart bool
+}
+
+// serviceFilePath returns where the service definition is installed for the current OS.
+func serviceFilePath() (string, error) {
+	home, err := os.UserHomeDir()
+	if err != nil {
+		return "", err
+	}
+	switch runtime.GOOS {
+	case "linux":
+		configDir := os.Getenv("XDG_CONFIG_HOME")
+		if configDir == "" {
+			configDir = filepath.Join(home, ".config")
+		}
+		return filepath.Join(configDir, "systemd", "user", service.SystemdUnitName), nil
+	case "darwin":
+		return filepath.Join(home, "Library", "LaunchAgents", service.LaunchdLabel+".plist"), nil
+	}
+	return "", fmt.Errorf("daemon install is not supported on %s, only linux (systemd) and darwin (launchd)", runtime.GOOS)
+}
+
+// buildServiceSpec returns the service running `gscache server` with current flags and env.
+func buildServiceSpec() (service.Spec, error) {
+	executable, err := os.Executable()
+	if err != nil {
+		return service.Spec{}, fmt.Errorf("failed to locate gscache binary: %w", err)
+	}
+	if executable, err = filepath.EvalSymlinks(executable); err != nil {
+		return service.Spec{}, fmt.Errorf("failed to locate gscache binary: %w", err)
+	}
+	wd, err := os.Getwd()
+	if err != nil {
+		return service.Spec{}, err
+	}
+	logFile, err := filepath.Abs(getServerConfig().Log.File)
+	if err != nil {
+		return service.Spec{}, err
+	}
+	args := append([]string{"server"}, rebuildCliArgs()...)
+	if !rootCmd.PersistentFlags().Changed("shutdown_after_inactivity") {
+		// The service manager owns the lifecycle, so the daemon keeps running when idle
+		args = append(args, "--shutdown_after_inactivity", "0")
+	}
+	return service.Spec{
+		Executable: executable,
+		Args:       args,
+		Env:        service.CaptureEnv(os.Environ()),
+		WorkingDir: wd,
+		LogFile:    logFile,
+	}, nil
+}
+
+func runCommands(commands ...[]string) error {
+	for _, args := range commands {
+		log.Info("Running", zap.String("command", strings.Join(args, " ")))
+		c := exec.Command(args[0], args[1:]...)
+		c.Stdout = os.Stdout
+		c.Stderr = os.Stderr
+		if err := c.Run(); err != nil {
+			return fmt.Errorf("failed to run %s: %w", strings.Join(args, " "), err)
+		}
+	}
+	return nil
+}
+
+func installService(opts serviceOpts) error {
+	spec, err := buildServiceSpec()
+	if err != nil {
+		return err
+	}
+	content := service.SystemdUnit(spec)
+	if runtime.GOOS == "darwin" {
+		content = service.LaunchdPlist(spec)
+	}
+	if opts.print {
+		fmt.Print(content)
+		return nil
+	}
+	path, err := serviceFilePath()
+	if err != nil {
+		return err
+	}
+	if err := os.MkdirAll(filepath.Dir(path), 0755); err != nil {
+		return err
+	}
+	if err := os.MkdirAll(filepath.Dir(spec.LogFile), 0755); err != nil {
+		return err
+	}
+	// Captured env may contain credentials
+	if err := os.WriteFile(path, []byte(content), 0600); err != nil {
+		return fmt.Errorf("failed to write %s: %w", path, err)
+	}
+	log.Info("Service installed", zap.String("path", path), zap.Int("capturedEnvVars", len(spec.Env)))
+	if opts.noStart {
+		return nil
+	}
+
+	// A daemon started on demand holds the port and the work dir
+	if _, err := newClient().ShutdownAndWait(30 * time.Second); err != nil {
+		return fmt.Errorf("failed to stop the running daemon: %w", err)
+	}
+	if runtime.GOOS == "darwin" {
+		_ = exec.Command("launchctl", "unload", path).Run()
+		return runCommands([]string{"launchctl", "load", "-w", path})
+	}
+	if err := runCommands(
+		[]string{"systemctl", "--user", "daemon-reload"},
+		[]string{"systemctl", "--user", "enable", "--now", service.SystemdUnitName},
+	); err != nil {
+		return err
+	}
+	log.Info("To keep the daemon running after logout and start it at boot, run `loginctl enable-linger`")
+	return nil
+}
+
+func uninstallService() error {
+	path, err := serviceFilePath()
+	if err != nil {
+		return err
+	}
+	if _, err := os.Stat(path); os.IsNotExist(err) {
+		log.Info("Service is not installed", zap.String("path", path))
+		return nil
+	}
+	if runtime.GOOS == "darwin" {
+		_ = runCommands([]string{"launchctl", "unload", "-w", path})
+	} else {
+		_ = runCommands([]string{"systemctl", "--user", "disable", "--now", service.SystemdUnitName})
+	}
+	if err := os.Remove(path); err != nil {
+		return err
+	}
+	if runtime.GOOS == "linux" {
+		_ = runCommands([]string{"systemctl", "--user", "daemon-reload"})
+	}
+	log.Info("Service uninstalled", zap.String("path", path))
+	return nil
+}
+
+func init() {
+	opts := serviceOpts{}
+
+	installCmd := &cobra.Command{
+		Use:   "install",
+		Short: "Install the daemon as a systemd user service (Linux) or a launchd agent (macOS), so that it survives reboots",
+		Long: "Install the daemon as a systemd user service (Linux) or a launchd agent (macOS), so that it survives reboots.\n" +
+			"Current flags and GSCACHE_*, AWS_*, GOOGLE_*, AZURE_* and proxy env variables are captured into the\n" +
+			"service definition, which is only readable by the current user. Run install again after changing them.",
+		Run: func(cmd *cobra.Command, args []string) {
+			if err := installService(opts); err != nil {
+				log.Error("Failed to install service", zap.Error(err))
+				os.Exit(1)
+			}
+		},
+	}
+	installCmd.Flags().BoolVar(&opts.print, "print", false,
+		"Only print the service definition instead of installing it")
+	installCmd.Flags().BoolVar(&opts.noStart, "no-start", false,
+		"Only write the service definition, without enabling and starting it")
+
+	uninstallCmd := &cobra.Command{
+		Use:   "uninstall",
+		Short: "Stop and remove the service installed by `gscache daemon install`",
+		Run: func(cmd *cobra.Command, args []string) {
+			if err := uninstallService(); err != nil {
+				log.Error("Failed to uninstall service", zap.Error(err))
+				os.Exit(1)
+			}
+		},
+	}
+
+	daemonCmd.AddCommand(installCmd)
+	daemonCmd.AddCommand(uninstallCmd)
+}
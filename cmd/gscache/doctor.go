@@ -0,0 +1,314 @@
+package main
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"net"
+	"os"
+	"os/exec"
+	"path/filepath"
+	"strconv"
+	"strings"
+	"syscall"
+	"time"
+
+	"github.com/spf13/cobra"
+	gocloudblob "gocloud.dev/blob"
+
+	"github.com/breezewish/gscache/internal/cache/backends/blob"
+	"github.com/breezewish/gscache/internal/client"
+	"github.com/breezewish/gscache/internal/server"
+	"github.com/breezewish/gscache/internal/util"
+)
+
+// doctorMaxClockSkew is the clock difference to the remote beyond which a warning is shown.
+// Clean and compaction compare local time with remote modification times, and signed requests
+// of S3 are rejected when clocks differ by 15 minutes.
+const doctorMaxClockSkew = 1 * time.Minute
+
+type doctorStatus int
+
+const (
+	doctorOK doctorStatus = iota
+	doctorWarn
+	doctorFail
+)
+
+func (s doctorStatus) String() string {
+	switch s {
+	case doctorOK:
+		return " OK "
+	case doctorWarn:
+		return "WARN"
+	default:
+		return "FAIL"
+	}
+}
+
+type doctorResult struct {
+	name   string
+	status doctorStatus
+	detail string
+	fix    string // How to fix a warning or a failure
+}
+
+func (r doctorResult) print() {
+	fmt.Printf("[%s] %s: %s\n", r.status, r.name, r.detail)
+	if r.fix != "" && r.status != doctorOK {
+		fmt.Printf("       fix: %s\n", r.fix)
+	}
+}
+
+func checkWorkDir(cfg *server.Config) doctorResult {
+	r := doctorResult{name: "Work dir"}
+	info, err := os.Stat(cfg.Dir)
+	if os.IsNotExist(err) {
+		// The daemon creates the work dir on start, so the closest existing parent must be writable
+		parent := filepath.Dir(cfg.Dir)
+		for {
+			if _, err := os.Stat(parent); err == nil || parent == filepath.Dir(parent) {
+				break
+			}
+			parent = filepath.Dir(parent)
+		}
+		if err := probeWritable(parent); err != nil {
+			r.status = doctorFail
+			r.detail = fmt.Sprintf("%s does not exist and cannot be created: %v", cfg.Dir, err)
+			r.fix = "create it with write permission for this user, or set --dir / GSCACHE_DIR to a writable path"
+			return r
+		}
+		r.detail = fmt.Sprintf("%s does not exist yet, it will be created by the daemon", cfg.Dir)
+		return r
+	}
+	if err != nil {
+		r.status = doctorFail
+		r.detail = fmt.Sprintf("cannot access %s: %v", cfg.Dir, err)
+		r.fix = "check permissions of the work dir and its parents"
+		return r
+	}
+	if !info.IsDir() {
+		r.status = doctorFail
+		r.detail = fmt.Sprintf("%s is not a directory", cfg.Dir)
+		r.fix = "set --dir / GSCACHE_DIR to a directory"
+		return r
+	}
+	if cfg.ReadOnly {
+		if _, err := os.ReadDir(cfg.Dir); err != nil {
+			r.status = doctorFail
+			r.detail = fmt.Sprintf("%s is not readable: %v", cfg.Dir, err)
+			r.fix = "check permissions of the work dir"
+			return r
+		}
+		r.detail = fmt.Sprintf("%s is readable (read_only is set)", cfg.Dir)
+		return r
+	}
+	if err := probeWritable(cfg.Dir); err != nil {
+		r.status = doctorFail
+		r.detail = fmt.Sprintf("%s is not writable: %v", cfg.Dir, err)
+		r.fix = fmt.Sprintf("run `chown -R $(id -u) %s`, or set read_only = true if it is a pre-baked read-only cache", cfg.Dir)
+		return r
+	}
+	r.detail = fmt.Sprintf("%s is writable", cfg.Dir)
+	return r
+}
+
+func probeWritable(dir string) error {
+	f, err := os.CreateTemp(dir, ".gscache-doctor.tmp.*")
+	if err != nil {
+		return err
+	}
+	_ = f.Close()
+	return os.Remove(f.Name())
+}
+
+// checkDaemon checks daemon liveness, or whether a daemon can listen on the port if it is not running.
+func checkDaemon(cfg *server.Config) []doctorResult {
+	host := cfg.Host
+	if host == "" {
+		host = client.DefaultDaemonHost
+	}
+	addr := net.JoinHostPort(host, strconv.Itoa(cfg.Port))
+	r := doctorResult{name: "Daemon"}
+	ping, err := newClient().CallPing()
+	if err == nil {
+		r.detail = fmt.Sprintf("running at %s (pid %d)", addr, ping.Pid)
+		results := []doctorResult{r}
+		if ping.CompactionUnhealthy {
+			results = append(results, doctorResult{
+				name:   "Compaction",
+				status: doctorWarn,
+				detail: "recent compactions of the daemon all failed, archives may be stale",
+				fix:    "check `gscache log` for compaction errors, or run `gscache compact` to retry",
+			})
+		}
+		return results
+	}
+	if !errors.Is(err, syscall.ECONNREFUSED) {
+		// Something else answers on the port
+		r.status = doctorFail
+		r.detail = fmt.Sprintf("%s does not respond as a gscache daemon: %v", addr, err)
+		r.fix = "another program may use this port, set --port / GSCACHE_PORT to a free port"
+		return []doctorResult{r}
+	}
+	if cfg.Host != "" && !util.IsLoopbackHost(cfg.Host) {
+		r.status = doctorFail
+		r.detail = fmt.Sprintf("no daemon is reachable at %s", addr)
+		r.fix = "start the remote daemon, or check host and port in the config"
+		return []doctorResult{r}
+	}
+	r.status = doctorWarn
+	r.detail = fmt.Sprintf("not running at %s, it is started on demand by `gscache prog`", addr)
+	r.fix = "run `gscache daemon start` to start it now"
+	results := []doctorResult{r}
+
+	port := doctorResult{name: "Port"}
+	ln, err := net.Listen("tcp", addr)
+	if err != nil {
+		port.status = doctorFail
+		port.detail = fmt.Sprintf("cannot listen on %s: %v", addr, err)
+		port.fix = "set --port / GSCACHE_PORT to a free port"
+	} else {
+		_ = ln.Close()
+		port.detail = fmt.Sprintf("%s is available", addr)
+	}
+	return append(results, port)
+}
+
+// checkRemote probes the remote blob store, including credentials and clock skew.
+func checkRemote(cfg *server.Config) []doctorResult {
+	r := doctorResult{name: "Blob store"}
+	if cfg.Blob.URL == "" {
+		r.detail = "not configured, only the local cache is used"
+		return []doctorResult{r}
+	}
+	ctx := context.Background()
+	bucket, err := gocloudblob.OpenBucket(ctx, cfg.Blob.URL)
+	if err != nil {
+		r.status = doctorFail
+		r.detail = fmt.Sprintf("cannot open %s: %v", util.RedactURL(cfg.Blob.URL), err)
+		r.fix = "check blob.url, e.g. s3://bucket?region=us-east-1 or gs://bucket"
+		return []doctorResult{r}
+	}
+	defer bucket.Close()
+
+	report := blob.ProbeRemote(ctx, bucket)
+	if failed := report.Failed(); failed != nil {
+		r.status = doctorFail
+		r.detail = fmt.Sprintf("%s of a probe object to %s failed: %v", failed.Op, util.RedactURL(cfg.Blob.URL), failed.Err)
+		r.fix = probeErrorFix(failed.Err)
+		return []doctorResult{r}
+	}
+	latencies := make([]string, 0, len(report.Ops))
+	for _, op := range report.Ops {
+		latencies = append(latencies, fmt.Sprintf("%s %s", op.Op, op.Latency.Round(time.Millisecond)))
+	}
+	r.detail = fmt.Sprintf("%s is readable and writable (%s)", util.RedactURL(cfg.Blob.URL), strings.Join(latencies, ", "))
+	results := []doctorResult{r}
+
+	if report.ClockSkewKnown {
+		skew := report.ClockSkew.Round(time.Second)
+		clock := doctorResult{
+			name:   "Clock",
+			detail: fmt.Sprintf("local clock differs from the blob store by %s", skew),
+			fix:    "enable time sync, e.g. `timedatectl set-ntp true`",
+		}
+		if skew.Abs() > doctorMaxClockSkew {
+			clock.status = doctorWarn
+		}
+		results = append(results, clock)
+	}
+	return results
+}
+
+// checkGoCacheProg checks whether the go command is configured to use gscache.
+func checkGoCacheProg(cfg *server.Config) doctorResult {
+	r := doctorResult{name: "GOCACHEPROG"}
+	out, err := exec.Command("go", "env", "GOVERSION", "GOCACHEPROG").Output()
+	if err != nil {
+		r.status = doctorWarn
+		r.detail = fmt.Sprintf("cannot run `go env`: %v", err)
+		r.fix = "make sure the go command is in PATH"
+		return r
+	}
+	lines := strings.SplitN(strings.TrimRight(string(out), "\n"), "\n", 2)
+	goVersion, cacheProg := lines[0], ""
+	if len(lines) > 1 {
+		cacheProg = strings.TrimSpace(lines[1])
+	}
+	if cacheProg == "" {
+		r.status = doctorWarn
+		r.detail = fmt.Sprintf("not set, %s uses its own local cache", goVersion)
+		r.fix = `eval "$(gscache env)"`
+		return r
+	}
+	fields := strings.Fields(cacheProg)
+	if len(fields) < 2 || fields[1] != "prog" || !strings.Contains(filepath.Base(fields[0]), "gscache") {
+		r.status = doctorWarn
+		r.detail = fmt.Sprintf("%q does not run gscache", cacheProg)
+		r.fix = `eval "$(gscache env)"`
+		return r
+	}
+	if _, err := exec.LookPath(fields[0]); err != nil {
+		r.status = doctorFail
+		r.detail = fmt.Sprintf("%s in %q is not found", fields[0], cacheProg)
+		r.fix = "add gscache to PATH, or use the absolute path of gscache in GOCACHEPROG"
+		return r
+	}
+	for i, field := range fields {
+		port := ""
+		if v, ok := strings.CutPrefix(field, "--port="); ok {
+			port = v
+		} else if field == "--port" && i+1 < len(fields) {
+			port = fields[i+1]
+		}
+		if port != "" && port != strconv.Itoa(cfg.Port) {
+			r.status = doctorWarn
+			r.detail = fmt.Sprintf("%q uses port %s, while the config uses %d", cacheProg, port, cfg.Port)
+			r.fix = "use the same port in GOCACHEPROG and the config, otherwise two daemons are started"
+			return r
+		}
+	}
+	r.detail = fmt.Sprintf("%q (%s)", cacheProg, goVersion)
+	return r
+}
+
+func init() {
+	doctorCmd := &cobra.Command{
+		Use:   "doctor",
+		Short: "Check the environment for common problems and print how to fix them",
+		Run: func(cmd *cobra.Command, args []string) {
+			cfg, err := server.LoadConfig(configFilePath(), rootCmd.PersistentFlags())
+			if err != nil {
+				doctorResult{
+					name:   "Config",
+					status: doctorFail,
+					detail: err.Error(),
+					fix:    "fix the config file, or run `gscache config show` to see where values come from",
+				}.print()
+				os.Exit(1)
+			}
+			serverConfig = &cfg
+
+			results := []doctorResult{{name: "Config", detail: fmt.Sprintf("backend %s", server.BackendName(cfg))}}
+			results = append(results, checkWorkDir(&cfg))
+			results = append(results, checkDaemon(&cfg)...)
+			results = append(results, checkRemote(&cfg)...)
+			results = append(results, checkGoCacheProg(&cfg))
+
+			failed := 0
+			for _, r := range results {
+				r.print()
+				if r.status == doctorFail {
+					failed++
+				}
+			}
+			if failed > 0 {
+				fmt.Printf("\n%d check(s) failed\n", failed)
+				os.Exit(1)
+			}
+		},
+	}
+
+	rootCmd.AddCommand(doctorCmd)
+}
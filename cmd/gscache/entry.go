@@ -0,0 +1,181 @@
+package main
+
+import (
+	"crypto/sha256"
+	"encoding/hex"
+	"fmt"
+	"io"
+	"os"
+
+	"github.com/spf13/cobra"
+	"go.uber.org/zap"
+
+	"github.com/breezewish/gscache/internal/log"
+	"github.com/breezewish/gscache/internal/protocol"
+	"github.com/breezewish/gscache/internal/util"
+)
+
+type entryOpts struct {
+	namespace string
+	output    string // get only
+	outputID  string // put only
+}
+
+func parseHexID(name, s string) ([]byte, error) {
+	id, err := hex.DecodeString(s)
+	if err != nil || len(id) == 0 {
+		return nil, fmt.Errorf("invalid %s %q, expect a hex string", name, s)
+	}
+	return id, nil
+}
+
+func runEntryGet(actionIDHex string, opts entryOpts) error {
+	actionID, err := parseHexID("actionID", actionIDHex)
+	if err != nil {
+		return err
+	}
+	resp, err := newClient().CallGet(protocol.GetRequest{ActionID: actionID, Namespace: opts.namespace})
+	if err != nil {
+		return err
+	}
+	util.PrettyPrintJSON(resp)
+	if resp.Miss {
+		return nil
+	}
+	// IDs are base64 in JSON, while the go command prints them in hex
+	fmt.Printf("OutputID (hex): %x\n", resp.OutputID)
+	if opts.output == "" {
+		return nil
+	}
+	src, err := os.Open(resp.DiskPath)
+	if err != nil {
+		return fmt.Errorf("failed to open output file: %w", err)
+	}
+	defer src.Close()
+	dst, err := os.Create(opts.output)
+	if err != nil {
+		return err
+	}
+	if _, err := io.Copy(dst, src); err != nil {
+		_ = dst.Close()
+		return fmt.Errorf("failed to copy output file: %w", err)
+	}
+	return dst.Close()
+}
+
+func runEntryPut(actionIDHex string, file string, opts entryOpts) error {
+	actionID, err := parseHexID("actionID", actionIDHex)
+	if err != nil {
+		return err
+	}
+	f, err := os.Open(file)
+	if err != nil {
+		return err
+	}
+	defer f.Close()
+	info, err := f.Stat()
+	if err != nil {
+		return err
+	}
+
+	var outputID []byte
+	if opts.outputID != "" {
+		if outputID, err = parseHexID("outputID", opts.outputID); err != nil {
+			return err
+		}
+	} else {
+		// Same as the go command, which uses the SHA-256 of the content as the OutputID
+		h := sha256.New()
+		if _, err := io.Copy(h, f); err != nil {
+			return err
+		}
+		outputID = h.Sum(nil)
+		if _, err := f.Seek(0, io.SeekStart); err != nil {
+			return err
+		}
+	}
+
+	resp, err := newClient().PutPlain(protocol.PutRequest{
+		ActionID:  actionID,
+		OutputID:  outputID,
+		BodySize:  info.Size(),
+		Namespace: opts.namespace,
+	}, f)
+	if err != nil {
+		return err
+	}
+	util.PrettyPrintJSON(resp)
+	return nil
+}
+
+func runEntryRm(actionIDHex string, opts entryOpts) error {
+	actionID, err := parseHexID("actionID", actionIDHex)
+	if err != nil {
+		return err
+	}
+	resp, err := newClient().CallDelete(protocol.DeleteRequest{ActionID: actionID, Namespace: opts.namespace})
+	if err != nil {
+		return err
+	}
+	util.PrettyPrintJSON(resp)
+	if resp.InArchive {
+		log.Warn("The entry is still in an archive and served from it until the keyspace is compacted again, e.g. by `gscache compact`")
+	}
+	return nil
+}
+
+func init() {
+	opts := entryOpts{}
+
+	entryCmd := &cobra.Command{
+		Use:   "entry",
+		Short: "Get, put or delete a single cache entry via the running daemon, for debugging",
+	}
+	entryCmd.PersistentFlags().StringVar(&opts.namespace, "namespace", "",
+		"Namespace of the entry. Empty means the default namespace")
+
+	getCmd := &cobra.Command{
+		Use:   "get <actionID>",
+		Short: "Get an entry by its hex actionID, and print the response",
+		Args:  cobra.ExactArgs(1),
+		Run: func(cmd *cobra.Command, args []string) {
+			if err := runEntryGet(args[0], opts); err != nil {
+				log.Error("Failed to get entry", zap.Error(err))
+				os.Exit(1)
+			}
+		},
+	}
+	getCmd.Flags().StringVarP(&opts.output, "output", "o", "",
+		"Also copy the output of a hit to this file")
+
+	putCmd := &cobra.Command{
+		Use:   "put <actionID> <file>",
+		Short: "Put the content of a file as an entry with the hex actionID",
+		Args:  cobra.ExactArgs(2),
+		Run: func(cmd *cobra.Command, args []string) {
+			if err := runEntryPut(args[0], args[1], opts); err != nil {
+				log.Error("Failed to put entry", zap.Error(err))
+				os.Exit(1)
+			}
+		},
+	}
+	putCmd.Flags().StringVar(&opts.outputID, "output-id", "",
+		"Hex outputID of the entry. Defaults to the SHA-256 of the file content")
+
+	rmCmd := &cobra.Command{
+		Use:   "rm <actionID>",
+		Short: "Delete an entry by its hex actionID from the local store and the remote bucket",
+		Args:  cobra.ExactArgs(1),
+		Run: func(cmd *cobra.Command, args []string) {
+			if err := runEntryRm(args[0], opts); err != nil {
+				log.Error("Failed to delete entry", zap.Error(err))
+				os.Exit(1)
+			}
+		},
+	}
+
+	entryCmd.AddCommand(getCmd)
+	entryCmd.AddCommand(putCmd)
+	entryCmd.AddCommand(rmCmd)
+	rootCmd.AddCommand(entryCmd)
+}
@@ -0,0 +1,151 @@
+package main
+
+import (
+	"cmp"
+	"fmt"
+	"os"
+	"os/exec"
+	"path/filepath"
+	"strconv"
+	"strings"
+
+	"github.com/spf13/cobra"
+	"github.com/spf13/pflag"
+	"go.uber.org/zap"
+
+	"github.com/breezewish/gscache/internal/log"
+	"github.com/breezewish/gscache/internal/server"
+)
+
+type envOpts struct {
+	namespace  string
+	standalone bool
+	shell      string
+	goEnv      bool
+}
+
+// quoteGoArg quotes an argument of GOCACHEPROG if needed. The go command splits GOCACHEPROG
+// by spaces, and a field can be enclosed in single or double quotes, without escaping.
+func quoteGoArg(arg string) (string, error) {
+	if arg != "" && !strings.ContainsAny(arg, " \t\n\r'\"") {
+		return arg, nil
+	}
+	if !strings.Contains(arg, "'") {
+		return "'" + arg + "'", nil
+	}
+	if !strings.Contains(arg, `"`) {
+		return `"` + arg + `"`, nil
+	}
+	return "", fmt.Errorf("%q contains both single and double quotes, which cannot be used in GOCACHEPROG", arg)
+}
+
+// goCacheProgValue returns the GOCACHEPROG value which runs `gscache prog` with the resolved
+// config, i.e. the same config file, flags and port as this command.
+func goCacheProgValue(opts envOpts) (string, error) {
+	exe, err := os.Executable()
+	if err != nil {
+		return "", fmt.Errorf("failed to get the path of gscache: %w", err)
+	}
+	args := []string{exe, "prog"}
+	if path := configFilePath(); path != "" {
+		// Also covers GSCACHE_CONFIG, which may not be set for the go command
+		abs, err := filepath.Abs(path)
+		if err != nil {
+			return "", err
+		}
+		args = append(args, "--config="+abs)
+	}
+	rootCmd.PersistentFlags().VisitAll(func(f *pflag.Flag) {
+		if f.Changed && f.Name != "config" && f.Name != "port" && f.Name != "socket" {
+			args = append(args, "--"+f.Name+"="+f.Value.String())
+		}
+	})
+	// Always pinned, so that the go command reaches the same daemon even if the config changes
+	args = append(args, "--port="+strconv.Itoa(getServerConfig().Port))
+	args = append(args, "--socket="+cmp.Or(getServerConfig().SocketPath(), server.SocketNone))
+	if opts.namespace != "" {
+		args = append(args, "--namespace="+opts.namespace)
+	}
+	if opts.standalone {
+		args = append(args, "--standalone")
+	}
+
+	quoted := make([]string, 0, len(args))
+	for _, arg := range args {
+		q, err := quoteGoArg(arg)
+		if err != nil {
+			return "", err
+		}
+		quoted = append(quoted, q)
+	}
+	return strings.Join(quoted, " "), nil
+}
+
+// shellDoubleQuote quotes s in double quotes, escaping chars which are special in them.
+func shellDoubleQuote(s string, special string) string {
+	var sb strings.Builder
+	sb.WriteByte('"')
+	for _, c := range s {
+		if strings.ContainsRune(special, c) {
+			sb.WriteByte('\\')
+		}
+		sb.WriteRune(c)
+	}
+	sb.WriteByte('"')
+	return sb.String()
+}
+
+func runEnv(opts envOpts) error {
+	value, err := goCacheProgValue(opts)
+	if err != nil {
+		return err
+	}
+	if opts.goEnv {
+		cmd := exec.Command("go", "env", "-w", "GOCACHEPROG="+value)
+		cmd.Stdout = os.Stdout
+		cmd.Stderr = os.Stderr
+		if err := cmd.Run(); err != nil {
+			return fmt.Errorf("failed to run `go env -w`: %w", err)
+		}
+		fmt.Fprintf(os.Stderr, "Set GOCACHEPROG=%s in the go env file\n", value)
+		return nil
+	}
+	switch opts.shell {
+	case "sh":
+		fmt.Printf("export GOCACHEPROG=%s\n", shellDoubleQuote(value, "\"$`\\"))
+	case "fish":
+		fmt.Printf("set -gx GOCACHEPROG %s\n", shellDoubleQuote(value, "\"$\\"))
+	default:
+		return fmt.Errorf("invalid --shell %q, must be one of sh, fish", opts.shell)
+	}
+	return nil
+}
+
+func init() {
+	opts := envOpts{}
+
+	envCmd := &cobra.Command{
+		Use:   "env",
+		Short: "Print GOCACHEPROG for the resolved config, e.g. eval \"$(gscache env)\", or write it via go env -w",
+		Long: "Print a shell statement which sets GOCACHEPROG to run `gscache prog` with the resolved config,\n" +
+			"i.e. the absolute path of this gscache, the config file, the flags given to this command and the\n" +
+			"port. Use it like `eval \"$(gscache env)\"` in shells and CI. With --go-env, GOCACHEPROG is written\n" +
+			"to the go env file via `go env -w` instead, so that it applies to all shells.",
+		Args: cobra.NoArgs,
+		Run: func(cmd *cobra.Command, args []string) {
+			if err := runEnv(opts); err != nil {
+				log.Error("Failed to set up GOCACHEPROG", zap.Error(err))
+				os.Exit(1)
+			}
+		},
+	}
+	envCmd.Flags().StringVar(&opts.namespace, "namespace", "",
+		"Also pass --namespace to gscache prog, e.g. \"auto\"")
+	envCmd.Flags().BoolVar(&opts.standalone, "standalone", false,
+		"Also pass --standalone to gscache prog")
+	envCmd.Flags().StringVar(&opts.shell, "shell", "sh", "Syntax of the printed statement, one of: sh, fish")
+	envCmd.Flags().BoolVar(&opts.goEnv, "go-env", false,
+		"Write GOCACHEPROG via `go env -w` instead of printing")
+
+	rootCmd.AddCommand(envCmd)
+}
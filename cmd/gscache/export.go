@@ -0,0 +1,69 @@
+package main
+
+import (
+	"context"
+	"fmt"
+	"os"
+
+	"github.com/spf13/cobra"
+	"go.uber.org/zap"
+
+	"github.com/breezewish/gscache/internal/bundle"
+	"github.com/breezewish/gscache/internal/log"
+	"github.com/breezewish/gscache/internal/progress"
+	"github.com/breezewish/gscache/internal/util"
+	"github.com/breezewish/gscache/internal/workdir"
+)
+
+func runExport(file string) (*bundle.Manifest, error) {
+	gzipped, err := bundle.IsGzip(file)
+	if err != nil {
+		return nil, err
+	}
+	dir := getServerConfig().Dir
+	if err := workdir.CheckReadOnly(dir); err != nil {
+		return nil, err
+	}
+	// Written to a temp file first, so that an interrupted export does not leave a truncated bundle.
+	tmpPath := file + ".tmp"
+	f, err := os.Create(tmpPath)
+	if err != nil {
+		return nil, fmt.Errorf("failed to create %s: %w", tmpPath, err)
+	}
+	tracker := progress.NewTracker()
+	bar := startProgress("Exporting", tracker)
+	manifest, err := bundle.Export(progress.NewContext(context.Background(), tracker), dir, f, gzipped)
+	bar.Stop()
+	if err2 := f.Close(); err == nil {
+		err = err2
+	}
+	if err != nil {
+		_ = os.Remove(tmpPath)
+		return nil, err
+	}
+	if err := os.Rename(tmpPath, file); err != nil {
+		_ = os.Remove(tmpPath)
+		return nil, err
+	}
+	return manifest, nil
+}
+
+func init() {
+	exportCmd := &cobra.Command{
+		Use:   "export <file.tar.gz|file.tgz|file.tar>",
+		Short: "Package the local cache and local archives into a tarball, e.g. to be stored as a CI artifact",
+		Args:  cobra.ExactArgs(1),
+		Run: func(cmd *cobra.Command, args []string) {
+			manifest, err := runExport(args[0])
+			if err != nil {
+				log.Error("Failed to export cache", zap.Error(err))
+				os.Exit(1)
+			}
+			fmt.Printf("Exported %d entries, %d outputs and %d archives (%s) to %s\n",
+				manifest.Actions, manifest.Outputs, manifest.Archives,
+				util.FormatBytes(uint64(manifest.Bytes)), args[0])
+		},
+	}
+
+	rootCmd.AddCommand(exportCmd)
+}
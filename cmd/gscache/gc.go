@@ -0,0 +1,109 @@
+package main
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"os"
+	"syscall"
+	"time"
+
+	"github.com/spf13/cobra"
+	"go.uber.org/zap"
+
+	"github.com/breezewish/gscache/internal/cache/backends/local"
+	"github.com/breezewish/gscache/internal/log"
+	"github.com/breezewish/gscache/internal/protocol"
+	"github.com/breezewish/gscache/internal/server"
+	"github.com/breezewish/gscache/internal/stats"
+	"github.com/breezewish/gscache/internal/util"
+)
+
+type gcOpts struct {
+	maxAge   time.Duration
+	maxBytes int64
+}
+
+// runGC trims the local store via the daemon if it is running, otherwise in the current process.
+func runGC(req protocol.GCRequest) (*protocol.GCResponse, error) {
+	resp, err := newClient().CallGC(req)
+	if err == nil {
+		return resp, nil
+	}
+	if !errors.Is(err, syscall.ECONNREFUSED) {
+		return nil, err
+	}
+
+	log.Info("Server daemon is not running, trim local cache in the current process")
+	cfg := getServerConfig()
+	if req == (protocol.GCRequest{}) {
+		req = protocol.GCRequest{MaxAge: cfg.GC.MaxAge, MaxBytes: cfg.GC.MaxBytes}
+	}
+	if cfg.ReadOnly {
+		return nil, fmt.Errorf("gc is not available in read_only mode")
+	}
+	dirLock, err := server.LockWorkDir(cfg.Dir)
+	if err != nil {
+		return nil, err
+	}
+	defer dirLock.Unlock()
+	if err := server.MigrateWorkDir(*cfg); err != nil {
+		return nil, err
+	}
+
+	stats.Default.LoadFromFileAndAttach(cfg.StatsFilePath())
+	defer stats.Default.ForcePersist()
+
+	store, err := local.NewLocalBackend(cfg.Dir)
+	if err != nil {
+		return nil, err
+	}
+	if err := store.Open(context.Background()); err != nil {
+		return nil, err
+	}
+	defer store.Close()
+	return store.GC(req)
+}
+
+func init() {
+	opts := gcOpts{}
+
+	gcCmd := &cobra.Command{
+		Use:   "gc",
+		Short: "Trim the local cache by age and total size",
+		Run: func(cmd *cobra.Command, args []string) {
+			// Without flags, an empty request is sent, so that the daemon trims by its gc config,
+			// which may have been reloaded.
+			if cmd.Flags().Changed("max-age") || cmd.Flags().Changed("max-bytes") {
+				cfg := getServerConfig()
+				if !cmd.Flags().Changed("max-age") {
+					opts.maxAge = cfg.GC.MaxAge
+				}
+				if !cmd.Flags().Changed("max-bytes") {
+					opts.maxBytes = cfg.GC.MaxBytes
+				}
+			}
+			if opts.maxAge < 0 || opts.maxBytes < 0 {
+				log.Error("--max-age and --max-bytes must not be negative")
+				os.Exit(1)
+			}
+			resp, err := runGC(protocol.GCRequest{
+				MaxAge:   opts.maxAge,
+				MaxBytes: opts.maxBytes,
+			})
+			if err != nil {
+				log.Error("Failed to trim local cache", zap.Error(err))
+				os.Exit(1)
+			}
+			fmt.Printf("Removed %d entries (%s), %d entries (%s) remaining\n",
+				resp.RemovedEntries, util.FormatBytes(uint64(resp.RemovedBytes)),
+				resp.RemainingEntries, util.FormatBytes(uint64(resp.RemainingBytes)))
+		},
+	}
+	gcCmd.Flags().DurationVar(&opts.maxAge, "max-age", 0,
+		"Remove entries not used within this duration, 0 to disable. Default: gc.max_age in config")
+	gcCmd.Flags().Int64Var(&opts.maxBytes, "max-bytes", 0,
+		"Remove least recently used entries until outputs take at most this many bytes, 0 to disable. Default: gc.max_bytes in config")
+
+	rootCmd.AddCommand(gcCmd)
+}
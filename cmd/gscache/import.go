@@ -0,0 +1,78 @@
+package main
+
+import (
+	"context"
+	"fmt"
+	"os"
+
+	"github.com/spf13/cobra"
+	"go.uber.org/zap"
+
+	"github.com/breezewish/gscache/internal/bundle"
+	"github.com/breezewish/gscache/internal/log"
+	"github.com/breezewish/gscache/internal/progress"
+	"github.com/breezewish/gscache/internal/server"
+	"github.com/breezewish/gscache/internal/util"
+)
+
+// runImport extracts the bundle into the work dir. The daemon must be stopped, so that the
+// imported archives are loaded when it starts.
+func runImport(file string) (*bundle.ImportReport, error) {
+	gzipped, err := bundle.IsGzip(file)
+	if err != nil {
+		return nil, err
+	}
+	cfg := getServerConfig()
+	if cfg.ReadOnly {
+		return nil, fmt.Errorf("import is not available in read_only mode")
+	}
+	// Importing is a typical first step on a fresh machine, where the work dir does not exist yet.
+	if err := os.MkdirAll(cfg.Dir, 0755); err != nil {
+		return nil, fmt.Errorf("failed to create work dir: %w", err)
+	}
+	dirLock, err := server.LockWorkDir(cfg.Dir)
+	if err != nil {
+		return nil, err
+	}
+	defer dirLock.Unlock()
+	if err := server.MigrateWorkDir(*cfg); err != nil {
+		return nil, err
+	}
+
+	f, err := os.Open(file)
+	if err != nil {
+		return nil, err
+	}
+	defer f.Close()
+	tracker := progress.NewTracker()
+	bar := startProgress("Importing", tracker)
+	manifest, report, err := bundle.Import(progress.NewContext(context.Background(), tracker), cfg.Dir, f, gzipped)
+	bar.Stop()
+	if manifest != nil {
+		log.Info("Importing bundle",
+			zap.Time("createdAt", manifest.CreatedAt),
+			zap.Int("actions", manifest.Actions),
+			zap.Int("outputs", manifest.Outputs),
+			zap.Int("archives", manifest.Archives))
+	}
+	return report, err
+}
+
+func init() {
+	importCmd := &cobra.Command{
+		Use:   "import <file.tar.gz|file.tgz|file.tar>",
+		Short: "Extract a tarball written by `gscache export` into the local cache. Existing files are kept",
+		Args:  cobra.ExactArgs(1),
+		Run: func(cmd *cobra.Command, args []string) {
+			report, err := runImport(args[0])
+			if err != nil {
+				log.Error("Failed to import cache", zap.Error(err))
+				os.Exit(1)
+			}
+			fmt.Printf("Imported %d files (%s), skipped %d existing files\n",
+				report.Imported, util.FormatBytes(uint64(report.ImportedBytes)), report.Skipped)
+		},
+	}
+
+	rootCmd.AddCommand(importCmd)
+}
@@ -0,0 +1,106 @@
+package main
+
+import (
+	"fmt"
+	"io"
+	"os"
+
+	"github.com/spf13/cobra"
+	"go.uber.org/atomic"
+	"go.uber.org/zap"
+	"golang.org/x/sync/errgroup"
+
+	"github.com/breezewish/gscache/internal/client"
+	"github.com/breezewish/gscache/internal/gocache"
+	"github.com/breezewish/gscache/internal/log"
+	"github.com/breezewish/gscache/internal/protocol"
+)
+
+func importGoCacheEntry(c *client.Client, dir string, entry gocache.Entry) error {
+	var body io.Reader
+	if entry.Size > 0 {
+		outputPath := gocache.OutputPath(dir, entry.OutputID)
+		f, err := os.Open(outputPath)
+		if err != nil {
+			return err
+		}
+		defer f.Close()
+		info, err := f.Stat()
+		if err != nil {
+			return err
+		}
+		if info.Size() != entry.Size {
+			return fmt.Errorf("output file size mismatch: expected %d, got %d", entry.Size, info.Size())
+		}
+		body = f
+	}
+	_, err := c.PutPlain(protocol.PutRequest{
+		ActionID: entry.ActionID,
+		OutputID: entry.OutputID,
+		BodySize: entry.Size,
+		// Makes re-running an import cheap: existing entries are neither rewritten nor re-uploaded.
+		IfAbsent: true,
+	}, body)
+	return err
+}
+
+func init() {
+	var concurrency int
+
+	importGoCacheCmd := &cobra.Command{
+		Use:   "import-gocache <dir>",
+		Short: "Import entries from an existing Go build cache dir (i.e. $GOCACHE) into gscache",
+		Args:  cobra.ExactArgs(1),
+		Run: func(cmd *cobra.Command, args []string) {
+			dir := args[0]
+			if err := ensureDaemonRunning( /* isExplicitStart */ false); err != nil {
+				log.Error("Failed to start gscache server daemon", zap.Error(err))
+				os.Exit(1)
+			}
+
+			c := newClient()
+			nImported := atomic.NewInt64(0)
+			nImportedBytes := atomic.NewInt64(0)
+			nFailed := atomic.NewInt64(0)
+			nInvalid := atomic.NewInt64(0)
+
+			g := errgroup.Group{}
+			g.SetLimit(concurrency)
+			err := gocache.Walk(dir, func(entry gocache.Entry) error {
+				g.Go(func() error {
+					if err := importGoCacheEntry(c, dir, entry); err != nil {
+						nFailed.Inc()
+						log.Warn("Failed to import entry",
+							zap.String("actionID", fmt.Sprintf("%x", entry.ActionID)),
+							zap.Error(err))
+						return nil
+					}
+					nImported.Inc()
+					nImportedBytes.Add(entry.Size)
+					return nil
+				})
+				return nil
+			}, func(path string, err error) {
+				nInvalid.Inc()
+				log.Debug("Skip invalid Go cache entry", zap.String("path", path), zap.Error(err))
+			})
+			_ = g.Wait()
+			if err != nil {
+				log.Error("Failed to read Go cache dir", zap.String("dir", dir), zap.Error(err))
+				os.Exit(1)
+			}
+
+			log.Info("Import finished",
+				zap.Int64("imported", nImported.Load()),
+				zap.Int64("importedBytes", nImportedBytes.Load()),
+				zap.Int64("failed", nFailed.Load()),
+				zap.Int64("invalid", nInvalid.Load()))
+			if nFailed.Load() > 0 {
+				os.Exit(1)
+			}
+		},
+	}
+	importGoCacheCmd.Flags().IntVar(&concurrency, "concurrency", 16, "Number of entries to import concurrently")
+
+	rootCmd.AddCommand(importGoCacheCmd)
+}
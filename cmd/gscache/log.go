@@ -2,25 +2,88 @@ package main
 
 import (
 	"bufio"
+	"context"
 	"encoding/json"
 	"errors"
 	"fmt"
+	"io"
+	"math"
 	"os"
-	"os/exec"
 	"os/signal"
+	"regexp"
 	"syscall"
+	"time"
 
 	"github.com/breezewish/gscache/internal/log"
 	"github.com/breezewish/gscache/internal/server"
 	zappretty "github.com/maoueh/zap-pretty"
 	"github.com/spf13/cobra"
 	"go.uber.org/zap"
+	"go.uber.org/zap/zapcore"
 )
 
+// logFollowPollInterval is how often the log file is checked for new lines.
+const logFollowPollInterval = 200 * time.Millisecond
+
+type logOpts struct {
+	remote bool
+	lines  int
+	level  string
+	grep   string
+	since  string
+	module string
+}
+
+var logCmdOpts = logOpts{}
+
+// buildFilter parses filter flags. --since accepts a duration (e.g. 10m) or an RFC3339 time.
+func (opts logOpts) buildFilter() (log.Filter, error) {
+	var filter log.Filter
+	if opts.level != "" {
+		level, err := zapcore.ParseLevel(opts.level)
+		if err != nil {
+			return filter, fmt.Errorf("invalid --level: %w", err)
+		}
+		filter.MinLevel = &level
+	}
+	if opts.grep != "" {
+		re, err := regexp.Compile(opts.grep)
+		if err != nil {
+			return filter, fmt.Errorf("invalid --grep: %w", err)
+		}
+		filter.Grep = re
+	}
+	if opts.since != "" {
+		if d, err := time.ParseDuration(opts.since); err == nil {
+			filter.Since = time.Now().Add(-d)
+		} else if ts, err := time.Parse(time.RFC3339, opts.since); err == nil {
+			filter.Since = ts
+		} else {
+			return filter, fmt.Errorf("invalid --since %q, expect a duration like 10m or a time like 2006-01-02T15:04:05Z", opts.since)
+		}
+	}
+	filter.Module = opts.module
+	return filter, nil
+}
+
 var logCmd = &cobra.Command{
 	Use:   "log",
 	Short: "Tail the daemon log file",
 	Run: func(cmd *cobra.Command, args []string) {
+		filter, err := logCmdOpts.buildFilter()
+		if err != nil {
+			log.Error("Invalid filter", zap.Error(err))
+			os.Exit(1)
+		}
+
+		if logCmdOpts.remote {
+			if err := runRemoteLog(logCmdOpts.lines, filter); err != nil {
+				log.Error("Failed to stream logs from server", zap.Error(err))
+				os.Exit(1)
+			}
+			return
+		}
+
 		logFile := getServerConfig().Log.File
 		pid := -1
 
@@ -54,52 +117,127 @@ var logCmd = &cobra.Command{
 		log.Info("Tailing log file", zap.String("logFile", logFile), zap.Int("pid", pid))
 		log.Info("Press Ctrl+C to stop")
 
-		if err := runTailCommand(logFile); err != nil {
+		if err := runTailLog(logFile, logCmdOpts.lines, filter); err != nil {
 			log.Error("Failed to tail log file", zap.Error(err))
 			os.Exit(1)
 		}
 	},
 }
 
-func runTailCommand(logFile string) error {
-	cmd := exec.Command("tail", "-f", logFile)
-	stdout, err := cmd.StdoutPipe()
-	if err != nil {
-		return fmt.Errorf("failed to create stdout pipe: %w", err)
+// prettyPrinter pretty-prints JSON log lines written to it to stdout.
+type prettyPrinter struct {
+	pw   *io.PipeWriter
+	done chan struct{}
+}
+
+func newPrettyPrinter() *prettyPrinter {
+	pr, pw := io.Pipe()
+	p := &prettyPrinter{pw: pw, done: make(chan struct{})}
+	go func() {
+		defer close(p.done)
+		processor := zappretty.NewProcessor(bufio.NewScanner(pr), os.Stdout)
+		processor.Process()
+		_, _ = io.Copy(io.Discard, pr)
+	}()
+	return p
+}
+
+func (p *prettyPrinter) printLine(line []byte) error {
+	// Not appending to line, which may share the buffer of the caller
+	if _, err := p.pw.Write(line); err != nil {
+		return err
 	}
+	_, err := p.pw.Write([]byte{'\n'})
+	return err
+}
 
-	cmd.Stderr = os.Stderr
+// close waits until all written lines are printed.
+func (p *prettyPrinter) close() {
+	_ = p.pw.Close()
+	<-p.done
+}
 
-	c := make(chan os.Signal, 1)
-	signal.Notify(c, os.Interrupt, syscall.SIGTERM)
+// runTailLog prints the last lines of the log file matching the filter, then follows new
+// lines until SIGINT / SIGTERM.
+func runTailLog(logFile string, lines int, filter log.Filter) error {
+	ctx, stop := signal.NotifyContext(context.Background(), os.Interrupt, syscall.SIGTERM)
+	defer stop()
 
-	if err := cmd.Start(); err != nil {
-		return fmt.Errorf("failed to start tail command: %w", err)
+	// Filter lines in the whole tail window, so that up to n matching lines are shown
+	all, offset, err := log.TailLines(logFile, math.MaxInt, 0)
+	if err != nil {
+		return fmt.Errorf("failed to read log file: %w", err)
+	}
+	matched := make([]string, 0, lines)
+	for _, line := range all {
+		if filter.Match([]byte(line)) {
+			matched = append(matched, line)
+		}
+	}
+	if len(matched) > lines {
+		matched = matched[len(matched)-lines:]
 	}
 
-	done := make(chan error, 1)
-	go func() {
-		defer stdout.Close()
-
-		scanner := bufio.NewScanner(stdout)
-		processor := zappretty.NewProcessor(scanner, os.Stdout)
-		processor.Process()
+	printer := newPrettyPrinter()
+	defer printer.close()
+	for _, line := range matched {
+		if err := printer.printLine([]byte(line)); err != nil {
+			return err
+		}
+	}
+	return log.FollowFile(ctx, logFile, offset, logFollowPollInterval, func(line []byte) error {
+		if !filter.Match(line) {
+			return nil
+		}
+		return printer.printLine(line)
+	})
+}
 
-		done <- cmd.Wait()
-	}()
+// runRemoteLog streams the log via the server API, for daemons whose file system is not
+// accessible, e.g. running in another container.
+func runRemoteLog(lines int, filter log.Filter) error {
+	ctx, stop := signal.NotifyContext(context.Background(), os.Interrupt, syscall.SIGTERM)
+	defer stop()
 
-	select {
-	case <-c:
-		if err := cmd.Process.Kill(); err != nil {
-			return fmt.Errorf("failed to kill tail process: %w", err)
+	body, err := newClient().CallLogs(ctx, lines, true)
+	if err != nil {
+		return err
+	}
+	defer body.Close()
+
+	log.Info("Streaming server log, press Ctrl+C to stop")
+	printer := newPrettyPrinter()
+	defer printer.close()
+	scanner := bufio.NewScanner(body)
+	for scanner.Scan() {
+		if !filter.Match(scanner.Bytes()) {
+			continue
 		}
-		<-done
+		if err := printer.printLine(scanner.Bytes()); err != nil {
+			return err
+		}
+	}
+	if ctx.Err() != nil {
 		return nil
-	case err := <-done:
+	}
+	if err := scanner.Err(); err != nil {
 		return err
 	}
+	return fmt.Errorf("server closed the log stream")
 }
 
 func init() {
+	logCmd.Flags().BoolVar(&logCmdOpts.remote, "remote", false,
+		"Stream the log via the server API instead of reading the log file, e.g. when the daemon runs in another container")
+	logCmd.Flags().IntVar(&logCmdOpts.lines, "lines", 10,
+		"Number of recent lines to show before following")
+	logCmd.Flags().StringVar(&logCmdOpts.level, "level", "",
+		"Only show lines at this level or above, e.g. warn")
+	logCmd.Flags().StringVar(&logCmdOpts.grep, "grep", "",
+		"Only show lines matching this regular expression")
+	logCmd.Flags().StringVar(&logCmdOpts.since, "since", "",
+		"Only show lines logged within this duration (e.g. 10m) or after this RFC3339 time")
+	logCmd.Flags().StringVar(&logCmdOpts.module, "module", "",
+		"Only show lines of this logger and its children, e.g. cache.blob")
 	rootCmd.AddCommand(logCmd)
 }
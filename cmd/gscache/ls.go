@@ -0,0 +1,216 @@
+package main
+
+import (
+	"context"
+	"fmt"
+	"os"
+	"os/signal"
+	"slices"
+	"syscall"
+	"text/tabwriter"
+	"time"
+
+	"github.com/spf13/cobra"
+	"go.uber.org/zap"
+	gocloudblob "gocloud.dev/blob"
+
+	"github.com/breezewish/gscache/internal/cache/backends/blob"
+	"github.com/breezewish/gscache/internal/cache/backends/local"
+	"github.com/breezewish/gscache/internal/log"
+	"github.com/breezewish/gscache/internal/util"
+	"github.com/breezewish/gscache/internal/workdir"
+)
+
+type lsOpts struct {
+	remote    bool
+	keyspaces []string
+	namespace string
+	minSize   string
+	olderThan time.Duration
+}
+
+// lsEntry is a row of `gscache ls`. OutputID is unknown for remote entries, as objects are
+// not downloaded.
+type lsEntry struct {
+	source    string // local, archive or remote
+	namespace string
+	actionID  []byte
+	outputID  []byte
+	size      int64
+	time      time.Time
+}
+
+type lsFilter struct {
+	keyspaces []string // Empty means all
+	namespace *string  // Nil means all
+	minSize   int64
+	before    time.Time // Zero means no limit
+}
+
+func (f lsFilter) match(namespace string, actionID []byte, size int64, t time.Time) bool {
+	if len(f.keyspaces) > 0 && !slices.Contains(f.keyspaces, blob.CacheEntityKeyspace(actionID)) {
+		return false
+	}
+	if f.namespace != nil && *f.namespace != namespace {
+		return false
+	}
+	if size < f.minSize {
+		return false
+	}
+	if !f.before.IsZero() && !t.Before(f.before) {
+		return false
+	}
+	return true
+}
+
+// listLocalEntries lists the local store and local copies of archives. It only reads the
+// work dir, so it can run while the daemon is serving.
+func listLocalEntries(filter lsFilter, fn func(lsEntry)) error {
+	cfg := getServerConfig()
+	if _, err := os.Stat(cfg.Dir); os.IsNotExist(err) {
+		return nil
+	}
+	if err := workdir.CheckReadOnly(cfg.Dir); err != nil {
+		return err
+	}
+	store, err := local.NewLocalBackendWithOpts(cfg.Dir, local.LocalBackendOpts{ReadOnly: true})
+	if err != nil {
+		return err
+	}
+	if err := store.Open(context.Background()); err != nil {
+		return err
+	}
+	defer store.Close()
+	err = store.List(func(e local.ListedEntry) error {
+		if filter.match(e.Namespace, e.ActionID, e.Size, e.Time) {
+			fn(lsEntry{"local", e.Namespace, e.ActionID, e.OutputID, e.Size, e.Time})
+		}
+		return nil
+	})
+	if err != nil {
+		return fmt.Errorf("failed to list local store: %w", err)
+	}
+
+	// Archives only contain entries of the default namespace
+	for _, keyspace := range blob.ArchiveKeyspaces {
+		path := blob.ArchiveFilePath(cfg.Dir, keyspace)
+		if _, err := os.Stat(path); err != nil {
+			continue
+		}
+		ar, err := blob.NewArReader(path)
+		if err != nil {
+			log.Warn("Failed to open archive", zap.String("path", path), zap.Error(err))
+			continue
+		}
+		names := ar.List()
+		slices.Sort(names)
+		for _, name := range names {
+			e := ar.Get(name)
+			if filter.match("", e.ActionID, e.Size, e.Time) {
+				fn(lsEntry{"archive", "", e.ActionID, e.OutputID, e.Size, e.Time})
+			}
+		}
+		_ = ar.Close()
+	}
+	return nil
+}
+
+func listRemoteEntries(filter lsFilter, fn func(lsEntry)) error {
+	cfg := getServerConfig()
+	if cfg.Blob.URL == "" {
+		return fmt.Errorf("--remote is only available when blob.url is set")
+	}
+	keyLayout, err := blob.ParseKeyLayout(cfg.Blob.KeyLayout)
+	if err != nil {
+		return err
+	}
+	ctx, stop := signal.NotifyContext(context.Background(), syscall.SIGINT, syscall.SIGTERM)
+	defer stop()
+	bucket, err := gocloudblob.OpenBucket(ctx, cfg.Blob.URL)
+	if err != nil {
+		return fmt.Errorf("failed to open blob store: %w", err)
+	}
+	defer bucket.Close()
+	return blob.ListRemoteEntries(ctx, bucket, keyLayout, func(e blob.RemoteEntry) error {
+		if filter.match(e.Namespace, e.ActionID, e.Size, e.ModTime) {
+			fn(lsEntry{"remote", e.Namespace, e.ActionID, nil, e.Size, e.ModTime})
+		}
+		return nil
+	})
+}
+
+func runLs(cmd *cobra.Command, opts lsOpts) error {
+	filter := lsFilter{}
+	if len(opts.keyspaces) > 0 {
+		keyspaces, err := blob.ParseKeyspaces(opts.keyspaces)
+		if err != nil {
+			return err
+		}
+		filter.keyspaces = keyspaces
+	}
+	if cmd.Flags().Changed("namespace") {
+		filter.namespace = &opts.namespace
+	}
+	if opts.minSize != "" {
+		minSize, err := util.ParseBytes(opts.minSize)
+		if err != nil {
+			return fmt.Errorf("invalid --min-size: %w", err)
+		}
+		filter.minSize = int64(minSize)
+	}
+	if opts.olderThan > 0 {
+		filter.before = time.Now().Add(-opts.olderThan)
+	}
+
+	w := tabwriter.NewWriter(os.Stdout, 0, 0, 2, ' ', 0)
+	fmt.Fprintln(w, "SOURCE\tNAMESPACE\tACTION ID\tOUTPUT ID\tSIZE\tTIME")
+	var count int
+	var totalSize int64
+	printEntry := func(e lsEntry) {
+		namespace, outputID := e.namespace, fmt.Sprintf("%x", e.outputID)
+		if namespace == "" {
+			namespace = "-"
+		}
+		if e.outputID == nil {
+			outputID = "-"
+		}
+		fmt.Fprintf(w, "%s\t%s\t%x\t%s\t%s\t%s\n", e.source, namespace, e.actionID, outputID,
+			util.FormatBytes(uint64(e.size)), e.time.Local().Format(time.DateTime))
+		count++
+		totalSize += e.size
+	}
+	err := listLocalEntries(filter, printEntry)
+	if err == nil && opts.remote {
+		err = listRemoteEntries(filter, printEntry)
+	}
+	_ = w.Flush()
+	fmt.Printf("%d entries, %s\n", count, util.FormatBytes(uint64(totalSize)))
+	return err
+}
+
+func init() {
+	opts := lsOpts{}
+
+	lsCmd := &cobra.Command{
+		Use:   "ls",
+		Short: "List cache entries in the local store and local copies of archives, and the remote bucket with --remote",
+		Run: func(cmd *cobra.Command, args []string) {
+			if err := runLs(cmd, opts); err != nil {
+				log.Error("Failed to list cache entries", zap.Error(err))
+				os.Exit(1)
+			}
+		},
+	}
+	lsCmd.Flags().BoolVar(&opts.remote, "remote", false,
+		"Also list standalone objects in the remote blob store. Sizes of remote entries include the entry header")
+	lsCmd.Flags().StringSliceVar(&opts.keyspaces, "keyspace", nil,
+		"Only list entries in these keyspaces (the first hex digit of actionIDs), e.g. a or 0-7")
+	lsCmd.Flags().StringVar(&opts.namespace, "namespace", "",
+		"Only list entries in this namespace. Empty means the default namespace. Entries of all namespaces are listed if not set")
+	lsCmd.Flags().StringVar(&opts.minSize, "min-size", "",
+		"Only list entries at least this size, e.g. 1MB")
+	lsCmd.Flags().DurationVar(&opts.olderThan, "older-than", 0,
+		"Only list entries put earlier than this duration ago, e.g. 168h")
+
+	rootCmd.AddCommand(lsCmd)
+}
@@ -0,0 +1,73 @@
+package main
+
+import (
+	"fmt"
+	"os"
+
+	"github.com/spf13/cobra"
+	"go.uber.org/zap"
+
+	"github.com/breezewish/gscache/internal/log"
+	"github.com/breezewish/gscache/internal/server"
+	"github.com/breezewish/gscache/internal/workdir"
+)
+
+type migrateOpts struct {
+	dryRun bool
+}
+
+func runMigrate(opts migrateOpts) error {
+	cfg := getServerConfig()
+	if cfg.ReadOnly {
+		return fmt.Errorf("read_only is set, a read-only work dir cannot be migrated")
+	}
+	version, err := workdir.ReadVersion(cfg.Dir)
+	if err != nil {
+		return err
+	}
+	pending, err := workdir.Pending(cfg.Dir)
+	if err != nil {
+		return err
+	}
+	fmt.Printf("Work dir %s: layout version %d, current version %d\n", cfg.Dir, version, workdir.CurrentVersion)
+	if len(pending) == 0 {
+		fmt.Println("Nothing to migrate")
+		return nil
+	}
+	for _, m := range pending {
+		fmt.Printf("  %d -> %d: %s\n", m.From, m.From+1, m.Description)
+	}
+	if opts.dryRun {
+		return nil
+	}
+
+	dirLock, err := server.LockWorkDir(cfg.Dir)
+	if err != nil {
+		return fmt.Errorf("%w (stop the daemon or use a different --dir)", err)
+	}
+	defer dirLock.Unlock()
+	if err := server.MigrateWorkDir(*cfg); err != nil {
+		return err
+	}
+	fmt.Printf("Migrated to layout version %d\n", workdir.CurrentVersion)
+	return nil
+}
+
+func init() {
+	opts := migrateOpts{}
+
+	migrateCmd := &cobra.Command{
+		Use:   "migrate",
+		Short: "Migrate the work dir to the layout of this gscache version, which is also done when the daemon starts",
+		Run: func(cmd *cobra.Command, args []string) {
+			if err := runMigrate(opts); err != nil {
+				log.Error("Failed to migrate work dir", zap.Error(err))
+				os.Exit(1)
+			}
+		},
+	}
+	migrateCmd.Flags().BoolVar(&opts.dryRun, "dry-run", false,
+		"Only print the layout version and pending migrations")
+
+	rootCmd.AddCommand(migrateCmd)
+}
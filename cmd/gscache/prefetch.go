@@ -0,0 +1,182 @@
+package main
+
+import (
+	"bytes"
+	"encoding/base64"
+	"encoding/json"
+	"fmt"
+	"io"
+	"os"
+	"os/exec"
+	"strings"
+
+	"github.com/spf13/cobra"
+	"go.uber.org/zap"
+
+	"github.com/breezewish/gscache/internal/log"
+	"github.com/breezewish/gscache/internal/protocol"
+	"github.com/breezewish/gscache/internal/util"
+)
+
+type prefetchOpts struct {
+	namespace string
+	test      bool
+	from      string
+}
+
+// goListPackage is the part of `go list -json` output used by prefetch.
+type goListPackage struct {
+	ImportPath string
+	BuildID    string
+}
+
+// buildIDActionPrefix returns the actionID prefix in a build ID reported by `go list -export`.
+// The build ID of a package is "<actionID>/<contentID>", where each part is the base64 of the
+// first bytes of the hash, and the compiled package is cached by the full actionID.
+func buildIDActionPrefix(buildID string) []byte {
+	actionPart, _, _ := strings.Cut(buildID, "/")
+	if actionPart == "" {
+		return nil
+	}
+	prefix, err := base64.RawURLEncoding.DecodeString(actionPart)
+	if err != nil {
+		return nil
+	}
+	return prefix
+}
+
+// readGoListPackages decodes a stream of `go list -json` output.
+func readGoListPackages(r io.Reader) ([]goListPackage, error) {
+	pkgs := make([]goListPackage, 0)
+	dec := json.NewDecoder(r)
+	for {
+		var pkg goListPackage
+		if err := dec.Decode(&pkg); err == io.EOF {
+			return pkgs, nil
+		} else if err != nil {
+			return nil, fmt.Errorf("failed to decode go list output: %w", err)
+		}
+		pkgs = append(pkgs, pkg)
+	}
+}
+
+// readActionIDPrefixes decodes a stream of `go list -json` output and collects actionID
+// prefixes of packages having a build ID.
+func readActionIDPrefixes(r io.Reader) (prefixes [][]byte, packages int, err error) {
+	pkgs, err := readGoListPackages(r)
+	if err != nil {
+		return nil, 0, err
+	}
+	seen := make(map[string]struct{})
+	for _, pkg := range pkgs {
+		prefix := buildIDActionPrefix(pkg.BuildID)
+		if prefix == nil {
+			continue
+		}
+		if _, ok := seen[string(prefix)]; ok {
+			continue
+		}
+		seen[string(prefix)] = struct{}{}
+		prefixes = append(prefixes, prefix)
+	}
+	return prefixes, len(pkgs), nil
+}
+
+// goListExport runs `go list -export -json` for the packages and their dependencies.
+func goListExport(patterns []string, test bool) ([]byte, error) {
+	args := []string{"list", "-export", "-deps", "-e", "-json=ImportPath,BuildID"}
+	if test {
+		args = append(args, "-test")
+	}
+	args = append(args, patterns...)
+	var stderr bytes.Buffer
+	cmd := exec.Command("go", args...)
+	cmd.Stderr = &stderr
+	out, err := cmd.Output()
+	if err != nil {
+		return nil, fmt.Errorf("go list failed: %w: %s", err, strings.TrimSpace(stderr.String()))
+	}
+	return out, nil
+}
+
+func runPrefetch(patterns []string, opts prefetchOpts) error {
+	var input io.Reader
+	switch opts.from {
+	case "":
+		out, err := goListExport(patterns, opts.test)
+		if err != nil {
+			return err
+		}
+		input = bytes.NewReader(out)
+	case "-":
+		input = os.Stdin
+	default:
+		f, err := os.Open(opts.from)
+		if err != nil {
+			return err
+		}
+		defer f.Close()
+		input = f
+	}
+	prefixes, packages, err := readActionIDPrefixes(input)
+	if err != nil {
+		return err
+	}
+	if len(prefixes) == 0 {
+		return fmt.Errorf("none of %d packages has a build ID, the output must be from `go list -export -json`", packages)
+	}
+	ns, err := resolveNamespace(opts.namespace)
+	if err != nil {
+		return err
+	}
+
+	if err := ensureDaemonRunning( /* isExplicitStart */ false); err != nil {
+		return fmt.Errorf("failed to start gscache server daemon: %w", err)
+	}
+	bar := startProgress("Prefetching", nil)
+	resp, err := newClient().CallPrefetch(protocol.PrefetchRequest{ActionIDPrefixes: prefixes, Namespace: ns}, bar.Func())
+	bar.Stop()
+	if err != nil {
+		return err
+	}
+	fmt.Printf("%d packages: %d entries matched, %d in archives, %d already local, downloaded %d (%s)\n",
+		packages, resp.Matched, resp.InArchive, resp.AlreadyLocal,
+		resp.Downloaded, util.FormatBytes(uint64(resp.DownloadedBytes)))
+	return nil
+}
+
+func init() {
+	opts := prefetchOpts{}
+
+	prefetchCmd := &cobra.Command{
+		Use:   "prefetch [packages]",
+		Short: "Download cache entries of compiled packages in bulk, e.g. gscache prefetch ./...",
+		Long: "Download cache entries of compiled packages in bulk before a build starts.\n" +
+			"Build IDs reported by `go list -export -json -deps` carry the actionID of each compiled\n" +
+			"package, and entries matching them are downloaded by the daemon concurrently.\n" +
+			"Note that `go list -export` needs export data of all packages itself. To prefetch on a fresh\n" +
+			"runner, save the output on a machine with a warm cache and pass it via --from, e.g.\n" +
+			"  go list -export -deps -json=ImportPath,BuildID ./... > packages.json\n" +
+			"  gscache prefetch --from packages.json",
+		Run: func(cmd *cobra.Command, args []string) {
+			if opts.from != "" && len(args) > 0 {
+				log.Error("Packages cannot be specified with --from")
+				os.Exit(1)
+			}
+			if len(args) == 0 {
+				args = []string{"."}
+			}
+			if err := runPrefetch(args, opts); err != nil {
+				log.Error("Failed to prefetch", zap.Error(err))
+				os.Exit(1)
+			}
+		},
+	}
+	prefetchCmd.Flags().StringVar(&opts.namespace, "namespace", os.Getenv("GSCACHE_NAMESPACE"),
+		"(env: GSCACHE_NAMESPACE)  Namespace of the entries, the same as gscache prog. Use \"auto\" to derive it from the Go version and platform")
+	prefetchCmd.Flags().BoolVar(&opts.test, "test", false, "Also include test packages, like go list -test")
+	prefetchCmd.Flags().StringVar(&opts.from, "from", "",
+		"Read `go list -export -json` output from this file (\"-\" for stdin) instead of running go list")
+
+	rootCmd.AddCommand(prefetchCmd)
+}
@@ -1,17 +1,38 @@
 package main
 
 import (
+	"context"
+	"fmt"
+	"io"
 	"os"
+	"os/exec"
+	"path/filepath"
+	"strconv"
+	"strings"
 
 	"github.com/spf13/cobra"
 	"go.uber.org/zap"
 
+	"github.com/breezewish/gscache/internal/cache"
 	"github.com/breezewish/gscache/internal/cacheprog"
-	"github.com/breezewish/gscache/internal/client"
 	"github.com/breezewish/gscache/internal/log"
+	"github.com/breezewish/gscache/internal/protocol"
+	"github.com/breezewish/gscache/internal/server"
+	"github.com/breezewish/gscache/internal/stats"
+	"github.com/breezewish/gscache/internal/util"
 )
 
+// NamespaceAuto is a special namespace value, which derives the namespace from the
+// Go version, GOOS and GOARCH of the go toolchain.
+const NamespaceAuto = "auto"
+
 func init() {
+	var namespace string
+	var maxConcurrency int
+	var noAutostart bool
+	var summary bool
+	var standalone bool
+
 	progCmd := &cobra.Command{
 		Use:   "prog",
 		Short: "Run as a cacheprog for go/cmd",
@@ -19,19 +40,167 @@ func init() {
 			// Only log errors when it is a cacheprog
 			log.SetupReadableLogging(zap.ErrorLevel)
 
-			ensureDaemonRunning( /* isExplicitStart */ false)
-			if err := cacheprog.New(cacheprog.Opts{
-				CacheHandler: cacheprog.NewHandlerViaServer(client.Config{
-					DaemonPort: getServerConfig().Port,
-				}),
-				In:  os.Stdin,
-				Out: os.Stdout,
-			}).Run(); err != nil {
+			ns, err := resolveNamespace(namespace)
+			if err != nil {
+				log.Error("Invalid namespace", zap.Error(err))
+				os.Exit(1)
+			}
+
+			var handler cacheprog.CacheHandler
+			closeBackend := func() {}
+			if standalone {
+				var backend cache.Backend
+				backend, closeBackend, err = openStandaloneBackend()
+				if err != nil {
+					log.Error("Failed to open cache backend in standalone mode", zap.Error(err))
+					os.Exit(1)
+				}
+				handler = cacheprog.NewHandlerViaBackend(backend)
+			} else if noAutostart {
+				// The daemon lifecycle is managed externally, never fork one.
+				if _, err := newClient().CallPing(); err != nil {
+					log.Error("No gscache daemon is reachable and autostart is disabled, start it via `gscache daemon start` first",
+						zap.Int("port", getServerConfig().Port),
+						zap.Error(err))
+					os.Exit(1)
+				}
+			} else {
+				ensureDaemonRunning( /* isExplicitStart */ false)
+			}
+			if cfg := getServerConfig(); handler == nil && cfg.Host != "" && !util.IsLoopbackHost(cfg.Host) {
+				// Disk paths of a daemon on another machine are not accessible, so bodies are
+				// streamed and materialized in the local work dir
+				remote := cacheprog.NewHandlerViaRemoteServer(newClientConfig(), filepath.Join(cfg.Dir, "remote-bodies"))
+				if cfg.GC.MaxAge > 0 {
+					go func() { _, _ = remote.Trim(cfg.GC.MaxAge) }()
+				}
+				handler = remote
+			}
+			if handler == nil {
+				handler = cacheprog.NewHandlerViaServer(newClientConfig())
+			}
+			var summaryOut io.Writer
+			if summary {
+				summaryOut = os.Stderr
+			}
+			err = cacheprog.New(cacheprog.Opts{
+				CacheHandler:   handler,
+				In:             os.Stdin,
+				Out:            os.Stdout,
+				Namespace:      ns,
+				MaxConcurrency: maxConcurrency,
+				Summary:        summaryOut,
+			}).Run()
+			closeBackend()
+			if err != nil {
 				log.Error("Failed to run cacheprog", zap.Error(err))
 				os.Exit(1)
 			}
 		},
 	}
+	progCmd.Flags().StringVar(&namespace, "namespace", os.Getenv("GSCACHE_NAMESPACE"),
+		"(env: GSCACHE_NAMESPACE)  Put and get cache entries in this namespace, so that entries from different toolchains are isolated. Use \"auto\" to derive it from the Go version and platform")
+
+	progCmd.Flags().IntVar(&maxConcurrency, "max-concurrency", cacheprog.DefaultMaxConcurrency,
+		"Max number of in-flight cache requests. Reading new requests from go/cmd is paused when reached")
+
+	defNoAutostart, _ := strconv.ParseBool(os.Getenv("GSCACHE_NO_AUTOSTART"))
+	progCmd.Flags().BoolVar(&noAutostart, "no-autostart", defNoAutostart,
+		"(env: GSCACHE_NO_AUTOSTART)  Do not start a daemon automatically. Fail if no daemon is reachable")
+
+	defSummary, _ := strconv.ParseBool(os.Getenv("GSCACHE_SUMMARY"))
+	progCmd.Flags().BoolVar(&summary, "summary", defSummary,
+		"(env: GSCACHE_SUMMARY)  Print a one-line summary of what the cache served to stderr when the go command exits")
+
+	defStandalone, _ := strconv.ParseBool(os.Getenv("GSCACHE_STANDALONE"))
+	progCmd.Flags().BoolVar(&standalone, "standalone", defStandalone,
+		"(env: GSCACHE_STANDALONE)  Open the cache backend in this process instead of using a daemon, for environments where background processes are not allowed. The work dir must not be used by a running daemon")
+	progCmd.MarkFlagsMutuallyExclusive("standalone", "no-autostart")
 
 	rootCmd.AddCommand(progCmd)
 }
+
+// openStandaloneBackend opens the configured backend in the current process, so that
+// cacheprog works without a daemon. The returned func closes the backend, waiting for
+// pending uploads, and persists stats.
+func openStandaloneBackend() (cache.Backend, func(), error) {
+	cfg := *getServerConfig()
+	if cfg.ReadOnly {
+		if _, err := os.Stat(cfg.Dir); err != nil {
+			return nil, nil, fmt.Errorf("cache directory is not accessible: %w", err)
+		}
+	} else if err := os.MkdirAll(cfg.Dir, 0755); err != nil {
+		return nil, nil, fmt.Errorf("failed to create cache directory: %w", err)
+	}
+
+	var unlock func()
+	// A read-only work dir is never modified, so it can be shared, like the daemon does.
+	if !cfg.ReadOnly {
+		dirLock, err := server.LockWorkDir(cfg.Dir)
+		if err != nil {
+			return nil, nil, fmt.Errorf("%w (stop the daemon or use a different --dir)", err)
+		}
+		unlock = func() { _ = dirLock.Unlock() }
+	} else {
+		unlock = func() {}
+	}
+	if err := server.MigrateWorkDir(cfg); err != nil {
+		unlock()
+		return nil, nil, err
+	}
+
+	if cfg.StatsFilePath() != "" {
+		_ = os.MkdirAll(filepath.Dir(cfg.StatsFilePath()), 0755)
+		stats.Default.LoadFromFileAndAttach(cfg.StatsFilePath())
+	}
+
+	// The process only lives as long as the go command, which is too short for a compaction
+	// to finish. Run `gscache compact` separately instead.
+	cfg.Blob.SkipCompactionOnOpen = true
+	backend, err := server.NewBackend(cfg)
+	if err != nil {
+		unlock()
+		return nil, nil, fmt.Errorf("failed to create backend: %w", err)
+	}
+	if err := backend.Open(context.Background()); err != nil {
+		unlock()
+		return nil, nil, fmt.Errorf("failed to open backend: %w", err)
+	}
+	return backend, func() {
+		_ = backend.Close()
+		stats.Default.ForcePersist()
+		unlock()
+	}, nil
+}
+
+func resolveNamespace(namespace string) (string, error) {
+	if namespace == NamespaceAuto {
+		ns, err := detectToolchainNamespace()
+		if err != nil {
+			return "", fmt.Errorf("failed to detect toolchain namespace: %w", err)
+		}
+		namespace = ns
+	}
+	if err := protocol.ValidateNamespace(namespace); err != nil {
+		return "", err
+	}
+	return namespace, nil
+}
+
+// detectToolchainNamespace returns a namespace like go1.24.2_linux_amd64.
+func detectToolchainNamespace() (string, error) {
+	goBin := "go"
+	// When invoked as GOCACHEPROG, prefer the toolchain which invokes us.
+	if goroot := os.Getenv("GOROOT"); goroot != "" {
+		goBin = filepath.Join(goroot, "bin", "go")
+	}
+	out, err := exec.Command(goBin, "env", "GOVERSION", "GOOS", "GOARCH").Output()
+	if err != nil {
+		return "", err
+	}
+	fields := strings.Fields(string(out))
+	if len(fields) != 3 {
+		return "", fmt.Errorf("unexpected go env output: %q", string(out))
+	}
+	return strings.Join(fields, "_"), nil
+}
@@ -0,0 +1,137 @@
+package main
+
+import (
+	"fmt"
+	"os"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/breezewish/gscache/internal/client"
+	"github.com/breezewish/gscache/internal/progress"
+	"github.com/breezewish/gscache/internal/util"
+)
+
+const (
+	progressRedrawInterval = 100 * time.Millisecond
+	progressBarWidth       = 30
+)
+
+var progressSpinner = []string{"|", "/", "-", "\\"}
+
+// progressBar shows progress of a long running command on stderr, as a bar when the total is
+// known, otherwise as a spinner. It is only shown when stderr is a terminal, so that logs of
+// CI runs are not flooded with redraws.
+type progressBar struct {
+	label     string
+	tracker   *progress.Tracker // Polled if set, e.g. for operations in the current process
+	startedAt time.Time
+
+	mu sync.Mutex
+	p  progress.Progress
+
+	stop chan struct{}
+	done chan struct{}
+}
+
+func isTerminal(f *os.File) bool {
+	fi, err := f.Stat()
+	return err == nil && fi.Mode()&os.ModeCharDevice != 0
+}
+
+// startProgress starts showing progress reported via Update, or by the tracker if set.
+// Stop must be called when the operation is finished.
+func startProgress(label string, tracker *progress.Tracker) *progressBar {
+	b := &progressBar{label: label, tracker: tracker, startedAt: time.Now()}
+	if !isTerminal(os.Stderr) {
+		return b
+	}
+	b.stop = make(chan struct{})
+	b.done = make(chan struct{})
+	go b.run()
+	return b
+}
+
+// Update sets the progress to show.
+func (b *progressBar) Update(p progress.Progress) {
+	b.mu.Lock()
+	defer b.mu.Unlock()
+	b.p = p
+}
+
+// Func returns Update for client calls, or nil if progress is not shown, so that the daemon
+// does not stream progress in vain.
+func (b *progressBar) Func() client.ProgressFunc {
+	if b.stop == nil {
+		return nil
+	}
+	return b.Update
+}
+
+// Stop stops showing progress and clears the line.
+func (b *progressBar) Stop() {
+	if b.stop == nil {
+		return
+	}
+	close(b.stop)
+	<-b.done
+	b.stop = nil
+}
+
+func (b *progressBar) run() {
+	defer close(b.done)
+	ticker := time.NewTicker(progressRedrawInterval)
+	defer ticker.Stop()
+	for frame := 0; ; frame++ {
+		select {
+		case <-b.stop:
+			if frame > 0 {
+				fmt.Fprint(os.Stderr, "\r\033[K")
+			}
+			return
+		case <-ticker.C:
+		}
+		if b.tracker != nil {
+			p, _ := b.tracker.Snapshot()
+			b.Update(p)
+		}
+		b.mu.Lock()
+		line := b.render(b.p, frame)
+		b.mu.Unlock()
+		fmt.Fprint(os.Stderr, "\r"+line+"\033[K")
+	}
+}
+
+func (b *progressBar) render(p progress.Progress, frame int) string {
+	var sb strings.Builder
+	sb.WriteString(b.label)
+	if p.Stage != "" {
+		sb.WriteString(" " + p.Stage)
+	}
+	ratio := -1.0
+	switch {
+	case p.Total > 0:
+		ratio = float64(p.Done) / float64(p.Total)
+	case p.TotalBytes > 0:
+		ratio = float64(p.Bytes) / float64(p.TotalBytes)
+	}
+	if ratio >= 0 {
+		filled := int(min(ratio, 1) * progressBarWidth)
+		fmt.Fprintf(&sb, " [%s%s] %3.0f%%", strings.Repeat("=", filled),
+			strings.Repeat(" ", progressBarWidth-filled), min(ratio, 1)*100)
+	} else {
+		sb.WriteString(" " + progressSpinner[frame%len(progressSpinner)])
+	}
+	if p.Total > 0 {
+		fmt.Fprintf(&sb, "  %d/%d", p.Done, p.Total)
+	} else if p.Done > 0 {
+		fmt.Fprintf(&sb, "  %d", p.Done)
+	}
+	if p.TotalBytes > 0 {
+		fmt.Fprintf(&sb, "  %s/%s", util.FormatBytes(uint64(p.Bytes)), util.FormatBytes(uint64(p.TotalBytes)))
+	} else if p.Bytes > 0 {
+		fmt.Fprintf(&sb, "  %s", util.FormatBytes(uint64(p.Bytes)))
+	}
+	fmt.Fprintf(&sb, "  %s", time.Since(b.startedAt).Round(time.Second))
+	return sb.String()
+}
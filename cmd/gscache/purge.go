@@ -0,0 +1,121 @@
+package main
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"os"
+	"strings"
+	"syscall"
+
+	"github.com/spf13/cobra"
+	"go.uber.org/zap"
+
+	"github.com/breezewish/gscache/internal/cache"
+	"github.com/breezewish/gscache/internal/cache/backends/blob"
+	"github.com/breezewish/gscache/internal/log"
+	"github.com/breezewish/gscache/internal/protocol"
+	"github.com/breezewish/gscache/internal/server"
+	"github.com/breezewish/gscache/internal/util"
+)
+
+type purgeOpts struct {
+	actionID  string
+	prefix    string
+	namespace string
+}
+
+// runPurge purges the entry via the daemon if it is running, otherwise in the current process.
+func runPurge(req protocol.DeleteRequest) (*protocol.DeleteResponse, error) {
+	resp, err := newClient().CallDelete(req)
+	if err == nil {
+		return resp, nil
+	}
+	if !errors.Is(err, syscall.ECONNREFUSED) {
+		return nil, err
+	}
+
+	log.Info("Server daemon is not running, purge in the current process")
+	cfg := getServerConfig()
+	if cfg.ReadOnly {
+		return nil, fmt.Errorf("purge is not available in read_only mode")
+	}
+	if err := os.MkdirAll(cfg.Dir, 0755); err != nil {
+		return nil, fmt.Errorf("failed to create work dir: %w", err)
+	}
+	dirLock, err := server.LockWorkDir(cfg.Dir)
+	if err != nil {
+		return nil, err
+	}
+	defer dirLock.Unlock()
+	if err := server.MigrateWorkDir(*cfg); err != nil {
+		return nil, err
+	}
+
+	// Only the local copy of the archive is needed to tell whether the entry is in it
+	cfg.Blob.SkipCompactionOnOpen = true
+	cfg.Blob.SkipInitialArchiveSync = true
+	backend, err := server.NewBackend(*cfg)
+	if err != nil {
+		return nil, err
+	}
+	if err := backend.Open(context.Background()); err != nil {
+		return nil, err
+	}
+	defer backend.Close()
+	deleter, ok := backend.(cache.BackendSupportDelete)
+	if !ok {
+		return nil, fmt.Errorf("backend %s does not support delete", server.BackendName(*cfg))
+	}
+	return deleter.Delete(context.Background(), req)
+}
+
+func init() {
+	opts := purgeOpts{}
+
+	purgeCmd := &cobra.Command{
+		Use:   "purge",
+		Short: "Purge an entry, or all entries of an actionID prefix, from the local store, the remote bucket and archives",
+		Long: "Purge an entry from the local store and the remote bucket, via the running daemon or in the\n" +
+			"current process. The entry is no longer served from the archive of its keyspace, and the next\n" +
+			"compaction of the keyspace rebuilds the archive without it. With --prefix, all entries whose\n" +
+			"hex actionID starts with the prefix (e.g. a keyspace like \"a\") are purged.",
+		Run: func(cmd *cobra.Command, args []string) {
+			req := protocol.DeleteRequest{
+				Prefix:    strings.ToLower(opts.prefix),
+				Namespace: opts.namespace,
+				Purge:     true,
+			}
+			if opts.actionID != "" {
+				actionID, err := parseHexID("actionID", opts.actionID)
+				if err != nil {
+					log.Error("Failed to purge entry", zap.Error(err))
+					os.Exit(1)
+				}
+				req.ActionID = actionID
+			}
+			resp, err := runPurge(req)
+			if err != nil {
+				log.Error("Failed to purge entry", zap.Error(err))
+				os.Exit(1)
+			}
+			util.PrettyPrintJSON(resp)
+			if resp.PurgeScheduled {
+				keyspace := req.Prefix
+				if len(req.ActionID) > 0 {
+					keyspace = blob.CacheEntityKeyspace(req.ActionID)
+				}
+				log.Info("Entries will be removed from the archive at the next compaction, run `gscache compact` to apply it now",
+					zap.String("keyspace", keyspace[:1]))
+			}
+		},
+	}
+	purgeCmd.Flags().StringVar(&opts.actionID, "action-id", "", "Hex actionID of the entry")
+	purgeCmd.Flags().StringVar(&opts.prefix, "prefix", "", "Hex prefix of actionIDs of entries to purge")
+	purgeCmd.Flags().StringVar(&opts.namespace, "namespace", "",
+		"Namespace of the entry. Empty means the default namespace. Only the default namespace is archived")
+	purgeCmd.MarkFlagsOneRequired("action-id", "prefix")
+	purgeCmd.MarkFlagsMutuallyExclusive("action-id", "prefix")
+
+	rootCmd.AddCommand(purgeCmd)
+}
@@ -0,0 +1,118 @@
+package main
+
+import (
+	"context"
+	"fmt"
+	"os"
+	"os/signal"
+	"syscall"
+	"text/tabwriter"
+	"time"
+
+	"github.com/spf13/cobra"
+	"go.uber.org/zap"
+	gocloudblob "gocloud.dev/blob"
+
+	"github.com/breezewish/gscache/internal/cache/backends/blob"
+	"github.com/breezewish/gscache/internal/log"
+)
+
+var remoteCmd = &cobra.Command{
+	Use:   "remote",
+	Short: "Inspect the remote blob store",
+}
+
+// probeErrorFix returns how to fix a failed probe operation.
+func probeErrorFix(err error) string {
+	switch blob.ClassifyProbeError(err) {
+	case blob.ProbeErrorNetwork:
+		return "the bucket is not reachable in time, check network access, DNS and proxy settings (HTTPS_PROXY)"
+	case blob.ProbeErrorCredentials:
+		return "check credentials (e.g. AWS_ACCESS_KEY_ID or GOOGLE_APPLICATION_CREDENTIALS) and that they allow read, write, list and delete on the bucket"
+	case blob.ProbeErrorBucket:
+		return "the bucket does not exist, check the bucket name and region in blob.url"
+	}
+	return "check blob.url and the error above"
+}
+
+// runRemoteCheck probes the remote blob store repeatedly and prints per-operation latency.
+// Returns false if any operation failed.
+func runRemoteCheck(url string, repeat int) (bool, error) {
+	if url == "" {
+		return false, fmt.Errorf("blob.url is not set, only the local cache is used")
+	}
+	if repeat <= 0 {
+		return false, fmt.Errorf("--repeat must be positive")
+	}
+	ctx, stop := signal.NotifyContext(context.Background(), syscall.SIGINT, syscall.SIGTERM)
+	defer stop()
+
+	t := time.Now()
+	bucket, err := gocloudblob.OpenBucket(ctx, url)
+	if err != nil {
+		return false, fmt.Errorf("failed to open %s, check the URL format, e.g. s3://bucket?region=us-east-1 or gs://bucket: %w", url, err)
+	}
+	defer bucket.Close()
+	fmt.Printf("Probing %s (opened in %s)\n\n", url, time.Since(t).Round(time.Millisecond))
+
+	w := tabwriter.NewWriter(os.Stdout, 0, 0, 2, ' ', 0)
+	fmt.Fprintln(w, "RUN\tOP\tLATENCY\tRESULT")
+	var failed *blob.ProbeOpResult
+	var report *blob.ProbeReport
+	for i := 1; i <= repeat && ctx.Err() == nil; i++ {
+		report = blob.ProbeRemote(ctx, bucket)
+		for _, op := range report.Ops {
+			result := "ok"
+			if op.Err != nil {
+				result = fmt.Sprintf("%s error: %v", blob.ClassifyProbeError(op.Err), op.Err)
+			}
+			fmt.Fprintf(w, "%d\t%s\t%s\t%s\n", i, op.Op, op.Latency.Round(time.Millisecond), result)
+		}
+		if f := report.Failed(); f != nil {
+			failed = f
+			break
+		}
+	}
+	_ = w.Flush()
+	fmt.Println()
+
+	if failed != nil {
+		fmt.Printf("%s failed: %s\n", failed.Op, probeErrorFix(failed.Err))
+		return false, nil
+	}
+	if report != nil && report.ClockSkewKnown {
+		fmt.Printf("Local clock differs from the blob store by %s\n", report.ClockSkew.Round(time.Second))
+	}
+	fmt.Println("All operations succeeded")
+	return true, nil
+}
+
+func init() {
+	var url string
+	var repeat int
+
+	remoteCheckCmd := &cobra.Command{
+		Use:   "check",
+		Short: "Write, read, list and delete a probe object in the blob store, and report latency and errors of each operation",
+		Run: func(cmd *cobra.Command, args []string) {
+			if url == "" {
+				url = getServerConfig().Blob.URL
+			}
+			ok, err := runRemoteCheck(url, repeat)
+			if err != nil {
+				log.Error("Remote check failed", zap.Error(err))
+				os.Exit(1)
+			}
+			if !ok {
+				os.Exit(1)
+			}
+		},
+	}
+	remoteCheckCmd.Flags().StringVar(&url, "url", "",
+		"Probe this blob URL instead of blob.url in the config")
+	remoteCheckCmd.Flags().IntVar(&repeat, "repeat", 1,
+		"Run the probe this many times, to see latency variation")
+
+	remoteCmd.AddCommand(remoteCheckCmd)
+	rootCmd.AddCommand(remoteCmd)
+}
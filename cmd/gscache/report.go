@@ -0,0 +1,353 @@
+package main
+
+import (
+	"cmp"
+	"fmt"
+	"html/template"
+	"io"
+	"os"
+	"strings"
+	"text/tabwriter"
+	"time"
+
+	"github.com/spf13/cobra"
+	"go.uber.org/zap"
+
+	"github.com/breezewish/gscache/internal/cache/backends/blob"
+	"github.com/breezewish/gscache/internal/log"
+	"github.com/breezewish/gscache/internal/stats"
+	"github.com/breezewish/gscache/internal/util"
+)
+
+type reportOpts struct {
+	statsFile         string
+	rebuildCostPerHit time.Duration
+	topKeyspaces      int
+	topMisses         int
+	packages          string
+	format            string
+	output            string
+}
+
+var reportFormats = []string{"text", "markdown", "html"}
+
+// reportPoint is the effectiveness between two stats snapshots.
+type reportPoint struct {
+	At time.Time
+	stats.Effectiveness
+}
+
+type reportMiss struct {
+	stats.MissCount
+	Package string // Import path of the package compiled by the action, if known.
+}
+
+type reportData struct {
+	GeneratedAt  time.Time
+	StatsFile    string
+	RebuildCost  time.Duration
+	Total        stats.Effectiveness
+	Trend        []reportPoint
+	TopKeyspaces []string
+	TopMisses    []reportMiss
+}
+
+// loadTrend computes the effectiveness between consecutive stats snapshots, and between the
+// last snapshot and the current stats.
+func loadTrend(statsFile string, cur stats.Effectiveness, rebuildCost time.Duration) ([]reportPoint, error) {
+	paths, err := stats.ListSnapshots(stats.HistoryDir(statsFile))
+	if err != nil || len(paths) == 0 {
+		return nil, err
+	}
+	trend := make([]reportPoint, 0, len(paths))
+	var prev *stats.Effectiveness
+	for _, path := range paths {
+		m, at, err := stats.LoadSnapshot(path)
+		if err != nil {
+			log.Warn("Skip unreadable stats snapshot", zap.String("path", path), zap.Error(err))
+			continue
+		}
+		e := m.Effectiveness(rebuildCost)
+		if prev != nil {
+			trend = append(trend, reportPoint{At: at, Effectiveness: e.Since(*prev, rebuildCost)})
+		}
+		prev = &e
+	}
+	if prev != nil {
+		at := time.Now()
+		if fi, err := os.Stat(statsFile); err == nil {
+			at = fi.ModTime()
+		}
+		if d := cur.Since(*prev, rebuildCost); d.Gets > 0 {
+			trend = append(trend, reportPoint{At: at, Effectiveness: d})
+		}
+	}
+	return trend, nil
+}
+
+// loadPackageNames maps actionID prefixes in `go list -export -json` output to import paths.
+func loadPackageNames(path string) (map[string]string, error) {
+	f, err := os.Open(path)
+	if err != nil {
+		return nil, err
+	}
+	defer f.Close()
+	pkgs, err := readGoListPackages(f)
+	if err != nil {
+		return nil, err
+	}
+	names := make(map[string]string, len(pkgs))
+	for _, pkg := range pkgs {
+		if prefix := buildIDActionPrefix(pkg.BuildID); prefix != nil {
+			names[fmt.Sprintf("%x", prefix)] = pkg.ImportPath
+		}
+	}
+	return names, nil
+}
+
+func buildReport(opts reportOpts) (*reportData, error) {
+	statsFile := opts.statsFile
+	if statsFile == "" {
+		statsFile = getServerConfig().StatsFilePath()
+	} else if _, err := os.Stat(statsFile); err != nil {
+		// Explicitly specified stats file must exist
+		return nil, fmt.Errorf("failed to read statistics file: %w", err)
+	}
+	if err := stats.Default.LoadFromFile(statsFile); err != nil {
+		return nil, fmt.Errorf("failed to load statistics file %s: %w", statsFile, err)
+	}
+
+	d := &reportData{
+		GeneratedAt: time.Now(),
+		StatsFile:   statsFile,
+		RebuildCost: opts.rebuildCostPerHit,
+		Total:       stats.Default.Effectiveness(opts.rebuildCostPerHit),
+	}
+	var err error
+	if d.Trend, err = loadTrend(statsFile, d.Total, opts.rebuildCostPerHit); err != nil {
+		return nil, fmt.Errorf("failed to load stats history: %w", err)
+	}
+
+	if opts.topKeyspaces > 0 {
+		scores, ranked := blob.LoadAffinityScores(getServerConfig().Dir)
+		for _, keyspace := range ranked[:min(opts.topKeyspaces, len(ranked))] {
+			if scores[keyspace] == 0 {
+				break
+			}
+			d.TopKeyspaces = append(d.TopKeyspaces, fmt.Sprintf("%s (%d)", keyspace, scores[keyspace]))
+		}
+	}
+
+	if opts.topMisses > 0 {
+		var names map[string]string
+		if opts.packages != "" {
+			if names, err = loadPackageNames(opts.packages); err != nil {
+				return nil, fmt.Errorf("failed to load packages: %w", err)
+			}
+		}
+		for _, mc := range stats.Default.Misses.Top(opts.topMisses) {
+			miss := reportMiss{MissCount: mc}
+			for prefix, name := range names {
+				if strings.HasPrefix(mc.ActionID, prefix) {
+					miss.Package = name
+					break
+				}
+			}
+			d.TopMisses = append(d.TopMisses, miss)
+		}
+	}
+	return d, nil
+}
+
+func percent(ratio float64) string {
+	return fmt.Sprintf("%.1f%%", ratio*100)
+}
+
+func renderReportText(w io.Writer, d *reportData) error {
+	e := d.Total
+	fmt.Fprintf(w, "Gets:            %d, %.1f%% hit\n", e.Gets, e.HitRatio*100)
+	fmt.Fprintf(w, "Downloaded:      %s\n", util.FormatBytes(e.DownloadedBytes))
+	fmt.Fprintf(w, "Served locally:  %s (%.1f%% of served bytes avoided the network)\n",
+		util.FormatBytes(e.ServedLocallyBytes), e.ServedLocallyRatio()*100)
+	fmt.Fprintf(w, "Compiled:        %s (put by builds after misses)\n", util.FormatBytes(e.PutBytes))
+	fmt.Fprintf(w, "Uploaded:        %s\n", util.FormatBytes(e.UploadedBytes))
+	fmt.Fprintf(w, "Time saved:      ~%s (assuming %s of rebuild per hit)\n",
+		e.EstimatedTimeSaved.Round(time.Second), d.RebuildCost)
+	if len(d.TopKeyspaces) > 0 {
+		// Access counts are persisted when the daemon stops, and older sessions are decayed.
+		fmt.Fprintf(w, "Top keyspaces:   %s\n", strings.Join(d.TopKeyspaces, ", "))
+	}
+
+	if len(d.Trend) > 0 {
+		fmt.Fprintf(w, "\nTrend:\n\n")
+		tw := tabwriter.NewWriter(w, 0, 0, 2, ' ', 0)
+		fmt.Fprintln(tw, "UNTIL\tGETS\tHIT\tDOWNLOADED\tCOMPILED")
+		for _, p := range d.Trend {
+			fmt.Fprintf(tw, "%s\t%d\t%s\t%s\t%s\n", p.At.Local().Format(time.DateTime), p.Gets,
+				percent(p.HitRatio), util.FormatBytes(p.DownloadedBytes), util.FormatBytes(p.PutBytes))
+		}
+		if err := tw.Flush(); err != nil {
+			return err
+		}
+	}
+	if len(d.TopMisses) > 0 {
+		fmt.Fprintf(w, "\nMost missed:\n\n")
+		tw := tabwriter.NewWriter(w, 0, 0, 2, ' ', 0)
+		fmt.Fprintln(tw, "ACTION ID\tPACKAGE\tMISSES\tCOMPILED")
+		for _, m := range d.TopMisses {
+			fmt.Fprintf(tw, "%s\t%s\t%d\t%s\n", m.ActionID, cmp.Or(m.Package, "-"), m.Misses, util.FormatBytes(m.PutBytes))
+		}
+		return tw.Flush()
+	}
+	return nil
+}
+
+func renderReportMarkdown(w io.Writer, d *reportData) error {
+	e := d.Total
+	fmt.Fprintf(w, "# gscache report\n\n")
+	fmt.Fprintf(w, "Generated at %s from `%s`.\n\n", d.GeneratedAt.Format(time.RFC3339), d.StatsFile)
+	fmt.Fprintf(w, "| Metric | Value |\n|---|---|\n")
+	fmt.Fprintf(w, "| Gets | %d |\n", e.Gets)
+	fmt.Fprintf(w, "| Hit ratio | %s |\n", percent(e.HitRatio))
+	fmt.Fprintf(w, "| Downloaded | %s |\n", util.FormatBytes(e.DownloadedBytes))
+	fmt.Fprintf(w, "| Served locally | %s (%s) |\n", util.FormatBytes(e.ServedLocallyBytes), percent(e.ServedLocallyRatio()))
+	fmt.Fprintf(w, "| Compiled | %s |\n", util.FormatBytes(e.PutBytes))
+	fmt.Fprintf(w, "| Uploaded | %s |\n", util.FormatBytes(e.UploadedBytes))
+	fmt.Fprintf(w, "| Time saved | ~%s (%s per hit) |\n", e.EstimatedTimeSaved.Round(time.Second), d.RebuildCost)
+	if len(d.TopKeyspaces) > 0 {
+		fmt.Fprintf(w, "| Top keyspaces | %s |\n", strings.Join(d.TopKeyspaces, ", "))
+	}
+	if len(d.Trend) > 0 {
+		fmt.Fprintf(w, "\n## Trend\n\n| Until | Gets | Hit | Downloaded | Compiled |\n|---|---:|---:|---:|---:|\n")
+		for _, p := range d.Trend {
+			fmt.Fprintf(w, "| %s | %d | %s | %s | %s |\n", p.At.Local().Format(time.DateTime), p.Gets,
+				percent(p.HitRatio), util.FormatBytes(p.DownloadedBytes), util.FormatBytes(p.PutBytes))
+		}
+	}
+	if len(d.TopMisses) > 0 {
+		fmt.Fprintf(w, "\n## Most missed\n\n| Action ID | Package | Misses | Compiled |\n|---|---|---:|---:|\n")
+		for _, m := range d.TopMisses {
+			fmt.Fprintf(w, "| `%s` | %s | %d | %s |\n", m.ActionID, cmp.Or(m.Package, "-"), m.Misses, util.FormatBytes(m.PutBytes))
+		}
+	}
+	return nil
+}
+
+var reportHTMLTemplate = template.Must(template.New("report").Funcs(template.FuncMap{
+	"bytes":   util.FormatBytes,
+	"percent": percent,
+	"time":    func(t time.Time) string { return t.Local().Format(time.DateTime) },
+	"seconds": func(d time.Duration) time.Duration { return d.Round(time.Second) },
+	"or":      cmp.Or[string],
+}).Parse(`<!DOCTYPE html>
+<html>
+<head>
+<meta charset="utf-8">
+<title>gscache report</title>
+<style>
+body { font-family: sans-serif; margin: 2em; }
+table { border-collapse: collapse; margin-bottom: 2em; }
+th, td { border: 1px solid #ddd; padding: 4px 8px; text-align: left; }
+.bar { background: #4caf50; height: 10px; }
+code { font-size: 90%; }
+</style>
+</head>
+<body>
+<h1>gscache report</h1>
+<p>Generated at {{time .GeneratedAt}} from <code>{{.StatsFile}}</code>.</p>
+<table>
+<tr><th>Gets</th><td>{{.Total.Gets}}</td></tr>
+<tr><th>Hit ratio</th><td>{{percent .Total.HitRatio}}</td></tr>
+<tr><th>Downloaded</th><td>{{bytes .Total.DownloadedBytes}}</td></tr>
+<tr><th>Served locally</th><td>{{bytes .Total.ServedLocallyBytes}} ({{percent .Total.ServedLocallyRatio}})</td></tr>
+<tr><th>Compiled</th><td>{{bytes .Total.PutBytes}}</td></tr>
+<tr><th>Uploaded</th><td>{{bytes .Total.UploadedBytes}}</td></tr>
+<tr><th>Time saved</th><td>~{{seconds .Total.EstimatedTimeSaved}} ({{.RebuildCost}} per hit)</td></tr>
+{{- if .TopKeyspaces}}
+<tr><th>Top keyspaces</th><td>{{range $i, $k := .TopKeyspaces}}{{if $i}}, {{end}}{{$k}}{{end}}</td></tr>
+{{- end}}
+</table>
+{{- if .Trend}}
+<h2>Trend</h2>
+<table>
+<tr><th>Until</th><th>Gets</th><th>Hit</th><th></th><th>Downloaded</th><th>Compiled</th></tr>
+{{- range .Trend}}
+<tr><td>{{time .At}}</td><td>{{.Gets}}</td><td>{{percent .HitRatio}}</td><td style="width: 100px"><div class="bar" style="width: {{percent .HitRatio}}"></div></td><td>{{bytes .DownloadedBytes}}</td><td>{{bytes .PutBytes}}</td></tr>
+{{- end}}
+</table>
+{{- end}}
+{{- if .TopMisses}}
+<h2>Most missed</h2>
+<table>
+<tr><th>Action ID</th><th>Package</th><th>Misses</th><th>Compiled</th></tr>
+{{- range .TopMisses}}
+<tr><td><code>{{.ActionID}}</code></td><td>{{or .Package "-"}}</td><td>{{.Misses}}</td><td>{{bytes .PutBytes}}</td></tr>
+{{- end}}
+</table>
+{{- end}}
+</body>
+</html>
+`))
+
+func runReport(opts reportOpts) error {
+	var render func(io.Writer, *reportData) error
+	switch opts.format {
+	case "text":
+		render = renderReportText
+	case "markdown":
+		render = renderReportMarkdown
+	case "html":
+		render = func(w io.Writer, d *reportData) error { return reportHTMLTemplate.Execute(w, d) }
+	default:
+		return fmt.Errorf("invalid --format %q, must be one of %s", opts.format, strings.Join(reportFormats, ", "))
+	}
+	d, err := buildReport(opts)
+	if err != nil {
+		return err
+	}
+	if opts.output == "" {
+		return render(os.Stdout, d)
+	}
+	f, err := os.Create(opts.output)
+	if err != nil {
+		return err
+	}
+	if err := render(f, d); err != nil {
+		_ = f.Close()
+		return err
+	}
+	return f.Close()
+}
+
+func init() {
+	opts := reportOpts{}
+
+	reportCmd := &cobra.Command{
+		Use:   "report",
+		Short: "Show a summary of cache effectiveness, with trends and most missed entries, as text, markdown or HTML",
+		Long: "Show a summary of cache effectiveness. Trends are computed from stats snapshots, which are\n" +
+			"taken when [stats_history] interval is set. Misses are counted by actionID, which can be mapped\n" +
+			"to packages via --packages.",
+		Run: func(cmd *cobra.Command, args []string) {
+			if err := runReport(opts); err != nil {
+				log.Error("Failed to generate report", zap.Error(err))
+				os.Exit(1)
+			}
+		},
+	}
+	reportCmd.Flags().StringVar(&opts.statsFile, "stats-file", "",
+		"Use this stats JSON file directly (e.g. copied from another machine), instead of the one in the server working directory")
+	reportCmd.Flags().DurationVar(&opts.rebuildCostPerHit, "rebuild-cost", 1*time.Second,
+		"Average time to rebuild an entry, used to estimate the time saved by hits")
+	reportCmd.Flags().IntVar(&opts.topKeyspaces, "top-keyspaces", 5,
+		"Number of most accessed archive keyspaces to show, 0 to disable")
+	reportCmd.Flags().IntVar(&opts.topMisses, "top-misses", 10,
+		"Number of most missed entries to show, 0 to disable")
+	reportCmd.Flags().StringVar(&opts.packages, "packages", "",
+		"Output of `go list -export -deps -json` of the build, to show packages of missed entries")
+	reportCmd.Flags().StringVar(&opts.format, "format", "text",
+		fmt.Sprintf("Output format, one of: %s", strings.Join(reportFormats, ", ")))
+	reportCmd.Flags().StringVarP(&opts.output, "output", "o", "", "Write the report to this file instead of stdout")
+
+	rootCmd.AddCommand(reportCmd)
+}
@@ -0,0 +1,114 @@
+package main
+
+import (
+	"bytes"
+	"encoding/json"
+	"os"
+	"path/filepath"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/require"
+
+	"github.com/breezewish/gscache/internal/server"
+	"github.com/breezewish/gscache/internal/stats"
+)
+
+func newTestReport(t *testing.T) *reportData {
+	dir := t.TempDir()
+	cfg := server.DefaultConfig()
+	cfg.Dir = dir
+	serverConfig = &cfg
+	t.Cleanup(func() {
+		serverConfig = nil
+		stats.Default.Clear()
+	})
+
+	m := stats.NewMetrics()
+	m.GetTotal.Store(2)
+	m.GetHit.Store(1)
+	require.NoError(t, m.SaveSnapshot(stats.HistoryDir(cfg.StatsFilePath()), 10, time.Now().Add(-time.Hour)))
+
+	m.GetTotal.Store(10)
+	m.GetHit.Store(7)
+	m.BlobOrganic.GetByDownloadBytes.Store(1024)
+	m.PutBytes.Store(2048)
+	m.Misses.Record([]byte{0x01, 0x02, 0x03})
+	m.Misses.Record([]byte{0x01, 0x02, 0x03})
+	m.Misses.RecordPut([]byte{0x01, 0x02, 0x03}, 2048)
+	m.Misses.Record([]byte{0x04})
+	data, err := json.Marshal(m)
+	require.NoError(t, err)
+	require.NoError(t, os.WriteFile(cfg.StatsFilePath(), data, 0644))
+
+	// BuildID "AQI" is actionID prefix 0102
+	packagesPath := filepath.Join(dir, "packages.json")
+	require.NoError(t, os.WriteFile(packagesPath, []byte(`{"ImportPath":"example.com/foo","BuildID":"AQI/xyz"}`), 0644))
+
+	d, err := buildReport(reportOpts{
+		rebuildCostPerHit: time.Second,
+		topKeyspaces:      5,
+		topMisses:         10,
+		packages:          packagesPath,
+	})
+	require.NoError(t, err)
+	return d
+}
+
+func TestBuildReport(t *testing.T) {
+	d := newTestReport(t)
+	require.Equal(t, uint32(10), d.Total.Gets)
+	require.Equal(t, 0.7, d.Total.HitRatio)
+	require.Equal(t, 7*time.Second, d.Total.EstimatedTimeSaved)
+	require.Len(t, d.Trend, 1)
+	require.Equal(t, uint32(8), d.Trend[0].Gets)
+	require.Equal(t, uint32(6), d.Trend[0].Hits)
+	require.Empty(t, d.TopKeyspaces)
+	require.Equal(t, []reportMiss{
+		{MissCount: stats.MissCount{ActionID: "010203", Misses: 2, PutBytes: 2048}, Package: "example.com/foo"},
+		{MissCount: stats.MissCount{ActionID: "04", Misses: 1}},
+	}, d.TopMisses)
+
+	_, err := buildReport(reportOpts{statsFile: filepath.Join(t.TempDir(), "missing.json")})
+	require.ErrorContains(t, err, "failed to read statistics file")
+}
+
+func TestRenderReport(t *testing.T) {
+	d := newTestReport(t)
+
+	var buf bytes.Buffer
+	require.NoError(t, renderReportText(&buf, d))
+	text := buf.String()
+	require.Contains(t, text, "Gets:            10, 70.0% hit")
+	require.Contains(t, text, "Trend:")
+	require.Contains(t, text, "Most missed:")
+	require.Regexp(t, `010203\s+example.com/foo\s+2\s+2\.0KB`, text)
+	require.Regexp(t, `04\s+-\s+1`, text)
+
+	buf.Reset()
+	require.NoError(t, renderReportMarkdown(&buf, d))
+	md := buf.String()
+	require.Contains(t, md, "| Hit ratio | 70.0% |")
+	require.Contains(t, md, "## Trend")
+	require.Contains(t, md, "| `010203` | example.com/foo | 2 | 2.0KB |")
+	require.Contains(t, md, "| `04` | - | 1 |")
+
+	buf.Reset()
+	require.NoError(t, reportHTMLTemplate.Execute(&buf, d))
+	html := buf.String()
+	require.Contains(t, html, "<tr><th>Hit ratio</th><td>70.0%</td></tr>")
+	require.Contains(t, html, "<h2>Trend</h2>")
+	require.Contains(t, html, "<tr><td><code>010203</code></td><td>example.com/foo</td><td>2</td><td>2.0KB</td></tr>")
+
+	// Sections without data are omitted
+	d.Trend, d.TopMisses = nil, nil
+	buf.Reset()
+	require.NoError(t, renderReportText(&buf, d))
+	require.NotContains(t, buf.String(), "Most missed")
+	buf.Reset()
+	require.NoError(t, renderReportMarkdown(&buf, d))
+	require.NotContains(t, buf.String(), "## Trend")
+	buf.Reset()
+	require.NoError(t, reportHTMLTemplate.Execute(&buf, d))
+	require.NotContains(t, buf.String(), "<h2>")
+}
@@ -0,0 +1,169 @@
+package main
+
+import (
+	"bufio"
+	"fmt"
+	"io/fs"
+	"os"
+	"path/filepath"
+	"strings"
+	"time"
+
+	"github.com/spf13/cobra"
+	"go.uber.org/zap"
+
+	"github.com/breezewish/gscache/internal/cache/backends/blob"
+	"github.com/breezewish/gscache/internal/log"
+	"github.com/breezewish/gscache/internal/server"
+)
+
+type resetOpts struct {
+	keepRemote bool
+	statsOnly  bool
+	yes        bool
+}
+
+type resetTarget struct {
+	name  string
+	paths []string
+}
+
+// resetTargets returns local state to be removed by reset. Remote bucket is never touched.
+// State recording remote operations, i.e. pending purges and the egress budget, is always kept,
+// as removing it would serve purged entries again or exceed the budget.
+func resetTargets(cfg *server.Config, opts resetOpts) []resetTarget {
+	targets := []resetTarget{
+		{"stats", []string{cfg.StatsFilePath()}},
+	}
+	if opts.statsOnly {
+		return targets
+	}
+	targets = append(targets, resetTarget{"local cache", []string{filepath.Join(cfg.Dir, "data")}})
+	if !opts.keepRemote {
+		archives := make([]string, 0, len(blob.ArchiveKeyspaces))
+		for _, keyspace := range blob.ArchiveKeyspaces {
+			archives = append(archives, blob.ArchiveFilePath(cfg.Dir, keyspace))
+		}
+		targets = append(targets, resetTarget{"local copies of remote archives", archives})
+	}
+	return targets
+}
+
+// pathSize returns the total size and number of files under the paths.
+func pathSize(paths []string) (int64, int) {
+	var size int64
+	var files int
+	for _, path := range paths {
+		_ = filepath.WalkDir(path, func(_ string, d fs.DirEntry, err error) error {
+			if err != nil || d.IsDir() {
+				return nil
+			}
+			if info, err := d.Info(); err == nil {
+				size += info.Size()
+				files++
+			}
+			return nil
+		})
+	}
+	return size, files
+}
+
+func confirm(prompt string) bool {
+	fmt.Fprintf(os.Stderr, "%s [y/N]: ", prompt)
+	answer, _ := bufio.NewReader(os.Stdin).ReadString('\n')
+	answer = strings.ToLower(strings.TrimSpace(answer))
+	return answer == "y" || answer == "yes"
+}
+
+func runReset(opts resetOpts) error {
+	cfg := getServerConfig()
+	targets := resetTargets(cfg, opts)
+
+	if opts.statsOnly {
+		// Only stats are cleared, which a running daemon can do without stopping
+		client := newClient()
+		alive, err := client.IsDaemonAlive()
+		if err != nil {
+			return fmt.Errorf("failed to check if server is alive: %w", err)
+		}
+		if alive {
+			if _, err := client.CallStatsClear(); err != nil {
+				return fmt.Errorf("failed to clear stats: %w", err)
+			}
+			log.Info("Cleared stats of the running daemon")
+			return nil
+		}
+		return removeResetTargets(targets)
+	}
+
+	if !opts.yes {
+		names := make([]string, 0, len(targets))
+		for _, t := range targets {
+			names = append(names, t.name)
+		}
+		if !confirm(fmt.Sprintf("This will stop the daemon and remove %s in %s. Continue?", strings.Join(names, ", "), cfg.Dir)) {
+			return fmt.Errorf("aborted")
+		}
+	}
+
+	// Files are in use by the daemon, so the daemon is stopped first.
+	// It will be started again on the next use.
+	wasRunning, err := newClient().ShutdownAndWait(30 * time.Second)
+	if err != nil {
+		return fmt.Errorf("failed to stop the daemon: %w", err)
+	}
+	if wasRunning {
+		log.Info("Server daemon stopped")
+	}
+
+	if err := os.MkdirAll(cfg.Dir, 0755); err != nil {
+		return fmt.Errorf("failed to create work dir: %w", err)
+	}
+	dirLock, err := server.LockWorkDir(cfg.Dir)
+	if err != nil {
+		return err
+	}
+	defer dirLock.Unlock()
+	return removeResetTargets(targets)
+}
+
+func removeResetTargets(targets []resetTarget) error {
+	for _, t := range targets {
+		size, files := pathSize(t.paths)
+		for _, path := range t.paths {
+			if err := os.RemoveAll(path); err != nil {
+				return fmt.Errorf("failed to remove %s: %w", t.name, err)
+			}
+		}
+		log.Info("Cleared "+t.name,
+			zap.Strings("paths", t.paths),
+			zap.Int("files", files),
+			zap.Int64("bytes", size))
+	}
+	return nil
+}
+
+func init() {
+	opts := resetOpts{}
+
+	resetCmd := &cobra.Command{
+		Use:   "reset",
+		Short: "Clear statistics and the local cache for a clean slate. The remote cache is not affected",
+		Run: func(cmd *cobra.Command, args []string) {
+			if err := runReset(opts); err != nil {
+				log.Error("Failed to reset", zap.Error(err))
+				os.Exit(1)
+			}
+			log.Info("Reset finished")
+		},
+	}
+	resetCmd.Flags().BoolVar(&opts.keepRemote, "keep-remote", false,
+		"Keep local copies of remote archives (blobar/*.zip in the work dir), so that they don't need to be downloaded again. "+
+			"Entries downloaded into the local cache are still removed")
+	resetCmd.Flags().BoolVar(&opts.statsOnly, "stats-only", false,
+		"Only clear statistics, through the running daemon if any, without stopping it")
+	resetCmd.Flags().BoolVarP(&opts.yes, "yes", "y", false,
+		"Do not ask for confirmation")
+
+	rootCmd.AddCommand(resetCmd)
+}
@@ -0,0 +1,34 @@
+package main
+
+import (
+	"path/filepath"
+	"testing"
+
+	"github.com/stretchr/testify/require"
+
+	"github.com/breezewish/gscache/internal/cache/backends/blob"
+	"github.com/breezewish/gscache/internal/server"
+)
+
+func TestResetTargets(t *testing.T) {
+	cfg := server.DefaultConfig()
+	cfg.Dir = "/work"
+	stats := resetTarget{"stats", []string{cfg.StatsFilePath()}}
+	localCache := resetTarget{"local cache", []string{filepath.Join("/work", "data")}}
+
+	require.Equal(t, []resetTarget{stats}, resetTargets(&cfg, resetOpts{statsOnly: true}))
+	require.Equal(t, []resetTarget{stats, localCache}, resetTargets(&cfg, resetOpts{keepRemote: true}))
+
+	targets := resetTargets(&cfg, resetOpts{})
+	require.Len(t, targets, 3)
+	require.Equal(t, []resetTarget{stats, localCache}, targets[:2])
+	require.Equal(t, "local copies of remote archives", targets[2].name)
+	require.Len(t, targets[2].paths, len(blob.ArchiveKeyspaces))
+	require.Contains(t, targets[2].paths, "/work/blobar/a.zip")
+	// Pending purges and the affinity are kept in the same dir
+	require.NotContains(t, targets[2].paths, blob.PurgeFilePath("/work"))
+	require.NotContains(t, targets[2].paths, filepath.Join("/work", "blobar"))
+
+	cfg.StatsFile = "/stats.json"
+	require.Equal(t, []resetTarget{{"stats", []string{"/stats.json"}}}, resetTargets(&cfg, resetOpts{statsOnly: true}))
+}
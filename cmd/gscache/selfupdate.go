@@ -0,0 +1,111 @@
+package main
+
+import (
+	"context"
+	"fmt"
+	"os"
+	"os/exec"
+	"path/filepath"
+	"runtime"
+	"time"
+
+	"github.com/spf13/cobra"
+	"go.uber.org/zap"
+
+	"github.com/breezewish/gscache/internal/log"
+	"github.com/breezewish/gscache/internal/selfupdate"
+)
+
+type selfUpdateOpts struct {
+	version   string
+	check     bool
+	force     bool
+	noRestart bool
+}
+
+// restartDaemonWith restarts the running daemon, if any, with the executable, so that the
+// daemon also runs the updated version.
+func restartDaemonWith(exePath string) error {
+	client := newClient()
+	if ping, _ := client.CallPing(); ping == nil {
+		return nil
+	}
+	log.Info("Restarting server daemon")
+	if _, err := client.ShutdownAndWait(30 * time.Second); err != nil {
+		return fmt.Errorf("failed to shutdown server: %w", err)
+	}
+	// This process still runs the old executable, so the daemon is started via the new one
+	cmd := exec.Command(exePath, append([]string{"daemon", "start"}, rebuildCliArgs()...)...)
+	cmd.Stdout = os.Stdout
+	cmd.Stderr = os.Stderr
+	return cmd.Run()
+}
+
+func runSelfUpdate(opts selfUpdateOpts) error {
+	ctx := context.Background()
+	u := &selfupdate.Updater{
+		APIURL: selfupdate.DefaultAPIURL,
+		Token:  os.Getenv("GITHUB_TOKEN"),
+	}
+	rel, err := u.FetchRelease(ctx, opts.version)
+	if err != nil {
+		return err
+	}
+	if rel.IsVersion(version) && !opts.force {
+		log.Info("Already up to date", zap.String("version", version))
+		return nil
+	}
+	if opts.check {
+		log.Info("A new version is available, run `gscache selfupdate` to update",
+			zap.String("current", version),
+			zap.String("release", rel.TagName))
+		return nil
+	}
+
+	exePath, err := os.Executable()
+	if err != nil {
+		return fmt.Errorf("failed to get the path of gscache: %w", err)
+	}
+	if exePath, err = filepath.EvalSymlinks(exePath); err != nil {
+		return fmt.Errorf("failed to get the path of gscache: %w", err)
+	}
+	log.Info("Updating gscache",
+		zap.String("current", version),
+		zap.String("release", rel.TagName),
+		zap.String("path", exePath))
+	if err := u.Apply(ctx, rel, runtime.GOOS, runtime.GOARCH, exePath); err != nil {
+		return err
+	}
+	log.Info("Updated gscache", zap.String("version", rel.TagName))
+
+	if opts.noRestart {
+		return nil
+	}
+	return restartDaemonWith(exePath)
+}
+
+func init() {
+	opts := selfUpdateOpts{}
+
+	selfUpdateCmd := &cobra.Command{
+		Use:   "selfupdate",
+		Short: "Update gscache to the latest GitHub release, and restart the daemon if it is running",
+		Long: "Download the binary of the latest (or --version) GitHub release for the current platform,\n" +
+			"verify it against the checksums of the release, and replace this executable atomically.\n" +
+			"The running daemon is restarted to use the new version, unless --no-restart is set.\n" +
+			"Set GITHUB_TOKEN to avoid rate limits of anonymous GitHub API requests.",
+		Args: cobra.NoArgs,
+		Run: func(cmd *cobra.Command, args []string) {
+			if err := runSelfUpdate(opts); err != nil {
+				log.Error("Failed to update gscache", zap.Error(err))
+				os.Exit(1)
+			}
+		},
+	}
+	selfUpdateCmd.Flags().StringVar(&opts.version, "version", "", "Release tag to update to, e.g. v0.3.0. Default to the latest release")
+	selfUpdateCmd.Flags().BoolVar(&opts.check, "check", false, "Only check whether a new version is available")
+	selfUpdateCmd.Flags().BoolVar(&opts.force, "force", false, "Update even if the release is the current version")
+	selfUpdateCmd.Flags().BoolVar(&opts.noRestart, "no-restart", false, "Do not restart the running daemon")
+
+	rootCmd.AddCommand(selfUpdateCmd)
+}
@@ -1,33 +1,67 @@
 package main
 
 import (
+	"context"
 	"fmt"
 	"os"
+	"path/filepath"
+	"time"
 
 	"github.com/breezewish/gscache/internal/log"
 	"github.com/breezewish/gscache/internal/server"
 	"github.com/breezewish/gscache/internal/stats"
+	"github.com/breezewish/gscache/internal/statsd"
+	"github.com/breezewish/gscache/internal/tracing"
 	"github.com/spf13/cobra"
 	"go.uber.org/zap"
 )
 
-func runAsServer() error {
+// runAsServer runs the server in the current process until it is shut down. Logs are written
+// to logOutput ("stdout" or "stderr").
+func runAsServer(logOutput string) error {
 	cfg := getServerConfig()
 
 	// Actually as a daemon we write to stdout / stderr. The stdout and stderr
 	// are pointed to the log file specified in the config when bring up
 	// the daemon.
-	err := log.SetupJSONLogging(cfg.Log)
+	err := log.SetupJSONLoggingTo(cfg.Log, logOutput)
 	if err != nil {
 		return fmt.Errorf("failed to setup logging: %w", err)
 	}
 
-	stats.Default.LoadFromFileAndAttach(stats.FileName(cfg.Dir))
+	if cfg.StatsFilePath() != "" {
+		_ = os.MkdirAll(filepath.Dir(cfg.StatsFilePath()), 0755)
+		stats.Default.LoadFromFileAndAttach(cfg.StatsFilePath())
+	}
+
+	shutdownTracing, err := tracing.Setup(cfg.Otel)
+	if err != nil {
+		return fmt.Errorf("failed to setup tracing: %w", err)
+	}
+	defer func() {
+		ctx, cancel := context.WithTimeout(context.Background(), 5*time.Second)
+		defer cancel()
+		_ = shutdownTracing(ctx)
+	}()
+
+	statsdEmitter, err := statsd.Start(cfg.Statsd, stats.Default)
+	if err != nil {
+		return fmt.Errorf("failed to setup statsd: %w", err)
+	}
+	defer statsdEmitter.Stop()
+
+	if cfg.StatsFilePath() != "" {
+		stopHistory := stats.Default.StartHistory(stats.HistoryDir(cfg.StatsFilePath()), cfg.StatsHistory)
+		defer stopHistory()
+	}
 
 	s, err := server.NewServer(*cfg)
 	if err != nil {
 		return fmt.Errorf("failed to create server: %w", err)
 	}
+	s.SetConfigLoader(func() (server.Config, error) {
+		return server.LoadConfig(configFilePath(), rootCmd.PersistentFlags())
+	})
 	if err := s.Run(); err != nil {
 		return fmt.Errorf("failed to run server: %w", err)
 	}
@@ -40,7 +74,7 @@ func init() {
 		Use:    "server",
 		Short:  "Start the gscache server",
 		Run: func(cmd *cobra.Command, args []string) {
-			if err := runAsServer(); err != nil {
+			if err := runAsServer("stderr"); err != nil {
 				log.Error("Failed to run as server", zap.Error(err))
 				os.Exit(1)
 			}
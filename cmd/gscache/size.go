@@ -0,0 +1,98 @@
+package main
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"os"
+	"syscall"
+	"text/tabwriter"
+
+	"github.com/spf13/cobra"
+	"go.uber.org/zap"
+
+	"github.com/breezewish/gscache/internal/log"
+	"github.com/breezewish/gscache/internal/protocol"
+	"github.com/breezewish/gscache/internal/server"
+	"github.com/breezewish/gscache/internal/util"
+)
+
+type sizeOpts struct {
+	remote bool
+	json   bool
+}
+
+// runSize reports disk usage via the daemon if it is running, otherwise in the current process.
+// Files are only read, so the work dir is not locked.
+func runSize(remote bool) (*protocol.SizeResponse, error) {
+	resp, err := newClient().CallSize(remote)
+	if err == nil {
+		return resp, nil
+	}
+	if !errors.Is(err, syscall.ECONNREFUSED) {
+		return nil, err
+	}
+	return server.ComputeSize(context.Background(), *getServerConfig(), remote)
+}
+
+func printSize(resp *protocol.SizeResponse) error {
+	fmt.Printf("Local: %s\n\n", resp.Dir)
+	w := tabwriter.NewWriter(os.Stdout, 0, 0, 2, ' ', 0)
+	fmt.Fprintln(w, "PATH\tFILES\tSIZE")
+	for _, item := range resp.Local {
+		fmt.Fprintf(w, "%s\t%d\t%s\n", item.Name, item.Files, util.FormatBytes(uint64(item.Bytes)))
+	}
+	fmt.Fprintf(w, "total\t\t%s\n", util.FormatBytes(uint64(resp.LocalBytes)))
+	if err := w.Flush(); err != nil {
+		return err
+	}
+
+	r := resp.Remote
+	if r == nil {
+		return nil
+	}
+	fmt.Printf("\nRemote: %s\n\n", r.URL)
+	w = tabwriter.NewWriter(os.Stdout, 0, 0, 2, ' ', 0)
+	fmt.Fprintln(w, "KEYSPACE\tBLOBS\tBLOB SIZE\tARCHIVE SIZE")
+	for _, ks := range r.Keyspaces {
+		fmt.Fprintf(w, "%s\t%d\t%s\t%s\n", ks.Keyspace, ks.Blobs,
+			util.FormatBytes(uint64(ks.BlobBytes)), util.FormatBytes(uint64(ks.ArchiveBytes)))
+	}
+	for _, ns := range r.Namespaces {
+		fmt.Fprintf(w, "ns/%s\t%d\t%s\t-\n", ns.Name, ns.Files, util.FormatBytes(uint64(ns.Bytes)))
+	}
+	if r.Other.Files > 0 {
+		fmt.Fprintf(w, "other\t%d\t%s\t-\n", r.Other.Files, util.FormatBytes(uint64(r.Other.Bytes)))
+	}
+	fmt.Fprintf(w, "total\t\t%s\n", util.FormatBytes(uint64(r.Bytes)))
+	return w.Flush()
+}
+
+func init() {
+	opts := sizeOpts{}
+
+	sizeCmd := &cobra.Command{
+		Use:   "size",
+		Short: "Report disk usage of the work dir by data subdirs, archives and temp files, and of the bucket by keyspace with --remote",
+		Run: func(cmd *cobra.Command, args []string) {
+			resp, err := runSize(opts.remote)
+			if err != nil {
+				log.Error("Failed to compute disk usage", zap.Error(err))
+				os.Exit(1)
+			}
+			if opts.json {
+				util.PrettyPrintJSON(resp)
+				return
+			}
+			if err := printSize(resp); err != nil {
+				log.Error("Failed to print disk usage", zap.Error(err))
+				os.Exit(1)
+			}
+		},
+	}
+	sizeCmd.Flags().BoolVar(&opts.remote, "remote", false,
+		"Also list the bucket and sum object sizes by keyspace. Only listings are read")
+	sizeCmd.Flags().BoolVar(&opts.json, "json", false, "Print in JSON")
+
+	rootCmd.AddCommand(sizeCmd)
+}
@@ -1,9 +1,17 @@
 package main
 
 import (
+	"context"
+	"fmt"
+	"io"
 	"os"
+	"os/signal"
+	"strings"
+	"time"
 
+	"github.com/breezewish/gscache/internal/client"
 	"github.com/breezewish/gscache/internal/log"
+	"github.com/breezewish/gscache/internal/protocol"
 	"github.com/breezewish/gscache/internal/stats"
 	"github.com/breezewish/gscache/internal/util"
 	"github.com/knadh/koanf/maps"
@@ -12,21 +20,60 @@ import (
 )
 
 func init() {
+	var statsFile string
+	var format string
+
 	statsCmd := &cobra.Command{
 		Use:   "stats",
 		Short: "Show statistics",
 		Run: func(cmd *cobra.Command, args []string) {
-			_ = stats.Default.LoadFromFile(stats.FileName(getServerConfig().Dir))
+			if statsFile != "" {
+				// Explicitly specified stats file must exist
+				if _, err := os.Stat(statsFile); err != nil {
+					log.Error("Failed to read statistics file", zap.Error(err))
+					os.Exit(1)
+				}
+				if err := stats.Default.LoadFromFile(statsFile); err != nil {
+					log.Error("Failed to load statistics file", zap.String("path", statsFile), zap.Error(err))
+					os.Exit(1)
+				}
+			} else {
+				_ = stats.Default.LoadFromFile(getServerConfig().StatsFilePath())
+			}
+			if format != "" {
+				if err := stats.Default.Export(os.Stdout, format); err != nil {
+					log.Error("Failed to export statistics", zap.Error(err))
+					os.Exit(1)
+				}
+				return
+			}
 			jsonMap, _ := util.ObjectToMapViaJSONSerde(stats.Default)
 			imapFlat, _ := maps.Flatten(jsonMap, nil, ".")
 			util.PrettyPrintJSON(imapFlat)
+			if summary := stats.Default.PutSize.CumulativeSummary(); summary != "" {
+				fmt.Printf("Put size distribution (cumulative): %s\n", summary)
+			}
 		},
 	}
+	statsCmd.Flags().StringVar(&format, "format", "",
+		fmt.Sprintf("Print statistics in a machine readable format instead, one of: %s", strings.Join(stats.ExportFormats, ", ")))
+	statsCmd.PersistentFlags().StringVar(&statsFile, "stats-file", "",
+		"Use this stats JSON file directly (e.g. copied from another machine), instead of the one in the server working directory")
 
 	clearCmd := &cobra.Command{
 		Use:   "clear",
 		Short: "Clear statistics",
 		Run: func(cmd *cobra.Command, args []string) {
+			if statsFile != "" {
+				// The file is not attached to any running server, simply remove it
+				if err := removeStatsFile(statsFile); err != nil {
+					log.Error("Failed to clear statistics", zap.Error(err))
+					os.Exit(1)
+				}
+				log.Info("Statistics cleared")
+				return
+			}
+
 			client := newClient()
 			alive, err := client.IsDaemonAlive()
 			if err != nil {
@@ -41,19 +88,131 @@ func init() {
 				}
 			} else {
 				// Server is not running, let's just reset the local stats file
-				statsFileName := stats.FileName(getServerConfig().Dir)
-				if _, err = os.Stat(statsFileName); !os.IsNotExist(err) {
-					err = os.Remove(statsFileName)
-					if err != nil {
-						log.Error("Failed to clear statistics", zap.Error(err))
-						os.Exit(1)
-					}
+				if err := removeStatsFile(getServerConfig().StatsFilePath()); err != nil {
+					log.Error("Failed to clear statistics", zap.Error(err))
+					os.Exit(1)
 				}
 			}
 			log.Info("Statistics cleared")
 		},
 	}
 
+	var watchInterval time.Duration
+	watchCmd := &cobra.Command{
+		Use:   "watch",
+		Short: "Show live statistics of the running daemon, refreshed periodically",
+		Run: func(cmd *cobra.Command, args []string) {
+			if statsFile != "" {
+				log.Error("--stats-file cannot be used with watch, as it streams from the running daemon")
+				os.Exit(1)
+			}
+			if watchInterval <= 0 {
+				log.Error("--interval must be positive")
+				os.Exit(1)
+			}
+			ctx, cancel := signal.NotifyContext(context.Background(), os.Interrupt)
+			defer cancel()
+			if err := watchStats(ctx, watchInterval); err != nil {
+				log.Error("Failed to watch statistics", zap.Error(err))
+				os.Exit(1)
+			}
+		},
+	}
+	watchCmd.Flags().DurationVar(&watchInterval, "interval", 1*time.Second, "Refresh interval")
+
 	rootCmd.AddCommand(statsCmd)
 	statsCmd.AddCommand(clearCmd)
+	statsCmd.AddCommand(watchCmd)
+}
+
+// watchRecentActivities is how many recently finished operations are shown by stats watch.
+const watchRecentActivities = 10
+
+// watchStats streams stats and activities of the daemon and redraws the screen at each interval
+// until ctx is done.
+func watchStats(ctx context.Context, interval time.Duration) error {
+	c := newClient()
+	var prev *stats.Metrics
+	var prevAt time.Time
+	var recent []protocol.ActivityEvent
+	return c.CallEvents(ctx, client.EventsOptions{StatsInterval: interval}, client.EventHandlers{
+		OnActivity: func(event protocol.ActivityEvent) {
+			recent = append(recent, event)
+			if len(recent) > watchRecentActivities {
+				recent = recent[len(recent)-watchRecentActivities:]
+			}
+		},
+		OnStats: func(resp *protocol.StatsResponse) {
+			now := time.Now()
+			cur := resp.Stats.(*stats.Metrics)
+			var rates stats.Rates
+			if prev != nil {
+				rates = stats.ComputeRates(prev, cur, now.Sub(prevAt))
+			}
+			var sb strings.Builder
+			renderStatsWatch(&sb, resp, cur, rates, recent)
+			// Move the cursor home and clear the screen before each redraw
+			fmt.Print("\033[H\033[2J" + sb.String())
+			prev, prevAt = cur, now
+		},
+	})
+}
+
+func renderStatsWatch(w io.Writer, resp *protocol.StatsResponse, m *stats.Metrics, rates stats.Rates, recent []protocol.ActivityEvent) {
+	fmt.Fprintf(w, "gscache daemon pid %d, up %s, %s (Ctrl-C to exit)\n\n",
+		resp.Pid, resp.Uptime, time.Now().Format(time.TimeOnly))
+
+	gets, hits := m.GetTotal.Load(), m.GetHit.Load()
+	hitRatio := 0.0
+	if gets > 0 {
+		hitRatio = float64(hits) / float64(gets)
+	}
+	organic := &m.BlobOrganic
+	fmt.Fprintf(w, "Get         %8.1f/s  hit %5.1f%%   total %d, hit %.1f%%, error %d, rejected %d\n",
+		rates.Gets, rates.HitRatio*100, gets, hitRatio*100, m.GetError.Load(), m.GetRejected.Load())
+	if served := organic.GetByLocal.Load() + organic.GetByArchive.Load() + organic.GetByDownload.Load(); served > 0 {
+		// Only reported by the blob backend
+		fmt.Fprintf(w, "  served    local %d, archive %d, download %d\n",
+			organic.GetByLocal.Load(), organic.GetByArchive.Load(), organic.GetByDownload.Load())
+	}
+	fmt.Fprintf(w, "Put         %8.1f/s               total %d, error %d, rejected %d\n",
+		rates.Puts, m.PutTotal.Load(), m.PutError.Load(), m.PutRejected.Load())
+	fmt.Fprintf(w, "Download    %8s/s               total %s\n",
+		util.FormatBytes(uint64(rates.DownloadBytes)),
+		util.FormatBytes(organic.DownloadBytes.Load()+m.BlobCompaction.DownloadBytes.Load()+m.BlobWarm.DownloadBytes.Load()))
+	fmt.Fprintf(w, "Upload      %8s/s               total %s\n",
+		util.FormatBytes(uint64(rates.UploadBytes)),
+		util.FormatBytes(organic.UploadedBytes.Load()+m.BlobCompaction.UploadedBytes.Load()))
+
+	if b := resp.Backend; b != nil {
+		fmt.Fprintf(w, "\nUpload queue  running %d, waiting %d\n", b.UploadQueueRunning, b.UploadQueueWaiting)
+		compactor := &m.BlobCompactor
+		last := "never"
+		if b.LastCompactionAt != nil {
+			last = b.LastCompactionAt.Local().Format(time.DateTime)
+		}
+		fmt.Fprintf(w, "Compaction    last %s, success %d, skip %d, fail %d, blobs added %d (%s)\n",
+			last, compactor.Success.Load(), compactor.Skip.Load(), compactor.Fail.Load(),
+			compactor.BlobAddTotal.Load(), util.FormatBytes(compactor.BlobAddTotalBytes.Load()))
+		if b.CompactionUnhealthy {
+			fmt.Fprintf(w, "              UNHEALTHY: %d consecutive failures\n", b.CompactionConsecutiveFailures)
+		}
+	}
+
+	if len(recent) > 0 {
+		fmt.Fprintf(w, "\nRecent activity\n")
+		for i := len(recent) - 1; i >= 0; i-- {
+			event := recent[i]
+			fmt.Fprintf(w, "  %s  %-10s %-6s %10s  %s\n",
+				event.StartedAt.Add(event.Duration).Local().Format("15:04:05.000"),
+				event.Kind, event.Result, event.Duration.Round(time.Microsecond), event.Detail)
+		}
+	}
+}
+
+func removeStatsFile(path string) error {
+	if _, err := os.Stat(path); os.IsNotExist(err) {
+		return nil
+	}
+	return os.Remove(path)
 }
@@ -0,0 +1,100 @@
+package main
+
+import (
+	"context"
+	"fmt"
+	"io"
+	"os"
+	"os/signal"
+	"strings"
+	"text/tabwriter"
+	"time"
+
+	"github.com/spf13/cobra"
+	"go.uber.org/zap"
+
+	"github.com/breezewish/gscache/internal/inflight"
+	"github.com/breezewish/gscache/internal/log"
+	"github.com/breezewish/gscache/internal/protocol"
+)
+
+type topOpts struct {
+	interval time.Duration
+	once     bool
+	limit    int
+}
+
+func renderTop(w io.Writer, resp *protocol.InFlightResponse, limit int) {
+	counts := make(map[string]int)
+	for _, op := range resp.Ops {
+		counts[op.Kind]++
+	}
+	fmt.Fprintf(w, "%d running: get %d, put %d, upload %d, compaction %d; %d uploads queued\n\n",
+		len(resp.Ops), counts[inflight.KindGet], counts[inflight.KindPut], counts[inflight.KindUpload],
+		counts[inflight.KindCompaction], resp.UploadQueueWaiting)
+
+	tw := tabwriter.NewWriter(w, 0, 0, 2, ' ', 0)
+	fmt.Fprintln(tw, "KIND\tELAPSED\tDETAIL")
+	for i, op := range resp.Ops {
+		if limit > 0 && i >= limit {
+			fmt.Fprintf(tw, "...\t\t%d more\n", len(resp.Ops)-limit)
+			break
+		}
+		fmt.Fprintf(tw, "%s\t%s\t%s\n", op.Kind, op.Elapsed.Round(time.Millisecond), op.Detail)
+	}
+	_ = tw.Flush()
+}
+
+// runTop polls in-flight operations of the daemon and redraws the screen until ctx is done.
+func runTop(ctx context.Context, opts topOpts) error {
+	client := newClient()
+	ticker := time.NewTicker(opts.interval)
+	defer ticker.Stop()
+	for {
+		resp, err := client.CallInFlight()
+		if err != nil {
+			return err
+		}
+		if opts.once {
+			renderTop(os.Stdout, resp, opts.limit)
+			return nil
+		}
+		var sb strings.Builder
+		fmt.Fprintf(&sb, "gscache in-flight operations, %s (Ctrl-C to exit)\n", time.Now().Format(time.TimeOnly))
+		renderTop(&sb, resp, opts.limit)
+		// Move the cursor home and clear the screen before each redraw
+		fmt.Print("\033[H\033[2J" + sb.String())
+
+		select {
+		case <-ctx.Done():
+			return nil
+		case <-ticker.C:
+		}
+	}
+}
+
+func init() {
+	opts := topOpts{}
+
+	topCmd := &cobra.Command{
+		Use:   "top",
+		Short: "Show gets, puts, uploads and compactions currently running in the daemon, the longest running first",
+		Run: func(cmd *cobra.Command, args []string) {
+			if opts.interval <= 0 {
+				log.Error("--interval must be positive")
+				os.Exit(1)
+			}
+			ctx, cancel := signal.NotifyContext(context.Background(), os.Interrupt)
+			defer cancel()
+			if err := runTop(ctx, opts); err != nil {
+				log.Error("Failed to get in-flight operations", zap.Error(err))
+				os.Exit(1)
+			}
+		},
+	}
+	topCmd.Flags().DurationVar(&opts.interval, "interval", 1*time.Second, "Refresh interval")
+	topCmd.Flags().BoolVar(&opts.once, "once", false, "Print once and exit, instead of refreshing")
+	topCmd.Flags().IntVarP(&opts.limit, "limit", "n", 30, "Max number of operations to show, 0 for all")
+
+	rootCmd.AddCommand(topCmd)
+}
@@ -0,0 +1,158 @@
+package main
+
+import (
+	"context"
+	"fmt"
+	"os"
+	"os/signal"
+	"path/filepath"
+	"syscall"
+
+	"github.com/spf13/cobra"
+	"go.uber.org/zap"
+	gocloudblob "gocloud.dev/blob"
+
+	"github.com/breezewish/gscache/internal/cache/backends/blob"
+	"github.com/breezewish/gscache/internal/cache/backends/local"
+	"github.com/breezewish/gscache/internal/log"
+	"github.com/breezewish/gscache/internal/server"
+	"github.com/breezewish/gscache/internal/util"
+	"github.com/breezewish/gscache/internal/workdir"
+)
+
+type verifyOpts struct {
+	remote      bool
+	sampleRate  float64
+	concurrency int
+	resume      bool
+	hash        bool
+	remove      bool
+}
+
+// runVerifyLocal checks the local store in the current process. Removing corrupted entries
+// needs the work dir lock, i.e. the daemon must be stopped, while only reporting does not.
+func runVerifyLocal(opts verifyOpts) (*local.VerifyReport, error) {
+	cfg := getServerConfig()
+	if opts.remove {
+		if cfg.ReadOnly {
+			return nil, fmt.Errorf("--remove is not available in read_only mode")
+		}
+		dirLock, err := server.LockWorkDir(cfg.Dir)
+		if err != nil {
+			return nil, err
+		}
+		defer dirLock.Unlock()
+		if err := server.MigrateWorkDir(*cfg); err != nil {
+			return nil, err
+		}
+	} else if err := workdir.CheckReadOnly(cfg.Dir); err != nil {
+		return nil, err
+	}
+	store, err := local.NewLocalBackendWithOpts(cfg.Dir, local.LocalBackendOpts{
+		ReadOnly: !opts.remove,
+	})
+	if err != nil {
+		return nil, err
+	}
+	if err := store.Open(context.Background()); err != nil {
+		return nil, err
+	}
+	defer store.Close()
+	return store.Verify(local.VerifyOpts{
+		CheckHash: opts.hash,
+		Remove:    opts.remove,
+	})
+}
+
+// runVerifyRemote sweeps the remote bucket directly. It does not open any backend or lock
+// the work dir, so it can run while daemons are serving.
+func runVerifyRemote(opts verifyOpts) (*blob.VerifyReport, error) {
+	cfg := getServerConfig()
+	if cfg.Blob.URL == "" {
+		return nil, fmt.Errorf("remote verification is only available when blob.url is set")
+	}
+	keyLayout, err := blob.ParseKeyLayout(cfg.Blob.KeyLayout)
+	if err != nil {
+		return nil, err
+	}
+	ctx, stop := signal.NotifyContext(context.Background(), syscall.SIGINT, syscall.SIGTERM)
+	defer stop()
+
+	bucket, err := gocloudblob.OpenBucket(ctx, cfg.Blob.URL)
+	if err != nil {
+		return nil, fmt.Errorf("failed to open blob store: %w", err)
+	}
+	defer bucket.Close()
+
+	// Progress is always saved so that an interrupted sweep can be resumed by --resume.
+	if err := os.MkdirAll(cfg.Dir, 0755); err != nil {
+		return nil, fmt.Errorf("failed to create work dir: %w", err)
+	}
+	progressFile := filepath.Join(cfg.Dir, "verify_remote_progress.json")
+	if !opts.resume {
+		_ = os.Remove(progressFile)
+	}
+	return blob.VerifyRemote(blob.VerifyRemoteOpts{
+		Ctx:          ctx,
+		Remote:       bucket,
+		Concurrency:  opts.concurrency,
+		SampleRate:   opts.sampleRate,
+		ProgressFile: progressFile,
+		KeyLayout:    keyLayout,
+	})
+}
+
+func init() {
+	opts := verifyOpts{}
+
+	verifyCmd := &cobra.Command{
+		Use:   "verify",
+		Short: "Verify the integrity of cached entries in the local store, or the remote one with --remote",
+		Run: func(cmd *cobra.Command, args []string) {
+			if !opts.remote {
+				report, err := runVerifyLocal(opts)
+				if report != nil {
+					util.PrettyPrintJSON(report)
+				}
+				if err != nil {
+					log.Error("Verification failed", zap.Error(err))
+					os.Exit(1)
+				}
+				if report.EntriesCorrupted > report.EntriesRemoved {
+					log.Error("Found corrupted entries, run again with --remove to remove them",
+						zap.Int("corrupted", report.EntriesCorrupted))
+					os.Exit(1)
+				}
+				log.Info("Verification finished")
+				return
+			}
+			report, err := runVerifyRemote(opts)
+			if report != nil {
+				util.PrettyPrintJSON(report)
+			}
+			if err != nil {
+				log.Error("Verification failed, run again with --resume to continue", zap.Error(err))
+				os.Exit(1)
+			}
+			if report.Corrupted() > 0 {
+				log.Error("Found corrupted entries", zap.Int("corrupted", report.Corrupted()))
+				os.Exit(1)
+			}
+			log.Info("Verification finished")
+		},
+	}
+	verifyCmd.Flags().BoolVar(&opts.remote, "remote", false,
+		"Download and validate archives and standalone objects in the remote blob store. Read-only")
+	verifyCmd.Flags().Float64Var(&opts.sampleRate, "sample", 1,
+		"Remote only: Fraction (0, 1] of standalone objects to verify. Archives are always fully verified")
+	verifyCmd.Flags().IntVar(&opts.concurrency, "concurrency", blob.DefaultVerifyConcurrency,
+		"Remote only: Number of objects downloaded concurrently")
+	verifyCmd.Flags().BoolVar(&opts.resume, "resume", false,
+		"Remote only: Continue an interrupted verification instead of starting over")
+	verifyCmd.Flags().BoolVar(&opts.hash, "hash", false,
+		"Local only: Also hash output files and compare with their OutputID. Reads all outputs")
+	verifyCmd.Flags().BoolVar(&opts.remove, "remove", false,
+		"Local only: Remove corrupted entries so that they become misses. The daemon must be stopped")
+
+	rootCmd.AddCommand(verifyCmd)
+}
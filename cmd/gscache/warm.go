@@ -0,0 +1,46 @@
+package main
+
+import (
+	"fmt"
+	"os"
+
+	"github.com/spf13/cobra"
+	"go.uber.org/zap"
+
+	"github.com/breezewish/gscache/internal/log"
+	"github.com/breezewish/gscache/internal/protocol"
+	"github.com/breezewish/gscache/internal/util"
+)
+
+func init() {
+	var blobs int
+
+	warmCmd := &cobra.Command{
+		Use:   "warm",
+		Short: "Download archives and recent blobs from the remote blob store before a build starts",
+		Run: func(cmd *cobra.Command, args []string) {
+			if blobs < 0 {
+				log.Error("--blobs must not be negative")
+				os.Exit(1)
+			}
+			if err := ensureDaemonRunning( /* isExplicitStart */ false); err != nil {
+				log.Error("Failed to start gscache server daemon", zap.Error(err))
+				os.Exit(1)
+			}
+			bar := startProgress("Warming", nil)
+			resp, err := newClient().CallWarm(protocol.WarmRequest{Blobs: blobs}, bar.Func())
+			bar.Stop()
+			if err != nil {
+				log.Error("Failed to warm cache", zap.Error(err))
+				os.Exit(1)
+			}
+			fmt.Printf("Loaded %d archives (%d entries), downloaded %d blobs (%s)\n",
+				resp.ArchivesLoaded, resp.ArchiveEntries,
+				resp.BlobsDownloaded, util.FormatBytes(uint64(resp.BlobsBytes)))
+		},
+	}
+	warmCmd.Flags().IntVar(&blobs, "blobs", 0,
+		"Also download up to N most recently uploaded blobs not in archives, for each keyspace")
+
+	rootCmd.AddCommand(warmCmd)
+}
@@ -0,0 +1,219 @@
+package bench
+
+import (
+	"bytes"
+	"context"
+	"crypto/rand"
+	"crypto/sha256"
+	"fmt"
+	"io"
+	"math"
+	mathrand "math/rand/v2"
+	"slices"
+	"sync"
+	"time"
+
+	"github.com/breezewish/gscache/internal/protocol"
+	"golang.org/x/sync/errgroup"
+)
+
+// This package generates synthetic cache workloads, so that different backend setups
+// (e.g. local disk vs S3 vs GCS) can be compared with the same numbers.
+//
+// A run has two phases. The put phase stores Entries new entries with random action IDs, so
+// that existing cache content is never touched. The get phase then issues Gets requests, where
+// HitRatio of them are for entries put in the first phase, and others are for absent entries.
+
+const DefaultNamespace = "gscache-bench"
+
+// Target is the cache under benchmark, usually the daemon accessed via client.Client.
+type Target interface {
+	Put(req protocol.PutRequest, body io.Reader) error
+	Get(req protocol.GetRequest) (*protocol.GetResponse, error)
+}
+
+type Config struct {
+	Entries     int     // Number of entries to put
+	Gets        int     // Number of gets. If 0, same as Entries
+	Sizes       []int64 // Body sizes of entries, used in round robin
+	HitRatio    float64 // Fraction of gets for entries which are put, in [0, 1]
+	Concurrency int
+	Namespace   string
+}
+
+func (c *Config) Validate() error {
+	if c.Entries <= 0 {
+		return fmt.Errorf("entries must be positive")
+	}
+	if c.Gets < 0 {
+		return fmt.Errorf("gets must not be negative")
+	}
+	if len(c.Sizes) == 0 {
+		return fmt.Errorf("at least one entry size must be specified")
+	}
+	for _, size := range c.Sizes {
+		if size < 0 {
+			return fmt.Errorf("entry size must not be negative")
+		}
+	}
+	if c.HitRatio < 0 || c.HitRatio > 1 {
+		return fmt.Errorf("hit ratio must be in [0, 1]")
+	}
+	if c.Concurrency <= 0 {
+		return fmt.Errorf("concurrency must be positive")
+	}
+	return protocol.ValidateNamespace(c.Namespace)
+}
+
+type PhaseResult struct {
+	Ops      int
+	Errors   int
+	Hits     int // Only for gets
+	Bytes    int64
+	Duration time.Duration
+	FirstErr error // Nil if Errors is 0
+
+	latencies []time.Duration // Sorted, successful operations only
+}
+
+func (r *PhaseResult) OpsPerSecond() float64 {
+	if r.Duration <= 0 {
+		return 0
+	}
+	return float64(r.Ops) / r.Duration.Seconds()
+}
+
+func (r *PhaseResult) BytesPerSecond() float64 {
+	if r.Duration <= 0 {
+		return 0
+	}
+	return float64(r.Bytes) / r.Duration.Seconds()
+}
+
+// Percentile returns the latency at percentile p in [0, 100] using the nearest rank method,
+// or 0 if no operation succeeded.
+func (r *PhaseResult) Percentile(p float64) time.Duration {
+	if len(r.latencies) == 0 {
+		return 0
+	}
+	rank := int(math.Ceil(p / 100 * float64(len(r.latencies))))
+	return r.latencies[min(max(rank-1, 0), len(r.latencies)-1)]
+}
+
+type Result struct {
+	Put PhaseResult
+	Get PhaseResult
+}
+
+type entry struct {
+	actionID []byte
+	size     int64
+}
+
+// Run runs the put phase and then the get phase against the target. Failed operations are
+// counted instead of aborting the run, so that a flaky remote still produces numbers.
+func Run(ctx context.Context, target Target, cfg Config) (*Result, error) {
+	if err := cfg.Validate(); err != nil {
+		return nil, err
+	}
+	if cfg.Gets == 0 {
+		cfg.Gets = cfg.Entries
+	}
+
+	maxSize := slices.Max(cfg.Sizes)
+	payload := make([]byte, maxSize)
+	_, _ = rand.Read(payload)
+	entries := make([]entry, cfg.Entries)
+	for i := range entries {
+		entries[i] = entry{actionID: randomID(), size: cfg.Sizes[i%len(cfg.Sizes)]}
+	}
+
+	result := &Result{}
+	err := runPhase(ctx, &result.Put, cfg.Entries, cfg.Concurrency, func(i int) (bool, int64, error) {
+		e := entries[i]
+		// Bodies start with the action ID so that outputs are not deduplicated by the backend
+		body := make([]byte, e.size)
+		copy(body, payload)
+		copy(body, e.actionID)
+		outputID := sha256.Sum256(body)
+		err := target.Put(protocol.PutRequest{
+			ActionID:  e.actionID,
+			OutputID:  outputID[:],
+			BodySize:  e.size,
+			Namespace: cfg.Namespace,
+		}, bytes.NewReader(body))
+		return false, e.size, err
+	})
+	if err != nil {
+		return result, err
+	}
+
+	// Hits are spread evenly over gets, so that the hit ratio holds for any prefix of the run
+	hitCount := int(math.Round(float64(cfg.Gets) * cfg.HitRatio))
+	isHit := make([]bool, cfg.Gets)
+	for i := range hitCount {
+		isHit[i] = true
+	}
+	mathrand.Shuffle(len(isHit), func(i, j int) { isHit[i], isHit[j] = isHit[j], isHit[i] })
+	err = runPhase(ctx, &result.Get, cfg.Gets, cfg.Concurrency, func(i int) (bool, int64, error) {
+		actionID := randomID()
+		if isHit[i] {
+			actionID = entries[i%len(entries)].actionID
+		}
+		resp, err := target.Get(protocol.GetRequest{ActionID: actionID, Namespace: cfg.Namespace})
+		if err != nil {
+			return false, 0, err
+		}
+		if resp.Miss {
+			return false, 0, nil
+		}
+		return true, resp.Size, nil
+	})
+	return result, err
+}
+
+// runPhase calls op for [0, n) with the given concurrency and collects results into r.
+func runPhase(ctx context.Context, r *PhaseResult, n int, concurrency int, op func(i int) (hit bool, bytes int64, err error)) error {
+	var mu sync.Mutex
+	latencies := make([]time.Duration, 0)
+	var g errgroup.Group
+	g.SetLimit(concurrency)
+	start := time.Now()
+	for i := range n {
+		if ctx.Err() != nil {
+			break
+		}
+		g.Go(func() error {
+			t := time.Now()
+			hit, bytes, err := op(i)
+			cost := time.Since(t)
+			mu.Lock()
+			defer mu.Unlock()
+			r.Ops++
+			if err != nil {
+				r.Errors++
+				if r.FirstErr == nil {
+					r.FirstErr = err
+				}
+				return nil
+			}
+			if hit {
+				r.Hits++
+			}
+			r.Bytes += bytes
+			latencies = append(latencies, cost)
+			return nil
+		})
+	}
+	_ = g.Wait()
+	r.Duration = time.Since(start)
+	slices.Sort(latencies)
+	r.latencies = latencies
+	return ctx.Err()
+}
+
+func randomID() []byte {
+	id := make([]byte, sha256.Size)
+	_, _ = rand.Read(id)
+	return id
+}
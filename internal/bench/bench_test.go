@@ -0,0 +1,114 @@
+package bench
+
+import (
+	"context"
+	"crypto/sha256"
+	"fmt"
+	"io"
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/require"
+
+	"github.com/breezewish/gscache/internal/protocol"
+)
+
+type memTarget struct {
+	mu        sync.Mutex
+	entries   map[string][]byte
+	outputIDs map[string]bool
+	failPuts  bool
+}
+
+func newMemTarget() *memTarget {
+	return &memTarget{entries: map[string][]byte{}, outputIDs: map[string]bool{}}
+}
+
+func (m *memTarget) Put(req protocol.PutRequest, body io.Reader) error {
+	if m.failPuts {
+		return fmt.Errorf("put failed")
+	}
+	data, err := io.ReadAll(body)
+	if err != nil {
+		return err
+	}
+	if int64(len(data)) != req.BodySize {
+		return fmt.Errorf("body size mismatch")
+	}
+	if sum := sha256.Sum256(data); string(sum[:]) != string(req.OutputID) {
+		return fmt.Errorf("output ID mismatch")
+	}
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	m.entries[req.Namespace+"/"+string(req.ActionID)] = data
+	m.outputIDs[string(req.OutputID)] = true
+	return nil
+}
+
+func (m *memTarget) Get(req protocol.GetRequest) (*protocol.GetResponse, error) {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	data, ok := m.entries[req.Namespace+"/"+string(req.ActionID)]
+	if !ok {
+		return &protocol.GetResponse{Miss: true}, nil
+	}
+	return &protocol.GetResponse{Size: int64(len(data))}, nil
+}
+
+func TestRun(t *testing.T) {
+	target := newMemTarget()
+	result, err := Run(context.Background(), target, Config{
+		Entries:     10,
+		Gets:        20,
+		Sizes:       []int64{0, 10, 100},
+		HitRatio:    0.75,
+		Concurrency: 4,
+		Namespace:   DefaultNamespace,
+	})
+	require.NoError(t, err)
+
+	require.Len(t, target.entries, 10)
+	// Empty bodies share the output ID, others are all different
+	require.Len(t, target.outputIDs, 6+1)
+	require.Equal(t, 10, result.Put.Ops)
+	require.Equal(t, 0, result.Put.Errors)
+	require.Equal(t, int64(4*0+3*10+3*100), result.Put.Bytes)
+
+	require.Equal(t, 20, result.Get.Ops)
+	require.Equal(t, 15, result.Get.Hits)
+	require.Equal(t, 0, result.Get.Errors)
+	require.Greater(t, result.Get.OpsPerSecond(), 0.0)
+	require.LessOrEqual(t, result.Get.Percentile(50), result.Get.Percentile(99))
+}
+
+func TestRun_Errors(t *testing.T) {
+	target := newMemTarget()
+	target.failPuts = true
+	result, err := Run(context.Background(), target, Config{
+		Entries:     5,
+		Sizes:       []int64{10},
+		HitRatio:    1,
+		Concurrency: 2,
+	})
+	require.NoError(t, err)
+	require.Equal(t, 5, result.Put.Errors)
+	require.ErrorContains(t, result.Put.FirstErr, "put failed")
+	require.Equal(t, time.Duration(0), result.Put.Percentile(50))
+	require.Equal(t, 5, result.Get.Ops)
+	require.Equal(t, 0, result.Get.Hits)
+
+	_, err = Run(context.Background(), target, Config{Entries: 1, Sizes: []int64{1}, HitRatio: 2, Concurrency: 1})
+	require.ErrorContains(t, err, "hit ratio")
+}
+
+func TestPhaseResult_Percentile(t *testing.T) {
+	r := PhaseResult{}
+	for i := 1; i <= 100; i++ {
+		r.latencies = append(r.latencies, time.Duration(i)*time.Millisecond)
+	}
+	require.Equal(t, 1*time.Millisecond, r.Percentile(0))
+	require.Equal(t, 50*time.Millisecond, r.Percentile(50))
+	require.Equal(t, 99*time.Millisecond, r.Percentile(99))
+	require.Equal(t, 100*time.Millisecond, r.Percentile(100))
+}
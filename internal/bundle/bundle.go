@@ -0,0 +1,315 @@
+package bundle
+
+import (
+	"archive/tar"
+	"compress/gzip"
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"io/fs"
+	"os"
+	"path"
+	"path/filepath"
+	"sort"
+	"strings"
+	"time"
+
+	"github.com/breezewish/gscache/internal/progress"
+	"github.com/breezewish/gscache/internal/util"
+	gonanoid "github.com/matoous/go-nanoid/v2"
+)
+
+// This package moves a gscache work dir between machines, e.g. as a CI artifact, without a
+// blob bucket. A bundle is a tar file (optionally gzipped) containing:
+//   manifest.json              Always the first entry
+//   blobar/<keyspace>.zip      Local copies of BlobArchive files
+//   data/.../<id>.output       Local store outputs, before actions referencing them
+//   data/.../<id>.action       Local store actions
+//
+// Only cache content is included. Config, logs and stats are not.
+
+const (
+	ManifestVersion = 1
+	manifestName    = "manifest.json"
+)
+
+type Manifest struct {
+	Version   int
+	CreatedAt time.Time
+	Actions   int
+	Outputs   int
+	Archives  int
+	Bytes     int64 // Total size of files, excluding the manifest
+}
+
+type ImportReport struct {
+	Imported      int
+	ImportedBytes int64
+	Skipped       int // Files which already exist in the work dir
+}
+
+type bundleFile struct {
+	name string // Slash separated path in the bundle, also relative to the work dir
+	size int64
+}
+
+// IsGzip returns whether the bundle file should be gzipped according to its name.
+// ".tar.gz" and ".tgz" are gzipped, ".tar" is not, other extensions are rejected. Other
+// compressions, e.g. zstd, are left to external tools working on ".tar" bundles.
+func IsGzip(fileName string) (bool, error) {
+	switch {
+	case strings.HasSuffix(fileName, ".tar.gz"), strings.HasSuffix(fileName, ".tgz"):
+		return true, nil
+	case strings.HasSuffix(fileName, ".tar"):
+		return false, nil
+	case strings.HasSuffix(fileName, ".tar.zst"):
+		return false, fmt.Errorf("unsupported bundle file %s, zstd is not built in: use a .tar bundle and compress it by the zstd command", fileName)
+	}
+	return false, fmt.Errorf("unsupported bundle file %s, must be .tar.gz, .tgz or .tar", fileName)
+}
+
+// listFiles returns files of the work dir to export, in the order they are written.
+func listFiles(workDir string) ([]bundleFile, error) {
+	archives := make([]bundleFile, 0)
+	outputs := make([]bundleFile, 0)
+	actions := make([]bundleFile, 0)
+	for _, sub := range []string{"blobar", "data"} {
+		err := filepath.WalkDir(filepath.Join(workDir, sub), func(p string, d fs.DirEntry, err error) error {
+			if err != nil {
+				if os.IsNotExist(err) {
+					return nil
+				}
+				return err
+			}
+			if !d.Type().IsRegular() {
+				return nil
+			}
+			info, err := d.Info()
+			if err != nil {
+				return nil // Removed concurrently
+			}
+			rel, err := filepath.Rel(workDir, p)
+			if err != nil {
+				return err
+			}
+			f := bundleFile{name: filepath.ToSlash(rel), size: info.Size()}
+			if strings.Contains(f.name, "/_") {
+				// Empty files of the local store, which are recreated when needed
+				return nil
+			}
+			switch {
+			case sub == "blobar" && strings.HasSuffix(f.name, ".zip"):
+				archives = append(archives, f)
+			case strings.HasSuffix(f.name, ".output"):
+				outputs = append(outputs, f)
+			case strings.HasSuffix(f.name, ".action"):
+				actions = append(actions, f)
+			}
+			return nil
+		})
+		if err != nil {
+			return nil, fmt.Errorf("failed to scan %s: %w", sub, err)
+		}
+	}
+	files := make([]bundleFile, 0, len(archives)+len(outputs)+len(actions))
+	for _, group := range [][]bundleFile{archives, outputs, actions} {
+		sort.Slice(group, func(i, j int) bool { return group[i].name < group[j].name })
+		files = append(files, group...)
+	}
+	return files, nil
+}
+
+// Export writes local store entries and local BlobArchive files of the work dir to w as a tar.
+// Files are never modified in place in the work dir, so it is safe to export while the daemon
+// is serving, although entries put or removed meanwhile may or may not be included.
+// Progress is reported to the tracker in ctx, if any.
+func Export(ctx context.Context, workDir string, w io.Writer, gzipped bool) (*Manifest, error) {
+	files, err := listFiles(workDir)
+	if err != nil {
+		return nil, err
+	}
+	manifest := &Manifest{Version: ManifestVersion, CreatedAt: time.Now().UTC()}
+	for _, f := range files {
+		switch {
+		case strings.HasPrefix(f.name, "blobar/"):
+			manifest.Archives++
+		case strings.HasSuffix(f.name, ".output"):
+			manifest.Outputs++
+		default:
+			manifest.Actions++
+		}
+		manifest.Bytes += f.size
+	}
+	tracker := progress.FromContext(ctx)
+	tracker.SetStage("files", int64(len(files)), manifest.Bytes)
+
+	var gw *gzip.Writer
+	if gzipped {
+		gw = gzip.NewWriter(w)
+		w = gw
+	}
+	tw := tar.NewWriter(w)
+	manifestData, err := json.MarshalIndent(manifest, "", "  ")
+	if err != nil {
+		return nil, err
+	}
+	if err := writeTarFile(tw, manifestName, int64(len(manifestData)), strings.NewReader(string(manifestData))); err != nil {
+		return nil, err
+	}
+	for _, f := range files {
+		if err := ctx.Err(); err != nil {
+			return nil, err
+		}
+		file, err := os.Open(filepath.Join(workDir, filepath.FromSlash(f.name)))
+		if os.IsNotExist(err) {
+			// The manifest is only a summary, so a file removed after scanning is simply skipped.
+			tracker.Add(1, f.size)
+			continue
+		}
+		if err != nil {
+			return nil, err
+		}
+		// The size is taken from the opened file instead of the scan, as the file may be
+		// replaced meanwhile, e.g. an archive rewritten by compaction.
+		info, err := file.Stat()
+		if err != nil {
+			_ = file.Close()
+			return nil, err
+		}
+		err = writeTarFile(tw, f.name, info.Size(), file)
+		_ = file.Close()
+		if err != nil {
+			return nil, err
+		}
+		tracker.Add(1, f.size)
+	}
+	if err := tw.Close(); err != nil {
+		return nil, fmt.Errorf("failed to finish bundle: %w", err)
+	}
+	if gw != nil {
+		if err := gw.Close(); err != nil {
+			return nil, fmt.Errorf("failed to finish bundle: %w", err)
+		}
+	}
+	return manifest, nil
+}
+
+func writeTarFile(tw *tar.Writer, name string, size int64, r io.Reader) error {
+	err := tw.WriteHeader(&tar.Header{
+		Typeflag: tar.TypeReg,
+		Name:     name,
+		Size:     size,
+		Mode:     0644,
+	})
+	if err != nil {
+		return fmt.Errorf("failed to write %s to bundle: %w", name, err)
+	}
+	if _, err := io.CopyN(tw, r, size); err != nil {
+		return fmt.Errorf("failed to write %s to bundle: %w", name, err)
+	}
+	return nil
+}
+
+// Import extracts a bundle written by Export into the work dir. Existing files are kept, so that
+// importing the same bundle again, or into a populated work dir, is cheap and does not replace
+// newer content. The daemon should not be running, as it does not pick up imported archives.
+// Progress is reported to the tracker in ctx, if any.
+func Import(ctx context.Context, workDir string, r io.Reader, gzipped bool) (*Manifest, *ImportReport, error) {
+	if gzipped {
+		gr, err := gzip.NewReader(r)
+		if err != nil {
+			return nil, nil, fmt.Errorf("failed to read bundle: %w", err)
+		}
+		defer gr.Close()
+		r = gr
+	}
+	tr := tar.NewReader(r)
+	hdr, err := tr.Next()
+	if err != nil {
+		return nil, nil, fmt.Errorf("failed to read bundle: %w", err)
+	}
+	if hdr.Name != manifestName {
+		return nil, nil, fmt.Errorf("not a gscache bundle: the first file is %s", hdr.Name)
+	}
+	manifest := &Manifest{}
+	if err := json.NewDecoder(tr).Decode(manifest); err != nil {
+		return nil, nil, fmt.Errorf("failed to parse bundle manifest: %w", err)
+	}
+	if manifest.Version != ManifestVersion {
+		return manifest, nil, fmt.Errorf("unsupported bundle version %d, expected %d", manifest.Version, ManifestVersion)
+	}
+
+	tracker := progress.FromContext(ctx)
+	tracker.SetStage("files", int64(manifest.Actions+manifest.Outputs+manifest.Archives), manifest.Bytes)
+	report := &ImportReport{}
+	for {
+		if err := ctx.Err(); err != nil {
+			return manifest, report, err
+		}
+		hdr, err := tr.Next()
+		if errors.Is(err, io.EOF) {
+			return manifest, report, nil
+		}
+		if err != nil {
+			return manifest, report, fmt.Errorf("failed to read bundle: %w", err)
+		}
+		if hdr.Typeflag != tar.TypeReg {
+			continue
+		}
+		if err := validateName(hdr.Name); err != nil {
+			return manifest, report, err
+		}
+		target := filepath.Join(workDir, filepath.FromSlash(hdr.Name))
+		if _, err := os.Stat(target); err == nil {
+			report.Skipped++
+			tracker.Add(1, hdr.Size)
+			continue
+		}
+		if err := extractFile(target, tr, hdr.Size); err != nil {
+			return manifest, report, err
+		}
+		report.Imported++
+		report.ImportedBytes += hdr.Size
+		tracker.Add(1, hdr.Size)
+	}
+}
+
+// validateName rejects files which would be extracted outside of cache dirs of the work dir.
+func validateName(name string) error {
+	if path.Clean(name) != name || path.IsAbs(name) || strings.Contains(name, "..") || strings.Contains(name, "/_") {
+		return fmt.Errorf("invalid file %s in bundle", name)
+	}
+	switch {
+	case strings.HasPrefix(name, "blobar/") && strings.HasSuffix(name, ".zip"):
+	case strings.HasPrefix(name, "data/") && (strings.HasSuffix(name, ".output") || strings.HasSuffix(name, ".action")):
+	default:
+		return fmt.Errorf("invalid file %s in bundle", name)
+	}
+	return nil
+}
+
+func extractFile(target string, r io.Reader, size int64) error {
+	if err := os.MkdirAll(filepath.Dir(target), 0755); err != nil {
+		return fmt.Errorf("failed to create dir for %s: %w", target, err)
+	}
+	tmpPath := target + ".tmp." + gonanoid.Must(8)
+	f, err := os.Create(tmpPath)
+	if err != nil {
+		return fmt.Errorf("failed to create %s: %w", tmpPath, err)
+	}
+	_, err = io.CopyN(f, r, size)
+	if err2 := f.Close(); err == nil {
+		err = err2
+	}
+	if err != nil {
+		_ = os.Remove(tmpPath)
+		return fmt.Errorf("failed to extract %s: %w", target, err)
+	}
+	if err := util.RenameFile(tmpPath, target); err != nil {
+		_ = os.Remove(tmpPath)
+		return err
+	}
+	return nil
+}
@@ -0,0 +1,133 @@
+package bundle
+
+import (
+	"archive/tar"
+	"bytes"
+	"context"
+	"os"
+	"path/filepath"
+	"testing"
+
+	"github.com/stretchr/testify/require"
+
+	"github.com/breezewish/gscache/internal/cache"
+	"github.com/breezewish/gscache/internal/cache/backends/local"
+	"github.com/breezewish/gscache/internal/progress"
+	"github.com/breezewish/gscache/internal/protocol"
+)
+
+func newTestStore(t *testing.T, workDir string) *local.LocalBackend {
+	store, err := local.NewLocalBackend(workDir)
+	require.NoError(t, err)
+	require.NoError(t, store.Open(context.Background()))
+	t.Cleanup(func() { _ = store.Close() })
+	return store
+}
+
+func TestExportImport(t *testing.T) {
+	for _, gzipped := range []bool{false, true} {
+		srcDir := t.TempDir()
+		src := newTestStore(t, srcDir)
+		_, err := src.Put(cache.PutOpts{
+			Req:  protocol.PutRequest{ActionID: []byte{0x01}, OutputID: []byte{0x11}, BodySize: 5},
+			Body: bytes.NewReader([]byte("hello")),
+		})
+		require.NoError(t, err)
+		_, err = src.Put(cache.PutOpts{
+			Req:  protocol.PutRequest{Namespace: "foo", ActionID: []byte{0x02}, OutputID: []byte{0x11}, BodySize: 5},
+			Body: bytes.NewReader([]byte("hello")),
+		})
+		require.NoError(t, err)
+		require.NoError(t, os.MkdirAll(filepath.Join(srcDir, "blobar"), 0755))
+		require.NoError(t, os.WriteFile(filepath.Join(srcDir, "blobar", "a.zip"), []byte("zip"), 0644))
+		require.NoError(t, os.WriteFile(filepath.Join(srcDir, "blobar", "affinity.json"), []byte("{}"), 0644))
+
+		var buf bytes.Buffer
+		manifest, err := Export(context.Background(), srcDir, &buf, gzipped)
+		require.NoError(t, err)
+		require.Equal(t, 2, manifest.Actions)
+		require.Equal(t, 1, manifest.Outputs)
+		require.Equal(t, 1, manifest.Archives)
+
+		dstDir := t.TempDir()
+		bundleData := buf.Bytes()
+		tracker := progress.NewTracker()
+		ctx := progress.NewContext(context.Background(), tracker)
+		imported, report, err := Import(ctx, dstDir, bytes.NewReader(bundleData), gzipped)
+		require.NoError(t, err)
+		require.Equal(t, manifest.Bytes, imported.Bytes)
+		require.Equal(t, ImportReport{Imported: 4, ImportedBytes: manifest.Bytes}, *report)
+		p, _ := tracker.Snapshot()
+		require.Equal(t, progress.Progress{Stage: "files", Done: 4, Total: 4, Bytes: manifest.Bytes, TotalBytes: manifest.Bytes}, p)
+		require.FileExists(t, filepath.Join(dstDir, "blobar", "a.zip"))
+		require.NoFileExists(t, filepath.Join(dstDir, "blobar", "affinity.json"))
+
+		dst := newTestStore(t, dstDir)
+		for _, req := range []protocol.GetRequest{
+			{ActionID: []byte{0x01}},
+			{Namespace: "foo", ActionID: []byte{0x02}},
+		} {
+			resp, err := dst.Get(cache.GetOpts{Req: req})
+			require.NoError(t, err)
+			require.False(t, resp.Miss)
+			data, err := os.ReadFile(resp.DiskPath)
+			require.NoError(t, err)
+			require.Equal(t, "hello", string(data))
+		}
+
+		// Importing again keeps existing files
+		_, report, err = Import(context.Background(), dstDir, bytes.NewReader(bundleData), gzipped)
+		require.NoError(t, err)
+		require.Equal(t, ImportReport{Skipped: 4}, *report)
+
+		// Compression must match
+		_, _, err = Import(context.Background(), t.TempDir(), bytes.NewReader(bundleData), !gzipped)
+		require.Error(t, err)
+	}
+}
+
+func TestImport_RejectInvalidFiles(t *testing.T) {
+	writeBundle := func(names ...string) []byte {
+		var buf bytes.Buffer
+		tw := tar.NewWriter(&buf)
+		for _, name := range names {
+			require.NoError(t, writeTarFile(tw, name, 1, bytes.NewReader([]byte("x"))))
+		}
+		require.NoError(t, tw.Close())
+		return buf.Bytes()
+	}
+
+	_, _, err := Import(context.Background(), t.TempDir(), bytes.NewReader(writeBundle("data/01/01.action")), false)
+	require.ErrorContains(t, err, "not a gscache bundle")
+
+	manifest := []byte(`{"Version":1}`)
+	for _, name := range []string{"data/../../etc/passwd.action", "/data/01/01.action", "config.toml", "blobar/a.txt"} {
+		var buf bytes.Buffer
+		tw := tar.NewWriter(&buf)
+		require.NoError(t, writeTarFile(tw, manifestName, int64(len(manifest)), bytes.NewReader(manifest)))
+		require.NoError(t, writeTarFile(tw, name, 1, bytes.NewReader([]byte("x"))))
+		require.NoError(t, tw.Close())
+		_, _, err := Import(context.Background(), t.TempDir(), &buf, false)
+		require.ErrorContains(t, err, "invalid file", name)
+	}
+
+	var buf bytes.Buffer
+	tw := tar.NewWriter(&buf)
+	manifest = []byte(`{"Version":99}`)
+	require.NoError(t, writeTarFile(tw, manifestName, int64(len(manifest)), bytes.NewReader(manifest)))
+	require.NoError(t, tw.Close())
+	_, _, err = Import(context.Background(), t.TempDir(), &buf, false)
+	require.ErrorContains(t, err, "unsupported bundle version 99")
+}
+
+func TestIsGzip(t *testing.T) {
+	for name, expected := range map[string]bool{"a.tar.gz": true, "a.tgz": true, "a.tar": false} {
+		gzipped, err := IsGzip(name)
+		require.NoError(t, err)
+		require.Equal(t, expected, gzipped)
+	}
+	_, err := IsGzip("a.tar.zst")
+	require.ErrorContains(t, err, "zstd command")
+	_, err = IsGzip("a.zip")
+	require.Error(t, err)
+}
@@ -12,6 +12,9 @@ type PutOpts struct {
 	Req  protocol.PutRequest
 	Body io.Reader
 
+	// Optional. The parent context of this request, currently only used for tracing.
+	Ctx context.Context
+
 	// If set, will use this time as the cache entry's time, instead of the current time.
 	// This is mainly used when a backend is used in another backend.
 	OverrideTime *time.Time
@@ -23,8 +26,36 @@ type PutOpts struct {
 type GetOpts struct {
 	Req protocol.GetRequest
 
+	// Optional. The parent context of this request, currently only used for tracing.
+	Ctx context.Context
+
 	// Is this Get request part of a compaction process? Used for statistics.
 	IsInCompaction bool
+
+	// Is this Get request warming the local store, e.g. by warm or prefetch, instead of being
+	// issued by a build? Used for statistics.
+	IsWarm bool
+}
+
+func (o PutOpts) Context() context.Context {
+	if o.Ctx == nil {
+		return context.Background()
+	}
+	return o.Ctx
+}
+
+func (o GetOpts) Context() context.Context {
+	if o.Ctx == nil {
+		return context.Background()
+	}
+	return o.Ctx
+}
+
+// EntryKey returns a key which uniquely identifies a cache entry across namespaces,
+// suitable for in-memory dedup.
+func EntryKey(namespace string, actionID []byte) string {
+	// Namespace never contains '/', so the first '/' always separates the two parts.
+	return namespace + "/" + string(actionID)
 }
 
 type Backend interface {
@@ -38,3 +69,69 @@ type BackendSupportCompaction interface {
 	Backend
 	Compact() error
 }
+
+type BackendSupportCompactArchives interface {
+	Backend
+	// CompactArchives compacts small remote blobs of the requested keyspaces into archives.
+	// Compaction runs until done even if ctx is cancelled, ctx only carries the progress tracker.
+	CompactArchives(ctx context.Context, req protocol.CompactRequest) (*protocol.CompactResponse, error)
+}
+
+type BackendSupportExists interface {
+	Backend
+	// Exists cheaply checks whether an entry exists without fetching its body.
+	Exists(ctx context.Context, namespace string, actionID []byte) (bool, error)
+}
+
+type BackendSupportStatus interface {
+	Backend
+	// Status returns a snapshot of the backend's internal state for monitoring purpose.
+	Status() protocol.BackendStatus
+}
+
+type BackendSupportFlush interface {
+	Backend
+	// PendingUploads returns the number of puts which are not persisted to the remote yet.
+	PendingUploads() int
+	// Flush waits until all pending uploads are persisted, or ctx is done.
+	// Returns the number of uploads still pending.
+	Flush(ctx context.Context) int
+}
+
+type BackendSupportGC interface {
+	Backend
+	// GC trims the local store by age and total size.
+	GC(req protocol.GCRequest) (*protocol.GCResponse, error)
+}
+
+type BackendSupportWarm interface {
+	Backend
+	// Warm downloads remote data ahead of builds, so that first requests are served locally.
+	Warm(ctx context.Context, req protocol.WarmRequest) (*protocol.WarmResponse, error)
+}
+
+type BackendSupportPrefetch interface {
+	Backend
+	// Prefetch downloads remote entries whose actionID matches the requested prefixes.
+	Prefetch(ctx context.Context, req protocol.PrefetchRequest) (*protocol.PrefetchResponse, error)
+}
+
+type BackendSupportDelete interface {
+	Backend
+	// Delete removes an entry, so that it becomes a miss.
+	Delete(ctx context.Context, req protocol.DeleteRequest) (*protocol.DeleteResponse, error)
+}
+
+type BackendSupportHealth interface {
+	Backend
+	// CheckRemote checks whether the remote responds, and returns when it last responded.
+	// Results are reused for a while, so that frequent health probes do not flood the remote.
+	// The time is zero if there is no remote.
+	CheckRemote(ctx context.Context) (time.Time, error)
+}
+
+type BackendSupportUploadConcurrency interface {
+	Backend
+	// SetUploadConcurrency changes the max number of concurrent uploads to the remote.
+	SetUploadConcurrency(n int) error
+}
@@ -2,9 +2,13 @@ package blob
 
 import (
 	"archive/zip"
+	"encoding/binary"
 	"encoding/json"
 	"fmt"
+	"hash/fnv"
 	"io"
+	"maps"
+	"slices"
 
 	"github.com/breezewish/gscache/internal/cache"
 )
@@ -22,30 +26,60 @@ func (e *ArEntry) Open() (io.ReadCloser, error) {
 	return r, nil
 }
 
+// CompressedSize returns the size of the entry data stored in the archive.
+func (e *ArEntry) CompressedSize() uint64 {
+	return e.f.CompressedSize64
+}
+
 // ArReader reads a BlobArchive file, and is concurrent-safe.
 // BlobArchive file is a collection of small blob files stored in a zip archive.
 // The zip format is only used for convenience. Compression is not the main purpose.
 type ArReader struct {
-	z     *zip.ReadCloser
-	files map[string]ArEntry // Map of file names to cache entries.
+	z            *zip.ReadCloser
+	files        map[string]ArEntry // Map of file names to cache entries.
+	invalidNames int
+}
+
+type ArReaderOpts struct {
+	// If true, entries whose name is not the hex of its ActionID are skipped,
+	// so that a malformed archive does not poison the index.
+	ValidateNames bool
 }
 
 func NewArReader(path string) (*ArReader, error) {
+	return NewArReaderWithOpts(path, ArReaderOpts{})
+}
+
+func NewArReaderWithOpts(path string, opts ArReaderOpts) (*ArReader, error) {
 	z, err := zip.OpenReader(path)
 	if err != nil {
 		return nil, err
 	}
 	files := make(map[string]ArEntry)
+	invalidNames := 0
 	for _, f := range z.File {
 		var meta cache.EntryMeta
 		if err := json.Unmarshal([]byte(f.Comment), &meta); err != nil {
+			_ = z.Close()
 			return nil, fmt.Errorf("failed to unmarshal entry meta from file comment %s: %w", f.Name, err)
 		}
+		if f.Name != CacheEntityNameInArchive(meta.ActionID) {
+			invalidNames++
+			if opts.ValidateNames {
+				continue
+			}
+		}
 		// For compatibility, we use JSON format instead
 		// of binary format to store EntryMeta in the comment.
 		files[f.Name] = ArEntry{meta, f}
 	}
-	return &ArReader{z, files}, nil
+	return &ArReader{z, files, invalidNames}, nil
+}
+
+// InvalidNames returns the number of entries whose name is not the hex of its ActionID.
+// When the reader is created with ValidateNames, these entries are not available.
+func (r *ArReader) InvalidNames() int {
+	return r.invalidNames
 }
 
 func (r *ArReader) Get(name string) *ArEntry {
@@ -63,18 +97,47 @@ func (r *ArReader) List() []string {
 	return names
 }
 
+// Version returns a fingerprint of entries in the archive, which is the same for archives
+// loaded from the same content, e.g. when an unchanged archive is synced again.
+func (r *ArReader) Version() string {
+	names := slices.Sorted(maps.Keys(r.files))
+	h := fnv.New64a()
+	for _, name := range names {
+		f := r.files[name].f
+		h.Write([]byte(name))
+		h.Write([]byte(f.Comment))
+		h.Write(binary.LittleEndian.AppendUint32(nil, f.CRC32))
+		h.Write(binary.LittleEndian.AppendUint64(nil, f.UncompressedSize64))
+	}
+	return fmt.Sprintf("%x", h.Sum64())
+}
+
 func (r *ArReader) Close() error {
 	r.files = nil
 	return r.z.Close()
 }
 
 type ArWriter struct {
-	z *zip.Writer
+	z    *zip.Writer
+	opts ArWriterOpts
+
+	lastName string // Only used when Deterministic is set
+}
+
+type ArWriterOpts struct {
+	// If true, entries must be added in ascending name order, which is checked by Add, so that
+	// the same set of entries always produces byte-identical archives. Entries are still written
+	// as they are added instead of being buffered, so callers sort them beforehand.
+	Deterministic bool
 }
 
 func NewArWriter(w io.Writer) *ArWriter {
+	return NewArWriterWithOpts(w, ArWriterOpts{})
+}
+
+func NewArWriterWithOpts(w io.Writer, opts ArWriterOpts) *ArWriter {
 	zW := zip.NewWriter(w)
-	return &ArWriter{zW}
+	return &ArWriter{z: zW, opts: opts}
 }
 
 func (w *ArWriter) Add(name string, meta cache.EntryMeta, data []byte) error {
@@ -84,11 +147,22 @@ func (w *ArWriter) Add(name string, meta cache.EntryMeta, data []byte) error {
 	if len(data) != int(meta.Size) {
 		return fmt.Errorf("size mismatch for file %s: expected %d according to meta, got %d", name, meta.Size, len(data))
 	}
+	if w.opts.Deterministic {
+		if w.lastName != "" && name <= w.lastName {
+			return fmt.Errorf("file %s is not added in name order after %s", name, w.lastName)
+		}
+		w.lastName = name
+	}
+	return w.write(name, meta, data)
+}
 
+func (w *ArWriter) write(name string, meta cache.EntryMeta, data []byte) error {
 	comment, err := json.Marshal(meta)
 	if err != nil {
 		return fmt.Errorf("failed to marshal entry meta for file %s: %w", name, err)
 	}
+	// Modified is intentionally left zero, so that no timestamp is written
+	// and archives are reproducible.
 	f, err := w.z.CreateHeader(&zip.FileHeader{
 		Name:    name,
 		Method:  zip.Deflate,
@@ -2,8 +2,11 @@ package blob
 
 import (
 	"bytes"
+	"io"
+	"maps"
 	"os"
 	"path/filepath"
+	"slices"
 	"testing"
 	"time"
 
@@ -90,6 +93,8 @@ func TestArWriter_ArReader_RoundTrip(t *testing.T) {
 	require.NoError(t, err)
 	rc.Close()
 	require.Equal(t, bytes.Repeat([]byte("x"), 1024), data.Bytes())
+	// Deflated
+	require.Less(t, entry.CompressedSize(), uint64(1024))
 
 	// Check empty.dat
 	entry = reader.Get("empty.dat")
@@ -173,3 +178,124 @@ func TestArWriter_EmptyArchive(t *testing.T) {
 	entry := reader.Get("any_file")
 	require.Nil(t, entry)
 }
+
+func TestArReader_ValidateNames(t *testing.T) {
+	tmpDir, err := os.MkdirTemp("", "ar_test")
+	require.NoError(t, err)
+	defer os.RemoveAll(tmpDir)
+
+	validActionID := []byte{0xab, 0xcd}
+	var buf bytes.Buffer
+	writer := NewArWriter(&buf)
+	add := func(name string, actionID []byte) {
+		err := writer.Add(name, cache.EntryMeta{
+			ActionID: actionID,
+			OutputID: []byte("output"),
+			Size:     4,
+			Time:     time.Now(),
+		}, []byte("data"))
+		require.NoError(t, err)
+	}
+	add(CacheEntityNameInArchive(validActionID), validActionID)
+	add("not-hex", []byte("action1"))
+	add("0102", []byte{0x01, 0x03}) // Hex, but does not match ActionID
+	require.NoError(t, writer.Close())
+
+	archivePath := filepath.Join(tmpDir, "test.ar")
+	require.NoError(t, os.WriteFile(archivePath, buf.Bytes(), 0644))
+
+	// Without validation, invalid entries are kept but still counted
+	reader, err := NewArReader(archivePath)
+	require.NoError(t, err)
+	require.Equal(t, 2, reader.InvalidNames())
+	require.Len(t, reader.List(), 3)
+	require.NoError(t, reader.Close())
+
+	reader, err = NewArReaderWithOpts(archivePath, ArReaderOpts{ValidateNames: true})
+	require.NoError(t, err)
+	defer reader.Close()
+	require.Equal(t, 2, reader.InvalidNames())
+	require.Equal(t, []string{"abcd"}, reader.List())
+	require.Nil(t, reader.Get("not-hex"))
+	require.Nil(t, reader.Get("0102"))
+}
+
+func TestArReader_TimeMatchesBinaryMeta(t *testing.T) {
+	tmpDir, err := os.MkdirTemp("", "ar_test")
+	require.NoError(t, err)
+	defer os.RemoveAll(tmpDir)
+
+	actionID := []byte{0x01, 0x02}
+	meta := cache.EntryMeta{
+		ActionID: actionID,
+		OutputID: []byte("output"),
+		Size:     4,
+		Time:     time.Now().In(time.FixedZone("UTC-7", -7*3600)),
+	}
+	var buf bytes.Buffer
+	writer := NewArWriter(&buf)
+	require.NoError(t, writer.Add(CacheEntityNameInArchive(actionID), meta, []byte("data")))
+	require.NoError(t, writer.Close())
+	archivePath := filepath.Join(tmpDir, "test.ar")
+	require.NoError(t, os.WriteFile(archivePath, buf.Bytes(), 0644))
+
+	reader, err := NewArReader(archivePath)
+	require.NoError(t, err)
+	defer reader.Close()
+	entry := reader.Get(CacheEntityNameInArchive(actionID))
+	require.NotNil(t, entry)
+
+	// Remote objects carry the meta in binary format
+	var metaBuf bytes.Buffer
+	_, err = meta.WriteTo(&metaBuf)
+	require.NoError(t, err)
+	fromBinary, err := cache.ReadEntryMeta(&metaBuf)
+	require.NoError(t, err)
+
+	require.True(t, meta.Time.Equal(entry.Time))
+	require.Equal(t, fromBinary.Time, entry.Time)
+}
+
+func TestArWriter_Deterministic(t *testing.T) {
+	entries := map[string][]byte{}
+	for i := 0; i < 20; i++ {
+		entries[CacheEntityNameInArchive([]byte{byte(i)})] = bytes.Repeat([]byte{byte(i)}, i)
+	}
+	meta := func(name string) cache.EntryMeta {
+		return cache.EntryMeta{
+			ActionID: []byte(name),
+			OutputID: []byte("output"),
+			Size:     int64(len(entries[name])),
+			Time:     time.Unix(1640995200, 0),
+		}
+	}
+	build := func() []byte {
+		var buf bytes.Buffer
+		writer := NewArWriterWithOpts(&buf, ArWriterOpts{Deterministic: true})
+		for _, name := range slices.Sorted(maps.Keys(entries)) {
+			require.NoError(t, writer.Add(name, meta(name), entries[name]))
+		}
+		require.NoError(t, writer.Close())
+		return buf.Bytes()
+	}
+
+	first := build()
+	for i := 0; i < 5; i++ {
+		require.Equal(t, first, build())
+	}
+
+	// Entries must be added in name order
+	writer := NewArWriterWithOpts(io.Discard, ArWriterOpts{Deterministic: true})
+	later, earlier := CacheEntityNameInArchive([]byte{1}), CacheEntityNameInArchive([]byte{0})
+	require.NoError(t, writer.Add(later, meta(later), entries[later]))
+	require.Error(t, writer.Add(earlier, meta(earlier), entries[earlier]))
+	require.Error(t, writer.Add(later, meta(later), entries[later]))
+
+	tmpDir := t.TempDir()
+	archivePath := filepath.Join(tmpDir, "test.ar")
+	require.NoError(t, os.WriteFile(archivePath, first, 0644))
+	reader, err := NewArReader(archivePath)
+	require.NoError(t, err)
+	defer reader.Close()
+	require.Len(t, reader.List(), len(entries))
+}
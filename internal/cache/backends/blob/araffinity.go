@@ -0,0 +1,81 @@
+package blob
+
+import (
+	"encoding/json"
+	"os"
+	"path/filepath"
+	"sort"
+	"sync/atomic"
+
+	gonanoid "github.com/matoous/go-nanoid/v2"
+)
+
+// arAffinity tracks how frequently each keyspace is accessed. Access counts
+// are carried over sessions (with decay) so that a new daemon knows which
+// keyspaces are likely to be hot before serving any request.
+type arAffinity struct {
+	path    string
+	learned map[string]uint64         // Decayed counts from previous sessions. Read-only after creation.
+	current map[string]*atomic.Uint64 // Counts of this session. Map is read-only after creation.
+}
+
+func newArAffinity(workDir string, keyspaces []string) *arAffinity {
+	a := &arAffinity{
+		path:    AffinityFilePath(workDir),
+		learned: make(map[string]uint64),
+		current: make(map[string]*atomic.Uint64),
+	}
+	for _, keyspace := range keyspaces {
+		a.current[keyspace] = &atomic.Uint64{}
+	}
+	if data, err := os.ReadFile(a.path); err == nil {
+		_ = json.Unmarshal(data, &a.learned)
+	}
+	return a
+}
+
+func (a *arAffinity) Record(keyspace string) {
+	if c, ok := a.current[keyspace]; ok {
+		c.Add(1)
+	}
+}
+
+// Scores returns the access score of each keyspace. Counts from previous
+// sessions are halved so that the score follows recent access patterns.
+func (a *arAffinity) Scores() map[string]uint64 {
+	scores := make(map[string]uint64, len(a.current))
+	for keyspace, c := range a.current {
+		scores[keyspace] = a.learned[keyspace]/2 + c.Load()
+	}
+	return scores
+}
+
+func (a *arAffinity) Save() error {
+	data, err := json.Marshal(a.Scores())
+	if err != nil {
+		return err
+	}
+	_ = os.MkdirAll(filepath.Dir(a.path), 0755)
+	tmpPath := a.path + ".tmp." + gonanoid.Must(8)
+	if err := os.WriteFile(tmpPath, data, 0644); err != nil {
+		return err
+	}
+	return os.Rename(tmpPath, a.path)
+}
+
+// LoadAffinityScores returns the access score of each keyspace persisted in the work dir
+// (when the daemon last stopped), and keyspaces ranked by the score.
+func LoadAffinityScores(workDir string) (map[string]uint64, []string) {
+	learned := newArAffinity(workDir, ArchiveKeyspaces).learned
+	return learned, rankKeyspaces(learned, ArchiveKeyspaces)
+}
+
+// rankKeyspaces sorts keyspaces by score in descending order.
+// Keyspaces with the same score keep their original order.
+func rankKeyspaces(scores map[string]uint64, keyspaces []string) []string {
+	ranked := append([]string(nil), keyspaces...)
+	sort.SliceStable(ranked, func(i, j int) bool {
+		return scores[ranked[i]] > scores[ranked[j]]
+	})
+	return ranked
+}
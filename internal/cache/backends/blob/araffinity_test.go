@@ -0,0 +1,35 @@
+package blob
+
+import (
+	"testing"
+
+	"github.com/stretchr/testify/require"
+)
+
+func TestRankKeyspaces(t *testing.T) {
+	ranked := rankKeyspaces(map[string]uint64{
+		"1": 5,
+		"3": 10,
+	}, []string{"0", "1", "2", "3"})
+	require.Equal(t, []string{"3", "1", "0", "2"}, ranked)
+}
+
+func TestArAffinity_SaveAndLoad(t *testing.T) {
+	workDir := t.TempDir()
+	keyspaces := []string{"0", "1", "2"}
+
+	a := newArAffinity(workDir, keyspaces)
+	for i := 0; i < 10; i++ {
+		a.Record("2")
+	}
+	a.Record("1")
+	a.Record("unknown") // Ignored
+	require.Equal(t, map[string]uint64{"0": 0, "1": 1, "2": 10}, a.Scores())
+	require.NoError(t, a.Save())
+
+	// Counts from previous session are decayed
+	a = newArAffinity(workDir, keyspaces)
+	a.Record("0")
+	require.Equal(t, map[string]uint64{"0": 1, "1": 0, "2": 5}, a.Scores())
+	require.Equal(t, []string{"2", "0", "1"}, rankKeyspaces(a.Scores(), keyspaces))
+}
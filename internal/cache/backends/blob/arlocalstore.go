@@ -8,6 +8,7 @@ import (
 	"runtime"
 	"sync"
 
+	"github.com/breezewish/gscache/internal/util"
 	gonanoid "github.com/matoous/go-nanoid/v2"
 )
 
@@ -17,13 +18,18 @@ import (
 // Put = Write local archive file in workDir and make it available for reading.
 // Get = Read a BlobArchive
 type ArLocalStore struct {
-	workDir string
+	workDir    string
+	readerOpts ArReaderOpts
 
 	mu      sync.RWMutex
 	readers map[string]*ArReader // key=keyspace
 }
 
 func NewArLocalStore(workDir string) (*ArLocalStore, error) {
+	return NewArLocalStoreWithOpts(workDir, ArReaderOpts{})
+}
+
+func NewArLocalStoreWithOpts(workDir string, readerOpts ArReaderOpts) (*ArLocalStore, error) {
 	if workDir == "" {
 		return nil, fmt.Errorf("workDir must be set")
 	}
@@ -33,20 +39,26 @@ func NewArLocalStore(workDir string) (*ArLocalStore, error) {
 		return nil, fmt.Errorf("failed to create directory %s: %w", arDir, err)
 	}
 	return &ArLocalStore{
-		workDir: workDir,
-		readers: make(map[string]*ArReader),
+		workDir:    workDir,
+		readerOpts: readerOpts,
+		readers:    make(map[string]*ArReader),
 	}, nil
 }
 
 func (s *ArLocalStore) LoadLocal(keyspace string) error {
-	filePath := ArchiveFilePath(s.workDir, keyspace)
+	return s.LoadLocalFrom(keyspace, ArchiveFilePath(s.workDir, keyspace))
+}
+
+// LoadLocalFrom is similar to LoadLocal, but loads the archive from the given file path
+// instead of the workDir. The file is read in place and is never modified.
+func (s *ArLocalStore) LoadLocalFrom(keyspace string, filePath string) error {
 	if _, err := os.Stat(filePath); err != nil {
 		if os.IsNotExist(err) {
 			return nil
 		}
 		return err
 	}
-	arReader, err := NewArReader(filePath)
+	arReader, err := NewArReaderWithOpts(filePath, s.readerOpts)
 	if err != nil {
 		// file is broken for some reason
 		return fmt.Errorf("failed to read as BlobArchive %s: %w", filePath, err)
@@ -81,14 +93,14 @@ func (s *ArLocalStore) Put(keyspace string, r io.Reader) error {
 	_ = newFile.Close()
 
 	// 2
-	arReader, err := NewArReader(newFilePathTmp)
+	arReader, err := NewArReaderWithOpts(newFilePathTmp, s.readerOpts)
 	if err != nil {
 		_ = os.Remove(newFilePathTmp)
 		return fmt.Errorf("failed to read as BlobArchive: %w", err)
 	}
 
 	// 3
-	err = os.Rename(newFilePathTmp, newFilePath)
+	err = util.RenameFile(newFilePathTmp, newFilePath)
 	if err != nil {
 		_ = arReader.Close()
 		_ = os.Remove(newFilePathTmp)
@@ -0,0 +1,125 @@
+package blob
+
+import (
+	"encoding/json"
+	"os"
+	"path/filepath"
+	"slices"
+	"sync"
+
+	gonanoid "github.com/matoous/go-nanoid/v2"
+)
+
+// arPurges tracks purged entries which may still be in archives. Such entries are no longer
+// served from archives, and their keyspaces are compacted regardless of the number of new
+// blobs, so that the next archive is built without them (their blobs are deleted). Pending
+// purges are persisted in the work dir until the keyspace is compacted.
+type arPurges struct {
+	path string
+
+	mu      sync.RWMutex
+	pending map[string]map[string]struct{} // Keyspace -> names in archive
+}
+
+func newArPurges(workDir string) *arPurges {
+	p := &arPurges{
+		path:    PurgeFilePath(workDir),
+		pending: make(map[string]map[string]struct{}),
+	}
+	var persisted map[string][]string
+	if data, err := os.ReadFile(p.path); err == nil {
+		_ = json.Unmarshal(data, &persisted)
+	}
+	for keyspace, names := range persisted {
+		for _, name := range names {
+			p.addLocked(keyspace, name)
+		}
+	}
+	return p
+}
+
+func (p *arPurges) addLocked(keyspace, name string) {
+	names := p.pending[keyspace]
+	if names == nil {
+		names = make(map[string]struct{})
+		p.pending[keyspace] = names
+	}
+	names[name] = struct{}{}
+}
+
+// Add records pending purges and persists them.
+func (p *arPurges) Add(keyspace string, names ...string) error {
+	p.mu.Lock()
+	defer p.mu.Unlock()
+	for _, name := range names {
+		p.addLocked(keyspace, name)
+	}
+	return p.saveLocked()
+}
+
+func (p *arPurges) Has(keyspace, name string) bool {
+	p.mu.RLock()
+	defer p.mu.RUnlock()
+	_, ok := p.pending[keyspace][name]
+	return ok
+}
+
+// Prune drops pending purges of the keyspace which are not in the archive, e.g. because the
+// archive has been rebuilt by another compactor, and returns how many are left.
+func (p *arPurges) Prune(keyspace string, ar *ArReader) (int, error) {
+	p.mu.Lock()
+	defer p.mu.Unlock()
+	names := p.pending[keyspace]
+	if len(names) == 0 {
+		return 0, nil
+	}
+	for name := range names {
+		if ar == nil || ar.Get(name) == nil {
+			delete(names, name)
+		}
+	}
+	if len(names) > 0 {
+		return len(names), nil
+	}
+	delete(p.pending, keyspace)
+	return 0, p.saveLocked()
+}
+
+// Clear drops all pending purges of the keyspace, after the archive is rebuilt from blobs.
+func (p *arPurges) Clear(keyspace string) error {
+	p.mu.Lock()
+	defer p.mu.Unlock()
+	if _, ok := p.pending[keyspace]; !ok {
+		return nil
+	}
+	delete(p.pending, keyspace)
+	return p.saveLocked()
+}
+
+func (p *arPurges) saveLocked() error {
+	if len(p.pending) == 0 {
+		if err := os.Remove(p.path); err != nil && !os.IsNotExist(err) {
+			return err
+		}
+		return nil
+	}
+	persisted := make(map[string][]string, len(p.pending))
+	for keyspace, names := range p.pending {
+		list := make([]string, 0, len(names))
+		for name := range names {
+			list = append(list, name)
+		}
+		slices.Sort(list)
+		persisted[keyspace] = list
+	}
+	data, err := json.Marshal(persisted)
+	if err != nil {
+		return err
+	}
+	_ = os.MkdirAll(filepath.Dir(p.path), 0755)
+	tmpPath := p.path + ".tmp." + gonanoid.Must(8)
+	if err := os.WriteFile(tmpPath, data, 0644); err != nil {
+		return err
+	}
+	return os.Rename(tmpPath, p.path)
+}
@@ -0,0 +1,59 @@
+package blob
+
+import (
+	"bytes"
+	"os"
+	"path/filepath"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/require"
+
+	"github.com/breezewish/gscache/internal/cache"
+)
+
+func TestArPurges(t *testing.T) {
+	workDir := t.TempDir()
+
+	p := newArPurges(workDir)
+	require.False(t, p.Has("a", "a001"))
+	require.NoError(t, p.Add("a", "a001"))
+	require.NoError(t, p.Add("a", "a002"))
+	require.NoError(t, p.Add("b", "b001"))
+	require.True(t, p.Has("a", "a001"))
+	require.False(t, p.Has("b", "a001"))
+
+	// Persisted across sessions
+	p = newArPurges(workDir)
+	require.True(t, p.Has("a", "a002"))
+	require.True(t, p.Has("b", "b001"))
+
+	var buf bytes.Buffer
+	w := NewArWriter(&buf)
+	require.NoError(t, w.Add("a001", cache.EntryMeta{
+		ActionID: []byte{0xa0, 0x01},
+		OutputID: []byte{0x01},
+		Size:     4,
+		Time:     time.Now(),
+	}, []byte("data")))
+	require.NoError(t, w.Close())
+	arPath := filepath.Join(t.TempDir(), "a.zip")
+	require.NoError(t, os.WriteFile(arPath, buf.Bytes(), 0644))
+	ar, err := NewArReader(arPath)
+	require.NoError(t, err)
+	defer ar.Close()
+
+	n, err := p.Prune("a", ar)
+	require.NoError(t, err)
+	require.Equal(t, 1, n)
+	require.True(t, p.Has("a", "a001"))
+	require.False(t, p.Has("a", "a002"))
+
+	n, err = p.Prune("b", nil)
+	require.NoError(t, err)
+	require.Equal(t, 0, n)
+	require.NoError(t, p.Clear("a"))
+	require.False(t, p.Has("a", "a001"))
+	_, err = os.Stat(PurgeFilePath(workDir))
+	require.True(t, os.IsNotExist(err))
+}
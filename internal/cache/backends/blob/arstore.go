@@ -3,13 +3,21 @@ package blob
 import (
 	"bytes"
 	"context"
+	"crypto/sha256"
+	"encoding/hex"
 	"fmt"
+	"io"
 	"os"
+	"path/filepath"
+	"slices"
+	"strings"
 	"sync"
 	"time"
 
 	"github.com/breezewish/gscache/internal/log"
+	"github.com/breezewish/gscache/internal/protocol"
 	"github.com/breezewish/gscache/internal/stats"
+	"github.com/breezewish/gscache/internal/util"
 	"go.uber.org/zap"
 	"gocloud.dev/blob"
 	"gocloud.dev/gcerrors"
@@ -20,6 +28,9 @@ const (
 	ArStoreMinSyncInterval = 5 * time.Second
 	ArStoreDownloadTimeout = 10 * time.Second
 	ArStoreUploadTimeout   = 10 * time.Second
+
+	// The SHA256 of the archive content is stored in the metadata of the remote archive.
+	archiveHashMetadataKey = "gscache-sha256"
 )
 
 // ArStore is the major access point for BlobArchive content.
@@ -32,6 +43,16 @@ type ArStore struct {
 
 	muLastSync sync.RWMutex
 	lastSyncAt map[string]time.Time
+
+	affinity  *arAffinity
+	purges    *arPurges
+	coldLoads map[string]*coldLoad // Only contains cold keyspaces. Read-only after creation.
+}
+
+// coldLoad loads a cold keyspace once, when it is firstly needed.
+type coldLoad struct {
+	once sync.Once
+	done chan struct{} // Closed when the load is finished
 }
 
 type ArStoreOpts struct {
@@ -39,50 +60,212 @@ type ArStoreOpts struct {
 	Remote               *blob.Bucket
 	AllPossibleKeyspaces []string
 	SkipInitialSync      bool // If true, skip initial sync from remote to local.
+	// If > 0, only this number of most frequently accessed keyspaces (learned from
+	// previous sessions) are loaded and synced when the store is created. Other
+	// keyspaces are loaded and synced in background when they are firstly accessed.
+	WarmKeyspaces int
+	// Optional. When cancelled, the initial sync is aborted and NewArStore returns an error.
+	Ctx context.Context
+	// Optional. Called when an archive is loaded from local or synced from remote.
+	// It is called synchronously so it should not block.
+	OnArchiveLoaded func(keyspace string, ar *ArReader)
+	// If true, archive entries whose name is not the hex of its ActionID are skipped when loading.
+	ValidateEntryNames bool
+	// Syncing a keyspace from remote is skipped if it was synced within this interval.
+	// If 0, ArStoreMinSyncInterval is used.
+	MinSyncInterval time.Duration
+	// Optional. A directory containing pre-built archives (<keyspace>.zip, the same layout as
+	// blobar/ in the bucket). An archive in this directory is loaded when there is no local copy
+	// synced from remote. If set, Remote can be nil, in which case archives are only read from here.
+	LocalArchiveDir string
+	// Optional. Used for the sync interval. Defaults to the real clock.
+	Clock util.Clock
+	// Optional. If set, archive downloads are accounted and suppressed when the budget is exhausted.
+	egress *egressBudget
+	// Optional. Pending purges shared with the backend. Loaded from WorkDir if nil.
+	purges *arPurges
+}
+
+// validateKeyspaces ensures each keyspace maps to a distinct archive key.
+func validateKeyspaces(keyspaces []string) error {
+	if len(keyspaces) == 0 {
+		return fmt.Errorf("keyspaces must not be empty")
+	}
+	seen := make(map[string]struct{}, len(keyspaces))
+	for _, keyspace := range keyspaces {
+		if len(keyspace) != 1 || !strings.Contains("0123456789abcdef", keyspace) {
+			return fmt.Errorf("invalid keyspace %q, must be a single lowercase hex char", keyspace)
+		}
+		if _, ok := seen[keyspace]; ok {
+			return fmt.Errorf("duplicate keyspace %q", keyspace)
+		}
+		seen[keyspace] = struct{}{}
+	}
+	return nil
 }
 
 func NewArStore(opts ArStoreOpts) (*ArStore, error) {
-	local, err := NewArLocalStore(opts.WorkDir)
+	if err := validateKeyspaces(opts.AllPossibleKeyspaces); err != nil {
+		return nil, fmt.Errorf("invalid AllPossibleKeyspaces: %w", err)
+	}
+	local, err := NewArLocalStoreWithOpts(opts.WorkDir, ArReaderOpts{ValidateNames: opts.ValidateEntryNames})
 	if err != nil {
 		return nil, err
 	}
-	if opts.Remote == nil {
+	if opts.Remote == nil && opts.LocalArchiveDir == "" {
 		return nil, fmt.Errorf("remote bucket must not be nil")
 	}
+	if opts.Ctx == nil {
+		opts.Ctx = context.Background()
+	}
+	if opts.MinSyncInterval <= 0 {
+		opts.MinSyncInterval = ArStoreMinSyncInterval
+	}
+	opts.Clock = util.ClockOrReal(opts.Clock)
+	if opts.purges == nil {
+		opts.purges = newArPurges(opts.WorkDir)
+	}
 	arStore := &ArStore{
 		opts:       opts,
 		local:      local,
 		lastSyncAt: make(map[string]time.Time),
+		affinity:   newArAffinity(opts.WorkDir, opts.AllPossibleKeyspaces),
+		purges:     opts.purges,
+		coldLoads:  make(map[string]*coldLoad),
 	}
-	_ = arStore.ForAllKeyspaces(func(keyspace string) error {
-		defer stats.Default.Persist()
-		stats.Default.BlobArchiveStore.LoadTotal.Inc()
-		if err := local.LoadLocal(keyspace); err != nil {
-			stats.Default.BlobArchiveStore.LoadFail.Inc()
-			log.Warn("Failed to load local BlobArchive",
-				zap.String("keyspace", keyspace),
-				zap.Error(err))
+	warmKeyspaces := opts.AllPossibleKeyspaces
+	if opts.WarmKeyspaces > 0 && opts.WarmKeyspaces < len(opts.AllPossibleKeyspaces) {
+		scores := arStore.affinity.Scores()
+		ranked := rankKeyspaces(scores, opts.AllPossibleKeyspaces)
+		warmKeyspaces = ranked[:opts.WarmKeyspaces]
+		for _, keyspace := range ranked[opts.WarmKeyspaces:] {
+			arStore.coldLoads[keyspace] = &coldLoad{done: make(chan struct{})}
 		}
+		log.Debug("Decided warm BlobArchive keyspaces by access affinity",
+			zap.Strings("warm", warmKeyspaces),
+			zap.Strings("cold", ranked[opts.WarmKeyspaces:]),
+			zap.Any("scores", scores))
+	}
+	_ = forKeyspaces(warmKeyspaces, func(keyspace string) error {
+		arStore.loadLocal(keyspace)
 		return nil
 	})
 	if !opts.SkipInitialSync {
-		_ = arStore.ForAllKeyspaces(func(keyspace string) error {
-			if err := arStore.SyncFromRemote(keyspace); err != nil {
-				log.Warn("failed to sync BlobArchive for keyspace",
-					zap.String("keyspace", keyspace),
-					zap.Error(err),
-					zap.Stack("stack"))
-			}
+		_ = forKeyspaces(warmKeyspaces, func(keyspace string) error {
+			arStore.syncFromRemoteAndLog(opts.Ctx, keyspace)
 			return nil
 		})
+		if err := opts.Ctx.Err(); err != nil {
+			return nil, fmt.Errorf("initial sync is aborted: %w", err)
+		}
 	}
 
 	return arStore, nil
 }
 
+func (s *ArStore) loadLocal(keyspace string) {
+	defer stats.Default.Persist()
+	stats.Default.BlobArchiveStore.LoadTotal.Inc()
+	filePath := ArchiveFilePath(s.opts.WorkDir, keyspace)
+	if s.opts.LocalArchiveDir != "" {
+		// A copy synced from remote is preferred as it is usually newer.
+		if _, err := os.Stat(filePath); os.IsNotExist(err) {
+			filePath = filepath.Join(s.opts.LocalArchiveDir, keyspace+".zip")
+		}
+	}
+	if err := s.local.LoadLocalFrom(keyspace, filePath); err != nil {
+		stats.Default.BlobArchiveStore.LoadFail.Inc()
+		log.Warn("Failed to load local BlobArchive",
+			zap.String("keyspace", keyspace),
+			zap.String("path", filePath),
+			zap.Error(err))
+		return
+	}
+	s.notifyArchiveLoaded(keyspace)
+}
+
+func (s *ArStore) notifyArchiveLoaded(keyspace string) {
+	ar := s.local.Get(keyspace)
+	if ar == nil {
+		return
+	}
+	if n := ar.InvalidNames(); n > 0 {
+		stats.Default.BlobArchiveStore.InvalidEntryNames.Add(uint32(n))
+		log.Warn("BlobArchive contains entries with invalid names, the archive may be tampered",
+			zap.String("keyspace", keyspace),
+			zap.Int("invalidEntries", n),
+			zap.Bool("skipped", s.opts.ValidateEntryNames))
+	}
+	if s.opts.OnArchiveLoaded != nil {
+		s.opts.OnArchiveLoaded(keyspace, ar)
+	}
+}
+
+func (s *ArStore) syncFromRemoteAndLog(ctx context.Context, keyspace string) {
+	if err := s.SyncFromRemoteCtx(ctx, keyspace); err != nil {
+		log.Warn("failed to sync BlobArchive for keyspace",
+			zap.String("keyspace", keyspace),
+			zap.Error(err),
+			zap.Stack("stack"))
+	}
+}
+
+// touchKeyspace records an access to the keyspace. If the keyspace is cold
+// and this is the first access, it will be loaded and synced in background.
+func (s *ArStore) touchKeyspace(keyspace string) {
+	s.affinity.Record(keyspace)
+	s.startColdLoad(keyspace)
+}
+
+// startColdLoad starts loading and syncing the keyspace in background if it is cold and not
+// loaded yet. The returned channel is closed when it is loaded, or is nil if it is not cold.
+func (s *ArStore) startColdLoad(keyspace string) <-chan struct{} {
+	load, ok := s.coldLoads[keyspace]
+	if !ok {
+		return nil
+	}
+	load.once.Do(func() {
+		log.Debug("Cold BlobArchive keyspace is accessed, loading in background",
+			zap.String("keyspace", keyspace))
+		go func() {
+			defer close(load.done)
+			s.loadLocal(keyspace)
+			if !s.opts.SkipInitialSync {
+				s.syncFromRemoteAndLog(context.Background(), keyspace)
+			}
+		}()
+	})
+	return load.done
+}
+
+// EnsureLoaded waits until the keyspace is loaded if it is cold, without recording an access,
+// e.g. before compacting it, as an archive not loaded yet would look empty.
+func (s *ArStore) EnsureLoaded(ctx context.Context, keyspace string) error {
+	done := s.startColdLoad(keyspace)
+	if done == nil {
+		return nil
+	}
+	select {
+	case <-done:
+		return nil
+	case <-ctx.Done():
+		return ctx.Err()
+	}
+}
+
+// SaveAffinity persists the access frequency of keyspaces so that
+// the next session can decide warm keyspaces.
+func (s *ArStore) SaveAffinity() error {
+	return s.affinity.Save()
+}
+
 func (s *ArStore) ForAllKeyspaces(fn func(keyspace string) error) error {
+	return forKeyspaces(s.opts.AllPossibleKeyspaces, fn)
+}
+
+func forKeyspaces(keyspaces []string, fn func(keyspace string) error) error {
 	g := errgroup.Group{}
-	for _, keyspace := range s.opts.AllPossibleKeyspaces {
+	for _, keyspace := range keyspaces {
 		k := keyspace
 		g.Go(func() error {
 			return fn(k)
@@ -93,12 +276,21 @@ func (s *ArStore) ForAllKeyspaces(fn func(keyspace string) error) error {
 
 // SyncFromRemote downloads the latest BlobArchive file from remote storage to local.
 func (s *ArStore) SyncFromRemote(keyspace string) error {
+	return s.SyncFromRemoteCtx(context.Background(), keyspace)
+}
+
+// SyncFromRemoteCtx is the same as SyncFromRemote, but can be cancelled by ctx.
+// It is a no-op if there is no remote.
+func (s *ArStore) SyncFromRemoteCtx(ctx context.Context, keyspace string) error {
+	if s.opts.Remote == nil {
+		return nil
+	}
 	{
 		// Skip syncing this keyspace if it has been synced recently.
 		shouldSkipSync := false
 		s.muLastSync.RLock()
 		lastSync, ok := s.lastSyncAt[keyspace]
-		if ok && time.Since(lastSync) < ArStoreMinSyncInterval {
+		if ok && s.opts.Clock.Since(lastSync) < s.opts.MinSyncInterval {
 			shouldSkipSync = true
 		}
 		s.muLastSync.RUnlock()
@@ -110,7 +302,12 @@ func (s *ArStore) SyncFromRemote(keyspace string) error {
 	defer stats.Default.Persist()
 	stats.Default.BlobArchiveStore.DownloadTotal.Inc()
 
-	ctx, cancel := context.WithTimeout(context.Background(), ArStoreDownloadTimeout)
+	if !s.opts.egress.Allow() {
+		stats.Default.BlobArchiveStore.DownloadSkip.Inc()
+		return nil
+	}
+
+	ctx, cancel := context.WithTimeout(ctx, ArStoreDownloadTimeout)
 	defer cancel()
 	blobReader, err := s.opts.Remote.NewReader(ctx, ArchiveKey(keyspace), nil)
 	if err != nil {
@@ -123,6 +320,7 @@ func (s *ArStore) SyncFromRemote(keyspace string) error {
 	}
 	err = s.local.Put(keyspace, blobReader)
 	_ = blobReader.Close()
+	s.opts.egress.Record(blobReader.Size())
 	if err != nil {
 		stats.Default.BlobArchiveStore.DownloadFail.Inc()
 		return err
@@ -131,9 +329,10 @@ func (s *ArStore) SyncFromRemote(keyspace string) error {
 	stats.Default.BlobArchiveStore.DownloadSuccessBytes.Add(uint64(blobReader.Size()))
 	{
 		s.muLastSync.Lock()
-		s.lastSyncAt[keyspace] = time.Now()
+		s.lastSyncAt[keyspace] = s.opts.Clock.Now()
 		s.muLastSync.Unlock()
 	}
+	s.notifyArchiveLoaded(keyspace)
 	return nil
 }
 
@@ -144,6 +343,9 @@ func (s *ArStore) IngestNewArchive(keyspace string, localFilePath string) error
 		return fmt.Errorf("failed to open %s: %w", localFilePath, err)
 	}
 	defer file.Close()
+	if s.opts.Remote == nil {
+		return fmt.Errorf("cannot ingest archive without a remote")
+	}
 	// First ingest locally to make sure the file is fine.
 	err = s.local.Put(keyspace, file)
 	if err != nil {
@@ -152,37 +354,131 @@ func (s *ArStore) IngestNewArchive(keyspace string, localFilePath string) error
 
 	file2, _ := os.Open(localFilePath)
 	defer file2.Close()
+	hash, err := archiveContentHash(file2)
+	if err != nil {
+		return fmt.Errorf("failed to hash %s: %w", localFilePath, err)
+	}
+	if _, err := file2.Seek(0, io.SeekStart); err != nil {
+		return fmt.Errorf("failed to seek %s: %w", localFilePath, err)
+	}
+
 	ctx, cancel := context.WithTimeout(context.Background(), ArStoreUploadTimeout)
 	defer cancel()
-	err = s.opts.Remote.Upload(
-		ctx,
-		ArchiveKey(keyspace),
-		file2,
-		&blob.WriterOptions{
-			ContentType: "application/octet-stream",
-		})
-	if err != nil {
-		return fmt.Errorf("failed to upload %s to %s: %w", localFilePath, ArchiveKey(keyspace), err)
+	if s.remoteArchiveHash(ctx, keyspace) == hash {
+		// With deterministic archives, compacting the same set of entries results in
+		// the same archive, so the upload can be saved.
+		stats.Default.BlobArchiveStore.UploadSkipUnchanged.Inc()
+		log.Info("BlobArchive content is unchanged, skip upload",
+			zap.String("keyspace", keyspace),
+			zap.String("sha256", hash))
+	} else {
+		err = s.opts.Remote.Upload(
+			ctx,
+			ArchiveKey(keyspace),
+			file2,
+			&blob.WriterOptions{
+				ContentType: "application/octet-stream",
+				Metadata:    map[string]string{archiveHashMetadataKey: hash},
+			})
+		if err != nil {
+			return fmt.Errorf("failed to upload %s to %s: %w", localFilePath, ArchiveKey(keyspace), err)
+		}
 	}
 	{
 		s.muLastSync.Lock()
-		s.lastSyncAt[keyspace] = time.Now()
+		s.lastSyncAt[keyspace] = s.opts.Clock.Now()
 		s.muLastSync.Unlock()
 	}
 	return nil
 }
 
+func archiveContentHash(r io.Reader) (string, error) {
+	h := sha256.New()
+	if _, err := io.Copy(h, r); err != nil {
+		return "", err
+	}
+	return hex.EncodeToString(h.Sum(nil)), nil
+}
+
+// remoteArchiveHash returns the content hash of the remote archive recorded when it was uploaded.
+// Empty is returned if the remote archive does not exist or has no recorded hash.
+func (s *ArStore) remoteArchiveHash(ctx context.Context, keyspace string) string {
+	attrs, err := s.opts.Remote.Attributes(ctx, ArchiveKey(keyspace))
+	if err != nil {
+		return ""
+	}
+	return attrs.Metadata[archiveHashMetadataKey]
+}
+
+// Status returns the status of all keyspaces which have a local archive or have been synced.
+func (s *ArStore) Status() []protocol.ArchiveStatus {
+	result := make([]protocol.ArchiveStatus, 0)
+	s.muLastSync.RLock()
+	defer s.muLastSync.RUnlock()
+	for _, keyspace := range s.opts.AllPossibleKeyspaces {
+		st := protocol.ArchiveStatus{Keyspace: keyspace}
+		if lastSync, ok := s.lastSyncAt[keyspace]; ok {
+			st.LastSyncAt = &lastSync
+		}
+		if r := s.local.Get(keyspace); r != nil {
+			st.Entries = len(r.List())
+		}
+		if fi, err := os.Stat(ArchiveFilePath(s.opts.WorkDir, keyspace)); err == nil {
+			st.SizeBytes = fi.Size()
+		}
+		if st.Entries == 0 && st.SizeBytes == 0 && st.LastSyncAt == nil {
+			continue
+		}
+		result = append(result, st)
+	}
+	return result
+}
+
+// SchedulePurge stops serving the entry from the archive of its keyspace, and removes it from
+// the archive at the next compaction of the keyspace.
+func (s *ArStore) SchedulePurge(actionID []byte) error {
+	return s.purges.Add(CacheEntityKeyspace(actionID), CacheEntityNameInArchive(actionID))
+}
+
+// SchedulePurgeNames is the same as SchedulePurge, for entries of a keyspace by their names
+// in the archive.
+func (s *ArStore) SchedulePurgeNames(keyspace string, names []string) error {
+	return s.purges.Add(keyspace, names...)
+}
+
+// PendingPurges returns how many purged entries are still in the archive of the keyspace.
+func (s *ArStore) PendingPurges(keyspace string) (int, error) {
+	return s.purges.Prune(keyspace, s.local.Get(keyspace))
+}
+
 func (s *ArStore) GetArchive(keyspace string) *ArReader {
 	return s.local.Get(keyspace)
 }
 
+// GetBlob returns the entry in the archive of the keyspace, and records an access to the
+// keyspace for affinity. Only Gets of builds should use it, see LookupBlob.
 func (s *ArStore) GetBlob(keyspace string, actionID []byte) *ArEntry {
+	if !slices.Contains(s.opts.AllPossibleKeyspaces, keyspace) {
+		// Not managed by this store, e.g. handled by another sharded instance.
+		return nil
+	}
+	s.touchKeyspace(keyspace)
+	return s.LookupBlob(keyspace, actionID)
+}
+
+// LookupBlob is the same as GetBlob, but does not record an access, so that maintenance like
+// compaction and warm does not make cold keyspaces look hot.
+func (s *ArStore) LookupBlob(keyspace string, actionID []byte) *ArEntry {
+	if !slices.Contains(s.opts.AllPossibleKeyspaces, keyspace) {
+		return nil
+	}
 	r := s.local.Get(keyspace)
 	if r == nil {
 		return nil
 	}
-	entry := r.Get(CacheEntityNameInArchive(actionID))
-	if entry == nil {
+	name := CacheEntityNameInArchive(actionID)
+	entry := r.Get(name)
+	if entry == nil || s.purges.Has(keyspace, name) {
 		return nil
 	}
 	if !bytes.Equal(entry.ActionID, actionID) {
@@ -0,0 +1,231 @@
+package blob
+
+import (
+	"context"
+	"io"
+	"os"
+	"path/filepath"
+	"testing"
+	"time"
+
+	"github.com/breezewish/gscache/internal/stats"
+	"github.com/breezewish/gscache/internal/util"
+	"github.com/stretchr/testify/require"
+	"gocloud.dev/blob/memblob"
+)
+
+func TestNewArStore_ValidateKeyspaces(t *testing.T) {
+	bucket := memblob.OpenBucket(nil)
+	defer bucket.Close()
+
+	for _, keyspaces := range [][]string{
+		nil,
+		{"0", "1", "1"},
+		{"0", "10"},
+		{"0", "g"},
+		{"A"},
+		{""},
+	} {
+		_, err := NewArStore(ArStoreOpts{
+			WorkDir:              t.TempDir(),
+			Remote:               bucket,
+			AllPossibleKeyspaces: keyspaces,
+			SkipInitialSync:      true,
+		})
+		require.Error(t, err, "%v", keyspaces)
+		require.Contains(t, err.Error(), "invalid AllPossibleKeyspaces")
+	}
+
+	s, err := NewArStore(ArStoreOpts{
+		WorkDir:              t.TempDir(),
+		Remote:               bucket,
+		AllPossibleKeyspaces: ArchiveKeyspaces,
+		SkipInitialSync:      true,
+	})
+	require.NoError(t, err)
+	require.NotNil(t, s)
+}
+
+func TestArStore_MinSyncInterval(t *testing.T) {
+	ctx := context.Background()
+	for _, tc := range []struct {
+		interval      time.Duration
+		expectUpdated bool
+	}{
+		{time.Hour, false},
+		{time.Millisecond, true},
+	} {
+		bucket := memblob.OpenBucket(nil)
+		putArchive := func(entries map[string][]byte) {
+			data, err := io.ReadAll(createBlobar(entries))
+			require.NoError(t, err)
+			require.NoError(t, bucket.WriteAll(ctx, ArchiveKey("a"), data, nil))
+		}
+
+		putArchive(map[string][]byte{"v1": []byte("1")})
+		s, err := NewArStore(ArStoreOpts{
+			WorkDir:              t.TempDir(),
+			Remote:               bucket,
+			AllPossibleKeyspaces: []string{"a"},
+			MinSyncInterval:      tc.interval,
+		})
+		require.NoError(t, err)
+		require.Equal(t, []string{"v1"}, s.GetArchive("a").List())
+
+		putArchive(map[string][]byte{"v2": []byte("2")})
+		time.Sleep(10 * time.Millisecond)
+		require.NoError(t, s.SyncFromRemote("a"))
+		if tc.expectUpdated {
+			require.Equal(t, []string{"v2"}, s.GetArchive("a").List())
+		} else {
+			require.Equal(t, []string{"v1"}, s.GetArchive("a").List())
+		}
+		_ = bucket.Close()
+	}
+}
+
+func TestArStore_IngestNewArchive_SkipUnchanged(t *testing.T) {
+	ctx := context.Background()
+	bucket := memblob.OpenBucket(nil)
+	defer bucket.Close()
+
+	s, err := NewArStore(ArStoreOpts{
+		WorkDir:              t.TempDir(),
+		Remote:               bucket,
+		AllPossibleKeyspaces: []string{"a"},
+		SkipInitialSync:      true,
+	})
+	require.NoError(t, err)
+
+	writeArchive := func(entries map[string][]byte) string {
+		data, err := io.ReadAll(createBlobar(entries))
+		require.NoError(t, err)
+		path := filepath.Join(t.TempDir(), "new.blobar")
+		require.NoError(t, os.WriteFile(path, data, 0644))
+		return path
+	}
+	remoteModTime := func() time.Time {
+		attrs, err := bucket.Attributes(ctx, ArchiveKey("a"))
+		require.NoError(t, err)
+		return attrs.ModTime
+	}
+
+	path := writeArchive(map[string][]byte{"v1": []byte("1")})
+	require.NoError(t, s.IngestNewArchive("a", path))
+	modTime := remoteModTime()
+
+	skipped := stats.Default.BlobArchiveStore.UploadSkipUnchanged.Load()
+	time.Sleep(10 * time.Millisecond)
+	require.NoError(t, s.IngestNewArchive("a", path))
+	require.Equal(t, skipped+1, stats.Default.BlobArchiveStore.UploadSkipUnchanged.Load())
+	require.Equal(t, modTime, remoteModTime())
+
+	require.NoError(t, s.IngestNewArchive("a", writeArchive(map[string][]byte{"v2": []byte("2")})))
+	require.Equal(t, skipped+1, stats.Default.BlobArchiveStore.UploadSkipUnchanged.Load())
+	require.NotEqual(t, modTime, remoteModTime())
+	require.Equal(t, []string{"v2"}, s.GetArchive("a").List())
+}
+
+func TestArStore_LocalArchiveDir(t *testing.T) {
+	arDir := t.TempDir()
+	data, err := io.ReadAll(createBlobar(map[string][]byte{"v1": []byte("1")}))
+	require.NoError(t, err)
+	require.NoError(t, os.WriteFile(filepath.Join(arDir, "a.zip"), data, 0644))
+
+	s, err := NewArStore(ArStoreOpts{
+		WorkDir:              t.TempDir(),
+		AllPossibleKeyspaces: []string{"a", "b"},
+		LocalArchiveDir:      arDir,
+	})
+	require.NoError(t, err)
+	require.Equal(t, []string{"v1"}, s.GetArchive("a").List())
+	require.Nil(t, s.GetArchive("b"))
+	require.NoError(t, s.SyncFromRemote("a"))
+	require.Error(t, s.IngestNewArchive("a", filepath.Join(arDir, "a.zip")))
+
+	// A copy synced from remote is preferred over the pre-built one.
+	workDir := t.TempDir()
+	bucket := memblob.OpenBucket(nil)
+	defer bucket.Close()
+	data, err = io.ReadAll(createBlobar(map[string][]byte{"v2": []byte("2")}))
+	require.NoError(t, err)
+	require.NoError(t, bucket.WriteAll(context.Background(), ArchiveKey("a"), data, nil))
+	_, err = NewArStore(ArStoreOpts{
+		WorkDir:              workDir,
+		Remote:               bucket,
+		AllPossibleKeyspaces: []string{"a"},
+	})
+	require.NoError(t, err)
+	s, err = NewArStore(ArStoreOpts{
+		WorkDir:              workDir,
+		AllPossibleKeyspaces: []string{"a"},
+		LocalArchiveDir:      arDir,
+	})
+	require.NoError(t, err)
+	require.Equal(t, []string{"v2"}, s.GetArchive("a").List())
+}
+
+func TestArStore_MinSyncIntervalWithFakeClock(t *testing.T) {
+	ctx := context.Background()
+	bucket := memblob.OpenBucket(nil)
+	defer bucket.Close()
+	putArchive := func(entries map[string][]byte) {
+		data, err := io.ReadAll(createBlobar(entries))
+		require.NoError(t, err)
+		require.NoError(t, bucket.WriteAll(ctx, ArchiveKey("a"), data, nil))
+	}
+
+	clock := util.NewFakeClock(time.Date(2025, 1, 1, 0, 0, 0, 0, time.UTC))
+	putArchive(map[string][]byte{"v1": []byte("1")})
+	s, err := NewArStore(ArStoreOpts{
+		WorkDir:              t.TempDir(),
+		Remote:               bucket,
+		AllPossibleKeyspaces: []string{"a"},
+		MinSyncInterval:      time.Minute,
+		Clock:                clock,
+	})
+	require.NoError(t, err)
+
+	putArchive(map[string][]byte{"v2": []byte("2")})
+	clock.Advance(59 * time.Second)
+	require.NoError(t, s.SyncFromRemote("a"))
+	require.Equal(t, []string{"v1"}, s.GetArchive("a").List())
+
+	clock.Advance(time.Second)
+	require.NoError(t, s.SyncFromRemote("a"))
+	require.Equal(t, []string{"v2"}, s.GetArchive("a").List())
+	require.Equal(t, clock.Now(), *s.Status()[0].LastSyncAt)
+}
+
+func TestArStore_EnsureLoadedColdKeyspace(t *testing.T) {
+	ctx := context.Background()
+	bucket := memblob.OpenBucket(nil)
+	defer bucket.Close()
+	for _, keyspace := range []string{"a", "b"} {
+		data, err := io.ReadAll(createBlobar(map[string][]byte{"v" + keyspace: []byte("1")}))
+		require.NoError(t, err)
+		require.NoError(t, bucket.WriteAll(ctx, ArchiveKey(keyspace), data, nil))
+	}
+
+	s, err := NewArStore(ArStoreOpts{
+		WorkDir:              t.TempDir(),
+		Remote:               bucket,
+		AllPossibleKeyspaces: []string{"a", "b"},
+		WarmKeyspaces:        1,
+	})
+	require.NoError(t, err)
+	require.Equal(t, []string{"va"}, s.GetArchive("a").List())
+	require.Nil(t, s.GetArchive("b"))
+
+	// Lookups of maintenance do not load the keyspace or count as accesses
+	require.Nil(t, s.LookupBlob("b", []byte{0xb0}))
+	require.Nil(t, s.GetArchive("b"))
+
+	require.NoError(t, s.EnsureLoaded(ctx, "b"))
+	require.Equal(t, []string{"vb"}, s.GetArchive("b").List())
+	require.NoError(t, s.EnsureLoaded(ctx, "a"))
+	require.Equal(t, map[string]uint64{"a": 0, "b": 0}, s.affinity.Scores())
+
+	s.GetBlob("b", []byte{0xb0})
+	require.Equal(t, map[string]uint64{"a": 0, "b": 1}, s.affinity.Scores())
+}
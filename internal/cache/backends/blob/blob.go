@@ -3,19 +3,28 @@ package blob
 import (
 	"bytes"
 	"context"
+	"errors"
 	"fmt"
 	"io"
 	"os"
+	"slices"
+	"strings"
+	"sync"
 	"sync/atomic"
 	"time"
 
 	"github.com/alitto/pond/v2"
 	"github.com/breezewish/gscache/internal/cache"
 	"github.com/breezewish/gscache/internal/cache/backends/local"
+	"github.com/breezewish/gscache/internal/inflight"
 	"github.com/breezewish/gscache/internal/log"
+	"github.com/breezewish/gscache/internal/progress"
 	"github.com/breezewish/gscache/internal/protocol"
 	"github.com/breezewish/gscache/internal/stats"
+	"github.com/breezewish/gscache/internal/tracing"
 	"github.com/breezewish/gscache/internal/util"
+	"go.opentelemetry.io/otel/attribute"
+	"go.opentelemetry.io/otel/trace"
 	"go.uber.org/zap"
 	"gocloud.dev/blob"
 	"gocloud.dev/gcerrors"
@@ -33,44 +42,108 @@ const (
 	MaxDownloadTimeout  = 1 * time.Minute
 	MaxUploadTimeout    = 1 * time.Minute
 	MaxCloseTimeout     = 1 * time.Minute
+	flushPollInterval   = 100 * time.Millisecond
+
+	PrematerializeConcurrency = 4
 )
 
 type BlobBackend struct {
-	config Config
-	log    *zap.Logger
+	config    Config
+	log       *zap.Logger
+	keyspaces []string // Archive keyspaces this instance is responsible for.
+	keyLayout KeyLayout
+
+	closed           atomic.Bool  // When true, new requests will be rejected.
+	lastCompactionAt atomic.Int64 // Unix nano of the last finished compaction, 0 if never.
+	compactionFails  atomic.Int32 // Number of consecutive failed compaction runs.
+	lastCompaction   atomic.Pointer[protocol.CompactionSummary]
+	remoteHealth     remoteHealth
+	egress           *egressBudget // nil if there is no egress budget
+	purges           *arPurges     // Purged entries pending removal from archives
+	lifecycle        context.Context
+	lifecycleClose   context.CancelFunc
+	bucket           *blob.Bucket
+	diskStore        *local.LocalBackend
+	archiveStore     *ArStore // Storing small files in BlobArchive format.
+	uploadQueue      pond.Pool
 
-	closed         atomic.Bool // When true, new requests will be rejected.
-	lifecycle      context.Context
-	lifecycleClose context.CancelFunc
-	bucket         *blob.Bucket
-	diskStore      *local.LocalBackend
-	archiveStore   *ArStore // Storing small files in BlobArchive format.
-	uploadQueue    pond.Pool
+	// Bounds compacting keyspaces across all concurrent compactions, nil if unlimited.
+	compactSem chan struct{}
+	// Keyspace to a slot held while the keyspace is being compacted, so that a keyspace is
+	// compacted by one compaction at a time.
+	compacting map[string]chan struct{}
 
-	sfGet    *util.SingleFlightGroup
-	sfUpload *util.SingleFlightGroup
+	sfGet            *util.SingleFlightGroup
+	sfUpload         *util.SingleFlightGroup
+	sfPrematerialize *util.SingleFlightGroup
+	prematerialized  sync.Map // Keyspace to the version of the archive last prematerialized
+
+	// Opens a remote entry by its key. Replaced in tests to simulate eventually consistent stores.
+	openEntry func(ctx context.Context, key string) (*blob.Reader, error)
 }
 
 var _ cache.BackendSupportCompaction = (*BlobBackend)(nil)
+var _ cache.BackendSupportCompactArchives = (*BlobBackend)(nil)
+var _ cache.BackendSupportExists = (*BlobBackend)(nil)
+var _ cache.BackendSupportStatus = (*BlobBackend)(nil)
+var _ cache.BackendSupportFlush = (*BlobBackend)(nil)
+var _ cache.BackendSupportGC = (*BlobBackend)(nil)
+var _ cache.BackendSupportWarm = (*BlobBackend)(nil)
+var _ cache.BackendSupportDelete = (*BlobBackend)(nil)
+var _ cache.BackendSupportPrefetch = (*BlobBackend)(nil)
+var _ cache.BackendSupportHealth = (*BlobBackend)(nil)
 
 func NewBlobBackend(config Config) (*BlobBackend, error) {
-	if config.URL == "" {
-		return nil, fmt.Errorf("url must be set")
+	if config.URL == "" && config.LocalArchiveDir == "" {
+		return nil, fmt.Errorf("url or localArchiveDir must be set")
 	}
 	if config.WorkDir == "" {
 		return nil, fmt.Errorf("workDir must be set")
 	}
+	if config.EgressBudgetBytes > 0 {
+		if err := validateEgressBudgetPeriod(config.EgressBudgetPeriod); err != nil {
+			return nil, err
+		}
+	}
+	keyspaces := ArchiveKeyspaces
+	if len(config.Keyspaces) > 0 {
+		var err error
+		keyspaces, err = ParseKeyspaces(config.Keyspaces)
+		if err != nil {
+			return nil, fmt.Errorf("invalid keyspaces: %w", err)
+		}
+	}
+	keyLayout, err := ParseKeyLayout(config.KeyLayout)
+	if err != nil {
+		return nil, err
+	}
+	config.Clock = util.ClockOrReal(config.Clock)
+	var compactSem chan struct{}
+	if config.MaxCompactionConcurrency > 0 {
+		compactSem = make(chan struct{}, config.MaxCompactionConcurrency)
+	}
+	compacting := make(map[string]chan struct{}, len(keyspaces))
+	for _, keyspace := range keyspaces {
+		compacting[keyspace] = make(chan struct{}, 1)
+	}
 	return &BlobBackend{
-		config:   config,
-		log:      log.Named("cache.blob"),
-		closed:   atomic.Bool{},
-		sfGet:    util.NewSingleFlightGroup(),
-		sfUpload: util.NewSingleFlightGroup(),
+		config:           config,
+		log:              log.Named("cache.blob"),
+		keyspaces:        keyspaces,
+		keyLayout:        keyLayout,
+		closed:           atomic.Bool{},
+		compactSem:       compactSem,
+		compacting:       compacting,
+		sfGet:            util.NewSingleFlightGroup(),
+		sfUpload:         util.NewSingleFlightGroup(),
+		sfPrematerialize: util.NewSingleFlightGroup(),
 	}, nil
 }
 
 func (store *BlobBackend) Open(ctx context.Context) error {
-	diskStore, err := local.NewLocalBackend(store.config.WorkDir)
+	diskStore, err := local.NewLocalBackendWithOpts(store.config.WorkDir, local.LocalBackendOpts{
+		Clock: store.config.Clock,
+	})
 	if err != nil {
 		return fmt.Errorf("failed to create local disk store: %w", err)
 	}
@@ -80,110 +153,488 @@ func (store *BlobBackend) Open(ctx context.Context) error {
 		return fmt.Errorf("failed to open local disk store: %w", err)
 	}
 
-	b, err := blob.OpenBucket(ctx, store.config.URL)
-	if err != nil {
-		_ = store.diskStore.Close()
-		return err
+	store.egress = newEgressBudget(store.config.WorkDir, store.config.EgressBudgetBytes, store.config.EgressBudgetPeriod)
+	if store.egress != nil {
+		store.egress.now = store.config.Clock.Now
 	}
-	store.bucket = b
+	store.purges = newArPurges(store.config.WorkDir)
 	store.lifecycle, store.lifecycleClose = context.WithCancel(context.Background())
 	store.uploadQueue = pond.NewPool(store.config.UploadConcurrency, pond.WithNonBlocking(true))
 
-	ctx, cancel := context.WithTimeout(store.lifecycle, InitialCheckTimeout)
-	accessOk, err := b.IsAccessible(ctx)
-	cancel()
-	if err != nil || !accessOk {
-		_ = store.diskStore.Close()
-		_ = store.bucket.Close()
+	// Without an URL, only pre-built archives in LocalArchiveDir and the local store are used.
+	if store.config.URL != "" {
+		b, err := blob.OpenBucket(ctx, store.config.URL)
 		if err != nil {
-			return fmt.Errorf("cannot access blob store: %w", err)
-		} else {
-			return fmt.Errorf("blob store is not accessible")
+			_ = store.diskStore.Close()
+			return err
+		}
+		store.bucket = b
+		store.openEntry = func(ctx context.Context, key string) (*blob.Reader, error) {
+			return b.NewReader(ctx, key, nil)
+		}
+
+		checkCtx, cancel := context.WithTimeout(ctx, InitialCheckTimeout)
+		accessOk, err := b.IsAccessible(checkCtx)
+		cancel()
+		if err != nil || !accessOk {
+			_ = store.diskStore.Close()
+			_ = store.bucket.Close()
+			if err != nil {
+				return fmt.Errorf("cannot access blob store: %w", err)
+			} else {
+				return fmt.Errorf("blob store is not accessible")
+			}
 		}
 	}
 
 	archiveStore, err := NewArStore(ArStoreOpts{
 		WorkDir:              store.config.WorkDir,
 		Remote:               store.bucket,
-		AllPossibleKeyspaces: ArchiveKeyspaces,
-		SkipInitialSync:      false,
+		AllPossibleKeyspaces: store.keyspaces,
+		SkipInitialSync:      store.config.SkipInitialArchiveSync,
+		WarmKeyspaces:        store.config.WarmKeyspaces,
+		Ctx:                  ctx,
+		OnArchiveLoaded:      store.onArchiveLoaded,
+		ValidateEntryNames:   store.config.ValidateArchiveEntryNames,
+		MinSyncInterval:      store.config.ArchiveMinSyncInterval,
+		LocalArchiveDir:      store.config.LocalArchiveDir,
+		Clock:                store.config.Clock,
+		egress:               store.egress,
+		purges:               store.purges,
 	})
 	if err != nil {
 		_ = store.diskStore.Close()
-		_ = store.bucket.Close()
+		store.closeBucket()
 		return fmt.Errorf("failed to create BlobArchive store: %w", err)
 	}
 	store.archiveStore = archiveStore
 
-	go func() {
-		// Run compact in parallel with the blob store open.
-		// Compact will be cancelled if the store is closed.
-		store.Compact()
-	}()
+	if !store.config.SkipCompactionOnOpen && store.bucket != nil {
+		go func() {
+			// Run compact in parallel with the blob store open.
+			// Compact will be cancelled if the store is closed.
+			_ = store.Compact()
+		}()
+	}
 
-	store.log.Info("Blob store opened", zap.Any("config", store.config))
+	store.log.Info("Blob store opened", zap.Any("config", util.Redact(store.config)))
 	return nil
 }
 
+func (store *BlobBackend) onArchiveLoaded(keyspace string, ar *ArReader) {
+	if !store.config.PrematerializeArchives {
+		return
+	}
+	// If a pass is already running for this keyspace, simply join it.
+	_ = store.sfPrematerialize.DoChan(keyspace, func() (any, error) {
+		store.prematerializeArchive(keyspace, ar)
+		return nil, nil
+	})
+}
+
+// prematerializeArchive copies entries of the archive to the local store, so that
+// later Get does not need to extract them on demand. Most recent entries are
+// copied first, since they are more likely to be used.
+func (store *BlobBackend) prematerializeArchive(keyspace string, ar *ArReader) {
+	// Archives are loaded again after each sync even if unchanged
+	version := ar.Version()
+	if last, ok := store.prematerialized.Load(keyspace); ok && last == version {
+		return
+	}
+	t := time.Now()
+	entries := make([]*ArEntry, 0)
+	for _, name := range ar.List() {
+		if store.purges.Has(keyspace, name) {
+			continue
+		}
+		// Empty entries are always served from memory, no need to copy.
+		if entry := ar.Get(name); entry != nil && entry.Size > 0 {
+			entries = append(entries, entry)
+		}
+	}
+	slices.SortFunc(entries, func(a, b *ArEntry) int {
+		return b.Time.Compare(a.Time)
+	})
+
+	var nFiles, nBytes atomic.Int64
+	var g errgroup.Group
+	g.SetLimit(PrematerializeConcurrency)
+	budget := store.config.PrematerializeMaxBytes
+	for _, entry := range entries {
+		if store.lifecycle.Err() != nil {
+			break
+		}
+		if budget < entry.Size {
+			// Smaller entries after it may still fit
+			continue
+		}
+		budget -= entry.Size
+		g.Go(func() error {
+			copied, err := store.materializeArEntry(entry)
+			if err != nil {
+				store.log.Debug("Failed to prematerialize archive entry",
+					zap.String("keyspace", keyspace),
+					zap.String("actionID", fmt.Sprintf("%x", entry.ActionID)),
+					zap.Error(err))
+			} else if copied {
+				nFiles.Add(1)
+				nBytes.Add(entry.Size)
+			}
+			return nil
+		})
+	}
+	_ = g.Wait()
+	if store.lifecycle.Err() == nil {
+		store.prematerialized.Store(keyspace, version)
+	}
+
+	stats.Default.BlobArchiveStore.PrematerializeFiles.Add(uint32(nFiles.Load()))
+	stats.Default.BlobArchiveStore.PrematerializeBytes.Add(uint64(nBytes.Load()))
+	stats.Default.Persist()
+	store.log.Info("Prematerialized BlobArchive entries",
+		zap.String("keyspace", keyspace),
+		zap.Int("entries", len(entries)),
+		zap.Int64("copiedFiles", nFiles.Load()),
+		zap.Int64("copiedBytes", nBytes.Load()),
+		zap.String("cost", time.Since(t).String()))
+}
+
+// materializeArEntry copies the archive entry to the local store if it does not exist locally.
+func (store *BlobBackend) materializeArEntry(entry *ArEntry) (bool /* copied */, error) {
+	localResp, err := store.diskStore.Get(cache.GetOpts{
+		Req: protocol.GetRequest{ActionID: entry.ActionID},
+	})
+	if err != nil {
+		return false, err
+	}
+	if !localResp.Miss {
+		return false, nil
+	}
+	r, err := entry.Open()
+	if err != nil {
+		return false, err
+	}
+	defer r.Close()
+	_, err = store.diskStore.Put(cache.PutOpts{
+		Req: protocol.PutRequest{
+			ActionID: entry.ActionID,
+			OutputID: entry.OutputID,
+			BodySize: entry.Size,
+		},
+		Body:         r,
+		OverrideTime: &entry.Time,
+	})
+	if err != nil {
+		return false, err
+	}
+	return true, nil
+}
+
 func (store *BlobBackend) Compact() error {
+	return store.CompactWithOpts(CompactOpts{})
+}
+
+type CompactOpts struct {
+	// Keyspaces to compact. If empty, all keyspaces are compacted.
+	Keyspaces []string
+	// Rebuild treats existing archives as empty, so that archives are rebuilt from
+	// all current small blobs and overwritten, regardless of CompactionAtLeastAddFiles.
+	Rebuild bool
+	// DryRun only reports what would be compacted, see CompactionJobOpts.DryRun.
+	DryRun bool
+	// Progress counts finished keyspaces and bytes of added blobs, if set.
+	Progress *progress.Tracker
+}
+
+// CompactWithOpts runs compaction for the given keyspaces in parallel, at most
+// MaxCompactionConcurrency keyspaces at a time across all running compactions if configured.
+// A keyspace being compacted by another compaction is compacted after it finishes.
+// Returns the first error if any keyspace compaction failed.
+func (store *BlobBackend) CompactWithOpts(opts CompactOpts) error {
+	_, err := store.CompactWithReport(opts)
+	return err
+}
+
+// CompactWithReport is similar to CompactWithOpts, but also returns the result of each keyspace,
+// in the order of opts.Keyspaces. The report is nil if opts are invalid.
+func (store *BlobBackend) CompactWithReport(opts CompactOpts) (*protocol.CompactResponse, error) {
 	if store.closed.Load() {
-		return fmt.Errorf("blob store is closed")
+		return nil, fmt.Errorf("blob store is closed")
+	}
+	if store.bucket == nil {
+		return nil, fmt.Errorf("compaction requires a remote blob store")
 	}
-	store.log.Info("Start parallel compaction")
+	keyspaces := opts.Keyspaces
+	if len(keyspaces) == 0 {
+		keyspaces = store.keyspaces
+	}
+	for _, keyspace := range keyspaces {
+		if !slices.Contains(ArchiveKeyspaces, keyspace) {
+			return nil, fmt.Errorf("invalid keyspace %q", keyspace)
+		}
+		if !slices.Contains(store.keyspaces, keyspace) {
+			return nil, fmt.Errorf("keyspace %q is not managed by this instance", keyspace)
+		}
+	}
+	store.log.Info("Start parallel compaction",
+		zap.Strings("keyspaces", keyspaces),
+		zap.Bool("rebuild", opts.Rebuild),
+		zap.Bool("dryRun", opts.DryRun),
+		zap.Int("maxConcurrency", store.config.MaxCompactionConcurrency))
+	resp := &protocol.CompactResponse{
+		Keyspaces: make([]protocol.KeyspaceCompaction, len(keyspaces)),
+	}
+	startedAt := store.config.Clock.Now()
+	opts.Progress.SetStage("keyspaces", int64(len(keyspaces)), 0)
 	var g errgroup.Group
-	for _, keyspacex := range ArchiveKeyspaces {
-		keyspace := keyspacex
+	for i, keyspace := range keyspaces {
 		g.Go(func() error {
+			release, err := store.acquireCompaction(keyspace)
+			if err != nil {
+				resp.Keyspaces[i] = protocol.KeyspaceCompaction{Keyspace: keyspace, Error: err.Error()}
+				return err
+			}
+			defer release()
+			defer inflight.Default.Start(inflight.KindCompaction, "keyspace "+keyspace)()
 			job := NewCompactionJob(CompactionJobOpts{
-				Keyspace:    keyspace,
-				BlobArStore: store.archiveStore,
-				BlobCache:   store,
-				Remote:      store.bucket,
-				Ctx:         store.lifecycle,
+				Keyspace:             keyspace,
+				BlobArStore:          store.archiveStore,
+				BlobCache:            store,
+				Remote:               store.bucket,
+				Ctx:                  store.lifecycle,
+				Rebuild:              opts.Rebuild,
+				ListConcurrency:      store.config.CompactionListConcurrency,
+				DeterministicArchive: store.config.DeterministicArchives,
+				KeyLayout:            store.keyLayout,
+				DryRun:               opts.DryRun,
 			})
-			job.Work()
-			return nil
+			err = job.Work()
+			resp.Keyspaces[i] = job.result(err)
+			opts.Progress.Add(1, resp.Keyspaces[i].AddedBytes)
+			return err
 		})
 	}
-	_ = g.Wait()
+	err := g.Wait()
+	if opts.DryRun {
+		return resp, err
+	}
+	store.lastCompactionAt.Store(store.config.Clock.Now().UnixNano())
+	store.lastCompaction.Store(summarizeCompaction(resp, startedAt, store.config.Clock.Since(startedAt)))
+	store.recordCompactionResult(err)
 	store.log.Info("Parallel compaction finished")
-	return nil
+	if len(opts.Keyspaces) == 0 {
+		// Also reclaim local outputs leaked by re-puts, which is cheap compared to the compaction.
+		if err := store.diskStore.Compact(); err != nil {
+			store.log.Warn("Failed to remove orphaned local output files", zap.Error(err))
+		}
+	}
+	return resp, err
+}
+
+// acquireCompaction waits until the keyspace is not being compacted by another compaction, e.g.
+// the periodic one and an on-demand one, and a slot of MaxCompactionConcurrency is available.
+func (store *BlobBackend) acquireCompaction(keyspace string) (release func(), err error) {
+	compacting := store.compacting[keyspace]
+	select {
+	case compacting <- struct{}{}:
+	case <-store.lifecycle.Done():
+		return nil, store.lifecycle.Err()
+	}
+	if store.compactSem != nil {
+		select {
+		case store.compactSem <- struct{}{}:
+		case <-store.lifecycle.Done():
+			<-compacting
+			return nil, store.lifecycle.Err()
+		}
+	}
+	return func() {
+		if store.compactSem != nil {
+			<-store.compactSem
+		}
+		<-compacting
+	}, nil
+}
+
+// CompactArchives runs compaction on demand, e.g. requested via the daemon API.
+// Failures of keyspaces are reported in the response instead of the error.
+func (store *BlobBackend) CompactArchives(ctx context.Context, req protocol.CompactRequest) (*protocol.CompactResponse, error) {
+	resp, err := store.CompactWithReport(CompactOpts{
+		Keyspaces: req.Keyspaces,
+		Rebuild:   req.Rebuild,
+		DryRun:    req.DryRun,
+		Progress:  progress.FromContext(ctx),
+	})
+	if resp != nil {
+		return resp, nil
+	}
+	return nil, err
+}
+
+func summarizeCompaction(resp *protocol.CompactResponse, startedAt time.Time, elapsed time.Duration) *protocol.CompactionSummary {
+	sum := &protocol.CompactionSummary{
+		StartedAt: startedAt,
+		Duration:  elapsed.Round(time.Millisecond).String(),
+		Keyspaces: len(resp.Keyspaces),
+	}
+	for _, ks := range resp.Keyspaces {
+		switch {
+		case ks.Error != "":
+			sum.Failed++
+			if sum.Error == "" {
+				sum.Error = ks.Keyspace + ": " + ks.Error
+			}
+		case ks.Skipped:
+			sum.Skipped++
+		default:
+			sum.Compacted++
+			sum.AddedBlobs += ks.AddedBlobs
+			sum.AddedBytes += ks.AddedBytes
+			sum.RemovedBlobs += ks.RemovedBlobs
+		}
+	}
+	return sum
+}
+
+// recordCompactionResult tracks consecutive failed compaction runs and alerts when
+// CompactionFailureAlertThreshold is reached.
+func (store *BlobBackend) recordCompactionResult(err error) {
+	if err == nil {
+		if fails := store.compactionFails.Swap(0); store.isCompactionUnhealthy(fails) {
+			store.log.Info("Compaction recovered", zap.Int32("previousConsecutiveFailures", fails))
+		}
+		return
+	}
+	if fails := store.compactionFails.Add(1); store.isCompactionUnhealthy(fails) {
+		store.log.Error("ALERT: Compaction keeps failing, archives are not updated",
+			zap.Int32("consecutiveFailures", fails),
+			zap.Error(err))
+	}
+}
+
+func (store *BlobBackend) isCompactionUnhealthy(consecutiveFails int32) bool {
+	threshold := store.config.CompactionFailureAlertThreshold
+	return threshold > 0 && consecutiveFails >= int32(threshold)
+}
+
+func (store *BlobBackend) Status() protocol.BackendStatus {
+	st := protocol.BackendStatus{
+		Archives: make([]protocol.ArchiveStatus, 0),
+	}
+	if store.uploadQueue != nil {
+		st.UploadQueueRunning = store.uploadQueue.RunningWorkers()
+		st.UploadQueueWaiting = store.uploadQueue.WaitingTasks()
+	}
+	if ts := store.lastCompactionAt.Load(); ts != 0 {
+		t := time.Unix(0, ts)
+		st.LastCompactionAt = &t
+	}
+	st.CompactionConsecutiveFailures = store.compactionFails.Load()
+	st.CompactionUnhealthy = store.isCompactionUnhealthy(st.CompactionConsecutiveFailures)
+	st.LastCompaction = store.lastCompaction.Load()
+	if store.archiveStore != nil {
+		st.Archives = store.archiveStore.Status()
+	}
+	return st
 }
 
 func (store *BlobBackend) Get(opts cache.GetOpts) (*protocol.GetResponse, error) {
+	return store.getWithOpts(opts, false)
+}
+
+// getWithOpts is the same as Get, but allows skipping the archive store, so that
+// archive rebuild does not read from the archive being rebuilt.
+func (store *BlobBackend) getWithOpts(opts cache.GetOpts, skipArchive bool) (*protocol.GetResponse, error) {
 	if store.closed.Load() {
 		return nil, fmt.Errorf("blob store is closed")
 	}
+	if err := opts.Req.Validate(); err != nil {
+		return nil, fmt.Errorf("invalid Get request: %w", err)
+	}
+
+	ctx, span := tracing.Start(opts.Context(), "blob.Get",
+		attribute.String("keyspace", CacheEntityKeyspace(opts.Req.ActionID)))
+	defer span.End()
+	opts.Ctx = ctx
 
-	resp, err, _ := store.sfGet.Do(string(opts.Req.ActionID), func() (any, error) {
-		return store.get(opts)
+	sfKey := cache.EntryKey(opts.Req.Namespace, opts.Req.ActionID)
+	if skipArchive {
+		sfKey = "skipArchive:" + sfKey
+	}
+	resp, err, _ := store.sfGet.Do(sfKey, func() (any, error) {
+		return store.get(opts, skipArchive)
 	})
 
+	if err != nil && opts.IsInCompaction && errors.Is(err, errEgressBudgetExhausted) {
+		// Not a miss, see errEgressBudgetExhausted
+		return nil, err
+	}
 	if err != nil {
+		span.RecordError(err)
 		store.log.Warn("Get cache entry from blob store failed",
 			zap.String("actionID", fmt.Sprintf("%x", opts.Req.ActionID)),
-			zap.String("object", CacheEntityKey(opts.Req.ActionID)),
+			zap.String("object", store.keyLayout.EntityKey(opts.Req.Namespace, opts.Req.ActionID)),
 			zap.Error(err))
 		return &protocol.GetResponse{Miss: true}, nil
 	}
-	return resp.(*protocol.GetResponse), nil
+	getResp := resp.(*protocol.GetResponse)
+	if !getResp.Miss {
+		// Responses are shared by concurrent requests via single flight, so the
+		// per-request empty file is prepared here.
+		diskPath, err := store.emptyFileForRequest(getResp.DiskPath, getResp.Size)
+		if err != nil {
+			return nil, err
+		}
+		if diskPath != getResp.DiskPath {
+			respCopy := *getResp
+			respCopy.DiskPath = diskPath
+			getResp = &respCopy
+		}
+	}
+	return getResp, nil
 }
 
-func (store *BlobBackend) get(opts cache.GetOpts) (*protocol.GetResponse, error) {
-	if len(opts.Req.ActionID) == 0 {
-		return nil, fmt.Errorf("actionID must be specified in GetRequest")
+// emptyFileForRequest returns a new empty file for zero-size entries if PerRequestEmptyFile
+// is set, otherwise the diskPath as is.
+func (store *BlobBackend) emptyFileForRequest(diskPath string, size int64) (string, error) {
+	if !store.config.PerRequestEmptyFile || size != 0 {
+		return diskPath, nil
 	}
+	return store.diskStore.NewEmptyOutputFile()
+}
 
+// getBlobMetrics returns the metrics the Get is counted in, so that only Gets of builds are
+// counted as organic.
+func getBlobMetrics(opts cache.GetOpts) *stats.BlobMetrics {
+	if opts.IsWarm {
+		return &stats.Default.BlobWarm
+	}
+	return stats.Default.GetBlobMetrics(opts.IsInCompaction)
+}
+
+func (store *BlobBackend) get(opts cache.GetOpts, skipArchive bool) (*protocol.GetResponse, error) {
 	defer stats.Default.Persist()
 
-	arEntry := store.archiveStore.GetBlob(CacheEntityKeyspace(opts.Req.ActionID), opts.Req.ActionID)
+	// Archives are only built for the default namespace.
+	var arEntry *ArEntry
+	if opts.Req.Namespace == "" && !skipArchive {
+		keyspace := CacheEntityKeyspace(opts.Req.ActionID)
+		if opts.IsInCompaction || opts.IsWarm {
+			// Only Gets of builds are counted for affinity
+			arEntry = store.archiveStore.LookupBlob(keyspace, opts.Req.ActionID)
+		} else {
+			arEntry = store.archiveStore.GetBlob(keyspace, opts.Req.ActionID)
+		}
+	}
 	if arEntry != nil && arEntry.Size == 0 {
 		// Fast path: We can serve from archive store in-memory directly.
 		outputPath, err := store.diskStore.EnsureEmptyOutputFile()
 		if err != nil {
 			return nil, fmt.Errorf("failed to prepare empty output file: %w", err)
 		}
-		stats.Default.GetBlobMetrics(opts.IsInCompaction).GetByArchive.Inc()
+		getBlobMetrics(opts).GetByArchive.Inc()
+		getBlobMetrics(opts).GetByArchiveBytes.Add(uint64(arEntry.Size))
+		setServedFrom(opts.Ctx, "archive", arEntry.Size)
 		return &protocol.GetResponse{
 			Miss:     false,
 			OutputID: arEntry.OutputID,
@@ -198,7 +649,9 @@ func (store *BlobBackend) get(opts cache.GetOpts) (*protocol.GetResponse, error)
 		return nil, err
 	}
 	if !diskResp.Miss {
-		stats.Default.GetBlobMetrics(opts.IsInCompaction).GetByLocal.Inc()
+		getBlobMetrics(opts).GetByLocal.Inc()
+		getBlobMetrics(opts).GetByLocalBytes.Add(uint64(diskResp.Size))
+		setServedFrom(opts.Ctx, "local", diskResp.Size)
 		return diskResp, nil
 	}
 
@@ -210,11 +663,13 @@ func (store *BlobBackend) get(opts cache.GetOpts) (*protocol.GetResponse, error)
 		}
 		putResp, err := store.diskStore.Put(cache.PutOpts{
 			Req: protocol.PutRequest{
-				ActionID: arEntry.ActionID,
-				OutputID: arEntry.OutputID,
-				BodySize: arEntry.Size,
+				ActionID:  arEntry.ActionID,
+				OutputID:  arEntry.OutputID,
+				BodySize:  arEntry.Size,
+				Namespace: opts.Req.Namespace,
 			},
 			Body:           zipFileHandle,
+			Ctx:            opts.Ctx,
 			OverrideTime:   &arEntry.Time,
 			IsInCompaction: opts.IsInCompaction,
 		})
@@ -222,9 +677,11 @@ func (store *BlobBackend) get(opts cache.GetOpts) (*protocol.GetResponse, error)
 		if err != nil {
 			return nil, fmt.Errorf("failed to put archive entry in disk store: %w", err)
 		}
-		stats.Default.GetBlobMetrics(opts.IsInCompaction).GetByArchive.Inc()
-		stats.Default.GetBlobMetrics(opts.IsInCompaction).ArchiveToLocalFiles.Inc() // Later GET will be served from local disk store.
-		stats.Default.GetBlobMetrics(opts.IsInCompaction).ArchiveToLocalBytes.Add(uint64(arEntry.Size))
+		getBlobMetrics(opts).GetByArchive.Inc()
+		getBlobMetrics(opts).GetByArchiveBytes.Add(uint64(arEntry.Size))
+		getBlobMetrics(opts).ArchiveToLocalFiles.Inc() // Later GET will be served from local disk store.
+		getBlobMetrics(opts).ArchiveToLocalBytes.Add(uint64(arEntry.Size))
+		setServedFrom(opts.Ctx, "archive", arEntry.Size)
 		return &protocol.GetResponse{
 			Miss:     false,
 			OutputID: arEntry.OutputID,
@@ -234,26 +691,47 @@ func (store *BlobBackend) get(opts cache.GetOpts) (*protocol.GetResponse, error)
 		}, nil
 	}
 
+	if store.bucket == nil {
+		setServedFrom(opts.Ctx, "miss", 0)
+		return &protocol.GetResponse{Miss: true}, nil
+	}
+
+	if !store.egress.Allow() {
+		if opts.IsInCompaction {
+			return nil, errEgressBudgetExhausted
+		}
+		store.log.Debug("Miss in blob store because egress budget is exhausted",
+			zap.String("actionID", fmt.Sprintf("%x", opts.Req.ActionID)))
+		setServedFrom(opts.Ctx, "miss", 0)
+		return &protocol.GetResponse{Miss: true}, nil
+	}
+
 	t := time.Now()
 
+	_, span := tracing.Start(opts.Ctx, "blob.Download")
+	defer span.End()
+
 	ctx, cancel := context.WithTimeout(store.lifecycle, MaxDownloadTimeout)
 	defer cancel()
 
-	r, err := store.bucket.NewReader(ctx, CacheEntityKey(opts.Req.ActionID), nil)
+	r, err := store.newEntryReader(ctx, opts)
 	if err != nil {
 		if gcerrors.Code(err) == gcerrors.NotFound {
 			store.log.Debug("Miss in blob store",
 				zap.String("actionID", fmt.Sprintf("%x", opts.Req.ActionID)))
+			setServedFrom(opts.Ctx, "miss", 0)
 			return &protocol.GetResponse{Miss: true}, nil
 		}
+		span.RecordError(err)
 		return nil, err
 	}
 	defer r.Close()
+	defer store.egress.Record(r.Size())
 
 	// the header part of r is our entry metadata
 	// the remaining part is the cache data
 
-	stats.Default.GetBlobMetrics(opts.IsInCompaction).GetByDownload.Inc()
+	getBlobMetrics(opts).GetByDownload.Inc()
 	meta, err := cache.ReadEntryMeta(r)
 	if err != nil {
 		return nil, fmt.Errorf("failed to read entry metadata: %w", err)
@@ -264,24 +742,42 @@ func (store *BlobBackend) get(opts cache.GetOpts) (*protocol.GetResponse, error)
 
 	diskPutResp, err := store.diskStore.Put(cache.PutOpts{
 		Req: protocol.PutRequest{
-			ActionID: meta.ActionID,
-			OutputID: meta.OutputID,
-			BodySize: meta.Size,
+			ActionID:  meta.ActionID,
+			OutputID:  meta.OutputID,
+			BodySize:  meta.Size,
+			Namespace: opts.Req.Namespace,
 		},
 		Body:           r,
+		Ctx:            opts.Ctx,
 		OverrideTime:   &meta.Time,
 		IsInCompaction: opts.IsInCompaction,
 	})
 	if err != nil {
+		if errors.Is(err, local.ErrBodyTruncated) || errors.Is(err, local.ErrBodyTooLong) {
+			// The remote object does not match its metadata, which will not heal by retrying.
+			// Treat it as a miss so that the build rebuilds and overwrites it.
+			store.log.Warn("Corrupted object in blob store, treat as miss",
+				zap.String("object", store.keyLayout.EntityKey(opts.Req.Namespace, opts.Req.ActionID)),
+				zap.Error(err))
+			setServedFrom(opts.Ctx, "miss", 0)
+			return &protocol.GetResponse{Miss: true}, nil
+		}
 		return nil, fmt.Errorf("failed to put entry in disk store: %w", err)
 	}
 
-	stats.Default.GetBlobMetrics(opts.IsInCompaction).DownloadBytes.Add(uint64(meta.Size))
+	getBlobMetrics(opts).DownloadBytes.Add(uint64(meta.Size))
+	getBlobMetrics(opts).GetByDownloadBytes.Add(uint64(meta.Size))
+	span.SetAttributes(attribute.Int64("bytes", meta.Size))
+	setServedFrom(opts.Ctx, "download", meta.Size)
 
+	store.logIfSlow("Slow download from blob store", time.Since(t),
+		zap.String("actionID", fmt.Sprintf("%x", opts.Req.ActionID)),
+		zap.String("servedFrom", "download"),
+		zap.Int64("size", meta.Size))
 	store.log.Debug("Hit and downloaded file from blob store",
 		zap.String("cost", time.Since(t).String()),
 		zap.String("actionID", fmt.Sprintf("%x", opts.Req.ActionID)),
-		zap.String("object", CacheEntityKey(opts.Req.ActionID)),
+		zap.String("object", store.keyLayout.EntityKey(opts.Req.Namespace, opts.Req.ActionID)),
 		zap.String("dataPath", diskPutResp.DiskPath),
 		zap.Int64("size", meta.Size))
 
@@ -298,15 +794,43 @@ func (store *BlobBackend) Put(opts cache.PutOpts) (*protocol.PutResponse, error)
 	if store.closed.Load() {
 		return nil, fmt.Errorf("blob store is closed")
 	}
+	if err := opts.Req.Validate(); err != nil {
+		return nil, fmt.Errorf("invalid Put request: %w", err)
+	}
+
+	ctx, span := tracing.Start(opts.Context(), "blob.Put",
+		attribute.String("keyspace", CacheEntityKeyspace(opts.Req.ActionID)),
+		attribute.Int64("bytes", opts.Req.BodySize))
+	defer span.End()
+	opts.Ctx = ctx
 
 	// First make the file available locally, then we can do upload in background and return immediately.
 	diskPutResp, err := store.diskStore.Put(opts)
 	if err != nil {
 		return nil, fmt.Errorf("failed to put entry in disk store: %w", err)
 	}
+	if existing := diskPutResp.Existing; existing != nil {
+		// The local file is the existing entry, so upload it with its own metadata,
+		// instead of the metadata of the discarded body.
+		opts.Req.OutputID = existing.OutputID
+		opts.Req.BodySize = existing.Size
+		opts.OverrideTime = existing.Time
+	}
+
+	respDiskPath, err := store.emptyFileForRequest(diskPutResp.DiskPath, opts.Req.BodySize)
+	if err != nil {
+		return nil, err
+	}
+
+	if store.bucket == nil {
+		// Without a remote, entries are only kept in the local store.
+		return &protocol.PutResponse{
+			DiskPath: respDiskPath,
+		}, nil
+	}
 
 	// Do dedup until the upload is finished in background.
-	_ = store.sfUpload.DoChan(string(opts.Req.ActionID), func() (any, error) {
+	_ = store.sfUpload.DoChan(cache.EntryKey(opts.Req.Namespace, opts.Req.ActionID), func() (any, error) {
 		task := store.uploadQueue.Submit(func() {
 			store.doBgUpload(opts, diskPutResp.DiskPath)
 		})
@@ -315,15 +839,18 @@ func (store *BlobBackend) Put(opts cache.PutOpts) (*protocol.PutResponse, error)
 	})
 
 	return &protocol.PutResponse{
-		DiskPath: diskPutResp.DiskPath,
+		DiskPath: respDiskPath,
 	}, nil
 }
 
 func (store *BlobBackend) doBgUpload(putOpts cache.PutOpts, payloadPathOnDisk string) {
-	objName := CacheEntityKey(putOpts.Req.ActionID)
+	objName := store.keyLayout.EntityKey(putOpts.Req.Namespace, putOpts.Req.ActionID)
+	defer inflight.Default.Start(inflight.KindUpload, objName)()
 	t := time.Now()
 
+	var span trace.Span
 	logError := func(msg string, err error) {
+		span.RecordError(err)
 		store.log.Error(msg,
 			zap.String("actionID", fmt.Sprintf("%x", putOpts.Req.ActionID)),
 			zap.String("object", objName),
@@ -334,18 +861,44 @@ func (store *BlobBackend) doBgUpload(putOpts cache.PutOpts, payloadPathOnDisk st
 	// Note that the real upload file should first contain the metadata header,
 	// and then the payload data (bodyPathOnDisk).
 
+	// Note: putOpts.Ctx is only used as the parent span. Upload happens in background and
+	// should not be cancelled when the original request finishes.
+	_, span = tracing.Start(putOpts.Context(), "blob.Upload",
+		attribute.String("keyspace", CacheEntityKeyspace(putOpts.Req.ActionID)),
+		attribute.Int64("bytes", putOpts.Req.BodySize))
+	defer span.End()
+
 	ctx, cancel := context.WithTimeout(store.lifecycle, MaxUploadTimeout)
 	defer cancel()
 
+	if putOpts.Req.IfAbsent {
+		exists, err := store.existsRemotely(ctx, putOpts.Req.Namespace, putOpts.Req.ActionID)
+		if err != nil {
+			// Not fatal, we just upload anyway
+			store.log.Warn("Failed to check entry existence, upload anyway",
+				zap.String("actionID", fmt.Sprintf("%x", putOpts.Req.ActionID)),
+				zap.String("object", objName),
+				zap.Error(err))
+		} else if exists {
+			stats.Default.GetBlobMetrics(putOpts.IsInCompaction).UploadSkipExists.Inc()
+			stats.Default.Persist()
+			store.log.Debug("Skip upload, entry already exists in blob store",
+				zap.String("actionID", fmt.Sprintf("%x", putOpts.Req.ActionID)),
+				zap.String("object", objName))
+			return
+		}
+	}
+
 	meta := cache.EntryMeta{
 		ActionID: putOpts.Req.ActionID,
 		OutputID: putOpts.Req.OutputID,
 		Size:     putOpts.Req.BodySize,
-		Time:     time.Now(),
+		Time:     store.config.Clock.Now(),
 	}
 	if putOpts.OverrideTime != nil {
 		meta.Time = *putOpts.OverrideTime
 	}
+	meta.Time = cache.NormalizeTime(meta.Time)
 
 	metadataBuf := bytes.NewBuffer(nil)
 	if _, err := meta.WriteTo(metadataBuf); err != nil {
@@ -380,16 +933,249 @@ func (store *BlobBackend) doBgUpload(putOpts cache.PutOpts, payloadPathOnDisk st
 	stats.Default.GetBlobMetrics(putOpts.IsInCompaction).UploadedBytes.Add(uint64(putOpts.Req.BodySize + int64(metadataBuf.Len())))
 	stats.Default.Persist()
 
+	store.logIfSlow("Slow upload to blob store", time.Since(t),
+		zap.String("actionID", fmt.Sprintf("%x", putOpts.Req.ActionID)),
+		zap.Int64("size", putOpts.Req.BodySize))
 	store.log.Debug("Uploaded file to blob store",
 		zap.String("cost", time.Since(t).String()),
 		zap.String("actionID", fmt.Sprintf("%x", putOpts.Req.ActionID)),
 		zap.String("object", objName))
 }
 
+// Exists checks whether the entry exists in the local store, archives or the blob store.
+// The body is never downloaded.
+func (store *BlobBackend) Exists(ctx context.Context, namespace string, actionID []byte) (bool, error) {
+	if store.closed.Load() {
+		return false, fmt.Errorf("blob store is closed")
+	}
+	exists, err := store.diskStore.Exists(ctx, namespace, actionID)
+	if err != nil || exists {
+		return exists, err
+	}
+	return store.existsRemotely(ctx, namespace, actionID)
+}
+
+// Delete removes the entry from the local store and the remote bucket. Entries in archives
+// cannot be removed individually, see protocol.DeleteResponse.InArchive, unless req.Purge is set.
+func (store *BlobBackend) Delete(ctx context.Context, req protocol.DeleteRequest) (*protocol.DeleteResponse, error) {
+	if store.closed.Load() {
+		return nil, fmt.Errorf("blob store is closed")
+	}
+	if req.Prefix != "" {
+		return store.deletePrefix(ctx, req)
+	}
+	resp, err := store.diskStore.Delete(ctx, req)
+	if err != nil {
+		return nil, err
+	}
+	if store.bucket != nil {
+		key := store.keyLayout.EntityKey(req.Namespace, req.ActionID)
+		err := store.bucket.Delete(ctx, key)
+		if err != nil && gcerrors.Code(err) != gcerrors.NotFound {
+			return nil, fmt.Errorf("failed to delete remote object %s: %w", key, err)
+		}
+		resp.DeletedRemote = err == nil
+	}
+	// Archives are only built for the default namespace.
+	if req.Namespace == "" && store.archiveStore.LookupBlob(CacheEntityKeyspace(req.ActionID), req.ActionID) != nil {
+		resp.InArchive = true
+	}
+	// The remote archive may contain the entry even if the local copy does not, so the purge
+	// is always scheduled. It is dropped at the next compaction if the archive does not contain it.
+	if req.Purge && req.Namespace == "" && store.bucket != nil {
+		if err := store.archiveStore.SchedulePurge(req.ActionID); err != nil {
+			return nil, fmt.Errorf("failed to schedule purge from archive: %w", err)
+		}
+		resp.PurgeScheduled = true
+	}
+	return resp, nil
+}
+
+// deletePrefix removes entries whose actionID starts with req.Prefix from the local store and
+// the remote bucket. Entries in archives are found in local copies of archives.
+func (store *BlobBackend) deletePrefix(ctx context.Context, req protocol.DeleteRequest) (*protocol.DeleteResponse, error) {
+	resp, err := store.diskStore.Delete(ctx, req)
+	if err != nil {
+		return nil, err
+	}
+	// Purged names by keyspace. Entries deleted remotely are purged as well, as the remote
+	// archive may contain them even if the local copy does not.
+	purges := make(map[string][]string)
+	if store.bucket != nil {
+		prefixKey := store.keyLayout.EntityPrefixKey(req.Namespace, req.Prefix)
+		iter := store.bucket.List(&blob.ListOptions{Prefix: prefixKey})
+		for {
+			obj, err := iter.Next(ctx)
+			if err == io.EOF {
+				break
+			}
+			if err != nil {
+				return nil, fmt.Errorf("failed to list objects using prefix %s: %w", prefixKey, err)
+			}
+			namespace, actionID, err := store.keyLayout.DecodeEntityKey(obj.Key)
+			if err != nil || namespace != req.Namespace {
+				continue
+			}
+			err = store.bucket.Delete(ctx, obj.Key)
+			if err != nil && gcerrors.Code(err) != gcerrors.NotFound {
+				return nil, fmt.Errorf("failed to delete remote object %s: %w", obj.Key, err)
+			}
+			if err == nil {
+				resp.RemoteEntries++
+			}
+			keyspace := CacheEntityKeyspace(actionID)
+			purges[keyspace] = append(purges[keyspace], CacheEntityNameInArchive(actionID))
+		}
+		resp.DeletedRemote = resp.RemoteEntries > 0
+	}
+	// Archives are only built for the default namespace.
+	if req.Namespace == "" {
+		for _, keyspace := range ArchiveKeyspaces {
+			if !strings.HasPrefix(keyspace, req.Prefix[:1]) {
+				continue
+			}
+			ar := store.archiveStore.GetArchive(keyspace)
+			if ar == nil {
+				continue
+			}
+			for _, name := range ar.List() {
+				if strings.HasPrefix(name, req.Prefix) {
+					resp.ArchiveEntries++
+					purges[keyspace] = append(purges[keyspace], name)
+				}
+			}
+		}
+		resp.InArchive = resp.ArchiveEntries > 0
+	}
+	if req.Purge && req.Namespace == "" && store.bucket != nil {
+		for keyspace, names := range purges {
+			if err := store.archiveStore.SchedulePurgeNames(keyspace, names); err != nil {
+				return nil, fmt.Errorf("failed to schedule purge from archive: %w", err)
+			}
+		}
+		resp.PurgeScheduled = len(purges) > 0
+	}
+	store.log.Info("Deleted cache entries by prefix",
+		zap.String("prefix", req.Prefix),
+		zap.String("namespace", req.Namespace),
+		zap.Int("remote", resp.RemoteEntries),
+		zap.Int("inArchive", resp.ArchiveEntries),
+		zap.Bool("purgeScheduled", resp.PurgeScheduled))
+	return resp, nil
+}
+
+// existsRemotely checks whether the entry exists in either archives or the blob store.
+func (store *BlobBackend) existsRemotely(ctx context.Context, namespace string, actionID []byte) (bool, error) {
+	// Archives are only built for the default namespace.
+	if namespace == "" && store.archiveStore.LookupBlob(CacheEntityKeyspace(actionID), actionID) != nil {
+		return true, nil
+	}
+	if store.bucket == nil {
+		return false, nil
+	}
+	return store.bucket.Exists(ctx, store.keyLayout.EntityKey(namespace, actionID))
+}
+
+// newEntryReader opens the remote cache entry, retrying NotFound according to the config.
+func (store *BlobBackend) newEntryReader(ctx context.Context, opts cache.GetOpts) (*blob.Reader, error) {
+	key := store.keyLayout.EntityKey(opts.Req.Namespace, opts.Req.ActionID)
+	retries := store.config.NotFoundRetries
+	if opts.IsInCompaction {
+		// Compaction only reads objects just listed, a NotFound there means the object is really gone.
+		retries = 0
+	}
+	r, err := store.openEntry(ctx, key)
+	for i := 0; i < retries && gcerrors.Code(err) == gcerrors.NotFound; i++ {
+		getBlobMetrics(opts).NotFoundRetry.Inc()
+		select {
+		case <-ctx.Done():
+			return nil, ctx.Err()
+		case <-time.After(store.config.NotFoundRetryDelay):
+		}
+		r, err = store.openEntry(ctx, key)
+		if err == nil {
+			getBlobMetrics(opts).NotFoundRetryHit.Inc()
+			store.log.Debug("Hit in blob store after retrying NotFound",
+				zap.String("actionID", fmt.Sprintf("%x", opts.Req.ActionID)),
+				zap.Int("retries", i+1))
+		}
+	}
+	return r, err
+}
+
+// logIfSlow logs at info level if the operation takes longer than SlowThreshold.
+func (store *BlobBackend) logIfSlow(msg string, cost time.Duration, fields ...zap.Field) {
+	if store.config.SlowThreshold <= 0 || cost < store.config.SlowThreshold {
+		return
+	}
+	store.log.Info(msg, append([]zap.Field{zap.String("cost", cost.String())}, fields...)...)
+}
+
+func setServedFrom(ctx context.Context, servedFrom string, bytes int64) {
+	cache.SetServedFrom(ctx, servedFrom)
+	tracing.SetAttributes(ctx,
+		attribute.String("servedFrom", servedFrom),
+		attribute.Int64("bytes", bytes))
+}
+
+func (store *BlobBackend) closeBucket() {
+	if store.bucket != nil {
+		_ = store.bucket.Close()
+	}
+}
+
+// GC trims the local store. Entries are still available from archives and remote.
+func (store *BlobBackend) GC(req protocol.GCRequest) (*protocol.GCResponse, error) {
+	if store.closed.Load() {
+		return nil, fmt.Errorf("blob store is closed")
+	}
+	return store.diskStore.GC(req)
+}
+
+// SetUploadConcurrency changes the max number of concurrent uploads, e.g. when the config
+// is reloaded. Running uploads are not interrupted.
+func (store *BlobBackend) SetUploadConcurrency(n int) error {
+	if n <= 0 {
+		return fmt.Errorf("upload concurrency must be positive, got %d", n)
+	}
+	if store.uploadQueue == nil {
+		return fmt.Errorf("blob store is not opened")
+	}
+	store.uploadQueue.Resize(n)
+	return nil
+}
+
+func (store *BlobBackend) PendingUploads() int {
+	if store.uploadQueue == nil {
+		return 0
+	}
+	return int(store.uploadQueue.RunningWorkers()) + int(store.uploadQueue.WaitingTasks())
+}
+
+func (store *BlobBackend) Flush(ctx context.Context) int {
+	ticker := time.NewTicker(flushPollInterval)
+	defer ticker.Stop()
+	for {
+		pending := store.PendingUploads()
+		if pending == 0 {
+			return 0
+		}
+		select {
+		case <-ctx.Done():
+			return pending
+		case <-ticker.C:
+		}
+	}
+}
+
 func (store *BlobBackend) Close() error {
 	defer func() {
+		if err := store.archiveStore.SaveAffinity(); err != nil {
+			store.log.Warn("Failed to save BlobArchive keyspace affinity", zap.Error(err))
+		}
+		store.egress.Save()
 		_ = store.diskStore.Close()
-		_ = store.bucket.Close()
+		store.closeBucket()
 		store.log.Info("Blob store closed")
 	}()
 
@@ -0,0 +1,439 @@
+package blob
+
+import (
+	"context"
+	"errors"
+	"io"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/breezewish/gscache/internal/cache"
+	"github.com/breezewish/gscache/internal/protocol"
+	"github.com/breezewish/gscache/internal/stats"
+	"github.com/stretchr/testify/require"
+	"gocloud.dev/blob"
+)
+
+// writeTestArchive writes a pre-built archive containing a single entry with body "hello".
+func writeTestArchive(t *testing.T, arDir string, actionID []byte) {
+	arFile, err := os.Create(filepath.Join(arDir, CacheEntityKeyspace(actionID)+".zip"))
+	require.NoError(t, err)
+	w := NewArWriter(arFile)
+	require.NoError(t, w.Add(CacheEntityNameInArchive(actionID), cache.EntryMeta{
+		ActionID: actionID,
+		OutputID: []byte{0x02},
+		Size:     5,
+		Time:     time.Now(),
+	}, []byte("hello")))
+	require.NoError(t, w.Close())
+	require.NoError(t, arFile.Close())
+}
+
+func TestBlobBackend_LocalArchiveDirWithoutRemote(t *testing.T) {
+	arDir := t.TempDir()
+	actionID := []byte{0x1a, 0x01}
+	writeTestArchive(t, arDir, actionID)
+
+	cfg := DefaultConfig()
+	cfg.WorkDir = t.TempDir()
+	cfg.LocalArchiveDir = arDir
+	store, err := NewBlobBackend(cfg)
+	require.NoError(t, err)
+	require.NoError(t, store.Open(context.Background()))
+	defer store.Close()
+	stats.Default.Clear()
+
+	// Served from the pre-built archive.
+	resp, err := store.Get(cache.GetOpts{Req: protocol.GetRequest{ActionID: actionID}})
+	require.NoError(t, err)
+	require.False(t, resp.Miss)
+	data, err := os.ReadFile(resp.DiskPath)
+	require.NoError(t, err)
+	require.Equal(t, "hello", string(data))
+
+	// Not in the archive, and there is no remote to download from.
+	resp, err = store.Get(cache.GetOpts{Req: protocol.GetRequest{ActionID: []byte{0x1a, 0x02}}})
+	require.NoError(t, err)
+	require.True(t, resp.Miss)
+
+	// Put is kept in the local store.
+	_, err = store.Put(cache.PutOpts{
+		Req:  protocol.PutRequest{ActionID: []byte{0x1a, 0x03}, OutputID: []byte{0x04}, BodySize: 3},
+		Body: strings.NewReader("foo"),
+	})
+	require.NoError(t, err)
+	resp, err = store.Get(cache.GetOpts{Req: protocol.GetRequest{ActionID: []byte{0x1a, 0x03}}})
+	require.NoError(t, err)
+	require.False(t, resp.Miss)
+
+	require.Equal(t, uint64(5), stats.Default.BlobOrganic.GetByArchiveBytes.Load())
+	require.Equal(t, uint64(3), stats.Default.BlobOrganic.GetByLocalBytes.Load())
+	require.Equal(t, uint64(0), stats.Default.BlobOrganic.GetByDownloadBytes.Load())
+
+	require.Error(t, store.Compact())
+}
+
+func TestBlobBackend_Keyspaces(t *testing.T) {
+	arDir := t.TempDir()
+	actionID := []byte{0x1a, 0x01}
+	writeTestArchive(t, arDir, actionID)
+
+	getFromInstance := func(keyspaces []string) *protocol.GetResponse {
+		cfg := DefaultConfig()
+		cfg.WorkDir = t.TempDir()
+		cfg.LocalArchiveDir = arDir
+		cfg.Keyspaces = keyspaces
+		store, err := NewBlobBackend(cfg)
+		require.NoError(t, err)
+		require.NoError(t, store.Open(context.Background()))
+		defer store.Close()
+		resp, err := store.Get(cache.GetOpts{Req: protocol.GetRequest{ActionID: actionID}})
+		require.NoError(t, err)
+		return resp
+	}
+
+	require.False(t, getFromInstance(nil).Miss)
+	require.False(t, getFromInstance([]string{"0-7"}).Miss)
+	// Keyspace 1 is not managed by this instance, so its archive is not used.
+	require.True(t, getFromInstance([]string{"8-f"}).Miss)
+
+	cfg := DefaultConfig()
+	cfg.WorkDir = t.TempDir()
+	cfg.LocalArchiveDir = arDir
+	cfg.Keyspaces = []string{"x"}
+	_, err := NewBlobBackend(cfg)
+	require.Error(t, err)
+}
+
+func TestBlobBackend_CompactionFailureAlert(t *testing.T) {
+	cfg := DefaultConfig()
+	cfg.WorkDir = t.TempDir()
+	cfg.LocalArchiveDir = t.TempDir()
+	cfg.CompactionFailureAlertThreshold = 2
+	store, err := NewBlobBackend(cfg)
+	require.NoError(t, err)
+
+	errFoo := errors.New("access denied")
+	store.recordCompactionResult(errFoo)
+	st := store.Status()
+	require.Equal(t, int32(1), st.CompactionConsecutiveFailures)
+	require.False(t, st.CompactionUnhealthy)
+
+	store.recordCompactionResult(errFoo)
+	st = store.Status()
+	require.Equal(t, int32(2), st.CompactionConsecutiveFailures)
+	require.True(t, st.CompactionUnhealthy)
+
+	// A successful run resets the state
+	store.recordCompactionResult(nil)
+	st = store.Status()
+	require.Equal(t, int32(0), st.CompactionConsecutiveFailures)
+	require.False(t, st.CompactionUnhealthy)
+
+	// Disabled
+	store.config.CompactionFailureAlertThreshold = 0
+	for range 5 {
+		store.recordCompactionResult(errFoo)
+	}
+	require.False(t, store.Status().CompactionUnhealthy)
+}
+
+func TestBlobBackend_Delete(t *testing.T) {
+	ctx := context.Background()
+	bucketURL := "file://" + t.TempDir()
+	bucket, err := blob.OpenBucket(ctx, bucketURL)
+	require.NoError(t, err)
+	defer bucket.Close()
+	write := func(key string, data []byte) error {
+		return bucket.WriteAll(ctx, key, data, nil)
+	}
+	remoteOnly := []byte{0xb0, 0x01}
+	writeTestObject(t, write, "", remoteOnly, "hello")
+
+	arDir := t.TempDir()
+	inArchive := []byte{0x1a, 0x01}
+	writeTestArchive(t, arDir, inArchive)
+
+	cfg := DefaultConfig()
+	cfg.URL = bucketURL
+	cfg.WorkDir = t.TempDir()
+	cfg.LocalArchiveDir = arDir
+	cfg.SkipCompactionOnOpen = true
+	cfg.SkipInitialArchiveSync = true
+	store, err := NewBlobBackend(cfg)
+	require.NoError(t, err)
+	require.NoError(t, store.Open(ctx))
+	defer store.Close()
+
+	// Downloaded into the local store by the Get
+	resp, err := store.Get(cache.GetOpts{Req: protocol.GetRequest{ActionID: remoteOnly}})
+	require.NoError(t, err)
+	require.False(t, resp.Miss)
+
+	delResp, err := store.Delete(ctx, protocol.DeleteRequest{ActionID: remoteOnly})
+	require.NoError(t, err)
+	require.Equal(t, &protocol.DeleteResponse{DeletedLocal: true, DeletedRemote: true}, delResp)
+	resp, err = store.Get(cache.GetOpts{Req: protocol.GetRequest{ActionID: remoteOnly}})
+	require.NoError(t, err)
+	require.True(t, resp.Miss)
+
+	delResp, err = store.Delete(ctx, protocol.DeleteRequest{ActionID: inArchive})
+	require.NoError(t, err)
+	require.Equal(t, &protocol.DeleteResponse{InArchive: true}, delResp)
+
+	_, err = store.Delete(ctx, protocol.DeleteRequest{})
+	require.Error(t, err)
+}
+
+func TestBlobBackend_PutIfAbsentUploadsExisting(t *testing.T) {
+	ctx := context.Background()
+	bucketURL := "file://" + t.TempDir()
+	bucket, err := blob.OpenBucket(ctx, bucketURL)
+	require.NoError(t, err)
+	defer bucket.Close()
+
+	cfg := DefaultConfig()
+	cfg.URL = bucketURL
+	cfg.WorkDir = t.TempDir()
+	cfg.SkipCompactionOnOpen = true
+	cfg.SkipInitialArchiveSync = true
+	store, err := NewBlobBackend(cfg)
+	require.NoError(t, err)
+	require.NoError(t, store.Open(ctx))
+
+	// The entry is only in the local store, e.g. its upload failed
+	actionID := []byte{0xc0, 0x01}
+	_, err = store.diskStore.Put(cache.PutOpts{
+		Req:  protocol.PutRequest{ActionID: actionID, OutputID: []byte{0x04}, BodySize: 3},
+		Body: strings.NewReader("foo"),
+	})
+	require.NoError(t, err)
+
+	// Another output is discarded, and the existing entry is uploaded
+	_, err = store.Put(cache.PutOpts{
+		Req:  protocol.PutRequest{ActionID: actionID, OutputID: []byte{0x05}, BodySize: 6, IfAbsent: true},
+		Body: strings.NewReader("hello!"),
+	})
+	require.NoError(t, err)
+	key := store.keyLayout.EntityKey("", actionID)
+	require.Eventually(t, func() bool {
+		exists, err := bucket.Exists(ctx, key)
+		return err == nil && exists
+	}, 5*time.Second, 10*time.Millisecond)
+	require.NoError(t, store.Close())
+
+	r, err := bucket.NewReader(ctx, key, nil)
+	require.NoError(t, err)
+	defer r.Close()
+	meta, err := cache.ReadEntryMeta(r)
+	require.NoError(t, err)
+	require.Equal(t, []byte{0x04}, meta.OutputID)
+	require.Equal(t, int64(3), meta.Size)
+	body, err := io.ReadAll(r)
+	require.NoError(t, err)
+	require.Equal(t, "foo", string(body))
+}
+
+func TestBlobBackend_DeletePrefix(t *testing.T) {
+	ctx := context.Background()
+	bucketURL := "file://" + t.TempDir()
+	bucket, err := blob.OpenBucket(ctx, bucketURL)
+	require.NoError(t, err)
+	defer bucket.Close()
+	write := func(key string, data []byte) error {
+		return bucket.WriteAll(ctx, key, data, nil)
+	}
+	writeTestObject(t, write, "", []byte{0xb0, 0x01}, "hello")
+	writeTestObject(t, write, "", []byte{0xb0, 0x02}, "hello")
+	writeTestObject(t, write, "", []byte{0xb1, 0x01}, "hello")
+	writeTestObject(t, write, "ns1", []byte{0xb0, 0x03}, "hello")
+
+	arDir := t.TempDir()
+	inArchive := []byte{0x1a, 0x01}
+	writeTestArchive(t, arDir, inArchive)
+
+	cfg := DefaultConfig()
+	cfg.URL = bucketURL
+	cfg.WorkDir = t.TempDir()
+	cfg.LocalArchiveDir = arDir
+	cfg.SkipCompactionOnOpen = true
+	cfg.SkipInitialArchiveSync = true
+	store, err := NewBlobBackend(cfg)
+	require.NoError(t, err)
+	require.NoError(t, store.Open(ctx))
+	defer store.Close()
+
+	// Downloaded into the local store by the Get
+	resp, err := store.Get(cache.GetOpts{Req: protocol.GetRequest{ActionID: []byte{0xb0, 0x01}}})
+	require.NoError(t, err)
+	require.False(t, resp.Miss)
+
+	delResp, err := store.Delete(ctx, protocol.DeleteRequest{Prefix: "b0"})
+	require.NoError(t, err)
+	require.Equal(t, &protocol.DeleteResponse{
+		DeletedLocal:  true,
+		DeletedRemote: true,
+		LocalEntries:  1,
+		RemoteEntries: 2,
+	}, delResp)
+	for _, actionID := range [][]byte{{0xb0, 0x01}, {0xb0, 0x02}} {
+		resp, err = store.Get(cache.GetOpts{Req: protocol.GetRequest{ActionID: actionID}})
+		require.NoError(t, err)
+		require.True(t, resp.Miss)
+	}
+	// Other prefixes and namespaces are kept
+	resp, err = store.Get(cache.GetOpts{Req: protocol.GetRequest{ActionID: []byte{0xb1, 0x01}}})
+	require.NoError(t, err)
+	require.False(t, resp.Miss)
+	resp, err = store.Get(cache.GetOpts{Req: protocol.GetRequest{ActionID: []byte{0xb0, 0x03}, Namespace: "ns1"}})
+	require.NoError(t, err)
+	require.False(t, resp.Miss)
+
+	delResp, err = store.Delete(ctx, protocol.DeleteRequest{Prefix: "1", Purge: true})
+	require.NoError(t, err)
+	require.Equal(t, &protocol.DeleteResponse{InArchive: true, PurgeScheduled: true, ArchiveEntries: 1}, delResp)
+	resp, err = store.Get(cache.GetOpts{Req: protocol.GetRequest{ActionID: inArchive}})
+	require.NoError(t, err)
+	require.True(t, resp.Miss)
+
+	_, err = store.Delete(ctx, protocol.DeleteRequest{Prefix: "B0"})
+	require.Error(t, err)
+	_, err = store.Delete(ctx, protocol.DeleteRequest{Prefix: "b0", ActionID: []byte{0xb0}})
+	require.Error(t, err)
+}
+
+func TestBlobBackend_NotFoundRetries(t *testing.T) {
+	ctx := context.Background()
+	bucketURL := "file://" + t.TempDir()
+	bucket, err := blob.OpenBucket(ctx, bucketURL)
+	require.NoError(t, err)
+	defer bucket.Close()
+	write := func(key string, data []byte) error {
+		return bucket.WriteAll(ctx, key, data, nil)
+	}
+	_, notFoundErr := bucket.NewReader(ctx, "missing", nil)
+	require.Error(t, notFoundErr)
+
+	cfg := DefaultConfig()
+	cfg.URL = bucketURL
+	cfg.WorkDir = t.TempDir()
+	cfg.SkipCompactionOnOpen = true
+	cfg.SkipInitialArchiveSync = true
+	cfg.NotFoundRetries = 2
+	cfg.NotFoundRetryDelay = time.Millisecond
+	store, err := NewBlobBackend(cfg)
+	require.NoError(t, err)
+	require.NoError(t, store.Open(ctx))
+	defer store.Close()
+
+	// The object becomes visible after being NotFound for the given times
+	var opens, notFoundTimes int
+	openEntry := store.openEntry
+	store.openEntry = func(ctx context.Context, key string) (*blob.Reader, error) {
+		opens++
+		if opens <= notFoundTimes {
+			return nil, notFoundErr
+		}
+		return openEntry(ctx, key)
+	}
+	get := func(actionID []byte, notFound int, isInCompaction bool) *protocol.GetResponse {
+		writeTestObject(t, write, "", actionID, "hello")
+		opens, notFoundTimes = 0, notFound
+		resp, err := store.Get(cache.GetOpts{Req: protocol.GetRequest{ActionID: actionID}, IsInCompaction: isInCompaction})
+		require.NoError(t, err)
+		return resp
+	}
+
+	retries, retryHits := stats.Default.BlobOrganic.NotFoundRetry.Load(), stats.Default.BlobOrganic.NotFoundRetryHit.Load()
+	require.False(t, get([]byte{0xc0, 0x01}, 0, false).Miss)
+	require.Equal(t, 1, opens)
+
+	// Hit after a transient NotFound
+	require.False(t, get([]byte{0xc0, 0x02}, 2, false).Miss)
+	require.Equal(t, 3, opens)
+	require.Equal(t, retries+2, stats.Default.BlobOrganic.NotFoundRetry.Load())
+	require.Equal(t, retryHits+1, stats.Default.BlobOrganic.NotFoundRetryHit.Load())
+
+	// Miss once retries run out
+	require.True(t, get([]byte{0xc0, 0x03}, 3, false).Miss)
+	require.Equal(t, 3, opens)
+	require.Equal(t, retries+4, stats.Default.BlobOrganic.NotFoundRetry.Load())
+	require.Equal(t, retryHits+1, stats.Default.BlobOrganic.NotFoundRetryHit.Load())
+
+	// Compaction does not retry
+	require.True(t, get([]byte{0xc0, 0x04}, 1, true).Miss)
+	require.Equal(t, 1, opens)
+}
+
+func TestBlobBackend_PrematerializeArchive(t *testing.T) {
+	ctx := context.Background()
+	cfg := DefaultConfig()
+	cfg.URL = "file://" + t.TempDir()
+	cfg.WorkDir = t.TempDir()
+	cfg.SkipCompactionOnOpen = true
+	cfg.SkipInitialArchiveSync = true
+	cfg.PrematerializeMaxBytes = 20
+	store, err := NewBlobBackend(cfg)
+	require.NoError(t, err)
+	require.NoError(t, store.Open(ctx))
+	defer store.Close()
+
+	now := time.Now()
+	newest := []byte{0xd0, 0x01}
+	large := []byte{0xd0, 0x02}
+	older := []byte{0xd0, 0x03}
+	oldest := []byte{0xd0, 0x04}
+	purged := []byte{0xd0, 0x05}
+	arPath := filepath.Join(t.TempDir(), "d.zip")
+	arFile, err := os.Create(arPath)
+	require.NoError(t, err)
+	w := NewArWriter(arFile)
+	for _, e := range []struct {
+		actionID []byte
+		size     int
+		age      time.Duration
+	}{
+		{newest, 10, 0},
+		{purged, 1, time.Minute},
+		{large, 100, 2 * time.Minute},
+		{older, 8, 3 * time.Minute},
+		{oldest, 8, 4 * time.Minute},
+	} {
+		require.NoError(t, w.Add(CacheEntityNameInArchive(e.actionID), cache.EntryMeta{
+			ActionID: e.actionID,
+			OutputID: []byte{0x02},
+			Size:     int64(e.size),
+			Time:     now.Add(-e.age),
+		}, []byte(strings.Repeat("x", e.size))))
+	}
+	require.NoError(t, w.Close())
+	require.NoError(t, arFile.Close())
+	ar, err := NewArReader(arPath)
+	require.NoError(t, err)
+	defer ar.Close()
+	require.NoError(t, store.purges.Add("d", CacheEntityNameInArchive(purged)))
+
+	exists := func(actionID []byte) bool {
+		ok, err := store.diskStore.Exists(ctx, "", actionID)
+		require.NoError(t, err)
+		return ok
+	}
+
+	// Most recent first. Entries larger than the remaining budget are skipped, but smaller
+	// entries after them are still copied.
+	store.prematerializeArchive("d", ar)
+	require.True(t, exists(newest))
+	require.False(t, exists(large))
+	require.True(t, exists(older))
+	require.False(t, exists(oldest))
+	require.False(t, exists(purged))
+
+	// Not copied again for the same archive
+	_, err = store.diskStore.Delete(ctx, protocol.DeleteRequest{ActionID: newest})
+	require.NoError(t, err)
+	store.prematerializeArchive("d", ar)
+	require.False(t, exists(newest))
+}
@@ -0,0 +1,284 @@
+package blob
+
+import (
+	"context"
+	"fmt"
+	"io"
+	"os"
+	"slices"
+	"sync"
+	"time"
+
+	"github.com/breezewish/gscache/internal/log"
+	"github.com/breezewish/gscache/internal/progress"
+	"github.com/breezewish/gscache/internal/util"
+	"go.uber.org/zap"
+	"gocloud.dev/blob"
+	"gocloud.dev/gcerrors"
+	"golang.org/x/sync/errgroup"
+)
+
+const DefaultCleanConcurrency = 16
+
+type CleanRemoteOpts struct {
+	Ctx    context.Context
+	Remote *blob.Bucket
+	// Objects last modified before now - OlderThan are deleted. Must be > 0.
+	OlderThan time.Duration
+	// If true, nothing is deleted or uploaded, only the report is produced.
+	DryRun bool
+	// Max number of objects deleted at the same time. If <= 0, DefaultCleanConcurrency is used.
+	Concurrency int
+	// Layout of standalone objects. Defaults to KeyLayoutDefault.
+	KeyLayout KeyLayout
+	// Optional. Defaults to the real clock.
+	Clock util.Clock
+}
+
+// CleanReport is the result of a remote cleanup.
+type CleanReport struct {
+	ObjectsListed         int
+	ObjectsDeleted        int
+	ObjectsFailed         int // Objects which cannot be deleted, e.g. due to network errors.
+	DeletedBytes          int64
+	ArchivesChecked       int
+	ArchivesRewritten     int
+	ArchiveEntriesRemoved int
+}
+
+type remoteCleaner struct {
+	opts     CleanRemoteOpts
+	log      *zap.Logger
+	cutoff   time.Time
+	report   CleanReport
+	progress *progress.Tracker // Listed objects and deleted bytes, then checked archives
+
+	mu sync.Mutex
+	// Names in archive of deleted entries in the default namespace, grouped by keyspace.
+	deletedNames map[string]map[string]struct{}
+}
+
+// CleanRemote deletes cache entities in the remote bucket which are older than OlderThan, for
+// buckets where lifecycle rules cannot be set. Standalone objects of all namespaces are deleted
+// by their modification time. Then each archive is rewritten without the deleted entries and
+// entries older than OlderThan, so that archives do not keep serving removed entries.
+//
+// It does not touch any local store. Daemons pick up rewritten archives when they sync from
+// the remote. A compaction running at the same time may upload an archive built before the
+// cleanup, in which case the removed entries are dropped by the next compaction.
+func CleanRemote(opts CleanRemoteOpts) (*CleanReport, error) {
+	if opts.Remote == nil {
+		return nil, fmt.Errorf("remote must be set")
+	}
+	if opts.OlderThan <= 0 {
+		return nil, fmt.Errorf("older than must be > 0, got %s", opts.OlderThan)
+	}
+	if opts.Ctx == nil {
+		opts.Ctx = context.Background()
+	}
+	if opts.Concurrency <= 0 {
+		opts.Concurrency = DefaultCleanConcurrency
+	}
+	c := &remoteCleaner{
+		opts:         opts,
+		log:          log.Named("blob.clean"),
+		cutoff:       util.ClockOrReal(opts.Clock).Now().Add(-opts.OlderThan),
+		deletedNames: make(map[string]map[string]struct{}),
+		progress:     progress.FromContext(opts.Ctx),
+	}
+	c.progress.SetStage("objects", 0, 0)
+	for _, prefix := range []string{opts.KeyLayout.rootPrefixKey(), "ns/"} {
+		if err := c.cleanPrefix(prefix); err != nil {
+			return &c.report, err
+		}
+	}
+	c.progress.SetStage("archives", int64(len(ArchiveKeyspaces)), 0)
+	for _, keyspace := range ArchiveKeyspaces {
+		if err := c.cleanArchive(keyspace); err != nil {
+			return &c.report, fmt.Errorf("failed to clean archive of keyspace %s: %w", keyspace, err)
+		}
+		c.progress.Add(1, 0)
+	}
+	return &c.report, nil
+}
+
+func (c *remoteCleaner) cleanPrefix(prefix string) error {
+	var g errgroup.Group
+	g.SetLimit(c.opts.Concurrency)
+	defer func() { _ = g.Wait() }()
+
+	iter := c.opts.Remote.List(&blob.ListOptions{Prefix: prefix})
+	for {
+		ctxList, cancel := context.WithTimeout(c.opts.Ctx, CompactionListFilesTimeout)
+		obj, err := iter.Next(ctxList)
+		cancel()
+		if err == io.EOF {
+			return nil
+		}
+		if err != nil {
+			return fmt.Errorf("failed to list objects using prefix %s: %w", prefix, err)
+		}
+		if obj.IsDir {
+			continue
+		}
+		namespace, actionID, err := c.opts.KeyLayout.DecodeEntityKey(obj.Key)
+		if err != nil {
+			// Never delete objects which are not written by gscache
+			continue
+		}
+		c.mu.Lock()
+		c.report.ObjectsListed++
+		c.mu.Unlock()
+		c.progress.Add(1, 0)
+		if !obj.ModTime.Before(c.cutoff) {
+			continue
+		}
+		key, size := obj.Key, obj.Size
+		g.Go(func() error {
+			err := c.deleteObject(key)
+			c.mu.Lock()
+			defer c.mu.Unlock()
+			if err != nil {
+				c.report.ObjectsFailed++
+				c.log.Warn("Failed to delete object", zap.String("object", key), zap.Error(err))
+				return nil
+			}
+			c.report.ObjectsDeleted++
+			c.report.DeletedBytes += size
+			c.progress.Add(0, size)
+			if namespace == "" {
+				keyspace := CacheEntityKeyspace(actionID)
+				if c.deletedNames[keyspace] == nil {
+					c.deletedNames[keyspace] = make(map[string]struct{})
+				}
+				c.deletedNames[keyspace][CacheEntityNameInArchive(actionID)] = struct{}{}
+			}
+			return nil
+		})
+	}
+}
+
+func (c *remoteCleaner) deleteObject(key string) error {
+	if c.opts.DryRun {
+		return nil
+	}
+	ctx, cancel := context.WithTimeout(c.opts.Ctx, MaxDownloadTimeout)
+	defer cancel()
+	err := c.opts.Remote.Delete(ctx, key)
+	if gcerrors.Code(err) == gcerrors.NotFound {
+		// Deleted concurrently
+		return nil
+	}
+	return err
+}
+
+// cleanArchive rewrites the archive of the keyspace without removed entries. The archive is
+// left as is if nothing is removed.
+func (c *remoteCleaner) cleanArchive(keyspace string) error {
+	arFile, err := os.CreateTemp("", "gscache_clean.*.zip")
+	if err != nil {
+		return fmt.Errorf("failed to create temp file: %w", err)
+	}
+	defer os.Remove(arFile.Name())
+	defer arFile.Close()
+
+	r, err := c.opts.Remote.NewReader(c.opts.Ctx, ArchiveKey(keyspace), nil)
+	if err != nil {
+		if gcerrors.Code(err) == gcerrors.NotFound {
+			return nil
+		}
+		return err
+	}
+	_, err = io.Copy(arFile, r)
+	_ = r.Close()
+	if err != nil {
+		return fmt.Errorf("failed to download: %w", err)
+	}
+	ar, err := NewArReader(arFile.Name())
+	if err != nil {
+		// Corrupted archives are reported by `gscache verify --remote` and rebuilt by compaction
+		c.log.Warn("Skip corrupted BlobArchive", zap.String("keyspace", keyspace), zap.Error(err))
+		return nil
+	}
+	defer ar.Close()
+	c.report.ArchivesChecked++
+
+	names := ar.List()
+	slices.Sort(names)
+	kept := make([]string, 0, len(names))
+	for _, name := range names {
+		_, deleted := c.deletedNames[keyspace][name]
+		if deleted || ar.Get(name).Time.Before(c.cutoff) {
+			continue
+		}
+		kept = append(kept, name)
+	}
+	removed := len(names) - len(kept)
+	if removed == 0 {
+		return nil
+	}
+	c.report.ArchivesRewritten++
+	c.report.ArchiveEntriesRemoved += removed
+	c.log.Info("Rewriting BlobArchive without removed entries",
+		zap.String("keyspace", keyspace),
+		zap.Int("kept", len(kept)),
+		zap.Int("removed", removed),
+		zap.Bool("dryRun", c.opts.DryRun))
+	if c.opts.DryRun {
+		return nil
+	}
+	return c.uploadArchive(keyspace, ar, kept)
+}
+
+func (c *remoteCleaner) uploadArchive(keyspace string, ar *ArReader, names []string) error {
+	newArFile, err := os.CreateTemp("", "gscache_clean.*.zip")
+	if err != nil {
+		return fmt.Errorf("failed to create file for new BlobArchive: %w", err)
+	}
+	defer os.Remove(newArFile.Name())
+	defer newArFile.Close()
+
+	w := NewArWriterWithOpts(newArFile, ArWriterOpts{Deterministic: true})
+	for _, name := range slices.Sorted(slices.Values(names)) {
+		entry := ar.Get(name)
+		er, err := entry.Open()
+		if err != nil {
+			_ = w.Close()
+			return err
+		}
+		data, err := io.ReadAll(er)
+		_ = er.Close()
+		if err != nil {
+			_ = w.Close()
+			return fmt.Errorf("failed to read %s in BlobArchive: %w", name, err)
+		}
+		if err := w.Add(name, entry.EntryMeta, data); err != nil {
+			_ = w.Close()
+			return err
+		}
+	}
+	if err := w.Close(); err != nil {
+		return err
+	}
+
+	if _, err := newArFile.Seek(0, io.SeekStart); err != nil {
+		return err
+	}
+	hash, err := archiveContentHash(newArFile)
+	if err != nil {
+		return fmt.Errorf("failed to hash new BlobArchive: %w", err)
+	}
+	if _, err := newArFile.Seek(0, io.SeekStart); err != nil {
+		return err
+	}
+	ctx, cancel := context.WithTimeout(c.opts.Ctx, ArStoreUploadTimeout)
+	defer cancel()
+	err = c.opts.Remote.Upload(ctx, ArchiveKey(keyspace), newArFile, &blob.WriterOptions{
+		ContentType: "application/octet-stream",
+		Metadata:    map[string]string{archiveHashMetadataKey: hash},
+	})
+	if err != nil {
+		return fmt.Errorf("failed to upload %s: %w", ArchiveKey(keyspace), err)
+	}
+	return nil
+}
@@ -0,0 +1,119 @@
+package blob
+
+import (
+	"bytes"
+	"context"
+	"os"
+	"path/filepath"
+	"testing"
+	"time"
+
+	"github.com/breezewish/gscache/internal/cache"
+	"github.com/breezewish/gscache/internal/util"
+	"github.com/stretchr/testify/require"
+	"gocloud.dev/blob/memblob"
+)
+
+func TestCleanRemote(t *testing.T) {
+	ctx := context.Background()
+	bucket := memblob.OpenBucket(nil)
+	defer bucket.Close()
+	write := func(key string, data []byte) error {
+		return bucket.WriteAll(ctx, key, data, nil)
+	}
+	exists := func(key string) bool {
+		ok, err := bucket.Exists(ctx, key)
+		require.NoError(t, err)
+		return ok
+	}
+
+	writeTestObject(t, write, "", []byte{0x10, 0x01}, "old")
+	writeTestObject(t, write, "go1.24_linux_amd64", []byte{0x20, 0x01}, "old")
+	require.NoError(t, write("other/foo", []byte("not a cache entry")))
+	cutoff := time.Now()
+	time.Sleep(10 * time.Millisecond)
+	writeTestObject(t, write, "", []byte{0x11, 0x01}, "new")
+
+	arDir := t.TempDir()
+	writeArchive := func(keyspace string, entries map[string]time.Time) {
+		var buf bytes.Buffer
+		w := NewArWriter(&buf)
+		for name, entryTime := range entries {
+			actionID := []byte{name[0], 0x01}
+			require.NoError(t, w.Add(CacheEntityNameInArchive(actionID), cache.EntryMeta{
+				ActionID: actionID,
+				OutputID: []byte{0x02},
+				Size:     int64(len(name)),
+				Time:     entryTime,
+			}, []byte(name)))
+		}
+		require.NoError(t, w.Close())
+		require.NoError(t, write(ArchiveKey(keyspace), buf.Bytes()))
+	}
+	writeArchive("1", map[string]time.Time{
+		"\x10": time.Now(),             // Its standalone object is deleted
+		"\x11": time.Now(),             // Kept
+		"\x12": cutoff.Add(-time.Hour), // Old
+	})
+	writeArchive("2", map[string]time.Time{"\x2a": time.Now()})
+	archiveNames := func(keyspace string) []string {
+		data, err := bucket.ReadAll(ctx, ArchiveKey(keyspace))
+		require.NoError(t, err)
+		path := filepath.Join(arDir, keyspace+".zip")
+		require.NoError(t, os.WriteFile(path, data, 0644))
+		ar, err := NewArReader(path)
+		require.NoError(t, err)
+		defer ar.Close()
+		return ar.List()
+	}
+	attrs2, err := bucket.Attributes(ctx, ArchiveKey("2"))
+	require.NoError(t, err)
+
+	opts := CleanRemoteOpts{
+		Ctx:       ctx,
+		Remote:    bucket,
+		OlderThan: 24 * time.Hour,
+		DryRun:    true,
+		Clock:     util.NewFakeClock(cutoff.Add(24 * time.Hour)),
+	}
+	expected := CleanReport{
+		ObjectsListed:         3,
+		ObjectsDeleted:        2,
+		DeletedBytes:          0,
+		ArchivesChecked:       2,
+		ArchivesRewritten:     1,
+		ArchiveEntriesRemoved: 2,
+	}
+	report, err := CleanRemote(opts)
+	require.NoError(t, err)
+	expected.DeletedBytes = report.DeletedBytes
+	require.Positive(t, report.DeletedBytes)
+	require.Equal(t, expected, *report)
+	require.True(t, exists(CacheEntityKey("", []byte{0x10, 0x01})))
+	require.Len(t, archiveNames("1"), 3)
+
+	opts.DryRun = false
+	report, err = CleanRemote(opts)
+	require.NoError(t, err)
+	require.Equal(t, expected, *report)
+	require.False(t, exists(CacheEntityKey("", []byte{0x10, 0x01})))
+	require.False(t, exists(CacheEntityKey("go1.24_linux_amd64", []byte{0x20, 0x01})))
+	require.True(t, exists(CacheEntityKey("", []byte{0x11, 0x01})))
+	require.True(t, exists("other/foo"))
+	require.Equal(t, []string{"1101"}, archiveNames("1"))
+	// Archives without removed entries are not uploaded again
+	newAttrs2, err := bucket.Attributes(ctx, ArchiveKey("2"))
+	require.NoError(t, err)
+	require.Equal(t, attrs2.ModTime, newAttrs2.ModTime)
+
+	attrs1, err := bucket.Attributes(ctx, ArchiveKey("1"))
+	require.NoError(t, err)
+	require.NotEmpty(t, attrs1.Metadata[archiveHashMetadataKey])
+}
+
+func TestCleanRemote_InvalidOpts(t *testing.T) {
+	bucket := memblob.OpenBucket(nil)
+	defer bucket.Close()
+	_, err := CleanRemote(CleanRemoteOpts{Remote: bucket})
+	require.ErrorContains(t, err, "older than must be > 0")
+}
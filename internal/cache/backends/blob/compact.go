@@ -2,9 +2,13 @@ package blob
 
 import (
 	"context"
+	"errors"
 	"fmt"
 	"io"
 	"os"
+	"slices"
+	"strings"
+	"sync/atomic"
 	"time"
 
 	"github.com/alitto/pond/v2"
@@ -12,8 +16,11 @@ import (
 	"github.com/breezewish/gscache/internal/log"
 	"github.com/breezewish/gscache/internal/protocol"
 	"github.com/breezewish/gscache/internal/stats"
+	"github.com/breezewish/gscache/internal/tracing"
+	"go.opentelemetry.io/otel/attribute"
 	"go.uber.org/zap"
 	"gocloud.dev/blob"
+	"golang.org/x/sync/errgroup"
 )
 
 const (
@@ -27,6 +34,13 @@ const (
 	CompactionListFilesTimeout = 20 * time.Second
 )
 
+// Reasons why a keyspace compaction is skipped.
+const (
+	CompactionSkipNoBlobs        = "no-blobs"        // There is no small blob in the keyspace.
+	CompactionSkipNothingNew     = "nothing-new"     // All small blobs are already in the archive.
+	CompactionSkipBelowThreshold = "below-threshold" // New small blobs are fewer than CompactionAtLeastAddFiles.
+)
+
 type compactItem struct {
 	ActionID   []byte
 	ObjectKey  string
@@ -53,11 +67,13 @@ type compactItem struct {
 // Each compactor only works for a single keyspace ('0' to 'f') to enable
 // better parallelism (like LIST and GET).
 type CompactionJob struct {
-	opts CompactionJobOpts
-	log  *zap.Logger
+	opts     CompactionJobOpts
+	log      *zap.Logger
+	traceCtx context.Context // Parent context for tracing spans of each step
 
 	// Fields below are filled during the compaction process.
 	isSkipped              bool
+	skipReason             string // One of CompactionSkipXxx when isSkipped
 	plannedList            []compactItem
 	newArFile              *os.File  // Temporary file to store the new BlobArchive file
 	newArFileWriter        *ArWriter // Writer to the new BlobArchive file
@@ -65,6 +81,7 @@ type CompactionJob struct {
 	nNewlyAddedFiles       int
 	nNewlyAddedBytes       int
 	nNewlyRemovedFiles     int // How many files are removed in the new archive
+	nPendingPurges         int // How many purged entries are still in the archive
 	elapsedFindBlobs       time.Duration
 	elapsedDownload        time.Duration
 	elapsedDownloadAndFill time.Duration
@@ -77,6 +94,19 @@ type CompactionJobOpts struct {
 	BlobCache   *BlobBackend
 	Remote      *blob.Bucket // Must not contain keyspace as the prefix
 	Ctx         context.Context
+	// If true, the existing BlobArchive is treated as empty and all current small blobs
+	// are included and overwrite it, regardless of CompactionAtLeastAddFiles.
+	Rebuild bool
+	// If > 1, the keyspace is listed as 16 sub-prefixes (e.g. b/a0 .. b/af) with this
+	// concurrency, which speeds up listing very large buckets. Otherwise a single LIST is used.
+	ListConcurrency int
+	// If true, the new BlobArchive is byte-identical for the same set of small blobs.
+	DeterministicArchive bool
+	// Layout of small blobs in Remote. Defaults to KeyLayoutDefault.
+	KeyLayout KeyLayout
+	// If true, the job only finds blobs to compact, without downloading them or uploading a new
+	// BlobArchive. Compaction stats are not counted.
+	DryRun bool
 }
 
 func NewCompactionJob(opts CompactionJobOpts) *CompactionJob {
@@ -100,20 +130,14 @@ func (c *CompactionJob) cleanUp() {
 	}
 }
 
-func (c *CompactionJob) step1FindBlobsToCompact() (bool /* needCompact */, error) {
-	t := time.Now()
-	defer func() {
-		c.elapsedFindBlobs = time.Since(t)
-	}()
-
+// listSmallBlobs lists all small blob files under the prefix.
+func (c *CompactionJob) listSmallBlobs(prefix string) ([]compactItem, error) {
 	iter := c.opts.Remote.List(&blob.ListOptions{
-		Prefix:    ArchiveListPrefixKey(c.opts.Keyspace),
+		Prefix:    prefix,
 		Delimiter: "",
 	})
 
-	c.plannedList = make([]compactItem, 0)
-	plannedTotalSize := int64(0)
-
+	items := make([]compactItem, 0)
 	for {
 		ctxList, cancel := context.WithTimeout(c.opts.Ctx, CompactionListFilesTimeout)
 		obj, err := iter.Next(ctxList)
@@ -122,9 +146,7 @@ func (c *CompactionJob) step1FindBlobsToCompact() (bool /* needCompact */, error
 			break
 		}
 		if err != nil {
-			return false, fmt.Errorf("failed to list objects using prefix %s: %w",
-				ArchiveListPrefixKey(c.opts.Keyspace),
-				err)
+			return nil, fmt.Errorf("failed to list objects using prefix %s: %w", prefix, err)
 		}
 		if obj.IsDir {
 			continue
@@ -132,8 +154,9 @@ func (c *CompactionJob) step1FindBlobsToCompact() (bool /* needCompact */, error
 		if obj.Size >= CompactionSmallBlobSize {
 			continue
 		}
-		actionID, err := DecodeCacheEntityKey(obj.Key)
-		if err != nil {
+		namespace, actionID, err := c.opts.KeyLayout.DecodeEntityKey(obj.Key)
+		if err != nil || namespace != "" {
+			// Archives are only built for the default namespace.
 			c.log.Warn("Skip object which does not seems to be a cache entry",
 				zap.String("object", obj.Key))
 			continue
@@ -142,18 +165,79 @@ func (c *CompactionJob) step1FindBlobsToCompact() (bool /* needCompact */, error
 			zap.String("object", obj.Key),
 			zap.Int64("size", obj.Size),
 			zap.String("actionID", fmt.Sprintf("%x", actionID)))
-		c.plannedList = append(c.plannedList, compactItem{
+		items = append(items, compactItem{
 			ActionID:   actionID,
 			ObjectKey:  obj.Key,
 			ObjectSize: obj.Size,
 		})
-		plannedTotalSize += obj.Size
 	}
-	if len(c.plannedList) == 0 {
+	return items, nil
+}
+
+// listSmallBlobsParallel lists the keyspace by 16 sub-prefixes concurrently.
+// Results are merged in the sub-prefix order, so that the result is the same as a single LIST.
+func (c *CompactionJob) listSmallBlobsParallel() ([]compactItem, error) {
+	const hexChars = "0123456789abcdef"
+	results := make([][]compactItem, len(hexChars))
+	var g errgroup.Group
+	g.SetLimit(c.opts.ListConcurrency)
+	for i := range hexChars {
+		prefix := c.opts.KeyLayout.ListPrefixKey(c.opts.Keyspace) + hexChars[i:i+1]
+		g.Go(func() error {
+			items, err := c.listSmallBlobs(prefix)
+			results[i] = items
+			return err
+		})
+	}
+	if err := g.Wait(); err != nil {
+		return nil, err
+	}
+	items := make([]compactItem, 0)
+	for _, r := range results {
+		items = append(items, r...)
+	}
+	return items, nil
+}
+
+func (c *CompactionJob) step1FindBlobsToCompact() (bool /* needCompact */, error) {
+	t := time.Now()
+	_, span := tracing.Start(c.traceCtx, "compaction.FindBlobs")
+	defer func() {
+		c.elapsedFindBlobs = time.Since(t)
+		span.SetAttributes(
+			attribute.Int("planned", len(c.plannedList)),
+			attribute.Int("newlyAdded", c.nNewlyAddedFiles))
+		span.End()
+	}()
+
+	var err error
+	if c.opts.ListConcurrency > 1 {
+		c.plannedList, err = c.listSmallBlobsParallel()
+	} else {
+		c.plannedList, err = c.listSmallBlobs(c.opts.KeyLayout.ListPrefixKey(c.opts.Keyspace))
+	}
+	if err != nil {
+		return false, err
+	}
+	plannedTotalSize := int64(0)
+	for _, item := range c.plannedList {
+		plannedTotalSize += item.ObjectSize
+	}
+	// Purged entries are only removed from the archive by compacting, so it must not be skipped
+	c.nPendingPurges, err = c.opts.BlobArStore.PendingPurges(c.opts.Keyspace)
+	if err != nil {
+		c.log.Warn("Failed to update pending purges", zap.Error(err))
+	}
+	force := c.opts.Rebuild || c.nPendingPurges > 0
+	if len(c.plannedList) == 0 && !force {
+		c.skipReason = CompactionSkipNoBlobs
 		return false, nil
 	}
 
 	ar := c.opts.BlobArStore.GetArchive(c.opts.Keyspace)
+	if c.opts.Rebuild {
+		ar = nil
+	}
 	c.nNewlyAddedFiles = 0
 	if ar != nil {
 		for _, item := range c.plannedList {
@@ -178,28 +262,39 @@ func (c *CompactionJob) step1FindBlobsToCompact() (bool /* needCompact */, error
 		c.nNewlyRemovedFiles = 0
 	}
 
-	if c.nNewlyAddedFiles < CompactionAtLeastAddFiles {
+	if c.nNewlyAddedFiles < CompactionAtLeastAddFiles && !force {
+		if c.nNewlyAddedFiles == 0 {
+			c.skipReason = CompactionSkipNothingNew
+		} else {
+			c.skipReason = CompactionSkipBelowThreshold
+		}
 		return false, nil
 	}
 
-	stats.Default.BlobCompactor.BlobAddTotal.Add(uint32(c.nNewlyAddedFiles))
-	stats.Default.BlobCompactor.BlobAddTotalBytes.Add(uint64(c.nNewlyAddedBytes))
-	stats.Default.BlobCompactor.BlobRemoveTotal.Add(uint32(c.nNewlyRemovedFiles))
-	stats.Default.Persist()
+	if !c.opts.DryRun {
+		stats.Default.BlobCompactor.BlobAddTotal.Add(uint32(c.nNewlyAddedFiles))
+		stats.Default.BlobCompactor.BlobAddTotalBytes.Add(uint64(c.nNewlyAddedBytes))
+		stats.Default.BlobCompactor.BlobRemoveTotal.Add(uint32(c.nNewlyRemovedFiles))
+		stats.Default.Persist()
+	}
 
 	c.log.Info("Finish listing small blob files",
 		zap.Int("planned", len(c.plannedList)),
 		zap.Int("newlyAdded", c.nNewlyAddedFiles),
 		zap.Int("newlyAddedBytes", c.nNewlyAddedBytes),
 		zap.Int("newlyRemoved", c.nNewlyRemovedFiles),
+		zap.Int("pendingPurges", c.nPendingPurges),
 		zap.Int64("totalSize", plannedTotalSize))
 	return true, nil
 }
 
 func (c *CompactionJob) step2DownloadAndFill() error {
 	t := time.Now()
+	spanCtx, span := tracing.Start(c.traceCtx, "compaction.DownloadAndFill")
 	defer func() {
 		c.elapsedDownloadAndFill = time.Since(t)
+		span.SetAttributes(attribute.Int("included", c.nIncludedFiles))
+		span.End()
 	}()
 
 	newArFile, err := os.CreateTemp("", "gscache_compact.*.zip")
@@ -207,7 +302,9 @@ func (c *CompactionJob) step2DownloadAndFill() error {
 		return fmt.Errorf("failed to create file for new BlobArchive: %w", err)
 	}
 	c.newArFile = newArFile
-	c.newArFileWriter = NewArWriter(newArFile)
+	c.newArFileWriter = NewArWriterWithOpts(newArFile, ArWriterOpts{
+		Deterministic: c.opts.DeterministicArchive,
+	})
 
 	// for an ActionID, it may be available in local cache, or in BlobArchive store,
 	// or only in the remote bucket. In any case, we will always retrieve it
@@ -216,29 +313,43 @@ func (c *CompactionJob) step2DownloadAndFill() error {
 	// available to GET requests.
 
 	// In this step, we concurrently trigger GET requests to BlobBackend (result
-	// in a LocalPath) and collect them in a channel. The channel is then
-	// processed by a single goroutine to fill the new BlobArchive file.
-	// This is because we could only have a single writer to the new
-	// BlobArchive file.
+	// in a LocalPath) and collect them in a slot of each planned item. Slots are
+	// then processed in the planned order by a single goroutine to fill the new
+	// BlobArchive file. This is because we could only have a single writer to the
+	// new BlobArchive file, which must be written in name order if it is deterministic.
+	// Only paths are held in slots, data is read when written, so memory is bounded.
 
 	type result struct {
 		compactItem
-		resp *protocol.GetResponse
+		resp *protocol.GetResponse // nil if the item is skipped
 	}
 
-	resultQueue := make(chan result, len(c.plannedList))
+	if c.opts.DeterministicArchive {
+		slices.SortFunc(c.plannedList, func(a, b compactItem) int {
+			return strings.Compare(CacheEntityNameInArchive(a.ActionID), CacheEntityNameInArchive(b.ActionID))
+		})
+	}
+	results := make([]chan result, len(c.plannedList))
+	for i := range results {
+		results[i] = make(chan result, 1)
+	}
 	getQueue := pond.NewPool(32, pond.WithContext(c.opts.Ctx))
+	// Set if the new BlobArchive cannot be complete, so that it must not replace the existing one
+	var fatalErr atomic.Pointer[error]
 
 	arWriteFinish := make(chan struct{})
 	go func() {
 		defer close(arWriteFinish)
 		// This goroutine will fill the new BlobArchive file
-		for {
-			r, ok := <-resultQueue
+		for _, resultCh := range results {
+			r, ok := <-resultCh
 			if !ok {
-				// No result any more
+				// Not run as the compaction is cancelled
 				return
 			}
+			if r.resp == nil {
+				continue
+			}
 			objLogger := c.log.With(
 				zap.String("actionID", fmt.Sprintf("%x", r.ActionID)),
 				zap.String("object", r.ObjectKey),
@@ -298,22 +409,30 @@ func (c *CompactionJob) step2DownloadAndFill() error {
 
 	tDownload := time.Now()
 
-	for _, item2 := range c.plannedList {
+	for i, item2 := range c.plannedList {
 		item := item2
+		resultCh := results[i]
 		_ = getQueue.Go(func() {
-			resp, err := c.opts.BlobCache.Get(cache.GetOpts{
+			resp, err := c.opts.BlobCache.getWithOpts(cache.GetOpts{
 				Req: protocol.GetRequest{
 					ActionID: item.ActionID,
 				},
+				Ctx:            spanCtx,
 				IsInCompaction: true,
-			})
+			}, c.opts.Rebuild)
 			objLogger := c.log.With(
 				zap.String("actionID", fmt.Sprintf("%x", item.ActionID)),
 				zap.String("object", item.ObjectKey))
+			if errors.Is(err, errEgressBudgetExhausted) {
+				fatalErr.CompareAndSwap(nil, &err)
+				resultCh <- result{item, nil}
+				return
+			}
 			if err != nil {
 				objLogger.Warn("Failed to get blob file", zap.Error(err))
 				stats.Default.BlobCompactor.BlobSkipForOther.Inc()
 				stats.Default.Persist()
+				resultCh <- result{item, nil}
 				return
 			}
 			if resp.Miss {
@@ -321,19 +440,27 @@ func (c *CompactionJob) step2DownloadAndFill() error {
 				objLogger.Warn("Blob file in list but not found, skip")
 				stats.Default.BlobCompactor.BlobSkipForMissing.Inc()
 				stats.Default.Persist()
+				resultCh <- result{item, nil}
 				return
 			}
-			resultQueue <- result{item, resp}
+			resultCh <- result{item, resp}
 		})
 	}
 
 	getQueue.StopAndWait()
-	close(resultQueue)
+	// Slots of items not run, e.g. when cancelled, are left empty and closed
+	for _, resultCh := range results {
+		close(resultCh)
+	}
 
 	c.elapsedDownload = time.Since(tDownload)
 
 	<-arWriteFinish
 
+	if err := fatalErr.Load(); err != nil {
+		return fmt.Errorf("failed to download blob files: %w", *err)
+	}
+
 	c.log.Info("Finish writing new BlobArchive file",
 		zap.Int("nPlannedFiles", len(c.plannedList)),
 		zap.Int("nIncludedFiles", c.nIncludedFiles),
@@ -350,7 +477,10 @@ func (c *CompactionJob) step3IngestNewArFile() error {
 		return err
 	}
 	t := time.Now()
-	if err := c.opts.BlobArStore.IngestNewArchive(c.opts.Keyspace, c.newArFile.Name()); err != nil {
+	_, span := tracing.Start(c.traceCtx, "compaction.Ingest")
+	err := c.opts.BlobArStore.IngestNewArchive(c.opts.Keyspace, c.newArFile.Name())
+	tracing.EndWithError(span, err)
+	if err != nil {
 		return err
 	}
 	c.elapsedIngest = time.Since(t)
@@ -362,6 +492,10 @@ func (c *CompactionJob) step3IngestNewArFile() error {
 func (c *CompactionJob) work() error {
 	defer c.cleanUp()
 	c.log.Debug("Starting compaction")
+	// A cold keyspace is not loaded until accessed, and would look like an empty archive
+	if err := c.opts.BlobArStore.EnsureLoaded(c.opts.Ctx, c.opts.Keyspace); err != nil {
+		return fmt.Errorf("failed to load BlobArchive: %w", err)
+	}
 	if err := c.opts.BlobArStore.SyncFromRemote(c.opts.Keyspace); err != nil {
 		c.log.Warn("Failed to sync BlobArchive", zap.Error(err))
 	}
@@ -371,6 +505,7 @@ func (c *CompactionJob) work() error {
 	}
 	if !needCompact {
 		c.log.Info("Not enough new small blob files to compact, skip compaction",
+			zap.String("reason", c.skipReason),
 			zap.Int("planned", len(c.plannedList)),
 			zap.Int("newlyAdded", c.nNewlyAddedFiles),
 			zap.Int("newlyAddedBytes", c.nNewlyAddedBytes),
@@ -386,15 +521,66 @@ func (c *CompactionJob) work() error {
 	if err != nil {
 		return fmt.Errorf("failed to ingest new BlobArchive file: %w", err)
 	}
+	// The new archive is built from current blobs, which no longer include purged entries
+	if err := c.opts.BlobArStore.purges.Clear(c.opts.Keyspace); err != nil {
+		c.log.Warn("Failed to clear pending purges", zap.Error(err))
+	}
 	return nil
 }
 
-func (c *CompactionJob) Work() {
+// plan only finds blobs to compact for a dry run.
+func (c *CompactionJob) plan() error {
+	c.traceCtx = c.opts.Ctx
+	// A cold keyspace is not loaded until accessed, and would look like an empty archive
+	if err := c.opts.BlobArStore.EnsureLoaded(c.opts.Ctx, c.opts.Keyspace); err != nil {
+		return fmt.Errorf("failed to load BlobArchive: %w", err)
+	}
+	if err := c.opts.BlobArStore.SyncFromRemote(c.opts.Keyspace); err != nil {
+		c.log.Warn("Failed to sync BlobArchive", zap.Error(err))
+	}
+	needCompact, err := c.step1FindBlobsToCompact()
+	if err != nil {
+		return fmt.Errorf("failed to find blobs to compact: %w", err)
+	}
+	c.isSkipped = !needCompact
+	return nil
+}
+
+// result summarizes the job after it is done.
+func (c *CompactionJob) result(err error) protocol.KeyspaceCompaction {
+	r := protocol.KeyspaceCompaction{
+		Keyspace:     c.opts.Keyspace,
+		Skipped:      c.isSkipped,
+		SkipReason:   c.skipReason,
+		Blobs:        len(c.plannedList),
+		AddedBlobs:   c.nNewlyAddedFiles,
+		AddedBytes:   int64(c.nNewlyAddedBytes),
+		RemovedBlobs: c.nNewlyRemovedFiles,
+	}
+	if err != nil {
+		r.Error = err.Error()
+	}
+	return r
+}
+
+func (c *CompactionJob) Work() error {
+	if c.opts.DryRun {
+		return c.plan()
+	}
 	defer stats.Default.Persist()
 	stats.Default.BlobCompactor.Total.Inc()
 
+	ctx, span := tracing.Start(c.opts.Ctx, "compaction",
+		attribute.String("keyspace", c.opts.Keyspace))
+	c.traceCtx = ctx
+
 	t := time.Now()
-	if err := c.work(); err != nil {
+	err := c.work()
+	span.SetAttributes(
+		attribute.Bool("isSkipped", c.isSkipped),
+		attribute.String("skipReason", c.skipReason))
+	tracing.EndWithError(span, err)
+	if err != nil {
 		stats.Default.BlobCompactor.Fail.Inc()
 		c.log.Error("Compaction job failed",
 			zap.Int("nPlannedFiles", len(c.plannedList)),
@@ -403,11 +589,20 @@ func (c *CompactionJob) Work() {
 	} else {
 		if c.isSkipped {
 			stats.Default.BlobCompactor.Skip.Inc()
+			switch c.skipReason {
+			case CompactionSkipNoBlobs:
+				stats.Default.BlobCompactor.SkipNoBlobs.Inc()
+			case CompactionSkipNothingNew:
+				stats.Default.BlobCompactor.SkipNothingNew.Inc()
+			case CompactionSkipBelowThreshold:
+				stats.Default.BlobCompactor.SkipBelowThreshold.Inc()
+			}
 		} else {
 			stats.Default.BlobCompactor.Success.Inc()
 		}
 		c.log.Info("Compaction job finished",
 			zap.Bool("isSkipped", c.isSkipped),
+			zap.String("skipReason", c.skipReason),
 			zap.Int("nPlannedFiles", len(c.plannedList)),
 			zap.Int("nIncludedFiles", c.nIncludedFiles),
 			zap.String("costJob", time.Since(t).String()),
@@ -416,4 +611,8 @@ func (c *CompactionJob) Work() {
 			zap.String("costDownload", c.elapsedDownload.String()),
 			zap.String("costIngest", c.elapsedIngest.String()))
 	}
+	if err != nil {
+		return fmt.Errorf("compaction of keyspace %s failed: %w", c.opts.Keyspace, err)
+	}
+	return nil
 }
@@ -0,0 +1,355 @@
+package blob
+
+import (
+	"archive/zip"
+	"bytes"
+	"context"
+	"os"
+	"slices"
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/breezewish/gscache/internal/cache"
+	"github.com/breezewish/gscache/internal/progress"
+	"github.com/breezewish/gscache/internal/protocol"
+	"github.com/breezewish/gscache/internal/stats"
+	"github.com/stretchr/testify/require"
+	"gocloud.dev/blob"
+	"gocloud.dev/blob/memblob"
+)
+
+func TestCompactionJob_ListSmallBlobsParallel(t *testing.T) {
+	ctx := context.Background()
+	bucket := memblob.OpenBucket(nil)
+	defer bucket.Close()
+
+	for i := 0; i < 256; i++ {
+		actionID := []byte{0xa0 | byte(i%16), byte(i)}
+		require.NoError(t, bucket.WriteAll(ctx, CacheEntityKey("", actionID), []byte("data"), nil))
+	}
+	// Objects which should be excluded
+	require.NoError(t, bucket.WriteAll(ctx, CacheEntityKey("", []byte{0xa1, 0xff, 0x01}), make([]byte, CompactionSmallBlobSize), nil))
+	require.NoError(t, bucket.WriteAll(ctx, CacheEntityKey("", []byte{0xb1, 0x01}), []byte("data"), nil))
+	require.NoError(t, bucket.WriteAll(ctx, "b/a1/not-a-cache-entry", []byte("data"), nil))
+
+	newJob := func(concurrency int) *CompactionJob {
+		return NewCompactionJob(CompactionJobOpts{
+			Keyspace:        "a",
+			Remote:          bucket,
+			Ctx:             ctx,
+			ListConcurrency: concurrency,
+		})
+	}
+
+	serial, err := newJob(1).listSmallBlobs(ArchiveListPrefixKey("a"))
+	require.NoError(t, err)
+	require.Len(t, serial, 256)
+
+	parallel, err := newJob(4).listSmallBlobsParallel()
+	require.NoError(t, err)
+	require.Equal(t, serial, parallel)
+}
+
+func TestCompactionJob_SkipReason(t *testing.T) {
+	ctx := context.Background()
+	bucket := memblob.OpenBucket(nil)
+	defer bucket.Close()
+
+	arStore, err := NewArStore(ArStoreOpts{
+		WorkDir:              t.TempDir(),
+		Remote:               bucket,
+		AllPossibleKeyspaces: []string{"a"},
+		MinSyncInterval:      time.Millisecond,
+	})
+	require.NoError(t, err)
+	runJob := func() *CompactionJob {
+		time.Sleep(2 * time.Millisecond) // Let the job sync the archive again
+		job := NewCompactionJob(CompactionJobOpts{
+			Keyspace:    "a",
+			BlobArStore: arStore,
+			Remote:      bucket,
+			Ctx:         ctx,
+		})
+		require.NoError(t, job.Work())
+		return job
+	}
+
+	before := stats.Default.BlobCompactor.SkipNoBlobs.Load()
+	job := runJob()
+	require.True(t, job.isSkipped)
+	require.Equal(t, CompactionSkipNoBlobs, job.skipReason)
+	require.Equal(t, before+1, stats.Default.BlobCompactor.SkipNoBlobs.Load())
+
+	actionIDs := [][]byte{{0xa0, 0x01}, {0xa0, 0x02}, {0xa0, 0x03}}
+	for _, actionID := range actionIDs {
+		require.NoError(t, bucket.WriteAll(ctx, CacheEntityKey("", actionID), []byte("data"), nil))
+	}
+	before = stats.Default.BlobCompactor.SkipBelowThreshold.Load()
+	job = runJob()
+	require.Equal(t, CompactionSkipBelowThreshold, job.skipReason)
+	require.Equal(t, before+1, stats.Default.BlobCompactor.SkipBelowThreshold.Load())
+
+	// All small blobs are already in the archive.
+	var buf bytes.Buffer
+	w := NewArWriter(&buf)
+	for _, actionID := range actionIDs {
+		require.NoError(t, w.Add(CacheEntityNameInArchive(actionID), cache.EntryMeta{
+			ActionID: actionID,
+			OutputID: []byte{0x01},
+			Size:     4,
+			Time:     time.Now(),
+		}, []byte("data")))
+	}
+	require.NoError(t, w.Close())
+	require.NoError(t, bucket.WriteAll(ctx, ArchiveKey("a"), buf.Bytes(), nil))
+	before = stats.Default.BlobCompactor.SkipNothingNew.Load()
+	job = runJob()
+	require.Equal(t, CompactionSkipNothingNew, job.skipReason)
+	require.Equal(t, before+1, stats.Default.BlobCompactor.SkipNothingNew.Load())
+}
+
+func TestBlobBackend_CompactWithReport(t *testing.T) {
+	ctx := context.Background()
+	bucketURL := "file://" + t.TempDir()
+	bucket, err := blob.OpenBucket(ctx, bucketURL)
+	require.NoError(t, err)
+	defer bucket.Close()
+	write := func(key string, data []byte) error {
+		return bucket.WriteAll(ctx, key, data, nil)
+	}
+	for i := range CompactionAtLeastAddFiles {
+		writeTestObject(t, write, "", []byte{0xa0, byte(i)}, "data")
+	}
+
+	cfg := DefaultConfig()
+	cfg.URL = bucketURL
+	cfg.WorkDir = t.TempDir()
+	cfg.SkipCompactionOnOpen = true
+	cfg.SkipInitialArchiveSync = true
+	cfg.ArchiveMinSyncInterval = 0
+	store, err := NewBlobBackend(cfg)
+	require.NoError(t, err)
+	require.NoError(t, store.Open(ctx))
+	defer store.Close()
+
+	_, err = store.CompactWithReport(CompactOpts{Keyspaces: []string{"x"}})
+	require.ErrorContains(t, err, "invalid keyspace")
+
+	// Dry run reports new blobs without uploading an archive
+	before := stats.Default.BlobCompactor.Total.Load()
+	tracker := progress.NewTracker()
+	resp, err := store.CompactWithReport(CompactOpts{Keyspaces: []string{"a", "b"}, DryRun: true, Progress: tracker})
+	require.NoError(t, err)
+	p, _ := tracker.Snapshot()
+	require.Equal(t, progress.Progress{Stage: "keyspaces", Done: 2, Total: 2, Bytes: resp.Keyspaces[0].AddedBytes}, p)
+	require.Equal(t, []protocol.KeyspaceCompaction{
+		{Keyspace: "a", Blobs: CompactionAtLeastAddFiles, AddedBlobs: CompactionAtLeastAddFiles, AddedBytes: resp.Keyspaces[0].AddedBytes},
+		{Keyspace: "b", Skipped: true, SkipReason: CompactionSkipNoBlobs},
+	}, resp.Keyspaces)
+	require.Greater(t, resp.Keyspaces[0].AddedBytes, int64(0))
+	require.Equal(t, before, stats.Default.BlobCompactor.Total.Load())
+	require.Nil(t, store.Status().LastCompaction)
+	exists, err := bucket.Exists(ctx, ArchiveKey("a"))
+	require.NoError(t, err)
+	require.False(t, exists)
+
+	resp, err = store.CompactWithReport(CompactOpts{Keyspaces: []string{"a"}})
+	require.NoError(t, err)
+	require.False(t, resp.Keyspaces[0].Skipped)
+	exists, err = bucket.Exists(ctx, ArchiveKey("a"))
+	require.NoError(t, err)
+	require.True(t, exists)
+	last := store.Status().LastCompaction
+	require.NotNil(t, last)
+	require.Equal(t, 1, last.Keyspaces)
+	require.Equal(t, 1, last.Compacted)
+	require.Equal(t, CompactionAtLeastAddFiles, last.AddedBlobs)
+	require.Empty(t, last.Error)
+
+	resp, err = store.CompactWithReport(CompactOpts{Keyspaces: []string{"a"}, DryRun: true})
+	require.NoError(t, err)
+	require.Equal(t, CompactionSkipNothingNew, resp.Keyspaces[0].SkipReason)
+
+	// A purged entry is no longer served from the archive, and the next compaction removes it
+	// even if there is nothing new
+	purged := []byte{0xa0, 0x00}
+	delResp, err := store.Delete(ctx, protocol.DeleteRequest{ActionID: purged, Purge: true})
+	require.NoError(t, err)
+	require.True(t, delResp.DeletedRemote)
+	require.True(t, delResp.InArchive)
+	require.True(t, delResp.PurgeScheduled)
+	getResp, err := store.Get(cache.GetOpts{Req: protocol.GetRequest{ActionID: purged}})
+	require.NoError(t, err)
+	require.True(t, getResp.Miss)
+	resp, err = store.CompactWithReport(CompactOpts{Keyspaces: []string{"a"}})
+	require.NoError(t, err)
+	require.False(t, resp.Keyspaces[0].Skipped)
+	require.Equal(t, 1, resp.Keyspaces[0].RemovedBlobs)
+	require.Nil(t, store.archiveStore.GetArchive("a").Get(CacheEntityNameInArchive(purged)))
+	_, err = os.Stat(PurgeFilePath(cfg.WorkDir))
+	require.True(t, os.IsNotExist(err))
+}
+
+func TestBlobBackend_CompactEgressBudgetExhausted(t *testing.T) {
+	ctx := context.Background()
+	bucketURL := "file://" + t.TempDir()
+	bucket, err := blob.OpenBucket(ctx, bucketURL)
+	require.NoError(t, err)
+	defer bucket.Close()
+	write := func(key string, data []byte) error {
+		return bucket.WriteAll(ctx, key, data, nil)
+	}
+	for i := range CompactionAtLeastAddFiles {
+		writeTestObject(t, write, "", []byte{0xa0, byte(i)}, "data")
+	}
+
+	cfg := DefaultConfig()
+	cfg.URL = bucketURL
+	cfg.WorkDir = t.TempDir()
+	cfg.SkipCompactionOnOpen = true
+	cfg.SkipInitialArchiveSync = true
+	cfg.ArchiveMinSyncInterval = 0
+	cfg.EgressBudgetBytes = 1
+	store, err := NewBlobBackend(cfg)
+	require.NoError(t, err)
+	require.NoError(t, store.Open(ctx))
+	defer store.Close()
+	stats.Default.Clear()
+
+	// The first download uses up the budget
+	resp, err := store.Get(cache.GetOpts{Req: protocol.GetRequest{ActionID: []byte{0xa0, 0x00}}})
+	require.NoError(t, err)
+	require.False(t, resp.Miss)
+	resp, err = store.Get(cache.GetOpts{Req: protocol.GetRequest{ActionID: []byte{0xa0, 0x01}}})
+	require.NoError(t, err)
+	require.True(t, resp.Miss)
+	require.Equal(t, uint32(1), stats.Default.BlobEgress.Suppressed.Load())
+
+	// Compaction fails instead of building an archive without blobs it cannot download
+	compactResp, err := store.CompactWithReport(CompactOpts{Keyspaces: []string{"a"}, Rebuild: true})
+	require.ErrorContains(t, err, "egress budget is exhausted")
+	require.Contains(t, compactResp.Keyspaces[0].Error, "egress budget is exhausted")
+	require.Greater(t, stats.Default.BlobEgress.Suppressed.Load(), uint32(1))
+	require.Equal(t, uint32(0), stats.Default.BlobCompactor.BlobSkipForMissing.Load())
+	exists, err := bucket.Exists(ctx, ArchiveKey("a"))
+	require.NoError(t, err)
+	require.False(t, exists)
+}
+
+func TestBlobBackend_CompactConcurrentCalls(t *testing.T) {
+	ctx := context.Background()
+	bucketURL := "file://" + t.TempDir()
+	bucket, err := blob.OpenBucket(ctx, bucketURL)
+	require.NoError(t, err)
+	defer bucket.Close()
+	write := func(key string, data []byte) error {
+		return bucket.WriteAll(ctx, key, data, nil)
+	}
+	for i := range CompactionAtLeastAddFiles {
+		writeTestObject(t, write, "", []byte{0xa0, byte(i)}, "data")
+		writeTestObject(t, write, "", []byte{0xb0, byte(i)}, "data")
+	}
+
+	cfg := DefaultConfig()
+	cfg.URL = bucketURL
+	cfg.WorkDir = t.TempDir()
+	cfg.SkipCompactionOnOpen = true
+	cfg.SkipInitialArchiveSync = true
+	cfg.ArchiveMinSyncInterval = 0
+	cfg.MaxCompactionConcurrency = 1
+	store, err := NewBlobBackend(cfg)
+	require.NoError(t, err)
+	require.NoError(t, store.Open(ctx))
+	defer store.Close()
+
+	// Downloads are blocked, so that the first compacting keyspace holds its slot
+	opened := make(chan string, 100)
+	unblock := make(chan struct{})
+	openEntry := store.openEntry
+	store.openEntry = func(ctx context.Context, key string) (*blob.Reader, error) {
+		_, actionID, _ := store.keyLayout.DecodeEntityKey(key)
+		opened <- CacheEntityKeyspace(actionID)
+		<-unblock
+		return openEntry(ctx, key)
+	}
+
+	var resps [2]*protocol.CompactResponse
+	var errs [2]error
+	var wg sync.WaitGroup
+	for i, keyspaces := range [][]string{{"a"}, {"b", "a"}} {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			resps[i], errs[i] = store.CompactWithReport(CompactOpts{Keyspaces: keyspaces})
+		}()
+	}
+
+	// Only one keyspace is compacted at a time, across both calls
+	first := <-opened
+	timeout := time.After(200 * time.Millisecond)
+wait:
+	for {
+		select {
+		case keyspace := <-opened:
+			require.Equal(t, first, keyspace)
+		case <-timeout:
+			break wait
+		}
+	}
+	close(unblock)
+	wg.Wait()
+	require.NoError(t, errs[0])
+	require.NoError(t, errs[1])
+
+	// The keyspace in both calls is compacted once, the other call finds nothing new
+	require.ElementsMatch(t, []string{"", CompactionSkipNothingNew},
+		[]string{resps[0].Keyspaces[0].SkipReason, resps[1].Keyspaces[1].SkipReason})
+	require.Empty(t, resps[1].Keyspaces[0].SkipReason)
+}
+
+func TestBlobBackend_CompactDeterministic(t *testing.T) {
+	ctx := context.Background()
+	bucketURL := "file://" + t.TempDir()
+	bucket, err := blob.OpenBucket(ctx, bucketURL)
+	require.NoError(t, err)
+	defer bucket.Close()
+	write := func(key string, data []byte) error {
+		return bucket.WriteAll(ctx, key, data, nil)
+	}
+	for i := range CompactionAtLeastAddFiles {
+		// Not written in name order
+		writeTestObject(t, write, "", []byte{0xa0, byte(CompactionAtLeastAddFiles - i)}, "data")
+	}
+
+	cfg := DefaultConfig()
+	cfg.URL = bucketURL
+	cfg.WorkDir = t.TempDir()
+	cfg.SkipCompactionOnOpen = true
+	cfg.SkipInitialArchiveSync = true
+	cfg.ArchiveMinSyncInterval = 0
+	cfg.DeterministicArchives = true
+	store, err := NewBlobBackend(cfg)
+	require.NoError(t, err)
+	require.NoError(t, store.Open(ctx))
+	defer store.Close()
+
+	_, err = store.CompactWithReport(CompactOpts{Keyspaces: []string{"a"}})
+	require.NoError(t, err)
+	data, err := bucket.ReadAll(ctx, ArchiveKey("a"))
+	require.NoError(t, err)
+	z, err := zip.NewReader(bytes.NewReader(data), int64(len(data)))
+	require.NoError(t, err)
+	names := make([]string, 0, len(z.File))
+	for _, f := range z.File {
+		names = append(names, f.Name)
+	}
+	require.Len(t, names, CompactionAtLeastAddFiles)
+	require.True(t, slices.IsSorted(names))
+
+	// Rebuilding the same entries results in the same archive, which is not uploaded again
+	skipped := stats.Default.BlobArchiveStore.UploadSkipUnchanged.Load()
+	_, err = store.CompactWithReport(CompactOpts{Keyspaces: []string{"a"}, Rebuild: true})
+	require.NoError(t, err)
+	require.Equal(t, skipped+1, stats.Default.BlobArchiveStore.UploadSkipUnchanged.Load())
+}
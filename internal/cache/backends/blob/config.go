@@ -1,15 +1,104 @@
 package blob
 
+import (
+	"time"
+
+	"github.com/breezewish/gscache/internal/util"
+)
+
 type Config struct {
-	URL               string `json:"url"`
+	URL               string `json:"url" redact:"url"`
 	UploadConcurrency int    `json:"upload_concurrency"`
-	WorkDir           string `json:"-"` // Should be set from parent config instead of config file
+	WarmKeyspaces     int    `json:"warm_keyspaces"` // If > 0, only eagerly load the N hottest BlobArchive keyspaces
+	// If > 0, a NotFound when downloading an entry will be retried for N times before concluding a miss.
+	// This is useful for eventually-consistent stores, where an entry just uploaded by another machine
+	// may not be visible immediately. Strongly-consistent stores (like S3) don't need it.
+	NotFoundRetries    int           `json:"not_found_retries"`
+	NotFoundRetryDelay time.Duration `json:"not_found_retry_delay"`
+	// If true, entries of an archive are copied to the local store in background once the archive
+	// is loaded, so that first reads are served locally. Most recent entries are copied first, as
+	// long as they fit in PrematerializeMaxBytes of each archive. An archive synced again without
+	// changes is not copied again.
+	PrematerializeArchives bool  `json:"prematerialize_archives"`
+	PrematerializeMaxBytes int64 `json:"prematerialize_max_bytes"`
+	// If true, archive entries whose name is not the hex of its ActionID are ignored when loading
+	// an archive. Such entries can never be looked up, and usually mean the archive is tampered.
+	ValidateArchiveEntryNames bool `json:"validate_archive_entry_names"`
+	// An archive is not re-downloaded if it was synced within this interval. A lower value lets
+	// new archives produced by compaction (possibly on other machines) become visible sooner,
+	// at the cost of more downloads. It does not need to be lower than the compaction interval,
+	// and for eventually-consistent stores a freshly uploaded archive may still be invisible
+	// until the store converges regardless of this value. If 0, 5s is used.
+	ArchiveMinSyncInterval time.Duration `json:"archive_min_sync_interval"`
+	// If > 0, downloads from remote (entries and archives) are suppressed once this many bytes
+	// are downloaded in the current period, so that Gets are served locally or as misses.
+	// The usage is reset at the start of each period ("daily" or "monthly", in local time).
+	EgressBudgetBytes  int64  `json:"egress_budget_bytes"`
+	EgressBudgetPeriod string `json:"egress_budget_period"`
+	// If > 1, compaction lists each keyspace as 16 sub-prefixes with this concurrency instead of
+	// a single LIST. This speeds up finding small blobs in very large buckets, at the cost of more
+	// LIST requests.
+	CompactionListConcurrency int `json:"compaction_list_concurrency"`
+	// If true, compaction produces byte-identical archives for the same set of small blobs, by
+	// writing entries in name order. Entries are still streamed into the archive.
+	DeterministicArchives bool `json:"deterministic_archives"`
+	// If set, pre-built archives (<keyspace>.zip) in this directory are served when there is no
+	// copy synced from remote. URL can be empty in this case, so that gscache works fully offline
+	// from these archives and the local store, without uploads or compaction.
+	LocalArchiveDir string `json:"local_archive_dir"`
+	// If > 0, at most this many keyspaces are compacted at the same time, including keyspaces of
+	// on-demand and periodic compactions running concurrently. Each compacting keyspace
+	// builds a full temporary archive on disk, so this bounds the temp disk used by a large repack.
+	MaxCompactionConcurrency int `json:"max_compaction_concurrency"`
+	// If set, only these keyspaces (e.g. ["0-7"]) are loaded, synced and compacted by this
+	// instance, so that the archive work of a huge cache can be sharded across multiple daemons.
+	// Entries in other keyspaces are still served from the local store or remote, without archive.
+	Keyspaces []string `json:"keyspaces"`
+	// If > 0, an alert is logged at error level and compaction is reported as unhealthy in the
+	// backend status once this many compaction runs have failed in a row, e.g. when the bucket
+	// permission is lost. Otherwise a broken compactor only shows as slowly growing small blobs.
+	CompactionFailureAlertThreshold int `json:"compaction_failure_alert_threshold"`
+	// Layout of cache entries in the bucket, see KeyLayout. Set it to share a bucket with the
+	// existing layout of another cache tool. Entries in other layouts are not visible.
+	KeyLayout string `json:"key_layout"`
+	WorkDir   string `json:"-"` // Should be set from parent config instead of config file
+	// If > 0, downloads and uploads taking longer than this are logged at info level.
+	// Should be set from parent config instead of config file.
+	SlowThreshold time.Duration `json:"-"`
+	// If true, each zero-size hit gets its own empty file. See local.LocalBackendOpts.PerRequestEmptyFile.
+	// Should be set from parent config instead of config file.
+	PerRequestEmptyFile bool `json:"-"`
+	// Optional. Used for time-based behaviors such as entry time, sync interval and egress
+	// budget periods, so that they can be tested with a fake clock. Defaults to the real clock.
+	Clock util.Clock `json:"-"`
+	// If true, compaction is not started automatically when the backend is opened.
+	// Used when compaction is explicitly driven, e.g. by `gscache compact`.
+	SkipCompactionOnOpen bool `json:"-"`
+	// If true, archives are not synced from remote when the backend is opened.
+	// Used when each compaction syncs the archive by itself anyway.
+	SkipInitialArchiveSync bool `json:"-"`
 }
 
 func DefaultConfig() Config {
 	return Config{
-		URL:               "",
-		UploadConcurrency: 50,
-		WorkDir:           "",
+		URL:                             "",
+		UploadConcurrency:               50,
+		WarmKeyspaces:                   0,
+		NotFoundRetries:                 0,
+		NotFoundRetryDelay:              200 * time.Millisecond,
+		PrematerializeArchives:          false,
+		PrematerializeMaxBytes:          256 * 1024 * 1024,
+		ValidateArchiveEntryNames:       true,
+		ArchiveMinSyncInterval:          ArStoreMinSyncInterval,
+		EgressBudgetBytes:               0,
+		EgressBudgetPeriod:              EgressBudgetPeriodDaily,
+		CompactionListConcurrency:       1,
+		DeterministicArchives:           false,
+		LocalArchiveDir:                 "",
+		MaxCompactionConcurrency:        0,
+		Keyspaces:                       nil,
+		CompactionFailureAlertThreshold: 3,
+		KeyLayout:                       string(KeyLayoutDefault),
+		WorkDir:                         "",
 	}
 }
@@ -0,0 +1,175 @@
+package blob
+
+import (
+	"encoding/json"
+	"errors"
+	"fmt"
+	"os"
+	"path/filepath"
+	"sync"
+	"time"
+
+	"github.com/breezewish/gscache/internal/log"
+	"github.com/breezewish/gscache/internal/stats"
+	gonanoid "github.com/matoous/go-nanoid/v2"
+	"go.uber.org/zap"
+)
+
+const (
+	EgressBudgetPeriodDaily   = "daily"
+	EgressBudgetPeriodMonthly = "monthly"
+
+	// Usage is persisted at most once in this interval, except when the period
+	// is reset or the budget is exhausted.
+	egressBudgetSaveInterval = 10 * time.Second
+)
+
+// errEgressBudgetExhausted is returned instead of a miss to Gets of compactions, so that a
+// compaction fails rather than building an archive without the entries it cannot download.
+var errEgressBudgetExhausted = errors.New("egress budget is exhausted")
+
+func validateEgressBudgetPeriod(period string) error {
+	switch period {
+	case EgressBudgetPeriodDaily, EgressBudgetPeriodMonthly:
+		return nil
+	default:
+		return fmt.Errorf("invalid egress budget period %q, expect %q or %q",
+			period, EgressBudgetPeriodDaily, EgressBudgetPeriodMonthly)
+	}
+}
+
+// egressBudget limits how many bytes can be downloaded from remote in a period.
+// Usage is carried over daemon restarts, so that a daemon restarted after inactivity
+// does not get a fresh budget. A nil egressBudget means unlimited.
+type egressBudget struct {
+	path   string
+	limit  int64
+	period string
+	now    func() time.Time
+
+	mu          sync.Mutex
+	periodStart time.Time
+	used        int64
+	warned      bool // Whether the exhausted warning is logged in this period.
+	lastSaveAt  time.Time
+}
+
+type egressBudgetState struct {
+	PeriodStart time.Time `json:"period_start"`
+	UsedBytes   int64     `json:"used_bytes"`
+}
+
+func newEgressBudget(workDir string, limit int64, period string) *egressBudget {
+	if limit <= 0 {
+		return nil
+	}
+	b := &egressBudget{
+		path:   EgressBudgetFilePath(workDir),
+		limit:  limit,
+		period: period,
+		now:    time.Now,
+	}
+	var state egressBudgetState
+	if data, err := os.ReadFile(b.path); err == nil {
+		_ = json.Unmarshal(data, &state)
+	}
+	// The period is checked and reset lazily when the budget is used.
+	b.periodStart = state.PeriodStart
+	b.used = state.UsedBytes
+	b.updateStatsLocked()
+	return b
+}
+
+func (b *egressBudget) currentPeriodStart() time.Time {
+	now := b.now()
+	if b.period == EgressBudgetPeriodMonthly {
+		return time.Date(now.Year(), now.Month(), 1, 0, 0, 0, 0, now.Location())
+	}
+	return time.Date(now.Year(), now.Month(), now.Day(), 0, 0, 0, 0, now.Location())
+}
+
+func (b *egressBudget) maybeResetLocked() {
+	start := b.currentPeriodStart()
+	if b.periodStart.Equal(start) {
+		return
+	}
+	b.periodStart = start
+	b.used = 0
+	b.warned = false
+	b.updateStatsLocked()
+	b.saveLocked()
+}
+
+func (b *egressBudget) updateStatsLocked() {
+	stats.Default.BlobEgress.UsedBytes.Store(uint64(b.used))
+	stats.Default.BlobEgress.LimitBytes.Store(uint64(b.limit))
+}
+
+// Allow returns false if the budget of the current period is exhausted,
+// in which case the download should be suppressed.
+func (b *egressBudget) Allow() bool {
+	if b == nil {
+		return true
+	}
+	b.mu.Lock()
+	defer b.mu.Unlock()
+	b.maybeResetLocked()
+	if b.used < b.limit {
+		return true
+	}
+	stats.Default.BlobEgress.Suppressed.Inc()
+	if !b.warned {
+		b.warned = true
+		log.Warn("Egress budget is exhausted, downloads from remote are suppressed until the next period",
+			zap.Int64("usedBytes", b.used),
+			zap.Int64("budgetBytes", b.limit),
+			zap.String("period", b.period),
+			zap.Time("periodStart", b.periodStart))
+	}
+	return false
+}
+
+// Record accounts downloaded bytes.
+func (b *egressBudget) Record(n int64) {
+	if b == nil || n <= 0 {
+		return
+	}
+	b.mu.Lock()
+	defer b.mu.Unlock()
+	b.maybeResetLocked()
+	b.used += n
+	b.updateStatsLocked()
+	if b.used >= b.limit || b.now().Sub(b.lastSaveAt) >= egressBudgetSaveInterval {
+		b.saveLocked()
+	}
+}
+
+func (b *egressBudget) Save() {
+	if b == nil {
+		return
+	}
+	b.mu.Lock()
+	defer b.mu.Unlock()
+	b.saveLocked()
+}
+
+func (b *egressBudget) saveLocked() {
+	b.lastSaveAt = b.now()
+	data, err := json.Marshal(egressBudgetState{
+		PeriodStart: b.periodStart,
+		UsedBytes:   b.used,
+	})
+	if err != nil {
+		return
+	}
+	_ = os.MkdirAll(filepath.Dir(b.path), 0755)
+	tmpPath := b.path + ".tmp." + gonanoid.Must(8)
+	if err := os.WriteFile(tmpPath, data, 0644); err != nil {
+		log.Warn("Failed to save egress budget usage", zap.Error(err))
+		return
+	}
+	if err := os.Rename(tmpPath, b.path); err != nil {
+		_ = os.Remove(tmpPath)
+		log.Warn("Failed to save egress budget usage", zap.Error(err))
+	}
+}
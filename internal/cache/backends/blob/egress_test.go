@@ -0,0 +1,65 @@
+package blob
+
+import (
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/require"
+)
+
+func TestEgressBudget_Unlimited(t *testing.T) {
+	b := newEgressBudget(t.TempDir(), 0, EgressBudgetPeriodDaily)
+	require.Nil(t, b)
+	require.True(t, b.Allow())
+	b.Record(100)
+	b.Save()
+}
+
+func TestEgressBudget_ExhaustAndReset(t *testing.T) {
+	workDir := t.TempDir()
+	now := time.Date(2025, 3, 10, 12, 0, 0, 0, time.Local)
+	newBudget := func() *egressBudget {
+		b := newEgressBudget(workDir, 100, EgressBudgetPeriodDaily)
+		b.now = func() time.Time { return now }
+		return b
+	}
+
+	b := newBudget()
+	require.True(t, b.Allow())
+	b.Record(60)
+	require.True(t, b.Allow())
+	b.Record(60)
+	require.False(t, b.Allow())
+
+	// Usage is carried over restarts within the same period
+	b = newBudget()
+	require.False(t, b.Allow())
+
+	// Reset in the next day
+	now = now.Add(12 * time.Hour)
+	require.True(t, b.Allow())
+	b.Record(10)
+	b.Save()
+
+	b = newBudget()
+	require.Equal(t, int64(10), b.used)
+}
+
+func TestEgressBudget_Monthly(t *testing.T) {
+	now := time.Date(2025, 3, 10, 12, 0, 0, 0, time.Local)
+	b := newEgressBudget(t.TempDir(), 100, EgressBudgetPeriodMonthly)
+	b.now = func() time.Time { return now }
+	b.Record(100)
+	require.False(t, b.Allow())
+
+	now = time.Date(2025, 3, 31, 23, 0, 0, 0, time.Local)
+	require.False(t, b.Allow())
+	now = time.Date(2025, 4, 1, 0, 0, 0, 0, time.Local)
+	require.True(t, b.Allow())
+}
+
+func TestValidateEgressBudgetPeriod(t *testing.T) {
+	require.NoError(t, validateEgressBudgetPeriod("daily"))
+	require.NoError(t, validateEgressBudgetPeriod("monthly"))
+	require.Error(t, validateEgressBudgetPeriod("weekly"))
+}
@@ -0,0 +1,43 @@
+package blob
+
+import (
+	"context"
+	"sync"
+	"time"
+)
+
+// remoteCheckInterval is how long a check of the remote is reused by CheckRemote.
+const remoteCheckInterval = 30 * time.Second
+
+// remoteHealth is the result of the last check of the remote.
+type remoteHealth struct {
+	mu          sync.Mutex
+	checkedAt   time.Time
+	respondedAt time.Time // Last time the remote responded, zero if never
+	err         error
+}
+
+// CheckRemote checks whether the bucket responds by a HEAD request of the probe prefix,
+// which is cheap and needs no write permission.
+func (store *BlobBackend) CheckRemote(ctx context.Context) (time.Time, error) {
+	if store.bucket == nil {
+		return time.Time{}, nil
+	}
+	h := &store.remoteHealth
+	h.mu.Lock()
+	defer h.mu.Unlock()
+	now := store.config.Clock.Now()
+	if !h.checkedAt.IsZero() && now.Sub(h.checkedAt) < remoteCheckInterval {
+		return h.respondedAt, h.err
+	}
+	checkCtx, cancel := context.WithTimeout(ctx, ProbeTimeout)
+	defer cancel()
+	// A missing object is a response as well
+	_, err := store.bucket.Exists(checkCtx, probeKeyPrefix+"health")
+	h.checkedAt = now
+	h.err = err
+	if err == nil {
+		h.respondedAt = now
+	}
+	return h.respondedAt, h.err
+}
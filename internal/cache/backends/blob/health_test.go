@@ -0,0 +1,54 @@
+package blob
+
+import (
+	"context"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/require"
+
+	"github.com/breezewish/gscache/internal/util"
+)
+
+func TestBlobBackend_CheckRemote(t *testing.T) {
+	ctx := context.Background()
+	bucketDir := t.TempDir()
+	clock := util.NewFakeClock(time.Date(2025, 1, 1, 0, 0, 0, 0, time.UTC))
+	cfg := DefaultConfig()
+	cfg.URL = "file://" + bucketDir
+	cfg.WorkDir = t.TempDir()
+	cfg.SkipCompactionOnOpen = true
+	cfg.SkipInitialArchiveSync = true
+	cfg.Clock = clock
+	store, err := NewBlobBackend(cfg)
+	require.NoError(t, err)
+	require.NoError(t, store.Open(ctx))
+	defer store.Close()
+
+	respondedAt, err := store.CheckRemote(ctx)
+	require.NoError(t, err)
+	require.Equal(t, clock.Now(), respondedAt)
+
+	// Reused within the interval
+	clock.Advance(time.Second)
+	respondedAt, err = store.CheckRemote(ctx)
+	require.NoError(t, err)
+	require.Equal(t, clock.Now().Add(-time.Second), respondedAt)
+
+	clock.Advance(remoteCheckInterval)
+	respondedAt, err = store.CheckRemote(ctx)
+	require.NoError(t, err)
+	require.Equal(t, clock.Now(), respondedAt)
+
+	// There is no remote to check with only a local archive dir
+	cfg.URL = ""
+	cfg.LocalArchiveDir = t.TempDir()
+	cfg.WorkDir = t.TempDir()
+	localStore, err := NewBlobBackend(cfg)
+	require.NoError(t, err)
+	require.NoError(t, localStore.Open(ctx))
+	defer localStore.Close()
+	respondedAt, err = localStore.CheckRemote(ctx)
+	require.NoError(t, err)
+	require.True(t, respondedAt.IsZero())
+}
@@ -3,29 +3,127 @@ package blob
 import (
 	"encoding/hex"
 	"fmt"
+	"slices"
+	"strings"
 )
 
 // Key is for Object Store
 // Path is for Local File System
 
-func CacheEntityKey(actionID []byte) string {
-	return fmt.Sprintf("b/%02x/%x", actionID[0], actionID)
+// KeyLayout is how cache entries are laid out in the bucket, so that gscache can share a bucket
+// with the layout of another cache tool. It does not affect archives. The zero value is the
+// default layout.
+type KeyLayout string
+
+const (
+	// KeyLayoutDefault stores entries at b/<xx>/<actionID>.
+	KeyLayoutDefault KeyLayout = "b"
+	// KeyLayoutSha stores entries at sha/<xx>/<actionID without the first byte>, like git objects.
+	KeyLayoutSha KeyLayout = "sha"
+)
+
+var KeyLayouts = []KeyLayout{KeyLayoutDefault, KeyLayoutSha}
+
+// ParseKeyLayout returns the layout of the name. Empty name means the default layout.
+func ParseKeyLayout(name string) (KeyLayout, error) {
+	if name == "" {
+		return KeyLayoutDefault, nil
+	}
+	if !slices.Contains(KeyLayouts, KeyLayout(name)) {
+		return "", fmt.Errorf("invalid key layout %q, must be one of %v", name, KeyLayouts)
+	}
+	return KeyLayout(name), nil
+}
+
+// EntityKey returns the object key of a cache entry. Entries in the default (empty) namespace
+// are stored at <layout>, while entries in other namespaces are stored at ns/<namespace>/<layout>,
+// so that a namespace can be cleaned up by prefix.
+func (l KeyLayout) EntityKey(namespace string, actionID []byte) string {
+	key := ""
+	if l == KeyLayoutSha {
+		key = fmt.Sprintf("sha/%02x/%x", actionID[0], actionID[1:])
+	} else {
+		key = fmt.Sprintf("b/%02x/%x", actionID[0], actionID)
+	}
+	if namespace != "" {
+		return NamespacePrefixKey(namespace) + key
+	}
+	return key
 }
 
-func DecodeCacheEntityKey(key string) (actionID []byte, err error) {
-	if len(key) < 5 || key[0] != 'b' || key[1] != '/' || key[4] != '/' {
-		return nil, fmt.Errorf("invalid cache entity key %s", key)
+// DecodeEntityKey is the reverse of EntityKey. Keys of other layouts are rejected.
+func (l KeyLayout) DecodeEntityKey(key string) (namespace string, actionID []byte, err error) {
+	entityKey := key
+	if strings.HasPrefix(key, "ns/") {
+		parts := strings.SplitN(key, "/", 3)
+		if len(parts) != 3 || parts[1] == "" {
+			return "", nil, fmt.Errorf("invalid cache entity key %s", key)
+		}
+		namespace = parts[1]
+		entityKey = parts[2]
+	}
+	rest, ok := strings.CutPrefix(entityKey, l.rootPrefixKey())
+	if !ok || len(rest) < 3 || rest[2] != '/' {
+		return "", nil, fmt.Errorf("invalid cache entity key %s", key)
+	}
+	actionIDInHex := rest[3:]
+	if l == KeyLayoutSha {
+		actionIDInHex = rest[:2] + actionIDInHex
 	}
-	actionIDInHex := key[5:]
 	actionIdInBytes, err := hex.DecodeString(actionIDInHex)
-	if err != nil {
-		return nil, fmt.Errorf("invalid cache entity key %s", key)
+	if err != nil || len(actionIdInBytes) == 0 {
+		return "", nil, fmt.Errorf("invalid cache entity key %s", key)
+	}
+	if l.EntityKey(namespace, actionIdInBytes) != key {
+		// This also compares the <xx> part
+		return "", nil, fmt.Errorf("invalid cache entity key %s", key)
 	}
-	if CacheEntityKey(actionIdInBytes) != key {
-		// This also compares the b/%02x part
-		return nil, fmt.Errorf("invalid cache entity key %s", key)
+	return namespace, actionIdInBytes, nil
+}
+
+// rootPrefixKey returns the prefix of all entries in the default namespace.
+func (l KeyLayout) rootPrefixKey() string {
+	if l == KeyLayoutSha {
+		return "sha/"
 	}
-	return actionIdInBytes, nil
+	return "b/"
+}
+
+// ListPrefixKey returns the prefix of entries in the default namespace whose actionID starts
+// with the given hex prefix, e.g. a keyspace.
+func (l KeyLayout) ListPrefixKey(hexPrefix string) string {
+	return l.rootPrefixKey() + hexPrefix
+}
+
+// EntityPrefixKey returns the prefix of object keys of entries in the namespace whose actionID
+// starts with the given hex prefix.
+func (l KeyLayout) EntityPrefixKey(namespace string, hexPrefix string) string {
+	key := l.rootPrefixKey() + hexPrefix
+	if len(hexPrefix) > 2 {
+		if l == KeyLayoutSha {
+			key = l.rootPrefixKey() + hexPrefix[:2] + "/" + hexPrefix[2:]
+		} else {
+			key = l.rootPrefixKey() + hexPrefix[:2] + "/" + hexPrefix
+		}
+	}
+	if namespace != "" {
+		return NamespacePrefixKey(namespace) + key
+	}
+	return key
+}
+
+// CacheEntityKey returns the object key of a cache entry in the default layout.
+func CacheEntityKey(namespace string, actionID []byte) string {
+	return KeyLayoutDefault.EntityKey(namespace, actionID)
+}
+
+func NamespacePrefixKey(namespace string) string {
+	return fmt.Sprintf("ns/%s/", namespace)
+}
+
+// DecodeCacheEntityKey is the reverse of CacheEntityKey.
+func DecodeCacheEntityKey(key string) (namespace string, actionID []byte, err error) {
+	return KeyLayoutDefault.DecodeEntityKey(key)
 }
 
 func CacheEntityNameInArchive(actionID []byte) string {
@@ -33,7 +131,7 @@ func CacheEntityNameInArchive(actionID []byte) string {
 }
 
 func ArchiveListPrefixKey(keyspace string) string {
-	return fmt.Sprintf("b/%s", keyspace)
+	return KeyLayoutDefault.ListPrefixKey(keyspace)
 }
 
 func ArchiveKey(keyspace string) string {
@@ -44,11 +142,51 @@ func ArchiveFilePath(workDir, keyspace string) string {
 	return fmt.Sprintf("%s/blobar/%s.zip", workDir, keyspace)
 }
 
+func AffinityFilePath(workDir string) string {
+	return fmt.Sprintf("%s/blobar/affinity.json", workDir)
+}
+
+func PurgeFilePath(workDir string) string {
+	return fmt.Sprintf("%s/blobar/purge.json", workDir)
+}
+
+func EgressBudgetFilePath(workDir string) string {
+	return fmt.Sprintf("%s/egress_budget.json", workDir)
+}
+
 var ArchiveKeyspaces = []string{
 	"0", "1", "2", "3", "4", "5", "6", "7",
 	"8", "9", "a", "b", "c", "d", "e", "f",
 }
 
+// ParseKeyspaces expands a list of keyspaces, where each item is either a single keyspace
+// like "a" or an inclusive range like "0-7", into a sorted list of distinct keyspaces.
+func ParseKeyspaces(items []string) ([]string, error) {
+	selected := make(map[string]struct{})
+	for _, item := range items {
+		item = strings.ToLower(strings.TrimSpace(item))
+		from, to, isRange := strings.Cut(item, "-")
+		if !isRange {
+			to = from
+		}
+		fromIdx := slices.Index(ArchiveKeyspaces, from)
+		toIdx := slices.Index(ArchiveKeyspaces, to)
+		if fromIdx < 0 || toIdx < 0 || fromIdx > toIdx {
+			return nil, fmt.Errorf("invalid keyspace %q, must be a hex char or a range like 0-7", item)
+		}
+		for _, keyspace := range ArchiveKeyspaces[fromIdx : toIdx+1] {
+			selected[keyspace] = struct{}{}
+		}
+	}
+	result := make([]string, 0, len(selected))
+	for _, keyspace := range ArchiveKeyspaces {
+		if _, ok := selected[keyspace]; ok {
+			result = append(result, keyspace)
+		}
+	}
+	return result, nil
+}
+
 func CacheEntityKeyspace(actionID []byte) string {
 	return fmt.Sprintf("%02x", actionID[0])[0:1]
 }
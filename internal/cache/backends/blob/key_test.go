@@ -1,6 +1,7 @@
 package blob
 
 import (
+	"strings"
 	"testing"
 
 	"github.com/stretchr/testify/require"
@@ -14,12 +15,111 @@ func TestDecodeCacheEntityKey_RoundTrip(t *testing.T) {
 		{0x12, 0x34, 0x56, 0x78, 0x90, 0xab, 0xcd, 0xef},
 		{0x00, 0x11, 0x22, 0x33, 0x44, 0x55, 0x66, 0x77, 0x88, 0x99, 0xaa, 0xbb, 0xcc, 0xdd, 0xee, 0xff},
 	}
-	for _, originalActionID := range testActionIDs {
-		t.Run("", func(t *testing.T) {
-			key := CacheEntityKey(originalActionID)
-			decodedActionID, err := DecodeCacheEntityKey(key)
-			require.NoError(t, err)
+	for _, namespace := range []string{"", "go1.24.2_linux_amd64"} {
+		for _, originalActionID := range testActionIDs {
+			t.Run("", func(t *testing.T) {
+				key := CacheEntityKey(namespace, originalActionID)
+				decodedNamespace, decodedActionID, err := DecodeCacheEntityKey(key)
+				require.NoError(t, err)
+				require.Equal(t, namespace, decodedNamespace)
+				require.Equal(t, originalActionID, decodedActionID)
+			})
+		}
+	}
+}
+
+func TestCacheEntityKey_Namespace(t *testing.T) {
+	actionID := []byte{0xab, 0xcd}
+	require.Equal(t, "b/ab/abcd", CacheEntityKey("", actionID))
+	require.Equal(t, "ns/go1.24_linux_amd64/b/ab/abcd", CacheEntityKey("go1.24_linux_amd64", actionID))
+}
+
+func TestEntityPrefixKey(t *testing.T) {
+	actionID := []byte{0xab, 0xcd, 0xef}
+	for _, layout := range KeyLayouts {
+		for _, namespace := range []string{"", "ns1"} {
+			key := layout.EntityKey(namespace, actionID)
+			for _, prefix := range []string{"a", "ab", "abc", "abcdef"} {
+				require.True(t, strings.HasPrefix(key, layout.EntityPrefixKey(namespace, prefix)), "%s %s", key, prefix)
+			}
+			require.False(t, strings.HasPrefix(key, layout.EntityPrefixKey(namespace, "abd")), key)
+		}
+	}
+	require.Equal(t, "b/ab/abc", KeyLayoutDefault.EntityPrefixKey("", "abc"))
+	require.Equal(t, "ns/ns1/sha/ab/c", KeyLayoutSha.EntityPrefixKey("ns1", "abc"))
+}
+
+func TestDecodeCacheEntityKey_Invalid(t *testing.T) {
+	for _, key := range []string{
+		"",
+		"b/ab/",
+		"b/ab/xyz",
+		"b/00/abcd",
+		"ns//b/ab/abcd",
+		"ns/foo",
+		"ns/foo/c/ab/abcd",
+		"blobar/a.zip",
+	} {
+		_, _, err := DecodeCacheEntityKey(key)
+		require.Error(t, err, key)
+	}
+}
+
+func TestKeyLayoutSha(t *testing.T) {
+	actionID := []byte{0xab, 0xcd, 0xef}
+	require.Equal(t, "sha/ab/cdef", KeyLayoutSha.EntityKey("", actionID))
+	require.Equal(t, "ns/foo/sha/ab/cdef", KeyLayoutSha.EntityKey("foo", actionID))
+	require.Equal(t, "sha/a", KeyLayoutSha.ListPrefixKey("a"))
+
+	for _, namespace := range []string{"", "foo"} {
+		for _, originalActionID := range [][]byte{{0x00}, {0xab, 0xcd}, {0x12, 0x34, 0x56, 0x78}} {
+			key := KeyLayoutSha.EntityKey(namespace, originalActionID)
+			decodedNamespace, decodedActionID, err := KeyLayoutSha.DecodeEntityKey(key)
+			require.NoError(t, err, key)
+			require.Equal(t, namespace, decodedNamespace)
 			require.Equal(t, originalActionID, decodedActionID)
-		})
+
+			// Keys of another layout are not recognized
+			_, _, err = DecodeCacheEntityKey(key)
+			require.Error(t, err, key)
+			_, _, err = KeyLayoutSha.DecodeEntityKey(CacheEntityKey(namespace, originalActionID))
+			require.Error(t, err, key)
+		}
+	}
+
+	for _, key := range []string{"sha/ab/xyz", "sha/ab", "sha/abcd", "sha/00/abcd/"} {
+		_, _, err := KeyLayoutSha.DecodeEntityKey(key)
+		require.Error(t, err, key)
+	}
+}
+
+func TestParseKeyLayout(t *testing.T) {
+	layout, err := ParseKeyLayout("")
+	require.NoError(t, err)
+	require.Equal(t, KeyLayoutDefault, layout)
+	layout, err = ParseKeyLayout("sha")
+	require.NoError(t, err)
+	require.Equal(t, KeyLayoutSha, layout)
+	_, err = ParseKeyLayout("SHA")
+	require.Error(t, err)
+
+	// Zero value is the default layout
+	var zero KeyLayout
+	require.Equal(t, CacheEntityKey("", []byte{0xab}), zero.EntityKey("", []byte{0xab}))
+	require.Equal(t, ArchiveListPrefixKey("a"), zero.ListPrefixKey("a"))
+}
+
+func TestParseKeyspaces(t *testing.T) {
+	keyspaces, err := ParseKeyspaces([]string{"0-3", "a", "2", "F"})
+	require.NoError(t, err)
+	require.Equal(t, []string{"0", "1", "2", "3", "a", "f"}, keyspaces)
+
+	keyspaces, err = ParseKeyspaces([]string{"8-f"})
+	require.NoError(t, err)
+	require.Equal(t, ArchiveKeyspaces[8:], keyspaces)
+
+	for _, invalid := range []string{"", "g", "7-0", "0-", "ab"} {
+		_, err := ParseKeyspaces([]string{invalid})
+		require.Error(t, err, invalid)
 	}
 }
@@ -0,0 +1,120 @@
+package blob
+
+import (
+	"context"
+	"fmt"
+	"io"
+	"slices"
+	"strings"
+	"time"
+
+	"gocloud.dev/blob"
+
+	"github.com/breezewish/gscache/internal/protocol"
+)
+
+// RemoteEntry is a standalone object of a cache entry in the remote bucket. Entries compacted
+// into archives are not included.
+type RemoteEntry struct {
+	Namespace string
+	ActionID  []byte
+	Size      int64 // Size of the object, including the entry header
+	ModTime   time.Time
+}
+
+// ListRemoteEntries calls fn for each standalone cache entry object in the bucket, until fn
+// returns an error. Only object listings are read, objects are not downloaded.
+func ListRemoteEntries(ctx context.Context, remote *blob.Bucket, layout KeyLayout, fn func(entry RemoteEntry) error) error {
+	for _, prefix := range []string{layout.rootPrefixKey(), "ns/"} {
+		iter := remote.List(&blob.ListOptions{Prefix: prefix})
+		for {
+			ctxList, cancel := context.WithTimeout(ctx, CompactionListFilesTimeout)
+			obj, err := iter.Next(ctxList)
+			cancel()
+			if err == io.EOF {
+				break
+			}
+			if err != nil {
+				return fmt.Errorf("failed to list objects using prefix %s: %w", prefix, err)
+			}
+			if obj.IsDir {
+				continue
+			}
+			namespace, actionID, err := layout.DecodeEntityKey(obj.Key)
+			if err != nil {
+				// Not written by gscache
+				continue
+			}
+			if err := fn(RemoteEntry{
+				Namespace: namespace,
+				ActionID:  actionID,
+				Size:      obj.Size,
+				ModTime:   obj.ModTime,
+			}); err != nil {
+				return err
+			}
+		}
+	}
+	return nil
+}
+
+// RemoteUsage sums sizes of objects in the bucket by keyspace and namespace. Only object
+// listings are read.
+func RemoteUsage(ctx context.Context, remote *blob.Bucket, layout KeyLayout) (*protocol.RemoteSize, error) {
+	usage := &protocol.RemoteSize{Other: protocol.SizeItem{Name: "other"}}
+	usage.Keyspaces = make([]protocol.KeyspaceSize, len(ArchiveKeyspaces))
+	keyspaces := make(map[string]*protocol.KeyspaceSize)
+	archives := make(map[string]*protocol.KeyspaceSize) // By archive key
+	for i, keyspace := range ArchiveKeyspaces {
+		usage.Keyspaces[i].Keyspace = keyspace
+		keyspaces[keyspace] = &usage.Keyspaces[i]
+		archives[ArchiveKey(keyspace)] = &usage.Keyspaces[i]
+	}
+	namespaces := make(map[string]*protocol.SizeItem)
+
+	iter := remote.List(&blob.ListOptions{})
+	for {
+		ctxList, cancel := context.WithTimeout(ctx, CompactionListFilesTimeout)
+		obj, err := iter.Next(ctxList)
+		cancel()
+		if err == io.EOF {
+			break
+		}
+		if err != nil {
+			return nil, fmt.Errorf("failed to list objects: %w", err)
+		}
+		if obj.IsDir {
+			continue
+		}
+		usage.Bytes += obj.Size
+		if ks := archives[obj.Key]; ks != nil {
+			ks.ArchiveBytes += obj.Size
+			continue
+		}
+		namespace, actionID, err := layout.DecodeEntityKey(obj.Key)
+		switch {
+		case err != nil:
+			usage.Other.Files++
+			usage.Other.Bytes += obj.Size
+		case namespace == "":
+			ks := keyspaces[CacheEntityKeyspace(actionID)]
+			ks.Blobs++
+			ks.BlobBytes += obj.Size
+		default:
+			ns := namespaces[namespace]
+			if ns == nil {
+				ns = &protocol.SizeItem{Name: namespace}
+				namespaces[namespace] = ns
+			}
+			ns.Files++
+			ns.Bytes += obj.Size
+		}
+	}
+	for _, ns := range namespaces {
+		usage.Namespaces = append(usage.Namespaces, *ns)
+	}
+	slices.SortFunc(usage.Namespaces, func(a, b protocol.SizeItem) int {
+		return strings.Compare(a.Name, b.Name)
+	})
+	return usage, nil
+}
@@ -0,0 +1,72 @@
+package blob
+
+import (
+	"context"
+	"fmt"
+	"slices"
+	"sort"
+	"testing"
+
+	"github.com/stretchr/testify/require"
+	"gocloud.dev/blob/memblob"
+)
+
+func TestListRemoteEntries(t *testing.T) {
+	ctx := context.Background()
+	bucket := memblob.OpenBucket(nil)
+	defer bucket.Close()
+	write := func(key string, data []byte) error {
+		return bucket.WriteAll(ctx, key, data, nil)
+	}
+
+	writeTestObject(t, write, "", []byte{0xa1, 0x01}, "hello")
+	writeTestObject(t, write, "go1.24_linux_amd64", []byte{0x02}, "world!")
+	// Archives and foreign objects are not entries
+	require.NoError(t, bucket.WriteAll(ctx, ArchiveKey("a"), []byte("zip"), nil))
+	require.NoError(t, bucket.WriteAll(ctx, "b/zz/not-hex", []byte("x"), nil))
+
+	listed := make([]string, 0)
+	err := ListRemoteEntries(ctx, bucket, KeyLayoutDefault, func(e RemoteEntry) error {
+		require.False(t, e.ModTime.IsZero())
+		listed = append(listed, fmt.Sprintf("%s/%x", e.Namespace, e.ActionID))
+		return nil
+	})
+	require.NoError(t, err)
+	sort.Strings(listed)
+	require.Equal(t, []string{"/a101", "go1.24_linux_amd64/02"}, listed)
+
+	// Errors of fn stop the listing
+	calls := 0
+	err = ListRemoteEntries(ctx, bucket, KeyLayoutDefault, func(e RemoteEntry) error {
+		calls++
+		return fmt.Errorf("stop")
+	})
+	require.EqualError(t, err, "stop")
+	require.Equal(t, 1, calls)
+}
+
+func TestRemoteUsage(t *testing.T) {
+	ctx := context.Background()
+	bucket := memblob.OpenBucket(nil)
+	defer bucket.Close()
+	write := func(key string, data []byte) error {
+		return bucket.WriteAll(ctx, key, data, nil)
+	}
+	writeTestObject(t, write, "", []byte{0xa1, 0x01}, "hello")
+	writeTestObject(t, write, "", []byte{0xa2}, "hello")
+	writeTestObject(t, write, "go1.24_linux_amd64", []byte{0x02}, "world!")
+	require.NoError(t, bucket.WriteAll(ctx, ArchiveKey("a"), []byte("zip"), nil))
+	require.NoError(t, bucket.WriteAll(ctx, "b/zz/not-hex", []byte("x"), nil))
+
+	usage, err := RemoteUsage(ctx, bucket, KeyLayoutDefault)
+	require.NoError(t, err)
+	require.Len(t, usage.Keyspaces, len(ArchiveKeyspaces))
+	ks := usage.Keyspaces[slices.Index(ArchiveKeyspaces, "a")]
+	require.Equal(t, int64(2), ks.Blobs)
+	require.Equal(t, int64(3), ks.ArchiveBytes)
+	require.Len(t, usage.Namespaces, 1)
+	require.Equal(t, "go1.24_linux_amd64", usage.Namespaces[0].Name)
+	require.Equal(t, int64(1), usage.Namespaces[0].Files)
+	require.Equal(t, int64(1), usage.Other.Files)
+	require.Equal(t, usage.Bytes, ks.BlobBytes+ks.ArchiveBytes+usage.Namespaces[0].Bytes+usage.Other.Bytes)
+}
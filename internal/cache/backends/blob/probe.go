@@ -0,0 +1,160 @@
+package blob
+
+import (
+	"bytes"
+	"context"
+	"errors"
+	"fmt"
+	"io"
+	"net"
+	"strings"
+	"time"
+
+	gonanoid "github.com/matoous/go-nanoid/v2"
+	"gocloud.dev/blob"
+	"gocloud.dev/gcerrors"
+)
+
+const (
+	// Probe objects are written under this prefix, which never collides with cache entries or
+	// archives, so that a probe left behind by an interrupted run is harmless.
+	probeKeyPrefix = "gscache-probe/"
+	ProbeTimeout   = 10 * time.Second
+)
+
+const (
+	ProbeOpWrite  = "write"
+	ProbeOpRead   = "read"
+	ProbeOpList   = "list"
+	ProbeOpDelete = "delete"
+)
+
+// Kinds of probe failures returned by ClassifyProbeError.
+const (
+	ProbeErrorNetwork     = "network"
+	ProbeErrorCredentials = "credentials"
+	ProbeErrorBucket      = "bucket"
+	ProbeErrorOther       = "other"
+)
+
+// credentialErrorHints are substrings of provider errors caused by missing, invalid or
+// insufficient credentials, which are not always mapped to gcerrors.PermissionDenied.
+var credentialErrorHints = []string{
+	"AccessDenied", "InvalidAccessKeyId", "SignatureDoesNotMatch", "ExpiredToken",
+	"InvalidToken", "AuthorizationFailure", "AuthenticationFailed", "credential",
+	"StatusCode: 401", "StatusCode: 403", "Error 401", "Error 403",
+}
+
+// ClassifyProbeError tells whether a failed probe operation is caused by the network (e.g. DNS,
+// proxy or firewall), credentials, or a bucket which does not exist.
+func ClassifyProbeError(err error) string {
+	if err == nil {
+		return ""
+	}
+	var netErr net.Error
+	if errors.Is(err, context.DeadlineExceeded) || gcerrors.Code(err) == gcerrors.DeadlineExceeded || errors.As(err, &netErr) {
+		return ProbeErrorNetwork
+	}
+	if gcerrors.Code(err) == gcerrors.PermissionDenied {
+		return ProbeErrorCredentials
+	}
+	msg := err.Error()
+	for _, hint := range credentialErrorHints {
+		if strings.Contains(msg, hint) {
+			return ProbeErrorCredentials
+		}
+	}
+	if strings.Contains(msg, "NoSuchBucket") || strings.Contains(msg, "ContainerNotFound") ||
+		strings.Contains(msg, "bucket does not exist") {
+		return ProbeErrorBucket
+	}
+	return ProbeErrorOther
+}
+
+type ProbeOpResult struct {
+	Op      string
+	Latency time.Duration
+	Err     error
+}
+
+type ProbeReport struct {
+	Ops []ProbeOpResult
+	// Local clock minus the remote clock, estimated from the modification time of the probe
+	// object. Remote modification times usually have a precision of one second. Only valid if
+	// ClockSkewKnown is true.
+	ClockSkew      time.Duration
+	ClockSkewKnown bool
+}
+
+// Failed returns the first failed operation, or nil if all operations succeeded.
+func (r *ProbeReport) Failed() *ProbeOpResult {
+	for i := range r.Ops {
+		if r.Ops[i].Err != nil {
+			return &r.Ops[i]
+		}
+	}
+	return nil
+}
+
+// ProbeRemote writes, reads, lists and deletes a small probe object, which are all operations
+// the daemon needs, so that credential or network problems are found before builds silently
+// fall back to misses. Each operation has its own ProbeTimeout. Operations after a failed
+// write are skipped, as there is nothing to read or delete.
+func ProbeRemote(ctx context.Context, bucket *blob.Bucket) *ProbeReport {
+	report := &ProbeReport{}
+	key := probeKeyPrefix + gonanoid.Must(16)
+	content := []byte("gscache probe " + key)
+	run := func(op string, fn func(ctx context.Context) error) error {
+		opCtx, cancel := context.WithTimeout(ctx, ProbeTimeout)
+		defer cancel()
+		t := time.Now()
+		err := fn(opCtx)
+		report.Ops = append(report.Ops, ProbeOpResult{Op: op, Latency: time.Since(t), Err: err})
+		return err
+	}
+
+	var writeStart, writeEnd time.Time
+	err := run(ProbeOpWrite, func(ctx context.Context) error {
+		writeStart = time.Now()
+		err := bucket.WriteAll(ctx, key, content, nil)
+		writeEnd = time.Now()
+		return err
+	})
+	if err != nil {
+		return report
+	}
+	_ = run(ProbeOpRead, func(ctx context.Context) error {
+		data, err := bucket.ReadAll(ctx, key)
+		if err != nil {
+			return err
+		}
+		if !bytes.Equal(data, content) {
+			return fmt.Errorf("probe object content mismatch")
+		}
+		attrs, err := bucket.Attributes(ctx, key)
+		if err == nil && !attrs.ModTime.IsZero() {
+			report.ClockSkew = writeStart.Add(writeEnd.Sub(writeStart) / 2).Sub(attrs.ModTime)
+			report.ClockSkewKnown = true
+		}
+		return nil
+	})
+	_ = run(ProbeOpList, func(ctx context.Context) error {
+		iter := bucket.List(&blob.ListOptions{Prefix: key})
+		for {
+			obj, err := iter.Next(ctx)
+			if err == io.EOF {
+				return fmt.Errorf("probe object is not listed")
+			}
+			if err != nil {
+				return err
+			}
+			if obj.Key == key {
+				return nil
+			}
+		}
+	})
+	_ = run(ProbeOpDelete, func(ctx context.Context) error {
+		return bucket.Delete(ctx, key)
+	})
+	return report
+}
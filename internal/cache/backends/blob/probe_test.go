@@ -0,0 +1,46 @@
+package blob
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"io"
+	"net"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/require"
+	"gocloud.dev/blob"
+	"gocloud.dev/blob/memblob"
+)
+
+func TestProbeRemote(t *testing.T) {
+	ctx := context.Background()
+	bucket := memblob.OpenBucket(nil)
+	defer bucket.Close()
+
+	report := ProbeRemote(ctx, bucket)
+	require.Nil(t, report.Failed())
+	ops := make([]string, 0)
+	for _, op := range report.Ops {
+		ops = append(ops, op.Op)
+	}
+	require.Equal(t, []string{ProbeOpWrite, ProbeOpRead, ProbeOpList, ProbeOpDelete}, ops)
+	require.True(t, report.ClockSkewKnown)
+	require.Less(t, report.ClockSkew.Abs(), 2*time.Second)
+
+	// The probe object is removed
+	_, err := bucket.List(&blob.ListOptions{}).Next(ctx)
+	require.Equal(t, io.EOF, err)
+}
+
+func TestClassifyProbeError(t *testing.T) {
+	require.Equal(t, "", ClassifyProbeError(nil))
+	require.Equal(t, ProbeErrorNetwork, ClassifyProbeError(fmt.Errorf("write: %w", context.DeadlineExceeded)))
+	require.Equal(t, ProbeErrorNetwork, ClassifyProbeError(&net.OpError{Op: "dial", Net: "tcp", Err: errors.New("connection refused")}))
+	require.Equal(t, ProbeErrorNetwork, ClassifyProbeError(&net.DNSError{Err: "no such host", Name: "s3.example.com"}))
+	require.Equal(t, ProbeErrorCredentials, ClassifyProbeError(errors.New("api error InvalidAccessKeyId: The AWS Access Key Id you provided does not exist")))
+	require.Equal(t, ProbeErrorCredentials, ClassifyProbeError(errors.New("failed to refresh cached credentials, no EC2 IMDS role found")))
+	require.Equal(t, ProbeErrorBucket, ClassifyProbeError(errors.New("api error NoSuchBucket: The specified bucket does not exist")))
+	require.Equal(t, ProbeErrorOther, ClassifyProbeError(errors.New("boom")))
+}
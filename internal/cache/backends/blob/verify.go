@@ -0,0 +1,366 @@
+package blob
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"hash/crc32"
+	"io"
+	"math"
+	"os"
+	"slices"
+	"strings"
+
+	"github.com/breezewish/gscache/internal/cache"
+	"github.com/breezewish/gscache/internal/log"
+	"go.uber.org/zap"
+	"gocloud.dev/blob"
+	"gocloud.dev/gcerrors"
+	"golang.org/x/sync/errgroup"
+)
+
+const DefaultVerifyConcurrency = 16
+
+// errCorrupted marks a verification failure caused by the content itself,
+// as opposed to failures like network errors, which are worth retrying.
+var errCorrupted = errors.New("corrupted")
+
+type VerifyRemoteOpts struct {
+	Ctx    context.Context
+	Remote *blob.Bucket
+	// Max number of objects downloaded at the same time. If <= 0, DefaultVerifyConcurrency is used.
+	Concurrency int
+	// Fraction (0, 1] of standalone objects to verify. Archives are always verified fully.
+	// Objects are sampled by key, so that a resumed sweep samples the same objects.
+	SampleRate float64
+	// If set, the progress is saved to this file after each unit (an archive or a key prefix)
+	// is verified, and units recorded in the file are skipped, so that an interrupted sweep
+	// can be resumed. The file is removed when the sweep finishes.
+	ProgressFile string
+	// Layout of standalone objects. Defaults to KeyLayoutDefault.
+	KeyLayout KeyLayout
+}
+
+// VerifyReport is the result of a remote integrity sweep.
+type VerifyReport struct {
+	ArchivesChecked         int
+	ArchivesCorrupted       int // Archives which cannot be opened at all.
+	ArchiveEntriesChecked   int
+	ArchiveEntriesCorrupted int
+	ObjectsListed           int
+	ObjectsChecked          int
+	ObjectsCorrupted        int
+	ObjectsFailed           int // Objects which cannot be verified, e.g. due to network errors.
+	CheckedBytes            int64
+}
+
+func (r *VerifyReport) Corrupted() int {
+	return r.ArchivesCorrupted + r.ArchiveEntriesCorrupted + r.ObjectsCorrupted
+}
+
+type verifyProgress struct {
+	Done   []string
+	Report VerifyReport
+}
+
+// remoteVerifier walks the remote bucket unit by unit. Units are verified sequentially,
+// while objects in a unit are verified concurrently.
+type remoteVerifier struct {
+	opts     VerifyRemoteOpts
+	log      *zap.Logger
+	progress verifyProgress
+}
+
+// VerifyRemote downloads and validates archives and standalone objects in the remote bucket.
+// It is read-only and does not touch any local store, so it can run alongside serving daemons.
+func VerifyRemote(opts VerifyRemoteOpts) (*VerifyReport, error) {
+	if opts.Remote == nil {
+		return nil, fmt.Errorf("remote must be set")
+	}
+	if opts.Ctx == nil {
+		opts.Ctx = context.Background()
+	}
+	if opts.Concurrency <= 0 {
+		opts.Concurrency = DefaultVerifyConcurrency
+	}
+	if opts.SampleRate <= 0 || opts.SampleRate > 1 {
+		return nil, fmt.Errorf("sample rate must be in (0, 1], got %v", opts.SampleRate)
+	}
+	v := &remoteVerifier{
+		opts: opts,
+		log:  log.Named("blob.verify"),
+	}
+	if err := v.loadProgress(); err != nil {
+		return nil, err
+	}
+	units, err := v.listUnits()
+	if err != nil {
+		return nil, err
+	}
+	for _, unit := range units {
+		if slices.Contains(v.progress.Done, unit) {
+			continue
+		}
+		if err := v.verifyUnit(unit); err != nil {
+			return &v.progress.Report, fmt.Errorf("failed to verify %s: %w", unit, err)
+		}
+		v.progress.Done = append(v.progress.Done, unit)
+		if err := v.saveProgress(); err != nil {
+			return &v.progress.Report, err
+		}
+	}
+	if opts.ProgressFile != "" {
+		_ = os.Remove(opts.ProgressFile)
+	}
+	return &v.progress.Report, nil
+}
+
+func (v *remoteVerifier) loadProgress() error {
+	if v.opts.ProgressFile == "" {
+		return nil
+	}
+	data, err := os.ReadFile(v.opts.ProgressFile)
+	if os.IsNotExist(err) {
+		return nil
+	}
+	if err != nil {
+		return fmt.Errorf("failed to read progress file: %w", err)
+	}
+	if err := json.Unmarshal(data, &v.progress); err != nil {
+		return fmt.Errorf("failed to parse progress file %s: %w", v.opts.ProgressFile, err)
+	}
+	v.log.Info("Resuming verification",
+		zap.String("progressFile", v.opts.ProgressFile),
+		zap.Int("doneUnits", len(v.progress.Done)))
+	return nil
+}
+
+func (v *remoteVerifier) saveProgress() error {
+	if v.opts.ProgressFile == "" {
+		return nil
+	}
+	data, err := json.Marshal(v.progress)
+	if err != nil {
+		return err
+	}
+	tmpPath := v.opts.ProgressFile + ".tmp"
+	if err := os.WriteFile(tmpPath, data, 0644); err != nil {
+		return fmt.Errorf("failed to write progress file: %w", err)
+	}
+	if err := os.Rename(tmpPath, v.opts.ProgressFile); err != nil {
+		return fmt.Errorf("failed to write progress file: %w", err)
+	}
+	return nil
+}
+
+// listUnits returns all units to verify: each archive, each <layout>/<xx>/ prefix of the
+// default namespace, and each other namespace as a whole.
+func (v *remoteVerifier) listUnits() ([]string, error) {
+	units := make([]string, 0)
+	for _, keyspace := range ArchiveKeyspaces {
+		units = append(units, ArchiveKey(keyspace))
+	}
+	for i := 0; i < 256; i++ {
+		units = append(units, v.opts.KeyLayout.ListPrefixKey(fmt.Sprintf("%02x/", i)))
+	}
+	iter := v.opts.Remote.List(&blob.ListOptions{Prefix: "ns/", Delimiter: "/"})
+	for {
+		obj, err := iter.Next(v.opts.Ctx)
+		if err == io.EOF {
+			break
+		}
+		if err != nil {
+			return nil, fmt.Errorf("failed to list namespaces: %w", err)
+		}
+		if obj.IsDir {
+			units = append(units, obj.Key)
+		}
+	}
+	return units, nil
+}
+
+func (v *remoteVerifier) verifyUnit(unit string) error {
+	if strings.HasPrefix(unit, "blobar/") {
+		return v.verifyArchive(unit)
+	}
+	return v.verifyPrefix(unit)
+}
+
+func (v *remoteVerifier) verifyArchive(key string) error {
+	tmpFile, err := os.CreateTemp("", "gscache_verify.*.zip")
+	if err != nil {
+		return fmt.Errorf("failed to create temp file: %w", err)
+	}
+	defer os.Remove(tmpFile.Name())
+	defer tmpFile.Close()
+
+	r, err := v.opts.Remote.NewReader(v.opts.Ctx, key, nil)
+	if err != nil {
+		if gcerrors.Code(err) == gcerrors.NotFound {
+			return nil
+		}
+		return err
+	}
+	_, err = io.Copy(tmpFile, r)
+	_ = r.Close()
+	if err != nil {
+		return fmt.Errorf("failed to download: %w", err)
+	}
+
+	report := &v.progress.Report
+	report.ArchivesChecked++
+	report.CheckedBytes += r.Size()
+	ar, err := NewArReader(tmpFile.Name())
+	if err != nil {
+		report.ArchivesCorrupted++
+		v.log.Warn("Corrupted BlobArchive", zap.String("object", key), zap.Error(err))
+		return nil
+	}
+	defer ar.Close()
+	names := ar.List()
+	slices.Sort(names)
+	for _, name := range names {
+		report.ArchiveEntriesChecked++
+		if err := verifyArEntry(name, ar.Get(name)); err != nil {
+			report.ArchiveEntriesCorrupted++
+			v.log.Warn("Corrupted BlobArchive entry",
+				zap.String("object", key),
+				zap.String("entry", name),
+				zap.Error(err))
+		}
+	}
+	return nil
+}
+
+func verifyArEntry(name string, entry *ArEntry) error {
+	if name != CacheEntityNameInArchive(entry.ActionID) {
+		return fmt.Errorf("name does not match actionID %x", entry.ActionID)
+	}
+	r, err := entry.Open()
+	if err != nil {
+		return err
+	}
+	defer r.Close()
+	// Reading to the end also verifies the CRC of the entry.
+	n, err := io.Copy(io.Discard, r)
+	if err != nil {
+		return err
+	}
+	if n != entry.Size {
+		return fmt.Errorf("size mismatch: meta says %d, got %d", entry.Size, n)
+	}
+	return nil
+}
+
+func (v *remoteVerifier) verifyPrefix(prefix string) error {
+	type result struct {
+		key   string
+		bytes int64
+		err   error
+	}
+	results := make([]result, 0)
+	var g errgroup.Group
+	g.SetLimit(v.opts.Concurrency)
+	resultCh := make(chan result, v.opts.Concurrency)
+	collectDone := make(chan struct{})
+	go func() {
+		defer close(collectDone)
+		for r := range resultCh {
+			results = append(results, r)
+		}
+	}()
+
+	listed := 0
+	iter := v.opts.Remote.List(&blob.ListOptions{Prefix: prefix})
+	var listErr error
+	for {
+		obj, err := iter.Next(v.opts.Ctx)
+		if err == io.EOF {
+			break
+		}
+		if err != nil {
+			listErr = fmt.Errorf("failed to list objects using prefix %s: %w", prefix, err)
+			break
+		}
+		if obj.IsDir {
+			continue
+		}
+		namespace, actionID, err := v.opts.KeyLayout.DecodeEntityKey(obj.Key)
+		if err != nil {
+			continue
+		}
+		listed++
+		if !verifySampled(obj.Key, v.opts.SampleRate) {
+			continue
+		}
+		key := obj.Key
+		g.Go(func() error {
+			n, err := v.verifyObject(namespace, actionID)
+			resultCh <- result{key: key, bytes: n, err: err}
+			return nil
+		})
+	}
+	_ = g.Wait()
+	close(resultCh)
+	<-collectDone
+	if listErr != nil {
+		// The unit is not recorded as done, so partial results are dropped to avoid
+		// double counting when resumed.
+		return listErr
+	}
+
+	report := &v.progress.Report
+	report.ObjectsListed += listed
+	for _, r := range results {
+		report.ObjectsChecked++
+		report.CheckedBytes += r.bytes
+		if r.err == nil {
+			continue
+		}
+		if errors.Is(r.err, errCorrupted) {
+			report.ObjectsCorrupted++
+			v.log.Warn("Corrupted object", zap.String("object", r.key), zap.Error(r.err))
+		} else {
+			report.ObjectsFailed++
+			v.log.Warn("Failed to verify object", zap.String("object", r.key), zap.Error(r.err))
+		}
+	}
+	return nil
+}
+
+// verifyObject downloads a standalone object and validates its EntryMeta header and body size.
+// Returns the number of bytes read.
+func (v *remoteVerifier) verifyObject(namespace string, actionID []byte) (int64, error) {
+	ctx, cancel := context.WithTimeout(v.opts.Ctx, MaxDownloadTimeout)
+	defer cancel()
+	r, err := v.opts.Remote.NewReader(ctx, v.opts.KeyLayout.EntityKey(namespace, actionID), nil)
+	if err != nil {
+		return 0, err
+	}
+	defer r.Close()
+	meta, err := cache.ReadEntryMeta(r)
+	if err != nil {
+		return 0, fmt.Errorf("%w: failed to read entry metadata: %w", errCorrupted, err)
+	}
+	if !bytes.Equal(meta.ActionID, actionID) {
+		return 0, fmt.Errorf("%w: actionID mismatch: got %x", errCorrupted, meta.ActionID)
+	}
+	n, err := io.Copy(io.Discard, r)
+	if err != nil {
+		return n, err
+	}
+	if n != meta.Size {
+		return n, fmt.Errorf("%w: size mismatch: meta says %d, got %d", errCorrupted, meta.Size, n)
+	}
+	return r.Size(), nil
+}
+
+// verifySampled decides whether an object is verified, stable across runs for the same key.
+func verifySampled(key string, rate float64) bool {
+	if rate >= 1 {
+		return true
+	}
+	return float64(crc32.ChecksumIEEE([]byte(key))) < rate*float64(math.MaxUint32)
+}
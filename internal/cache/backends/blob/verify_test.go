@@ -0,0 +1,93 @@
+package blob
+
+import (
+	"bytes"
+	"context"
+	"os"
+	"path/filepath"
+	"testing"
+	"time"
+
+	"github.com/breezewish/gscache/internal/cache"
+	"github.com/stretchr/testify/require"
+	"gocloud.dev/blob/memblob"
+)
+
+func writeTestObject(t *testing.T, write func(key string, data []byte) error, namespace string, actionID []byte, body string) {
+	var buf bytes.Buffer
+	_, err := cache.EntryMeta{
+		ActionID: actionID,
+		OutputID: []byte{0x01},
+		Size:     int64(len(body)),
+		Time:     time.Now(),
+	}.WriteTo(&buf)
+	require.NoError(t, err)
+	buf.WriteString(body)
+	require.NoError(t, write(CacheEntityKey(namespace, actionID), buf.Bytes()))
+}
+
+func TestVerifyRemote(t *testing.T) {
+	ctx := context.Background()
+	bucket := memblob.OpenBucket(nil)
+	defer bucket.Close()
+	write := func(key string, data []byte) error {
+		return bucket.WriteAll(ctx, key, data, nil)
+	}
+
+	writeTestObject(t, write, "", []byte{0x10, 0x01}, "hello")
+	writeTestObject(t, write, "", []byte{0xf0, 0x01}, "world")
+	writeTestObject(t, write, "go1.24_linux_amd64", []byte{0x20, 0x01}, "foo")
+	// Header says another actionID
+	var buf bytes.Buffer
+	_, err := cache.EntryMeta{ActionID: []byte{0x99}, OutputID: []byte{0x01}, Size: 1, Time: time.Now()}.WriteTo(&buf)
+	require.NoError(t, err)
+	buf.WriteString("x")
+	require.NoError(t, write(CacheEntityKey("", []byte{0x30, 0x01}), buf.Bytes()))
+	// Truncated body
+	writeTestObject(t, func(key string, data []byte) error {
+		return write(key, data[:len(data)-1])
+	}, "", []byte{0x40, 0x01}, "truncated")
+
+	// A valid archive and a broken one
+	arDir := t.TempDir()
+	writeTestArchive(t, arDir, []byte{0x1a, 0x01})
+	arData, err := os.ReadFile(filepath.Join(arDir, "1.zip"))
+	require.NoError(t, err)
+	require.NoError(t, write(ArchiveKey("1"), arData))
+	require.NoError(t, write(ArchiveKey("2"), []byte("not a zip")))
+
+	report, err := VerifyRemote(VerifyRemoteOpts{Ctx: ctx, Remote: bucket, SampleRate: 1})
+	require.NoError(t, err)
+	require.Equal(t, 2, report.ArchivesChecked)
+	require.Equal(t, 1, report.ArchivesCorrupted)
+	require.Equal(t, 1, report.ArchiveEntriesChecked)
+	require.Equal(t, 0, report.ArchiveEntriesCorrupted)
+	require.Equal(t, 5, report.ObjectsListed)
+	require.Equal(t, 5, report.ObjectsChecked)
+	require.Equal(t, 2, report.ObjectsCorrupted)
+	require.Equal(t, 0, report.ObjectsFailed)
+	require.Equal(t, 3, report.Corrupted())
+
+	// Units recorded as done in the progress file are skipped when resumed.
+	progressFile := filepath.Join(t.TempDir(), "progress.json")
+	require.NoError(t, os.WriteFile(progressFile, []byte(`{"Done":["blobar/2.zip","b/30/","b/40/"],"Report":{"ObjectsListed":100}}`), 0644))
+	report, err = VerifyRemote(VerifyRemoteOpts{Ctx: ctx, Remote: bucket, SampleRate: 1, ProgressFile: progressFile})
+	require.NoError(t, err)
+	require.Equal(t, 1, report.ArchivesChecked)
+	require.Equal(t, 103, report.ObjectsListed)
+	require.Equal(t, 0, report.Corrupted())
+	require.NoFileExists(t, progressFile)
+}
+
+func TestVerifySampled(t *testing.T) {
+	sampled := 0
+	for i := 0; i < 1000; i++ {
+		key := CacheEntityKey("", []byte{byte(i), byte(i >> 8)})
+		require.Equal(t, verifySampled(key, 0.2), verifySampled(key, 0.2))
+		if verifySampled(key, 0.2) {
+			sampled++
+		}
+		require.True(t, verifySampled(key, 1))
+	}
+	require.InDelta(t, 200, sampled, 60)
+}
@@ -0,0 +1,293 @@
+package blob
+
+import (
+	"context"
+	"encoding/hex"
+	"fmt"
+	"io"
+	"slices"
+	"sort"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/breezewish/gscache/internal/cache"
+	"github.com/breezewish/gscache/internal/progress"
+	"github.com/breezewish/gscache/internal/protocol"
+	"go.uber.org/zap"
+	"gocloud.dev/blob"
+	"golang.org/x/sync/errgroup"
+)
+
+const WarmConcurrency = 8
+
+type warmCandidate struct {
+	actionID []byte
+	modTime  time.Time
+}
+
+// Warm downloads archives of all keyspaces this instance is responsible for, so that CI
+// runners with cold disks get local hits from the first build. If req.Blobs > 0, the most
+// recently uploaded standalone objects of each keyspace are also downloaded, as entries which
+// are built recently are likely to be used again. Objects already in archives or in the local
+// store are not counted.
+func (store *BlobBackend) Warm(ctx context.Context, req protocol.WarmRequest) (*protocol.WarmResponse, error) {
+	if store.closed.Load() {
+		return nil, fmt.Errorf("blob store is closed")
+	}
+	if store.bucket == nil {
+		return nil, fmt.Errorf("warm requires a remote blob store")
+	}
+	t := time.Now()
+	tracker := progress.FromContext(ctx)
+	tracker.SetStage("archives", int64(len(store.keyspaces)), 0)
+
+	var mu sync.Mutex
+	resp := &protocol.WarmResponse{}
+	var g errgroup.Group
+	g.SetLimit(WarmConcurrency)
+	for _, keyspace := range store.keyspaces {
+		g.Go(func() error {
+			if err := store.archiveStore.SyncFromRemoteCtx(ctx, keyspace); err != nil {
+				return fmt.Errorf("failed to sync archive of keyspace %s: %w", keyspace, err)
+			}
+			mu.Lock()
+			if ar := store.archiveStore.GetArchive(keyspace); ar != nil {
+				resp.ArchivesLoaded++
+				resp.ArchiveEntries += len(ar.List())
+			}
+			mu.Unlock()
+			tracker.Add(1, 0)
+			return nil
+		})
+	}
+	if err := g.Wait(); err != nil {
+		return nil, err
+	}
+
+	if req.Blobs > 0 {
+		// Totals grow as blobs to download are selected in each keyspace
+		tracker.SetStage("blobs", 0, 0)
+		for _, keyspace := range store.keyspaces {
+			files, bytes, err := store.warmBlobs(ctx, keyspace, req.Blobs)
+			resp.BlobsDownloaded += files
+			resp.BlobsBytes += bytes
+			if err != nil {
+				return resp, err
+			}
+		}
+	}
+
+	store.log.Info("Warmed blob store",
+		zap.Int("blobs", req.Blobs),
+		zap.Int("archivesLoaded", resp.ArchivesLoaded),
+		zap.Int("archiveEntries", resp.ArchiveEntries),
+		zap.Int("blobsDownloaded", resp.BlobsDownloaded),
+		zap.Int64("blobsBytes", resp.BlobsBytes),
+		zap.String("cost", time.Since(t).String()))
+	return resp, nil
+}
+
+// warmBlobs downloads up to limit most recently uploaded standalone objects of the keyspace
+// which are not available locally yet.
+func (store *BlobBackend) warmBlobs(ctx context.Context, keyspace string, limit int) (int, int64, error) {
+	candidates := make([]warmCandidate, 0)
+	prefix := store.keyLayout.ListPrefixKey(keyspace)
+	iter := store.bucket.List(&blob.ListOptions{Prefix: prefix})
+	for {
+		ctxList, cancel := context.WithTimeout(ctx, CompactionListFilesTimeout)
+		obj, err := iter.Next(ctxList)
+		cancel()
+		if err == io.EOF {
+			break
+		}
+		if err != nil {
+			return 0, 0, fmt.Errorf("failed to list objects using prefix %s: %w", prefix, err)
+		}
+		if obj.IsDir {
+			continue
+		}
+		namespace, actionID, err := store.keyLayout.DecodeEntityKey(obj.Key)
+		if err != nil || namespace != "" {
+			continue
+		}
+		if store.archiveStore.LookupBlob(keyspace, actionID) != nil {
+			continue
+		}
+		candidates = append(candidates, warmCandidate{actionID: actionID, modTime: obj.ModTime})
+	}
+	slices.SortFunc(candidates, func(a, b warmCandidate) int {
+		return b.modTime.Compare(a.modTime)
+	})
+
+	selected := make([][]byte, 0)
+	for _, candidate := range candidates {
+		if len(selected) >= limit {
+			break
+		}
+		if exists, _ := store.diskStore.Exists(ctx, "", candidate.actionID); !exists {
+			selected = append(selected, candidate.actionID)
+		}
+	}
+
+	progress.FromContext(ctx).AddTotal(int64(len(selected)), 0)
+	files, bytes := store.downloadEntries(ctx, "", selected)
+	return files, bytes, ctx.Err()
+}
+
+// downloadEntries downloads entries into the local store. Entries which fail to download are
+// not counted, but are still reported as done to the progress tracker in ctx.
+func (store *BlobBackend) downloadEntries(ctx context.Context, namespace string, actionIDs [][]byte) (int, int64) {
+	tracker := progress.FromContext(ctx)
+	var mu sync.Mutex
+	files, bytes := 0, int64(0)
+	var g errgroup.Group
+	g.SetLimit(WarmConcurrency)
+	for _, actionID := range actionIDs {
+		g.Go(func() error {
+			resp, err := store.Get(cache.GetOpts{
+				Req:    protocol.GetRequest{ActionID: actionID, Namespace: namespace},
+				Ctx:    ctx,
+				IsWarm: true,
+			})
+			if err != nil || resp.Miss {
+				tracker.Add(1, 0)
+				return nil
+			}
+			mu.Lock()
+			files++
+			bytes += resp.Size
+			mu.Unlock()
+			tracker.Add(1, resp.Size)
+			return nil
+		})
+	}
+	_ = g.Wait()
+	return files, bytes
+}
+
+// Prefetch downloads entries whose actionID starts with one of req.ActionIDPrefixes and which
+// are not available locally yet. Archives of the keyspaces of the prefixes are synced first, as
+// entries in archives are served without downloading.
+func (store *BlobBackend) Prefetch(ctx context.Context, req protocol.PrefetchRequest) (*protocol.PrefetchResponse, error) {
+	if store.closed.Load() {
+		return nil, fmt.Errorf("blob store is closed")
+	}
+	if store.bucket == nil {
+		return nil, fmt.Errorf("prefetch requires a remote blob store")
+	}
+	if err := req.Validate(); err != nil {
+		return nil, err
+	}
+	t := time.Now()
+	tracker := progress.FromContext(ctx)
+	resp := &protocol.PrefetchResponse{Prefixes: len(req.ActionIDPrefixes)}
+
+	// Sorted names of archive entries by keyspace, so that entries only in archives also match.
+	// Archives are only built for the default namespace.
+	archived := make(map[string][]string)
+	if req.Namespace == "" {
+		for _, prefix := range req.ActionIDPrefixes {
+			keyspace := CacheEntityKeyspace(prefix)
+			if _, ok := archived[keyspace]; ok || !slices.Contains(store.keyspaces, keyspace) {
+				continue
+			}
+			if err := store.archiveStore.SyncFromRemoteCtx(ctx, keyspace); err != nil {
+				return nil, fmt.Errorf("failed to sync archive of keyspace %s: %w", keyspace, err)
+			}
+			archived[keyspace] = nil
+			if ar := store.archiveStore.GetArchive(keyspace); ar != nil {
+				archived[keyspace] = slices.Sorted(slices.Values(ar.List()))
+			}
+		}
+	}
+
+	tracker.SetStage("prefixes", int64(len(req.ActionIDPrefixes)), 0)
+	var mu sync.Mutex
+	seen := make(map[string]struct{})
+	selected := make([][]byte, 0)
+	var g errgroup.Group
+	g.SetLimit(WarmConcurrency)
+	for _, prefix := range req.ActionIDPrefixes {
+		g.Go(func() error {
+			actionIDs, err := store.listEntries(ctx, req.Namespace, prefix)
+			if err != nil {
+				return err
+			}
+			names := archived[CacheEntityKeyspace(prefix)]
+			hexPrefix := CacheEntityNameInArchive(prefix)
+			for i := sort.SearchStrings(names, hexPrefix); i < len(names) && strings.HasPrefix(names[i], hexPrefix); i++ {
+				if actionID, err := hex.DecodeString(names[i]); err == nil {
+					actionIDs = append(actionIDs, actionID)
+				}
+			}
+			for _, actionID := range actionIDs {
+				inArchive := req.Namespace == "" &&
+					store.archiveStore.LookupBlob(CacheEntityKeyspace(actionID), actionID) != nil
+				exists := false
+				if !inArchive {
+					exists, _ = store.diskStore.Exists(ctx, req.Namespace, actionID)
+				}
+				mu.Lock()
+				if _, ok := seen[string(actionID)]; !ok {
+					seen[string(actionID)] = struct{}{}
+					resp.Matched++
+					switch {
+					case inArchive:
+						resp.InArchive++
+					case exists:
+						resp.AlreadyLocal++
+					default:
+						selected = append(selected, actionID)
+					}
+				}
+				mu.Unlock()
+			}
+			tracker.Add(1, 0)
+			return nil
+		})
+	}
+	if err := g.Wait(); err != nil {
+		return nil, err
+	}
+	tracker.SetStage("entries", int64(len(selected)), 0)
+	resp.Downloaded, resp.DownloadedBytes = store.downloadEntries(ctx, req.Namespace, selected)
+
+	store.log.Info("Prefetched blob store entries",
+		zap.Int("prefixes", resp.Prefixes),
+		zap.Int("matched", resp.Matched),
+		zap.Int("inArchive", resp.InArchive),
+		zap.Int("alreadyLocal", resp.AlreadyLocal),
+		zap.Int("downloaded", resp.Downloaded),
+		zap.Int64("downloadedBytes", resp.DownloadedBytes),
+		zap.String("cost", time.Since(t).String()))
+	return resp, ctx.Err()
+}
+
+// listEntries returns actionIDs of remote objects in the namespace whose actionID starts with
+// the prefix.
+func (store *BlobBackend) listEntries(ctx context.Context, namespace string, prefix []byte) ([][]byte, error) {
+	// The key of a full actionID starts with the key of its prefix in all layouts
+	keyPrefix := store.keyLayout.EntityKey(namespace, prefix)
+	actionIDs := make([][]byte, 0)
+	iter := store.bucket.List(&blob.ListOptions{Prefix: keyPrefix})
+	for {
+		ctxList, cancel := context.WithTimeout(ctx, CompactionListFilesTimeout)
+		obj, err := iter.Next(ctxList)
+		cancel()
+		if err == io.EOF {
+			return actionIDs, nil
+		}
+		if err != nil {
+			return nil, fmt.Errorf("failed to list objects using prefix %s: %w", keyPrefix, err)
+		}
+		if obj.IsDir {
+			continue
+		}
+		ns, actionID, err := store.keyLayout.DecodeEntityKey(obj.Key)
+		if err != nil || ns != namespace {
+			continue
+		}
+		actionIDs = append(actionIDs, actionID)
+	}
+}
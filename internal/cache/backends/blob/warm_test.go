@@ -0,0 +1,131 @@
+package blob
+
+import (
+	"context"
+	"os"
+	"path/filepath"
+	"testing"
+	"time"
+
+	"github.com/breezewish/gscache/internal/protocol"
+	"github.com/breezewish/gscache/internal/stats"
+	"github.com/stretchr/testify/require"
+	"gocloud.dev/blob"
+)
+
+func TestBlobBackend_Warm(t *testing.T) {
+	ctx := context.Background()
+	bucketURL := "file://" + t.TempDir()
+	bucket, err := blob.OpenBucket(ctx, bucketURL)
+	require.NoError(t, err)
+	defer bucket.Close()
+	write := func(key string, data []byte) error {
+		return bucket.WriteAll(ctx, key, data, nil)
+	}
+
+	arDir := t.TempDir()
+	writeTestArchive(t, arDir, []byte{0x1a, 0x01})
+	arData, err := os.ReadFile(filepath.Join(arDir, "1.zip"))
+	require.NoError(t, err)
+	require.NoError(t, write(ArchiveKey("1"), arData))
+
+	// Standalone objects uploaded at different times
+	for _, actionID := range [][]byte{{0x1a, 0x01}, {0x20, 0x01}, {0x21, 0x01}, {0x22, 0x01}} {
+		writeTestObject(t, write, "", actionID, "hello")
+		time.Sleep(10 * time.Millisecond)
+	}
+
+	cfg := DefaultConfig()
+	cfg.URL = bucketURL
+	cfg.WorkDir = t.TempDir()
+	cfg.SkipCompactionOnOpen = true
+	cfg.SkipInitialArchiveSync = true
+	store, err := NewBlobBackend(cfg)
+	require.NoError(t, err)
+	require.NoError(t, store.Open(ctx))
+	defer store.Close()
+	require.Nil(t, store.archiveStore.GetArchive("1"))
+
+	organicDownloads, warmDownloads := stats.Default.BlobOrganic.GetByDownload.Load(), stats.Default.BlobWarm.GetByDownload.Load()
+	resp, err := store.Warm(ctx, protocol.WarmRequest{Blobs: 2})
+	require.NoError(t, err)
+	require.Equal(t, protocol.WarmResponse{
+		ArchivesLoaded:  1,
+		ArchiveEntries:  1,
+		BlobsDownloaded: 2,
+		BlobsBytes:      10,
+	}, *resp)
+	// Not counted as downloads of builds
+	require.Equal(t, organicDownloads, stats.Default.BlobOrganic.GetByDownload.Load())
+	require.Equal(t, warmDownloads+2, stats.Default.BlobWarm.GetByDownload.Load())
+	require.NotNil(t, store.archiveStore.GetArchive("1"))
+	for actionID, expected := range map[string]bool{"\x20\x01": false, "\x21\x01": true, "\x22\x01": true} {
+		exists, err := store.diskStore.Exists(ctx, "", []byte(actionID))
+		require.NoError(t, err)
+		require.Equal(t, expected, exists, "%x", actionID)
+	}
+
+	// Blobs available locally are skipped
+	resp, err = store.Warm(ctx, protocol.WarmRequest{Blobs: 2})
+	require.NoError(t, err)
+	require.Equal(t, 1, resp.BlobsDownloaded)
+}
+
+func TestBlobBackend_Prefetch(t *testing.T) {
+	ctx := context.Background()
+	bucketURL := "file://" + t.TempDir()
+	bucket, err := blob.OpenBucket(ctx, bucketURL)
+	require.NoError(t, err)
+	defer bucket.Close()
+	write := func(key string, data []byte) error {
+		return bucket.WriteAll(ctx, key, data, nil)
+	}
+
+	arDir := t.TempDir()
+	writeTestArchive(t, arDir, []byte{0x1a, 0x01})
+	arData, err := os.ReadFile(filepath.Join(arDir, "1.zip"))
+	require.NoError(t, err)
+	require.NoError(t, write(ArchiveKey("1"), arData))
+	for _, actionID := range [][]byte{{0x20, 0x01}, {0x20, 0x02}, {0x21, 0x01}, {0x21, 0x02}} {
+		writeTestObject(t, write, "", actionID, "hello")
+	}
+	writeTestObject(t, write, "ns1", []byte{0x20, 0x03}, "hello")
+
+	cfg := DefaultConfig()
+	cfg.URL = bucketURL
+	cfg.WorkDir = t.TempDir()
+	cfg.SkipCompactionOnOpen = true
+	cfg.SkipInitialArchiveSync = true
+	store, err := NewBlobBackend(cfg)
+	require.NoError(t, err)
+	require.NoError(t, store.Open(ctx))
+	defer store.Close()
+
+	req := protocol.PrefetchRequest{ActionIDPrefixes: [][]byte{{0x20}, {0x1a}, {0x21, 0x01}, {0x30}}}
+	resp, err := store.Prefetch(ctx, req)
+	require.NoError(t, err)
+	require.Equal(t, protocol.PrefetchResponse{
+		Prefixes:        4,
+		Matched:         4,
+		InArchive:       1, // Only in the archive
+		Downloaded:      3,
+		DownloadedBytes: 15,
+	}, *resp)
+	exists, err := store.diskStore.Exists(ctx, "", []byte{0x21, 0x02})
+	require.NoError(t, err)
+	require.False(t, exists)
+
+	// Entries available locally are skipped
+	resp, err = store.Prefetch(ctx, req)
+	require.NoError(t, err)
+	require.Equal(t, 3, resp.AlreadyLocal)
+	require.Equal(t, 0, resp.Downloaded)
+
+	resp, err = store.Prefetch(ctx, protocol.PrefetchRequest{ActionIDPrefixes: [][]byte{{0x20}}, Namespace: "ns1"})
+	require.NoError(t, err)
+	require.Equal(t, 1, resp.Matched)
+	require.Equal(t, 1, resp.Downloaded)
+
+	_, err = store.Prefetch(ctx, protocol.PrefetchRequest{ActionIDPrefixes: [][]byte{{}}})
+	require.Error(t, err)
+}
@@ -0,0 +1,66 @@
+package local
+
+import (
+	"errors"
+	"fmt"
+	"io"
+	"os"
+)
+
+var (
+	// ErrBodyTruncated means the body ends before the declared size, e.g. the client
+	// disconnected in the middle of a Put, or the remote object is truncated.
+	ErrBodyTruncated = errors.New("body is shorter than declared")
+	// ErrBodyTooLong means the body has more data than the declared size.
+	ErrBodyTooLong = errors.New("body is longer than declared")
+)
+
+// errRecordingReader remembers the error returned by the wrapped reader, so that
+// read errors can be told apart from write errors after io.CopyN.
+type errRecordingReader struct {
+	r   io.Reader
+	err error
+}
+
+func (r *errRecordingReader) Read(p []byte) (int, error) {
+	n, err := r.r.Read(p)
+	if err != nil && err != io.EOF {
+		r.err = err
+	}
+	return n, err
+}
+
+// copyExactly copies exactly n bytes from src to dst and verifies src has nothing more.
+// If the copy fails, dst is closed and removed, so that no partial file is left behind.
+// A body ending early is reported as ErrBodyTruncated and a body with extra data as
+// ErrBodyTooLong, while read and write failures are returned as is (wrapped).
+func copyExactly(dst *os.File, src io.Reader, n int64) (retErr error) {
+	defer func() {
+		if retErr != nil {
+			_ = dst.Close()
+			_ = os.Remove(dst.Name())
+		}
+	}()
+
+	rr := &errRecordingReader{r: src}
+	copied, err := io.CopyN(dst, rr, n)
+	if err != nil {
+		if rr.err != nil {
+			return fmt.Errorf("failed to read body after %d of %d bytes: %w", copied, n, rr.err)
+		}
+		if err == io.EOF {
+			return fmt.Errorf("%w: expected %d bytes, got %d", ErrBodyTruncated, n, copied)
+		}
+		return fmt.Errorf("failed to write body: %w", err)
+	}
+
+	var extra [1]byte
+	m, err := io.ReadFull(rr, extra[:])
+	if m > 0 {
+		return fmt.Errorf("%w: expected %d bytes", ErrBodyTooLong, n)
+	}
+	if err != nil && rr.err != nil {
+		return fmt.Errorf("failed to read body after %d of %d bytes: %w", n, n, rr.err)
+	}
+	return nil
+}
@@ -0,0 +1,107 @@
+package local
+
+import (
+	"context"
+	"errors"
+	"io"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+
+	"github.com/breezewish/gscache/internal/cache"
+	"github.com/breezewish/gscache/internal/protocol"
+	"github.com/stretchr/testify/require"
+)
+
+func newTestCopyFile(t *testing.T) *os.File {
+	f, err := os.Create(filepath.Join(t.TempDir(), "output"))
+	require.NoError(t, err)
+	t.Cleanup(func() { _ = f.Close() })
+	return f
+}
+
+func TestCopyExactly(t *testing.T) {
+	f := newTestCopyFile(t)
+	require.NoError(t, copyExactly(f, strings.NewReader("hello"), 5))
+	require.NoError(t, f.Close())
+	data, err := os.ReadFile(f.Name())
+	require.NoError(t, err)
+	require.Equal(t, "hello", string(data))
+}
+
+func TestCopyExactly_EarlyEOF(t *testing.T) {
+	f := newTestCopyFile(t)
+	err := copyExactly(f, strings.NewReader("hel"), 5)
+	require.ErrorIs(t, err, ErrBodyTruncated)
+	require.Contains(t, err.Error(), "expected 5 bytes, got 3")
+	require.NoFileExists(t, f.Name())
+}
+
+func TestCopyExactly_TooLong(t *testing.T) {
+	f := newTestCopyFile(t)
+	err := copyExactly(f, strings.NewReader("hello!"), 5)
+	require.ErrorIs(t, err, ErrBodyTooLong)
+	require.NoFileExists(t, f.Name())
+}
+
+func TestCopyExactly_ReadError(t *testing.T) {
+	errFoo := errors.New("connection reset")
+	f := newTestCopyFile(t)
+	err := copyExactly(f, io.MultiReader(strings.NewReader("he"), &errReader{err: errFoo}), 5)
+	require.ErrorIs(t, err, errFoo)
+	require.NotErrorIs(t, err, ErrBodyTruncated)
+	require.Contains(t, err.Error(), "after 2 of 5 bytes")
+	require.NoFileExists(t, f.Name())
+
+	// Error when checking for extra data
+	f = newTestCopyFile(t)
+	err = copyExactly(f, io.MultiReader(strings.NewReader("hello"), &errReader{err: errFoo}), 5)
+	require.ErrorIs(t, err, errFoo)
+	require.NoFileExists(t, f.Name())
+}
+
+func TestCopyExactly_WriteError(t *testing.T) {
+	f := newTestCopyFile(t)
+	require.NoError(t, f.Close())
+	err := copyExactly(f, strings.NewReader("hello"), 5)
+	require.ErrorContains(t, err, "failed to write body")
+	require.NotErrorIs(t, err, ErrBodyTruncated)
+	require.NoFileExists(t, f.Name())
+}
+
+type errReader struct {
+	err error
+}
+
+func (r *errReader) Read(p []byte) (int, error) {
+	return 0, r.err
+}
+
+func TestLocalBackend_PutTruncatedBody(t *testing.T) {
+	dir := t.TempDir()
+	store, err := NewLocalBackend(dir)
+	require.NoError(t, err)
+	require.NoError(t, store.Open(context.Background()))
+	defer store.Close()
+
+	actionID := []byte{0x01, 0x02}
+	_, err = store.Put(cache.PutOpts{
+		Req:  protocol.PutRequest{ActionID: actionID, OutputID: []byte{0x03}, BodySize: 10},
+		Body: strings.NewReader("short"),
+	})
+	require.ErrorIs(t, err, ErrBodyTruncated)
+
+	resp, err := store.Get(cache.GetOpts{Req: protocol.GetRequest{ActionID: actionID}})
+	require.NoError(t, err)
+	require.True(t, resp.Miss)
+	// No partial output file is left behind
+	var leftovers []string
+	require.NoError(t, filepath.WalkDir(dir, func(path string, d os.DirEntry, err error) error {
+		if err == nil && strings.Contains(d.Name(), ".tmp.") {
+			leftovers = append(leftovers, path)
+		}
+		return err
+	}))
+	require.Empty(t, leftovers)
+}
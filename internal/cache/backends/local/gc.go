@@ -0,0 +1,165 @@
+package local
+
+import (
+	"encoding/hex"
+	"fmt"
+	"io/fs"
+	"os"
+	"path/filepath"
+	"sort"
+	"strings"
+	"time"
+
+	"github.com/breezewish/gscache/internal/cache"
+	"github.com/breezewish/gscache/internal/protocol"
+	"github.com/breezewish/gscache/internal/stats"
+	"go.uber.org/zap"
+)
+
+type gcEntry struct {
+	actionPath string
+	outputID   string // Empty for zero-size entries
+	lastUsed   time.Time
+}
+
+// GC trims the local store. Entries not used within MaxAge are removed, then the least recently
+// used entries are removed until outputs take at most MaxBytes. Recency is the mtime of action
+// files, which is maintained by markRecentlyUsed. Outputs are removed once no remaining entry
+// references them. Zero values disable the corresponding rule.
+func (store *LocalBackend) GC(req protocol.GCRequest) (*protocol.GCResponse, error) {
+	if store.closed.Load() {
+		return nil, fmt.Errorf("local cache store is closed")
+	}
+	if store.readOnly {
+		return nil, fmt.Errorf("local cache store is read-only")
+	}
+
+	startedAt := time.Now()
+	now := store.clock.Now()
+	entries := make([]gcEntry, 0)
+	err := filepath.WalkDir(store.dir, func(path string, d fs.DirEntry, err error) error {
+		if err != nil {
+			if os.IsNotExist(err) {
+				return nil
+			}
+			return err
+		}
+		if d.IsDir() || !strings.HasSuffix(path, ".action") {
+			return nil
+		}
+		info, err := d.Info()
+		if err != nil {
+			return nil // Removed concurrently
+		}
+		f, err := os.Open(path)
+		if err != nil {
+			return nil
+		}
+		meta, err := cache.ReadEntryMeta(f)
+		_ = f.Close()
+		if err != nil {
+			return nil
+		}
+		entry := gcEntry{actionPath: path, lastUsed: info.ModTime()}
+		if meta.Size > 0 {
+			entry.outputID = hex.EncodeToString(meta.OutputID)
+		}
+		entries = append(entries, entry)
+		return nil
+	})
+	if err != nil {
+		return nil, fmt.Errorf("failed to scan action files: %w", err)
+	}
+
+	// Outputs are content addressed, so that an output may be shared by multiple entries.
+	outputRefs := make(map[string]int)
+	outputSizes := make(map[string]int64)
+	var totalBytes int64
+	for _, entry := range entries {
+		if entry.outputID == "" {
+			continue
+		}
+		outputRefs[entry.outputID]++
+		if _, ok := outputSizes[entry.outputID]; ok {
+			continue
+		}
+		info, err := os.Stat(store.outputPathFromHex(entry.outputID))
+		if err != nil {
+			continue
+		}
+		outputSizes[entry.outputID] = info.Size()
+		totalBytes += info.Size()
+	}
+
+	resp := &protocol.GCResponse{}
+	unreferenced := make([]string, 0)
+	removeEntry := func(entry gcEntry) {
+		if err := os.Remove(entry.actionPath); err != nil && !os.IsNotExist(err) {
+			store.log.Warn("Failed to remove action file", zap.String("path", entry.actionPath), zap.Error(err))
+			return
+		}
+		resp.RemovedEntries++
+		if entry.outputID == "" {
+			return
+		}
+		outputRefs[entry.outputID]--
+		if outputRefs[entry.outputID] == 0 {
+			totalBytes -= outputSizes[entry.outputID]
+			unreferenced = append(unreferenced, entry.outputID)
+		}
+	}
+
+	// Least recently used first
+	sort.Slice(entries, func(i, j int) bool {
+		return entries[i].lastUsed.Before(entries[j].lastUsed)
+	})
+	for _, entry := range entries {
+		expired := req.MaxAge > 0 && now.Sub(entry.lastUsed) > req.MaxAge
+		overBudget := req.MaxBytes > 0 && totalBytes > req.MaxBytes
+		if !expired && !overBudget {
+			// Later entries are more recently used
+			break
+		}
+		removeEntry(entry)
+	}
+
+	// Puts are blocked, so that an output is not removed while being referenced again
+	store.outputsMu.Lock()
+	for _, outputID := range unreferenced {
+		outputPath := store.outputPathFromHex(outputID)
+		info, err := os.Stat(outputPath)
+		if err != nil {
+			continue
+		}
+		if info.ModTime().After(startedAt) {
+			// Written again by a concurrent Put, so that it is still there
+			totalBytes += info.Size()
+			continue
+		}
+		if err := os.Remove(outputPath); err != nil {
+			totalBytes += info.Size()
+			continue
+		}
+		resp.RemovedBytes += info.Size()
+	}
+	store.outputsMu.Unlock()
+	resp.RemainingEntries = len(entries) - resp.RemovedEntries
+	resp.RemainingBytes = totalBytes
+
+	stats.Default.Local.GCRemovedEntries.Add(uint32(resp.RemovedEntries))
+	stats.Default.Local.GCRemovedBytes.Add(uint64(resp.RemovedBytes))
+	stats.Default.Persist()
+	store.log.Info("Local cache GC finished",
+		zap.String("maxAge", req.MaxAge.String()),
+		zap.Int64("maxBytes", req.MaxBytes),
+		zap.Int("removedEntries", resp.RemovedEntries),
+		zap.Int64("reclaimedBytes", resp.RemovedBytes),
+		zap.Int("remainingEntries", resp.RemainingEntries),
+		zap.Int64("remainingBytes", resp.RemainingBytes),
+		zap.String("cost", time.Since(startedAt).String()))
+	return resp, nil
+}
+
+func (store *LocalBackend) outputPathFromHex(outputID string) string {
+	return filepath.Join(store.dir, outputID[:2], outputID+".output")
+}
@@ -0,0 +1,132 @@
+package local
+
+import (
+	"bytes"
+	"os"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/require"
+
+	"github.com/breezewish/gscache/internal/cache"
+	"github.com/breezewish/gscache/internal/protocol"
+)
+
+func TestLocalBackend_GC(t *testing.T) {
+	store := newTestBackend(t)
+
+	put := func(namespace string, actionID, outputID []byte, body string, age time.Duration) string {
+		resp, err := store.Put(cache.PutOpts{
+			Req: protocol.PutRequest{
+				Namespace: namespace,
+				ActionID:  actionID,
+				OutputID:  outputID,
+				BodySize:  int64(len(body)),
+			},
+			Body: bytes.NewReader([]byte(body)),
+		})
+		require.NoError(t, err)
+		lastUsed := time.Now().Add(-age)
+		require.NoError(t, os.Chtimes(store.actionPath(namespace, actionID), lastUsed, lastUsed))
+		return resp.DiskPath
+	}
+	isMiss := func(namespace string, actionID []byte) bool {
+		resp, err := store.Get(cache.GetOpts{Req: protocol.GetRequest{Namespace: namespace, ActionID: actionID}})
+		require.NoError(t, err)
+		return resp.Miss
+	}
+
+	expiredPath := put("", []byte{0x01}, []byte{0x11}, "expired", 10*24*time.Hour)
+	lruPath := put("", []byte{0x02}, []byte{0x12}, "0123456789", 5*time.Hour)
+	// The same output is referenced by an old entry and a recent entry in another namespace
+	sharedPath := put("", []byte{0x03}, []byte{0x13}, "shared", 4*time.Hour)
+	require.Equal(t, sharedPath, put("foo", []byte{0x03}, []byte{0x13}, "shared", time.Minute))
+	recentPath := put("", []byte{0x04}, []byte{0x14}, "recent", 30*time.Second)
+
+	// Nothing to do
+	resp, err := store.GC(protocol.GCRequest{})
+	require.NoError(t, err)
+	require.Equal(t, protocol.GCResponse{RemainingEntries: 5, RemainingBytes: 29}, *resp)
+
+	resp, err = store.GC(protocol.GCRequest{MaxAge: 7 * 24 * time.Hour})
+	require.NoError(t, err)
+	require.Equal(t, protocol.GCResponse{
+		RemovedEntries:   1,
+		RemovedBytes:     7,
+		RemainingEntries: 4,
+		RemainingBytes:   22,
+	}, *resp)
+	require.NoFileExists(t, expiredPath)
+	require.True(t, isMiss("", []byte{0x01}))
+
+	// Removes least recently used entries until the budget is met
+	resp, err = store.GC(protocol.GCRequest{MaxBytes: 12})
+	require.NoError(t, err)
+	require.Equal(t, protocol.GCResponse{
+		RemovedEntries:   1,
+		RemovedBytes:     10,
+		RemainingEntries: 3,
+		RemainingBytes:   12,
+	}, *resp)
+	require.NoFileExists(t, lruPath)
+	require.True(t, isMiss("", []byte{0x02}))
+
+	// The shared output is only removed when no entry references it
+	resp, err = store.GC(protocol.GCRequest{MaxBytes: 6})
+	require.NoError(t, err)
+	require.Equal(t, protocol.GCResponse{
+		RemovedEntries:   2,
+		RemovedBytes:     6,
+		RemainingEntries: 1,
+		RemainingBytes:   6,
+	}, *resp)
+	require.NoFileExists(t, sharedPath)
+	require.FileExists(t, recentPath)
+	require.True(t, isMiss("", []byte{0x03}))
+	require.True(t, isMiss("foo", []byte{0x03}))
+	require.False(t, isMiss("", []byte{0x04}))
+}
+
+func TestLocalBackend_GCReadOnly(t *testing.T) {
+	dir := t.TempDir()
+	store, err := NewLocalBackendWithOpts(dir, LocalBackendOpts{ReadOnly: true})
+	require.NoError(t, err)
+	_, err = store.GC(protocol.GCRequest{MaxBytes: 1})
+	require.ErrorContains(t, err, "read-only")
+}
+
+func TestLocalBackend_GCOutputPutAgain(t *testing.T) {
+	store := newTestBackend(t)
+
+	put := func(actionID, outputID []byte, body string, age time.Duration) string {
+		resp, err := store.Put(cache.PutOpts{
+			Req: protocol.PutRequest{
+				ActionID: actionID,
+				OutputID: outputID,
+				BodySize: int64(len(body)),
+			},
+			Body: bytes.NewReader([]byte(body)),
+		})
+		require.NoError(t, err)
+		lastUsed := time.Now().Add(-age)
+		require.NoError(t, os.Chtimes(store.actionPath("", actionID), lastUsed, lastUsed))
+		return resp.DiskPath
+	}
+
+	put([]byte{0x01}, []byte{0x11}, "expired", 10*24*time.Hour)
+	againPath := put([]byte{0x02}, []byte{0x12}, "put again", 10*24*time.Hour)
+	put([]byte{0x03}, []byte{0x13}, "recent", time.Minute)
+	// As if the output is written again by a Put during the GC
+	writtenAt := time.Now().Add(time.Hour)
+	require.NoError(t, os.Chtimes(againPath, writtenAt, writtenAt))
+
+	resp, err := store.GC(protocol.GCRequest{MaxAge: 7 * 24 * time.Hour})
+	require.NoError(t, err)
+	require.Equal(t, protocol.GCResponse{
+		RemovedEntries:   2,
+		RemovedBytes:     7,
+		RemainingEntries: 1,
+		RemainingBytes:   15,
+	}, *resp)
+	require.FileExists(t, againPath)
+}
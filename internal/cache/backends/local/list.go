@@ -0,0 +1,60 @@
+package local
+
+import (
+	"fmt"
+	"io/fs"
+	"os"
+	"path/filepath"
+	"strings"
+	"time"
+
+	"github.com/breezewish/gscache/internal/cache"
+)
+
+// ListedEntry is a cache entry in the local store.
+type ListedEntry struct {
+	cache.EntryMeta
+	Namespace string
+	LastUsed  time.Time // Maintained by markRecentlyUsed, see GC.
+}
+
+// List calls fn for each entry in the local store, in no particular order, until fn returns
+// an error. Action files which cannot be decoded are skipped, see Verify for finding them.
+func (store *LocalBackend) List(fn func(entry ListedEntry) error) error {
+	if store.closed.Load() {
+		return fmt.Errorf("local cache store is closed")
+	}
+	return filepath.WalkDir(store.dir, func(path string, d fs.DirEntry, err error) error {
+		if err != nil {
+			if os.IsNotExist(err) {
+				return nil
+			}
+			return err
+		}
+		if d.IsDir() || !strings.HasSuffix(path, ".action") {
+			return nil
+		}
+		info, err := d.Info()
+		if err != nil {
+			return nil // Removed concurrently
+		}
+		f, err := os.Open(path)
+		if err != nil {
+			return nil
+		}
+		meta, err := cache.ReadEntryMeta(f)
+		_ = f.Close()
+		if err != nil {
+			return nil
+		}
+		entry := ListedEntry{EntryMeta: meta, LastUsed: info.ModTime()}
+		// Namespaced actions are at ns/<namespace>/<xx>/<actionID>.action
+		if rel, err := filepath.Rel(store.dir, path); err == nil {
+			parts := strings.Split(filepath.ToSlash(rel), "/")
+			if len(parts) == 4 && parts[0] == "ns" {
+				entry.Namespace = parts[1]
+			}
+		}
+		return fn(entry)
+	})
+}
@@ -0,0 +1,42 @@
+package local
+
+import (
+	"bytes"
+	"fmt"
+	"sort"
+	"testing"
+
+	"github.com/stretchr/testify/require"
+
+	"github.com/breezewish/gscache/internal/cache"
+	"github.com/breezewish/gscache/internal/protocol"
+)
+
+func TestLocalBackend_List(t *testing.T) {
+	store := newTestBackend(t)
+
+	for _, req := range []protocol.PutRequest{
+		{ActionID: []byte{0x01, 0x02}, OutputID: []byte{0x03}, BodySize: 5},
+		{ActionID: []byte{0x01, 0x02}, OutputID: []byte{0x03}, BodySize: 5, Namespace: "go1.24_linux_amd64"},
+		{ActionID: []byte{0xff}, OutputID: []byte{0x04}},
+	} {
+		_, err := store.Put(cache.PutOpts{
+			Req:  req,
+			Body: bytes.NewReader(make([]byte, req.BodySize)),
+		})
+		require.NoError(t, err)
+	}
+
+	listed := make([]string, 0)
+	require.NoError(t, store.List(func(e ListedEntry) error {
+		require.False(t, e.LastUsed.IsZero())
+		listed = append(listed, fmt.Sprintf("%s/%x/%x/%d", e.Namespace, e.ActionID, e.OutputID, e.Size))
+		return nil
+	}))
+	sort.Strings(listed)
+	require.Equal(t, []string{
+		"/0102/03/5",
+		"/ff/04/0",
+		"go1.24_linux_amd64/0102/03/5",
+	}, listed)
+}
@@ -7,14 +7,18 @@ import (
 	"io"
 	"os"
 	"path/filepath"
+	"strings"
+	"sync"
 	"sync/atomic"
 	"time"
 
 	"github.com/breezewish/gscache/internal/cache"
 	"github.com/breezewish/gscache/internal/log"
 	"github.com/breezewish/gscache/internal/protocol"
+	"github.com/breezewish/gscache/internal/tracing"
 	"github.com/breezewish/gscache/internal/util"
 	gonanoid "github.com/matoous/go-nanoid/v2"
+	"go.opentelemetry.io/otel/attribute"
 	"go.uber.org/zap"
 )
 
@@ -22,14 +26,49 @@ type LocalBackend struct {
 	dir    string
 	log    *zap.Logger
 	closed atomic.Bool // When true, new requests will be rejected.
+	clock  util.Clock
+
+	perRequestEmptyFile bool
+	readOnly            bool
+	scratchDir          string // Only used when readOnly. Created in Open.
+	// Closed on Close to stop the sweep of scratchDir.
+	stopScratchSweep chan struct{}
 
 	sfGet *util.SingleFlightGroup
 	sfPut *util.SingleFlightGroup
+
+	// Held for read while an entry is put, and for write while orphaned outputs are removed,
+	// so that an output is never removed between being written and being referenced.
+	outputsMu sync.RWMutex
+	// True if no output can have been orphaned since the last RemoveOrphanedOutputs, i.e. there
+	// was no re-put with a different output and no delete. Unknown (false) after start.
+	noOrphans atomic.Bool
 }
 
 var _ cache.Backend = (*LocalBackend)(nil)
+var _ cache.BackendSupportExists = (*LocalBackend)(nil)
+var _ cache.BackendSupportCompaction = (*LocalBackend)(nil)
+var _ cache.BackendSupportGC = (*LocalBackend)(nil)
+var _ cache.BackendSupportDelete = (*LocalBackend)(nil)
+
+type LocalBackendOpts struct {
+	// Optional. Used for entry time and access time. Defaults to the real clock.
+	Clock util.Clock
+	// If true, each zero-size Get / Put response gets its own empty file instead of the
+	// shared one, so that a file path is never handed to toolchains of different users.
+	// This costs a file creation per response, and the files are removed after a while.
+	PerRequestEmptyFile bool
+	// If true, the work dir is never written, e.g. when it is a read-only mount of a pre-baked
+	// cache image. Existing entries are served, while put bodies and empty files are written to
+	// a temp dir which is removed on Close, so that put entries are only available to the caller.
+	ReadOnly bool
+}
 
 func NewLocalBackend(workDir string) (*LocalBackend, error) {
+	return NewLocalBackendWithOpts(workDir, LocalBackendOpts{})
+}
+
+func NewLocalBackendWithOpts(workDir string, opts LocalBackendOpts) (*LocalBackend, error) {
 	if workDir == "" {
 		return nil, fmt.Errorf("workDir must be specified")
 	}
@@ -37,25 +76,149 @@ func NewLocalBackend(workDir string) (*LocalBackend, error) {
 		dir:    filepath.Join(workDir, "data"),
 		log:    log.Named("cache.local"),
 		closed: atomic.Bool{},
+		clock:  util.ClockOrReal(opts.Clock),
 		sfGet:  util.NewSingleFlightGroup(),
 		sfPut:  util.NewSingleFlightGroup(),
+
+		perRequestEmptyFile: opts.PerRequestEmptyFile,
+		readOnly:            opts.ReadOnly,
 	}, nil
 }
 
+// writableDir returns the dir for files which are not cache entries, like empty files.
+func (store *LocalBackend) writableDir() string {
+	if store.readOnly {
+		return store.scratchDir
+	}
+	return store.dir
+}
+
+func (store *LocalBackend) requestEmptyFilesDir() string {
+	return filepath.Join(store.writableDir(), "_empty")
+}
+
+// NewEmptyOutputFile creates an empty file which is only returned to the current request.
+// See LocalBackendOpts.PerRequestEmptyFile.
+func (store *LocalBackend) NewEmptyOutputFile() (string, error) {
+	dir := store.requestEmptyFilesDir()
+	if err := os.MkdirAll(dir, 0755); err != nil {
+		return "", fmt.Errorf("failed to prepare empty output file: %w", err)
+	}
+	path := filepath.Join(dir, gonanoid.Must(16)+".output")
+	f, err := os.OpenFile(path, os.O_CREATE|os.O_EXCL|os.O_WRONLY, 0644)
+	if err != nil {
+		return "", fmt.Errorf("failed to prepare empty output file %s: %w", path, err)
+	}
+	_ = f.Close()
+	return path, nil
+}
+
+// emptyFileForRequest replaces the shared empty file with a new one if PerRequestEmptyFile
+// is set. Responses may be shared by concurrent requests via single flight, so it must be
+// called for each request.
+func (store *LocalBackend) emptyFileForRequest(diskPath string, size int64) (string, error) {
+	if !store.perRequestEmptyFile || size != 0 || diskPath == "" {
+		return diskPath, nil
+	}
+	return store.NewEmptyOutputFile()
+}
+
+// removeStaleRequestEmptyFiles removes per-request empty files older than minAge. In read-only
+// mode, put bodies in the scratch dir, which are only used by the caller of the put, are also
+// removed.
+func (store *LocalBackend) removeStaleRequestEmptyFiles(minAge time.Duration) {
+	store.removeStaleOutputFiles(store.requestEmptyFilesDir(), minAge)
+	if store.readOnly {
+		store.removeStaleOutputFiles(store.scratchDir, minAge)
+	}
+}
+
+// removeStaleOutputFiles removes output files in the dir older than minAge, except the shared
+// empty file.
+func (store *LocalBackend) removeStaleOutputFiles(dir string, minAge time.Duration) {
+	entries, err := os.ReadDir(dir)
+	if err != nil {
+		return
+	}
+	now := store.clock.Now()
+	for _, entry := range entries {
+		if !entry.Type().IsRegular() || !strings.HasSuffix(entry.Name(), ".output") || entry.Name() == "_empty.output" {
+			continue
+		}
+		info, err := entry.Info()
+		if err != nil || now.Sub(info.ModTime()) < minAge {
+			continue
+		}
+		_ = os.Remove(filepath.Join(dir, entry.Name()))
+	}
+}
+
+// sweepScratchDir periodically removes stale files in the scratch dir until stop is closed,
+// as a read-only store is never compacted.
+func (store *LocalBackend) sweepScratchDir(stop <-chan struct{}) {
+	timer := store.clock.NewTimer(OrphanedOutputMinAge)
+	defer timer.Stop()
+	for {
+		select {
+		case <-timer.C():
+			store.removeStaleRequestEmptyFiles(OrphanedOutputMinAge)
+			timer.Reset(OrphanedOutputMinAge)
+		case <-stop:
+			return
+		}
+	}
+}
+
+// EnsureEmptyOutputFile returns the path of the empty file shared by all zero-size entries.
+// The file may be removed at any time (e.g. by a concurrent purge), so callers should
+// call this function every time they need it, which recreates the file atomically if
+// it is missing or broken.
 func (store *LocalBackend) EnsureEmptyOutputFile() (string, error) {
-	path := filepath.Join(store.dir, "_empty.output")
+	path := filepath.Join(store.writableDir(), "_empty.output")
 	info, err := os.Stat(path)
-	if err == nil && !info.IsDir() && info.Size() == 0 {
+	if err == nil && info.Mode().IsRegular() && info.Size() == 0 {
 		return path, nil
 	}
-	err = os.WriteFile(path, []byte{}, 0644)
+	if err == nil && info.IsDir() {
+		_ = os.RemoveAll(path)
+	}
+
+	// Create in a temp file and rename, so that concurrent callers never observe
+	// a file being written, and a concurrent removal never fails the creation.
+	if err := os.MkdirAll(filepath.Dir(path), 0755); err != nil {
+		return "", fmt.Errorf("failed to prepare empty output file %s: %w", path, err)
+	}
+	pathTmp := path + ".tmp." + gonanoid.Must(8)
+	f, err := os.OpenFile(pathTmp, os.O_CREATE|os.O_EXCL|os.O_WRONLY, 0644)
 	if err != nil {
 		return "", fmt.Errorf("failed to prepare empty output file %s: %w", path, err)
 	}
+	_ = f.Close()
+	if err := os.Rename(pathTmp, path); err != nil {
+		_ = os.Remove(pathTmp)
+		return "", fmt.Errorf("failed to prepare empty output file %s: %w", path, err)
+	}
 	return path, nil
 }
 
 func (store *LocalBackend) Open(_ context.Context) error {
+	if store.readOnly {
+		scratchDir, err := os.MkdirTemp("", "gscache_readonly.*")
+		if err != nil {
+			return fmt.Errorf("failed to create scratch dir for read-only mode: %w", err)
+		}
+		store.scratchDir = scratchDir
+		if _, err := store.EnsureEmptyOutputFile(); err != nil {
+			return fmt.Errorf("failed to prepare empty output file: %w", err)
+		}
+		store.stopScratchSweep = make(chan struct{})
+		go store.sweepScratchDir(store.stopScratchSweep)
+		store.log.Info("Local cache store opened in read-only mode",
+			zap.String("dir", store.dir),
+			zap.String("scratchDir", scratchDir))
+		return nil
+	}
+
 	for i := 0; i < 256; i++ {
 		subdir := filepath.Join(store.dir, fmt.Sprintf("%02x", i))
 		if err := os.MkdirAll(subdir, 0755); err != nil {
@@ -65,18 +228,32 @@ func (store *LocalBackend) Open(_ context.Context) error {
 	if _, err := store.EnsureEmptyOutputFile(); err != nil {
 		return fmt.Errorf("failed to prepare empty output file: %w", err)
 	}
+	store.removeStaleRequestEmptyFiles(OrphanedOutputMinAge)
 
 	store.log.Info("Local cache store opened", zap.Any("dir", store.dir))
 	return nil
 }
 
 func (store *LocalBackend) Close() error {
-	store.closed.Store(true)
+	if store.closed.Swap(true) {
+		return nil
+	}
+	if store.stopScratchSweep != nil {
+		close(store.stopScratchSweep)
+	}
+	if store.scratchDir != "" {
+		_ = os.RemoveAll(store.scratchDir)
+	}
 	store.log.Info("Local cache store closed")
 	return nil
 }
 
-func (store *LocalBackend) actionPath(actionID []byte) string {
+func (store *LocalBackend) actionPath(namespace string, actionID []byte) string {
+	if namespace != "" {
+		// Outputs are content addressed so that they can be shared across namespaces,
+		// only actions are namespaced.
+		return filepath.Join(store.dir, "ns", namespace, fmt.Sprintf("%02x", actionID[0]), fmt.Sprintf("%x.action", actionID))
+	}
 	return filepath.Join(store.dir, fmt.Sprintf("%02x", actionID[0]), fmt.Sprintf("%x.action", actionID))
 }
 
@@ -88,57 +265,193 @@ func (store *LocalBackend) Get(opts cache.GetOpts) (*protocol.GetResponse, error
 	if store.closed.Load() {
 		return nil, fmt.Errorf("local cache store is closed")
 	}
-	resp, err, _ := store.sfGet.Do(string(opts.Req.ActionID), func() (any, error) {
+	if err := opts.Req.Validate(); err != nil {
+		return nil, fmt.Errorf("invalid Get request: %w", err)
+	}
+	_, span := tracing.Start(opts.Context(), "local.Get")
+	defer span.End()
+	resp, err, _ := store.sfGet.Do(cache.EntryKey(opts.Req.Namespace, opts.Req.ActionID), func() (any, error) {
 		return store.get(opts)
 	})
 	if err != nil {
 		store.log.Warn("Failed to get from local cache",
 			zap.String("actionID", fmt.Sprintf("%x", opts.Req.ActionID)),
-			zap.String("metaPath", store.actionPath(opts.Req.ActionID)),
+			zap.String("metaPath", store.actionPath(opts.Req.Namespace, opts.Req.ActionID)),
 			zap.Error(err))
+		cache.SetServedFrom(opts.Ctx, "miss")
 		return &protocol.GetResponse{
 			Miss: true,
 		}, nil
 	}
-	return resp.(*protocol.GetResponse), nil
+	getResp := resp.(*protocol.GetResponse)
+	if getResp.Miss {
+		cache.SetServedFrom(opts.Ctx, "miss")
+		return getResp, nil
+	}
+	cache.SetServedFrom(opts.Ctx, "local")
+	diskPath, err := store.emptyFileForRequest(getResp.DiskPath, getResp.Size)
+	if err != nil {
+		return nil, err
+	}
+	if diskPath != getResp.DiskPath {
+		respCopy := *getResp
+		respCopy.DiskPath = diskPath
+		getResp = &respCopy
+	}
+	return getResp, nil
 }
 
 func (store *LocalBackend) Put(opts cache.PutOpts) (*protocol.PutResponse, error) {
 	if store.closed.Load() {
 		return nil, fmt.Errorf("local cache store is closed")
 	}
-	resp, err, _ := store.sfPut.Do(string(opts.Req.ActionID), func() (any, error) {
+	if err := opts.Req.Validate(); err != nil {
+		return nil, fmt.Errorf("invalid Put request: %w", err)
+	}
+	_, span := tracing.Start(opts.Context(), "local.Put",
+		attribute.Int64("bytes", opts.Req.BodySize))
+	resp, err, _ := store.sfPut.Do(cache.EntryKey(opts.Req.Namespace, opts.Req.ActionID), func() (any, error) {
 		return store.put(opts)
 	})
+	tracing.EndWithError(span, err)
 	if err != nil {
 		store.log.Warn("Failed to put in local cache",
 			zap.String("actionID", fmt.Sprintf("%x", opts.Req.ActionID)),
-			zap.String("metaPath", store.actionPath(opts.Req.ActionID)),
+			zap.String("metaPath", store.actionPath(opts.Req.Namespace, opts.Req.ActionID)),
 			zap.Error(err))
+		return nil, err
 	}
 	store.log.Debug("Put in local cache",
 		zap.String("actionID", fmt.Sprintf("%x", opts.Req.ActionID)),
-		zap.String("metaPath", store.actionPath(opts.Req.ActionID)),
-		zap.String("dataPath", store.outputPath(opts.Req.OutputID)))
+		zap.String("metaPath", store.actionPath(opts.Req.Namespace, opts.Req.ActionID)),
+		zap.String("dataPath", resp.(*protocol.PutResponse).DiskPath))
 
-	return resp.(*protocol.PutResponse), err
+	putResp := resp.(*protocol.PutResponse)
+	diskPath, err := store.emptyFileForRequest(putResp.DiskPath, opts.Req.BodySize)
+	if err != nil {
+		return nil, err
+	}
+	return &protocol.PutResponse{DiskPath: diskPath, Existing: putResp.Existing}, nil
+}
+
+func (store *LocalBackend) Exists(_ context.Context, namespace string, actionID []byte) (bool, error) {
+	if store.closed.Load() {
+		return false, fmt.Errorf("local cache store is closed")
+	}
+	if len(actionID) == 0 {
+		return false, fmt.Errorf("actionID must be specified")
+	}
+	_, err := os.Stat(store.actionPath(namespace, actionID))
+	if err != nil {
+		if os.IsNotExist(err) {
+			return false, nil
+		}
+		return false, err
+	}
+	return true, nil
+}
+
+// Delete removes the action file of the entry. The output file is left to RemoveOrphanedOutputs,
+// as it may be shared by other entries.
+func (store *LocalBackend) Delete(_ context.Context, req protocol.DeleteRequest) (*protocol.DeleteResponse, error) {
+	if store.closed.Load() {
+		return nil, fmt.Errorf("local cache store is closed")
+	}
+	if store.readOnly {
+		return nil, fmt.Errorf("local cache store is read-only")
+	}
+	if err := req.Validate(); err != nil {
+		return nil, fmt.Errorf("invalid Delete request: %w", err)
+	}
+	if req.Prefix != "" {
+		return store.deletePrefix(req.Namespace, req.Prefix)
+	}
+	err := os.Remove(store.actionPath(req.Namespace, req.ActionID))
+	if err != nil && !os.IsNotExist(err) {
+		return nil, fmt.Errorf("failed to remove action file: %w", err)
+	}
+	if err == nil {
+		store.noOrphans.Store(false)
+	}
+	store.log.Info("Deleted cache entry",
+		zap.String("actionID", fmt.Sprintf("%x", req.ActionID)),
+		zap.String("namespace", req.Namespace),
+		zap.Bool("existed", err == nil))
+	return &protocol.DeleteResponse{DeletedLocal: err == nil}, nil
+}
+
+// deletePrefix removes action files of the namespace whose actionID starts with the hex prefix.
+func (store *LocalBackend) deletePrefix(namespace, prefix string) (*protocol.DeleteResponse, error) {
+	root := store.dir
+	if namespace != "" {
+		root = filepath.Join(store.dir, "ns", namespace)
+	}
+	// Actions are sharded by dirs named by the first byte of actionID in hex
+	shards, err := os.ReadDir(root)
+	if err != nil && !os.IsNotExist(err) {
+		return nil, fmt.Errorf("failed to list local store: %w", err)
+	}
+	deleted := 0
+	for _, shard := range shards {
+		name := shard.Name()
+		if !shard.IsDir() || len(name) != 2 || !(strings.HasPrefix(name, prefix) || strings.HasPrefix(prefix, name)) {
+			continue
+		}
+		files, err := os.ReadDir(filepath.Join(root, name))
+		if err != nil {
+			return nil, fmt.Errorf("failed to list local store: %w", err)
+		}
+		for _, f := range files {
+			if !strings.HasSuffix(f.Name(), ".action") || !strings.HasPrefix(f.Name(), prefix) {
+				continue
+			}
+			err := os.Remove(filepath.Join(root, name, f.Name()))
+			if err != nil && !os.IsNotExist(err) {
+				return nil, fmt.Errorf("failed to remove action file: %w", err)
+			}
+			if err == nil {
+				deleted++
+			}
+		}
+	}
+	if deleted > 0 {
+		store.noOrphans.Store(false)
+	}
+	store.log.Info("Deleted cache entries by prefix",
+		zap.String("prefix", prefix),
+		zap.String("namespace", namespace),
+		zap.Int("deleted", deleted))
+	return &protocol.DeleteResponse{DeletedLocal: deleted > 0, LocalEntries: deleted}, nil
 }
 
 func (store *LocalBackend) markRecentlyUsed(actionPath string) bool {
+	if store.readOnly {
+		return true
+	}
 	// We follow a similar strategy as Golang:
 	// https://github.com/golang/go/blob/go1.24.3/src/cmd/go/internal/cache/cache.go#L349
 	info, err := os.Stat(actionPath)
 	if err != nil {
 		return false
 	}
-	if now := time.Now(); now.Sub(info.ModTime()) >= 1*time.Hour {
+	if now := store.clock.Now(); now.Sub(info.ModTime()) >= 1*time.Hour {
 		os.Chtimes(actionPath, now, now)
 	}
 	return true
 }
 
+// readActionMeta reads the entry metadata in the action file.
+func readActionMeta(actionPath string) (cache.EntryMeta, error) {
+	f, err := os.Open(actionPath)
+	if err != nil {
+		return cache.EntryMeta{}, err
+	}
+	defer f.Close()
+	return cache.ReadEntryMeta(f)
+}
+
 func (store *LocalBackend) get(opts cache.GetOpts) (*protocol.GetResponse, error) {
-	actionPath := store.actionPath(opts.Req.ActionID)
+	actionPath := store.actionPath(opts.Req.Namespace, opts.Req.ActionID)
 	actionFile, err := os.Open(actionPath)
 	if err != nil {
 		if os.IsNotExist(err) {
@@ -159,14 +472,16 @@ func (store *LocalBackend) get(opts cache.GetOpts) (*protocol.GetResponse, error
 		return nil, fmt.Errorf("action ID mismatch: expected %x, got %x", opts.Req.ActionID, meta.ActionID)
 	}
 
-	outputPath := store.outputPath(meta.OutputID)
+	outputPath := ""
 	if meta.Size == 0 {
+		// OutputID may be empty for zero-size entries, so it must not be used here.
 		emptyPath, err := store.EnsureEmptyOutputFile()
 		if err != nil {
 			return nil, fmt.Errorf("failed to prepare empty output file: %w", err)
 		}
 		outputPath = emptyPath
 	} else {
+		outputPath = store.outputPath(meta.OutputID)
 		info, err := os.Stat(outputPath)
 		if err != nil {
 			_ = os.Remove(actionPath)
@@ -200,12 +515,41 @@ func (store *LocalBackend) get(opts cache.GetOpts) (*protocol.GetResponse, error
 }
 
 func (store *LocalBackend) put(opts cache.PutOpts) (*protocol.PutResponse, error) {
-	actionPath := store.actionPath(opts.Req.ActionID)
-	outputPath := store.outputPath(opts.Req.OutputID)
+	if opts.Req.IfAbsent {
+		existing, err := store.get(cache.GetOpts{
+			Req: protocol.GetRequest{
+				ActionID:  opts.Req.ActionID,
+				Namespace: opts.Req.Namespace,
+			},
+		})
+		if err == nil && !existing.Miss {
+			// Consume the body so that the caller is not blocked on writing it.
+			if opts.Body != nil {
+				_, _ = io.Copy(io.Discard, opts.Body)
+			}
+			store.log.Debug("Skip put in local cache, entry already exists",
+				zap.String("actionID", fmt.Sprintf("%x", opts.Req.ActionID)))
+			return &protocol.PutResponse{
+				DiskPath: existing.DiskPath,
+				Existing: existing,
+			}, nil
+		}
+	}
+
+	if store.readOnly {
+		return store.putScratch(opts)
+	}
+
+	store.outputsMu.RLock()
+	defer store.outputsMu.RUnlock()
+
+	actionPath := store.actionPath(opts.Req.Namespace, opts.Req.ActionID)
+	outputPath := ""
 	uniqueId := gonanoid.Must(8)
 
 	// Write object first to ensure atomicity
 	if opts.Req.BodySize > 0 {
+		outputPath = store.outputPath(opts.Req.OutputID)
 		if err := os.MkdirAll(filepath.Dir(outputPath), 0755); err != nil {
 			return nil, fmt.Errorf("failed to create output directory: %w", err)
 		}
@@ -215,15 +559,11 @@ func (store *LocalBackend) put(opts cache.PutOpts) (*protocol.PutResponse, error
 			return nil, fmt.Errorf("failed to create output file: %w", err)
 		}
 		defer outputFile.Close()
-		n, err := io.Copy(outputFile, opts.Body)
-		if err != nil {
+		if err := copyExactly(outputFile, opts.Body, opts.Req.BodySize); err != nil {
 			return nil, fmt.Errorf("failed to write output body: %w", err)
 		}
-		if n != opts.Req.BodySize {
-			return nil, fmt.Errorf("body size mismatch: expected %d according to meta, got %d", opts.Req.BodySize, n)
-		}
 		_ = outputFile.Close()
-		if err := os.Rename(outputPathTmp, outputPath); err != nil {
+		if err := util.RenameFile(outputPathTmp, outputPath); err != nil {
 			return nil, fmt.Errorf("failed to rename output file: %w", err)
 		}
 	} else {
@@ -247,16 +587,21 @@ func (store *LocalBackend) put(opts cache.PutOpts) (*protocol.PutResponse, error
 			ActionID: opts.Req.ActionID,
 			OutputID: opts.Req.OutputID,
 			Size:     opts.Req.BodySize,
-			Time:     time.Now(),
+			Time:     store.clock.Now(),
 		}
 		if opts.OverrideTime != nil {
 			meta.Time = *opts.OverrideTime
 		}
+		meta.Time = cache.NormalizeTime(meta.Time)
 		if _, err := meta.WriteTo(actionFile); err != nil {
 			return nil, fmt.Errorf("failed to write entry metadata: %w", err)
 		}
 		_ = actionFile.Close()
-		if err := os.Rename(actionPathTmp, actionPath); err != nil {
+		if old, err := readActionMeta(actionPath); err == nil && old.Size > 0 && !bytes.Equal(old.OutputID, meta.OutputID) {
+			// The old output is orphaned unless shared by other entries
+			store.noOrphans.Store(false)
+		}
+		if err := util.RenameFile(actionPathTmp, actionPath); err != nil {
 			return nil, fmt.Errorf("failed to rename action file: %w", err)
 		}
 	}
@@ -267,3 +612,27 @@ func (store *LocalBackend) put(opts cache.PutOpts) (*protocol.PutResponse, error
 		DiskPath: outputPath,
 	}, nil
 }
+
+// putScratch materializes the body in the scratch dir without storing the entry, so that
+// the caller gets a valid DiskPath while the work dir is never written.
+func (store *LocalBackend) putScratch(opts cache.PutOpts) (*protocol.PutResponse, error) {
+	if opts.Req.BodySize == 0 {
+		emptyPath, err := store.EnsureEmptyOutputFile()
+		if err != nil {
+			return nil, fmt.Errorf("failed to prepare empty output file: %w", err)
+		}
+		return &protocol.PutResponse{DiskPath: emptyPath}, nil
+	}
+	outputPath := filepath.Join(store.scratchDir, gonanoid.Must(16)+".output")
+	outputFile, err := os.Create(outputPath)
+	if err != nil {
+		return nil, fmt.Errorf("failed to create output file: %w", err)
+	}
+	defer outputFile.Close()
+	if err := copyExactly(outputFile, opts.Body, opts.Req.BodySize); err != nil {
+		_ = outputFile.Close()
+		_ = os.Remove(outputPath)
+		return nil, fmt.Errorf("failed to write output body: %w", err)
+	}
+	return &protocol.PutResponse{DiskPath: outputPath}, nil
+}
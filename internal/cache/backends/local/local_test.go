@@ -0,0 +1,429 @@
+package local
+
+import (
+	"bytes"
+	"context"
+	"fmt"
+	"os"
+	"path/filepath"
+	"strings"
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/require"
+
+	"github.com/breezewish/gscache/internal/cache"
+	"github.com/breezewish/gscache/internal/protocol"
+	"github.com/breezewish/gscache/internal/util"
+)
+
+func newTestBackend(t *testing.T) *LocalBackend {
+	store, err := NewLocalBackend(t.TempDir())
+	require.NoError(t, err)
+	require.NoError(t, store.Open(context.Background()))
+	t.Cleanup(func() {
+		_ = store.Close()
+	})
+	return store
+}
+
+func TestLocalBackend_PutGet(t *testing.T) {
+	store := newTestBackend(t)
+
+	putResp, err := store.Put(cache.PutOpts{
+		Req: protocol.PutRequest{
+			ActionID: []byte{0x01, 0x02},
+			OutputID: []byte{0x03, 0x04},
+			BodySize: 5,
+		},
+		Body: bytes.NewReader([]byte("hello")),
+	})
+	require.NoError(t, err)
+	data, err := os.ReadFile(putResp.DiskPath)
+	require.NoError(t, err)
+	require.Equal(t, "hello", string(data))
+
+	getResp, err := store.Get(cache.GetOpts{
+		Req: protocol.GetRequest{ActionID: []byte{0x01, 0x02}},
+	})
+	require.NoError(t, err)
+	require.False(t, getResp.Miss)
+	require.Equal(t, []byte{0x03, 0x04}, getResp.OutputID)
+	require.Equal(t, int64(5), getResp.Size)
+	require.Equal(t, putResp.DiskPath, getResp.DiskPath)
+
+	getResp, err = store.Get(cache.GetOpts{
+		Req: protocol.GetRequest{ActionID: []byte{0xff}},
+	})
+	require.NoError(t, err)
+	require.True(t, getResp.Miss)
+}
+
+func TestLocalBackend_PutEmptyOutputIDWithBody(t *testing.T) {
+	store := newTestBackend(t)
+
+	_, err := store.Put(cache.PutOpts{
+		Req: protocol.PutRequest{
+			ActionID: []byte{0x01},
+			OutputID: nil,
+			BodySize: 3,
+		},
+		Body: bytes.NewReader([]byte("abc")),
+	})
+	require.Error(t, err)
+	require.Contains(t, err.Error(), "outputID must be specified")
+
+	// Empty OutputID is fine when there is no body.
+	resp, err := store.Put(cache.PutOpts{
+		Req: protocol.PutRequest{
+			ActionID: []byte{0x01},
+			OutputID: nil,
+			BodySize: 0,
+		},
+		Body: bytes.NewReader(nil),
+	})
+	require.NoError(t, err)
+	require.NotEmpty(t, resp.DiskPath)
+}
+
+func TestLocalBackend_EmptyActionID(t *testing.T) {
+	store := newTestBackend(t)
+
+	_, err := store.Put(cache.PutOpts{
+		Req: protocol.PutRequest{
+			OutputID: []byte{0x01},
+			BodySize: 0,
+		},
+		Body: bytes.NewReader(nil),
+	})
+	require.Error(t, err)
+	require.Contains(t, err.Error(), "actionID must be specified")
+
+	_, err = store.Get(cache.GetOpts{
+		Req: protocol.GetRequest{},
+	})
+	require.Error(t, err)
+	require.Contains(t, err.Error(), "actionID must be specified")
+}
+
+func TestLocalBackend_Namespace(t *testing.T) {
+	store := newTestBackend(t)
+
+	_, err := store.Put(cache.PutOpts{
+		Req: protocol.PutRequest{
+			ActionID:  []byte{0x01},
+			OutputID:  []byte{0x02},
+			BodySize:  3,
+			Namespace: "go1.24_linux_amd64",
+		},
+		Body: bytes.NewReader([]byte("abc")),
+	})
+	require.NoError(t, err)
+
+	getResp, err := store.Get(cache.GetOpts{
+		Req: protocol.GetRequest{ActionID: []byte{0x01}, Namespace: "go1.24_linux_amd64"},
+	})
+	require.NoError(t, err)
+	require.False(t, getResp.Miss)
+
+	// Not visible in other namespaces
+	getResp, err = store.Get(cache.GetOpts{
+		Req: protocol.GetRequest{ActionID: []byte{0x01}},
+	})
+	require.NoError(t, err)
+	require.True(t, getResp.Miss)
+	getResp, err = store.Get(cache.GetOpts{
+		Req: protocol.GetRequest{ActionID: []byte{0x01}, Namespace: "go1.24_darwin_arm64"},
+	})
+	require.NoError(t, err)
+	require.True(t, getResp.Miss)
+
+	_, err = store.Get(cache.GetOpts{
+		Req: protocol.GetRequest{ActionID: []byte{0x01}, Namespace: "../escape"},
+	})
+	require.Error(t, err)
+}
+
+func TestLocalBackend_PutIfAbsent(t *testing.T) {
+	store := newTestBackend(t)
+
+	putResp, err := store.Put(cache.PutOpts{
+		Req: protocol.PutRequest{
+			ActionID: []byte{0x01},
+			OutputID: []byte{0x02},
+			BodySize: 3,
+		},
+		Body: bytes.NewReader([]byte("abc")),
+	})
+	require.NoError(t, err)
+
+	// Existing entry is kept, and the body is still consumed
+	body := bytes.NewReader([]byte("xyzw"))
+	putResp2, err := store.Put(cache.PutOpts{
+		Req: protocol.PutRequest{
+			ActionID: []byte{0x01},
+			OutputID: []byte{0x03},
+			BodySize: 4,
+			IfAbsent: true,
+		},
+		Body: body,
+	})
+	require.NoError(t, err)
+	require.Equal(t, putResp.DiskPath, putResp2.DiskPath)
+	require.Equal(t, 0, body.Len())
+
+	getResp, err := store.Get(cache.GetOpts{
+		Req: protocol.GetRequest{ActionID: []byte{0x01}},
+	})
+	require.NoError(t, err)
+	require.Equal(t, []byte{0x02}, getResp.OutputID)
+	require.Equal(t, int64(3), getResp.Size)
+
+	// Absent entry is put as usual
+	putResp3, err := store.Put(cache.PutOpts{
+		Req: protocol.PutRequest{
+			ActionID: []byte{0x05},
+			OutputID: []byte{0x06},
+			BodySize: 2,
+			IfAbsent: true,
+		},
+		Body: bytes.NewReader([]byte("hi")),
+	})
+	require.NoError(t, err)
+	data, err := os.ReadFile(putResp3.DiskPath)
+	require.NoError(t, err)
+	require.Equal(t, "hi", string(data))
+}
+
+func TestLocalBackend_EmptyOutputFileRemovedConcurrently(t *testing.T) {
+	store := newTestBackend(t)
+
+	emptyPath, err := store.EnsureEmptyOutputFile()
+	require.NoError(t, err)
+
+	stopCh := make(chan struct{})
+	removerDone := make(chan struct{})
+	go func() {
+		defer close(removerDone)
+		for {
+			select {
+			case <-stopCh:
+				return
+			default:
+				_ = os.Remove(emptyPath)
+			}
+		}
+	}()
+
+	// Failures are checked on the test goroutine, as require must not be called in others
+	var wg sync.WaitGroup
+	errCh := make(chan error, 8)
+	for i := 0; i < 8; i++ {
+		wg.Add(1)
+		go func(i int) {
+			defer wg.Done()
+			for j := 0; j < 100; j++ {
+				actionID := []byte{byte(i), byte(j)}
+				putResp, err := store.Put(cache.PutOpts{
+					Req:  protocol.PutRequest{ActionID: actionID},
+					Body: bytes.NewReader(nil),
+				})
+				if err != nil {
+					errCh <- fmt.Errorf("put %x: %w", actionID, err)
+					return
+				}
+				if putResp.DiskPath != emptyPath {
+					errCh <- fmt.Errorf("put %x: unexpected disk path %s", actionID, putResp.DiskPath)
+					return
+				}
+
+				getResp, err := store.Get(cache.GetOpts{
+					Req: protocol.GetRequest{ActionID: actionID},
+				})
+				if err != nil {
+					errCh <- fmt.Errorf("get %x: %w", actionID, err)
+					return
+				}
+				if getResp.Miss || getResp.DiskPath != emptyPath {
+					errCh <- fmt.Errorf("get %x: unexpected response %+v", actionID, getResp)
+					return
+				}
+			}
+		}(i)
+	}
+	wg.Wait()
+	close(stopCh)
+	<-removerDone
+	close(errCh)
+	for err := range errCh {
+		require.NoError(t, err)
+	}
+
+	// Once removals stop, the file is recreated as an empty regular file
+	path, err := store.EnsureEmptyOutputFile()
+	require.NoError(t, err)
+	info, err := os.Stat(path)
+	require.NoError(t, err)
+	require.True(t, info.Mode().IsRegular())
+	require.Equal(t, int64(0), info.Size())
+}
+
+func TestLocalBackend_EntryTimeFromClock(t *testing.T) {
+	clock := util.NewFakeClock(time.Date(2025, 1, 1, 0, 0, 0, 0, time.UTC))
+	store, err := NewLocalBackendWithOpts(t.TempDir(), LocalBackendOpts{Clock: clock})
+	require.NoError(t, err)
+	require.NoError(t, store.Open(context.Background()))
+	defer store.Close()
+
+	_, err = store.Put(cache.PutOpts{
+		Req:  protocol.PutRequest{ActionID: []byte{0x01}, OutputID: []byte{0x02}, BodySize: 1},
+		Body: bytes.NewReader([]byte("a")),
+	})
+	require.NoError(t, err)
+	resp, err := store.Get(cache.GetOpts{Req: protocol.GetRequest{ActionID: []byte{0x01}}})
+	require.NoError(t, err)
+	require.Equal(t, clock.Now(), *resp.Time)
+}
+
+func TestLocalBackend_PerRequestEmptyFile(t *testing.T) {
+	clock := util.NewFakeClock(time.Now())
+	store, err := NewLocalBackendWithOpts(t.TempDir(), LocalBackendOpts{Clock: clock, PerRequestEmptyFile: true})
+	require.NoError(t, err)
+	require.NoError(t, store.Open(context.Background()))
+	defer store.Close()
+	sharedPath, err := store.EnsureEmptyOutputFile()
+	require.NoError(t, err)
+
+	putResp, err := store.Put(cache.PutOpts{
+		Req: protocol.PutRequest{ActionID: []byte{0x01}, BodySize: 0},
+	})
+	require.NoError(t, err)
+	paths := []string{putResp.DiskPath}
+	for i := 0; i < 2; i++ {
+		resp, err := store.Get(cache.GetOpts{Req: protocol.GetRequest{ActionID: []byte{0x01}}})
+		require.NoError(t, err)
+		require.False(t, resp.Miss)
+		paths = append(paths, resp.DiskPath)
+	}
+	for i, path := range paths {
+		require.NotEqual(t, sharedPath, path)
+		require.NotContains(t, paths[i+1:], path)
+		info, err := os.Stat(path)
+		require.NoError(t, err)
+		require.Zero(t, info.Size())
+	}
+
+	// Non-empty entries are not affected.
+	putResp, err = store.Put(cache.PutOpts{
+		Req:  protocol.PutRequest{ActionID: []byte{0x02}, OutputID: []byte{0x03}, BodySize: 1},
+		Body: bytes.NewReader([]byte("a")),
+	})
+	require.NoError(t, err)
+	require.Equal(t, store.outputPath([]byte{0x03}), putResp.DiskPath)
+
+	// Stale per-request files are removed by compaction.
+	require.NoError(t, store.Compact())
+	require.FileExists(t, paths[0])
+	clock.Advance(OrphanedOutputMinAge + time.Minute)
+	require.NoError(t, store.Compact())
+	for _, path := range paths {
+		require.NoFileExists(t, path)
+	}
+}
+
+func TestLocalBackend_ReadOnly(t *testing.T) {
+	workDir := t.TempDir()
+	store, err := NewLocalBackend(workDir)
+	require.NoError(t, err)
+	require.NoError(t, store.Open(context.Background()))
+	_, err = store.Put(cache.PutOpts{
+		Req:  protocol.PutRequest{ActionID: []byte{0x01}, OutputID: []byte{0x02}, BodySize: 5},
+		Body: strings.NewReader("hello"),
+	})
+	require.NoError(t, err)
+	require.NoError(t, store.Close())
+
+	snapshot := func() map[string]time.Time {
+		files := make(map[string]time.Time)
+		require.NoError(t, filepath.Walk(workDir, func(path string, info os.FileInfo, err error) error {
+			require.NoError(t, err)
+			files[path] = info.ModTime()
+			return nil
+		}))
+		return files
+	}
+	before := snapshot()
+
+	store, err = NewLocalBackendWithOpts(workDir, LocalBackendOpts{ReadOnly: true})
+	require.NoError(t, err)
+	require.NoError(t, store.Open(context.Background()))
+
+	resp, err := store.Get(cache.GetOpts{Req: protocol.GetRequest{ActionID: []byte{0x01}}})
+	require.NoError(t, err)
+	require.False(t, resp.Miss)
+
+	// Put bodies are materialized outside the work dir and are not stored.
+	putResp, err := store.Put(cache.PutOpts{
+		Req:  protocol.PutRequest{ActionID: []byte{0x03}, OutputID: []byte{0x04}, BodySize: 3},
+		Body: strings.NewReader("foo"),
+	})
+	require.NoError(t, err)
+	require.False(t, strings.HasPrefix(putResp.DiskPath, workDir))
+	data, err := os.ReadFile(putResp.DiskPath)
+	require.NoError(t, err)
+	require.Equal(t, "foo", string(data))
+	putResp, err = store.Put(cache.PutOpts{
+		Req: protocol.PutRequest{ActionID: []byte{0x05}, BodySize: 0},
+	})
+	require.NoError(t, err)
+	require.False(t, strings.HasPrefix(putResp.DiskPath, workDir))
+	resp, err = store.Get(cache.GetOpts{Req: protocol.GetRequest{ActionID: []byte{0x03}}})
+	require.NoError(t, err)
+	require.True(t, resp.Miss)
+
+	require.NoError(t, store.Compact())
+	require.NoError(t, store.Close())
+	require.Equal(t, before, snapshot())
+	require.NoFileExists(t, putResp.DiskPath)
+}
+
+func TestLocalBackend_ReadOnlyScratchFiles(t *testing.T) {
+	clock := util.NewFakeClock(time.Now())
+	store, err := NewLocalBackendWithOpts(t.TempDir(), LocalBackendOpts{Clock: clock, ReadOnly: true})
+	require.NoError(t, err)
+	require.NoError(t, store.Open(context.Background()))
+	defer store.Close()
+	scratchOutputs := func() []string {
+		files, err := filepath.Glob(filepath.Join(store.scratchDir, "*.output"))
+		require.NoError(t, err)
+		return files
+	}
+	emptyPath, err := store.EnsureEmptyOutputFile()
+	require.NoError(t, err)
+	require.Equal(t, []string{emptyPath}, scratchOutputs())
+
+	// A partially written body is removed
+	_, err = store.Put(cache.PutOpts{
+		Req:  protocol.PutRequest{ActionID: []byte{0x01}, OutputID: []byte{0x02}, BodySize: 5},
+		Body: strings.NewReader("foo"),
+	})
+	require.Error(t, err)
+	require.Equal(t, []string{emptyPath}, scratchOutputs())
+
+	putResp, err := store.Put(cache.PutOpts{
+		Req:  protocol.PutRequest{ActionID: []byte{0x01}, OutputID: []byte{0x02}, BodySize: 3},
+		Body: strings.NewReader("foo"),
+	})
+	require.NoError(t, err)
+	require.FileExists(t, putResp.DiskPath)
+
+	// Stale bodies are removed by the periodic sweep
+	require.Eventually(t, func() bool { return clock.Timers() == 1 }, 5*time.Second, 10*time.Millisecond)
+	clock.Advance(OrphanedOutputMinAge + time.Minute)
+	require.Eventually(t, func() bool {
+		_, err := os.Stat(putResp.DiskPath)
+		return os.IsNotExist(err)
+	}, 5*time.Second, 10*time.Millisecond)
+	require.FileExists(t, emptyPath)
+}
@@ -0,0 +1,127 @@
+package local
+
+import (
+	"encoding/hex"
+	"fmt"
+	"io/fs"
+	"os"
+	"path/filepath"
+	"strings"
+	"time"
+
+	"github.com/breezewish/gscache/internal/cache"
+	"github.com/breezewish/gscache/internal/stats"
+	"go.uber.org/zap"
+)
+
+// OrphanedOutputMinAge is the default min age of an orphaned output file to be removed.
+// A build may still be reading a young output file via a DiskPath returned before it was
+// orphaned, e.g. before the entry was re-put.
+const OrphanedOutputMinAge = 1 * time.Hour
+
+type OrphanReport struct {
+	RemovedFiles int
+	RemovedBytes int64
+}
+
+// RemoveOrphanedOutputs removes output files which are no longer referenced by any action.
+// Outputs are content addressed by OutputID, so when an ActionID is put again with a
+// different OutputID, the action file only references the latest output and the old
+// one is leaked. Output files modified within minAge are always kept.
+//
+// Puts are blocked while orphaned outputs are removed, so that an output being put is never
+// mistaken as orphaned. The store is only scanned if outputs may have been orphaned since the
+// last call.
+func (store *LocalBackend) RemoveOrphanedOutputs(minAge time.Duration) (OrphanReport, error) {
+	report := OrphanReport{}
+	if store.closed.Load() {
+		return report, fmt.Errorf("local cache store is closed")
+	}
+
+	store.outputsMu.Lock()
+	defer store.outputsMu.Unlock()
+	if store.noOrphans.Swap(true) {
+		store.log.Debug("Skip removing orphaned output files, no entry is re-put or deleted")
+		return report, nil
+	}
+
+	referenced := make(map[string]struct{})
+	err := filepath.WalkDir(store.dir, func(path string, d fs.DirEntry, err error) error {
+		if err != nil {
+			if os.IsNotExist(err) {
+				return nil
+			}
+			return err
+		}
+		if d.IsDir() || !strings.HasSuffix(path, ".action") {
+			return nil
+		}
+		f, err := os.Open(path)
+		if err != nil {
+			return nil // Removed concurrently
+		}
+		meta, err := cache.ReadEntryMeta(f)
+		_ = f.Close()
+		if err != nil || meta.Size == 0 {
+			return nil
+		}
+		referenced[hex.EncodeToString(meta.OutputID)] = struct{}{}
+		return nil
+	})
+	if err != nil {
+		store.noOrphans.Store(false)
+		return report, fmt.Errorf("failed to scan action files: %w", err)
+	}
+
+	now := store.clock.Now()
+	for i := 0; i < 256; i++ {
+		subdir := filepath.Join(store.dir, fmt.Sprintf("%02x", i))
+		entries, err := os.ReadDir(subdir)
+		if err != nil {
+			continue
+		}
+		for _, entry := range entries {
+			outputID, ok := strings.CutSuffix(entry.Name(), ".output")
+			if !ok || entry.IsDir() {
+				continue
+			}
+			if _, ok := referenced[outputID]; ok {
+				continue
+			}
+			info, err := entry.Info()
+			if err != nil {
+				continue
+			}
+			if now.Sub(info.ModTime()) < minAge {
+				// Check again in the next call
+				store.noOrphans.Store(false)
+				continue
+			}
+			if err := os.Remove(filepath.Join(subdir, entry.Name())); err != nil {
+				continue
+			}
+			report.RemovedFiles++
+			report.RemovedBytes += info.Size()
+		}
+	}
+
+	stats.Default.Local.OrphanRemovedFiles.Add(uint32(report.RemovedFiles))
+	stats.Default.Local.OrphanRemovedBytes.Add(uint64(report.RemovedBytes))
+	stats.Default.Persist()
+	store.log.Info("Removed orphaned output files",
+		zap.Int("referenced", len(referenced)),
+		zap.Int("removedFiles", report.RemovedFiles),
+		zap.Int64("reclaimedBytes", report.RemovedBytes))
+	return report, nil
+}
+
+// Compact removes orphaned output files and stale per-request empty files. In read-only mode,
+// only stale files in the scratch dir are removed.
+func (store *LocalBackend) Compact() error {
+	store.removeStaleRequestEmptyFiles(OrphanedOutputMinAge)
+	if store.readOnly {
+		return nil
+	}
+	_, err := store.RemoveOrphanedOutputs(OrphanedOutputMinAge)
+	return err
+}
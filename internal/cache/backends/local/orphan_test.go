@@ -0,0 +1,169 @@
+package local
+
+import (
+	"bytes"
+	"context"
+	"os"
+	"path/filepath"
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/require"
+
+	"github.com/breezewish/gscache/internal/cache"
+	"github.com/breezewish/gscache/internal/protocol"
+)
+
+func TestLocalBackend_RemoveOrphanedOutputs(t *testing.T) {
+	store := newTestBackend(t)
+
+	put := func(actionID, outputID []byte, body string) string {
+		resp, err := store.Put(cache.PutOpts{
+			Req: protocol.PutRequest{
+				ActionID: actionID,
+				OutputID: outputID,
+				BodySize: int64(len(body)),
+			},
+			Body: bytes.NewReader([]byte(body)),
+		})
+		require.NoError(t, err)
+		return resp.DiskPath
+	}
+
+	oldPath := put([]byte{0x01}, []byte{0x11}, "old")
+	newPath := put([]byte{0x01}, []byte{0x12}, "new!") // Re-put with a different output
+	sharedPath := put([]byte{0x02}, []byte{0x13}, "shared")
+	require.Equal(t, sharedPath, put([]byte{0x03}, []byte{0x13}, "shared"))
+	put([]byte{0x04}, nil, "") // Empty output
+
+	// Young orphans are kept.
+	report, err := store.RemoveOrphanedOutputs(time.Hour)
+	require.NoError(t, err)
+	require.Equal(t, OrphanReport{}, report)
+	require.FileExists(t, oldPath)
+
+	report, err = store.RemoveOrphanedOutputs(0)
+	require.NoError(t, err)
+	require.Equal(t, OrphanReport{RemovedFiles: 1, RemovedBytes: 3}, report)
+	require.NoFileExists(t, oldPath)
+	require.FileExists(t, newPath)
+	require.FileExists(t, sharedPath)
+
+	resp, err := store.Get(cache.GetOpts{Req: protocol.GetRequest{ActionID: []byte{0x01}}})
+	require.NoError(t, err)
+	require.False(t, resp.Miss)
+	data, err := os.ReadFile(resp.DiskPath)
+	require.NoError(t, err)
+	require.Equal(t, "new!", string(data))
+	resp, err = store.Get(cache.GetOpts{Req: protocol.GetRequest{ActionID: []byte{0x04}}})
+	require.NoError(t, err)
+	require.False(t, resp.Miss)
+}
+
+func TestLocalBackend_RemoveOrphanedOutputsSkipsUnchanged(t *testing.T) {
+	store := newTestBackend(t)
+
+	put := func(actionID, outputID []byte, body string) string {
+		resp, err := store.Put(cache.PutOpts{
+			Req: protocol.PutRequest{
+				ActionID: actionID,
+				OutputID: outputID,
+				BodySize: int64(len(body)),
+			},
+			Body: bytes.NewReader([]byte(body)),
+		})
+		require.NoError(t, err)
+		return resp.DiskPath
+	}
+
+	put([]byte{0x01}, []byte{0x11}, "a")
+	report, err := store.RemoveOrphanedOutputs(0)
+	require.NoError(t, err)
+	require.Equal(t, OrphanReport{}, report)
+
+	// Nothing is re-put or deleted, so that the leaked file is not found
+	leaked := filepath.Join(store.dir, "22", "22.output")
+	require.NoError(t, os.WriteFile(leaked, []byte("xx"), 0644))
+	put([]byte{0x02}, []byte{0x12}, "b")
+	report, err = store.RemoveOrphanedOutputs(0)
+	require.NoError(t, err)
+	require.Equal(t, OrphanReport{}, report)
+	require.FileExists(t, leaked)
+
+	put([]byte{0x01}, []byte{0x13}, "c")
+	report, err = store.RemoveOrphanedOutputs(0)
+	require.NoError(t, err)
+	require.Equal(t, OrphanReport{RemovedFiles: 2, RemovedBytes: 3}, report)
+	require.NoFileExists(t, leaked)
+
+	// Young orphans are checked again in the next call
+	put([]byte{0x01}, []byte{0x14}, "d")
+	report, err = store.RemoveOrphanedOutputs(time.Hour)
+	require.NoError(t, err)
+	require.Equal(t, OrphanReport{}, report)
+	report, err = store.RemoveOrphanedOutputs(0)
+	require.NoError(t, err)
+	require.Equal(t, OrphanReport{RemovedFiles: 1, RemovedBytes: 1}, report)
+
+	_, err = store.Delete(context.Background(), protocol.DeleteRequest{ActionID: []byte{0x02}})
+	require.NoError(t, err)
+	report, err = store.RemoveOrphanedOutputs(0)
+	require.NoError(t, err)
+	require.Equal(t, OrphanReport{RemovedFiles: 1, RemovedBytes: 1}, report)
+}
+
+func TestLocalBackend_RemoveOrphanedOutputsConcurrentPut(t *testing.T) {
+	store := newTestBackend(t)
+
+	var wg sync.WaitGroup
+	errCh := make(chan error, 4)
+	for w := 0; w < 4; w++ {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			for i := 0; i < 50; i++ {
+				// Re-put with different outputs, so that previous outputs are orphaned
+				_, err := store.Put(cache.PutOpts{
+					Req: protocol.PutRequest{
+						ActionID: []byte{byte(w)},
+						OutputID: []byte{byte(w), byte(i)},
+						BodySize: 1,
+					},
+					Body: bytes.NewReader([]byte{byte(i)}),
+				})
+				if err != nil {
+					errCh <- err
+					return
+				}
+			}
+		}()
+	}
+	done := make(chan struct{})
+	go func() {
+		wg.Wait()
+		close(done)
+	}()
+	for running := true; running; {
+		select {
+		case <-done:
+			running = false
+		default:
+		}
+		_, err := store.RemoveOrphanedOutputs(0)
+		require.NoError(t, err)
+	}
+	close(errCh)
+	for err := range errCh {
+		require.NoError(t, err)
+	}
+
+	for w := 0; w < 4; w++ {
+		resp, err := store.Get(cache.GetOpts{Req: protocol.GetRequest{ActionID: []byte{byte(w)}}})
+		require.NoError(t, err)
+		require.False(t, resp.Miss)
+		data, err := os.ReadFile(resp.DiskPath)
+		require.NoError(t, err)
+		require.Equal(t, []byte{49}, data)
+	}
+}
@@ -0,0 +1,152 @@
+package local
+
+import (
+	"bytes"
+	"crypto/sha256"
+	"errors"
+	"fmt"
+	"io"
+	"io/fs"
+	"os"
+	"path/filepath"
+	"strings"
+
+	"github.com/breezewish/gscache/internal/cache"
+	"go.uber.org/zap"
+)
+
+// errActionRemoved means the action file is removed concurrently, e.g. by a GC.
+var errActionRemoved = errors.New("action file is removed")
+
+type VerifyOpts struct {
+	// If true, output files are also hashed and compared with the OutputID. This reads all
+	// outputs, so it is much slower than only checking sizes. Only OutputIDs in SHA-256 size
+	// are checked, as the go command uses the SHA-256 of the content as the OutputID.
+	CheckHash bool
+	// If true, corrupted entries are removed so that they become misses, instead of only reported.
+	Remove bool
+}
+
+// VerifyReport is the result of a local integrity sweep.
+type VerifyReport struct {
+	EntriesChecked   int
+	EntriesCorrupted int
+	EntriesRemoved   int
+	CheckedBytes     int64 // Bytes of outputs which are hashed
+}
+
+// Verify walks all action files of the local store and checks that each one can be decoded
+// and references an output file of the recorded size (and hash if CheckHash). Without
+// Verify, corruption is only detected when the entry is read by a Get.
+func (store *LocalBackend) Verify(opts VerifyOpts) (*VerifyReport, error) {
+	if store.closed.Load() {
+		return nil, fmt.Errorf("local cache store is closed")
+	}
+	if opts.Remove && store.readOnly {
+		return nil, fmt.Errorf("local cache store is read-only")
+	}
+
+	report := &VerifyReport{}
+	err := filepath.WalkDir(store.dir, func(path string, d fs.DirEntry, err error) error {
+		if err != nil {
+			if os.IsNotExist(err) {
+				return nil
+			}
+			return err
+		}
+		if d.IsDir() || !strings.HasSuffix(path, ".action") {
+			return nil
+		}
+		report.EntriesChecked++
+		outputPath, checkedBytes, err := store.verifyEntry(path, opts.CheckHash)
+		report.CheckedBytes += checkedBytes
+		if err == nil {
+			return nil
+		}
+		if errors.Is(err, errActionRemoved) {
+			report.EntriesChecked--
+			return nil
+		}
+		report.EntriesCorrupted++
+		store.log.Warn("Corrupted local cache entry",
+			zap.String("metaPath", path),
+			zap.String("outputPath", outputPath),
+			zap.Error(err))
+		if !opts.Remove {
+			return nil
+		}
+		if err := os.Remove(path); err != nil && !os.IsNotExist(err) {
+			store.log.Warn("Failed to remove corrupted action file", zap.String("path", path), zap.Error(err))
+			return nil
+		}
+		if outputPath != "" {
+			// The output is corrupted for all entries referencing it, so it is removed as well
+			_ = os.Remove(outputPath)
+		}
+		report.EntriesRemoved++
+		return nil
+	})
+	if err != nil {
+		return report, fmt.Errorf("failed to scan action files: %w", err)
+	}
+	store.log.Info("Local cache verification finished",
+		zap.Int("checked", report.EntriesChecked),
+		zap.Int("corrupted", report.EntriesCorrupted),
+		zap.Int("removed", report.EntriesRemoved),
+		zap.Bool("checkHash", opts.CheckHash))
+	return report, nil
+}
+
+// verifyEntry checks a single action file. When the output file is corrupted, its path is
+// returned along with the error.
+func (store *LocalBackend) verifyEntry(actionPath string, checkHash bool) (outputPath string, checkedBytes int64, err error) {
+	f, err := os.Open(actionPath)
+	if os.IsNotExist(err) {
+		return "", 0, errActionRemoved
+	}
+	if err != nil {
+		return "", 0, err
+	}
+	meta, err := cache.ReadEntryMeta(f)
+	_ = f.Close()
+	if err != nil {
+		return "", 0, fmt.Errorf("failed to read entry metadata: %w", err)
+	}
+	if name := fmt.Sprintf("%x.action", meta.ActionID); name != filepath.Base(actionPath) {
+		return "", 0, fmt.Errorf("action ID mismatch: file name is %s, got %x", filepath.Base(actionPath), meta.ActionID)
+	}
+	if meta.Size == 0 {
+		return "", 0, nil
+	}
+
+	outputPath = store.outputPath(meta.OutputID)
+	info, err := os.Stat(outputPath)
+	if err != nil {
+		// Not returning the path, as there is nothing to remove
+		return "", 0, fmt.Errorf("failed to stat output file: %w", err)
+	}
+	if !info.Mode().IsRegular() {
+		return outputPath, 0, fmt.Errorf("output path is not a regular file: %s", outputPath)
+	}
+	if info.Size() != meta.Size {
+		return outputPath, 0, fmt.Errorf("output file size mismatch: expected %d, got %d", meta.Size, info.Size())
+	}
+	if !checkHash || len(meta.OutputID) != sha256.Size {
+		return outputPath, 0, nil
+	}
+
+	output, err := os.Open(outputPath)
+	if err != nil {
+		return "", 0, fmt.Errorf("failed to open output file: %w", err)
+	}
+	defer output.Close()
+	h := sha256.New()
+	n, err := io.Copy(h, output)
+	if err != nil {
+		return "", n, fmt.Errorf("failed to read output file: %w", err)
+	}
+	if sum := h.Sum(nil); !bytes.Equal(sum, meta.OutputID) {
+		return outputPath, n, fmt.Errorf("output file hash mismatch: expected %x, got %x", meta.OutputID, sum)
+	}
+	return outputPath, n, nil
+}
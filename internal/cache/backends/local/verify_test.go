@@ -0,0 +1,75 @@
+package local
+
+import (
+	"bytes"
+	"crypto/sha256"
+	"os"
+	"testing"
+
+	"github.com/stretchr/testify/require"
+
+	"github.com/breezewish/gscache/internal/cache"
+	"github.com/breezewish/gscache/internal/protocol"
+)
+
+func TestLocalBackend_Verify(t *testing.T) {
+	store := newTestBackend(t)
+
+	put := func(actionID, outputID []byte, body string) string {
+		resp, err := store.Put(cache.PutOpts{
+			Req: protocol.PutRequest{
+				ActionID: actionID,
+				OutputID: outputID,
+				BodySize: int64(len(body)),
+			},
+			Body: bytes.NewReader([]byte(body)),
+		})
+		require.NoError(t, err)
+		return resp.DiskPath
+	}
+	sha := func(body string) []byte {
+		sum := sha256.Sum256([]byte(body))
+		return sum[:]
+	}
+
+	put([]byte{0x01}, sha("good"), "good")
+	put([]byte{0x02}, nil, "")
+	truncatedPath := put([]byte{0x03}, sha("truncated"), "truncated")
+	require.NoError(t, os.Truncate(truncatedPath, 3))
+	// Content does not match the OutputID, only detected by hashing
+	wrongHashPath := put([]byte{0x04}, sha("foo"), "bar")
+	// Short OutputIDs are not hashed
+	put([]byte{0x05}, []byte{0x15}, "short")
+	put([]byte{0x06}, []byte{0x16}, "broken")
+	require.NoError(t, os.WriteFile(store.actionPath("", []byte{0x06}), []byte("garbage"), 0644))
+
+	report, err := store.Verify(VerifyOpts{})
+	require.NoError(t, err)
+	require.Equal(t, VerifyReport{EntriesChecked: 6, EntriesCorrupted: 2}, *report)
+
+	report, err = store.Verify(VerifyOpts{CheckHash: true})
+	require.NoError(t, err)
+	require.Equal(t, VerifyReport{EntriesChecked: 6, EntriesCorrupted: 3, CheckedBytes: 7}, *report)
+	require.FileExists(t, wrongHashPath)
+
+	report, err = store.Verify(VerifyOpts{CheckHash: true, Remove: true})
+	require.NoError(t, err)
+	require.Equal(t, VerifyReport{EntriesChecked: 6, EntriesCorrupted: 3, EntriesRemoved: 3, CheckedBytes: 7}, *report)
+	require.NoFileExists(t, truncatedPath)
+	require.NoFileExists(t, wrongHashPath)
+
+	for _, actionID := range [][]byte{{0x03}, {0x04}, {0x06}} {
+		resp, err := store.Get(cache.GetOpts{Req: protocol.GetRequest{ActionID: actionID}})
+		require.NoError(t, err)
+		require.True(t, resp.Miss)
+	}
+	for _, actionID := range [][]byte{{0x01}, {0x02}, {0x05}} {
+		resp, err := store.Get(cache.GetOpts{Req: protocol.GetRequest{ActionID: actionID}})
+		require.NoError(t, err)
+		require.False(t, resp.Miss)
+	}
+
+	report, err = store.Verify(VerifyOpts{CheckHash: true})
+	require.NoError(t, err)
+	require.Equal(t, VerifyReport{EntriesChecked: 3, CheckedBytes: 4}, *report)
+}
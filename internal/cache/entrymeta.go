@@ -2,6 +2,7 @@ package cache
 
 import (
 	"encoding/binary"
+	"encoding/json"
 	"io"
 	"time"
 )
@@ -13,6 +14,36 @@ type EntryMeta struct {
 	Time     time.Time
 }
 
+// NormalizeTime converts the time to the canonical representation of EntryMeta.Time,
+// which is UTC with nanosecond precision and without monotonic clock reading.
+// Times normalized are kept unchanged after round-trips in both binary and JSON format.
+func NormalizeTime(t time.Time) time.Time {
+	if t.IsZero() {
+		return time.Time{}
+	}
+	return time.Unix(0, t.UnixNano()).UTC()
+}
+
+// entryMetaJSON is used to avoid infinite recursion in MarshalJSON and UnmarshalJSON.
+type entryMetaJSON EntryMeta
+
+// MarshalJSON always encodes Time in UTC, so that the same EntryMeta
+// always has the same JSON representation regardless of the local timezone.
+func (em EntryMeta) MarshalJSON() ([]byte, error) {
+	em.Time = NormalizeTime(em.Time)
+	return json.Marshal(entryMetaJSON(em))
+}
+
+func (em *EntryMeta) UnmarshalJSON(data []byte) error {
+	var v entryMetaJSON
+	if err := json.Unmarshal(data, &v); err != nil {
+		return err
+	}
+	*em = EntryMeta(v)
+	em.Time = NormalizeTime(em.Time)
+	return nil
+}
+
 // WriteTo writes the EntryMeta to an io.Writer in binary format
 // Format: [ActionID length][OutputID length][ActionID][OutputID][Size][Time unix nano]
 func (em EntryMeta) WriteTo(w io.Writer) (int64, error) {
@@ -73,7 +104,7 @@ func ReadEntryMeta(r io.Reader) (EntryMeta, error) {
 	if timeNano == (time.Time{}).UnixNano() {
 		em.Time = time.Time{}
 	} else {
-		em.Time = time.Unix(0, timeNano)
+		em.Time = time.Unix(0, timeNano).UTC()
 	}
 
 	return em, nil
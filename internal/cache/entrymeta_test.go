@@ -2,6 +2,7 @@ package cache
 
 import (
 	"bytes"
+	"encoding/json"
 	"testing"
 	"time"
 
@@ -143,3 +144,49 @@ func TestEntryMeta_ZeroValues(t *testing.T) {
 	require.Equal(t, int64(0), readMeta.Size)
 	require.True(t, meta.Time.Equal(readMeta.Time))
 }
+
+func TestEntryMeta_TimeRoundTrip_JSONAndBinary(t *testing.T) {
+	times := []time.Time{
+		time.Now(), // Contains monotonic clock reading
+		time.Unix(1640995200, 123456789).In(time.FixedZone("UTC+8", 8*3600)),
+		time.Unix(1640995200, 1).Local(),
+		time.Time{},
+	}
+	for _, tm := range times {
+		meta := EntryMeta{
+			ActionID: []byte("action"),
+			OutputID: []byte("output"),
+			Size:     1,
+			Time:     tm,
+		}
+
+		var buf bytes.Buffer
+		_, err := meta.WriteTo(&buf)
+		require.NoError(t, err)
+		fromBinary, err := ReadEntryMeta(&buf)
+		require.NoError(t, err)
+
+		data, err := json.Marshal(meta)
+		require.NoError(t, err)
+		var fromJSON EntryMeta
+		require.NoError(t, json.Unmarshal(data, &fromJSON))
+
+		require.True(t, tm.Equal(fromBinary.Time))
+		require.True(t, tm.Equal(fromJSON.Time))
+		// Both paths produce the identical representation
+		require.Equal(t, fromBinary.Time, fromJSON.Time)
+		require.Equal(t, NormalizeTime(tm), fromJSON.Time)
+		if !tm.IsZero() {
+			require.Equal(t, time.UTC, fromJSON.Time.Location())
+		}
+	}
+}
+
+func TestEntryMeta_UnmarshalJSON_Legacy(t *testing.T) {
+	// Archives written by previous versions may contain times with a non-UTC offset
+	data := []byte(`{"ActionID":"YWN0aW9u","OutputID":"b3V0cHV0","Size":1,"Time":"2022-01-01T08:00:00.123456789+08:00"}`)
+	var meta EntryMeta
+	require.NoError(t, json.Unmarshal(data, &meta))
+	require.Equal(t, []byte("action"), meta.ActionID)
+	require.Equal(t, time.Unix(1640995200, 123456789).UTC(), meta.Time)
+}
@@ -0,0 +1,35 @@
+package cache
+
+import (
+	"context"
+	"sync/atomic"
+)
+
+type servedFromKey struct{}
+
+// WithServedFrom returns a context in which backends can record where a Get is served from
+// (e.g. "local", "archive", "download", "miss"). The value can be read by ServedFrom afterwards.
+func WithServedFrom(ctx context.Context) context.Context {
+	return context.WithValue(ctx, servedFromKey{}, &atomic.Value{})
+}
+
+// SetServedFrom records where a Get is served from. It is a no-op if the context
+// is not created by WithServedFrom.
+func SetServedFrom(ctx context.Context, servedFrom string) {
+	if ctx == nil {
+		return
+	}
+	if v, ok := ctx.Value(servedFromKey{}).(*atomic.Value); ok {
+		v.Store(servedFrom)
+	}
+}
+
+// ServedFrom returns the value recorded by SetServedFrom, or empty if nothing is recorded.
+func ServedFrom(ctx context.Context) string {
+	if v, ok := ctx.Value(servedFromKey{}).(*atomic.Value); ok {
+		if s, ok := v.Load().(string); ok {
+			return s
+		}
+	}
+	return ""
+}
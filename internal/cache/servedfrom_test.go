@@ -0,0 +1,22 @@
+package cache
+
+import (
+	"context"
+	"testing"
+
+	"github.com/stretchr/testify/require"
+)
+
+func TestServedFrom(t *testing.T) {
+	ctx := WithServedFrom(context.Background())
+	require.Equal(t, "", ServedFrom(ctx))
+	SetServedFrom(ctx, "local")
+	SetServedFrom(ctx, "download")
+	require.Equal(t, "download", ServedFrom(ctx))
+
+	// Not recorded without WithServedFrom.
+	ctx = context.Background()
+	SetServedFrom(ctx, "local")
+	require.Equal(t, "", ServedFrom(ctx))
+	SetServedFrom(nil, "local") // GetOpts.Ctx may be nil
+}
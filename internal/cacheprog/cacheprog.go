@@ -13,14 +13,22 @@ import (
 	"github.com/breezewish/gscache/internal/util"
 )
 
+// DefaultMaxConcurrency is the default max number of in-flight requests.
+const DefaultMaxConcurrency = 128
+
 type CacheProg struct {
-	handler CacheHandler
+	handler   CacheHandler
+	namespace string
 
-	wg sync.WaitGroup
+	wg  sync.WaitGroup
+	sem chan struct{} // Limits in-flight requests
 
 	lifecycle       context.Context
 	lifecycleCancel context.CancelCauseFunc
 
+	summaryOut io.Writer // nil if no summary is printed
+	session    sessionStats
+
 	// 1 reader, n writers
 	reader  *util.LineChunkedReader
 	writeMu sync.Mutex // guard jEnc
@@ -31,6 +39,14 @@ type Opts struct {
 	CacheHandler CacheHandler
 	In           io.Reader
 	Out          io.Writer
+	// Optional. If set, all entries are put and get in this namespace.
+	Namespace string
+	// Optional. Max number of in-flight requests. When reached, reading new requests
+	// is paused until some requests are finished. Defaults to DefaultMaxConcurrency.
+	MaxConcurrency int
+	// Optional. If set, a one-line summary of this session (gets, hit ratio, bytes served)
+	// is written to it when the session ends cleanly, e.g. os.Stderr.
+	Summary io.Writer
 }
 
 func New(opts Opts) *CacheProg {
@@ -42,9 +58,16 @@ func New(opts Opts) *CacheProg {
 	if opts.Out == nil {
 		opts.Out = os.Stdout
 	}
+	if opts.MaxConcurrency <= 0 {
+		opts.MaxConcurrency = DefaultMaxConcurrency
+	}
 
 	return &CacheProg{
-		handler: opts.CacheHandler,
+		handler:   opts.CacheHandler,
+		namespace: opts.Namespace,
+		sem:       make(chan struct{}, opts.MaxConcurrency),
+
+		summaryOut: opts.Summary,
 
 		lifecycle:       ctx,
 		lifecycleCancel: cancel,
@@ -88,10 +111,16 @@ func (cp *CacheProg) readLoop() error {
 				pipeRead, pipeWrite := io.Pipe()
 
 				cp.runAsync(func() {
+					// The handler may return without consuming the whole body, e.g. when the
+					// server is unreachable. The rest of the body must still be read from stdin
+					// so that the next request is framed correctly, so writes to the pipe must
+					// not block after the handler returns.
+					defer pipeRead.Close()
 					apiResp, err := cp.handler.Put(protocol.PutRequest{
-						ActionID: req.ActionID,
-						OutputID: req.OutputID,
-						BodySize: req.BodySize,
+						ActionID:  req.ActionID,
+						OutputID:  req.OutputID,
+						BodySize:  req.BodySize,
+						Namespace: cp.namespace,
 					}, pipeRead)
 					if err != nil {
 						cp.mustWriteResponse(protocol.CacheProgResponse{
@@ -99,6 +128,7 @@ func (cp *CacheProg) readLoop() error {
 							Err: err.Error(),
 						})
 					} else {
+						cp.session.recordPut()
 						cp.mustWriteResponse(protocol.CacheProgResponse{
 							ID:       req.ID,
 							DiskPath: apiResp.DiskPath,
@@ -115,7 +145,8 @@ func (cp *CacheProg) readLoop() error {
 							pipeWrite.CloseWithError(io.ErrClosedPipe)
 							return fmt.Errorf("failed to read CmdPut body: %w", err)
 						}
-						pipeWrite.Write(lineChunk)
+						// Write fails after the handler returns early, in which case the rest of the body is discarded.
+						_, _ = pipeWrite.Write(lineChunk)
 						if !isPrefix {
 							pipeWrite.Close()
 							break
@@ -126,7 +157,8 @@ func (cp *CacheProg) readLoop() error {
 		case protocol.CmdGet:
 			cp.runAsync(func() {
 				apiResp, err := cp.handler.Get(protocol.GetRequest{
-					ActionID: req.ActionID,
+					ActionID:  req.ActionID,
+					Namespace: cp.namespace,
 				})
 				if err != nil {
 					cp.mustWriteResponse(protocol.CacheProgResponse{
@@ -134,6 +166,7 @@ func (cp *CacheProg) readLoop() error {
 						Err: err.Error(),
 					})
 				} else {
+					cp.session.recordGet(apiResp)
 					cp.mustWriteResponse(protocol.CacheProgResponse{
 						ID:       req.ID,
 						Miss:     apiResp.Miss,
@@ -155,10 +188,16 @@ func (cp *CacheProg) readLoop() error {
 	}
 }
 
+// runAsync runs fn in a new goroutine. It blocks when there are already too many
+// in-flight requests, so that the read loop is paused (backpressure).
 func (cp *CacheProg) runAsync(fn func()) {
+	cp.sem <- struct{}{}
 	cp.wg.Add(1)
 	go func() {
-		defer cp.wg.Done()
+		defer func() {
+			<-cp.sem
+			cp.wg.Done()
+		}()
 		fn()
 	}()
 }
@@ -178,12 +217,20 @@ func (cp *CacheProg) Run() error {
 
 	go func() {
 		err := cp.readLoop()
+		if err == nil {
+			// Close is received. Requests received before close must all be responded
+			// before Run returns, so wait for them before ending the lifecycle.
+			cp.wg.Wait()
+		}
 		cp.lifecycleCancel(err)
 	}()
 	<-cp.lifecycle.Done()
 
 	err = context.Cause(cp.lifecycle)
 	if errors.Is(err, context.Canceled) {
+		if cp.summaryOut != nil {
+			_, _ = fmt.Fprintln(cp.summaryOut, cp.session.summary())
+		}
 		return nil
 	}
 	return err
@@ -8,7 +8,9 @@ import (
 	"fmt"
 	"io"
 	"strings"
+	"sync/atomic"
 	"testing"
+	"time"
 
 	"github.com/stretchr/testify/require"
 
@@ -334,6 +336,50 @@ test
 	require.JSONEq(t, `{"ID":1,"Err":"put handler error"}`, lines[1])
 }
 
+// earlyErrorHandler fails Put without consuming the body, like a client whose server is unreachable.
+type earlyErrorHandler struct {
+	mockHandler
+}
+
+func (m *earlyErrorHandler) Put(req protocol.PutRequest, body io.Reader) (*protocol.PutResponse, error) {
+	return nil, errors.New("server unreachable")
+}
+
+func TestCacheProg_PutHandlerReturnsWithoutReadingBody(t *testing.T) {
+	handler := &earlyErrorHandler{}
+	var output bytes.Buffer
+
+	// The body spans many reader chunks, and none of them is consumed by the handler.
+	largeBody := `"` + strings.Repeat("a", 100000) + `"`
+
+	cp := New(Opts{
+		CacheHandler: handler,
+		In: strings.NewReader(fmt.Sprintf(`
+{"ID":1,"Command":"put","ActionID":"dGVzdC1hY3Rpb24taWQ=","OutputID":"dGVzdC1vdXRwdXQtaWQ=","BodySize":75000}
+%s
+{"ID":2,"Command":"get","ActionID":"dGVzdC1hY3Rpb24taWQ="}
+{"ID":3,"Command":"close"}
+`, largeBody)),
+		Out: &output,
+	})
+
+	done := make(chan error, 1)
+	go func() {
+		done <- cp.Run()
+	}()
+	select {
+	case err := <-done:
+		require.NoError(t, err)
+	case <-time.After(10 * time.Second):
+		t.Fatal("cacheprog is blocked by the unconsumed Put body")
+	}
+
+	lines := strings.Split(strings.TrimSpace(output.String()), "\n")
+	require.Len(t, lines, 3)
+	require.Contains(t, output.String(), `"Err":"server unreachable"`)
+	require.Len(t, handler.getCalls, 1)
+}
+
 func TestCacheProg_UnknownCommand(t *testing.T) {
 	handler := &mockHandler{}
 	var output bytes.Buffer
@@ -408,3 +454,108 @@ func TestCacheProg_LargePut(t *testing.T) {
 	require.Len(t, lines, 2)
 	require.JSONEq(t, `{"ID":1,"DiskPath":"/tmp/test"}`, lines[1])
 }
+
+type slowHandler struct {
+	mockHandler
+	inflight    atomic.Int32
+	maxInflight atomic.Int32
+}
+
+func (m *slowHandler) Get(req protocol.GetRequest) (*protocol.GetResponse, error) {
+	n := m.inflight.Add(1)
+	defer m.inflight.Add(-1)
+	for {
+		cur := m.maxInflight.Load()
+		if n <= cur || m.maxInflight.CompareAndSwap(cur, n) {
+			break
+		}
+	}
+	time.Sleep(10 * time.Millisecond)
+	return &protocol.GetResponse{Miss: true}, nil
+}
+
+func TestCacheProg_MaxConcurrency(t *testing.T) {
+	handler := &slowHandler{}
+	var output bytes.Buffer
+
+	var input strings.Builder
+	for i := 1; i <= 20; i++ {
+		fmt.Fprintf(&input, `{"ID":%d,"Command":"get","ActionID":"dGVzdC1hY3Rpb24taWQ="}`+"\n", i)
+	}
+	input.WriteString(`{"ID":100,"Command":"close"}` + "\n")
+
+	cp := New(Opts{
+		CacheHandler:   handler,
+		In:             strings.NewReader(input.String()),
+		Out:            &output,
+		MaxConcurrency: 3,
+	})
+
+	err := cp.Run()
+	require.NoError(t, err)
+
+	lines := strings.Split(strings.TrimSpace(output.String()), "\n")
+	require.Len(t, lines, 21)
+	require.LessOrEqual(t, handler.maxInflight.Load(), int32(3))
+	require.Greater(t, handler.maxInflight.Load(), int32(0))
+}
+
+func TestCacheProg_GetThenClose(t *testing.T) {
+	for i := 0; i < 20; i++ {
+		handler := &slowHandler{}
+		var output bytes.Buffer
+
+		cp := New(Opts{
+			CacheHandler: handler,
+			In: strings.NewReader(`{"ID":1,"Command":"get","ActionID":"dGVzdC1hY3Rpb24taWQ="}
+{"ID":2,"Command":"get","ActionID":"dGVzdC1hY3Rpb24taWQ="}
+{"ID":3,"Command":"close"}
+`),
+			Out: &output,
+		})
+		require.NoError(t, cp.Run())
+
+		lines := strings.Split(strings.TrimSpace(output.String()), "\n")
+		require.Len(t, lines, 3)
+		require.ElementsMatch(t, []string{`{"ID":1,"Miss":true}`, `{"ID":2,"Miss":true}`}, lines[1:])
+	}
+}
+
+func TestCacheProg_Summary(t *testing.T) {
+	handler := &mockHandler{
+		getResp: &protocol.GetResponse{OutputID: []byte("output"), Size: 2048, DiskPath: "/tmp/test", ServedFrom: "download"},
+	}
+	var output, summary bytes.Buffer
+
+	cp := New(Opts{
+		CacheHandler: handler,
+		In: strings.NewReader(`
+{"ID":1,"Command":"get","ActionID":"dGVzdC1hY3Rpb24taWQ="}
+{"ID":2,"Command":"put","ActionID":"dGVzdC1hY3Rpb24taWQ=","OutputID":"dGVzdC1vdXRwdXQtaWQ=","BodySize":0}
+{"ID":3,"Command":"close"}
+`),
+		Out:     &output,
+		Summary: &summary,
+	})
+	require.NoError(t, cp.Run())
+	require.Equal(t, "gscache: 1 gets, 100.0% hit, 2.0KB from cache (2.0KB downloaded), 1 puts\n", summary.String())
+
+	// Silent by default
+	cp = New(Opts{
+		CacheHandler: handler,
+		In:           strings.NewReader(`{"ID":1,"Command":"close"}`),
+		Out:          &output,
+	})
+	require.NoError(t, cp.Run())
+}
+
+func TestSessionStats_Summary(t *testing.T) {
+	var s sessionStats
+	require.Equal(t, "gscache: 0 gets, 0.0% hit, 0B from cache (0B downloaded), 0 puts", s.summary())
+
+	s.recordGet(&protocol.GetResponse{Miss: true})
+	s.recordGet(&protocol.GetResponse{Size: 1024 * 1024, ServedFrom: "local"})
+	s.recordGet(&protocol.GetResponse{Size: 1024 * 1024, ServedFrom: "download"})
+	s.recordGet(&protocol.GetResponse{Size: 1024 * 1024})
+	require.Equal(t, "gscache: 4 gets, 75.0% hit, 3.0MB from cache (1.0MB downloaded), 0 puts", s.summary())
+}
@@ -1,10 +1,14 @@
 package cacheprog
 
 import (
+	"context"
+	"fmt"
 	"io"
 
+	"github.com/breezewish/gscache/internal/cache"
 	"github.com/breezewish/gscache/internal/client"
 	"github.com/breezewish/gscache/internal/protocol"
+	"github.com/breezewish/gscache/internal/stats"
 )
 
 // CacheHandler abstracts Get and Put so that we can unit test it.
@@ -33,3 +37,69 @@ func (c *HandlerViaServer) Put(req protocol.PutRequest, body io.Reader) (*protoc
 func (c *HandlerViaServer) Get(req protocol.GetRequest) (*protocol.GetResponse, error) {
 	return c.client.CallGet(req)
 }
+
+// HandlerViaBackend serves cache API calls by a backend in the current process, without a daemon.
+// Stats are counted in the same way as the server does.
+type HandlerViaBackend struct {
+	backend cache.Backend
+}
+
+var _ CacheHandler = (*HandlerViaBackend)(nil)
+
+// NewHandlerViaBackend returns a handler using the backend, which must be already opened.
+func NewHandlerViaBackend(backend cache.Backend) CacheHandler {
+	return &HandlerViaBackend{
+		backend: backend,
+	}
+}
+
+func (c *HandlerViaBackend) Put(req protocol.PutRequest, body io.Reader) (*protocol.PutResponse, error) {
+	if err := req.Validate(); err != nil {
+		return nil, fmt.Errorf("invalid Put request: %w", err)
+	}
+
+	defer stats.Default.Persist()
+	stats.Default.PutTotal.Inc()
+	stats.Default.PutBytes.Add(uint64(max(req.BodySize, 0)))
+	stats.Default.PutSize.Observe(req.BodySize)
+	stats.Default.Misses.RecordPut(req.ActionID, req.BodySize)
+
+	resp, err := c.backend.Put(cache.PutOpts{
+		Req:  req,
+		Body: body,
+	})
+	if err != nil {
+		stats.Default.PutError.Inc()
+		return nil, err
+	}
+	return resp, nil
+}
+
+func (c *HandlerViaBackend) Get(req protocol.GetRequest) (*protocol.GetResponse, error) {
+	if err := req.Validate(); err != nil {
+		return nil, fmt.Errorf("invalid Get request: %w", err)
+	}
+
+	defer stats.Default.Persist()
+	stats.Default.GetTotal.Inc()
+
+	ctx := cache.WithServedFrom(context.Background())
+	resp, err := c.backend.Get(cache.GetOpts{
+		Req: req,
+		Ctx: ctx,
+	})
+	if err != nil {
+		stats.Default.GetError.Inc()
+		return nil, err
+	}
+	if resp.Miss {
+		stats.Default.GetMiss.Inc()
+		stats.Default.Misses.Record(req.ActionID)
+		return resp, nil
+	}
+	stats.Default.GetHit.Inc()
+	// Copy, as the response may be shared by deduplicated requests
+	respWithSource := *resp
+	respWithSource.ServedFrom = cache.ServedFrom(ctx)
+	return &respWithSource, nil
+}
@@ -0,0 +1,53 @@
+package cacheprog
+
+import (
+	"bytes"
+	"context"
+	"os"
+	"testing"
+
+	"github.com/stretchr/testify/require"
+
+	"github.com/breezewish/gscache/internal/cache/backends/local"
+	"github.com/breezewish/gscache/internal/protocol"
+	"github.com/breezewish/gscache/internal/stats"
+)
+
+func TestHandlerViaBackend_PutGet(t *testing.T) {
+	backend, err := local.NewLocalBackend(t.TempDir())
+	require.NoError(t, err)
+	require.NoError(t, backend.Open(context.Background()))
+	defer backend.Close()
+
+	handler := NewHandlerViaBackend(backend)
+	getTotal, getHit, getMiss := stats.Default.GetTotal.Load(), stats.Default.GetHit.Load(), stats.Default.GetMiss.Load()
+
+	getResp, err := handler.Get(protocol.GetRequest{ActionID: []byte("action")})
+	require.NoError(t, err)
+	require.True(t, getResp.Miss)
+
+	putResp, err := handler.Put(protocol.PutRequest{
+		ActionID: []byte("action"),
+		OutputID: []byte("output"),
+		BodySize: 5,
+	}, bytes.NewReader([]byte("hello")))
+	require.NoError(t, err)
+	data, err := os.ReadFile(putResp.DiskPath)
+	require.NoError(t, err)
+	require.Equal(t, "hello", string(data))
+
+	getResp, err = handler.Get(protocol.GetRequest{ActionID: []byte("action")})
+	require.NoError(t, err)
+	require.False(t, getResp.Miss)
+	require.Equal(t, []byte("output"), getResp.OutputID)
+	require.Equal(t, int64(5), getResp.Size)
+	require.Equal(t, "local", getResp.ServedFrom)
+
+	require.Equal(t, getTotal+2, stats.Default.GetTotal.Load())
+	require.Equal(t, getHit+1, stats.Default.GetHit.Load())
+	require.Equal(t, getMiss+1, stats.Default.GetMiss.Load())
+
+	// Invalid requests never reach the backend
+	_, err = handler.Get(protocol.GetRequest{})
+	require.ErrorContains(t, err, "invalid Get request")
+}
@@ -0,0 +1,142 @@
+package cacheprog
+
+import (
+	"fmt"
+	"io"
+	"io/fs"
+	"os"
+	"path/filepath"
+	"time"
+
+	gonanoid "github.com/matoous/go-nanoid/v2"
+
+	"github.com/breezewish/gscache/internal/client"
+	"github.com/breezewish/gscache/internal/protocol"
+)
+
+// HandlerViaRemoteServer delegates cache API calls to a gscache server on another machine,
+// where DiskPath of the server is not accessible. Bodies are streamed over HTTP instead, and
+// materialized in a local dir, whose paths are returned to the go command.
+type HandlerViaRemoteServer struct {
+	client  *client.Client
+	bodyDir string
+}
+
+var _ CacheHandler = (*HandlerViaRemoteServer)(nil)
+
+// NewHandlerViaRemoteServer returns a handler materializing bodies in bodyDir. Bodies are named
+// by their OutputID, so that the dir can be shared by concurrent go commands.
+func NewHandlerViaRemoteServer(config client.Config, bodyDir string) *HandlerViaRemoteServer {
+	return &HandlerViaRemoteServer{
+		client:  client.NewClient(config),
+		bodyDir: bodyDir,
+	}
+}
+
+func (c *HandlerViaRemoteServer) bodyPath(outputID []byte) string {
+	name := fmt.Sprintf("%x", outputID)
+	if len(name) < 2 {
+		return filepath.Join(c.bodyDir, "_", "_"+name)
+	}
+	return filepath.Join(c.bodyDir, name[:2], name)
+}
+
+// writeBody writes the body to its path atomically, and returns the path.
+func (c *HandlerViaRemoteServer) writeBody(outputID []byte, size int64, body io.Reader) (string, error) {
+	path := c.bodyPath(outputID)
+	if err := os.MkdirAll(filepath.Dir(path), 0755); err != nil {
+		return "", fmt.Errorf("failed to create body dir: %w", err)
+	}
+	pathTmp := path + ".tmp." + gonanoid.Must(8)
+	f, err := os.Create(pathTmp)
+	if err != nil {
+		return "", fmt.Errorf("failed to create body file: %w", err)
+	}
+	defer os.Remove(pathTmp)
+	n, err := io.Copy(f, body)
+	if closeErr := f.Close(); err == nil {
+		err = closeErr
+	}
+	if err != nil {
+		return "", fmt.Errorf("failed to write body file: %w", err)
+	}
+	if n != size {
+		return "", fmt.Errorf("body size mismatch: expect %d, got %d", size, n)
+	}
+	if err := os.Rename(pathTmp, path); err != nil {
+		return "", fmt.Errorf("failed to write body file: %w", err)
+	}
+	return path, nil
+}
+
+func (c *HandlerViaRemoteServer) Put(req protocol.PutRequest, body io.Reader) (*protocol.PutResponse, error) {
+	if req.BodySize == 0 {
+		body = io.LimitReader(body, 0)
+	} else {
+		decoded, err := protocol.DecodePutBody(body)
+		if err != nil {
+			return nil, err
+		}
+		body = decoded
+	}
+	// Materialized first, so that the upload reads the local file instead of holding the stdin
+	path, err := c.writeBody(req.OutputID, req.BodySize, body)
+	if err != nil {
+		return nil, err
+	}
+	f, err := os.Open(path)
+	if err != nil {
+		return nil, err
+	}
+	defer f.Close()
+	resp, err := c.client.PutPlain(req, f)
+	if err != nil {
+		return nil, err
+	}
+	localResp := *resp
+	localResp.DiskPath = path
+	return &localResp, nil
+}
+
+func (c *HandlerViaRemoteServer) Get(req protocol.GetRequest) (*protocol.GetResponse, error) {
+	resp, body, err := c.client.CallGetStream(req)
+	if err != nil {
+		return nil, err
+	}
+	if resp.Miss {
+		return resp, nil
+	}
+	defer body.Close()
+	path, err := c.writeBody(resp.OutputID, resp.Size, body)
+	if err != nil {
+		return nil, err
+	}
+	resp.DiskPath = path
+	return resp, nil
+}
+
+// Trim removes bodies not written within maxAge, as they are not trimmed by the go command.
+func (c *HandlerViaRemoteServer) Trim(maxAge time.Duration) (int, error) {
+	removed := 0
+	cutoff := time.Now().Add(-maxAge)
+	err := filepath.WalkDir(c.bodyDir, func(path string, d fs.DirEntry, err error) error {
+		if err != nil {
+			if os.IsNotExist(err) {
+				return nil
+			}
+			return err
+		}
+		if d.IsDir() {
+			return nil
+		}
+		info, err := d.Info()
+		if err != nil {
+			return nil // Removed concurrently
+		}
+		if info.ModTime().Before(cutoff) && os.Remove(path) == nil {
+			removed++
+		}
+		return nil
+	})
+	return removed, err
+}
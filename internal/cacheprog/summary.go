@@ -0,0 +1,50 @@
+package cacheprog
+
+import (
+	"fmt"
+	"sync/atomic"
+
+	"github.com/breezewish/gscache/internal/protocol"
+	"github.com/breezewish/gscache/internal/util"
+)
+
+// sessionStats accumulates what the cache did for this cacheprog session, i.e. a single
+// go command invocation, so that it can be summarized when the session ends.
+type sessionStats struct {
+	gets            atomic.Uint64
+	hits            atomic.Uint64
+	hitBytes        atomic.Uint64
+	downloadedBytes atomic.Uint64
+	puts            atomic.Uint64
+}
+
+func (s *sessionStats) recordGet(resp *protocol.GetResponse) {
+	s.gets.Add(1)
+	if resp.Miss {
+		return
+	}
+	s.hits.Add(1)
+	s.hitBytes.Add(uint64(resp.Size))
+	if resp.ServedFrom == "download" {
+		s.downloadedBytes.Add(uint64(resp.Size))
+	}
+}
+
+func (s *sessionStats) recordPut() {
+	s.puts.Add(1)
+}
+
+// summary returns a one-line human readable summary, like
+// "gscache: 1234 gets, 87.0% hit, 45.0MB from cache (3.0MB downloaded), 160 puts".
+func (s *sessionStats) summary() string {
+	gets := s.gets.Load()
+	hitRatio := 0.0
+	if gets > 0 {
+		hitRatio = float64(s.hits.Load()) / float64(gets) * 100
+	}
+	return fmt.Sprintf("gscache: %d gets, %.1f%% hit, %s from cache (%s downloaded), %d puts",
+		gets, hitRatio,
+		util.FormatBytes(s.hitBytes.Load()),
+		util.FormatBytes(s.downloadedBytes.Load()),
+		s.puts.Load())
+}
@@ -2,36 +2,128 @@ package client
 
 import (
 	"bytes"
+	"compress/gzip"
+	"context"
+	"crypto/tls"
 	"encoding/json"
 	"fmt"
 	"io"
+	"net"
+	"net/http"
+	"net/url"
+	"strconv"
 	"time"
 
 	"github.com/breezewish/gscache/internal/protocol"
+	"github.com/breezewish/gscache/internal/stats"
+	"github.com/breezewish/gscache/internal/util"
 	"github.com/go-resty/resty/v2"
 )
 
+const DefaultDaemonHost = "127.0.0.1"
+
 type Config struct {
+	DaemonHost string // If empty, DefaultDaemonHost is used
 	DaemonPort int
+	// If set, the daemon is connected via this unix socket when it is available, otherwise
+	// via DaemonHost and DaemonPort.
+	SocketPath string
+	Token      string // If set, sent as a bearer token in every request
+	// If set, the daemon is connected via HTTPS with this config. The unix socket is always plain
+	// HTTP, as it is only accessible locally.
+	TLS *tls.Config
 }
 
 // Client talks to a gscache server daemon via HTTP REST API
 type Client struct {
 	client *resty.Client
 	config Config
+
+	// Same as client but without timeout, for maintenance calls which may take long.
+	maintenanceClient *resty.Client
+
+	// Put bodies are gzip encoded when the daemon is not on loopback, e.g. behind a remote proxy.
+	// Responses are decoded transparently by the HTTP transport.
+	gzipRequestBody bool
 }
 
 func NewClient(config Config) *Client {
+	if config.DaemonHost == "" {
+		config.DaemonHost = DefaultDaemonHost
+	}
+	scheme := "http"
+	if config.TLS != nil {
+		scheme = "https"
+	}
+	baseURL := scheme + "://" + net.JoinHostPort(config.DaemonHost, strconv.Itoa(config.DaemonPort))
 	client := resty.New().
 		SetTimeout(30 * time.Second).
-		SetBaseURL(fmt.Sprintf("http://127.0.0.1:%d", config.DaemonPort)).
+		SetBaseURL(baseURL).
+		SetError(&protocol.ErrorResponse{})
+	maintenanceClient := resty.New().
+		SetBaseURL(baseURL).
 		SetError(&protocol.ErrorResponse{})
+	if config.Token != "" {
+		client.SetAuthToken(config.Token)
+		maintenanceClient.SetAuthToken(config.Token)
+	}
+	if config.SocketPath != "" || config.TLS != nil {
+		transport := newTransport(config)
+		client.SetTransport(transport)
+		maintenanceClient.SetTransport(transport)
+	}
 	return &Client{
-		client: client,
-		config: config,
+		client:            client,
+		config:            config,
+		maintenanceClient: maintenanceClient,
+		gzipRequestBody:   !util.IsLoopbackHost(config.DaemonHost),
 	}
 }
 
+// newTransport returns a transport for TLS and the unix socket in the config. The socket is
+// tried for each connection, as the daemon may be started after the client, and TCP is the
+// fallback if the socket cannot be connected, e.g. the daemon does not listen on it.
+func newTransport(config Config) *http.Transport {
+	transport := http.DefaultTransport.(*http.Transport).Clone()
+	transport.TLSClientConfig = config.TLS
+	if config.SocketPath == "" {
+		return transport
+	}
+	dialer := &net.Dialer{Timeout: 30 * time.Second, KeepAlive: 30 * time.Second}
+	transport.DialContext = func(ctx context.Context, network, addr string) (net.Conn, error) {
+		if conn, err := dialer.DialContext(ctx, "unix", config.SocketPath); err == nil {
+			return conn, nil
+		}
+		return dialer.DialContext(ctx, network, addr)
+	}
+	if config.TLS != nil {
+		tlsDialer := &tls.Dialer{NetDialer: dialer, Config: config.TLS}
+		// A non-TLS connection returned here is used as plain HTTP
+		transport.DialTLSContext = func(ctx context.Context, network, addr string) (net.Conn, error) {
+			if conn, err := dialer.DialContext(ctx, "unix", config.SocketPath); err == nil {
+				return conn, nil
+			}
+			return tlsDialer.DialContext(ctx, network, addr)
+		}
+	}
+	return transport
+}
+
+// gzipReader returns a reader producing the gzip encoded content of r.
+// It must be closed to release the encoding goroutine.
+func gzipReader(r io.Reader) io.ReadCloser {
+	pipeR, pipeW := io.Pipe()
+	go func() {
+		gz := gzip.NewWriter(pipeW)
+		_, err := io.Copy(gz, r)
+		if err == nil {
+			err = gz.Close()
+		}
+		pipeW.CloseWithError(err)
+	}()
+	return pipeR
+}
+
 type ClientError struct {
 	msg string
 }
@@ -59,6 +151,49 @@ func (c *Client) CallShutdown() (*protocol.ShutdownResponse, error) {
 	return r.Result().(*protocol.ShutdownResponse), nil
 }
 
+// CallStatus returns the status of the daemon and its backend. There is no timeout, as
+// the local disk usage is computed by walking the work dir.
+func (c *Client) CallStatus() (*protocol.StatusResponse, error) {
+	r, err := c.maintenanceClient.R().
+		SetResult(&protocol.StatusResponse{}).
+		Get("/status")
+	if err != nil {
+		return nil, err
+	}
+	if r.IsError() {
+		return nil, newClientError(r)
+	}
+	return r.Result().(*protocol.StatusResponse), nil
+}
+
+// CallStats returns live statistics of the daemon. Stats of the response is a *stats.Metrics.
+func (c *Client) CallStats() (*protocol.StatsResponse, error) {
+	r, err := c.client.R().
+		SetResult(&protocol.StatsResponse{Stats: stats.NewMetrics()}).
+		Get("/stats")
+	if err != nil {
+		return nil, err
+	}
+	if r.IsError() {
+		return nil, newClientError(r)
+	}
+	return r.Result().(*protocol.StatsResponse), nil
+}
+
+// CallInFlight returns operations currently running in the daemon.
+func (c *Client) CallInFlight() (*protocol.InFlightResponse, error) {
+	r, err := c.client.R().
+		SetResult(&protocol.InFlightResponse{}).
+		Get("/inflight")
+	if err != nil {
+		return nil, err
+	}
+	if r.IsError() {
+		return nil, newClientError(r)
+	}
+	return r.Result().(*protocol.InFlightResponse), nil
+}
+
 func (c *Client) CallStatsClear() (*protocol.StatsClearResponse, error) {
 	r, err := c.client.R().
 		SetResult(&protocol.StatsClearResponse{}).
@@ -72,6 +207,83 @@ func (c *Client) CallStatsClear() (*protocol.StatsClearResponse, error) {
 	return r.Result().(*protocol.StatsClearResponse), nil
 }
 
+// CallConfigReload makes the daemon re-read its config file, and returns what is changed.
+func (c *Client) CallConfigReload() (*protocol.ConfigReloadResponse, error) {
+	r, err := c.client.R().
+		SetResult(&protocol.ConfigReloadResponse{}).
+		Post("/config/reload")
+	if err != nil {
+		return nil, err
+	}
+	if r.IsError() {
+		return nil, newClientError(r)
+	}
+	return r.Result().(*protocol.ConfigReloadResponse), nil
+}
+
+// CallGC trims the local store of the daemon. It may take a while for a large store,
+// so there is no timeout.
+func (c *Client) CallGC(req protocol.GCRequest) (*protocol.GCResponse, error) {
+	r, err := c.maintenanceClient.R().
+		SetResult(&protocol.GCResponse{}).
+		SetBody(req).
+		Post("/gc")
+	if err != nil {
+		return nil, err
+	}
+	if r.IsError() {
+		return nil, newClientError(r)
+	}
+	return r.Result().(*protocol.GCResponse), nil
+}
+
+// CallPrefetch downloads entries matching actionID prefixes into the daemon. There is no
+// timeout, as there may be many entries. Progress is reported to onProgress if set.
+func (c *Client) CallPrefetch(req protocol.PrefetchRequest, onProgress ProgressFunc) (*protocol.PrefetchResponse, error) {
+	resp := &protocol.PrefetchResponse{}
+	if err := c.postWithProgress("/prefetch", req, resp, onProgress); err != nil {
+		return nil, err
+	}
+	return resp, nil
+}
+
+// CallWarm downloads remote data into the daemon ahead of builds. There is no timeout, as
+// downloading all archives may take a while. Progress is reported to onProgress if set.
+func (c *Client) CallWarm(req protocol.WarmRequest, onProgress ProgressFunc) (*protocol.WarmResponse, error) {
+	resp := &protocol.WarmResponse{}
+	if err := c.postWithProgress("/warm", req, resp, onProgress); err != nil {
+		return nil, err
+	}
+	return resp, nil
+}
+
+// CallCompact compacts small blobs into archives in the daemon. There is no timeout, as
+// listing and downloading blobs of all keyspaces may take a while. Progress is reported to
+// onProgress if set.
+func (c *Client) CallCompact(req protocol.CompactRequest, onProgress ProgressFunc) (*protocol.CompactResponse, error) {
+	resp := &protocol.CompactResponse{}
+	if err := c.postWithProgress("/compact", req, resp, onProgress); err != nil {
+		return nil, err
+	}
+	return resp, nil
+}
+
+// CallSize reports disk usage of the daemon's work dir, and the remote bucket if remote is set.
+// There is no timeout, as listing a large bucket may take a while.
+func (c *Client) CallSize(remote bool) (*protocol.SizeResponse, error) {
+	r, err := c.maintenanceClient.R().
+		SetResult(&protocol.SizeResponse{}).
+		SetQueryParam("remote", strconv.FormatBool(remote)).
+		Get("/size")
+	if err != nil {
+		return nil, err
+	}
+	if r.IsError() {
+		return nil, newClientError(r)
+	}
+	return r.Result().(*protocol.SizeResponse), nil
+}
+
 func (c *Client) CallPing() (*protocol.PingResponse, error) {
 	r, err := c.client.R().
 		SetResult(&protocol.PingResponse{}).
@@ -85,6 +297,38 @@ func (c *Client) CallPing() (*protocol.PingResponse, error) {
 	return r.Result().(*protocol.PingResponse), nil
 }
 
+// CallLogs streams the last lines of the server log file, and subsequent lines if follow is set.
+// Unlike other calls there is no timeout, as following never ends: cancel ctx to stop.
+// The returned body must be closed.
+func (c *Client) CallLogs(ctx context.Context, lines int, follow bool) (io.ReadCloser, error) {
+	query := url.Values{}
+	query.Set("lines", strconv.Itoa(lines))
+	query.Set("follow", strconv.FormatBool(follow))
+	req, err := http.NewRequestWithContext(ctx, http.MethodGet,
+		c.client.BaseURL+"/logs?"+query.Encode(), nil)
+	if err != nil {
+		return nil, err
+	}
+	if c.config.Token != "" {
+		req.Header.Set("Authorization", "Bearer "+c.config.Token)
+	}
+	streamClient := *c.client.GetClient()
+	streamClient.Timeout = 0
+	resp, err := streamClient.Do(req)
+	if err != nil {
+		return nil, err
+	}
+	if resp.StatusCode != http.StatusOK {
+		defer resp.Body.Close()
+		var errResp protocol.ErrorResponse
+		if err := json.NewDecoder(resp.Body).Decode(&errResp); err != nil {
+			return nil, fmt.Errorf("unexpected response status %s", resp.Status)
+		}
+		return nil, ClientError{msg: errResp.Error}
+	}
+	return resp.Body, nil
+}
+
 func (c *Client) CallPut(req protocol.PutRequest, encodedPayload io.Reader) (*protocol.PutResponse, error) {
 	// Note: Unlike other APIs, PUT is carefully designed in a streaming way
 
@@ -102,10 +346,17 @@ func (c *Client) CallPut(req protocol.PutRequest, encodedPayload io.Reader) (*pr
 		bodyReader = encodedReq
 	}
 
-	r, err := c.client.R().
+	httpReq := c.client.R().
 		SetResult(&protocol.PutResponse{}).
+		SetHeader("Content-Type", "application/octet-stream")
+	if c.gzipRequestBody {
+		gzBody := gzipReader(bodyReader)
+		defer gzBody.Close()
+		httpReq.SetHeader("Content-Encoding", "gzip")
+		bodyReader = gzBody
+	}
+	r, err := httpReq.
 		SetBody(bodyReader).
-		SetHeader("Content-Type", "application/octet-stream").
 		Post("/cacheprog/put")
 	if err != nil {
 		return nil, err
@@ -117,6 +368,20 @@ func (c *Client) CallPut(req protocol.PutRequest, encodedPayload io.Reader) (*pr
 	return resp, nil
 }
 
+func (c *Client) CallExistsBatch(req protocol.ExistsBatchRequest) (*protocol.ExistsBatchResponse, error) {
+	r, err := c.client.R().
+		SetResult(&protocol.ExistsBatchResponse{}).
+		SetBody(req).
+		Post("/cacheprog/exists_batch")
+	if err != nil {
+		return nil, err
+	}
+	if r.IsError() {
+		return nil, newClientError(r)
+	}
+	return r.Result().(*protocol.ExistsBatchResponse), nil
+}
+
 func (c *Client) CallGet(req protocol.GetRequest) (*protocol.GetResponse, error) {
 	r, err := c.client.R().
 		SetResult(&protocol.GetResponse{}).
@@ -131,3 +396,65 @@ func (c *Client) CallGet(req protocol.GetRequest) (*protocol.GetResponse, error)
 	resp := r.Result().(*protocol.GetResponse)
 	return resp, nil
 }
+
+// CallGetStream is the same as CallGet, but the body of a hit is streamed in the response,
+// for clients not on the same machine as the daemon, see GetRequest.StreamBody. The returned
+// body is nil on a miss, and must be closed otherwise.
+func (c *Client) CallGetStream(req protocol.GetRequest) (*protocol.GetResponse, io.ReadCloser, error) {
+	req.StreamBody = true
+	r, err := c.client.R().
+		SetBody(req).
+		SetDoNotParseResponse(true).
+		Post("/cacheprog/get")
+	if err != nil {
+		return nil, nil, err
+	}
+	body := r.RawBody()
+	if r.StatusCode() != http.StatusOK {
+		defer body.Close()
+		var errResp protocol.ErrorResponse
+		if err := json.NewDecoder(body).Decode(&errResp); err != nil {
+			return nil, nil, fmt.Errorf("unexpected response status %s", r.Status())
+		}
+		return nil, nil, ClientError{msg: errResp.Error}
+	}
+	dec := json.NewDecoder(body)
+	var resp protocol.GetResponse
+	if err := dec.Decode(&resp); err != nil {
+		_ = body.Close()
+		return nil, nil, fmt.Errorf("failed to decode Get response: %w", err)
+	}
+	if resp.Miss {
+		_ = body.Close()
+		return &resp, nil, nil
+	}
+	// The body follows the newline after the JSON
+	rest := io.MultiReader(dec.Buffered(), body)
+	if resp.Size > 0 {
+		var newline [1]byte
+		if _, err := io.ReadFull(rest, newline[:]); err != nil || newline[0] != '\n' {
+			_ = body.Close()
+			return nil, nil, fmt.Errorf("unexpected body of Get response")
+		}
+	}
+	return &resp, readCloser{Reader: io.LimitReader(rest, resp.Size), Closer: body}, nil
+}
+
+type readCloser struct {
+	io.Reader
+	io.Closer
+}
+
+func (c *Client) CallDelete(req protocol.DeleteRequest) (*protocol.DeleteResponse, error) {
+	r, err := c.client.R().
+		SetResult(&protocol.DeleteResponse{}).
+		SetBody(req).
+		Delete("/cacheprog/entry")
+	if err != nil {
+		return nil, err
+	}
+	if r.IsError() {
+		return nil, newClientError(r)
+	}
+	return r.Result().(*protocol.DeleteResponse), nil
+}
@@ -0,0 +1,98 @@
+package client
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"io"
+	"net/http"
+	"net/url"
+	"strings"
+	"time"
+
+	"github.com/breezewish/gscache/internal/protocol"
+	"github.com/breezewish/gscache/internal/stats"
+)
+
+// EventsOptions selects events streamed by CallEvents.
+type EventsOptions struct {
+	Kinds         []string      // Kinds of activity events, e.g. "get". All kinds if empty
+	StatsInterval time.Duration // If set, stats are streamed at this interval
+}
+
+// EventHandlers receive events streamed by CallEvents. Nil handlers are skipped.
+type EventHandlers struct {
+	OnActivity func(protocol.ActivityEvent)
+	OnStats    func(*protocol.StatsResponse)
+	OnDropped  func(protocol.EventsDropped)
+}
+
+// CallEvents streams events of the daemon to handlers. Like CallLogs there is no timeout, as
+// the stream never ends: it returns nil when ctx is cancelled.
+func (c *Client) CallEvents(ctx context.Context, opts EventsOptions, handlers EventHandlers) error {
+	query := url.Values{}
+	if len(opts.Kinds) > 0 {
+		query.Set("kinds", strings.Join(opts.Kinds, ","))
+	}
+	if opts.StatsInterval > 0 {
+		query.Set("stats", opts.StatsInterval.String())
+	}
+	req, err := http.NewRequestWithContext(ctx, http.MethodGet,
+		c.client.BaseURL+"/events?"+query.Encode(), nil)
+	if err != nil {
+		return err
+	}
+	if c.config.Token != "" {
+		req.Header.Set("Authorization", "Bearer "+c.config.Token)
+	}
+	streamClient := *c.client.GetClient()
+	streamClient.Timeout = 0
+	resp, err := streamClient.Do(req)
+	if err != nil {
+		if ctx.Err() != nil {
+			return nil
+		}
+		return err
+	}
+	defer resp.Body.Close()
+	if resp.StatusCode != http.StatusOK {
+		var errResp protocol.ErrorResponse
+		if err := json.NewDecoder(resp.Body).Decode(&errResp); err != nil {
+			return fmt.Errorf("unexpected response status %s", resp.Status)
+		}
+		return ClientError{msg: errResp.Error}
+	}
+
+	err = scanEvents(resp.Body, func(event, data string) (bool, error) {
+		switch event {
+		case "stats":
+			if handlers.OnStats == nil {
+				return false, nil
+			}
+			st := &protocol.StatsResponse{Stats: stats.NewMetrics()}
+			if err := json.Unmarshal([]byte(data), st); err != nil {
+				return false, fmt.Errorf("failed to decode stats: %w", err)
+			}
+			handlers.OnStats(st)
+		case "dropped":
+			var dropped protocol.EventsDropped
+			if err := json.Unmarshal([]byte(data), &dropped); err == nil && handlers.OnDropped != nil {
+				handlers.OnDropped(dropped)
+			}
+		default:
+			// Activity events are named by their kinds, which may be added by newer daemons
+			var activity protocol.ActivityEvent
+			if err := json.Unmarshal([]byte(data), &activity); err == nil && handlers.OnActivity != nil {
+				handlers.OnActivity(activity)
+			}
+		}
+		return false, nil
+	})
+	if ctx.Err() != nil {
+		return nil
+	}
+	if err == io.EOF {
+		return fmt.Errorf("daemon closed the event stream")
+	}
+	return err
+}
@@ -2,8 +2,10 @@ package client
 
 import (
 	"context"
+	"encoding/base64"
 	"errors"
 	"fmt"
+	"io"
 	"syscall"
 	"time"
 
@@ -61,3 +63,26 @@ func (c *Client) ShutdownAndWait(maxWait time.Duration) (bool, error) {
 
 	return true, nil
 }
+
+// PutPlain is similar to CallPut, but accepts a plain body instead of a
+// base64 encoded JSON string. The body is encoded in a streaming way.
+func (c *Client) PutPlain(req protocol.PutRequest, body io.Reader) (*protocol.PutResponse, error) {
+	if req.BodySize == 0 {
+		return c.CallPut(req, nil)
+	}
+	pipeR, pipeW := io.Pipe()
+	go func() {
+		_, _ = pipeW.Write([]byte{'"'})
+		enc := base64.NewEncoder(base64.StdEncoding, pipeW)
+		if _, err := io.Copy(enc, body); err != nil {
+			pipeW.CloseWithError(err)
+			return
+		}
+		_ = enc.Close()
+		_, _ = pipeW.Write([]byte{'"'})
+		_ = pipeW.Close()
+	}()
+	resp, err := c.CallPut(req, pipeR)
+	_ = pipeR.Close()
+	return resp, err
+}
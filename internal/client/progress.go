@@ -0,0 +1,112 @@
+package client
+
+import (
+	"bufio"
+	"encoding/json"
+	"fmt"
+	"io"
+	"net/http"
+	"strings"
+
+	"github.com/breezewish/gscache/internal/progress"
+	"github.com/breezewish/gscache/internal/protocol"
+)
+
+// ProgressFunc receives progress of a long running call.
+type ProgressFunc func(progress.Progress)
+
+// postWithProgress posts req to the path of the daemon and decodes the response into result.
+// If onProgress is set, the daemon streams progress events, which are passed to onProgress
+// until the result arrives. There is no timeout.
+func (c *Client) postWithProgress(path string, req any, result any, onProgress ProgressFunc) error {
+	if onProgress == nil {
+		r, err := c.maintenanceClient.R().
+			SetResult(result).
+			SetBody(req).
+			Post(path)
+		if err != nil {
+			return err
+		}
+		if r.IsError() {
+			return newClientError(r)
+		}
+		return nil
+	}
+
+	r, err := c.maintenanceClient.R().
+		SetBody(req).
+		SetQueryParam("progress", "true").
+		SetDoNotParseResponse(true).
+		Post(path)
+	if err != nil {
+		return err
+	}
+	body := r.RawBody()
+	defer body.Close()
+	if r.StatusCode() != http.StatusOK {
+		var errResp protocol.ErrorResponse
+		if err := json.NewDecoder(body).Decode(&errResp); err != nil {
+			return fmt.Errorf("unexpected response status %s", r.Status())
+		}
+		return ClientError{msg: errResp.Error}
+	}
+	return readProgressEvents(body, result, onProgress)
+}
+
+// readProgressEvents reads server-sent events of a call with progress, until the "result" or
+// "error" event.
+func readProgressEvents(r io.Reader, result any, onProgress ProgressFunc) error {
+	err := scanEvents(r, func(event, data string) (bool, error) {
+		switch event {
+		case "progress":
+			var p progress.Progress
+			if err := json.Unmarshal([]byte(data), &p); err == nil {
+				onProgress(p)
+			}
+		case "result":
+			if err := json.Unmarshal([]byte(data), result); err != nil {
+				return false, fmt.Errorf("failed to decode result: %w", err)
+			}
+			return true, nil
+		case "error":
+			var errResp protocol.ErrorResponse
+			if err := json.Unmarshal([]byte(data), &errResp); err != nil {
+				return false, fmt.Errorf("failed to decode error: %w", err)
+			}
+			return false, ClientError{msg: errResp.Error}
+		}
+		return false, nil
+	})
+	if err == io.EOF {
+		return fmt.Errorf("daemon closed the stream without a result")
+	}
+	return err
+}
+
+// scanEvents reads server-sent events and passes each of them to fn, until fn returns done or
+// an error. io.EOF is returned if the stream ends before that.
+func scanEvents(r io.Reader, fn func(event, data string) (done bool, err error)) error {
+	scanner := bufio.NewScanner(r)
+	// A result, e.g. the compaction report of all keyspaces, is a single line
+	scanner.Buffer(make([]byte, 64*1024), 64*1024*1024)
+	event := ""
+	for scanner.Scan() {
+		line := scanner.Text()
+		if v, ok := strings.CutPrefix(line, "event:"); ok {
+			event = strings.TrimSpace(v)
+			continue
+		}
+		data, ok := strings.CutPrefix(line, "data:")
+		if !ok {
+			continue
+		}
+		done, err := fn(event, strings.TrimPrefix(data, " "))
+		if done || err != nil {
+			return err
+		}
+	}
+	if err := scanner.Err(); err != nil {
+		return err
+	}
+	return io.EOF
+}
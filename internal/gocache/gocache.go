@@ -0,0 +1,126 @@
+package gocache
+
+import (
+	"encoding/hex"
+	"fmt"
+	"os"
+	"path/filepath"
+	"strconv"
+	"strings"
+	"time"
+)
+
+// This package reads the on-disk cache written by the Go toolchain ($GOCACHE).
+// See: https://github.com/golang/go/blob/go1.24.3/src/cmd/go/internal/cache/cache.go
+//
+// Layout:
+//   <dir>/<xx>/<actionID in hex>-a   Action entry, a single line
+//   <dir>/<xx>/<outputID in hex>-d   Output data
+//
+// Action entry format:
+//   "v1 <actionID hex> <outputID hex> <size %20d> <time unix nano %20d>\n"
+
+const (
+	hashSize  = 32 // sha256
+	entrySize = 2 + 1 + 2*hashSize + 1 + 2*hashSize + 1 + 20 + 1 + 20 + 1
+)
+
+type Entry struct {
+	ActionID []byte
+	OutputID []byte
+	Size     int64
+	Time     time.Time
+}
+
+// ParseActionEntry parses the content of an action entry file ("-a" file).
+func ParseActionEntry(data []byte) (Entry, error) {
+	if len(data) != entrySize {
+		return Entry{}, fmt.Errorf("invalid action entry: expected %d bytes, got %d", entrySize, len(data))
+	}
+	if data[0] != 'v' || data[1] != '1' || data[2] != ' ' || data[entrySize-1] != '\n' {
+		return Entry{}, fmt.Errorf("invalid action entry: unknown format")
+	}
+	fields := strings.Fields(string(data[3 : entrySize-1]))
+	if len(fields) != 4 {
+		return Entry{}, fmt.Errorf("invalid action entry: expected 4 fields, got %d", len(fields))
+	}
+	actionID, err := hex.DecodeString(fields[0])
+	if err != nil || len(actionID) != hashSize {
+		return Entry{}, fmt.Errorf("invalid action entry: bad actionID %q", fields[0])
+	}
+	outputID, err := hex.DecodeString(fields[1])
+	if err != nil || len(outputID) != hashSize {
+		return Entry{}, fmt.Errorf("invalid action entry: bad outputID %q", fields[1])
+	}
+	size, err := strconv.ParseInt(fields[2], 10, 64)
+	if err != nil || size < 0 {
+		return Entry{}, fmt.Errorf("invalid action entry: bad size %q", fields[2])
+	}
+	timeNano, err := strconv.ParseInt(fields[3], 10, 64)
+	if err != nil || timeNano < 0 {
+		return Entry{}, fmt.Errorf("invalid action entry: bad time %q", fields[3])
+	}
+	return Entry{
+		ActionID: actionID,
+		OutputID: outputID,
+		Size:     size,
+		Time:     time.Unix(0, timeNano),
+	}, nil
+}
+
+// FormatActionEntry is the reverse of ParseActionEntry.
+func FormatActionEntry(e Entry) []byte {
+	return []byte(fmt.Sprintf("v1 %x %x %20d %20d\n", e.ActionID, e.OutputID, e.Size, e.Time.UnixNano()))
+}
+
+func ActionPath(dir string, actionID []byte) string {
+	return filepath.Join(dir, fmt.Sprintf("%02x", actionID[0]), fmt.Sprintf("%x-a", actionID))
+}
+
+func OutputPath(dir string, outputID []byte) string {
+	return filepath.Join(dir, fmt.Sprintf("%02x", outputID[0]), fmt.Sprintf("%x-d", outputID))
+}
+
+// Walk calls fn for every valid action entry found in the Go cache dir.
+// Malformed action entries are reported to onInvalid (if set) and skipped.
+func Walk(dir string, fn func(Entry) error, onInvalid func(path string, err error)) error {
+	if info, err := os.Stat(dir); err != nil {
+		return err
+	} else if !info.IsDir() {
+		return fmt.Errorf("%s is not a directory", dir)
+	}
+	for i := 0; i < 256; i++ {
+		subdir := filepath.Join(dir, fmt.Sprintf("%02x", i))
+		files, err := os.ReadDir(subdir)
+		if err != nil {
+			if os.IsNotExist(err) {
+				continue
+			}
+			return err
+		}
+		for _, f := range files {
+			if f.IsDir() || !strings.HasSuffix(f.Name(), "-a") {
+				continue
+			}
+			path := filepath.Join(subdir, f.Name())
+			data, err := os.ReadFile(path)
+			if err == nil {
+				var entry Entry
+				entry, err = ParseActionEntry(data)
+				if err == nil && fmt.Sprintf("%x-a", entry.ActionID) != f.Name() {
+					err = fmt.Errorf("actionID does not match file name")
+				}
+				if err == nil {
+					if err := fn(entry); err != nil {
+						return err
+					}
+					continue
+				}
+			}
+			if onInvalid != nil {
+				onInvalid(path, err)
+			}
+		}
+	}
+	return nil
+}
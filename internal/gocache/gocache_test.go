@@ -0,0 +1,80 @@
+package gocache
+
+import (
+	"bytes"
+	"os"
+	"path/filepath"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/require"
+)
+
+func TestActionEntry_RoundTrip(t *testing.T) {
+	entry := Entry{
+		ActionID: bytes.Repeat([]byte{0xab}, hashSize),
+		OutputID: bytes.Repeat([]byte{0xcd}, hashSize),
+		Size:     12345,
+		Time:     time.Unix(1640995200, 123456789),
+	}
+	data := FormatActionEntry(entry)
+	require.Len(t, data, entrySize)
+
+	parsed, err := ParseActionEntry(data)
+	require.NoError(t, err)
+	require.Equal(t, entry.ActionID, parsed.ActionID)
+	require.Equal(t, entry.OutputID, parsed.OutputID)
+	require.Equal(t, entry.Size, parsed.Size)
+	require.True(t, entry.Time.Equal(parsed.Time))
+}
+
+func TestParseActionEntry_Invalid(t *testing.T) {
+	_, err := ParseActionEntry([]byte("v1 abc\n"))
+	require.Error(t, err)
+
+	valid := FormatActionEntry(Entry{
+		ActionID: bytes.Repeat([]byte{0x01}, hashSize),
+		OutputID: bytes.Repeat([]byte{0x02}, hashSize),
+		Size:     1,
+		Time:     time.Unix(1, 0),
+	})
+	bad := bytes.Clone(valid)
+	bad[1] = '2'
+	_, err = ParseActionEntry(bad)
+	require.Error(t, err)
+
+	bad = bytes.Clone(valid)
+	bad[3] = 'z'
+	_, err = ParseActionEntry(bad)
+	require.Error(t, err)
+}
+
+func TestWalk(t *testing.T) {
+	dir := t.TempDir()
+	good := Entry{
+		ActionID: bytes.Repeat([]byte{0x1f}, hashSize),
+		OutputID: bytes.Repeat([]byte{0x2f}, hashSize),
+		Size:     3,
+		Time:     time.Unix(100, 0),
+	}
+	require.NoError(t, os.MkdirAll(filepath.Dir(ActionPath(dir, good.ActionID)), 0755))
+	require.NoError(t, os.WriteFile(ActionPath(dir, good.ActionID), FormatActionEntry(good), 0644))
+	require.NoError(t, os.MkdirAll(filepath.Dir(OutputPath(dir, good.OutputID)), 0755))
+	require.NoError(t, os.WriteFile(OutputPath(dir, good.OutputID), []byte("abc"), 0644))
+	// Malformed entry and unrelated files
+	require.NoError(t, os.WriteFile(filepath.Join(dir, "1f", "deadbeef-a"), []byte("garbage"), 0644))
+	require.NoError(t, os.WriteFile(filepath.Join(dir, "README"), []byte("hello"), 0644))
+
+	var entries []Entry
+	var invalid []string
+	err := Walk(dir, func(e Entry) error {
+		entries = append(entries, e)
+		return nil
+	}, func(path string, err error) {
+		invalid = append(invalid, filepath.Base(path))
+	})
+	require.NoError(t, err)
+	require.Len(t, entries, 1)
+	require.Equal(t, good.ActionID, entries[0].ActionID)
+	require.Equal(t, []string{"deadbeef-a"}, invalid)
+}
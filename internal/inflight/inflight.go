@@ -0,0 +1,133 @@
+package inflight
+
+import (
+	"slices"
+	"sync"
+	"sync/atomic"
+	"time"
+)
+
+// This package tracks operations currently running in the daemon, so that users can see what
+// a slow build is waiting for, e.g. via `gscache top`. Finished operations are streamed to
+// subscribers, e.g. via GET /events.
+
+// Kinds of operations.
+const (
+	KindGet        = "get"
+	KindPut        = "put"
+	KindUpload     = "upload"
+	KindCompaction = "compaction"
+)
+
+// Op is a running operation.
+type Op struct {
+	Kind      string
+	Detail    string // e.g. the ActionID or the keyspace
+	StartedAt time.Time
+}
+
+// Event is a finished operation, sent to subscribers.
+type Event struct {
+	Op
+	Result   string // e.g. "hit" or "miss" of a get. Empty if not reported
+	Duration time.Duration
+}
+
+// Subscription receives events of operations finished after Subscribe. Events are dropped
+// instead of blocking operations if the subscriber does not keep up.
+type Subscription struct {
+	C       <-chan Event
+	ch      chan Event
+	dropped atomic.Uint64
+}
+
+// Dropped returns the number of events dropped so far.
+func (s *Subscription) Dropped() uint64 {
+	return s.dropped.Load()
+}
+
+type Tracker struct {
+	mu     sync.Mutex
+	nextID uint64
+	ops    map[uint64]Op
+	subs   map[*Subscription]struct{}
+}
+
+func NewTracker() *Tracker {
+	return &Tracker{
+		ops:  make(map[uint64]Op),
+		subs: make(map[*Subscription]struct{}),
+	}
+}
+
+// Default is the tracker of the process.
+var Default = NewTracker()
+
+// Start records a running operation. The returned func must be called when it is finished.
+func (t *Tracker) Start(kind, detail string) (done func()) {
+	finish := t.StartWithResult(kind, detail)
+	return func() { finish("") }
+}
+
+// StartWithResult is like Start, but the result of the operation is reported when it is
+// finished, so that subscribers can see it.
+func (t *Tracker) StartWithResult(kind, detail string) (done func(result string)) {
+	op := Op{Kind: kind, Detail: detail, StartedAt: time.Now()}
+	t.mu.Lock()
+	id := t.nextID
+	t.nextID++
+	t.ops[id] = op
+	t.mu.Unlock()
+
+	var once sync.Once
+	return func(result string) {
+		once.Do(func() {
+			event := Event{Op: op, Result: result, Duration: time.Since(op.StartedAt)}
+			t.mu.Lock()
+			defer t.mu.Unlock()
+			delete(t.ops, id)
+			for sub := range t.subs {
+				select {
+				case sub.ch <- event:
+				default:
+					sub.dropped.Add(1)
+				}
+			}
+		})
+	}
+}
+
+// Subscribe starts receiving events of finished operations, buffering up to buffer events.
+// Unsubscribe must be called when the subscriber is gone.
+func (t *Tracker) Subscribe(buffer int) *Subscription {
+	ch := make(chan Event, buffer)
+	sub := &Subscription{C: ch, ch: ch}
+	t.mu.Lock()
+	t.subs[sub] = struct{}{}
+	t.mu.Unlock()
+	return sub
+}
+
+// Unsubscribe stops sending events to the subscription. Its channel is not closed.
+func (t *Tracker) Unsubscribe(sub *Subscription) {
+	t.mu.Lock()
+	delete(t.subs, sub)
+	t.mu.Unlock()
+}
+
+// Snapshot returns running operations, the longest running first.
+func (t *Tracker) Snapshot() []Op {
+	t.mu.Lock()
+	defer t.mu.Unlock()
+	// IDs are assigned in the order of start
+	ids := make([]uint64, 0, len(t.ops))
+	for id := range t.ops {
+		ids = append(ids, id)
+	}
+	slices.Sort(ids)
+	ops := make([]Op, 0, len(ids))
+	for _, id := range ids {
+		ops = append(ops, t.ops[id])
+	}
+	return ops
+}
@@ -0,0 +1,58 @@
+package inflight
+
+import (
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/require"
+)
+
+func TestTracker(t *testing.T) {
+	tracker := NewTracker()
+	require.Empty(t, tracker.Snapshot())
+
+	doneGet := tracker.Start(KindGet, "aa")
+	doneUpload := tracker.Start(KindUpload, "bb")
+	ops := tracker.Snapshot()
+	require.Len(t, ops, 2)
+	require.Equal(t, KindGet, ops[0].Kind)
+	require.Equal(t, "aa", ops[0].Detail)
+	require.Equal(t, KindUpload, ops[1].Kind)
+
+	doneGet()
+	doneGet() // No-op
+	ops = tracker.Snapshot()
+	require.Len(t, ops, 1)
+	require.Equal(t, "bb", ops[0].Detail)
+
+	doneUpload()
+	require.Empty(t, tracker.Snapshot())
+}
+
+func TestSubscribe(t *testing.T) {
+	tracker := NewTracker()
+	sub := tracker.Subscribe(1)
+
+	done := tracker.StartWithResult(KindGet, "aa")
+	require.Empty(t, sub.C)
+	done("hit")
+	done("miss") // No-op
+	event := <-sub.C
+	require.Equal(t, KindGet, event.Kind)
+	require.Equal(t, "aa", event.Detail)
+	require.Equal(t, "hit", event.Result)
+	require.GreaterOrEqual(t, event.Duration, time.Duration(0))
+
+	// Dropped when the buffer is full
+	tracker.Start(KindUpload, "bb")()
+	tracker.Start(KindUpload, "cc")()
+	require.Equal(t, uint64(1), sub.Dropped())
+	event = <-sub.C
+	require.Equal(t, "bb", event.Detail)
+	require.Empty(t, event.Result)
+
+	tracker.Unsubscribe(sub)
+	tracker.Start(KindPut, "dd")()
+	require.Empty(t, sub.C)
+	require.Equal(t, uint64(1), sub.Dropped())
+}
@@ -19,13 +19,22 @@ func DefaultConfig(workDir string) Config {
 	}
 }
 
+// jsonLevel is the level of JSON logging, which can be changed at runtime via SetLevel.
+var jsonLevel = zap.NewAtomicLevel()
+
 func SetupJSONLogging(cfg Config) error {
+	return SetupJSONLoggingTo(cfg, "stderr")
+}
+
+// SetupJSONLoggingTo is the same as SetupJSONLogging, but writes to the output path,
+// which can be "stdout", "stderr" or a file.
+func SetupJSONLoggingTo(cfg Config, outputPath string) error {
 	zapConfig := zap.NewProductionConfig()
-	parsedLevel, err := zapcore.ParseLevel(cfg.Level)
-	if err != nil {
+	zapConfig.OutputPaths = []string{outputPath}
+	if err := SetLevel(cfg.Level); err != nil {
 		return err
 	}
-	zapConfig.Level = zap.NewAtomicLevelAt(parsedLevel)
+	zapConfig.Level = jsonLevel
 	zapConfig.Encoding = "json"
 	l, err := zapConfig.Build()
 	if err != nil {
@@ -34,3 +43,14 @@ func SetupJSONLogging(cfg Config) error {
 	logger = l
 	return nil
 }
+
+// SetLevel changes the level of JSON logging without rebuilding the logger, e.g. when the
+// config is reloaded. It does not affect readable logging.
+func SetLevel(level string) error {
+	parsedLevel, err := zapcore.ParseLevel(level)
+	if err != nil {
+		return err
+	}
+	jsonLevel.SetLevel(parsedLevel)
+	return nil
+}
@@ -0,0 +1,67 @@
+package log
+
+import (
+	"encoding/json"
+	"regexp"
+	"strings"
+	"time"
+
+	"go.uber.org/zap/zapcore"
+)
+
+// Filter selects JSON log lines written by SetupJSONLogging. A zero Filter matches everything.
+// Lines which are not JSON log lines (e.g. a panic trace) only match when no field-based
+// filters (level, since, module) are set.
+type Filter struct {
+	// Optional. Only lines at this level or above are matched.
+	MinLevel *zapcore.Level
+	// Optional. Only lines matching this regexp are matched, tested against the raw line.
+	Grep *regexp.Regexp
+	// Optional. Only lines logged at or after this time are matched.
+	Since time.Time
+	// Optional. Only lines of this logger or its children are matched, e.g. "cache" matches
+	// both "cache.blob" and "cache.local".
+	Module string
+}
+
+// jsonLine is the subset of fields of a JSON log line which filters look at.
+type jsonLine struct {
+	Level  string   `json:"level"`
+	Ts     *float64 `json:"ts"`
+	Logger string   `json:"logger"`
+}
+
+func (f Filter) hasFieldFilters() bool {
+	return f.MinLevel != nil || !f.Since.IsZero() || f.Module != ""
+}
+
+// Match returns whether the line passes the filter.
+func (f Filter) Match(line []byte) bool {
+	if f.Grep != nil && !f.Grep.Match(line) {
+		return false
+	}
+	if !f.hasFieldFilters() {
+		return true
+	}
+	var l jsonLine
+	if err := json.Unmarshal(line, &l); err != nil || l.Ts == nil {
+		return false
+	}
+	if f.MinLevel != nil {
+		level, err := zapcore.ParseLevel(l.Level)
+		if err != nil || level < *f.MinLevel {
+			return false
+		}
+	}
+	if !f.Since.IsZero() {
+		sec := int64(*l.Ts)
+		nsec := int64((*l.Ts - float64(sec)) * 1e9)
+		if time.Unix(sec, nsec).Before(f.Since) {
+			return false
+		}
+	}
+	if f.Module != "" && l.Logger != f.Module && !strings.HasPrefix(l.Logger, f.Module+".") {
+		return false
+	}
+	return true
+}
@@ -0,0 +1,49 @@
+package log
+
+import (
+	"fmt"
+	"regexp"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/require"
+	"go.uber.org/zap/zapcore"
+)
+
+func TestFilter_Match(t *testing.T) {
+	now := time.Now()
+	line := func(level string, age time.Duration, logger string, msg string) []byte {
+		return []byte(fmt.Sprintf(`{"level":%q,"ts":%f,"logger":%q,"msg":%q}`,
+			level, float64(now.Add(-age).UnixNano())/1e9, logger, msg))
+	}
+	warn := zapcore.WarnLevel
+
+	require.True(t, Filter{}.Match([]byte("panic: not json")))
+	require.True(t, Filter{}.Match(line("debug", 0, "", "hello")))
+
+	f := Filter{MinLevel: &warn}
+	require.False(t, f.Match(line("info", 0, "", "hello")))
+	require.True(t, f.Match(line("warn", 0, "", "hello")))
+	require.True(t, f.Match(line("error", 0, "", "hello")))
+	require.False(t, f.Match([]byte("panic: not json")))
+
+	f = Filter{Grep: regexp.MustCompile(`Slow (Get|Put)`)}
+	require.True(t, f.Match(line("info", 0, "", "Slow Get")))
+	require.False(t, f.Match(line("info", 0, "", "Server is started")))
+	require.True(t, f.Match([]byte("Slow Put: not json")))
+
+	f = Filter{Since: now.Add(-time.Minute)}
+	require.True(t, f.Match(line("info", 10*time.Second, "", "hello")))
+	require.False(t, f.Match(line("info", time.Hour, "", "hello")))
+
+	f = Filter{Module: "cache"}
+	require.True(t, f.Match(line("info", 0, "cache", "hello")))
+	require.True(t, f.Match(line("info", 0, "cache.blob", "hello")))
+	require.False(t, f.Match(line("info", 0, "cachex", "hello")))
+	require.False(t, f.Match(line("info", 0, "", "hello")))
+
+	f = Filter{MinLevel: &warn, Module: "cache.blob", Grep: regexp.MustCompile("upload")}
+	require.True(t, f.Match(line("error", 0, "cache.blob", "upload failed")))
+	require.False(t, f.Match(line("error", 0, "cache.blob", "download failed")))
+	require.False(t, f.Match(line("info", 0, "cache.blob", "upload failed")))
+}
@@ -0,0 +1,128 @@
+package log
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"io"
+	"os"
+	"time"
+)
+
+// tailWindow is how many bytes at the end of the log file are scanned for recent lines.
+const tailWindow = 1024 * 1024
+
+// TailLines returns the last n lines of the log file, oldest first. If maxAge > 0, it stops at
+// the first JSON log line whose timestamp is older than maxAge, so that lines from a previous
+// run are not shown. The returned offset points right after the last complete line, which can
+// be passed to FollowFile to continue reading subsequent lines.
+func TailLines(logFile string, n int, maxAge time.Duration) (lines []string, offset int64, err error) {
+	f, err := os.Open(logFile)
+	if err != nil {
+		return nil, 0, err
+	}
+	defer f.Close()
+
+	start, err := f.Seek(-tailWindow, io.SeekEnd)
+	if err != nil {
+		// File is smaller than the window
+		start = 0
+		if _, err := f.Seek(0, io.SeekStart); err != nil {
+			return nil, 0, err
+		}
+	}
+	buf, err := io.ReadAll(f)
+	if err != nil {
+		return nil, 0, err
+	}
+
+	// An incomplete last line is left to FollowFile
+	complete := bytes.LastIndexByte(buf, '\n') + 1
+	offset = start + int64(complete)
+	buf = buf[:complete]
+	if start > 0 {
+		// The first line is likely cut by the window
+		if idx := bytes.IndexByte(buf, '\n'); idx >= 0 {
+			buf = buf[idx+1:]
+		}
+	}
+
+	all := bytes.Split(buf, []byte{'\n'})
+	for i := len(all) - 1; i >= 0 && len(lines) < n; i-- {
+		line := all[i]
+		if len(line) == 0 {
+			continue
+		}
+		// Try to parse the line as JSON, and stop if it is a valid log line with too old timestamp.
+		if maxAge > 0 {
+			var logEntry map[string]interface{}
+			if err := json.Unmarshal(line, &logEntry); err == nil {
+				if ts, ok := logEntry["ts"].(float64); ok {
+					if time.Since(time.Unix(int64(ts), 0)) > maxAge {
+						break
+					}
+				}
+			}
+		}
+		lines = append(lines, string(line))
+	}
+	for i, j := 0, len(lines)-1; i < j; i, j = i+1, j-1 {
+		lines[i], lines[j] = lines[j], lines[i]
+	}
+	return lines, offset, nil
+}
+
+// FollowFile polls the log file for content appended after offset, and calls onLine for each
+// complete line (without the trailing newline) until ctx is done or onLine returns an error.
+// If the file is truncated, it is read again from the beginning.
+func FollowFile(ctx context.Context, logFile string, offset int64, pollInterval time.Duration, onLine func(line []byte) error) error {
+	f, err := os.Open(logFile)
+	if err != nil {
+		return err
+	}
+	defer f.Close()
+
+	var pending []byte
+	readBuf := make([]byte, 32*1024)
+	ticker := time.NewTicker(pollInterval)
+	defer ticker.Stop()
+	for {
+		info, err := f.Stat()
+		if err != nil {
+			return err
+		}
+		if info.Size() < offset {
+			offset = 0
+			pending = pending[:0]
+		}
+		for offset < info.Size() {
+			n, err := f.ReadAt(readBuf, offset)
+			offset += int64(n)
+			pending = append(pending, readBuf[:n]...)
+			for {
+				idx := bytes.IndexByte(pending, '\n')
+				if idx < 0 {
+					break
+				}
+				if idx > 0 {
+					if err := onLine(pending[:idx]); err != nil {
+						return err
+					}
+				}
+				pending = pending[idx+1:]
+			}
+			if err != nil {
+				if err == io.EOF {
+					break
+				}
+				return err
+			}
+		}
+
+		select {
+		case <-ctx.Done():
+			return nil
+		case <-ticker.C:
+		}
+	}
+}
@@ -0,0 +1,75 @@
+package log
+
+import (
+	"context"
+	"fmt"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/require"
+)
+
+func TestTailLines(t *testing.T) {
+	logFile := filepath.Join(t.TempDir(), "gscache.log")
+	now := time.Now().Unix()
+	content := fmt.Sprintf(`{"ts":%d,"msg":"old"}`+"\n", now-3600) +
+		fmt.Sprintf(`{"ts":%d,"msg":"a"}`+"\n", now) +
+		"not json\n" +
+		fmt.Sprintf(`{"ts":%d,"msg":"b"}`+"\n", now) +
+		`{"ts":` // Incomplete line being written
+	require.NoError(t, os.WriteFile(logFile, []byte(content), 0644))
+
+	lines, offset, err := TailLines(logFile, 2, 0)
+	require.NoError(t, err)
+	require.Len(t, lines, 2)
+	require.Equal(t, "not json", lines[0])
+	require.Contains(t, lines[1], `"b"`)
+	require.Equal(t, int64(strings.LastIndex(content, "\n")+1), offset)
+
+	lines, _, err = TailLines(logFile, 10, 0)
+	require.NoError(t, err)
+	require.Len(t, lines, 4)
+
+	// Stops at old lines
+	lines, _, err = TailLines(logFile, 10, time.Minute)
+	require.NoError(t, err)
+	require.Len(t, lines, 3)
+	require.Contains(t, lines[0], `"a"`)
+
+	_, _, err = TailLines(filepath.Join(t.TempDir(), "missing.log"), 10, 0)
+	require.True(t, os.IsNotExist(err))
+}
+
+func TestFollowFile(t *testing.T) {
+	logFile := filepath.Join(t.TempDir(), "gscache.log")
+	require.NoError(t, os.WriteFile(logFile, []byte("skipped\npart"), 0644))
+
+	ctx, cancel := context.WithCancel(context.Background())
+	defer cancel()
+	linesCh := make(chan string, 10)
+	done := make(chan error, 1)
+	go func() {
+		done <- FollowFile(ctx, logFile, int64(len("skipped\n")), 10*time.Millisecond, func(line []byte) error {
+			linesCh <- string(line)
+			return nil
+		})
+	}()
+
+	f, err := os.OpenFile(logFile, os.O_APPEND|os.O_WRONLY, 0644)
+	require.NoError(t, err)
+	_, err = f.WriteString("ial\nnext\n")
+	require.NoError(t, err)
+	require.NoError(t, f.Close())
+	require.Equal(t, "partial", <-linesCh)
+	require.Equal(t, "next", <-linesCh)
+
+	// Truncated file is read from the beginning
+	require.NoError(t, os.WriteFile(logFile, []byte("new\n"), 0644))
+	require.Equal(t, "new", <-linesCh)
+
+	cancel()
+	require.NoError(t, <-done)
+}
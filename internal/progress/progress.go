@@ -0,0 +1,93 @@
+package progress
+
+import (
+	"context"
+	"sync"
+)
+
+// This package tracks the progress of a long running operation, e.g. warm, compaction or
+// export, so that the CLI can show it instead of waiting silently. The operation updates a
+// Tracker found in its context, and observers poll snapshots of it, e.g. the daemon sends
+// them to the client as server-sent events.
+
+// Progress is a snapshot of a Tracker.
+type Progress struct {
+	Stage      string // What is being done, e.g. "archives"
+	Done       int64
+	Total      int64 `json:",omitempty"` // 0 if unknown
+	Bytes      int64 `json:",omitempty"`
+	TotalBytes int64 `json:",omitempty"` // 0 if unknown
+}
+
+// Tracker is the progress of an operation. Methods of a nil Tracker are no-ops, so that
+// operations can report progress regardless of whether anyone observes it.
+type Tracker struct {
+	mu      sync.Mutex
+	p       Progress
+	version uint64 // Increased by every update
+}
+
+func NewTracker() *Tracker {
+	return &Tracker{}
+}
+
+// SetStage starts a new stage, and resets counters.
+func (t *Tracker) SetStage(stage string, total, totalBytes int64) {
+	if t == nil {
+		return
+	}
+	t.mu.Lock()
+	defer t.mu.Unlock()
+	t.p = Progress{Stage: stage, Total: total, TotalBytes: totalBytes}
+	t.version++
+}
+
+// Add counts finished items and bytes of the current stage.
+func (t *Tracker) Add(n, bytes int64) {
+	if t == nil {
+		return
+	}
+	t.mu.Lock()
+	defer t.mu.Unlock()
+	t.p.Done += n
+	t.p.Bytes += bytes
+	t.version++
+}
+
+// AddTotal increases totals of the current stage, e.g. when more items are discovered.
+func (t *Tracker) AddTotal(n, bytes int64) {
+	if t == nil {
+		return
+	}
+	t.mu.Lock()
+	defer t.mu.Unlock()
+	t.p.Total += n
+	t.p.TotalBytes += bytes
+	t.version++
+}
+
+// Snapshot returns the current progress, and a version which changes on every update.
+func (t *Tracker) Snapshot() (Progress, uint64) {
+	if t == nil {
+		return Progress{}, 0
+	}
+	t.mu.Lock()
+	defer t.mu.Unlock()
+	return t.p, t.version
+}
+
+type contextKey struct{}
+
+// NewContext returns a context carrying the tracker.
+func NewContext(ctx context.Context, t *Tracker) context.Context {
+	return context.WithValue(ctx, contextKey{}, t)
+}
+
+// FromContext returns the tracker in the context, or nil.
+func FromContext(ctx context.Context) *Tracker {
+	if ctx == nil {
+		return nil
+	}
+	t, _ := ctx.Value(contextKey{}).(*Tracker)
+	return t
+}
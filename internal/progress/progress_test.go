@@ -0,0 +1,48 @@
+package progress
+
+import (
+	"context"
+	"testing"
+
+	"github.com/stretchr/testify/require"
+)
+
+func TestTracker(t *testing.T) {
+	tracker := NewTracker()
+	p, v0 := tracker.Snapshot()
+	require.Equal(t, Progress{}, p)
+
+	tracker.SetStage("archives", 16, 0)
+	tracker.Add(1, 100)
+	tracker.Add(2, 50)
+	p, v1 := tracker.Snapshot()
+	require.Equal(t, Progress{Stage: "archives", Done: 3, Total: 16, Bytes: 150}, p)
+	require.Greater(t, v1, v0)
+
+	p, v2 := tracker.Snapshot()
+	require.Equal(t, v1, v2)
+
+	tracker.SetStage("blobs", 0, 0)
+	tracker.AddTotal(5, 1000)
+	tracker.Add(1, 200)
+	p, _ = tracker.Snapshot()
+	require.Equal(t, Progress{Stage: "blobs", Done: 1, Total: 5, Bytes: 200, TotalBytes: 1000}, p)
+}
+
+func TestTracker_Nil(t *testing.T) {
+	var tracker *Tracker
+	tracker.SetStage("x", 1, 0)
+	tracker.Add(1, 1)
+	tracker.AddTotal(1, 1)
+	p, v := tracker.Snapshot()
+	require.Equal(t, Progress{}, p)
+	require.Zero(t, v)
+}
+
+func TestContext(t *testing.T) {
+	require.Nil(t, FromContext(context.Background()))
+	tracker := NewTracker()
+	ctx := NewContext(context.Background(), tracker)
+	require.Same(t, tracker, FromContext(ctx))
+	FromContext(context.Background()).Add(1, 1) // No-op
+}
@@ -1,7 +1,10 @@
 package protocol
 
 import (
+	"bufio"
+	"encoding/base64"
 	"fmt"
+	"io"
 	"time"
 
 	"go.uber.org/zap/zapcore"
@@ -13,20 +16,260 @@ type PingResponse struct {
 	Status string
 	Pid    int
 	Config any
+	// True if the last compaction runs all failed, see BackendStatus.CompactionUnhealthy.
+	CompactionUnhealthy bool `json:",omitempty"`
 }
 
 type ShutdownResponse struct {
 }
 
+type StatusResponse struct {
+	Pid        int
+	StartedAt  time.Time
+	Uptime     string
+	Backend    string // Name of the backend, e.g. "blob"
+	Healthy    bool
+	Problems   []string       `json:",omitempty"` // Why the daemon is not healthy
+	Status     *BackendStatus `json:",omitempty"` // Only available when the backend supports it
+	Dir        string
+	LocalBytes int64
+}
+
+// HealthResponse is the response of /healthz and /readyz, whose status code is 503 if not OK.
+type HealthResponse struct {
+	OK     bool
+	Checks []HealthCheck
+}
+
+type HealthCheck struct {
+	Name        string // e.g. "disk" or "remote"
+	OK          bool
+	Error       string     `json:",omitempty"`
+	RespondedAt *time.Time `json:",omitempty"` // When the remote last responded
+}
+
 type StatsClearResponse struct {
 }
 
+type StatsResponse struct {
+	Pid       int
+	StartedAt time.Time
+	Uptime    string
+	Stats     any
+	Backend   *BackendStatus `json:",omitempty"` // Only available when the backend supports it
+}
+
+type InFlightResponse struct {
+	Ops []InFlightOp
+	// Uploads waiting for a free upload worker, which are not in Ops yet.
+	UploadQueueWaiting uint64 `json:",omitempty"`
+}
+
+// InFlightOp is an operation running in the daemon, see inflight.Op.
+type InFlightOp struct {
+	Kind      string
+	Detail    string
+	StartedAt time.Time
+	Elapsed   time.Duration
+}
+
+// ActivityEvent is an operation finished in the daemon, streamed by GET /events as an event
+// named by its Kind.
+type ActivityEvent struct {
+	Kind      string // See inflight kinds, e.g. "get" or "upload"
+	Detail    string
+	Result    string `json:",omitempty"` // e.g. "hit", "miss", "stored" or "error"
+	StartedAt time.Time
+	Duration  time.Duration
+}
+
+// EventsDropped is streamed by GET /events when activity events are dropped, because the
+// subscriber does not read fast enough.
+type EventsDropped struct {
+	Count uint64 // Dropped since the last EventsDropped
+}
+
+type BackendStatus struct {
+	UploadQueueRunning int64
+	UploadQueueWaiting uint64
+	LastCompactionAt   *time.Time `json:",omitempty"`
+	// Number of compaction runs failed in a row, reset by a successful run.
+	CompactionConsecutiveFailures int32
+	// True if CompactionConsecutiveFailures reached the alert threshold.
+	CompactionUnhealthy bool
+	LastCompaction      *CompactionSummary `json:",omitempty"`
+	Archives            []ArchiveStatus
+}
+
+// CompactionSummary sums up results of keyspaces in a compaction run.
+type CompactionSummary struct {
+	StartedAt    time.Time
+	Duration     string
+	Keyspaces    int
+	Compacted    int // Keyspaces whose archive is rebuilt
+	Skipped      int
+	Failed       int
+	AddedBlobs   int
+	AddedBytes   int64
+	RemovedBlobs int
+	Error        string `json:",omitempty"` // First error of failed keyspaces
+}
+
+type ArchiveStatus struct {
+	Keyspace   string
+	Entries    int
+	SizeBytes  int64
+	LastSyncAt *time.Time `json:",omitempty"`
+}
+
+// GCRequest trims the local store. An empty request sent to the daemon trims by gc.max_age and
+// gc.max_bytes of the daemon config.
+type GCRequest struct {
+	MaxAge   time.Duration // Entries not used within this duration are removed. 0 to disable.
+	MaxBytes int64         // Least recently used entries are removed until outputs take at most this size. 0 to disable.
+}
+
+type GCResponse struct {
+	RemovedEntries   int
+	RemovedBytes     int64
+	RemainingEntries int
+	RemainingBytes   int64
+}
+
+type SizeResponse struct {
+	Dir        string
+	Local      []SizeItem // Sorted by name
+	LocalBytes int64
+	Remote     *RemoteSize `json:",omitempty"` // Only when requested
+}
+
+// SizeItem is the usage of a group of files or objects.
+type SizeItem struct {
+	Name  string
+	Files int64
+	Bytes int64
+}
+
+type RemoteSize struct {
+	URL        string
+	Keyspaces  []KeyspaceSize // Standalone blobs and archives of the default namespace
+	Namespaces []SizeItem     // Standalone blobs of other namespaces, which are never compacted
+	Other      SizeItem       // Objects not written by gscache, e.g. probe objects
+	Bytes      int64
+}
+
+type KeyspaceSize struct {
+	Keyspace     string
+	Blobs        int64
+	BlobBytes    int64
+	ArchiveBytes int64
+}
+
+type WarmRequest struct {
+	// If > 0, up to this many most recently uploaded standalone objects which are not in archives
+	// are also downloaded for each keyspace.
+	Blobs int
+}
+
+type WarmResponse struct {
+	ArchivesLoaded  int // Keyspaces having an archive after warming.
+	ArchiveEntries  int
+	BlobsDownloaded int
+	BlobsBytes      int64
+}
+
+type PrefetchRequest struct {
+	// Entries whose actionID starts with one of the prefixes are downloaded, e.g. actionIDs
+	// truncated in build IDs reported by `go list -export`.
+	ActionIDPrefixes [][]byte
+	// Namespace of the entries. Empty means the default namespace.
+	Namespace string `json:",omitempty"`
+}
+
+func (r *PrefetchRequest) Validate() error {
+	for _, prefix := range r.ActionIDPrefixes {
+		if len(prefix) == 0 {
+			return fmt.Errorf("actionID prefix must not be empty")
+		}
+	}
+	return ValidateNamespace(r.Namespace)
+}
+
+type PrefetchResponse struct {
+	Prefixes        int
+	Matched         int // Entries whose actionID matches a prefix.
+	InArchive       int // Matched entries in local archives, which are served without downloading.
+	AlreadyLocal    int // Matched entries already in the local store.
+	Downloaded      int
+	DownloadedBytes int64
+}
+
+type CompactRequest struct {
+	Keyspaces []string // Keyspaces to compact. If empty, all keyspaces managed by the daemon.
+	// If true, existing archives are ignored and rebuilt from all current small blobs.
+	Rebuild bool
+	// If true, only report what would be compacted, without downloading blobs or uploading archives.
+	DryRun bool
+}
+
+type CompactResponse struct {
+	Keyspaces []KeyspaceCompaction
+}
+
+type KeyspaceCompaction struct {
+	Keyspace   string
+	Skipped    bool   // In a dry run, whether compaction would be skipped.
+	SkipReason string `json:",omitempty"`
+	Blobs      int    // Small blobs in the keyspace, i.e. entries of the new archive.
+	AddedBlobs int    // Small blobs not in the existing archive.
+	AddedBytes int64
+	// Entries of the existing archive whose small blob no longer exists in the remote.
+	RemovedBlobs int
+	Error        string `json:",omitempty"`
+}
+
 type ErrorResponse struct {
 	Error string
 }
 
+// MaxNamespaceLength is the max length of a cache namespace.
+const MaxNamespaceLength = 64
+
+// ValidateNamespace checks whether the namespace can be safely used as a part of
+// object keys and file paths. Empty namespace is valid and means the default namespace.
+func ValidateNamespace(namespace string) error {
+	if len(namespace) > MaxNamespaceLength {
+		return fmt.Errorf("namespace %q is too long, max %d chars", namespace, MaxNamespaceLength)
+	}
+	for _, c := range namespace {
+		if (c >= 'a' && c <= 'z') || (c >= 'A' && c <= 'Z') || (c >= '0' && c <= '9') ||
+			c == '.' || c == '_' || c == '-' {
+			continue
+		}
+		return fmt.Errorf("namespace %q contains invalid char %q", namespace, c)
+	}
+	if namespace == "." || namespace == ".." {
+		return fmt.Errorf("namespace %q is not allowed", namespace)
+	}
+	return nil
+}
+
 type GetRequest struct {
 	ActionID []byte `json:",omitempty"` // or nil if not used
+	// Namespace isolates cache entries, e.g. by Go version and platform.
+	// Empty means the default namespace.
+	Namespace string `json:",omitempty"`
+	// If true, the response of a hit is the GetResponse JSON without DiskPath, followed by a
+	// newline and Size bytes of the body, for clients not on the same machine as the daemon,
+	// where DiskPath is not accessible.
+	StreamBody bool `json:",omitempty"`
+}
+
+func (r *GetRequest) Validate() error {
+	if len(r.ActionID) == 0 {
+		return fmt.Errorf("actionID must be specified")
+	}
+	return ValidateNamespace(r.Namespace)
 }
 
 func (r *GetRequest) MarshalLogObject(enc zapcore.ObjectEncoder) error {
@@ -35,6 +278,9 @@ func (r *GetRequest) MarshalLogObject(enc zapcore.ObjectEncoder) error {
 		return nil
 	}
 	enc.AddString("actionID", fmt.Sprintf("%x", r.ActionID))
+	if r.Namespace != "" {
+		enc.AddString("namespace", r.Namespace)
+	}
 	return nil
 }
 
@@ -46,6 +292,9 @@ type GetResponse struct {
 	// DiskPath is the absolute path on disk of the body corresponding to a
 	// "get" (on cache hit) or "put" request's ActionID.
 	DiskPath string `json:",omitempty"`
+	// Where a hit is served from, e.g. "local", "archive" or "download". Informational only,
+	// empty if the backend does not report it.
+	ServedFrom string `json:",omitempty"`
 }
 
 func (r *GetResponse) MarshalLogObject(enc zapcore.ObjectEncoder) error {
@@ -67,6 +316,25 @@ type PutRequest struct {
 	OutputID []byte `json:",omitempty"` // or nil if not used
 	// BodySize is the number of bytes of Body. If zero, the body isn't written.
 	BodySize int64 `json:",omitempty"`
+	// Namespace isolates cache entries, e.g. by Go version and platform.
+	// Empty means the default namespace.
+	Namespace string `json:",omitempty"`
+	// IfAbsent makes the Put a no-op when the ActionID already exists (locally or remotely).
+	// The body is still consumed and the DiskPath of the existing entry is returned.
+	IfAbsent bool `json:",omitempty"`
+}
+
+func (r *PutRequest) Validate() error {
+	if len(r.ActionID) == 0 {
+		return fmt.Errorf("actionID must be specified")
+	}
+	if r.BodySize < 0 {
+		return fmt.Errorf("invalid body size %d", r.BodySize)
+	}
+	if r.BodySize > 0 && len(r.OutputID) == 0 {
+		return fmt.Errorf("outputID must be specified when body size is %d", r.BodySize)
+	}
+	return ValidateNamespace(r.Namespace)
 }
 
 func (r *PutRequest) MarshalLogObject(enc zapcore.ObjectEncoder) error {
@@ -77,13 +345,160 @@ func (r *PutRequest) MarshalLogObject(enc zapcore.ObjectEncoder) error {
 	enc.AddString("actionID", fmt.Sprintf("%x", r.ActionID))
 	enc.AddString("outputID", fmt.Sprintf("%x", r.OutputID))
 	enc.AddInt64("size", r.BodySize)
+	if r.Namespace != "" {
+		enc.AddString("namespace", r.Namespace)
+	}
+	if r.IfAbsent {
+		enc.AddBool("ifAbsent", r.IfAbsent)
+	}
 	return nil
 }
 
+// quoteCloseReader emits EOF when meets a quote and swallows the quote.
+// It is used to streamingly read the cache body with a Base64 decoder
+// which is like:
+// "<BASE64_ENCODED_DATA>"
+type quoteCloseReader struct {
+	wrapped io.Reader
+	closed  bool
+}
+
+func (r *quoteCloseReader) Read(p []byte) (int, error) {
+	if r.closed {
+		return 0, io.EOF
+	}
+	n, err := r.wrapped.Read(p)
+	if n > 0 {
+		for i := 0; i < n; i++ {
+			if p[i] == '"' {
+				r.closed = true
+				return i, err
+			}
+		}
+	}
+	return n, err
+}
+
+// DecodePutBody returns a reader of the Put body encoded as a base64 JSON string, which follows
+// the JSON of a Put request, or a cacheprog put request. Leading whitespaces are skipped.
+func DecodePutBody(r io.Reader) (io.Reader, error) {
+	reader := bufio.NewReader(r)
+
+	// Skip whitespaces between the JSON and the body, then the first byte must be a quote (").
+	var firstByte byte
+	for {
+		b, err := reader.ReadByte()
+		if err != nil {
+			return nil, fmt.Errorf("failed to read Put body: %v", err)
+		}
+		if b == ' ' || b == '\t' || b == '\r' || b == '\n' {
+			continue
+		}
+		firstByte = b
+		break
+	}
+	if firstByte != '"' {
+		return nil, fmt.Errorf("unexpected Put body first byte: %q", firstByte)
+	}
+	// Last byte must be a quote (").
+	return base64.NewDecoder(base64.StdEncoding, &quoteCloseReader{wrapped: reader}), nil
+}
+
+// MaxExistsBatchSize is the max number of ActionIDs in a single ExistsBatch request.
+const MaxExistsBatchSize = 10000
+
+type ExistsBatchRequest struct {
+	ActionIDs [][]byte
+	// Namespace isolates cache entries, e.g. by Go version and platform.
+	// Empty means the default namespace.
+	Namespace string `json:",omitempty"`
+}
+
+func (r *ExistsBatchRequest) Validate() error {
+	if len(r.ActionIDs) > MaxExistsBatchSize {
+		return fmt.Errorf("too many actionIDs %d, max %d", len(r.ActionIDs), MaxExistsBatchSize)
+	}
+	for i, actionID := range r.ActionIDs {
+		if len(actionID) == 0 {
+			return fmt.Errorf("actionID #%d must be specified", i)
+		}
+	}
+	return ValidateNamespace(r.Namespace)
+}
+
+type ExistsBatchResponse struct {
+	// Exists[i] tells whether ActionIDs[i] is in the cache, either locally or remotely.
+	Exists []bool
+}
+
+type DeleteRequest struct {
+	ActionID []byte `json:",omitempty"`
+	// Lowercase hex prefix of actionIDs, e.g. a keyspace like "a", to delete all matching entries
+	// at once. Exactly one of ActionID and Prefix must be specified.
+	Prefix string `json:",omitempty"`
+	// Namespace isolates cache entries, e.g. by Go version and platform.
+	// Empty means the default namespace.
+	Namespace string `json:",omitempty"`
+	// If true, the entry is also purged from the archive of its keyspace: it is no longer
+	// served from the archive, and the next compaction of the keyspace rebuilds the archive
+	// without it, even if there are few new blobs.
+	Purge bool `json:",omitempty"`
+}
+
+func (r *DeleteRequest) Validate() error {
+	if len(r.ActionID) == 0 && r.Prefix == "" {
+		return fmt.Errorf("actionID or prefix must be specified")
+	}
+	if len(r.ActionID) > 0 && r.Prefix != "" {
+		return fmt.Errorf("actionID and prefix cannot be both specified")
+	}
+	if r.Prefix != "" {
+		for _, ch := range r.Prefix {
+			if (ch < '0' || ch > '9') && (ch < 'a' || ch > 'f') {
+				return fmt.Errorf("invalid prefix %q, must be lowercase hex", r.Prefix)
+			}
+		}
+	}
+	return ValidateNamespace(r.Namespace)
+}
+
+type DeleteResponse struct {
+	DeletedLocal  bool // Whether the entry existed in the local store and is deleted.
+	DeletedRemote bool // Whether the entry existed as a remote object and is deleted.
+	// The entry is still in an archive, so that it can be served until the archive is compacted
+	// again, which drops entries whose remote objects are deleted.
+	InArchive bool `json:",omitempty"`
+	// Whether the removal from the archive is scheduled, see DeleteRequest.Purge.
+	PurgeScheduled bool `json:",omitempty"`
+
+	// Counts of a prefix delete. Flags above are set if any matching entry is found.
+	LocalEntries   int `json:",omitempty"` // How many entries are deleted from the local store.
+	RemoteEntries  int `json:",omitempty"` // How many remote objects are deleted.
+	ArchiveEntries int `json:",omitempty"` // How many entries are in the local copy of archives.
+}
+
+// ConfigChange is a config key whose value in the config file differs from the running server.
+type ConfigChange struct {
+	Key string // Like "log.level"
+	Old string
+	New string
+	// The new value is not applied until the server is restarted, so that the change is still
+	// reported by later reloads.
+	RequiresRestart bool `json:",omitempty"`
+}
+
+type ConfigReloadResponse struct {
+	Changes []ConfigChange
+}
+
 type PutResponse struct {
 	// DiskPath is the absolute path on disk of the body corresponding to a
 	// "get" (on cache hit) or "put" request's ActionID.
 	DiskPath string `json:",omitempty"`
+	// Existing is set when an IfAbsent put is skipped because the entry already exists. The body
+	// is discarded, and DiskPath is the body of the existing entry, which may have a different
+	// OutputID and size than the request.
+	Existing *GetResponse `json:"-"`
 }
 
 func (r *PutResponse) MarshalLogObject(enc zapcore.ObjectEncoder) error {
@@ -0,0 +1,239 @@
+package selfupdate
+
+import (
+	"archive/tar"
+	"bufio"
+	"bytes"
+	"compress/gzip"
+	"context"
+	"crypto/sha256"
+	"encoding/hex"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"net/http"
+	"os"
+	"path"
+	"path/filepath"
+	"strings"
+
+	gonanoid "github.com/matoous/go-nanoid/v2"
+)
+
+// This package updates the gscache executable to a GitHub release. Releases are built by
+// goreleaser (see .goreleaser.yaml), so each release has:
+//   gscache_<Os>_<uname arch>.tar.gz   Containing the gscache binary, e.g. gscache_Linux_x86_64.tar.gz
+//   gscache_<version>_checksums.txt    "<sha256 hex>  <asset name>" per line
+
+const (
+	DefaultAPIURL = "https://api.github.com/repos/breezewish/gscache"
+	binaryName    = "gscache"
+)
+
+type Asset struct {
+	Name string `json:"name"`
+	URL  string `json:"browser_download_url"`
+}
+
+type Release struct {
+	TagName string  `json:"tag_name"`
+	Assets  []Asset `json:"assets"`
+}
+
+// Asset returns the asset with the name, or nil.
+func (r *Release) Asset(name string) *Asset {
+	for i := range r.Assets {
+		if r.Assets[i].Name == name {
+			return &r.Assets[i]
+		}
+	}
+	return nil
+}
+
+// checksumsAsset returns the checksums file of the release, or nil.
+func (r *Release) checksumsAsset() *Asset {
+	for i := range r.Assets {
+		if strings.HasSuffix(r.Assets[i].Name, "checksums.txt") {
+			return &r.Assets[i]
+		}
+	}
+	return nil
+}
+
+// IsVersion returns whether the release is the version, ignoring the "v" prefix.
+func (r *Release) IsVersion(version string) bool {
+	return strings.TrimPrefix(r.TagName, "v") == strings.TrimPrefix(version, "v")
+}
+
+// ArchiveName returns the release archive name for the platform, following the name
+// template in .goreleaser.yaml.
+func ArchiveName(goos, goarch string) string {
+	arch := goarch
+	switch goarch {
+	case "amd64":
+		arch = "x86_64"
+	case "386":
+		arch = "i386"
+	}
+	return fmt.Sprintf("%s_%s_%s.tar.gz", binaryName, strings.ToUpper(goos[:1])+goos[1:], arch)
+}
+
+type Updater struct {
+	APIURL string // e.g. DefaultAPIURL
+	Token  string // Optional GitHub token, to avoid rate limits of anonymous requests
+	Client *http.Client
+}
+
+func (u *Updater) get(ctx context.Context, url string, accept string) (*http.Response, error) {
+	req, err := http.NewRequestWithContext(ctx, http.MethodGet, url, nil)
+	if err != nil {
+		return nil, err
+	}
+	if accept != "" {
+		req.Header.Set("Accept", accept)
+	}
+	if u.Token != "" {
+		req.Header.Set("Authorization", "Bearer "+u.Token)
+	}
+	client := u.Client
+	if client == nil {
+		client = http.DefaultClient
+	}
+	resp, err := client.Do(req)
+	if err != nil {
+		return nil, err
+	}
+	if resp.StatusCode != http.StatusOK {
+		body, _ := io.ReadAll(io.LimitReader(resp.Body, 1024))
+		_ = resp.Body.Close()
+		return nil, fmt.Errorf("GET %s: %s: %s", url, resp.Status, strings.TrimSpace(string(body)))
+	}
+	return resp, nil
+}
+
+// FetchRelease returns the release of the tag, or the latest release if tag is empty.
+func (u *Updater) FetchRelease(ctx context.Context, tag string) (*Release, error) {
+	url := u.APIURL + "/releases/latest"
+	if tag != "" {
+		url = u.APIURL + "/releases/tags/" + tag
+	}
+	resp, err := u.get(ctx, url, "application/vnd.github+json")
+	if err != nil {
+		return nil, fmt.Errorf("failed to fetch release: %w", err)
+	}
+	defer resp.Body.Close()
+	var rel Release
+	if err := json.NewDecoder(resp.Body).Decode(&rel); err != nil {
+		return nil, fmt.Errorf("failed to decode release: %w", err)
+	}
+	if rel.TagName == "" {
+		return nil, fmt.Errorf("release has no tag")
+	}
+	return &rel, nil
+}
+
+// fetchChecksum returns the sha256 of the asset listed in the checksums file of the release.
+func (u *Updater) fetchChecksum(ctx context.Context, rel *Release, name string) ([]byte, error) {
+	asset := rel.checksumsAsset()
+	if asset == nil {
+		return nil, fmt.Errorf("release %s has no checksums file", rel.TagName)
+	}
+	resp, err := u.get(ctx, asset.URL, "")
+	if err != nil {
+		return nil, fmt.Errorf("failed to download checksums: %w", err)
+	}
+	defer resp.Body.Close()
+	scanner := bufio.NewScanner(resp.Body)
+	for scanner.Scan() {
+		fields := strings.Fields(scanner.Text())
+		if len(fields) == 2 && fields[1] == name {
+			return hex.DecodeString(fields[0])
+		}
+	}
+	if err := scanner.Err(); err != nil {
+		return nil, fmt.Errorf("failed to read checksums: %w", err)
+	}
+	return nil, fmt.Errorf("%s is not in the checksums of release %s", name, rel.TagName)
+}
+
+// Apply downloads the release archive for the platform, verifies its checksum, and replaces
+// exePath with the binary in it atomically. The running process is not affected.
+func (u *Updater) Apply(ctx context.Context, rel *Release, goos, goarch string, exePath string) error {
+	name := ArchiveName(goos, goarch)
+	asset := rel.Asset(name)
+	if asset == nil {
+		return fmt.Errorf("release %s has no binary for %s/%s, expect %s", rel.TagName, goos, goarch, name)
+	}
+	checksum, err := u.fetchChecksum(ctx, rel, name)
+	if err != nil {
+		return err
+	}
+	info, err := os.Stat(exePath)
+	if err != nil {
+		return err
+	}
+
+	resp, err := u.get(ctx, asset.URL, "")
+	if err != nil {
+		return fmt.Errorf("failed to download %s: %w", name, err)
+	}
+	defer resp.Body.Close()
+	// Verified only after the whole archive is read, so the binary is extracted to a temp file
+	// next to the executable, and only installed if the checksum matches.
+	h := sha256.New()
+	tmpPath := filepath.Join(filepath.Dir(exePath), "."+filepath.Base(exePath)+".tmp."+gonanoid.Must(8))
+	defer os.Remove(tmpPath)
+	if err := extractBinary(io.TeeReader(resp.Body, h), tmpPath, info.Mode().Perm()); err != nil {
+		return fmt.Errorf("failed to extract %s: %w", name, err)
+	}
+	// Trailing bytes after the gzip stream, if any, are also covered by the checksum
+	if _, err := io.Copy(h, resp.Body); err != nil {
+		return fmt.Errorf("failed to download %s: %w", name, err)
+	}
+	if got := h.Sum(nil); !bytes.Equal(got, checksum) {
+		return fmt.Errorf("checksum mismatch of %s: expect %x, got %x", name, checksum, got)
+	}
+	if err := os.Rename(tmpPath, exePath); err != nil {
+		return fmt.Errorf("failed to replace %s: %w", exePath, err)
+	}
+	return nil
+}
+
+// extractBinary writes the gscache binary in a tar.gz stream to dst, and reads the rest of the stream.
+func extractBinary(r io.Reader, dst string, perm os.FileMode) error {
+	gz, err := gzip.NewReader(r)
+	if err != nil {
+		return err
+	}
+	tr := tar.NewReader(gz)
+	for {
+		hdr, err := tr.Next()
+		if errors.Is(err, io.EOF) {
+			return fmt.Errorf("%s is not found in the archive", binaryName)
+		}
+		if err != nil {
+			return err
+		}
+		if hdr.Typeflag != tar.TypeReg || path.Base(hdr.Name) != binaryName {
+			continue
+		}
+		f, err := os.OpenFile(dst, os.O_CREATE|os.O_EXCL|os.O_WRONLY, perm)
+		if err != nil {
+			return err
+		}
+		_, err = io.Copy(f, tr)
+		if err == nil {
+			err = f.Sync()
+		}
+		if closeErr := f.Close(); err == nil {
+			err = closeErr
+		}
+		if err != nil {
+			return err
+		}
+		// Drain the gzip stream, so that the checksum covers the whole archive
+		_, err = io.Copy(io.Discard, gz)
+		return err
+	}
+}
@@ -0,0 +1,110 @@
+package selfupdate
+
+import (
+	"archive/tar"
+	"bytes"
+	"compress/gzip"
+	"context"
+	"crypto/sha256"
+	"fmt"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"path/filepath"
+	"testing"
+
+	"github.com/stretchr/testify/require"
+)
+
+func TestArchiveName(t *testing.T) {
+	require.Equal(t, "gscache_Linux_x86_64.tar.gz", ArchiveName("linux", "amd64"))
+	require.Equal(t, "gscache_Darwin_arm64.tar.gz", ArchiveName("darwin", "arm64"))
+}
+
+func makeArchive(t *testing.T, files map[string]string) []byte {
+	var buf bytes.Buffer
+	gz := gzip.NewWriter(&buf)
+	tw := tar.NewWriter(gz)
+	for _, name := range []string{"LICENSE", "gscache"} {
+		content, ok := files[name]
+		if !ok {
+			continue
+		}
+		require.NoError(t, tw.WriteHeader(&tar.Header{Name: name, Mode: 0755, Size: int64(len(content))}))
+		_, err := tw.Write([]byte(content))
+		require.NoError(t, err)
+	}
+	require.NoError(t, tw.Close())
+	require.NoError(t, gz.Close())
+	return buf.Bytes()
+}
+
+func TestUpdater_Apply(t *testing.T) {
+	archiveName := ArchiveName("linux", "amd64")
+	archive := makeArchive(t, map[string]string{"LICENSE": "MIT", "gscache": "new binary"})
+	checksum := sha256.Sum256(archive)
+	checksums := fmt.Sprintf("%x  %s\n", checksum, archiveName)
+
+	mux := http.NewServeMux()
+	var ts *httptest.Server
+	mux.HandleFunc("/releases/latest", func(w http.ResponseWriter, r *http.Request) {
+		fmt.Fprintf(w, `{"tag_name":"v1.2.0","assets":[{"name":%q,"browser_download_url":%q},{"name":"gscache_1.2.0_checksums.txt","browser_download_url":%q}]}`,
+			archiveName, ts.URL+"/download/archive", ts.URL+"/download/checksums")
+	})
+	mux.HandleFunc("/download/archive", func(w http.ResponseWriter, r *http.Request) {
+		_, _ = w.Write(archive)
+	})
+	mux.HandleFunc("/download/checksums", func(w http.ResponseWriter, r *http.Request) {
+		_, _ = w.Write([]byte(checksums))
+	})
+	ts = httptest.NewServer(mux)
+	defer ts.Close()
+
+	ctx := context.Background()
+	u := &Updater{APIURL: ts.URL}
+	rel, err := u.FetchRelease(ctx, "")
+	require.NoError(t, err)
+	require.Equal(t, "v1.2.0", rel.TagName)
+	require.True(t, rel.IsVersion("1.2.0"))
+	require.False(t, rel.IsVersion("nightly"))
+	_, err = u.FetchRelease(ctx, "v0.0.1")
+	require.ErrorContains(t, err, "404")
+
+	exePath := filepath.Join(t.TempDir(), "gscache")
+	require.NoError(t, os.WriteFile(exePath, []byte("old binary"), 0755))
+
+	err = u.Apply(ctx, rel, "windows", "amd64", exePath)
+	require.ErrorContains(t, err, "has no binary for windows/amd64")
+
+	require.NoError(t, u.Apply(ctx, rel, "linux", "amd64", exePath))
+	content, err := os.ReadFile(exePath)
+	require.NoError(t, err)
+	require.Equal(t, "new binary", string(content))
+	info, err := os.Stat(exePath)
+	require.NoError(t, err)
+	require.Equal(t, os.FileMode(0755), info.Mode().Perm())
+
+	// Not replaced if the checksum mismatches
+	require.NoError(t, os.WriteFile(exePath, []byte("old binary"), 0755))
+	checksums = fmt.Sprintf("%x  %s\n", sha256.Sum256([]byte("other")), archiveName)
+	err = u.Apply(ctx, rel, "linux", "amd64", exePath)
+	require.ErrorContains(t, err, "checksum mismatch")
+	content, err = os.ReadFile(exePath)
+	require.NoError(t, err)
+	require.Equal(t, "old binary", string(content))
+	entries, err := os.ReadDir(filepath.Dir(exePath))
+	require.NoError(t, err)
+	require.Len(t, entries, 1) // Temp file is removed
+
+	// Not in checksums
+	checksums = ""
+	err = u.Apply(ctx, rel, "linux", "amd64", exePath)
+	require.ErrorContains(t, err, "is not in the checksums")
+
+	// No binary in the archive
+	archive = makeArchive(t, map[string]string{"LICENSE": "MIT"})
+	checksum = sha256.Sum256(archive)
+	checksums = fmt.Sprintf("%x  %s\n", checksum, archiveName)
+	err = u.Apply(ctx, rel, "linux", "amd64", exePath)
+	require.ErrorContains(t, err, "not found in the archive")
+}
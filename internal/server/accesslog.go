@@ -0,0 +1,66 @@
+package server
+
+import (
+	"fmt"
+	"math/rand/v2"
+	"time"
+
+	"github.com/gin-gonic/gin"
+	"go.uber.org/zap"
+)
+
+const accessRecordKey = "gscache.accessRecord"
+
+// accessRecord is what a cache request did, filled by handlers and logged by mAccessLog.
+type accessRecord struct {
+	ActionID   []byte
+	Namespace  string
+	Result     string // "hit" or "miss" of a Get, "stored" of a Put
+	ServedFrom string // Backend tier of a hit, e.g. "local", "archive" or "download"
+	Bytes      int64
+	Rejected   bool // Rejected by limits
+}
+
+// accessRecordOf returns the record of the request to fill. It is discarded if the access
+// log is disabled.
+func accessRecordOf(c *gin.Context) *accessRecord {
+	if v, ok := c.Get(accessRecordKey); ok {
+		return v.(*accessRecord)
+	}
+	return &accessRecord{}
+}
+
+// mAccessLog is a middleware logs cache requests as structured records, so that slow requests
+// can be analyzed offline. Requests are sampled by access_log.sample_rate, except for those
+// slower than slow_threshold, which are always logged.
+func (s *Server) mAccessLog(c *gin.Context) {
+	if !s.config.AccessLog.Enabled {
+		c.Next()
+		return
+	}
+	record := &accessRecord{}
+	c.Set(accessRecordKey, record)
+	start := time.Now()
+	c.Next()
+	cost := time.Since(start)
+
+	slow := s.config.SlowThreshold > 0 && cost >= s.config.SlowThreshold
+	if !slow && rand.Float64() >= s.config.AccessLog.SampleRate {
+		return
+	}
+	result := record.Result
+	switch {
+	case record.Rejected:
+		result = "rejected"
+	case len(c.Errors) > 0:
+		result = "error"
+	}
+	s.accessLog.Info(c.FullPath(),
+		zap.String("actionID", fmt.Sprintf("%x", record.ActionID)),
+		zap.String("namespace", record.Namespace),
+		zap.String("result", result),
+		zap.String("servedFrom", record.ServedFrom),
+		zap.Int64("bytes", record.Bytes),
+		zap.Float64("durationMs", float64(cost.Microseconds())/1000),
+		zap.Int("status", c.Writer.Status()))
+}
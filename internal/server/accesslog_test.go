@@ -0,0 +1,105 @@
+package server
+
+import (
+	"context"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+
+	"github.com/stretchr/testify/require"
+	"go.uber.org/zap"
+	"go.uber.org/zap/zaptest/observer"
+)
+
+func TestAccessLog(t *testing.T) {
+	cfg := DefaultConfig()
+	cfg.Dir = t.TempDir()
+	cfg.AccessLog.Enabled = true
+	s, err := NewServer(cfg)
+	require.NoError(t, err)
+	require.NoError(t, s.backend.Open(context.Background()))
+	defer s.backend.Close()
+	core, logs := observer.New(zap.InfoLevel)
+	s.accessLog = zap.New(core)
+	router := s.newRouter()
+
+	call := func(path, body string) {
+		req := httptest.NewRequest(http.MethodPost, path, strings.NewReader(body))
+		req.Header.Set("Content-Type", "application/json")
+		router.ServeHTTP(httptest.NewRecorder(), req)
+	}
+	call("/cacheprog/get", `{"ActionID":"AQ=="}`)
+	call("/cacheprog/put", `{"ActionID":"AQ==","OutputID":"Ag==","BodySize":5}`+"\n"+`"aGVsbG8="`)
+	call("/cacheprog/get", `{"ActionID":"AQ=="}`)
+	call("/cacheprog/get", `garbage`)
+
+	entries := logs.TakeAll()
+	require.Len(t, entries, 4)
+	expected := []struct {
+		msg    string
+		result string
+		bytes  int64
+	}{
+		{"/cacheprog/get", "miss", 0},
+		{"/cacheprog/put", "stored", 5},
+		{"/cacheprog/get", "hit", 5},
+		{"/cacheprog/get", "error", 0},
+	}
+	for i, e := range expected {
+		fields := entries[i].ContextMap()
+		require.Equal(t, e.msg, entries[i].Message)
+		require.Equal(t, e.result, fields["result"], i)
+		require.Equal(t, e.bytes, fields["bytes"], i)
+	}
+	require.Equal(t, "01", entries[0].ContextMap()["actionID"])
+	require.Equal(t, "local", entries[2].ContextMap()["servedFrom"])
+
+	// Not sampled
+	s.config.AccessLog.SampleRate = 0
+	call("/cacheprog/get", `{"ActionID":"AQ=="}`)
+	require.Zero(t, logs.Len())
+	// Slow requests are always logged
+	s.config.SlowThreshold = 1
+	call("/cacheprog/get", `{"ActionID":"AQ=="}`)
+	require.Equal(t, 1, logs.Len())
+}
+
+func TestAccessLogRejected(t *testing.T) {
+	cfg := DefaultConfig()
+	cfg.Dir = t.TempDir()
+	cfg.AccessLog.Enabled = true
+	cfg.Limits.Get = RequestLimitConfig{Concurrency: 1}
+	cfg.Limits.Put = RequestLimitConfig{Concurrency: 1}
+	s, err := NewServer(cfg)
+	require.NoError(t, err)
+	require.NoError(t, s.backend.Open(context.Background()))
+	defer s.backend.Close()
+	core, logs := observer.New(zap.InfoLevel)
+	s.accessLog = zap.New(core)
+	router := s.newRouter()
+
+	// All slots are taken
+	require.True(t, s.getLimiter.Acquire(context.Background()))
+	defer s.getLimiter.Release()
+	require.True(t, s.putLimiter.Acquire(context.Background()))
+	defer s.putLimiter.Release()
+	for _, path := range []string{"/cacheprog/get", "/cacheprog/put"} {
+		body := `{"ActionID":"AQ==","Namespace":"ns"}`
+		if path == "/cacheprog/put" {
+			body = `{"ActionID":"AQ==","Namespace":"ns","OutputID":"Ag==","BodySize":5}` + "\n" + `"aGVsbG8="`
+		}
+		req := httptest.NewRequest(http.MethodPost, path, strings.NewReader(body))
+		req.Header.Set("Content-Type", "application/json")
+		router.ServeHTTP(httptest.NewRecorder(), req)
+	}
+
+	entries := logs.TakeAll()
+	require.Len(t, entries, 2)
+	for _, entry := range entries {
+		fields := entry.ContextMap()
+		require.Equal(t, "rejected", fields["result"], entry.Message)
+		require.Equal(t, "01", fields["actionID"], entry.Message)
+		require.Equal(t, "ns", fields["namespace"], entry.Message)
+	}
+}
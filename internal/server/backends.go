@@ -0,0 +1,93 @@
+package server
+
+import (
+	"fmt"
+	"sort"
+	"sync"
+
+	"github.com/breezewish/gscache/internal/cache"
+	"github.com/breezewish/gscache/internal/cache/backends/blob"
+	"github.com/breezewish/gscache/internal/cache/backends/local"
+)
+
+const (
+	BackendLocal = "local"
+	BackendBlob  = "blob"
+)
+
+// BackendConstructor creates a backend from the server config.
+type BackendConstructor func(config Config) (cache.Backend, error)
+
+var (
+	backendsMu sync.RWMutex
+	backends   = make(map[string]BackendConstructor)
+)
+
+// RegisterBackend makes a backend available by the name, which can be selected
+// by the `backend` config. It panics if the name is already registered.
+func RegisterBackend(name string, constructor BackendConstructor) {
+	backendsMu.Lock()
+	defer backendsMu.Unlock()
+	if constructor == nil {
+		panic("server: RegisterBackend constructor is nil")
+	}
+	if _, dup := backends[name]; dup {
+		panic("server: RegisterBackend called twice for backend " + name)
+	}
+	backends[name] = constructor
+}
+
+// BackendNames returns names of all registered backends in sorted order.
+func BackendNames() []string {
+	backendsMu.RLock()
+	defer backendsMu.RUnlock()
+	names := make([]string, 0, len(backends))
+	for name := range backends {
+		names = append(names, name)
+	}
+	sort.Strings(names)
+	return names
+}
+
+// BackendName returns the backend to use. If not configured explicitly, the blob
+// backend is used when a remote or local archives are configured, otherwise local.
+func BackendName(config Config) string {
+	if config.Backend != "" {
+		return config.Backend
+	}
+	if config.Blob.URL != "" || config.Blob.LocalArchiveDir != "" {
+		return BackendBlob
+	}
+	return BackendLocal
+}
+
+// NewBackend creates the backend selected by the config. The backend must be opened
+// before use and closed afterwards.
+func NewBackend(config Config) (cache.Backend, error) {
+	name := BackendName(config)
+	backendsMu.RLock()
+	constructor, ok := backends[name]
+	backendsMu.RUnlock()
+	if !ok {
+		return nil, fmt.Errorf("unknown backend %q, available: %v", name, BackendNames())
+	}
+	return constructor(config)
+}
+
+func init() {
+	RegisterBackend(BackendLocal, func(config Config) (cache.Backend, error) {
+		return local.NewLocalBackendWithOpts(config.Dir, local.LocalBackendOpts{
+			PerRequestEmptyFile: config.PerRequestEmptyFile,
+			ReadOnly:            config.ReadOnly,
+		})
+	})
+	RegisterBackend(BackendBlob, func(config Config) (cache.Backend, error) {
+		if config.ReadOnly {
+			return nil, fmt.Errorf("read_only is only supported by the local backend")
+		}
+		config.Blob.WorkDir = config.Dir
+		config.Blob.SlowThreshold = config.SlowThreshold
+		config.Blob.PerRequestEmptyFile = config.PerRequestEmptyFile
+		return blob.NewBlobBackend(config.Blob)
+	})
+}
@@ -0,0 +1,62 @@
+package server
+
+import (
+	"context"
+	"testing"
+
+	"github.com/stretchr/testify/require"
+
+	"github.com/breezewish/gscache/internal/cache"
+	"github.com/breezewish/gscache/internal/cache/backends/blob"
+	"github.com/breezewish/gscache/internal/cache/backends/local"
+	"github.com/breezewish/gscache/internal/protocol"
+)
+
+type fakeBackend struct {
+	dir string
+}
+
+func (b *fakeBackend) Put(cache.PutOpts) (*protocol.PutResponse, error) { return nil, nil }
+func (b *fakeBackend) Get(cache.GetOpts) (*protocol.GetResponse, error) { return nil, nil }
+func (b *fakeBackend) Open(context.Context) error                       { return nil }
+func (b *fakeBackend) Close() error                                     { return nil }
+
+func TestNewServer_SelectBackend(t *testing.T) {
+	cfg := DefaultConfig()
+	cfg.Dir = t.TempDir()
+	s, err := NewServer(cfg)
+	require.NoError(t, err)
+	require.IsType(t, &local.LocalBackend{}, s.backend)
+
+	cfg.Blob.URL = "mem://"
+	s, err = NewServer(cfg)
+	require.NoError(t, err)
+	require.IsType(t, &blob.BlobBackend{}, s.backend)
+
+	cfg.Backend = BackendLocal
+	s, err = NewServer(cfg)
+	require.NoError(t, err)
+	require.IsType(t, &local.LocalBackend{}, s.backend)
+
+	cfg.Backend = "unknown"
+	_, err = NewServer(cfg)
+	require.Error(t, err)
+	require.Contains(t, err.Error(), `unknown backend "unknown"`)
+}
+
+func TestRegisterBackend(t *testing.T) {
+	RegisterBackend("test-fake", func(config Config) (cache.Backend, error) {
+		return &fakeBackend{dir: config.Dir}, nil
+	})
+	require.Contains(t, BackendNames(), "test-fake")
+	require.Panics(t, func() {
+		RegisterBackend("test-fake", func(config Config) (cache.Backend, error) { return nil, nil })
+	})
+
+	cfg := DefaultConfig()
+	cfg.Dir = t.TempDir()
+	cfg.Backend = "test-fake"
+	s, err := NewServer(cfg)
+	require.NoError(t, err)
+	require.Equal(t, &fakeBackend{dir: cfg.Dir}, s.backend)
+}
@@ -1,14 +1,22 @@
 package server
 
 import (
+	"crypto/tls"
+	"crypto/x509"
 	"fmt"
+	"net"
 	"os"
 	"path/filepath"
+	"strconv"
 	"strings"
 	"time"
 
 	"github.com/breezewish/gscache/internal/cache/backends/blob"
 	"github.com/breezewish/gscache/internal/log"
+	"github.com/breezewish/gscache/internal/stats"
+	"github.com/breezewish/gscache/internal/statsd"
+	"github.com/breezewish/gscache/internal/tracing"
+	"github.com/breezewish/gscache/internal/util"
 	"github.com/knadh/koanf/parsers/toml/v2"
 	"github.com/knadh/koanf/providers/env"
 	"github.com/knadh/koanf/providers/file"
@@ -25,11 +33,203 @@ var (
 )
 
 type Config struct {
-	Port                    int           `json:"port"`
-	Log                     log.Config    `json:"log"`
-	Dir                     string        `json:"dir"`
-	ShutdownAfterInactivity time.Duration `json:"shutdown_after_inactivity"` // Note: This cannot be overridden by env variable due to its name
-	Blob                    blob.Config   `json:"blob"`
+	Port                    int                 `json:"port"`
+	Host                    string              `json:"host"` // Client only: Host of the daemon to connect to, e.g. a remote proxy. If empty, 127.0.0.1 is used
+	Log                     log.Config          `json:"log"`
+	Dir                     string              `json:"dir"`
+	Backend                 string              `json:"backend"`                   // If empty, "blob" is used when blob.url or blob.local_archive_dir is set, otherwise "local"
+	ShutdownAfterInactivity time.Duration       `json:"shutdown_after_inactivity"` // Note: This cannot be overridden by env variable due to its name
+	Blob                    blob.Config         `json:"blob"`
+	Otel                    tracing.Config      `json:"otel"`
+	Statsd                  statsd.Config       `json:"statsd"`
+	StatsFile               string              `json:"stats_file"` // If empty, <dir>/stats.json is used. Note: This cannot be overridden by env variable due to its name
+	UI                      UIConfig            `json:"ui"`
+	Auth                    AuthConfig          `json:"auth"`
+	TLS                     TLSConfig           `json:"tls"`
+	StatsHistory            stats.HistoryConfig `json:"stats_history"` // Periodic gzip snapshots of stats in <stats file dir>/stats-history
+	GC                      GCConfig            `json:"gc"`
+	Limits                  LimitsConfig        `json:"limits"`
+	Debug                   DebugConfig         `json:"debug"`
+	AccessLog               AccessLogConfig     `json:"access_log"` // Note: This cannot be overridden by env variable due to its name
+	// If > 0, Get/Put requests and remote downloads/uploads taking longer than this are logged
+	// at info level, so that slow operations are visible without enabling debug logs.
+	// Note: This cannot be overridden by env variable due to its name
+	SlowThreshold time.Duration `json:"slow_threshold"`
+	// The server is never shut down for inactivity within this duration after start, to avoid
+	// rapid start/stop churn when builds come in waves.
+	// Note: This cannot be overridden by env variable due to its name
+	MinUptimeBeforeInactivityShutdown time.Duration `json:"min_uptime_before_inactivity_shutdown"`
+	// Before shutting down for inactivity, the server waits up to this duration for queued uploads
+	// to be persisted to the remote, so that puts of a just finished build are not lost.
+	// The shutdown is cancelled if a new request comes in meanwhile.
+	// Note: This cannot be overridden by env variable due to its name
+	FlushDeadline time.Duration `json:"flush_deadline"`
+	// If true, each zero-size hit is served with its own ephemeral empty file instead of one
+	// shared file, so that a path is never handed to toolchains of different users when a daemon
+	// is shared. This costs a file creation per response, so the shared file is used by default.
+	// Note: This cannot be overridden by env variable due to its name
+	PerRequestEmptyFile bool `json:"per_request_empty_file"`
+	// If true, the work dir is never written, so that it can be a read-only mount of a pre-baked
+	// cache image. Existing entries are served, while Put bodies are only materialized in a temp
+	// dir for the caller. Only the local backend is supported. Stats are kept in memory unless
+	// stats_file is set, and log.file should point to a writable path.
+	// Note: This cannot be overridden by env variable due to its name
+	ReadOnly bool `json:"read_only"`
+	// Path of the unix socket the server listens on in addition to the TCP port. Clients connect
+	// to a local daemon via the socket when it exists, so that only the current user (by file
+	// permissions) can talk to the daemon. If empty, <dir>/gscache.sock is used. "none" disables it.
+	Socket string `json:"socket"`
+	// Server only: Address the server listens on, e.g. "0.0.0.0" to serve a whole LAN. The port
+	// is used if the address has no port. If empty, 127.0.0.1 is used, so that only local clients
+	// can connect. A non-loopback address requires auth.token.
+	Listen string `json:"listen"`
+	// Optional. Used for uptime and inactivity shutdown, so that they can be tested with a fake
+	// clock. Defaults to the real clock.
+	Clock util.Clock `json:"-"`
+}
+
+type UIConfig struct {
+	// If true, a status page is served at GET /. The server only listens on loopback by default,
+	// so it is not exposed to other machines. If auth.token is set, open /?token=<token> once to
+	// log in, which sets an HttpOnly cookie in the browser.
+	Enabled bool `json:"enabled"`
+}
+
+type AccessLogConfig struct {
+	// If true, each cacheprog Get and Put is logged at info level by the "access" logger, with
+	// actionID, hit/miss, the backend tier it is served from, bytes and duration.
+	Enabled bool `json:"enabled"`
+	// Fraction of requests logged, from 0 to 1. Requests slower than slow_threshold are always logged.
+	SampleRate float64 `json:"sample_rate"`
+}
+
+type DebugConfig struct {
+	// If true, net/http/pprof handlers are served at /debug/pprof/, so that CPU and heap profiles
+	// of the daemon can be captured when it misbehaves. It requires auth.token if set.
+	Pprof bool `json:"pprof"`
+}
+
+type AuthConfig struct {
+	// If set, the server rejects requests without "Authorization: Bearer <token>", and clients
+	// send it automatically. There is no flag for it, so that it is not visible in process lists.
+	Token string `json:"token" redact:"true"`
+}
+
+type TLSConfig struct {
+	// If true, the server serves HTTPS on the TCP address, and clients connect to it via HTTPS.
+	// The unix socket is always plain HTTP, as it is only accessible locally.
+	Enabled bool `json:"enabled"`
+	// Server only: PEM files of the certificate and its private key.
+	// Note: These cannot be overridden by env variable due to their names
+	CertFile string `json:"cert_file"`
+	KeyFile  string `json:"key_file"`
+	// Client only: PEM file of CAs to verify the server certificate. If empty, system CAs are used.
+	// Note: This cannot be overridden by env variable due to its name
+	CAFile string `json:"ca_file"`
+}
+
+// ClientConfig returns the TLS config for clients, or nil if TLS is not enabled.
+func (c *TLSConfig) ClientConfig() (*tls.Config, error) {
+	if !c.Enabled {
+		return nil, nil
+	}
+	config := &tls.Config{MinVersion: tls.VersionTLS12}
+	if c.CAFile != "" {
+		pem, err := os.ReadFile(c.CAFile)
+		if err != nil {
+			return nil, fmt.Errorf("failed to read tls.ca_file: %w", err)
+		}
+		config.RootCAs = x509.NewCertPool()
+		if !config.RootCAs.AppendCertsFromPEM(pem) {
+			return nil, fmt.Errorf("no certificate is found in tls.ca_file %s", c.CAFile)
+		}
+	}
+	return config, nil
+}
+
+// serverConfig returns the TLS config for the server, or nil if TLS is not enabled.
+func (c *TLSConfig) serverConfig() (*tls.Config, error) {
+	if !c.Enabled {
+		return nil, nil
+	}
+	if c.CertFile == "" || c.KeyFile == "" {
+		return nil, fmt.Errorf("tls.cert_file and tls.key_file are required when tls is enabled")
+	}
+	cert, err := tls.LoadX509KeyPair(c.CertFile, c.KeyFile)
+	if err != nil {
+		return nil, fmt.Errorf("failed to load tls certificate: %w", err)
+	}
+	return &tls.Config{Certificates: []tls.Certificate{cert}, MinVersion: tls.VersionTLS12}, nil
+}
+
+// GCConfig is the default trimming policy of `gscache gc` for the local store.
+type GCConfig struct {
+	// Entries not used within this duration are removed. 0 to disable.
+	// Note: This cannot be overridden by env variable due to its name
+	MaxAge time.Duration `json:"max_age"`
+	// Least recently used entries are removed until outputs take at most this many bytes. 0 to disable.
+	// Note: This cannot be overridden by env variable due to its name
+	MaxBytes int64 `json:"max_bytes"`
+}
+
+// LimitsConfig bounds cacheprog requests served at the same time, so that hundreds of parallel
+// build workers cannot exhaust file descriptors or memory of the daemon.
+type LimitsConfig struct {
+	Get RequestLimitConfig `json:"get"`
+	Put RequestLimitConfig `json:"put"`
+}
+
+type RequestLimitConfig struct {
+	// At most this many requests are served at the same time. 0 for no limit.
+	Concurrency int `json:"concurrency"`
+	// Requests over the concurrency wait in a queue of this size. Requests beyond it are rejected
+	// immediately: a Get is served as a miss, and a Put fails with 503 on purpose, i.e. the upload
+	// of the output fails and it is not cached, while the build goes on.
+	Queue int `json:"queue"`
+	// A queued request is rejected after waiting this long. 0 to wait until it is served.
+	// Note: This cannot be overridden by env variable due to its name
+	QueueTimeout time.Duration `json:"queue_timeout"`
+}
+
+// StatsFilePath returns the path of the stats file, which is <dir>/stats.json by default.
+// In read-only mode, it is empty unless explicitly configured, which means stats are in-memory.
+func (c *Config) StatsFilePath() string {
+	if c.StatsFile != "" {
+		return c.StatsFile
+	}
+	if c.ReadOnly {
+		return ""
+	}
+	return stats.FileName(c.Dir)
+}
+
+// SocketNone is the value of Config.Socket to only listen on the TCP port.
+const SocketNone = "none"
+
+// SocketPath returns the path of the unix socket, which is <dir>/gscache.sock by default.
+// It is empty if disabled, or in read-only mode unless explicitly configured, as the work dir
+// is not writable.
+func (c *Config) SocketPath() string {
+	switch {
+	case c.Socket == SocketNone:
+		return ""
+	case c.Socket != "":
+		return c.Socket
+	case c.ReadOnly:
+		return ""
+	}
+	return filepath.Join(c.Dir, "gscache.sock")
+}
+
+// ListenAddr returns the TCP address the server listens on, which is 127.0.0.1:<port> by default.
+func (c *Config) ListenAddr() string {
+	if c.Listen == "" {
+		return net.JoinHostPort("127.0.0.1", strconv.Itoa(c.Port))
+	}
+	if _, _, err := net.SplitHostPort(c.Listen); err == nil {
+		return c.Listen
+	}
+	return net.JoinHostPort(strings.Trim(c.Listen, "[]"), strconv.Itoa(c.Port))
 }
 
 func defaultWorkDir() string {
@@ -62,15 +262,72 @@ func DefaultConfig() Config {
 		Log:                     log.DefaultConfig(DefaultWorkDir),
 		Dir:                     DefaultWorkDir,
 		ShutdownAfterInactivity: 10 * time.Minute,
+		FlushDeadline:           5 * time.Minute,
 		Blob:                    blob.DefaultConfig(),
+		Otel:                    tracing.DefaultConfig(),
+		Statsd:                  statsd.DefaultConfig(),
+		StatsHistory:            stats.DefaultHistoryConfig(),
+		GC: GCConfig{
+			MaxAge: 7 * 24 * time.Hour,
+		},
+		AccessLog: AccessLogConfig{
+			SampleRate: 1,
+		},
+		Limits: LimitsConfig{
+			Get: RequestLimitConfig{Queue: 1024, QueueTimeout: time.Second},
+			Put: RequestLimitConfig{Queue: 1024, QueueTimeout: time.Second},
+		},
 	}
 }
 
 func LoadConfig(configPath string, flags *pflag.FlagSet) (Config, error) {
+	config, _, err := LoadConfigWithSources(configPath, flags)
+	return config, err
+}
+
+// Sources of config values, see LoadConfigWithSources.
+const (
+	ConfigSourceDefault = "default"
+	ConfigSourceFile    = "file"
+	ConfigSourceEnv     = "env"
+	ConfigSourceFlag    = "flag"
+)
+
+// ConfigSource describes where the value of a config key comes from.
+type ConfigSource struct {
+	Kind string // One of ConfigSourceXxx
+	Name string // The file path, env variable or flag. Empty for defaults.
+}
+
+func (s ConfigSource) String() string {
+	if s.Name == "" {
+		return s.Kind
+	}
+	return s.Kind + " " + s.Name
+}
+
+// LoadConfigWithSources is the same as LoadConfig, and also returns the source of each config
+// key (like "log.level"), i.e. the last layer which sets the key.
+func LoadConfigWithSources(configPath string, flags *pflag.FlagSet) (Config, map[string]ConfigSource, error) {
 	k := koanf.New(".")
+	sources := make(map[string]ConfigSource)
+	// Each layer is loaded separately and then merged, so that keys set by the layer are known.
+	mergeLayer := func(layer *koanf.Koanf, sourceOf func(key string) ConfigSource) error {
+		for _, key := range layer.Keys() {
+			sources[key] = sourceOf(key)
+		}
+		return k.Merge(layer)
+	}
+
 	// 1. Load from default
-	if err := k.Load(structs.Provider(DefaultConfig(), "json"), nil); err != nil {
-		return Config{}, err
+	layer := koanf.New(".")
+	if err := layer.Load(structs.Provider(DefaultConfig(), "json"), nil); err != nil {
+		return Config{}, nil, err
+	}
+	if err := mergeLayer(layer, func(string) ConfigSource {
+		return ConfigSource{Kind: ConfigSourceDefault}
+	}); err != nil {
+		return Config{}, nil, err
 	}
 	displayLoadFileFailure := true
 	if configPath == "" {
@@ -79,54 +336,92 @@ func LoadConfig(configPath string, flags *pflag.FlagSet) (Config, error) {
 	}
 
 	// 2. Load from config file
-	if err := k.Load(file.Provider(configPath), toml.Parser()); err != nil {
+	layer = koanf.New(".")
+	if err := layer.Load(file.Provider(configPath), toml.Parser()); err != nil {
 		// If user has specified a config path that does not exist, we return an error
 		if os.IsNotExist(err) {
 			if displayLoadFileFailure {
 				log.Warn("Config file does not exist, skip loading", zap.String("file", configPath))
 			}
 		} else {
-			return Config{}, fmt.Errorf("failed to load config file %s: %w", configPath, err)
+			return Config{}, nil, fmt.Errorf("failed to load config file %s: %w", configPath, err)
 		}
 	}
+	if err := mergeLayer(layer, func(string) ConfigSource {
+		return ConfigSource{Kind: ConfigSourceFile, Name: configPath}
+	}); err != nil {
+		return Config{}, nil, err
+	}
 
 	// 3. Load from environment variables
 	// Example: GSCACHE_LOG_LEVEL=debug -> log.level=debug
-	if err := k.Load(env.ProviderWithValue("GSCACHE_", ".", func(key string, value string) (string, any) {
+	layer = koanf.New(".")
+	envNames := make(map[string]string)
+	if err := layer.Load(env.ProviderWithValue("GSCACHE_", ".", func(key string, value string) (string, any) {
 		if len(value) == 0 {
 			return "", nil
 		}
+		envName := key
 		key = strings.Replace(strings.ToLower(strings.TrimPrefix(key, "GSCACHE_")), "_", ".", -1)
+		envNames[key] = envName
 		return key, value
 	}), nil); err != nil {
 		log.Warn("Failed to load environment variables", zap.Error(err))
 	}
+	if err := mergeLayer(layer, func(key string) ConfigSource {
+		return ConfigSource{Kind: ConfigSourceEnv, Name: envNames[key]}
+	}); err != nil {
+		return Config{}, nil, err
+	}
 
 	// 4. Load from command-line flags
 	// Example: --log.level=debug -> log.level=debug
 	if flags != nil {
-		if err := k.Load(posflag.Provider(flags, ".", k), nil); err != nil {
+		layer = koanf.New(".")
+		// Unchanged flags are only loaded if the key is not set by previous layers.
+		if err := layer.Load(posflag.Provider(flags, ".", k), nil); err != nil {
 			log.Warn("Failed to load command-line flags", zap.Error(err))
 		}
+		if err := mergeLayer(layer, func(key string) ConfigSource {
+			return ConfigSource{Kind: ConfigSourceFlag, Name: "--" + key}
+		}); err != nil {
+			return Config{}, nil, err
+		}
 	}
 
 	var instance Config
 	if err := k.UnmarshalWithConf("", &instance, koanf.UnmarshalConf{Tag: "json"}); err != nil {
-		return Config{}, err
+		return Config{}, nil, err
 	}
-	return instance, nil
+	return instance, sources, nil
 }
 
 func AddFlags(f *pflag.FlagSet) {
 	defServerCfg := DefaultConfig()
 	f.IntP("port", "p", defServerCfg.Port,
 		"(env: GSCACHE_PORT)  Listen port of gscache server (or the gscache server port to connect to if running as client)")
+	f.String("host", defServerCfg.Host,
+		"(env: GSCACHE_HOST)  Client only: Host of the gscache server to connect to. Request bodies are gzip compressed if it is not loopback. Default: 127.0.0.1")
+	f.String("socket", defServerCfg.Socket,
+		"(env: GSCACHE_SOCKET)  Unix socket path the gscache server listens on, and a local client prefers over the port. Default: <dir>/gscache.sock, \"none\" to disable")
+	f.String("listen", defServerCfg.Listen,
+		"(env: GSCACHE_LISTEN)  Server only: Address to listen on, e.g. 0.0.0.0 to serve other machines (requires auth.token). Default: 127.0.0.1")
 	f.String("log.file", defServerCfg.Log.File,
 		"(env: GSCACHE_LOG_FILE)  Server only: Log file path")
 	f.String("log.level", defServerCfg.Log.Level,
 		"(env: GSCACHE_LOG_LEVEL)  Server only: Log level (info, debug, warn, error)")
 	f.String("dir", defServerCfg.Dir,
 		"(env: GSCACHE_DIR)  Server only: Working directory for the server, where local cache files will be stored")
+	f.String("backend", defServerCfg.Backend,
+		"(env: GSCACHE_BACKEND)  Server only: Cache backend to use (local, blob). If not set, it is decided by whether blob.url is set")
 	f.String("blob.url", defServerCfg.Blob.URL,
 		"(env: GSCACHE_BLOB_URL)  Server only: If set, remote blob cache will be used. If not set, by default a local cache is used. Example: s3://my-bucket")
+	f.Duration("shutdown_after_inactivity", defServerCfg.ShutdownAfterInactivity,
+		"Server only: Shut down the daemon after being inactive for this duration, 0 to never shut down")
+	f.Duration("slow_threshold", defServerCfg.SlowThreshold,
+		"Server only: If set, operations slower than this are logged at info level. Example: 200ms")
+	f.String("otel.endpoint", defServerCfg.Otel.Endpoint,
+		"(env: GSCACHE_OTEL_ENDPOINT)  Server only: If set, OpenTelemetry spans will be exported to this OTLP/HTTP endpoint. Example: localhost:4318")
+	f.String("statsd.addr", defServerCfg.Statsd.Addr,
+		"(env: GSCACHE_STATSD_ADDR)  Server only: If set, metrics will be periodically sent to this StatsD UDP address. Example: localhost:8125")
 }
@@ -8,6 +8,8 @@ import (
 
 	"github.com/spf13/pflag"
 	"github.com/stretchr/testify/require"
+
+	"github.com/breezewish/gscache/internal/util"
 )
 
 func TestLoadEmptyConfigPathReturnsDefault(t *testing.T) {
@@ -137,6 +139,32 @@ url = "file:///config/storage"
 	require.Equal(t, DefaultConfig().Blob.UploadConcurrency, config.Blob.UploadConcurrency)
 }
 
+func TestLoadConfigWithSources(t *testing.T) {
+	configPath := filepath.Join(t.TempDir(), "config.toml")
+	err := os.WriteFile(configPath, []byte("port = 8080\ndir = \"/config/work\"\n[log]\nlevel = \"info\"\n"), 0644)
+	require.NoError(t, err)
+	t.Setenv("GSCACHE_PORT", "9000")
+	t.Setenv("GSCACHE_LOG_LEVEL", "error")
+
+	flags := pflag.NewFlagSet("test", pflag.ContinueOnError)
+	flags.Int("port", 0, "port")
+	flags.String("log.file", "", "log file")
+	flags.String("otel.endpoint", "", "otel endpoint")
+	require.NoError(t, flags.Parse([]string{"--port=7000"}))
+
+	config, sources, err := LoadConfigWithSources(configPath, flags)
+	require.NoError(t, err)
+	require.Equal(t, 7000, config.Port)
+	require.Equal(t, ConfigSource{Kind: ConfigSourceFlag, Name: "--port"}, sources["port"])
+	require.Equal(t, ConfigSource{Kind: ConfigSourceEnv, Name: "GSCACHE_LOG_LEVEL"}, sources["log.level"])
+	require.Equal(t, ConfigSource{Kind: ConfigSourceFile, Name: configPath}, sources["dir"])
+	// Unchanged flags do not override lower layers
+	require.Equal(t, ConfigSource{Kind: ConfigSourceDefault}, sources["log.file"])
+	require.Equal(t, ConfigSource{Kind: ConfigSourceDefault}, sources["otel.endpoint"])
+	require.Equal(t, "flag --port", sources["port"].String())
+	require.Equal(t, "default", sources["log.file"].String())
+}
+
 func TestEmptyEnvVarsUseDefault(t *testing.T) {
 	// If env var is set to an empty string, it falls back to the default value instead of being empty.
 	t.Setenv("GSCACHE_PORT", "")
@@ -151,3 +179,85 @@ func TestEmptyEnvVarsUseDefault(t *testing.T) {
 	require.Equal(t, defaultConfig.Log.Level, config.Log.Level)
 	require.Equal(t, defaultConfig.Dir, config.Dir)
 }
+
+func TestStatsFilePath(t *testing.T) {
+	tmpDir := t.TempDir()
+	configPath := filepath.Join(tmpDir, "config.toml")
+
+	err := os.WriteFile(configPath, []byte(`dir = "/config/work"`), 0644)
+	require.NoError(t, err)
+	config, err := LoadConfig(configPath, nil)
+	require.NoError(t, err)
+	require.Equal(t, filepath.Join("/config/work", "stats.json"), config.StatsFilePath())
+
+	err = os.WriteFile(configPath, []byte("dir = \"/config/work\"\nstats_file = \"/var/lib/gscache/stats.json\""), 0644)
+	require.NoError(t, err)
+	config, err = LoadConfig(configPath, nil)
+	require.NoError(t, err)
+	require.Equal(t, "/var/lib/gscache/stats.json", config.StatsFilePath())
+}
+
+func TestSocketPath(t *testing.T) {
+	config := Config{Dir: "/config/work"}
+	require.Equal(t, filepath.Join("/config/work", "gscache.sock"), config.SocketPath())
+
+	config.Socket = "/run/gscache.sock"
+	require.Equal(t, "/run/gscache.sock", config.SocketPath())
+
+	config.Socket = SocketNone
+	require.Equal(t, "", config.SocketPath())
+
+	// The work dir is not writable in read-only mode
+	config = Config{Dir: "/config/work", ReadOnly: true}
+	require.Equal(t, "", config.SocketPath())
+	config.Socket = "/run/gscache.sock"
+	require.Equal(t, "/run/gscache.sock", config.SocketPath())
+}
+
+func TestListenAddr(t *testing.T) {
+	config := Config{Port: 8511}
+	require.Equal(t, "127.0.0.1:8511", config.ListenAddr())
+	require.True(t, util.IsLoopbackAddr(config.ListenAddr()))
+
+	config.Listen = "0.0.0.0"
+	require.Equal(t, "0.0.0.0:8511", config.ListenAddr())
+	require.False(t, util.IsLoopbackAddr(config.ListenAddr()))
+
+	config.Listen = "192.168.1.10:9000"
+	require.Equal(t, "192.168.1.10:9000", config.ListenAddr())
+	require.False(t, util.IsLoopbackAddr(config.ListenAddr()))
+
+	config.Listen = "::1"
+	require.Equal(t, "[::1]:8511", config.ListenAddr())
+	require.True(t, util.IsLoopbackAddr(config.ListenAddr()))
+
+	config.Listen = "[::]"
+	require.Equal(t, "[::]:8511", config.ListenAddr())
+	require.False(t, util.IsLoopbackAddr(config.ListenAddr()))
+
+	config.Listen = ":8512"
+	require.Equal(t, ":8512", config.ListenAddr())
+	require.False(t, util.IsLoopbackAddr(config.ListenAddr()))
+}
+
+func TestTLSConfig(t *testing.T) {
+	config := TLSConfig{CertFile: "cert.pem", KeyFile: "key.pem", CAFile: "ca.pem"}
+	clientConfig, err := config.ClientConfig()
+	require.NoError(t, err)
+	require.Nil(t, clientConfig)
+	serverConfig, err := config.serverConfig()
+	require.NoError(t, err)
+	require.Nil(t, serverConfig)
+
+	config = TLSConfig{Enabled: true}
+	clientConfig, err = config.ClientConfig()
+	require.NoError(t, err)
+	require.Nil(t, clientConfig.RootCAs) // System CAs
+	_, err = config.serverConfig()
+	require.ErrorContains(t, err, "tls.cert_file and tls.key_file are required")
+
+	config.CAFile = filepath.Join(t.TempDir(), "ca.pem")
+	require.NoError(t, os.WriteFile(config.CAFile, []byte("garbage"), 0644))
+	_, err = config.ClientConfig()
+	require.ErrorContains(t, err, "no certificate is found")
+}
@@ -0,0 +1,113 @@
+package server
+
+import (
+	"context"
+	"net/http"
+	"slices"
+	"strings"
+	"time"
+
+	"github.com/caarlos0/httperr"
+	"github.com/gin-gonic/gin"
+	"go.uber.org/zap"
+
+	"github.com/breezewish/gscache/internal/inflight"
+	"github.com/breezewish/gscache/internal/log"
+	"github.com/breezewish/gscache/internal/protocol"
+)
+
+const (
+	// eventsBuffer is how many activity events are buffered for a slow subscriber before
+	// they are dropped.
+	eventsBuffer = 1024
+	// eventsKeepAliveInterval is how often a comment is sent when there is no event, so that
+	// proxies do not close an idle stream.
+	eventsKeepAliveInterval = 15 * time.Second
+)
+
+// activityResult is the result of a cache request reported to event subscribers.
+func activityResult(c *gin.Context, record *accessRecord) string {
+	if len(c.Errors) > 0 {
+		return "error"
+	}
+	return record.Result
+}
+
+// GET /events?kinds=get,put&stats=1s
+//
+// Streams server-sent events of cache activity until the client disconnects, so that
+// dashboards do not need to poll: an event named by the kind carrying a protocol.ActivityEvent
+// for each finished operation, filtered by kinds if set, and a "dropped" event carrying a
+// protocol.EventsDropped if the client does not keep up. With stats, a "stats" event carrying
+// a protocol.StatsResponse is also sent at the interval.
+func (s *Server) handleEvents(c *gin.Context) {
+	var kinds []string
+	if v := c.Query("kinds"); v != "" {
+		kinds = strings.Split(v, ",")
+	}
+	var statsInterval time.Duration
+	if v := c.Query("stats"); v != "" {
+		d, err := time.ParseDuration(v)
+		if err != nil || d <= 0 {
+			c.Error(httperr.Errorf(http.StatusBadRequest, "invalid stats interval %q", v))
+			return
+		}
+		statsInterval = d
+	}
+	log.Info("/events", zap.String("remoteAddr", c.Request.RemoteAddr),
+		zap.Strings("kinds", kinds),
+		zap.Duration("stats", statsInterval))
+
+	sub := inflight.Default.Subscribe(eventsBuffer)
+	defer inflight.Default.Unsubscribe(sub)
+
+	ctx := c.Request.Context()
+	if s.lifecycle != nil {
+		// Subscribers must not hold back graceful shutdown
+		var cancel context.CancelFunc
+		ctx, cancel = context.WithCancel(ctx)
+		defer cancel()
+		stop := context.AfterFunc(s.lifecycle, cancel)
+		defer stop()
+	}
+
+	c.Header("Content-Type", "text/event-stream")
+	c.Header("Cache-Control", "no-cache")
+	c.Status(http.StatusOK)
+	var statsC <-chan time.Time
+	if statsInterval > 0 {
+		c.SSEvent("stats", s.statsResponse())
+		ticker := time.NewTicker(statsInterval)
+		defer ticker.Stop()
+		statsC = ticker.C
+	}
+	c.Writer.Flush()
+	keepAlive := time.NewTicker(eventsKeepAliveInterval)
+	defer keepAlive.Stop()
+	var reportedDropped uint64
+	for {
+		select {
+		case <-ctx.Done():
+			return
+		case event := <-sub.C:
+			if dropped := sub.Dropped(); dropped > reportedDropped {
+				c.SSEvent("dropped", protocol.EventsDropped{Count: dropped - reportedDropped})
+				reportedDropped = dropped
+			}
+			if len(kinds) == 0 || slices.Contains(kinds, event.Kind) {
+				c.SSEvent(event.Kind, protocol.ActivityEvent{
+					Kind:      event.Kind,
+					Detail:    event.Detail,
+					Result:    event.Result,
+					StartedAt: event.StartedAt,
+					Duration:  event.Duration,
+				})
+			}
+		case <-statsC:
+			c.SSEvent("stats", s.statsResponse())
+		case <-keepAlive.C:
+			_, _ = c.Writer.WriteString(": keepalive\n\n")
+		}
+		c.Writer.Flush()
+	}
+}
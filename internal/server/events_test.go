@@ -0,0 +1,76 @@
+package server
+
+import (
+	"context"
+	"net/http"
+	"net/http/httptest"
+	"strconv"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/require"
+
+	"github.com/breezewish/gscache/internal/client"
+	"github.com/breezewish/gscache/internal/inflight"
+	"github.com/breezewish/gscache/internal/protocol"
+)
+
+func TestHandleEvents(t *testing.T) {
+	cfg := DefaultConfig()
+	cfg.Dir = t.TempDir()
+	s, err := NewServer(cfg)
+	require.NoError(t, err)
+	require.NoError(t, s.backend.Open(context.Background()))
+	defer s.backend.Close()
+	ts := httptest.NewServer(s.newRouter())
+	defer ts.Close()
+	port, err := strconv.Atoi(ts.URL[strings.LastIndex(ts.URL, ":")+1:])
+	require.NoError(t, err)
+	c := client.NewClient(client.Config{DaemonPort: port})
+
+	w := httptest.NewRecorder()
+	s.newRouter().ServeHTTP(w, httptest.NewRequest(http.MethodGet, "/events?stats=xyz", nil))
+	require.Equal(t, http.StatusBadRequest, w.Code)
+
+	ctx, cancel := context.WithCancel(context.Background())
+	activities := make(chan protocol.ActivityEvent, 16)
+	statsC := make(chan *protocol.StatsResponse, 16)
+	streamDone := make(chan error, 1)
+	go func() {
+		streamDone <- c.CallEvents(ctx, client.EventsOptions{
+			Kinds:         []string{inflight.KindGet, inflight.KindPut},
+			StatsInterval: time.Hour,
+		}, client.EventHandlers{
+			OnActivity: func(event protocol.ActivityEvent) { activities <- event },
+			OnStats:    func(resp *protocol.StatsResponse) { statsC <- resp },
+		})
+	}()
+
+	// Stats are sent once subscribed
+	st := <-statsC
+	require.NotNil(t, st.Stats)
+
+	// Filtered out
+	inflight.Default.Start(inflight.KindUpload, "xx")()
+	_, err = c.CallPut(protocol.PutRequest{ActionID: []byte{1}, OutputID: []byte{2}}, strings.NewReader(""))
+	require.NoError(t, err)
+	_, err = c.CallGet(protocol.GetRequest{ActionID: []byte{1}})
+	require.NoError(t, err)
+	_, err = c.CallGet(protocol.GetRequest{ActionID: []byte{3}})
+	require.NoError(t, err)
+
+	event := <-activities
+	require.Equal(t, inflight.KindPut, event.Kind)
+	require.Equal(t, "01", event.Detail)
+	require.Equal(t, "stored", event.Result)
+	event = <-activities
+	require.Equal(t, inflight.KindGet, event.Kind)
+	require.Equal(t, "hit", event.Result)
+	event = <-activities
+	require.Equal(t, "03", event.Detail)
+	require.Equal(t, "miss", event.Result)
+
+	cancel()
+	require.NoError(t, <-streamDone)
+}
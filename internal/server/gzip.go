@@ -0,0 +1,73 @@
+package server
+
+import (
+	"compress/gzip"
+	"fmt"
+	"net"
+	"net/http"
+	"strings"
+
+	"github.com/breezewish/gscache/internal/protocol"
+	"github.com/breezewish/gscache/internal/util"
+	"github.com/gin-gonic/gin"
+)
+
+// gzipResponseWriter compresses everything written to the response.
+type gzipResponseWriter struct {
+	gin.ResponseWriter
+	gz *gzip.Writer
+}
+
+func (w *gzipResponseWriter) Write(data []byte) (int, error) {
+	return w.gz.Write(data)
+}
+
+func (w *gzipResponseWriter) WriteString(s string) (int, error) {
+	return w.gz.Write([]byte(s))
+}
+
+// Flush flushes data buffered by the gzip writer, so that streamed responses are not delayed.
+func (w *gzipResponseWriter) Flush() {
+	_ = w.gz.Flush()
+	w.ResponseWriter.Flush()
+}
+
+// isLoopbackRemote returns whether the request comes from the same host, including requests
+// via the unix socket.
+func isLoopbackRemote(r *http.Request) bool {
+	if addr, ok := r.Context().Value(http.LocalAddrContextKey).(net.Addr); ok && addr.Network() == "unix" {
+		return true
+	}
+	return util.IsLoopbackAddr(r.RemoteAddr)
+}
+
+// mGzip is a middleware supports gzip Content-Encoding. Request bodies encoded in gzip are
+// decoded. Responses are gzip encoded if accepted by the client, but only for non-loopback
+// clients, as compression is pure overhead on loopback. It must be installed before other
+// middlewares writing responses, so that they write before the gzip writer is closed.
+func mGzip(c *gin.Context) {
+	if c.GetHeader("Content-Encoding") == "gzip" {
+		gr, err := gzip.NewReader(c.Request.Body)
+		if err != nil {
+			c.AbortWithStatusJSON(http.StatusBadRequest, protocol.ErrorResponse{
+				Error: fmt.Sprintf("invalid gzip body: %v", err),
+			})
+			return
+		}
+		defer gr.Close()
+		c.Request.Body = gr
+		c.Request.Header.Del("Content-Encoding")
+		c.Request.ContentLength = -1
+	}
+
+	if isLoopbackRemote(c.Request) || !strings.Contains(c.GetHeader("Accept-Encoding"), "gzip") {
+		c.Next()
+		return
+	}
+	c.Header("Content-Encoding", "gzip")
+	c.Header("Vary", "Accept-Encoding")
+	gz := gzip.NewWriter(c.Writer)
+	c.Writer = &gzipResponseWriter{ResponseWriter: c.Writer, gz: gz}
+	defer gz.Close()
+	c.Next()
+}
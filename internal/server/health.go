@@ -0,0 +1,75 @@
+package server
+
+import (
+	"fmt"
+	"net/http"
+	"os"
+
+	"github.com/gin-gonic/gin"
+
+	"github.com/breezewish/gscache/internal/cache"
+	"github.com/breezewish/gscache/internal/protocol"
+)
+
+// checkDisk checks whether the work dir is writable, or readable in read-only mode.
+func (s *Server) checkDisk() protocol.HealthCheck {
+	check := protocol.HealthCheck{Name: "disk", OK: true}
+	if s.config.ReadOnly {
+		if _, err := os.ReadDir(s.config.Dir); err != nil {
+			check.OK, check.Error = false, err.Error()
+		}
+		return check
+	}
+	f, err := os.CreateTemp(s.config.Dir, ".healthz-*")
+	if err == nil {
+		_, err = f.Write([]byte("ok"))
+		if closeErr := f.Close(); err == nil {
+			err = closeErr
+		}
+		_ = os.Remove(f.Name())
+	}
+	if err != nil {
+		check.OK, check.Error = false, fmt.Sprintf("work dir is not writable: %v", err)
+	}
+	return check
+}
+
+func respondHealth(c *gin.Context, checks ...protocol.HealthCheck) {
+	resp := protocol.HealthResponse{OK: true, Checks: checks}
+	for _, check := range checks {
+		resp.OK = resp.OK && check.OK
+	}
+	status := http.StatusOK
+	if !resp.OK {
+		status = http.StatusServiceUnavailable
+	}
+	c.JSON(status, resp)
+}
+
+// GET /healthz
+// The daemon is alive if it serves requests and the local disk works. The remote is not
+// checked, so that the daemon is not restarted by orchestrators for an outage of the remote.
+func (s *Server) handleHealthz(c *gin.Context) {
+	respondHealth(c, s.checkDisk())
+}
+
+// GET /readyz
+// The daemon is ready if it is not shutting down, the local disk works, and the remote responds.
+func (s *Server) handleReadyz(c *gin.Context) {
+	checks := []protocol.HealthCheck{s.checkDisk()}
+	if s.lifecycle != nil && s.lifecycle.Err() != nil {
+		checks = append(checks, protocol.HealthCheck{Name: "lifecycle", Error: "shutting down"})
+	}
+	if b, ok := s.backend.(cache.BackendSupportHealth); ok {
+		check := protocol.HealthCheck{Name: "remote", OK: true}
+		respondedAt, err := b.CheckRemote(c.Request.Context())
+		if err != nil {
+			check.OK, check.Error = false, err.Error()
+		}
+		if !respondedAt.IsZero() {
+			check.RespondedAt = &respondedAt
+		}
+		checks = append(checks, check)
+	}
+	respondHealth(c, checks...)
+}
@@ -0,0 +1,72 @@
+package server
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/require"
+
+	"github.com/breezewish/gscache/internal/cache"
+	"github.com/breezewish/gscache/internal/protocol"
+)
+
+type healthTestBackend struct {
+	cache.Backend
+	err error
+}
+
+func (b *healthTestBackend) CheckRemote(ctx context.Context) (time.Time, error) {
+	return time.Date(2025, 1, 1, 0, 0, 0, 0, time.UTC), b.err
+}
+
+func TestHealthz(t *testing.T) {
+	cfg := DefaultConfig()
+	cfg.Dir = t.TempDir()
+	cfg.Auth.Token = "secret"
+	s, err := NewServer(cfg)
+	require.NoError(t, err)
+	router := s.newRouter()
+
+	call := func(path string) (int, protocol.HealthResponse) {
+		w := httptest.NewRecorder()
+		router.ServeHTTP(w, httptest.NewRequest(http.MethodGet, path, nil))
+		var resp protocol.HealthResponse
+		require.NoError(t, json.Unmarshal(w.Body.Bytes(), &resp))
+		return w.Code, resp
+	}
+
+	// No token is needed
+	code, resp := call("/healthz")
+	require.Equal(t, http.StatusOK, code)
+	require.Equal(t, protocol.HealthResponse{OK: true, Checks: []protocol.HealthCheck{{Name: "disk", OK: true}}}, resp)
+	code, _ = call("/readyz")
+	require.Equal(t, http.StatusOK, code)
+	entries, err := os.ReadDir(cfg.Dir)
+	require.NoError(t, err)
+	for _, e := range entries {
+		require.NotContains(t, e.Name(), "healthz")
+	}
+
+	s.backend = &healthTestBackend{Backend: s.backend, err: fmt.Errorf("connection refused")}
+	code, resp = call("/readyz")
+	require.Equal(t, http.StatusServiceUnavailable, code)
+	require.False(t, resp.OK)
+	require.Len(t, resp.Checks, 2)
+	require.Equal(t, "remote", resp.Checks[1].Name)
+	require.Equal(t, "connection refused", resp.Checks[1].Error)
+	require.NotNil(t, resp.Checks[1].RespondedAt)
+	// The remote does not affect liveness
+	code, _ = call("/healthz")
+	require.Equal(t, http.StatusOK, code)
+
+	require.NoError(t, os.RemoveAll(cfg.Dir))
+	code, resp = call("/healthz")
+	require.Equal(t, http.StatusServiceUnavailable, code)
+	require.Contains(t, resp.Checks[0].Error, "work dir is not writable")
+}
@@ -0,0 +1,120 @@
+package server
+
+import (
+	"context"
+	"encoding/json"
+	"net/http"
+	"time"
+
+	"github.com/caarlos0/httperr"
+	"github.com/gin-gonic/gin"
+	"go.uber.org/zap"
+
+	"github.com/breezewish/gscache/internal/log"
+	"github.com/breezewish/gscache/internal/protocol"
+	"github.com/breezewish/gscache/internal/stats"
+)
+
+// requestLimiter bounds requests served at the same time. Requests over the concurrency wait in
+// a bounded queue, and are rejected once the queue is full or the wait times out, so that an
+// overloaded daemon answers fast instead of stalling builds.
+type requestLimiter struct {
+	slots        chan struct{}
+	queue        chan struct{}
+	queueTimeout time.Duration
+}
+
+// newRequestLimiter returns nil if the concurrency is not limited.
+func newRequestLimiter(config RequestLimitConfig) *requestLimiter {
+	if config.Concurrency <= 0 {
+		return nil
+	}
+	return &requestLimiter{
+		slots:        make(chan struct{}, config.Concurrency),
+		queue:        make(chan struct{}, max(config.Queue, 0)),
+		queueTimeout: config.QueueTimeout,
+	}
+}
+
+// Acquire waits for a slot, and returns false if the request should be rejected.
+// Release must be called if it returns true.
+func (l *requestLimiter) Acquire(ctx context.Context) bool {
+	if l == nil {
+		return true
+	}
+	select {
+	case l.slots <- struct{}{}:
+		return true
+	default:
+	}
+	select {
+	case l.queue <- struct{}{}:
+	default:
+		return false
+	}
+	defer func() { <-l.queue }()
+	var timeout <-chan time.Time
+	if l.queueTimeout > 0 {
+		timer := time.NewTimer(l.queueTimeout)
+		defer timer.Stop()
+		timeout = timer.C
+	}
+	select {
+	case l.slots <- struct{}{}:
+		return true
+	case <-timeout:
+		return false
+	case <-ctx.Done():
+		return false
+	}
+}
+
+func (l *requestLimiter) Release() {
+	if l != nil {
+		<-l.slots
+	}
+}
+
+// mLimitGet is a middleware serves Get requests over limits.get as misses.
+func (s *Server) mLimitGet(c *gin.Context) {
+	if !s.getLimiter.Acquire(c.Request.Context()) {
+		stats.Default.GetRejected.Inc()
+		record := accessRecordOf(c)
+		record.Rejected = true
+		if s.config.AccessLog.Enabled {
+			// The handler is skipped, so the request is only decoded for the access log
+			if req, err := decodeGet(c.GetHeader("Content-Type"), c.Request.Body); err == nil {
+				record.ActionID, record.Namespace = req.ActionID, req.Namespace
+			}
+		}
+		log.Debug("Get is rejected by limits.get", zap.String("remoteAddr", c.Request.RemoteAddr))
+		c.AbortWithStatusJSON(http.StatusOK, &protocol.GetResponse{Miss: true})
+		return
+	}
+	defer s.getLimiter.Release()
+	c.Next()
+}
+
+// mLimitPut is a middleware fails Put requests over limits.put with 503 on purpose, instead of
+// making the build wait for an overloaded daemon. The go command does not fail the build for a
+// failed Put, the output is just not cached.
+func (s *Server) mLimitPut(c *gin.Context) {
+	if !s.putLimiter.Acquire(c.Request.Context()) {
+		stats.Default.PutRejected.Inc()
+		record := accessRecordOf(c)
+		record.Rejected = true
+		if s.config.AccessLog.Enabled {
+			// The handler is skipped, so only the request JSON before the body is decoded for
+			// the access log
+			var req protocol.PutRequest
+			if err := json.NewDecoder(c.Request.Body).Decode(&req); err == nil {
+				record.ActionID, record.Namespace = req.ActionID, req.Namespace
+			}
+		}
+		c.Error(httperr.Errorf(http.StatusServiceUnavailable, "too many concurrent puts, exceeding limits.put"))
+		c.Abort()
+		return
+	}
+	defer s.putLimiter.Release()
+	c.Next()
+}
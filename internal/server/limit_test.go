@@ -0,0 +1,103 @@
+package server
+
+import (
+	"context"
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/require"
+
+	"github.com/breezewish/gscache/internal/protocol"
+)
+
+func TestRequestLimiter(t *testing.T) {
+	var unlimited *requestLimiter
+	require.Nil(t, newRequestLimiter(RequestLimitConfig{Queue: 1}))
+	require.True(t, unlimited.Acquire(context.Background()))
+	unlimited.Release()
+
+	l := newRequestLimiter(RequestLimitConfig{Concurrency: 1, Queue: 1, QueueTimeout: 50 * time.Millisecond})
+	require.True(t, l.Acquire(context.Background()))
+
+	// Queued until timeout
+	start := time.Now()
+	require.False(t, l.Acquire(context.Background()))
+	require.GreaterOrEqual(t, time.Since(start), 50*time.Millisecond)
+
+	// Queued until released
+	acquired := make(chan bool)
+	go func() { acquired <- l.Acquire(context.Background()) }()
+	require.Eventually(t, func() bool { return len(l.queue) == 1 }, time.Second, time.Millisecond)
+	// The queue is full
+	require.False(t, l.Acquire(context.Background()))
+	l.Release()
+	require.True(t, <-acquired)
+
+	// Cancelled while queued
+	ctx, cancel := context.WithCancel(context.Background())
+	cancel()
+	require.False(t, l.Acquire(ctx))
+	l.Release()
+	require.True(t, l.Acquire(context.Background()))
+	l.Release()
+
+	// Rejected immediately without a queue
+	l = newRequestLimiter(RequestLimitConfig{Concurrency: 1, QueueTimeout: time.Hour})
+	require.True(t, l.Acquire(context.Background()))
+	require.False(t, l.Acquire(context.Background()))
+}
+
+func TestLimits(t *testing.T) {
+	cfg := DefaultConfig()
+	cfg.Dir = t.TempDir()
+	cfg.Limits.Get = RequestLimitConfig{Concurrency: 1}
+	cfg.Limits.Put = RequestLimitConfig{Concurrency: 1}
+	s, err := NewServer(cfg)
+	require.NoError(t, err)
+	require.NoError(t, s.backend.Open(context.Background()))
+	defer s.backend.Close()
+	router := s.newRouter()
+
+	get := func() *httptest.ResponseRecorder {
+		req := httptest.NewRequest(http.MethodPost, "/cacheprog/get", strings.NewReader(`{"ActionID":"AQ=="}`))
+		req.Header.Set("Content-Type", "application/json")
+		w := httptest.NewRecorder()
+		router.ServeHTTP(w, req)
+		return w
+	}
+	put := func() *httptest.ResponseRecorder {
+		req := httptest.NewRequest(http.MethodPost, "/cacheprog/put",
+			strings.NewReader(`{"ActionID":"AQ==","OutputID":"Ag==","BodySize":0}`))
+		w := httptest.NewRecorder()
+		router.ServeHTTP(w, req)
+		return w
+	}
+
+	require.Equal(t, http.StatusOK, put().Code)
+	w := get()
+	require.Equal(t, http.StatusOK, w.Code)
+	var resp protocol.GetResponse
+	require.NoError(t, json.Unmarshal(w.Body.Bytes(), &resp))
+	require.False(t, resp.Miss)
+
+	// All slots are taken
+	require.True(t, s.getLimiter.Acquire(context.Background()))
+	require.True(t, s.putLimiter.Acquire(context.Background()))
+	w = get()
+	require.Equal(t, http.StatusOK, w.Code)
+	require.NoError(t, json.Unmarshal(w.Body.Bytes(), &resp))
+	require.True(t, resp.Miss)
+	require.Equal(t, http.StatusServiceUnavailable, put().Code)
+
+	s.getLimiter.Release()
+	s.putLimiter.Release()
+	require.Equal(t, http.StatusOK, put().Code)
+	w = get()
+	resp = protocol.GetResponse{}
+	require.NoError(t, json.Unmarshal(w.Body.Bytes(), &resp))
+	require.False(t, resp.Miss)
+}
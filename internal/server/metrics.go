@@ -0,0 +1,113 @@
+package server
+
+import (
+	"bytes"
+	"fmt"
+	"io"
+	"net/http"
+	"slices"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/gin-gonic/gin"
+
+	"github.com/breezewish/gscache/internal/stats"
+)
+
+// latencyBuckets are upper bounds in seconds of request latency histograms. Most requests
+// are served locally within milliseconds, while remote downloads and maintenance calls may
+// take seconds.
+var latencyBuckets = []float64{0.001, 0.0025, 0.005, 0.01, 0.025, 0.05, 0.1, 0.25, 0.5, 1, 2.5, 5, 10, 30}
+
+const latencyMetricName = "gscache_http_request_duration_seconds"
+
+type latencyKey struct {
+	method string
+	route  string // Route pattern like "/cacheprog/get", so that labels are bounded
+}
+
+type latencyHistogram struct {
+	counts []uint64 // Per bucket of latencyBuckets, not cumulative
+	count  uint64
+	sum    float64
+}
+
+// latencyMetrics are request latency histograms of the HTTP server. Unlike counters in
+// internal/stats, they are not persisted, as latencies of previous runs are not comparable.
+type latencyMetrics struct {
+	mu         sync.Mutex
+	histograms map[latencyKey]*latencyHistogram
+}
+
+func newLatencyMetrics() *latencyMetrics {
+	return &latencyMetrics{histograms: make(map[latencyKey]*latencyHistogram)}
+}
+
+func (m *latencyMetrics) Observe(method, route string, d time.Duration) {
+	seconds := d.Seconds()
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	key := latencyKey{method: method, route: route}
+	h := m.histograms[key]
+	if h == nil {
+		h = &latencyHistogram{counts: make([]uint64, len(latencyBuckets))}
+		m.histograms[key] = h
+	}
+	if i, _ := slices.BinarySearch(latencyBuckets, seconds); i < len(latencyBuckets) {
+		h.counts[i]++
+	}
+	h.count++
+	h.sum += seconds
+}
+
+// WritePrometheus writes histograms in the Prometheus text exposition format.
+func (m *latencyMetrics) WritePrometheus(w io.Writer) error {
+	m.mu.Lock()
+	keys := make([]latencyKey, 0, len(m.histograms))
+	for key := range m.histograms {
+		keys = append(keys, key)
+	}
+	slices.SortFunc(keys, func(a, b latencyKey) int {
+		return strings.Compare(a.route+" "+a.method, b.route+" "+b.method)
+	})
+	var b strings.Builder
+	fmt.Fprintf(&b, "# TYPE %s histogram\n", latencyMetricName)
+	for _, key := range keys {
+		h := m.histograms[key]
+		labels := fmt.Sprintf("method=%q,route=%q", key.method, key.route)
+		cumulative := uint64(0)
+		for i, le := range latencyBuckets {
+			cumulative += h.counts[i]
+			fmt.Fprintf(&b, "%s_bucket{%s,le=%q} %d\n", latencyMetricName, labels,
+				strconv.FormatFloat(le, 'f', -1, 64), cumulative)
+		}
+		fmt.Fprintf(&b, "%s_bucket{%s,le=\"+Inf\"} %d\n", latencyMetricName, labels, h.count)
+		fmt.Fprintf(&b, "%s_sum{%s} %s\n", latencyMetricName, labels, strconv.FormatFloat(h.sum, 'f', -1, 64))
+		fmt.Fprintf(&b, "%s_count{%s} %d\n", latencyMetricName, labels, h.count)
+	}
+	m.mu.Unlock()
+	_, err := io.WriteString(w, b.String())
+	return err
+}
+
+// mLatency is a middleware records the latency of each request to a known route.
+func (s *Server) mLatency(c *gin.Context) {
+	start := time.Now()
+	c.Next()
+	if route := c.FullPath(); route != "" {
+		s.latency.Observe(c.Request.Method, route, time.Since(start))
+	}
+}
+
+// GET /metrics
+func (s *Server) handleMetrics(c *gin.Context) {
+	var buf bytes.Buffer
+	if err := stats.Default.Export(&buf, stats.FormatPrometheus); err != nil {
+		_ = c.Error(err)
+		return
+	}
+	_ = s.latency.WritePrometheus(&buf)
+	c.Data(http.StatusOK, "text/plain; version=0.0.4; charset=utf-8", buf.Bytes())
+}
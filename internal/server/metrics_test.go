@@ -0,0 +1,52 @@
+package server
+
+import (
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/require"
+)
+
+func TestLatencyMetrics(t *testing.T) {
+	m := newLatencyMetrics()
+	m.Observe("POST", "/cacheprog/get", 3*time.Millisecond)
+	m.Observe("POST", "/cacheprog/get", 2*time.Second)
+	m.Observe("POST", "/cacheprog/get", time.Minute)
+
+	var b strings.Builder
+	require.NoError(t, m.WritePrometheus(&b))
+	out := b.String()
+	labels := `method="POST",route="/cacheprog/get"`
+	require.Contains(t, out, "# TYPE gscache_http_request_duration_seconds histogram\n")
+	require.Contains(t, out, `gscache_http_request_duration_seconds_bucket{`+labels+`,le="0.0025"} 0`+"\n")
+	require.Contains(t, out, `gscache_http_request_duration_seconds_bucket{`+labels+`,le="0.005"} 1`+"\n")
+	require.Contains(t, out, `gscache_http_request_duration_seconds_bucket{`+labels+`,le="2.5"} 2`+"\n")
+	require.Contains(t, out, `gscache_http_request_duration_seconds_bucket{`+labels+`,le="30"} 2`+"\n")
+	require.Contains(t, out, `gscache_http_request_duration_seconds_bucket{`+labels+`,le="+Inf"} 3`+"\n")
+	require.Contains(t, out, `gscache_http_request_duration_seconds_sum{`+labels+`} 62.003`+"\n")
+	require.Contains(t, out, `gscache_http_request_duration_seconds_count{`+labels+`} 3`+"\n")
+}
+
+func TestHandleMetrics(t *testing.T) {
+	cfg := DefaultConfig()
+	cfg.Dir = t.TempDir()
+	s, err := NewServer(cfg)
+	require.NoError(t, err)
+	router := s.newRouter()
+
+	router.ServeHTTP(httptest.NewRecorder(), httptest.NewRequest(http.MethodGet, "/ping", nil))
+	router.ServeHTTP(httptest.NewRecorder(), httptest.NewRequest(http.MethodGet, "/no_such_route", nil))
+
+	w := httptest.NewRecorder()
+	router.ServeHTTP(w, httptest.NewRequest(http.MethodGet, "/metrics", nil))
+	require.Equal(t, http.StatusOK, w.Code)
+	require.Contains(t, w.Header().Get("Content-Type"), "text/plain")
+	out := w.Body.String()
+	require.Contains(t, out, "# TYPE gscache_get_total counter\n")
+	require.Contains(t, out, "# TYPE gscache_get_hit_ratio gauge\n")
+	require.Contains(t, out, `gscache_http_request_duration_seconds_count{method="GET",route="/ping"} 1`+"\n")
+	require.NotContains(t, out, "no_such_route")
+}
@@ -0,0 +1,28 @@
+package server
+
+import (
+	"net/http/pprof"
+	"strings"
+
+	"github.com/gin-gonic/gin"
+)
+
+// GET /debug/pprof/*name, POST /debug/pprof/symbol
+//
+// Serves net/http/pprof, so that `go tool pprof http://127.0.0.1:<port>/debug/pprof/profile`
+// captures profiles of the daemon. Only registered if debug.pprof is set.
+func (s *Server) handlePprof(c *gin.Context) {
+	switch strings.TrimPrefix(c.Request.URL.Path, "/debug/pprof") {
+	case "/cmdline":
+		pprof.Cmdline(c.Writer, c.Request)
+	case "/profile":
+		pprof.Profile(c.Writer, c.Request)
+	case "/symbol":
+		pprof.Symbol(c.Writer, c.Request)
+	case "/trace":
+		pprof.Trace(c.Writer, c.Request)
+	default:
+		// Index serves named profiles like /heap as well
+		pprof.Index(c.Writer, c.Request)
+	}
+}
@@ -0,0 +1,75 @@
+package server
+
+import (
+	"context"
+	"net/http"
+	"time"
+
+	"github.com/gin-gonic/gin"
+	"go.uber.org/zap"
+
+	"github.com/breezewish/gscache/internal/log"
+	"github.com/breezewish/gscache/internal/progress"
+	"github.com/breezewish/gscache/internal/protocol"
+)
+
+// progressInterval is how often progress of a long running request is checked and sent.
+const progressInterval = 200 * time.Millisecond
+
+// respondWithProgress runs a long running operation and responds with its result as JSON.
+// With ?progress=true, the response is a stream of server-sent events instead: "progress"
+// events carrying a progress.Progress whenever it changes, then a "result" event carrying the
+// result, or an "error" event carrying a protocol.ErrorResponse.
+func respondWithProgress(c *gin.Context, run func(ctx context.Context) (any, error)) {
+	if c.Query("progress") != "true" {
+		resp, err := run(c.Request.Context())
+		if err != nil {
+			c.Error(err)
+			return
+		}
+		c.JSON(http.StatusOK, resp)
+		return
+	}
+
+	tracker := progress.NewTracker()
+	type result struct {
+		resp any
+		err  error
+	}
+	done := make(chan result, 1)
+	go func() {
+		resp, err := run(progress.NewContext(c.Request.Context(), tracker))
+		done <- result{resp, err}
+	}()
+
+	c.Header("Content-Type", "text/event-stream")
+	c.Header("Cache-Control", "no-cache")
+	c.Status(http.StatusOK)
+	c.Writer.Flush()
+	ticker := time.NewTicker(progressInterval)
+	defer ticker.Stop()
+	var lastVersion uint64
+	for {
+		select {
+		case r := <-done:
+			if r.err != nil {
+				// The status is already sent, so the error is reported as an event
+				log.Error("Request failed",
+					zap.String("remoteAddr", c.Request.RemoteAddr),
+					zap.String("path", c.Request.URL.Path),
+					zap.Error(r.err))
+				c.SSEvent("error", protocol.ErrorResponse{Error: r.err.Error()})
+			} else {
+				c.SSEvent("result", r.resp)
+			}
+			c.Writer.Flush()
+			return
+		case <-ticker.C:
+			if p, version := tracker.Snapshot(); version != lastVersion {
+				lastVersion = version
+				c.SSEvent("progress", p)
+				c.Writer.Flush()
+			}
+		}
+	}
+}
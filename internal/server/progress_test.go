@@ -0,0 +1,64 @@
+package server
+
+import (
+	"context"
+	"fmt"
+	"net/http/httptest"
+	"strconv"
+	"strings"
+	"sync/atomic"
+	"testing"
+	"time"
+
+	"github.com/gin-gonic/gin"
+	"github.com/stretchr/testify/require"
+
+	"github.com/breezewish/gscache/internal/client"
+	"github.com/breezewish/gscache/internal/progress"
+	"github.com/breezewish/gscache/internal/protocol"
+)
+
+func TestRespondWithProgress(t *testing.T) {
+	gin.SetMode(gin.ReleaseMode)
+	router := gin.New()
+	router.Use(mCatchError)
+	var fail atomic.Bool
+	router.POST("/warm", func(c *gin.Context) {
+		respondWithProgress(c, func(ctx context.Context) (any, error) {
+			tracker := progress.FromContext(ctx)
+			tracker.SetStage("archives", 2, 0)
+			tracker.Add(1, 10)
+			time.Sleep(3 * progressInterval)
+			tracker.Add(1, 10)
+			if fail.Load() {
+				return nil, fmt.Errorf("access denied")
+			}
+			return &protocol.WarmResponse{ArchivesLoaded: 2}, nil
+		})
+	})
+	ts := httptest.NewServer(router)
+	defer ts.Close()
+	port, err := strconv.Atoi(ts.URL[strings.LastIndex(ts.URL, ":")+1:])
+	require.NoError(t, err)
+	c := client.NewClient(client.Config{DaemonPort: port})
+
+	// Without progress
+	resp, err := c.CallWarm(protocol.WarmRequest{}, nil)
+	require.NoError(t, err)
+	require.Equal(t, 2, resp.ArchivesLoaded)
+
+	var updates []progress.Progress
+	resp, err = c.CallWarm(protocol.WarmRequest{}, func(p progress.Progress) {
+		updates = append(updates, p)
+	})
+	require.NoError(t, err)
+	require.Equal(t, 2, resp.ArchivesLoaded)
+	require.NotEmpty(t, updates)
+	require.Equal(t, progress.Progress{Stage: "archives", Done: 1, Total: 2, Bytes: 10}, updates[0])
+
+	fail.Store(true)
+	_, err = c.CallWarm(protocol.WarmRequest{}, func(p progress.Progress) {})
+	require.ErrorContains(t, err, "access denied")
+	_, err = c.CallWarm(protocol.WarmRequest{}, nil)
+	require.ErrorContains(t, err, "access denied")
+}
@@ -0,0 +1,169 @@
+package server
+
+import (
+	"fmt"
+	"net/http"
+	"os"
+	"reflect"
+	"slices"
+	"sort"
+
+	"github.com/caarlos0/httperr"
+	"github.com/gin-gonic/gin"
+	"github.com/knadh/koanf/providers/structs"
+	"github.com/knadh/koanf/v2"
+	"go.uber.org/zap"
+
+	"github.com/breezewish/gscache/internal/cache"
+	"github.com/breezewish/gscache/internal/log"
+	"github.com/breezewish/gscache/internal/protocol"
+	"github.com/breezewish/gscache/internal/util"
+)
+
+// reloadableConfigKeys are config keys applied by Reload. Changes of other keys require a restart.
+var reloadableConfigKeys = []string{
+	"log.level",
+	"blob.upload_concurrency",
+	"gc.max_age",
+	"gc.max_bytes",
+}
+
+// SetConfigLoader sets how the config is re-read on reload, usually the same way as it is
+// loaded at start. Reload is not supported if it is not set.
+func (s *Server) SetConfigLoader(load func() (Config, error)) {
+	s.loadConfig = load
+}
+
+// currentConfig returns a copy of the config, which may be changed by Reload concurrently.
+func (s *Server) currentConfig() Config {
+	s.configMu.Lock()
+	defer s.configMu.Unlock()
+	return s.config
+}
+
+// flattenConfig returns config values keyed like "log.level", the same as keys in the config file.
+func flattenConfig(config Config) (map[string]any, error) {
+	k := koanf.New(".")
+	if err := k.Load(structs.Provider(config, "json"), nil); err != nil {
+		return nil, err
+	}
+	return k.All(), nil
+}
+
+// diffConfig returns changed config keys sorted by key. Values of sensitive keys are redacted.
+func diffConfig(oldConfig, newConfig Config) ([]protocol.ConfigChange, error) {
+	oldValues, err := flattenConfig(oldConfig)
+	if err != nil {
+		return nil, err
+	}
+	newValues, err := flattenConfig(newConfig)
+	if err != nil {
+		return nil, err
+	}
+	oldShown, err := flattenConfig(util.Redact(oldConfig))
+	if err != nil {
+		return nil, err
+	}
+	newShown, err := flattenConfig(util.Redact(newConfig))
+	if err != nil {
+		return nil, err
+	}
+	keys := make([]string, 0, len(newValues))
+	for key := range newValues {
+		keys = append(keys, key)
+	}
+	for key := range oldValues {
+		if _, ok := newValues[key]; !ok {
+			keys = append(keys, key)
+		}
+	}
+	sort.Strings(keys)
+
+	changes := make([]protocol.ConfigChange, 0)
+	for _, key := range keys {
+		oldValue, newValue := oldValues[key], newValues[key]
+		if reflect.DeepEqual(oldValue, newValue) {
+			continue
+		}
+		changes = append(changes, protocol.ConfigChange{
+			Key:             key,
+			Old:             fmt.Sprint(oldShown[key]),
+			New:             fmt.Sprint(newShown[key]),
+			RequiresRestart: !slices.Contains(reloadableConfigKeys, key),
+		})
+	}
+	return changes, nil
+}
+
+// Reload re-reads the config and applies changes which do not require a restart. Nothing is
+// applied if the new config is invalid.
+func (s *Server) Reload() (*protocol.ConfigReloadResponse, error) {
+	if s.loadConfig == nil {
+		return nil, fmt.Errorf("config reload is not supported")
+	}
+	newConfig, err := s.loadConfig()
+	if err != nil {
+		return nil, fmt.Errorf("failed to load config: %w", err)
+	}
+
+	s.configMu.Lock()
+	defer s.configMu.Unlock()
+	changes, err := diffConfig(s.config, newConfig)
+	if err != nil {
+		return nil, err
+	}
+	if newConfig.Blob.UploadConcurrency <= 0 {
+		return nil, fmt.Errorf("blob.upload_concurrency must be positive, got %d", newConfig.Blob.UploadConcurrency)
+	}
+	if newConfig.Log.Level != s.config.Log.Level {
+		if err := log.SetLevel(newConfig.Log.Level); err != nil {
+			return nil, fmt.Errorf("invalid log.level: %w", err)
+		}
+		s.config.Log.Level = newConfig.Log.Level
+	}
+	if newConfig.Blob.UploadConcurrency != s.config.Blob.UploadConcurrency {
+		if b, ok := s.backend.(cache.BackendSupportUploadConcurrency); ok {
+			if err := b.SetUploadConcurrency(newConfig.Blob.UploadConcurrency); err != nil {
+				log.Warn("Failed to change upload concurrency", zap.Error(err))
+			}
+		}
+		s.config.Blob.UploadConcurrency = newConfig.Blob.UploadConcurrency
+	}
+	s.config.GC = newConfig.GC
+
+	for _, change := range changes {
+		log.Info("Config changed",
+			zap.String("key", change.Key),
+			zap.String("old", change.Old),
+			zap.String("new", change.New),
+			zap.Bool("requiresRestart", change.RequiresRestart))
+	}
+	log.Info("Config reloaded", zap.Int("changes", len(changes)))
+	return &protocol.ConfigReloadResponse{Changes: changes}, nil
+}
+
+// reloadOnSignal reloads the config on each signal until the server is stopped.
+func (s *Server) reloadOnSignal(sigCh <-chan os.Signal) {
+	for {
+		select {
+		case <-s.lifecycle.Done():
+			return
+		case sig := <-sigCh:
+			log.Info("Received reload signal", zap.String("signal", sig.String()))
+			if _, err := s.Reload(); err != nil {
+				log.Error("Failed to reload config", zap.Error(err))
+			}
+		}
+	}
+}
+
+// POST /config/reload
+func (s *Server) handleConfigReload(c *gin.Context) {
+	log.Info("/config/reload", zap.String("remoteAddr", c.Request.RemoteAddr))
+	resp, err := s.Reload()
+	if err != nil {
+		c.Error(httperr.Errorf(http.StatusBadRequest, "%v", err))
+		return
+	}
+	c.JSON(http.StatusOK, resp)
+}
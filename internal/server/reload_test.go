@@ -0,0 +1,130 @@
+package server
+
+import (
+	"bytes"
+	"encoding/json"
+	"fmt"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/require"
+
+	"github.com/breezewish/gscache/internal/cache"
+	"github.com/breezewish/gscache/internal/log"
+	"github.com/breezewish/gscache/internal/protocol"
+	"github.com/breezewish/gscache/internal/util"
+)
+
+type reloadTestBackend struct {
+	cache.Backend
+	uploadConcurrency int
+	gcReq             protocol.GCRequest
+}
+
+func (b *reloadTestBackend) GC(req protocol.GCRequest) (*protocol.GCResponse, error) {
+	b.gcReq = req
+	return &protocol.GCResponse{}, nil
+}
+
+func (b *reloadTestBackend) SetUploadConcurrency(n int) error {
+	b.uploadConcurrency = n
+	return nil
+}
+
+func TestDiffConfig(t *testing.T) {
+	oldConfig := DefaultConfig()
+	changes, err := diffConfig(oldConfig, oldConfig)
+	require.NoError(t, err)
+	require.Empty(t, changes)
+
+	newConfig := oldConfig
+	newConfig.Log.Level = "debug"
+	newConfig.Port = 9999
+	newConfig.Auth.Token = "secret"
+	changes, err = diffConfig(oldConfig, newConfig)
+	require.NoError(t, err)
+	require.Equal(t, []protocol.ConfigChange{
+		{Key: "auth.token", Old: "", New: util.RedactedValue, RequiresRestart: true},
+		{Key: "log.level", Old: "info", New: "debug"},
+		{Key: "port", Old: fmt.Sprint(oldConfig.Port), New: "9999", RequiresRestart: true},
+	}, changes)
+}
+
+func TestReload(t *testing.T) {
+	defer func() { _ = log.SetLevel("info") }()
+
+	cfg := DefaultConfig()
+	cfg.Dir = t.TempDir()
+	s, err := NewServer(cfg)
+	require.NoError(t, err)
+	backend := &reloadTestBackend{Backend: s.backend}
+	s.backend = backend
+	router := s.newRouter()
+
+	call := func() (int, []byte) {
+		w := httptest.NewRecorder()
+		router.ServeHTTP(w, httptest.NewRequest(http.MethodPost, "/config/reload", nil))
+		return w.Code, w.Body.Bytes()
+	}
+
+	code, _ := call()
+	require.Equal(t, http.StatusBadRequest, code)
+
+	newConfig := cfg
+	s.SetConfigLoader(func() (Config, error) { return newConfig, nil })
+	code, body := call()
+	require.Equal(t, http.StatusOK, code)
+	var resp protocol.ConfigReloadResponse
+	require.NoError(t, json.Unmarshal(body, &resp))
+	require.Empty(t, resp.Changes)
+
+	newConfig.Log.Level = "debug"
+	newConfig.Blob.UploadConcurrency = 3
+	newConfig.GC.MaxAge = time.Hour
+	newConfig.Port = 9999
+	code, body = call()
+	require.Equal(t, http.StatusOK, code)
+	require.NoError(t, json.Unmarshal(body, &resp))
+	require.Len(t, resp.Changes, 4)
+	require.Equal(t, 3, backend.uploadConcurrency)
+	applied := s.currentConfig()
+	require.Equal(t, "debug", applied.Log.Level)
+	require.Equal(t, 3, applied.Blob.UploadConcurrency)
+	require.Equal(t, time.Hour, applied.GC.MaxAge)
+	require.Equal(t, cfg.Port, applied.Port)
+
+	// An empty gc request trims by the reloaded gc config
+	gc := func(req protocol.GCRequest) {
+		data, err := json.Marshal(req)
+		require.NoError(t, err)
+		w := httptest.NewRecorder()
+		router.ServeHTTP(w, httptest.NewRequest(http.MethodPost, "/gc", bytes.NewReader(data)))
+		require.Equal(t, http.StatusOK, w.Code)
+	}
+	gc(protocol.GCRequest{})
+	require.Equal(t, protocol.GCRequest{MaxAge: time.Hour, MaxBytes: cfg.GC.MaxBytes}, backend.gcReq)
+	gc(protocol.GCRequest{MaxBytes: 100})
+	require.Equal(t, protocol.GCRequest{MaxBytes: 100}, backend.gcReq)
+
+	// The port still requires a restart
+	reloaded, err := s.Reload()
+	require.NoError(t, err)
+	require.Equal(t, []protocol.ConfigChange{
+		{Key: "port", Old: fmt.Sprint(cfg.Port), New: "9999", RequiresRestart: true},
+	}, reloaded.Changes)
+
+	// Nothing is applied if the new config is invalid
+	newConfig.Log.Level = "verbose"
+	newConfig.GC.MaxAge = 2 * time.Hour
+	code, _ = call()
+	require.Equal(t, http.StatusBadRequest, code)
+	require.Equal(t, time.Hour, s.currentConfig().GC.MaxAge)
+
+	newConfig.Log.Level = "debug"
+	newConfig.Blob.UploadConcurrency = 0
+	_, err = s.Reload()
+	require.Error(t, err)
+	require.Equal(t, 3, backend.uploadConcurrency)
+}
@@ -1,35 +1,88 @@
 package server
 
 import (
-	"bufio"
 	"bytes"
-	"encoding/base64"
+	"context"
+	"crypto/sha256"
+	"crypto/subtle"
+	"encoding/hex"
 	"encoding/json"
+	"errors"
 	"fmt"
 	"io"
+	"mime"
 	"net/http"
 	"os"
+	"strconv"
+	"strings"
+	"time"
 
 	"github.com/breezewish/gscache/internal/cache"
+	"github.com/breezewish/gscache/internal/inflight"
 	"github.com/breezewish/gscache/internal/log"
 	"github.com/breezewish/gscache/internal/protocol"
 	"github.com/breezewish/gscache/internal/stats"
+	"github.com/breezewish/gscache/internal/tracing"
+	"github.com/breezewish/gscache/internal/util"
 	"github.com/caarlos0/httperr"
 	"github.com/gin-gonic/gin"
+	"go.opentelemetry.io/otel/attribute"
+	"go.opentelemetry.io/otel/codes"
 	"go.uber.org/zap"
+	"golang.org/x/sync/errgroup"
+)
+
+// existsBatchConcurrency bounds concurrent checks of a single ExistsBatch request,
+// as each check may be a HEAD request to the remote.
+const existsBatchConcurrency = 32
+
+const (
+	defaultLogLines       = 10
+	logFollowPollInterval = 200 * time.Millisecond
 )
 
 func (s *Server) newRouter() *gin.Engine {
 	gin.SetMode(gin.ReleaseMode)
 	router := gin.New()
 	router.Use(gin.Recovery())
+	router.Use(mGzip)
+	router.Use(mTrace)
+	router.Use(s.mLatency)
 	router.Use(mCatchError)
 
+	// Registered before the auth middleware, so that orchestrators can probe without the token
+	router.GET("/healthz", s.handleHealthz)
+	router.GET("/readyz", s.handleReadyz)
+	if s.config.Auth.Token != "" {
+		router.Use(s.mAuth)
+	}
+
 	router.GET("/ping", s.handlePing)
 	router.POST("/shutdown", s.handleShutdown)
+	router.GET("/status", s.handleStatus)
+	router.GET("/stats", s.handleStats)
 	router.POST("/stats/clear", s.handleStatsClear)
-	router.POST("/cacheprog/put", s.mMarkActive, s.handleCachePut)
-	router.POST("/cacheprog/get", s.mMarkActive, s.handleCacheGet)
+	router.GET("/metrics", s.handleMetrics)
+	router.GET("/logs", s.handleLogs)
+	router.GET("/inflight", s.handleInFlight)
+	router.GET("/events", s.handleEvents)
+	router.GET("/size", s.handleSize)
+	router.POST("/gc", s.handleGC)
+	router.POST("/config/reload", s.handleConfigReload)
+	router.POST("/warm", s.mMarkActive, s.handleWarm)
+	router.POST("/prefetch", s.mMarkActive, s.handlePrefetch)
+	router.POST("/compact", s.handleCompact)
+	router.POST("/cacheprog/put", s.mMarkActive, s.mAccessLog, s.mLimitPut, s.handleCachePut)
+	router.POST("/cacheprog/get", s.mMarkActive, s.mAccessLog, s.mLimitGet, s.handleCacheGet)
+	router.POST("/cacheprog/exists_batch", s.mMarkActive, s.handleCacheExistsBatch)
+	router.DELETE("/cacheprog/entry", s.handleCacheDelete)
+	if s.config.UI.Enabled {
+		router.GET("/", s.handleUI)
+	}
+	if s.config.Debug.Pprof {
+		router.GET("/debug/pprof/*name", s.handlePprof)
+		router.POST("/debug/pprof/symbol", s.handlePprof)
+	}
 
 	return router
 }
@@ -43,6 +96,74 @@ func (s *Server) mMarkActive(c *gin.Context) {
 	c.Next()
 }
 
+// authCookieName is the cookie authenticating the browser UI, which cannot send the header.
+const authCookieName = "gscache_auth"
+
+// mAuth is a middleware rejects requests without the configured bearer token. The browser UI
+// logs in by opening /?token=<token> once, which sets an HttpOnly cookie and redirects to /.
+// The cookie is only accepted for GET requests, so that other sites cannot change anything
+// via a logged in browser.
+func (s *Server) mAuth(c *gin.Context) {
+	token, ok := strings.CutPrefix(c.GetHeader("Authorization"), "Bearer ")
+	if ok && s.isValidToken(token) {
+		c.Next()
+		return
+	}
+	if c.Request.Method == http.MethodGet {
+		if cookie, err := c.Cookie(authCookieName); err == nil &&
+			subtle.ConstantTimeCompare([]byte(cookie), []byte(s.authCookieValue())) == 1 {
+			c.Next()
+			return
+		}
+		if s.config.UI.Enabled && c.Request.URL.Path == "/" && s.isValidToken(c.Query("token")) {
+			http.SetCookie(c.Writer, &http.Cookie{
+				Name:     authCookieName,
+				Value:    s.authCookieValue(),
+				Path:     "/",
+				HttpOnly: true,
+				Secure:   c.Request.TLS != nil,
+				SameSite: http.SameSiteStrictMode,
+			})
+			// Removes the token from the address bar and the history
+			c.Redirect(http.StatusSeeOther, "/")
+			c.Abort()
+			return
+		}
+	}
+	c.Header("WWW-Authenticate", `Bearer realm="gscache"`)
+	if s.config.UI.Enabled && c.Request.URL.Path == "/" {
+		_ = c.Error(httperr.Errorf(http.StatusUnauthorized, "missing or invalid auth token, open /?token=<auth.token> to log in"))
+	} else {
+		_ = c.Error(httperr.Errorf(http.StatusUnauthorized, "missing or invalid auth token"))
+	}
+	c.Abort()
+}
+
+func (s *Server) isValidToken(token string) bool {
+	return token != "" && subtle.ConstantTimeCompare([]byte(token), []byte(s.config.Auth.Token)) == 1
+}
+
+// authCookieValue is derived from the token instead of being the token itself, which may
+// contain characters not allowed in cookies. It changes when the token changes.
+func (s *Server) authCookieValue() string {
+	sum := sha256.Sum256([]byte("gscache-ui:" + s.config.Auth.Token))
+	return hex.EncodeToString(sum[:])
+}
+
+// mTrace is a middleware starts a tracing span for each request.
+func mTrace(c *gin.Context) {
+	ctx, span := tracing.Start(c.Request.Context(), c.Request.Method+" "+c.FullPath(),
+		attribute.String("http.path", c.Request.URL.Path))
+	defer span.End()
+	c.Request = c.Request.WithContext(ctx)
+	c.Next()
+	span.SetAttributes(attribute.Int("http.status", c.Writer.Status()))
+	if len(c.Errors) > 0 {
+		span.RecordError(c.Errors.Last().Err)
+		span.SetStatus(codes.Error, c.Errors.Last().Error())
+	}
+}
+
 // mCatchError is a middleware turns errors into a standard JSON error response.
 func mCatchError(c *gin.Context) {
 	c.Next()
@@ -63,11 +184,15 @@ func mCatchError(c *gin.Context) {
 // GET /ping
 func (s *Server) handlePing(c *gin.Context) {
 	log.Debug("/ping", zap.String("remoteAddr", c.Request.RemoteAddr))
-	c.JSON(http.StatusOK, protocol.PingResponse{
+	resp := protocol.PingResponse{
 		Status: "ok",
 		Pid:    os.Getpid(),
-		Config: s.config, // TODO: Remove sensitive data
-	})
+		Config: util.Redact(s.currentConfig()),
+	}
+	if b, ok := s.backend.(cache.BackendSupportStatus); ok {
+		resp.CompactionUnhealthy = b.Status().CompactionUnhealthy
+	}
+	c.JSON(http.StatusOK, resp)
 }
 
 // POST /shutdown
@@ -77,6 +202,88 @@ func (s *Server) handleShutdown(c *gin.Context) {
 	s.Shutdown()
 }
 
+// GET /status
+func (s *Server) handleStatus(c *gin.Context) {
+	log.Debug("/status", zap.String("remoteAddr", c.Request.RemoteAddr))
+	resp := protocol.StatusResponse{
+		Pid:       os.Getpid(),
+		StartedAt: s.startedAt,
+		Uptime:    s.clock.Since(s.startedAt).Round(time.Second).String(),
+		Backend:   BackendName(s.currentConfig()),
+		Dir:       s.config.Dir,
+	}
+	if b, ok := s.backend.(cache.BackendSupportStatus); ok {
+		st := b.Status()
+		resp.Status = &st
+		if st.CompactionUnhealthy {
+			resp.Problems = append(resp.Problems,
+				fmt.Sprintf("compaction failed %d times in a row", st.CompactionConsecutiveFailures))
+		}
+	}
+	size, err := ComputeSize(c.Request.Context(), s.currentConfig(), false)
+	if err != nil {
+		resp.Problems = append(resp.Problems, err.Error())
+	} else {
+		resp.LocalBytes = size.LocalBytes
+	}
+	resp.Healthy = len(resp.Problems) == 0
+	c.JSON(http.StatusOK, resp)
+}
+
+// GET /stats
+func (s *Server) handleStats(c *gin.Context) {
+	c.JSON(http.StatusOK, s.statsResponse())
+}
+
+func (s *Server) statsResponse() *protocol.StatsResponse {
+	resp := &protocol.StatsResponse{
+		Pid:       os.Getpid(),
+		StartedAt: s.startedAt,
+		Uptime:    s.clock.Since(s.startedAt).Round(time.Second).String(),
+		Stats:     stats.Default,
+	}
+	if b, ok := s.backend.(cache.BackendSupportStatus); ok {
+		st := b.Status()
+		resp.Backend = &st
+	}
+	return resp
+}
+
+// GET /inflight
+func (s *Server) handleInFlight(c *gin.Context) {
+	now := time.Now()
+	resp := protocol.InFlightResponse{Ops: []protocol.InFlightOp{}}
+	for _, op := range inflight.Default.Snapshot() {
+		resp.Ops = append(resp.Ops, protocol.InFlightOp{
+			Kind:      op.Kind,
+			Detail:    op.Detail,
+			StartedAt: op.StartedAt,
+			Elapsed:   now.Sub(op.StartedAt),
+		})
+	}
+	if b, ok := s.backend.(cache.BackendSupportStatus); ok {
+		resp.UploadQueueWaiting = b.Status().UploadQueueWaiting
+	}
+	c.JSON(http.StatusOK, resp)
+}
+
+// GET /size?remote=true
+func (s *Server) handleSize(c *gin.Context) {
+	remote, _ := strconv.ParseBool(c.Query("remote"))
+	log.Info("/size", zap.String("remoteAddr", c.Request.RemoteAddr), zap.Bool("remote", remote))
+	resp, err := ComputeSize(c.Request.Context(), s.currentConfig(), remote)
+	if err != nil {
+		c.Error(err)
+		return
+	}
+	c.JSON(http.StatusOK, resp)
+}
+
+// GET /
+func (s *Server) handleUI(c *gin.Context) {
+	c.Data(http.StatusOK, "text/html; charset=utf-8", uiPage)
+}
+
 // POST /stats/clear
 func (s *Server) handleStatsClear(c *gin.Context) {
 	log.Info("/stats/clear", zap.String("remoteAddr", c.Request.RemoteAddr))
@@ -85,39 +292,170 @@ func (s *Server) handleStatsClear(c *gin.Context) {
 	c.JSON(http.StatusOK, protocol.StatsClearResponse{})
 }
 
-// quoteCloseReader emits EOF when meets a quote and swallows the quote.
-// It is used to streamingly read the cache body with a Base64 decoder
-// which is like:
-// "<BASE64_ENCODED_DATA>"
-type quoteCloseReader struct {
-	wrapped io.Reader
-	closed  bool
+// POST /gc
+func (s *Server) handleGC(c *gin.Context) {
+	var req protocol.GCRequest
+	if err := c.ShouldBindJSON(&req); err != nil {
+		c.Error(httperr.Errorf(http.StatusBadRequest, "failed to parse GC request: %v", err))
+		return
+	}
+	backend, ok := s.backend.(cache.BackendSupportGC)
+	if !ok {
+		c.Error(httperr.Errorf(http.StatusNotImplemented, "backend does not support gc"))
+		return
+	}
+	if req == (protocol.GCRequest{}) {
+		// Trim by the gc config, which may be changed by Reload
+		gc := s.currentConfig().GC
+		req = protocol.GCRequest{MaxAge: gc.MaxAge, MaxBytes: gc.MaxBytes}
+	}
+	log.Info("/gc", zap.String("remoteAddr", c.Request.RemoteAddr),
+		zap.String("maxAge", req.MaxAge.String()),
+		zap.Int64("maxBytes", req.MaxBytes))
+	resp, err := backend.GC(req)
+	if err != nil {
+		c.Error(err)
+		return
+	}
+	c.JSON(http.StatusOK, resp)
 }
 
-func (r *quoteCloseReader) Read(p []byte) (int, error) {
-	if r.closed {
-		return 0, io.EOF
+// POST /warm?progress=true
+func (s *Server) handleWarm(c *gin.Context) {
+	var req protocol.WarmRequest
+	if err := c.ShouldBindJSON(&req); err != nil {
+		c.Error(httperr.Errorf(http.StatusBadRequest, "failed to parse warm request: %v", err))
+		return
 	}
-	n, err := r.wrapped.Read(p)
-	if n > 0 {
-		for i := 0; i < n; i++ {
-			if p[i] == '"' {
-				r.closed = true
-				return i, err
-			}
-		}
+	backend, ok := s.backend.(cache.BackendSupportWarm)
+	if !ok {
+		c.Error(httperr.Errorf(http.StatusNotImplemented, "backend does not support warm"))
+		return
 	}
-	return n, err
+	log.Info("/warm", zap.String("remoteAddr", c.Request.RemoteAddr),
+		zap.Int("blobs", req.Blobs))
+	respondWithProgress(c, func(ctx context.Context) (any, error) {
+		return backend.Warm(ctx, req)
+	})
 }
 
-func decodePut(r io.Reader) (*protocol.PutRequest, io.Reader, error) {
-	reader := bufio.NewReader(r)
-	jsonLine, err := reader.ReadBytes('\n')
+// POST /prefetch?progress=true
+func (s *Server) handlePrefetch(c *gin.Context) {
+	var req protocol.PrefetchRequest
+	if err := c.ShouldBindJSON(&req); err != nil {
+		c.Error(httperr.Errorf(http.StatusBadRequest, "failed to parse prefetch request: %v", err))
+		return
+	}
+	if err := req.Validate(); err != nil {
+		c.Error(httperr.Errorf(http.StatusBadRequest, "invalid prefetch request: %v", err))
+		return
+	}
+	backend, ok := s.backend.(cache.BackendSupportPrefetch)
+	if !ok {
+		c.Error(httperr.Errorf(http.StatusNotImplemented, "backend does not support prefetch"))
+		return
+	}
+	log.Info("/prefetch", zap.String("remoteAddr", c.Request.RemoteAddr),
+		zap.Int("prefixes", len(req.ActionIDPrefixes)),
+		zap.String("namespace", req.Namespace))
+	respondWithProgress(c, func(ctx context.Context) (any, error) {
+		return backend.Prefetch(ctx, req)
+	})
+}
+
+// POST /compact?progress=true
+func (s *Server) handleCompact(c *gin.Context) {
+	var req protocol.CompactRequest
+	if err := c.ShouldBindJSON(&req); err != nil {
+		c.Error(httperr.Errorf(http.StatusBadRequest, "failed to parse compact request: %v", err))
+		return
+	}
+	backend, ok := s.backend.(cache.BackendSupportCompactArchives)
+	if !ok {
+		c.Error(httperr.Errorf(http.StatusNotImplemented, "backend does not support compaction"))
+		return
+	}
+	log.Info("/compact", zap.String("remoteAddr", c.Request.RemoteAddr),
+		zap.Strings("keyspaces", req.Keyspaces),
+		zap.Bool("rebuild", req.Rebuild),
+		zap.Bool("dryRun", req.DryRun))
+	respondWithProgress(c, func(ctx context.Context) (any, error) {
+		return backend.CompactArchives(ctx, req)
+	})
+}
+
+// GET /logs?lines=N&follow=true
+//
+// Streams the last N lines of the server log file, then subsequent lines if follow is set,
+// until the client disconnects or the server stops.
+func (s *Server) handleLogs(c *gin.Context) {
+	lines, err := strconv.Atoi(c.DefaultQuery("lines", strconv.Itoa(defaultLogLines)))
+	if err != nil || lines < 0 {
+		c.Error(httperr.Errorf(http.StatusBadRequest, "invalid lines %q", c.Query("lines")))
+		return
+	}
+	follow := c.Query("follow") == "true"
+	logFile := s.config.Log.File
+	if logFile == "" {
+		c.Error(httperr.Errorf(http.StatusNotFound, "server does not write to a log file"))
+		return
+	}
+	tail, offset, err := log.TailLines(logFile, lines, 0)
 	if err != nil {
-		return nil, nil, fmt.Errorf("failed to read Put request: %v", err)
+		if os.IsNotExist(err) {
+			// e.g. the server runs in foreground and logs to stderr
+			c.Error(httperr.Errorf(http.StatusNotFound, "log file %s does not exist", logFile))
+			return
+		}
+		c.Error(fmt.Errorf("failed to read log file: %w", err))
+		return
+	}
+
+	c.Header("Content-Type", "text/plain; charset=utf-8")
+	c.Status(http.StatusOK)
+	for _, line := range tail {
+		_, _ = c.Writer.WriteString(line + "\n")
 	}
+	c.Writer.Flush()
+	if !follow {
+		return
+	}
+
+	ctx := c.Request.Context()
+	if s.lifecycle != nil {
+		// Followers must not hold back graceful shutdown
+		var cancel context.CancelFunc
+		ctx, cancel = context.WithCancel(ctx)
+		defer cancel()
+		stop := context.AfterFunc(s.lifecycle, cancel)
+		defer stop()
+	}
+	err = log.FollowFile(ctx, logFile, offset, logFollowPollInterval, func(line []byte) error {
+		if _, err := c.Writer.Write(line); err != nil {
+			return err
+		}
+		if _, err := c.Writer.WriteString("\n"); err != nil {
+			return err
+		}
+		c.Writer.Flush()
+		return nil
+	})
+	if err != nil {
+		// Response is already started, the client will see a truncated stream
+		log.Debug("Stopped following log file", zap.Error(err))
+	}
+}
+
+func decodePut(r io.Reader) (*protocol.PutRequest, io.Reader, error) {
+	// The request JSON is usually followed by a newline, but we don't rely on it:
+	// a JSON decoder knows where the JSON value ends, so that non-conforming clients
+	// which directly put the body quote after the JSON are also accepted.
+	dec := json.NewDecoder(r)
 	var putReq protocol.PutRequest
-	if err := json.Unmarshal(jsonLine, &putReq); err != nil {
+	if err := dec.Decode(&putReq); err != nil {
+		if errors.Is(err, io.EOF) || errors.Is(err, io.ErrUnexpectedEOF) {
+			return nil, nil, fmt.Errorf("failed to read Put request: %v", err)
+		}
 		return nil, nil, fmt.Errorf("failed to parse Put request: %v", err)
 	}
 
@@ -125,19 +463,21 @@ func decodePut(r io.Reader) (*protocol.PutRequest, io.Reader, error) {
 		return &putReq, bytes.NewReader(nil), nil
 	}
 
-	// First byte must be a quote (").
-	firstByte, err := reader.ReadByte()
+	restReader, err := protocol.DecodePutBody(io.MultiReader(dec.Buffered(), r))
 	if err != nil {
-		return nil, nil, fmt.Errorf("failed to read Put body: %v", err)
-	}
-	if firstByte != '"' {
-		return nil, nil, fmt.Errorf("unexpected Put body first byte: %q", firstByte)
+		return nil, nil, err
 	}
-	// Last byte must be a quote (").
-	restReader := base64.NewDecoder(base64.StdEncoding, &quoteCloseReader{wrapped: reader})
 	return &putReq, restReader, nil
 }
 
+// logIfSlow logs at info level if the operation takes longer than SlowThreshold.
+func (s *Server) logIfSlow(msg string, cost time.Duration, fields ...zap.Field) {
+	if s.config.SlowThreshold <= 0 || cost < s.config.SlowThreshold {
+		return
+	}
+	log.Info(msg, append([]zap.Field{zap.String("cost", cost.String())}, fields...)...)
+}
+
 // POST /cacheprog/put
 func (s *Server) handleCachePut(c *gin.Context) {
 	defer c.Request.Body.Close()
@@ -146,13 +486,27 @@ func (s *Server) handleCachePut(c *gin.Context) {
 		c.Error(httperr.Wrap(err, http.StatusBadRequest))
 		return
 	}
+	if err := req.Validate(); err != nil {
+		c.Error(httperr.Errorf(http.StatusBadRequest, "invalid Put request: %v", err))
+		return
+	}
+
+	record := accessRecordOf(c)
+	record.ActionID, record.Namespace, record.Bytes = req.ActionID, req.Namespace, max(req.BodySize, 0)
 
 	defer stats.Default.Persist()
+	finish := inflight.Default.StartWithResult(inflight.KindPut, fmt.Sprintf("%x", req.ActionID))
+	defer func() { finish(activityResult(c, record)) }()
 	stats.Default.PutTotal.Inc()
+	stats.Default.PutBytes.Add(uint64(max(req.BodySize, 0)))
+	stats.Default.PutSize.Observe(req.BodySize)
+	stats.Default.Misses.RecordPut(req.ActionID, req.BodySize)
 
+	t := time.Now()
 	resp, err := s.backend.Put(cache.PutOpts{
 		Req:  *req,
 		Body: putPayloadReader,
+		Ctx:  c.Request.Context(),
 	})
 	if err != nil {
 		stats.Default.PutError.Inc()
@@ -160,23 +514,58 @@ func (s *Server) handleCachePut(c *gin.Context) {
 		return
 	}
 
+	record.Result = "stored"
+	s.logIfSlow("Slow Put", time.Since(t), zap.Object("request", req))
 	log.Debug("/cacheprog/get", zap.Object("request", req), zap.Object("response", resp))
 	c.JSON(http.StatusOK, resp)
 }
 
+// decodeGet strictly decodes a Get request: the body must be a single JSON object
+// without unknown fields, sent as application/json.
+func decodeGet(contentType string, r io.Reader) (*protocol.GetRequest, error) {
+	mediaType, _, err := mime.ParseMediaType(contentType)
+	if err != nil || mediaType != "application/json" {
+		return nil, fmt.Errorf("unsupported content type %q, expect application/json", contentType)
+	}
+	dec := json.NewDecoder(r)
+	dec.DisallowUnknownFields()
+	var req protocol.GetRequest
+	if err := dec.Decode(&req); err != nil {
+		return nil, fmt.Errorf("failed to parse Get request: %v", err)
+	}
+	if _, err := dec.Token(); !errors.Is(err, io.EOF) {
+		return nil, fmt.Errorf("failed to parse Get request: unexpected data after JSON object")
+	}
+	return &req, nil
+}
+
 // POST /cacheprog/get
 func (s *Server) handleCacheGet(c *gin.Context) {
-	var req protocol.GetRequest
-	if err := c.ShouldBindJSON(&req); err != nil {
-		c.Error(httperr.Errorf(http.StatusBadRequest, "failed to read Get request: %v", err))
+	defer c.Request.Body.Close()
+	reqPtr, err := decodeGet(c.GetHeader("Content-Type"), c.Request.Body)
+	if err != nil {
+		c.Error(httperr.Wrap(err, http.StatusBadRequest))
+		return
+	}
+	req := *reqPtr
+	if err := req.Validate(); err != nil {
+		c.Error(httperr.Errorf(http.StatusBadRequest, "invalid Get request: %v", err))
 		return
 	}
 
+	record := accessRecordOf(c)
+	record.ActionID, record.Namespace = req.ActionID, req.Namespace
+
 	defer stats.Default.Persist()
+	finish := inflight.Default.StartWithResult(inflight.KindGet, fmt.Sprintf("%x", req.ActionID))
+	defer func() { finish(activityResult(c, record)) }()
 	stats.Default.GetTotal.Inc()
 
+	t := time.Now()
+	ctx := cache.WithServedFrom(c.Request.Context())
 	resp, err := s.backend.Get(cache.GetOpts{
 		Req: req,
+		Ctx: ctx,
 	})
 	if err != nil {
 		stats.Default.GetError.Inc()
@@ -185,10 +574,123 @@ func (s *Server) handleCacheGet(c *gin.Context) {
 	}
 	if resp.Miss {
 		stats.Default.GetMiss.Inc()
+		stats.Default.Misses.Record(req.ActionID)
 	} else {
 		stats.Default.GetHit.Inc()
 	}
+	record.Result, record.ServedFrom, record.Bytes = "hit", cache.ServedFrom(ctx), resp.Size
+	if resp.Miss {
+		record.Result = "miss"
+	}
 
+	s.logIfSlow("Slow Get", time.Since(t),
+		zap.Object("request", &req),
+		zap.String("servedFrom", cache.ServedFrom(ctx)),
+		zap.Int64("size", resp.Size))
 	log.Debug("/cacheprog/get", zap.Object("request", &req), zap.Object("response", resp))
+	// Copy, as the response may be shared by deduplicated requests
+	respWithSource := *resp
+	if !resp.Miss {
+		respWithSource.ServedFrom = cache.ServedFrom(ctx)
+		if req.StreamBody {
+			streamGetBody(c, &respWithSource)
+			return
+		}
+	}
+	c.JSON(http.StatusOK, &respWithSource)
+}
+
+// streamGetBody responds with the hit followed by its body, see GetRequest.StreamBody.
+func streamGetBody(c *gin.Context, resp *protocol.GetResponse) {
+	body := io.Reader(bytes.NewReader(nil))
+	if resp.Size > 0 {
+		f, err := os.Open(resp.DiskPath)
+		if err != nil {
+			c.Error(fmt.Errorf("failed to open body of Get: %w", err))
+			return
+		}
+		defer f.Close()
+		body = f
+	}
+	resp.DiskPath = ""
+	header, err := json.Marshal(resp)
+	if err != nil {
+		c.Error(err)
+		return
+	}
+	c.Header("Content-Type", "application/octet-stream")
+	c.Status(http.StatusOK)
+	if _, err := c.Writer.Write(append(header, '\n')); err != nil {
+		return
+	}
+	if _, err := io.CopyN(c.Writer, body, resp.Size); err != nil {
+		// Response is already started, the client will see a truncated body
+		log.Warn("Failed to stream body of Get", zap.Error(err))
+	}
+}
+
+// POST /cacheprog/exists_batch
+func (s *Server) handleCacheExistsBatch(c *gin.Context) {
+	defer c.Request.Body.Close()
+	var req protocol.ExistsBatchRequest
+	if err := c.ShouldBindJSON(&req); err != nil {
+		c.Error(httperr.Errorf(http.StatusBadRequest, "failed to parse ExistsBatch request: %v", err))
+		return
+	}
+	if err := req.Validate(); err != nil {
+		c.Error(httperr.Errorf(http.StatusBadRequest, "invalid ExistsBatch request: %v", err))
+		return
+	}
+	backend, ok := s.backend.(cache.BackendSupportExists)
+	if !ok {
+		c.Error(httperr.Errorf(http.StatusNotImplemented, "backend does not support exists"))
+		return
+	}
+
+	resp := protocol.ExistsBatchResponse{Exists: make([]bool, len(req.ActionIDs))}
+	g, ctx := errgroup.WithContext(c.Request.Context())
+	g.SetLimit(existsBatchConcurrency)
+	for i, actionID := range req.ActionIDs {
+		g.Go(func() error {
+			exists, err := backend.Exists(ctx, req.Namespace, actionID)
+			if err != nil {
+				// This is only an estimation, so a failed check is simply reported as a miss.
+				log.Warn("Failed to check whether cache entry exists",
+					zap.String("actionID", fmt.Sprintf("%x", actionID)),
+					zap.Error(err))
+				return nil
+			}
+			resp.Exists[i] = exists
+			return nil
+		})
+	}
+	_ = g.Wait()
+
+	log.Debug("/cacheprog/exists_batch", zap.Int("actionIDs", len(req.ActionIDs)))
+	c.JSON(http.StatusOK, resp)
+}
+
+// DELETE /cacheprog/entry
+func (s *Server) handleCacheDelete(c *gin.Context) {
+	defer c.Request.Body.Close()
+	var req protocol.DeleteRequest
+	if err := c.ShouldBindJSON(&req); err != nil {
+		c.Error(httperr.Errorf(http.StatusBadRequest, "failed to parse Delete request: %v", err))
+		return
+	}
+	if err := req.Validate(); err != nil {
+		c.Error(httperr.Errorf(http.StatusBadRequest, "invalid Delete request: %v", err))
+		return
+	}
+	backend, ok := s.backend.(cache.BackendSupportDelete)
+	if !ok {
+		c.Error(httperr.Errorf(http.StatusNotImplemented, "backend does not support delete"))
+		return
+	}
+	resp, err := backend.Delete(c.Request.Context(), req)
+	if err != nil {
+		c.Error(err)
+		return
+	}
 	c.JSON(http.StatusOK, resp)
 }
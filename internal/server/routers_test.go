@@ -0,0 +1,655 @@
+package server
+
+import (
+	"bytes"
+	"compress/gzip"
+	"context"
+	"encoding/base64"
+	"encoding/json"
+	"fmt"
+	"io"
+	"math/rand"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"path/filepath"
+	"strconv"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/require"
+
+	"github.com/breezewish/gscache/internal/cache"
+	"github.com/breezewish/gscache/internal/cacheprog"
+	"github.com/breezewish/gscache/internal/client"
+	"github.com/breezewish/gscache/internal/inflight"
+	"github.com/breezewish/gscache/internal/protocol"
+)
+
+func TestDecodePut_WithNewline(t *testing.T) {
+	req, body, err := decodePut(strings.NewReader(`{"ActionID":"AQI=","OutputID":"AwQ=","BodySize":5}` + "\n" + `"aGVsbG8="`))
+	require.NoError(t, err)
+	require.Equal(t, []byte{0x01, 0x02}, req.ActionID)
+	require.Equal(t, []byte{0x03, 0x04}, req.OutputID)
+	require.Equal(t, int64(5), req.BodySize)
+	data, err := io.ReadAll(body)
+	require.NoError(t, err)
+	require.Equal(t, "hello", string(data))
+}
+
+func TestDecodePut_WithoutNewline(t *testing.T) {
+	req, body, err := decodePut(strings.NewReader(`{"ActionID":"AQI=","OutputID":"AwQ=","BodySize":5}"aGVsbG8="`))
+	require.NoError(t, err)
+	require.Equal(t, int64(5), req.BodySize)
+	data, err := io.ReadAll(body)
+	require.NoError(t, err)
+	require.Equal(t, "hello", string(data))
+}
+
+func TestDecodePut_NoBody(t *testing.T) {
+	// No trailing newline and no body: must not block waiting for more data.
+	req, body, err := decodePut(strings.NewReader(`{"ActionID":"AQI="}`))
+	require.NoError(t, err)
+	require.Equal(t, []byte{0x01, 0x02}, req.ActionID)
+	data, err := io.ReadAll(body)
+	require.NoError(t, err)
+	require.Empty(t, data)
+}
+
+func TestDecodePut_MalformedFraming(t *testing.T) {
+	cases := []struct {
+		input  string
+		errMsg string
+	}{
+		{``, "failed to read Put request"},
+		{`{"ActionID":"AQI=","BodySize":5`, "failed to read Put request"},
+		{`not a json`, "failed to parse Put request"},
+		{`{"ActionID":"AQI=","OutputID":"AwQ=","BodySize":5}`, "failed to read Put body"},
+		{`{"ActionID":"AQI=","OutputID":"AwQ=","BodySize":5}` + "\n", "failed to read Put body"},
+		{`{"ActionID":"AQI=","OutputID":"AwQ=","BodySize":5}` + "\naGVsbG8=", "unexpected Put body first byte"},
+	}
+	for _, c := range cases {
+		_, _, err := decodePut(strings.NewReader(c.input))
+		require.Error(t, err, c.input)
+		require.Contains(t, err.Error(), c.errMsg, c.input)
+	}
+}
+
+func TestDecodeGet(t *testing.T) {
+	req, err := decodeGet("application/json; charset=utf-8", strings.NewReader(`{"ActionID":"AQI="}`))
+	require.NoError(t, err)
+	require.Equal(t, []byte{0x01, 0x02}, req.ActionID)
+
+	cases := []struct {
+		contentType string
+		input       string
+		errMsg      string
+	}{
+		{"text/plain", `{"ActionID":"AQI="}`, "unsupported content type"},
+		{"", `{"ActionID":"AQI="}`, "unsupported content type"},
+		{"application/json", `{"ActionID":"AQI=","Foo":1}`, "unknown field"},
+		{"application/json", `garbage`, "failed to parse Get request"},
+		{"application/json", ``, "failed to parse Get request"},
+		{"application/json", `{"ActionID":"AQI="}{"ActionID":"AQI="}`, "unexpected data after JSON object"},
+	}
+	for _, c := range cases {
+		_, err := decodeGet(c.contentType, strings.NewReader(c.input))
+		require.Error(t, err, c.input)
+		require.Contains(t, err.Error(), c.errMsg, c.input)
+	}
+}
+
+func TestHandleCacheGet_MalformedRequest(t *testing.T) {
+	cfg := DefaultConfig()
+	cfg.Dir = t.TempDir()
+	s, err := NewServer(cfg)
+	require.NoError(t, err)
+	router := s.newRouter()
+
+	for _, body := range []string{`{"ActionID":"AQI=","Extra":true}`, `garbage`} {
+		req := httptest.NewRequest(http.MethodPost, "/cacheprog/get", strings.NewReader(body))
+		req.Header.Set("Content-Type", "application/json")
+		w := httptest.NewRecorder()
+		router.ServeHTTP(w, req)
+
+		require.Equal(t, http.StatusBadRequest, w.Code, body)
+		var errResp protocol.ErrorResponse
+		require.NoError(t, json.Unmarshal(w.Body.Bytes(), &errResp))
+		require.Contains(t, errResp.Error, "failed to parse Get request")
+	}
+}
+
+func TestHandlePing_RedactsSecrets(t *testing.T) {
+	cfg := DefaultConfig()
+	cfg.Dir = t.TempDir()
+	cfg.Auth.Token = "secret-token"
+	cfg.Blob.URL = "s3://bucket?secret_access_key=secret-key"
+	s, err := NewServer(cfg)
+	require.NoError(t, err)
+
+	req := httptest.NewRequest(http.MethodGet, "/ping", nil)
+	req.Header.Set("Authorization", "Bearer secret-token")
+	w := httptest.NewRecorder()
+	s.newRouter().ServeHTTP(w, req)
+	require.Equal(t, http.StatusOK, w.Code)
+	require.NotContains(t, w.Body.String(), "secret-")
+	require.Contains(t, w.Body.String(), "s3://bucket")
+	require.Equal(t, "secret-token", s.config.Auth.Token)
+}
+
+func TestHandleStats(t *testing.T) {
+	cfg := DefaultConfig()
+	cfg.Dir = t.TempDir()
+	s, err := NewServer(cfg)
+	require.NoError(t, err)
+	router := s.newRouter()
+
+	req := httptest.NewRequest(http.MethodGet, "/stats", nil)
+	w := httptest.NewRecorder()
+	router.ServeHTTP(w, req)
+	require.Equal(t, http.StatusOK, w.Code)
+
+	var resp struct {
+		Pid     int
+		Stats   map[string]any
+		Backend *protocol.BackendStatus
+	}
+	require.NoError(t, json.Unmarshal(w.Body.Bytes(), &resp))
+	require.NotZero(t, resp.Pid)
+	require.Contains(t, resp.Stats, "Get.Total")
+	require.Nil(t, resp.Backend) // Local backend does not report status
+}
+
+func TestHandleStatus(t *testing.T) {
+	cfg := DefaultConfig()
+	cfg.Dir = t.TempDir()
+	s, err := NewServer(cfg)
+	require.NoError(t, err)
+	require.NoError(t, os.WriteFile(filepath.Join(cfg.Dir, "foo"), []byte("hello"), 0644))
+
+	w := httptest.NewRecorder()
+	s.newRouter().ServeHTTP(w, httptest.NewRequest(http.MethodGet, "/status", nil))
+	require.Equal(t, http.StatusOK, w.Code)
+
+	var resp protocol.StatusResponse
+	require.NoError(t, json.Unmarshal(w.Body.Bytes(), &resp))
+	require.NotZero(t, resp.Pid)
+	require.Equal(t, BackendLocal, resp.Backend)
+	require.True(t, resp.Healthy)
+	require.Empty(t, resp.Problems)
+	require.GreaterOrEqual(t, resp.LocalBytes, int64(5))
+	require.Nil(t, resp.Status) // Local backend does not report status
+}
+
+func TestHandleUI(t *testing.T) {
+	cfg := DefaultConfig()
+	cfg.Dir = t.TempDir()
+	s, err := NewServer(cfg)
+	require.NoError(t, err)
+
+	w := httptest.NewRecorder()
+	s.newRouter().ServeHTTP(w, httptest.NewRequest(http.MethodGet, "/", nil))
+	require.Equal(t, http.StatusNotFound, w.Code)
+
+	s.config.UI.Enabled = true
+	w = httptest.NewRecorder()
+	s.newRouter().ServeHTTP(w, httptest.NewRequest(http.MethodGet, "/", nil))
+	require.Equal(t, http.StatusOK, w.Code)
+	require.Contains(t, w.Header().Get("Content-Type"), "text/html")
+	require.Contains(t, w.Body.String(), "/stats")
+}
+
+func TestHandlePprof(t *testing.T) {
+	cfg := DefaultConfig()
+	cfg.Dir = t.TempDir()
+	s, err := NewServer(cfg)
+	require.NoError(t, err)
+
+	w := httptest.NewRecorder()
+	s.newRouter().ServeHTTP(w, httptest.NewRequest(http.MethodGet, "/debug/pprof/", nil))
+	require.Equal(t, http.StatusNotFound, w.Code)
+
+	s.config.Debug.Pprof = true
+	router := s.newRouter()
+	w = httptest.NewRecorder()
+	router.ServeHTTP(w, httptest.NewRequest(http.MethodGet, "/debug/pprof/", nil))
+	require.Equal(t, http.StatusOK, w.Code)
+	require.Contains(t, w.Body.String(), "goroutine")
+
+	w = httptest.NewRecorder()
+	router.ServeHTTP(w, httptest.NewRequest(http.MethodGet, "/debug/pprof/heap?debug=1", nil))
+	require.Equal(t, http.StatusOK, w.Code)
+	require.Contains(t, w.Body.String(), "heap profile")
+
+	w = httptest.NewRecorder()
+	router.ServeHTTP(w, httptest.NewRequest(http.MethodGet, "/debug/pprof/cmdline", nil))
+	require.Equal(t, http.StatusOK, w.Code)
+	require.Equal(t, strings.Join(os.Args, "\x00"), w.Body.String())
+}
+
+func TestAuth(t *testing.T) {
+	cfg := DefaultConfig()
+	cfg.Dir = t.TempDir()
+	cfg.Log.File = filepath.Join(cfg.Dir, "gscache.log")
+	cfg.Auth.Token = "secret"
+	require.NoError(t, os.WriteFile(cfg.Log.File, []byte("a\n"), 0644))
+	s, err := NewServer(cfg)
+	require.NoError(t, err)
+	ts := httptest.NewServer(s.newRouter())
+	defer ts.Close()
+	port, err := strconv.Atoi(ts.URL[strings.LastIndex(ts.URL, ":")+1:])
+	require.NoError(t, err)
+
+	for _, token := range []string{"", "wrong"} {
+		c := client.NewClient(client.Config{DaemonPort: port, Token: token})
+		_, err = c.CallPing()
+		require.ErrorContains(t, err, "missing or invalid auth token")
+		_, err = c.CallLogs(context.Background(), 1, false)
+		require.ErrorContains(t, err, "missing or invalid auth token")
+	}
+
+	c := client.NewClient(client.Config{DaemonPort: port, Token: "secret"})
+	ping, err := c.CallPing()
+	require.NoError(t, err)
+	require.NotZero(t, ping.Pid)
+	body, err := c.CallLogs(context.Background(), 1, false)
+	require.NoError(t, err)
+	require.NoError(t, body.Close())
+}
+
+func TestAuthUI(t *testing.T) {
+	cfg := DefaultConfig()
+	cfg.Dir = t.TempDir()
+	cfg.Auth.Token = "secret"
+	cfg.UI.Enabled = true
+	s, err := NewServer(cfg)
+	require.NoError(t, err)
+	router := s.newRouter()
+	get := func(target string, cookies ...*http.Cookie) *httptest.ResponseRecorder {
+		req := httptest.NewRequest(http.MethodGet, target, nil)
+		for _, cookie := range cookies {
+			req.AddCookie(cookie)
+		}
+		w := httptest.NewRecorder()
+		router.ServeHTTP(w, req)
+		return w
+	}
+
+	w := get("/")
+	require.Equal(t, http.StatusUnauthorized, w.Code)
+	require.Contains(t, w.Body.String(), "/?token=")
+	require.Equal(t, http.StatusUnauthorized, get("/?token=wrong").Code)
+
+	w = get("/?token=secret")
+	require.Equal(t, http.StatusSeeOther, w.Code)
+	require.Equal(t, "/", w.Header().Get("Location"))
+	cookies := w.Result().Cookies()
+	require.Len(t, cookies, 1)
+	require.True(t, cookies[0].HttpOnly)
+	require.Equal(t, http.SameSiteStrictMode, cookies[0].SameSite)
+	require.NotContains(t, cookies[0].Value, "secret")
+
+	require.Equal(t, http.StatusOK, get("/", cookies[0]).Code)
+	require.Equal(t, http.StatusOK, get("/stats", cookies[0]).Code)
+	require.Equal(t, http.StatusUnauthorized, get("/stats", &http.Cookie{Name: authCookieName, Value: "x"}).Code)
+	// Only reads are allowed by the cookie
+	req := httptest.NewRequest(http.MethodPost, "/stats/clear", nil)
+	req.AddCookie(cookies[0])
+	w = httptest.NewRecorder()
+	router.ServeHTTP(w, req)
+	require.Equal(t, http.StatusUnauthorized, w.Code)
+
+	// The token does not log in if the UI is disabled
+	s.config.UI.Enabled = false
+	router = s.newRouter()
+	require.Equal(t, http.StatusUnauthorized, get("/?token=secret").Code)
+}
+
+func TestHandleLogs(t *testing.T) {
+	cfg := DefaultConfig()
+	cfg.Dir = t.TempDir()
+	cfg.Log.File = filepath.Join(cfg.Dir, "gscache.log")
+	s, err := NewServer(cfg)
+	require.NoError(t, err)
+	router := s.newRouter()
+
+	w := httptest.NewRecorder()
+	router.ServeHTTP(w, httptest.NewRequest(http.MethodGet, "/logs", nil))
+	require.Equal(t, http.StatusNotFound, w.Code)
+
+	require.NoError(t, os.WriteFile(cfg.Log.File, []byte("a\nb\nc\n"), 0644))
+	w = httptest.NewRecorder()
+	router.ServeHTTP(w, httptest.NewRequest(http.MethodGet, "/logs?lines=2", nil))
+	require.Equal(t, http.StatusOK, w.Code)
+	require.Equal(t, "b\nc\n", w.Body.String())
+
+	w = httptest.NewRecorder()
+	router.ServeHTTP(w, httptest.NewRequest(http.MethodGet, "/logs?lines=x", nil))
+	require.Equal(t, http.StatusBadRequest, w.Code)
+
+	// Follow via the client, until cancelled
+	ts := httptest.NewServer(router)
+	defer ts.Close()
+	port, err := strconv.Atoi(ts.URL[strings.LastIndex(ts.URL, ":")+1:])
+	require.NoError(t, err)
+	ctx, cancel := context.WithCancel(context.Background())
+	defer cancel()
+	body, err := client.NewClient(client.Config{DaemonPort: port}).CallLogs(ctx, 1, true)
+	require.NoError(t, err)
+	defer body.Close()
+	f, err := os.OpenFile(cfg.Log.File, os.O_APPEND|os.O_WRONLY, 0644)
+	require.NoError(t, err)
+	_, err = f.WriteString("d\n")
+	require.NoError(t, err)
+	require.NoError(t, f.Close())
+	buf := make([]byte, 4)
+	_, err = io.ReadFull(body, buf)
+	require.NoError(t, err)
+	require.Equal(t, "c\nd\n", string(buf))
+}
+
+func TestHandleCacheExistsBatch(t *testing.T) {
+	cfg := DefaultConfig()
+	cfg.Dir = t.TempDir()
+	s, err := NewServer(cfg)
+	require.NoError(t, err)
+	require.NoError(t, s.backend.Open(context.Background()))
+	defer s.backend.Close()
+	router := s.newRouter()
+
+	_, err = s.backend.Put(cache.PutOpts{
+		Req:  protocol.PutRequest{ActionID: []byte{0x01}, OutputID: []byte{0x02}, BodySize: 5},
+		Body: strings.NewReader("hello"),
+	})
+	require.NoError(t, err)
+
+	call := func(body string) *httptest.ResponseRecorder {
+		req := httptest.NewRequest(http.MethodPost, "/cacheprog/exists_batch", strings.NewReader(body))
+		req.Header.Set("Content-Type", "application/json")
+		w := httptest.NewRecorder()
+		router.ServeHTTP(w, req)
+		return w
+	}
+
+	w := call(`{"ActionIDs":["AQ==","Aw==","AQ=="]}`)
+	require.Equal(t, http.StatusOK, w.Code)
+	var resp protocol.ExistsBatchResponse
+	require.NoError(t, json.Unmarshal(w.Body.Bytes(), &resp))
+	require.Equal(t, []bool{true, false, true}, resp.Exists)
+
+	// Entries are isolated by namespace.
+	w = call(`{"ActionIDs":["AQ=="],"Namespace":"other"}`)
+	require.Equal(t, http.StatusOK, w.Code)
+	require.NoError(t, json.Unmarshal(w.Body.Bytes(), &resp))
+	require.Equal(t, []bool{false}, resp.Exists)
+
+	for _, body := range []string{`garbage`, `{"ActionIDs":[""]}`, `{"ActionIDs":["AQ=="],"Namespace":"a/b"}`} {
+		require.Equal(t, http.StatusBadRequest, call(body).Code, body)
+	}
+}
+
+func TestHandleCacheDelete(t *testing.T) {
+	cfg := DefaultConfig()
+	cfg.Dir = t.TempDir()
+	s, err := NewServer(cfg)
+	require.NoError(t, err)
+	require.NoError(t, s.backend.Open(context.Background()))
+	defer s.backend.Close()
+	router := s.newRouter()
+
+	_, err = s.backend.Put(cache.PutOpts{
+		Req:  protocol.PutRequest{ActionID: []byte{0x01}, OutputID: []byte{0x02}, BodySize: 5},
+		Body: strings.NewReader("hello"),
+	})
+	require.NoError(t, err)
+
+	call := func(body string) *httptest.ResponseRecorder {
+		req := httptest.NewRequest(http.MethodDelete, "/cacheprog/entry", strings.NewReader(body))
+		req.Header.Set("Content-Type", "application/json")
+		w := httptest.NewRecorder()
+		router.ServeHTTP(w, req)
+		return w
+	}
+
+	w := call(`{"ActionID":"AQ=="}`)
+	require.Equal(t, http.StatusOK, w.Code)
+	var resp protocol.DeleteResponse
+	require.NoError(t, json.Unmarshal(w.Body.Bytes(), &resp))
+	require.True(t, resp.DeletedLocal)
+
+	getResp, err := s.backend.Get(cache.GetOpts{Req: protocol.GetRequest{ActionID: []byte{0x01}}})
+	require.NoError(t, err)
+	require.True(t, getResp.Miss)
+
+	// Deleting again is not an error
+	w = call(`{"ActionID":"AQ=="}`)
+	require.Equal(t, http.StatusOK, w.Code)
+	require.NoError(t, json.Unmarshal(w.Body.Bytes(), &resp))
+	require.False(t, resp.DeletedLocal)
+
+	// By prefix
+	for _, actionID := range [][]byte{{0xab, 0x01}, {0xab, 0x02}, {0xac, 0x01}} {
+		_, err = s.backend.Put(cache.PutOpts{
+			Req:  protocol.PutRequest{ActionID: actionID, OutputID: []byte{0x02}, BodySize: 5},
+			Body: strings.NewReader("hello"),
+		})
+		require.NoError(t, err)
+	}
+	w = call(`{"Prefix":"ab"}`)
+	require.Equal(t, http.StatusOK, w.Code)
+	resp = protocol.DeleteResponse{}
+	require.NoError(t, json.Unmarshal(w.Body.Bytes(), &resp))
+	require.Equal(t, protocol.DeleteResponse{DeletedLocal: true, LocalEntries: 2}, resp)
+	getResp, err = s.backend.Get(cache.GetOpts{Req: protocol.GetRequest{ActionID: []byte{0xac, 0x01}}})
+	require.NoError(t, err)
+	require.False(t, getResp.Miss)
+
+	for _, body := range []string{`garbage`, `{"ActionID":""}`, `{"ActionID":"AQ==","Namespace":"a/b"}`,
+		`{"Prefix":"xyz"}`, `{"ActionID":"AQ==","Prefix":"01"}`} {
+		require.Equal(t, http.StatusBadRequest, call(body).Code, body)
+	}
+}
+
+func TestCacheProg_MultiMegabytePutViaServer(t *testing.T) {
+	cfg := DefaultConfig()
+	cfg.Dir = t.TempDir()
+	s, err := NewServer(cfg)
+	require.NoError(t, err)
+	require.NoError(t, s.backend.Open(context.Background()))
+	defer s.backend.Close()
+	ts := httptest.NewServer(s.newRouter())
+	defer ts.Close()
+	port, err := strconv.Atoi(ts.URL[strings.LastIndex(ts.URL, ":")+1:])
+	require.NoError(t, err)
+
+	// The base64 body spans ~1000 chunks of the cacheprog line reader.
+	body := make([]byte, 3*1024*1024+7)
+	_, err = rand.New(rand.NewSource(1)).Read(body)
+	require.NoError(t, err)
+
+	var in bytes.Buffer
+	fmt.Fprintf(&in, `{"ID":1,"Command":"put","ActionID":"AQI=","OutputID":"AwQ=","BodySize":%d}`+"\n", len(body))
+	fmt.Fprintf(&in, "%q\n", base64.StdEncoding.EncodeToString(body))
+	fmt.Fprintf(&in, `{"ID":2,"Command":"close"}`+"\n")
+
+	var out bytes.Buffer
+	cp := cacheprog.New(cacheprog.Opts{
+		CacheHandler: cacheprog.NewHandlerViaServer(client.Config{DaemonPort: port}),
+		In:           &in,
+		Out:          &out,
+	})
+	require.NoError(t, cp.Run())
+
+	lines := strings.Split(strings.TrimSpace(out.String()), "\n")
+	require.Len(t, lines, 2)
+	var resp protocol.CacheProgResponse
+	require.NoError(t, json.Unmarshal([]byte(lines[1]), &resp))
+	require.Empty(t, resp.Err)
+	require.Equal(t, int64(1), resp.ID)
+	stored, err := os.ReadFile(resp.DiskPath)
+	require.NoError(t, err)
+	require.True(t, bytes.Equal(body, stored), "stored body differs from the original")
+}
+
+func TestCacheProg_ViaRemoteServer(t *testing.T) {
+	cfg := DefaultConfig()
+	cfg.Dir = t.TempDir()
+	s, err := NewServer(cfg)
+	require.NoError(t, err)
+	require.NoError(t, s.backend.Open(context.Background()))
+	defer s.backend.Close()
+	ts := httptest.NewServer(s.newRouter())
+	defer ts.Close()
+	port, err := strconv.Atoi(ts.URL[strings.LastIndex(ts.URL, ":")+1:])
+	require.NoError(t, err)
+
+	body := make([]byte, 1024*1024+3)
+	_, err = rand.New(rand.NewSource(1)).Read(body)
+	require.NoError(t, err)
+
+	bodyDir := t.TempDir()
+	handler := cacheprog.NewHandlerViaRemoteServer(client.Config{DaemonPort: port}, bodyDir)
+	run := func(requests ...string) []protocol.CacheProgResponse {
+		var out bytes.Buffer
+		cp := cacheprog.New(cacheprog.Opts{
+			CacheHandler: handler,
+			In:           strings.NewReader(strings.Join(append(requests, `{"ID":100,"Command":"close"}`), "\n") + "\n"),
+			Out:          &out,
+		})
+		require.NoError(t, cp.Run())
+		lines := strings.Split(strings.TrimSpace(out.String()), "\n")[1:] // Skip the initial capabilities
+		resps := make([]protocol.CacheProgResponse, len(lines))
+		for i, line := range lines {
+			require.NoError(t, json.Unmarshal([]byte(line), &resps[i]))
+			require.Empty(t, resps[i].Err)
+		}
+		return resps
+	}
+
+	resps := run(
+		fmt.Sprintf(`{"ID":1,"Command":"put","ActionID":"AQI=","OutputID":"AwQ=","BodySize":%d}`, len(body)),
+		fmt.Sprintf("%q", base64.StdEncoding.EncodeToString(body)),
+		`{"ID":2,"Command":"put","ActionID":"BQY=","OutputID":"Bwg="}`,
+	)
+	require.Len(t, resps, 2)
+	for _, resp := range resps {
+		require.True(t, strings.HasPrefix(resp.DiskPath, bodyDir), resp.DiskPath)
+	}
+
+	// Materialized again from the daemon
+	require.NoError(t, os.RemoveAll(bodyDir))
+	resps = run(
+		`{"ID":1,"Command":"get","ActionID":"AQI="}`,
+		`{"ID":2,"Command":"get","ActionID":"BQY="}`,
+		`{"ID":3,"Command":"get","ActionID":"CQo="}`,
+	)
+	require.Len(t, resps, 3)
+	byID := make(map[int64]protocol.CacheProgResponse)
+	for _, resp := range resps {
+		byID[resp.ID] = resp
+	}
+	require.Equal(t, int64(len(body)), byID[1].Size)
+	require.True(t, strings.HasPrefix(byID[1].DiskPath, bodyDir), byID[1].DiskPath)
+	stored, err := os.ReadFile(byID[1].DiskPath)
+	require.NoError(t, err)
+	require.True(t, bytes.Equal(body, stored), "materialized body differs from the original")
+	stored, err = os.ReadFile(byID[2].DiskPath)
+	require.NoError(t, err)
+	require.Empty(t, stored)
+	require.True(t, byID[3].Miss)
+
+	removed, err := handler.Trim(time.Hour)
+	require.NoError(t, err)
+	require.Zero(t, removed)
+	removed, err = handler.Trim(0)
+	require.NoError(t, err)
+	require.Equal(t, 2, removed)
+}
+
+func TestGzipContentEncoding(t *testing.T) {
+	cfg := DefaultConfig()
+	cfg.Dir = t.TempDir()
+	s, err := NewServer(cfg)
+	require.NoError(t, err)
+	require.NoError(t, s.backend.Open(context.Background()))
+	defer s.backend.Close()
+	router := s.newRouter()
+
+	var gzBody bytes.Buffer
+	gz := gzip.NewWriter(&gzBody)
+	_, _ = gz.Write([]byte(`{"ActionID":"AQI=","OutputID":"AwQ=","BodySize":5}` + "\n" + `"aGVsbG8="`))
+	require.NoError(t, gz.Close())
+	req := httptest.NewRequest(http.MethodPost, "/cacheprog/put", &gzBody)
+	req.Header.Set("Content-Encoding", "gzip")
+	w := httptest.NewRecorder()
+	router.ServeHTTP(w, req)
+	require.Equal(t, http.StatusOK, w.Code, w.Body.String())
+	var putResp protocol.PutResponse
+	require.NoError(t, json.Unmarshal(w.Body.Bytes(), &putResp))
+	data, err := os.ReadFile(putResp.DiskPath)
+	require.NoError(t, err)
+	require.Equal(t, "hello", string(data))
+
+	req = httptest.NewRequest(http.MethodPost, "/cacheprog/put", strings.NewReader("not gzip"))
+	req.Header.Set("Content-Encoding", "gzip")
+	w = httptest.NewRecorder()
+	router.ServeHTTP(w, req)
+	require.Equal(t, http.StatusBadRequest, w.Code)
+
+	// Responses are only compressed for non-loopback clients.
+	getBody := `{"ActionID":"AQI="}`
+	for _, remoteAddr := range []string{"127.0.0.1:1234", "192.0.2.1:1234"} {
+		req = httptest.NewRequest(http.MethodPost, "/cacheprog/get", strings.NewReader(getBody))
+		req.Header.Set("Content-Type", "application/json")
+		req.Header.Set("Accept-Encoding", "gzip")
+		req.RemoteAddr = remoteAddr
+		w = httptest.NewRecorder()
+		router.ServeHTTP(w, req)
+		require.Equal(t, http.StatusOK, w.Code)
+
+		respBody := w.Body.Bytes()
+		if remoteAddr != "127.0.0.1:1234" {
+			require.Equal(t, "gzip", w.Header().Get("Content-Encoding"))
+			gr, err := gzip.NewReader(w.Body)
+			require.NoError(t, err)
+			respBody, err = io.ReadAll(gr)
+			require.NoError(t, err)
+		} else {
+			require.Empty(t, w.Header().Get("Content-Encoding"))
+		}
+		var getResp protocol.GetResponse
+		require.NoError(t, json.Unmarshal(respBody, &getResp))
+		require.False(t, getResp.Miss)
+	}
+}
+
+func TestHandleInFlight(t *testing.T) {
+	cfg := DefaultConfig()
+	cfg.Dir = t.TempDir()
+	s, err := NewServer(cfg)
+	require.NoError(t, err)
+	router := s.newRouter()
+
+	// Other tests may leave background operations in the shared tracker, so only the op
+	// started here is checked
+	running := func() bool {
+		w := httptest.NewRecorder()
+		router.ServeHTTP(w, httptest.NewRequest(http.MethodGet, "/inflight", nil))
+		require.Equal(t, http.StatusOK, w.Code)
+		var resp protocol.InFlightResponse
+		require.NoError(t, json.Unmarshal(w.Body.Bytes(), &resp))
+		for _, op := range resp.Ops {
+			if op.Kind == inflight.KindUpload && op.Detail == "b/01/test" {
+				require.GreaterOrEqual(t, op.Elapsed, time.Duration(0))
+				return true
+			}
+		}
+		return false
+	}
+
+	done := inflight.Default.Start(inflight.KindUpload, "b/01/test")
+	require.True(t, running())
+	done()
+	require.False(t, running())
+}
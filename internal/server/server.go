@@ -2,20 +2,22 @@ package server
 
 import (
 	"context"
+	"crypto/tls"
 	"fmt"
 	"net"
 	"net/http"
 	"os"
 	"os/signal"
 	"path/filepath"
+	"sync"
 	"syscall"
 	"time"
 
 	"github.com/breezewish/gscache/internal/cache"
-	"github.com/breezewish/gscache/internal/cache/backends/blob"
-	"github.com/breezewish/gscache/internal/cache/backends/local"
 	"github.com/breezewish/gscache/internal/log"
 	"github.com/breezewish/gscache/internal/stats"
+	"github.com/breezewish/gscache/internal/util"
+	"github.com/breezewish/gscache/internal/workdir"
 	"github.com/nightlyone/lockfile"
 	"go.uber.org/zap"
 	"golang.org/x/sync/errgroup"
@@ -27,40 +29,59 @@ const (
 
 // Server is the gscache daemon server. All cacheprog simply talks to this server via HTTP REST API.
 type Server struct {
-	config  Config
-	backend cache.Backend
+	config     Config
+	configMu   sync.Mutex             // Guards config, which may be changed by Reload
+	loadConfig func() (Config, error) // Used by Reload, see SetConfigLoader
+	backend    cache.Backend
 
 	activityCh chan struct{} // Channel to track server activity
+	latency    *latencyMetrics
+	getLimiter *requestLimiter // nil if not limited
+	putLimiter *requestLimiter // nil if not limited
+	accessLog  *zap.Logger
 
 	lifecycle      context.Context    // Can be used to track server's stop. Only available after Run is called
 	lifecycleClose context.CancelFunc // Only available after Run is called
+
+	startedAt time.Time
+	clock     util.Clock // Used for uptime and inactivity, see Config.Clock
 }
 
 func NewServer(config Config) (*Server, error) {
-	if err := os.MkdirAll(config.Dir, 0755); err != nil {
+	if config.ReadOnly {
+		if _, err := os.Stat(config.Dir); err != nil {
+			return nil, fmt.Errorf("cache directory is not accessible: %w", err)
+		}
+	} else if err := os.MkdirAll(config.Dir, 0755); err != nil {
 		return nil, fmt.Errorf("failed to create cache directory: %w", err)
 	}
-	var backend cache.Backend
-	var err error
-	if config.Blob.URL == "" {
-		backend, err = local.NewLocalBackend(config.Dir)
-	} else {
-		config.Blob.WorkDir = config.Dir
-		backend, err = blob.NewBlobBackend(config.Blob)
-	}
+	backend, err := NewBackend(config)
 	if err != nil {
 		return nil, fmt.Errorf("failed to create backend: %w", err)
 	}
+	clock := util.ClockOrReal(config.Clock)
 	return &Server{
 		config:     config,
 		backend:    backend,
 		activityCh: make(chan struct{}, 1),
+		latency:    newLatencyMetrics(),
+		getLimiter: newRequestLimiter(config.Limits.Get),
+		putLimiter: newRequestLimiter(config.Limits.Put),
+		accessLog:  log.Named("access"),
+		startedAt:  clock.Now(),
+		clock:      clock,
 	}, nil
 }
 
 // lockWorkDir ensures local cache dir is not reused by multiple daemons.
 func (s *Server) lockWorkDir() (lockfile.Lockfile, error) {
-	lockfilePath := filepath.Join(s.config.Dir, ".gscache_daemon.lock")
+	return LockWorkDir(s.config.Dir)
+}
+
+// LockWorkDir acquires the exclusive lock of the work dir. It fails if the work dir
+// is being used by a running daemon or another process that opens the cache backend.
+func LockWorkDir(dir string) (lockfile.Lockfile, error) {
+	lockfilePath := filepath.Join(dir, ".gscache_daemon.lock")
 	log.Info("Acquiring lock for work dir",
 		zap.String("lockfile", lockfilePath))
 
@@ -74,30 +95,57 @@ func (s *Server) lockWorkDir() (lockfile.Lockfile, error) {
 		return lockfile.Lockfile(""), err
 	}
 	if err := lock.TryLock(); err != nil {
-		return lockfile.Lockfile(""), fmt.Errorf("work dir '%s' is in use by another daemon: %w", s.config.Dir, err)
+		return lockfile.Lockfile(""), fmt.Errorf("work dir '%s' is in use by another daemon: %w", dir, err)
 	}
 	return lock, nil
 }
 
+// MigrateWorkDir brings the layout of the work dir to the version of this gscache, which must
+// be done before the backend is opened. The work dir must be locked by the caller. A read-only
+// work dir is only checked to be servable.
+func MigrateWorkDir(config Config) error {
+	if config.ReadOnly {
+		return workdir.CheckReadOnly(config.Dir)
+	}
+	return workdir.Migrate(config.Dir, func(m workdir.Migration) {
+		log.Info("Migrating work dir layout",
+			zap.String("dir", config.Dir),
+			zap.Int("from", m.From),
+			zap.Int("to", m.From+1),
+			zap.String("migration", m.Description))
+	})
+}
+
 func (s *Server) startInactivityMonitor() {
 	if s.config.ShutdownAfterInactivity <= 0 {
 		return
 	}
 
 	log.Info("Server is configured to shutdown after inactivity",
-		zap.String("inactivityTimeout", s.config.ShutdownAfterInactivity.String()))
+		zap.String("inactivityTimeout", s.config.ShutdownAfterInactivity.String()),
+		zap.String("minUptime", s.config.MinUptimeBeforeInactivityShutdown.String()))
 
-	lastActive := time.Now()
-	shutdownTimer := time.NewTimer(s.config.ShutdownAfterInactivity)
+	lastActive := s.clock.Now()
+	shutdownTimer := s.clock.NewTimer(s.config.ShutdownAfterInactivity)
 
 	// Worker routine
 	go func() {
 		for {
 			select {
 			case <-s.activityCh:
-				lastActive = time.Now()
+				lastActive = s.clock.Now()
 				shutdownTimer.Reset(s.config.ShutdownAfterInactivity)
-			case <-shutdownTimer.C:
+			case <-shutdownTimer.C():
+				if wait := s.inactivityShutdownDelay(lastActive, s.clock.Now()); wait > 0 {
+					shutdownTimer.Reset(wait)
+					continue
+				}
+				if !s.flushBeforeInactivityShutdown() {
+					log.Info("Server became active while flushing uploads, inactivity shutdown is cancelled")
+					lastActive = s.clock.Now()
+					shutdownTimer.Reset(s.config.ShutdownAfterInactivity)
+					continue
+				}
 				log.Warn("Server idle, shutting down", zap.Time("lastActive", lastActive))
 				s.Shutdown()
 			case <-s.lifecycle.Done():
@@ -108,32 +156,146 @@ func (s *Server) startInactivityMonitor() {
 	}()
 }
 
+// flushBeforeInactivityShutdown waits for pending uploads to be persisted, bounded by
+// FlushDeadline. Returns false if a request comes in meanwhile, so that the shutdown
+// should be cancelled.
+func (s *Server) flushBeforeInactivityShutdown() bool {
+	backend, ok := s.backend.(cache.BackendSupportFlush)
+	if !ok {
+		return true
+	}
+	pending := backend.PendingUploads()
+	if pending > 0 {
+		log.Info("Flushing pending uploads before inactivity shutdown",
+			zap.Int("pending", pending),
+			zap.String("deadline", s.config.FlushDeadline.String()))
+		t := time.Now()
+		ctx, cancel := context.WithTimeout(s.lifecycle, s.config.FlushDeadline)
+		remaining := backend.Flush(ctx)
+		cancel()
+		if remaining > 0 {
+			log.Warn("Deadline exceeded while flushing uploads, remaining uploads may be lost",
+				zap.Int("flushed", max(pending-remaining, 0)),
+				zap.Int("remaining", remaining),
+				zap.String("cost", time.Since(t).String()))
+		} else {
+			log.Info("Flushed pending uploads",
+				zap.Int("flushed", pending),
+				zap.String("cost", time.Since(t).String()))
+		}
+	}
+	select {
+	case <-s.activityCh:
+		return false
+	default:
+		return true
+	}
+}
+
+// inactivityShutdownDelay returns how long to wait before the server can be shut down
+// for inactivity, or 0 if it should be shut down now. The server is never shut down
+// within MinUptimeBeforeInactivityShutdown after start, to avoid start/stop churn when
+// builds come in waves.
+func (s *Server) inactivityShutdownDelay(lastActive time.Time, now time.Time) time.Duration {
+	wait := lastActive.Add(s.config.ShutdownAfterInactivity).Sub(now)
+	if minUptimeWait := s.startedAt.Add(s.config.MinUptimeBeforeInactivityShutdown).Sub(now); minUptimeWait > wait {
+		wait = minUptimeWait
+	}
+	if wait < 0 {
+		return 0
+	}
+	return wait
+}
+
 // Run starts the gscache server, returns error if start failed.
 // Blocks until the server is stopped (by signal or as request).
 func (s *Server) Run() error {
-	dirLock, err := s.lockWorkDir()
+	// Checked before opening the backend, which may take long
+	listenAddr := s.config.ListenAddr()
+	if !util.IsLoopbackAddr(listenAddr) && s.config.Auth.Token == "" {
+		return fmt.Errorf("listening on non-loopback address %s requires auth.token", listenAddr)
+	}
+	tlsConfig, err := s.config.TLS.serverConfig()
 	if err != nil {
 		return err
 	}
-	defer dirLock.Unlock()
 
-	ctx, cancel := context.WithTimeout(context.Background(), 5*time.Second)
-	defer cancel()
-	err = s.backend.Open(ctx)
+	// A read-only work dir is never modified, so it can be shared by multiple daemons.
+	if !s.config.ReadOnly {
+		dirLock, err := s.lockWorkDir()
+		if err != nil {
+			return err
+		}
+		defer dirLock.Unlock()
+	}
+	if err := MigrateWorkDir(s.config); err != nil {
+		return err
+	}
+
+	// Signal handler is installed before opening the backend, so that a slow startup
+	// (e.g. syncing archives on a cold start) can be aborted by SIGTERM.
+	sigtermCh := make(chan os.Signal, 1)
+	signal.Notify(sigtermCh, syscall.SIGINT, syscall.SIGTERM)
+	defer signal.Stop(sigtermCh)
+	// SIGHUP is installed early as well, otherwise it terminates the process during startup.
+	// It is handled after the server is started.
+	sighupCh := make(chan os.Signal, 1)
+	signal.Notify(sighupCh, syscall.SIGHUP)
+	defer signal.Stop(sighupCh)
+
+	openCtx, openCancel := context.WithCancel(context.Background())
+	defer openCancel()
+	openDone := make(chan struct{})
+	go func() {
+		select {
+		case sig := <-sigtermCh:
+			log.Info("Received shutdown signal during startup", zap.String("signal", sig.String()))
+			openCancel()
+			// Put it back so that it is handled as usual if backend is already opened.
+			sigtermCh <- sig
+		case <-openDone:
+		}
+	}()
+	err = s.backend.Open(openCtx)
+	close(openDone)
 	if err != nil {
+		if openCtx.Err() != nil {
+			log.Info("Server startup aborted", zap.Error(err))
+			return nil
+		}
 		return err
 	}
 
 	// Start the listener
-	listenAddr := fmt.Sprintf("127.0.0.1:%d", s.config.Port)
-	log.Info("Starting gscache server", zap.Any("config", s.config))
+	log.Info("Starting gscache server", zap.Any("config", util.Redact(s.config)))
+	if !util.IsLoopbackAddr(listenAddr) && tlsConfig == nil {
+		log.Warn("Listening on non-loopback address without tls, the auth token is sent in plain text",
+			zap.String("listen", listenAddr))
+	}
 
 	listener, err := net.Listen("tcp", listenAddr)
 	if err != nil {
 		return err
 	}
+	if tlsConfig != nil {
+		listener = tls.NewListener(listener, tlsConfig)
+	}
+	var unixListener net.Listener
+	if socketPath := s.config.SocketPath(); socketPath != "" {
+		unixListener, err = listenUnix(socketPath)
+		if err != nil {
+			if s.config.Socket != "" {
+				_ = listener.Close()
+				return err
+			}
+			// The default socket is best effort, e.g. the path may be too long for a unix socket
+			log.Warn("Failed to listen on unix socket, only listen on TCP port",
+				zap.String("socket", socketPath),
+				zap.Error(err))
+		}
+	}
 
-	ctx, cancel = context.WithCancel(context.Background())
+	ctx, cancel := context.WithCancel(context.Background())
 	s.lifecycle = ctx
 	s.lifecycleClose = cancel
 	defer cancel()
@@ -144,9 +306,6 @@ func (s *Server) Run() error {
 		Handler: router.Handler(),
 	}
 
-	sigtermCh := make(chan os.Signal, 1)
-	signal.Notify(sigtermCh, syscall.SIGINT, syscall.SIGTERM)
-
 	shutdownWg := errgroup.Group{}
 	shutdownWg.Go(func() error {
 		select {
@@ -175,6 +334,16 @@ func (s *Server) Run() error {
 	})
 
 	s.startInactivityMonitor()
+	go s.reloadOnSignal(sighupCh)
+
+	if unixListener != nil {
+		shutdownWg.Go(func() error {
+			if err := server.Serve(unixListener); err != nil && err != http.ErrServerClosed {
+				log.Warn("Failed to serve on unix socket", zap.Error(err))
+			}
+			return nil
+		})
+	}
 
 	log.Info("Server is started")
 
@@ -195,6 +364,33 @@ func (s *Server) Run() error {
 	return retErr
 }
 
+// listenUnix listens on the unix socket, which is only accessible by the current user.
+// A stale socket left by a crashed daemon is replaced, but not one served by a running daemon.
+func listenUnix(path string) (net.Listener, error) {
+	if fi, err := os.Lstat(path); err == nil {
+		if fi.Mode()&os.ModeSocket == 0 {
+			return nil, fmt.Errorf("'%s' exists and is not a unix socket", path)
+		}
+		if conn, err := net.DialTimeout("unix", path, time.Second); err == nil {
+			_ = conn.Close()
+			return nil, fmt.Errorf("unix socket '%s' is in use by another daemon", path)
+		}
+		if err := os.Remove(path); err != nil {
+			return nil, fmt.Errorf("failed to remove stale unix socket: %w", err)
+		}
+	}
+	listener, err := net.Listen("unix", path)
+	if err != nil {
+		return nil, err
+	}
+	// The socket file is removed when the listener is closed
+	if err := os.Chmod(path, 0600); err != nil {
+		_ = listener.Close()
+		return nil, fmt.Errorf("failed to restrict permissions of unix socket: %w", err)
+	}
+	return listener, nil
+}
+
 func (s *Server) Shutdown() {
 	s.lifecycleClose()
 }
@@ -0,0 +1,228 @@
+package server
+
+import (
+	"context"
+	"crypto/tls"
+	"crypto/x509"
+	"net"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"path/filepath"
+	"sync/atomic"
+	"syscall"
+	"testing"
+	"time"
+
+	"github.com/gin-gonic/gin"
+	"github.com/stretchr/testify/require"
+
+	"github.com/breezewish/gscache/internal/cache"
+	"github.com/breezewish/gscache/internal/client"
+	"github.com/breezewish/gscache/internal/protocol"
+	"github.com/breezewish/gscache/internal/util"
+)
+
+func TestInactivityShutdownDelay(t *testing.T) {
+	startedAt := time.Date(2025, 1, 1, 0, 0, 0, 0, time.UTC)
+	s := &Server{startedAt: startedAt}
+	s.config.ShutdownAfterInactivity = time.Minute
+
+	require.Equal(t, time.Duration(0), s.inactivityShutdownDelay(startedAt, startedAt.Add(time.Minute)))
+	require.Equal(t, 30*time.Second, s.inactivityShutdownDelay(startedAt.Add(30*time.Second), startedAt.Add(time.Minute)))
+
+	// Not shut down within the min uptime, even if inactive.
+	s.config.MinUptimeBeforeInactivityShutdown = 10 * time.Minute
+	require.Equal(t, 9*time.Minute, s.inactivityShutdownDelay(startedAt, startedAt.Add(time.Minute)))
+	require.Equal(t, time.Duration(0), s.inactivityShutdownDelay(startedAt, startedAt.Add(10*time.Minute)))
+	require.Equal(t, 30*time.Second, s.inactivityShutdownDelay(startedAt.Add(10*time.Minute), startedAt.Add(10*time.Minute+30*time.Second)))
+}
+
+func TestInactivityMonitor(t *testing.T) {
+	clock := util.NewFakeClock(time.Date(2025, 1, 1, 0, 0, 0, 0, time.UTC))
+	cfg := DefaultConfig()
+	cfg.ShutdownAfterInactivity = time.Minute
+	cfg.MinUptimeBeforeInactivityShutdown = 5 * time.Minute
+	s := &Server{config: cfg, activityCh: make(chan struct{}, 1), startedAt: clock.Now(), clock: clock}
+	s.lifecycle, s.lifecycleClose = context.WithCancel(context.Background())
+	defer s.lifecycleClose()
+	s.startInactivityMonitor()
+	require.Equal(t, 1, clock.Timers())
+
+	// Inactive, but still within the min uptime
+	clock.Advance(time.Minute)
+	require.Eventually(t, func() bool { return clock.Timers() == 1 }, 5*time.Second, time.Millisecond)
+	require.NoError(t, s.lifecycle.Err())
+
+	clock.Advance(4*time.Minute - time.Second)
+	require.Equal(t, 1, clock.Timers())
+	require.NoError(t, s.lifecycle.Err())
+	clock.Advance(time.Second)
+	select {
+	case <-s.lifecycle.Done():
+	case <-time.After(5 * time.Second):
+		t.Fatal("Server is not shut down after inactivity")
+	}
+}
+
+type flushTestBackend struct {
+	cache.Backend
+	pending int
+	flushed bool
+}
+
+func (b *flushTestBackend) PendingUploads() int {
+	return b.pending
+}
+
+func (b *flushTestBackend) Flush(ctx context.Context) int {
+	if b.flushed {
+		b.pending = 0
+		return 0
+	}
+	<-ctx.Done()
+	return b.pending
+}
+
+func TestFlushBeforeInactivityShutdown(t *testing.T) {
+	backend := &flushTestBackend{pending: 3, flushed: true}
+	s := &Server{
+		backend:    backend,
+		activityCh: make(chan struct{}, 1),
+		lifecycle:  context.Background(),
+	}
+	s.config.FlushDeadline = time.Minute
+	require.True(t, s.flushBeforeInactivityShutdown())
+	require.Equal(t, 0, backend.pending)
+
+	// Bounded by the deadline
+	backend.pending = 2
+	backend.flushed = false
+	s.config.FlushDeadline = 10 * time.Millisecond
+	require.True(t, s.flushBeforeInactivityShutdown())
+	require.Equal(t, 2, backend.pending)
+
+	// Cancelled by new activity
+	s.activityCh <- struct{}{}
+	require.False(t, s.flushBeforeInactivityShutdown())
+}
+
+type blockingOpenBackend struct {
+	cache.Backend
+	opening chan struct{}
+}
+
+func (b *blockingOpenBackend) Open(ctx context.Context) error {
+	close(b.opening)
+	<-ctx.Done()
+	return ctx.Err()
+}
+
+func TestRunAbortedDuringOpen(t *testing.T) {
+	l, err := net.Listen("tcp", "127.0.0.1:0")
+	require.NoError(t, err)
+	port := l.Addr().(*net.TCPAddr).Port
+	require.NoError(t, l.Close())
+
+	cfg := DefaultConfig()
+	cfg.Dir = t.TempDir()
+	cfg.Port = port
+	s, err := NewServer(cfg)
+	require.NoError(t, err)
+	backend := &blockingOpenBackend{Backend: s.backend, opening: make(chan struct{})}
+	s.backend = backend
+
+	runDone := make(chan error, 1)
+	go func() { runDone <- s.Run() }()
+	<-backend.opening
+	require.NoError(t, syscall.Kill(os.Getpid(), syscall.SIGTERM))
+	select {
+	case err := <-runDone:
+		require.NoError(t, err)
+	case <-time.After(5 * time.Second):
+		t.Fatal("Run is not aborted by SIGTERM during backend open")
+	}
+	// The listener is never started
+	_, err = net.DialTimeout("tcp", cfg.ListenAddr(), time.Second)
+	require.Error(t, err)
+}
+
+func TestListenUnix(t *testing.T) {
+	// Not t.TempDir(), which may exceed the length limit of unix socket paths
+	dir, err := os.MkdirTemp("", "gscache")
+	require.NoError(t, err)
+	defer os.RemoveAll(dir)
+	socketPath := filepath.Join(dir, "gscache.sock")
+
+	listener, err := listenUnix(socketPath)
+	require.NoError(t, err)
+	fi, err := os.Stat(socketPath)
+	require.NoError(t, err)
+	require.Equal(t, os.FileMode(0600), fi.Mode().Perm())
+
+	gin.SetMode(gin.ReleaseMode)
+	router := gin.New()
+	var loopback atomic.Bool
+	router.GET("/ping", func(c *gin.Context) {
+		loopback.Store(isLoopbackRemote(c.Request))
+		c.JSON(http.StatusOK, protocol.PingResponse{Pid: 42})
+	})
+	server := &http.Server{Handler: router.Handler()}
+	go func() { _ = server.Serve(listener) }()
+
+	// Nothing listens on the port, so the request must go through the socket
+	c := client.NewClient(client.Config{DaemonPort: 1, SocketPath: socketPath})
+	ping, err := c.CallPing()
+	require.NoError(t, err)
+	require.Equal(t, 42, ping.Pid)
+	require.True(t, loopback.Load())
+
+	_, err = listenUnix(socketPath)
+	require.ErrorContains(t, err, "in use by another daemon")
+
+	require.NoError(t, server.Close())
+	_, err = os.Stat(socketPath)
+	require.True(t, os.IsNotExist(err))
+
+	// A stale socket is replaced
+	stale, err := net.Listen("unix", socketPath)
+	require.NoError(t, err)
+	stale.(*net.UnixListener).SetUnlinkOnClose(false)
+	require.NoError(t, stale.Close())
+	listener, err = listenUnix(socketPath)
+	require.NoError(t, err)
+	require.NoError(t, listener.Close())
+
+	// Other files are never removed
+	require.NoError(t, os.WriteFile(socketPath, []byte("data"), 0644))
+	_, err = listenUnix(socketPath)
+	require.ErrorContains(t, err, "is not a unix socket")
+}
+
+func TestClientTLS(t *testing.T) {
+	gin.SetMode(gin.ReleaseMode)
+	router := gin.New()
+	router.GET("/ping", func(c *gin.Context) {
+		c.JSON(http.StatusOK, protocol.PingResponse{Pid: 42})
+	})
+	ts := httptest.NewTLSServer(router)
+	defer ts.Close()
+	addr := ts.Listener.Addr().(*net.TCPAddr)
+	roots := x509.NewCertPool()
+	roots.AddCert(ts.Certificate())
+
+	// Falls back to TLS over TCP, as nothing listens on the socket
+	c := client.NewClient(client.Config{
+		DaemonPort: addr.Port,
+		SocketPath: filepath.Join(t.TempDir(), "gscache.sock"),
+		TLS:        &tls.Config{RootCAs: roots},
+	})
+	ping, err := c.CallPing()
+	require.NoError(t, err)
+	require.Equal(t, 42, ping.Pid)
+
+	// The certificate is not trusted
+	c = client.NewClient(client.Config{DaemonPort: addr.Port, TLS: &tls.Config{}})
+	_, err = c.CallPing()
+	require.ErrorContains(t, err, "certificate")
+}
@@ -0,0 +1,138 @@
+package server
+
+import (
+	"context"
+	"encoding/hex"
+	"fmt"
+	"io/fs"
+	"os"
+	"path/filepath"
+	"slices"
+	"strings"
+
+	gocloudblob "gocloud.dev/blob"
+
+	"github.com/breezewish/gscache/internal/cache/backends/blob"
+	"github.com/breezewish/gscache/internal/protocol"
+	"github.com/breezewish/gscache/internal/util"
+)
+
+// tempDirPattern matches temp files and dirs of compaction, clean, verify and read-only mode
+// in the system temp dir, which are left behind if the process is killed.
+const tempDirPattern = "gscache_*"
+
+// sizeGroup returns the group of a file in the work dir by its relative path. Subdirs of
+// data/ are grouped by their first hex char, which is also how keyspaces are split.
+func sizeGroup(rel string) string {
+	if strings.Contains(filepath.Base(rel), ".tmp") {
+		return "temp"
+	}
+	parts := strings.Split(filepath.ToSlash(rel), "/")
+	if len(parts) == 1 {
+		return parts[0]
+	}
+	switch parts[0] {
+	case "data":
+		if len(parts) == 2 {
+			return "data"
+		}
+		if _, err := hex.DecodeString(parts[1]); err == nil && len(parts[1]) == 2 {
+			return "data/" + parts[1][:1] + "*"
+		}
+		return "data/" + parts[1]
+	case "blobar":
+		return "blobar/" + parts[1]
+	}
+	return parts[0]
+}
+
+// LocalUsage sums sizes of files in the work dir by group, see sizeGroup, and gscache temp
+// files in the system temp dir.
+func LocalUsage(dir string) ([]protocol.SizeItem, error) {
+	groups := make(map[string]*protocol.SizeItem)
+	add := func(name string, size int64) {
+		g := groups[name]
+		if g == nil {
+			g = &protocol.SizeItem{Name: name}
+			groups[name] = g
+		}
+		g.Files++
+		g.Bytes += size
+	}
+	walk := func(root string, groupOf func(rel string) string) error {
+		return filepath.WalkDir(root, func(path string, d fs.DirEntry, err error) error {
+			if err != nil {
+				if os.IsNotExist(err) {
+					// Removed meanwhile
+					return nil
+				}
+				return err
+			}
+			if !d.Type().IsRegular() {
+				return nil
+			}
+			info, err := d.Info()
+			if err != nil {
+				return nil
+			}
+			rel, err := filepath.Rel(root, path)
+			if err != nil {
+				return err
+			}
+			add(groupOf(rel), info.Size())
+			return nil
+		})
+	}
+	if err := walk(dir, sizeGroup); err != nil {
+		return nil, err
+	}
+	temps, _ := filepath.Glob(filepath.Join(os.TempDir(), tempDirPattern))
+	for _, path := range temps {
+		if err := walk(path, func(string) string { return "temp" }); err != nil {
+			return nil, err
+		}
+	}
+
+	items := make([]protocol.SizeItem, 0, len(groups))
+	for _, g := range groups {
+		items = append(items, *g)
+	}
+	slices.SortFunc(items, func(a, b protocol.SizeItem) int {
+		return strings.Compare(a.Name, b.Name)
+	})
+	return items, nil
+}
+
+// ComputeSize reports disk usage of the work dir, and also usage of the remote bucket if
+// remote is set.
+func ComputeSize(ctx context.Context, config Config, remote bool) (*protocol.SizeResponse, error) {
+	items, err := LocalUsage(config.Dir)
+	if err != nil {
+		return nil, fmt.Errorf("failed to compute local usage: %w", err)
+	}
+	resp := &protocol.SizeResponse{Dir: config.Dir, Local: items}
+	for _, item := range items {
+		resp.LocalBytes += item.Bytes
+	}
+	if !remote {
+		return resp, nil
+	}
+
+	if config.Blob.URL == "" {
+		return nil, fmt.Errorf("blob.url is not set")
+	}
+	layout, err := blob.ParseKeyLayout(config.Blob.KeyLayout)
+	if err != nil {
+		return nil, err
+	}
+	bucket, err := gocloudblob.OpenBucket(ctx, config.Blob.URL)
+	if err != nil {
+		return nil, fmt.Errorf("failed to open %s: %w", util.RedactURL(config.Blob.URL), err)
+	}
+	defer bucket.Close()
+	if resp.Remote, err = blob.RemoteUsage(ctx, bucket, layout); err != nil {
+		return nil, err
+	}
+	resp.Remote.URL = util.RedactURL(config.Blob.URL)
+	return resp, nil
+}
@@ -0,0 +1,51 @@
+package server
+
+import (
+	"context"
+	"os"
+	"path/filepath"
+	"testing"
+
+	"github.com/stretchr/testify/require"
+)
+
+func TestSizeGroup(t *testing.T) {
+	require.Equal(t, "data/a*", sizeGroup("data/a1/a1b2.action"))
+	require.Equal(t, "data/ns", sizeGroup("data/ns/go1.24/a1/a1b2.action"))
+	require.Equal(t, "data/_empty", sizeGroup("data/_empty/x.output"))
+	require.Equal(t, "data", sizeGroup("data/_empty.output"))
+	require.Equal(t, "temp", sizeGroup("data/a1/a1b2.output.tmp.abc"))
+	require.Equal(t, "blobar/a.zip", sizeGroup("blobar/a.zip"))
+	require.Equal(t, "stats-history", sizeGroup("stats-history/1.json.gz"))
+	require.Equal(t, "stats.json", sizeGroup("stats.json"))
+}
+
+func TestComputeSize(t *testing.T) {
+	cfg := DefaultConfig()
+	cfg.Dir = t.TempDir()
+	write := func(rel string, size int) {
+		path := filepath.Join(cfg.Dir, rel)
+		require.NoError(t, os.MkdirAll(filepath.Dir(path), 0755))
+		require.NoError(t, os.WriteFile(path, make([]byte, size), 0644))
+	}
+	write("data/a1/x.action", 10)
+	write("data/a2/y.output", 20)
+	write("data/a2/y.output.tmp.1", 5)
+	write("blobar/a.zip", 100)
+
+	resp, err := ComputeSize(context.Background(), cfg, false)
+	require.NoError(t, err)
+	require.Nil(t, resp.Remote)
+	bytes := make(map[string]int64)
+	for _, item := range resp.Local {
+		bytes[item.Name] = item.Bytes
+	}
+	require.Equal(t, int64(30), bytes["data/a*"])
+	require.Equal(t, int64(100), bytes["blobar/a.zip"])
+	// Other gscache processes may leave temp files in the system temp dir
+	require.GreaterOrEqual(t, bytes["temp"], int64(5))
+	require.Equal(t, int64(130)+bytes["temp"], resp.LocalBytes)
+
+	_, err = ComputeSize(context.Background(), cfg, true)
+	require.ErrorContains(t, err, "blob.url")
+}
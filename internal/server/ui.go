@@ -0,0 +1,8 @@
+package server
+
+import _ "embed"
+
+// uiPage is a static status page which polls GET /stats.
+//
+//go:embed ui/index.html
+var uiPage []byte
@@ -0,0 +1,143 @@
+package service
+
+import (
+	"bytes"
+	"encoding/xml"
+	"fmt"
+	"sort"
+	"strings"
+)
+
+// This package generates service definitions, so that the daemon is managed by the service
+// manager of the OS (systemd user units on Linux, launchd agents on macOS) and survives reboots,
+// instead of being started lazily by `gscache prog`.
+
+const (
+	SystemdUnitName = "gscache.service"
+	LaunchdLabel    = "io.github.breezewish.gscache"
+)
+
+// capturedEnvPrefixes are env variables copied into service definitions. Service managers start
+// services with a minimal environment, so config and credentials of the blob store in the current
+// shell would be lost otherwise.
+var capturedEnvPrefixes = []string{
+	"GSCACHE_",
+	"AWS_",
+	"GOOGLE_",
+	"AZURE_",
+	"HTTP_PROXY", "HTTPS_PROXY", "NO_PROXY",
+	"http_proxy", "https_proxy", "no_proxy",
+}
+
+type Spec struct {
+	Executable string // Absolute path of the gscache binary
+	Args       []string
+	Env        map[string]string
+	WorkingDir string // Relative paths in Args are resolved against this dir
+	LogFile    string // Stdout and stderr are appended to this file
+}
+
+// CaptureEnv returns env variables of environ (in the form of os.Environ) which affect the daemon.
+func CaptureEnv(environ []string) map[string]string {
+	env := make(map[string]string)
+	for _, kv := range environ {
+		key, value, ok := strings.Cut(kv, "=")
+		if !ok || key == "" {
+			continue
+		}
+		for _, prefix := range capturedEnvPrefixes {
+			if strings.HasPrefix(key, prefix) {
+				env[key] = value
+				break
+			}
+		}
+	}
+	return env
+}
+
+func sortedKeys(m map[string]string) []string {
+	keys := make([]string, 0, len(m))
+	for k := range m {
+		keys = append(keys, k)
+	}
+	sort.Strings(keys)
+	return keys
+}
+
+// systemdQuote quotes a word for ExecStart= and Environment=, where specifiers (%) and
+// variables ($) are also expanded by systemd.
+func systemdQuote(s string) string {
+	s = strings.NewReplacer(`\`, `\\`, `"`, `\"`, "%", "%%", "$", "$$", "\n", `\n`).Replace(s)
+	return `"` + s + `"`
+}
+
+// SystemdUnit returns a systemd user unit running the daemon.
+func SystemdUnit(spec Spec) string {
+	var b strings.Builder
+	b.WriteString("# Generated by `gscache daemon install`\n")
+	b.WriteString("[Unit]\n")
+	b.WriteString("Description=gscache daemon\n")
+	b.WriteString("After=network-online.target\n\n")
+	b.WriteString("[Service]\n")
+	b.WriteString("Type=simple\n")
+	words := make([]string, 0, len(spec.Args)+1)
+	for _, word := range append([]string{spec.Executable}, spec.Args...) {
+		words = append(words, systemdQuote(word))
+	}
+	fmt.Fprintf(&b, "ExecStart=%s\n", strings.Join(words, " "))
+	if spec.WorkingDir != "" {
+		fmt.Fprintf(&b, "WorkingDirectory=%s\n", systemdQuote(spec.WorkingDir))
+	}
+	for _, key := range sortedKeys(spec.Env) {
+		fmt.Fprintf(&b, "Environment=%s\n", systemdQuote(key+"="+spec.Env[key]))
+	}
+	if spec.LogFile != "" {
+		fmt.Fprintf(&b, "StandardOutput=append:%s\n", spec.LogFile)
+		fmt.Fprintf(&b, "StandardError=append:%s\n", spec.LogFile)
+	}
+	b.WriteString("Restart=on-failure\n")
+	b.WriteString("RestartSec=5s\n\n")
+	b.WriteString("[Install]\n")
+	b.WriteString("WantedBy=default.target\n")
+	return b.String()
+}
+
+func xmlEscape(s string) string {
+	var buf bytes.Buffer
+	_ = xml.EscapeText(&buf, []byte(s))
+	return buf.String()
+}
+
+// LaunchdPlist returns a launchd agent property list running the daemon.
+func LaunchdPlist(spec Spec) string {
+	var b strings.Builder
+	b.WriteString(`<?xml version="1.0" encoding="UTF-8"?>` + "\n")
+	b.WriteString(`<!DOCTYPE plist PUBLIC "-//Apple//DTD PLIST 1.0//EN" "http://www.apple.com/DTDs/PropertyList-1.0.dtd">` + "\n")
+	b.WriteString("<!-- Generated by `gscache daemon install` -->\n")
+	b.WriteString(`<plist version="1.0">` + "\n<dict>\n")
+	fmt.Fprintf(&b, "  <key>Label</key>\n  <string>%s</string>\n", LaunchdLabel)
+	b.WriteString("  <key>ProgramArguments</key>\n  <array>\n")
+	for _, word := range append([]string{spec.Executable}, spec.Args...) {
+		fmt.Fprintf(&b, "    <string>%s</string>\n", xmlEscape(word))
+	}
+	b.WriteString("  </array>\n")
+	if spec.WorkingDir != "" {
+		fmt.Fprintf(&b, "  <key>WorkingDirectory</key>\n  <string>%s</string>\n", xmlEscape(spec.WorkingDir))
+	}
+	if len(spec.Env) > 0 {
+		b.WriteString("  <key>EnvironmentVariables</key>\n  <dict>\n")
+		for _, key := range sortedKeys(spec.Env) {
+			fmt.Fprintf(&b, "    <key>%s</key>\n    <string>%s</string>\n", xmlEscape(key), xmlEscape(spec.Env[key]))
+		}
+		b.WriteString("  </dict>\n")
+	}
+	if spec.LogFile != "" {
+		fmt.Fprintf(&b, "  <key>StandardOutPath</key>\n  <string>%s</string>\n", xmlEscape(spec.LogFile))
+		fmt.Fprintf(&b, "  <key>StandardErrorPath</key>\n  <string>%s</string>\n", xmlEscape(spec.LogFile))
+	}
+	b.WriteString("  <key>RunAtLoad</key>\n  <true/>\n")
+	// Restart only when the daemon crashes
+	b.WriteString("  <key>KeepAlive</key>\n  <dict>\n    <key>SuccessfulExit</key>\n    <false/>\n  </dict>\n")
+	b.WriteString("</dict>\n</plist>\n")
+	return b.String()
+}
@@ -0,0 +1,71 @@
+package service
+
+import (
+	"encoding/xml"
+	"strings"
+	"testing"
+
+	"github.com/stretchr/testify/require"
+)
+
+func TestCaptureEnv(t *testing.T) {
+	env := CaptureEnv([]string{
+		"GSCACHE_BLOB_URL=s3://bucket",
+		"AWS_REGION=us-east-1",
+		"GOOGLE_APPLICATION_CREDENTIALS=/key.json",
+		"HTTPS_PROXY=http://proxy:3128",
+		"PATH=/usr/bin",
+		"HOME=/home/me",
+		"GSCACHE_EMPTY=",
+		"invalid",
+	})
+	require.Equal(t, map[string]string{
+		"GSCACHE_BLOB_URL":               "s3://bucket",
+		"AWS_REGION":                     "us-east-1",
+		"GOOGLE_APPLICATION_CREDENTIALS": "/key.json",
+		"HTTPS_PROXY":                    "http://proxy:3128",
+		"GSCACHE_EMPTY":                  "",
+	}, env)
+}
+
+func testSpec() Spec {
+	return Spec{
+		Executable: "/usr/local/bin/gscache",
+		Args:       []string{"server", "--port", "8511", "--blob.url", `s3://b?x="1"&y=%2F$HOME`},
+		Env:        map[string]string{"GSCACHE_LOG_LEVEL": "debug", "AWS_REGION": "us-east-1"},
+		WorkingDir: "/home/me/src",
+		LogFile:    "/home/me/.gscache/gscache.log",
+	}
+}
+
+func TestSystemdUnit(t *testing.T) {
+	unit := SystemdUnit(testSpec())
+	require.Contains(t, unit,
+		`ExecStart="/usr/local/bin/gscache" "server" "--port" "8511" "--blob.url" "s3://b?x=\"1\"&y=%%2F$$HOME"`+"\n")
+	require.Contains(t, unit, `WorkingDirectory="/home/me/src"`+"\n")
+	// Sorted by key
+	require.Contains(t, unit, `Environment="AWS_REGION=us-east-1"`+"\n"+`Environment="GSCACHE_LOG_LEVEL=debug"`+"\n")
+	require.Contains(t, unit, "StandardOutput=append:/home/me/.gscache/gscache.log\n")
+	require.Contains(t, unit, "WantedBy=default.target\n")
+
+	unit = SystemdUnit(Spec{Executable: "/gscache", Args: []string{"server"}})
+	require.NotContains(t, unit, "Environment=")
+	require.NotContains(t, unit, "StandardOutput=")
+}
+
+func TestLaunchdPlist(t *testing.T) {
+	plist := LaunchdPlist(testSpec())
+	require.Contains(t, plist, "<string>s3://b?x=&#34;1&#34;&amp;y=%2F$HOME</string>")
+	require.Contains(t, plist, "<key>AWS_REGION</key>\n    <string>us-east-1</string>")
+	require.Contains(t, plist, "<key>StandardOutPath</key>\n  <string>/home/me/.gscache/gscache.log</string>")
+
+	// Must be well-formed XML
+	d := xml.NewDecoder(strings.NewReader(plist))
+	for {
+		_, err := d.Token()
+		if err != nil {
+			require.Equal(t, "EOF", err.Error())
+			break
+		}
+	}
+}
@@ -0,0 +1,167 @@
+package stats
+
+import (
+	"encoding/csv"
+	"encoding/json"
+	"fmt"
+	"io"
+	"reflect"
+	"strconv"
+	"strings"
+
+	"go.uber.org/atomic"
+)
+
+// Export formats accepted by Export.
+const (
+	FormatJSON       = "json"
+	FormatCSV        = "csv"
+	FormatPrometheus = "prometheus"
+)
+
+// ExportFormats lists all export formats.
+var ExportFormats = []string{FormatJSON, FormatCSV, FormatPrometheus}
+
+// hitRatioName is the name of the derived hit ratio in exports, see Effectiveness.
+const hitRatioName = "Get.HitRatio"
+
+type Counter struct {
+	Name  string // json tags of the field and its parents, joined by "."
+	Value uint64
+	// True if the value may go down, e.g. the remaining budget, instead of only increasing
+	// until cleared. Marked by the `metric:"gauge"` tag.
+	Gauge bool
+}
+
+// Counters collects all counters in the Metrics via reflection, so that newly
+// added counters are exported automatically.
+func Counters(m *Metrics) []Counter {
+	counters := make([]Counter, 0)
+	collectCounters(reflect.ValueOf(m).Elem(), "", &counters)
+	return counters
+}
+
+func collectCounters(v reflect.Value, prefix string, out *[]Counter) {
+	t := v.Type()
+	for i := 0; i < t.NumField(); i++ {
+		f := t.Field(i)
+		if !f.IsExported() {
+			continue
+		}
+		name, _, _ := strings.Cut(f.Tag.Get("json"), ",")
+		if name == "-" {
+			continue
+		}
+		if name == "" {
+			name = f.Name
+		}
+		name = prefix + name
+		gauge := f.Tag.Get("metric") == "gauge"
+		switch c := v.Field(i).Addr().Interface().(type) {
+		case *atomic.Uint32:
+			*out = append(*out, Counter{Name: name, Value: uint64(c.Load()), Gauge: gauge})
+		case *atomic.Uint64:
+			*out = append(*out, Counter{Name: name, Value: c.Load(), Gauge: gauge})
+		default:
+			if f.Type.Kind() == reflect.Struct {
+				collectCounters(v.Field(i), name+".", out)
+			}
+		}
+	}
+}
+
+// Export writes all counters and the hit ratio in a machine readable format, one of ExportFormats.
+func (m *Metrics) Export(w io.Writer, format string) error {
+	switch format {
+	case FormatJSON:
+		return m.exportJSON(w)
+	case FormatCSV:
+		return m.exportCSV(w)
+	case FormatPrometheus:
+		return m.exportPrometheus(w, "gscache_")
+	}
+	return fmt.Errorf("unknown format %q, available: %s", format, strings.Join(ExportFormats, ", "))
+}
+
+// exportJSON writes a flat JSON object, keys are counter names.
+func (m *Metrics) exportJSON(w io.Writer) error {
+	values := make(map[string]any)
+	for _, c := range Counters(m) {
+		values[c.Name] = c.Value
+	}
+	values[hitRatioName] = m.Effectiveness(0).HitRatio
+	enc := json.NewEncoder(w)
+	enc.SetIndent("", "  ")
+	return enc.Encode(values)
+}
+
+func (m *Metrics) exportCSV(w io.Writer) error {
+	cw := csv.NewWriter(w)
+	_ = cw.Write([]string{"name", "value"})
+	for _, c := range Counters(m) {
+		_ = cw.Write([]string{c.Name, strconv.FormatUint(c.Value, 10)})
+	}
+	_ = cw.Write([]string{hitRatioName, strconv.FormatFloat(m.Effectiveness(0).HitRatio, 'f', -1, 64)})
+	cw.Flush()
+	return cw.Error()
+}
+
+// exportPrometheus writes the Prometheus text exposition format. Buckets of a SizeHistogram
+// (named like "0:<1KB") become a "bucket" label of the histogram metric.
+func (m *Metrics) exportPrometheus(w io.Writer, prefix string) error {
+	var b strings.Builder
+	lastName := ""
+	for _, c := range Counters(m) {
+		name, bucket := c.Name, ""
+		if idx := strings.LastIndexByte(name, '.'); idx >= 0 {
+			if _, label, ok := strings.Cut(name[idx+1:], ":"); ok {
+				name, bucket = name[:idx], label
+			}
+		}
+		name = prefix + PrometheusName(name)
+		if name != lastName {
+			typ := "counter"
+			if c.Gauge {
+				typ = "gauge"
+			}
+			fmt.Fprintf(&b, "# TYPE %s %s\n", name, typ)
+			lastName = name
+		}
+		if bucket != "" {
+			fmt.Fprintf(&b, "%s{bucket=%q} %d\n", name, bucket, c.Value)
+		} else {
+			fmt.Fprintf(&b, "%s %d\n", name, c.Value)
+		}
+	}
+	name := prefix + PrometheusName(hitRatioName)
+	fmt.Fprintf(&b, "# TYPE %s gauge\n%s %s\n", name, name,
+		strconv.FormatFloat(m.Effectiveness(0).HitRatio, 'f', -1, 64))
+	_, err := io.WriteString(w, b.String())
+	return err
+}
+
+// PrometheusName converts a counter name like "Blob.FromOrganic.Get.ByLocal" to a Prometheus
+// metric name like "blob_from_organic_get_by_local".
+func PrometheusName(name string) string {
+	var b strings.Builder
+	prevLower := false
+	for _, r := range name {
+		switch {
+		case r >= 'A' && r <= 'Z':
+			if prevLower {
+				b.WriteByte('_')
+			}
+			b.WriteRune(r - 'A' + 'a')
+			prevLower = false
+		case r >= 'a' && r <= 'z', r >= '0' && r <= '9':
+			b.WriteRune(r)
+			prevLower = true
+		default:
+			if s := b.String(); len(s) > 0 && s[len(s)-1] != '_' {
+				b.WriteByte('_')
+			}
+			prevLower = false
+		}
+	}
+	return strings.TrimSuffix(b.String(), "_")
+}
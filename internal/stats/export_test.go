@@ -0,0 +1,58 @@
+package stats
+
+import (
+	"bytes"
+	"encoding/json"
+	"testing"
+
+	"github.com/stretchr/testify/require"
+)
+
+func newExportTestMetrics() *Metrics {
+	m := NewMetrics()
+	m.GetTotal.Add(4)
+	m.GetHit.Add(3)
+	m.BlobOrganic.GetByLocalBytes.Add(100)
+	m.BlobEgress.LimitBytes.Store(1000)
+	m.PutSize.Observe(10)
+	return m
+}
+
+func TestPrometheusName(t *testing.T) {
+	require.Equal(t, "get_total", PrometheusName("Get.Total"))
+	require.Equal(t, "blob_from_organic_get_by_local_bytes", PrometheusName("Blob.FromOrganic.Get.ByLocal.Bytes"))
+	require.Equal(t, "blob_egress_budget_used_bytes", PrometheusName("Blob.Egress.Budget.UsedBytes"))
+	require.Equal(t, "local_gc_removed_entries", PrometheusName("Local.GC.Removed.Entries"))
+}
+
+func TestMetrics_ExportPrometheus(t *testing.T) {
+	var buf bytes.Buffer
+	require.NoError(t, newExportTestMetrics().Export(&buf, FormatPrometheus))
+	out := buf.String()
+	require.Contains(t, out, "# TYPE gscache_get_hit counter\ngscache_get_hit 3\n")
+	require.Contains(t, out, "gscache_blob_from_organic_get_by_local_bytes 100\n")
+	require.Contains(t, out, "# TYPE gscache_blob_egress_budget_limit_bytes gauge\ngscache_blob_egress_budget_limit_bytes 1000\n")
+	require.Contains(t, out, "# TYPE gscache_put_size counter\ngscache_put_size{bucket=\"<1KB\"} 1\ngscache_put_size{bucket=\"<4KB\"} 0\n")
+	require.Contains(t, out, "# TYPE gscache_get_hit_ratio gauge\ngscache_get_hit_ratio 0.75\n")
+}
+
+func TestMetrics_ExportJSON(t *testing.T) {
+	var buf bytes.Buffer
+	require.NoError(t, newExportTestMetrics().Export(&buf, FormatJSON))
+	var values map[string]float64
+	require.NoError(t, json.Unmarshal(buf.Bytes(), &values))
+	require.Equal(t, float64(3), values["Get.Hit"])
+	require.Equal(t, float64(1), values["Put.Size.0:<1KB"])
+	require.Equal(t, 0.75, values["Get.HitRatio"])
+}
+
+func TestMetrics_ExportCSV(t *testing.T) {
+	var buf bytes.Buffer
+	require.NoError(t, newExportTestMetrics().Export(&buf, FormatCSV))
+	out := buf.String()
+	require.True(t, bytes.HasPrefix(buf.Bytes(), []byte("name,value\nGet.Total,4\nGet.Hit,3\n")))
+	require.Contains(t, out, "Put.Size.0:<1KB,1\n")
+	require.Contains(t, out, "Get.HitRatio,0.75\n")
+
+	require.ErrorContains(t, NewMetrics().Export(&buf, "xml"), "unknown format")
+}
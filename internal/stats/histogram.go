@@ -0,0 +1,85 @@
+package stats
+
+import (
+	"fmt"
+	"strings"
+
+	"go.uber.org/atomic"
+)
+
+// SizeHistogram is a log-scale histogram of object sizes.
+// Bucket names are prefixed with the index so that they are displayed in order.
+type SizeHistogram struct {
+	Lt1KB  atomic.Uint32 `json:"0:<1KB"`
+	Lt4KB  atomic.Uint32 `json:"1:<4KB"`
+	Lt16KB atomic.Uint32 `json:"2:<16KB"`
+	Lt64KB atomic.Uint32 `json:"3:<64KB"`
+	Lt256K atomic.Uint32 `json:"4:<256KB"`
+	Lt1MB  atomic.Uint32 `json:"5:<1MB"`
+	Lt4MB  atomic.Uint32 `json:"6:<4MB"`
+	Lt16MB atomic.Uint32 `json:"7:<16MB"`
+	Lt64MB atomic.Uint32 `json:"8:<64MB"`
+	Ge64MB atomic.Uint32 `json:"9:>=64MB"`
+}
+
+type sizeBucket struct {
+	name    string
+	counter *atomic.Uint32
+}
+
+// buckets returns all buckets in ascending order. Each bucket covers sizes
+// less than 4x of the previous bucket's upper bound.
+func (h *SizeHistogram) buckets() []sizeBucket {
+	return []sizeBucket{
+		{"<1KB", &h.Lt1KB},
+		{"<4KB", &h.Lt4KB},
+		{"<16KB", &h.Lt16KB},
+		{"<64KB", &h.Lt64KB},
+		{"<256KB", &h.Lt256K},
+		{"<1MB", &h.Lt1MB},
+		{"<4MB", &h.Lt4MB},
+		{"<16MB", &h.Lt16MB},
+		{"<64MB", &h.Lt64MB},
+		{">=64MB", &h.Ge64MB},
+	}
+}
+
+func (h *SizeHistogram) Observe(size int64) {
+	buckets := h.buckets()
+	bound := int64(1 << 10)
+	for i := 0; i < len(buckets)-1; i++ {
+		if size < bound {
+			buckets[i].counter.Inc()
+			return
+		}
+		bound *= 4
+	}
+	buckets[len(buckets)-1].counter.Inc()
+}
+
+func (h *SizeHistogram) Clear() {
+	for _, b := range h.buckets() {
+		b.counter.Store(0)
+	}
+}
+
+// CumulativeSummary returns a human readable cumulative distribution,
+// like "<1KB: 10.0%, <4KB: 35.0%, ...". Returns empty string if there is no data.
+func (h *SizeHistogram) CumulativeSummary() string {
+	buckets := h.buckets()
+	var total uint64
+	for _, b := range buckets {
+		total += uint64(b.counter.Load())
+	}
+	if total == 0 {
+		return ""
+	}
+	parts := make([]string, 0, len(buckets)-1)
+	var acc uint64
+	// The last bucket is always 100% so it is omitted
+	for _, b := range buckets[:len(buckets)-1] {
+		acc += uint64(b.counter.Load())
+		parts = append(parts, fmt.Sprintf("%s: %.1f%%", b.name, float64(acc)*100/float64(total)))
+	}
+	return strings.Join(parts, ", ")
+}
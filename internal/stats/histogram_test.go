@@ -0,0 +1,42 @@
+package stats
+
+import (
+	"testing"
+
+	"github.com/stretchr/testify/require"
+)
+
+func TestSizeHistogram_Observe(t *testing.T) {
+	h := &SizeHistogram{}
+	h.Observe(0)
+	h.Observe(1023)
+	h.Observe(1024)
+	h.Observe(64<<10 - 1)
+	h.Observe(64 << 10)
+	h.Observe(64<<20 - 1)
+	h.Observe(64 << 20)
+	h.Observe(1 << 40)
+
+	require.Equal(t, uint32(2), h.Lt1KB.Load())
+	require.Equal(t, uint32(1), h.Lt4KB.Load())
+	require.Equal(t, uint32(1), h.Lt64KB.Load())
+	require.Equal(t, uint32(1), h.Lt256K.Load())
+	require.Equal(t, uint32(1), h.Lt64MB.Load())
+	require.Equal(t, uint32(2), h.Ge64MB.Load())
+
+	h.Clear()
+	require.Equal(t, uint32(0), h.Lt1KB.Load())
+	require.Equal(t, uint32(0), h.Ge64MB.Load())
+}
+
+func TestSizeHistogram_CumulativeSummary(t *testing.T) {
+	h := &SizeHistogram{}
+	require.Equal(t, "", h.CumulativeSummary())
+
+	h.Observe(100)
+	h.Observe(2000)
+	h.Observe(100 << 10)
+	h.Observe(100 << 20)
+	require.Equal(t, "<1KB: 25.0%, <4KB: 50.0%, <16KB: 50.0%, <64KB: 50.0%, <256KB: 75.0%, "+
+		"<1MB: 75.0%, <4MB: 75.0%, <16MB: 75.0%, <64MB: 75.0%", h.CumulativeSummary())
+}
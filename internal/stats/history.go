@@ -0,0 +1,159 @@
+package stats
+
+import (
+	"bytes"
+	"compress/gzip"
+	"encoding/json"
+	"fmt"
+	"os"
+	"path/filepath"
+	"sort"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/breezewish/gscache/internal/log"
+	"go.uber.org/zap"
+)
+
+const (
+	historySnapshotPrefix = "stats-"
+	historySnapshotSuffix = ".json.gz"
+	historyTimeLayout     = "20060102T150405Z"
+)
+
+// HistoryConfig configures periodic snapshots of stats, which are kept as a coarse
+// time series next to the stats file.
+type HistoryConfig struct {
+	Interval time.Duration `json:"interval"` // If 0, no snapshot is taken
+	Keep     int           `json:"keep"`     // Number of most recent snapshots to keep
+}
+
+func DefaultHistoryConfig() HistoryConfig {
+	return HistoryConfig{
+		Interval: 0,
+		Keep:     24,
+	}
+}
+
+// HistoryDir returns the directory of snapshots for the given stats file.
+func HistoryDir(statsPath string) string {
+	return filepath.Join(filepath.Dir(statsPath), "stats-history")
+}
+
+// SaveSnapshot writes the current stats as a gzip-compressed, timestamped snapshot in dir,
+// then removes old snapshots so that at most keep snapshots are left.
+func (m *Metrics) SaveSnapshot(dir string, keep int, now time.Time) error {
+	data, err := json.Marshal(m)
+	if err != nil {
+		return err
+	}
+	var buf bytes.Buffer
+	gz := gzip.NewWriter(&buf)
+	if _, err := gz.Write(data); err != nil {
+		return err
+	}
+	if err := gz.Close(); err != nil {
+		return err
+	}
+	if err := os.MkdirAll(dir, 0755); err != nil {
+		return fmt.Errorf("failed to create stats history dir: %w", err)
+	}
+	name := historySnapshotPrefix + now.UTC().Format(historyTimeLayout) + historySnapshotSuffix
+	if err := writeFileAtomic(filepath.Join(dir, name), buf.Bytes()); err != nil {
+		return fmt.Errorf("failed to write stats snapshot: %w", err)
+	}
+	return pruneSnapshots(dir, keep)
+}
+
+// ListSnapshots returns paths of all snapshots in dir, oldest first.
+func ListSnapshots(dir string) ([]string, error) {
+	entries, err := os.ReadDir(dir)
+	if err != nil {
+		if os.IsNotExist(err) {
+			return nil, nil
+		}
+		return nil, err
+	}
+	var paths []string
+	for _, entry := range entries {
+		name := entry.Name()
+		if entry.IsDir() || !strings.HasPrefix(name, historySnapshotPrefix) || !strings.HasSuffix(name, historySnapshotSuffix) {
+			continue
+		}
+		paths = append(paths, filepath.Join(dir, name))
+	}
+	// Timestamps in names sort in time order.
+	sort.Strings(paths)
+	return paths, nil
+}
+
+// LoadSnapshot loads a snapshot written by SaveSnapshot, and returns the time it was taken.
+func LoadSnapshot(path string) (*Metrics, time.Time, error) {
+	name := strings.TrimSuffix(strings.TrimPrefix(filepath.Base(path), historySnapshotPrefix), historySnapshotSuffix)
+	at, err := time.Parse(historyTimeLayout, name)
+	if err != nil {
+		return nil, time.Time{}, fmt.Errorf("invalid stats snapshot name %s: %w", path, err)
+	}
+	f, err := os.Open(path)
+	if err != nil {
+		return nil, time.Time{}, err
+	}
+	defer f.Close()
+	gz, err := gzip.NewReader(f)
+	if err != nil {
+		return nil, time.Time{}, fmt.Errorf("failed to read stats snapshot %s: %w", path, err)
+	}
+	m := NewMetrics()
+	if err := json.NewDecoder(gz).Decode(m); err != nil {
+		return nil, time.Time{}, fmt.Errorf("failed to decode stats snapshot %s: %w", path, err)
+	}
+	return m, at, nil
+}
+
+func pruneSnapshots(dir string, keep int) error {
+	if keep <= 0 {
+		return nil
+	}
+	paths, err := ListSnapshots(dir)
+	if err != nil {
+		return err
+	}
+	for len(paths) > keep {
+		_ = os.Remove(paths[0])
+		paths = paths[1:]
+	}
+	return nil
+}
+
+// StartHistory takes a snapshot of m every cfg.Interval until the returned stop function is called.
+// Nothing happens if the interval is 0.
+func (m *Metrics) StartHistory(dir string, cfg HistoryConfig) (stop func()) {
+	if cfg.Interval <= 0 {
+		return func() {}
+	}
+	done := make(chan struct{})
+	var wg sync.WaitGroup
+	wg.Add(1)
+	go func() {
+		defer wg.Done()
+		ticker := time.NewTicker(cfg.Interval)
+		defer ticker.Stop()
+		for {
+			select {
+			case <-done:
+				return
+			case now := <-ticker.C:
+				if err := m.SaveSnapshot(dir, cfg.Keep, now); err != nil {
+					log.Warn("Failed to save stats snapshot",
+						zap.String("dir", dir),
+						zap.Error(err))
+				}
+			}
+		}
+	}()
+	return func() {
+		close(done)
+		wg.Wait()
+	}
+}
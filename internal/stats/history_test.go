@@ -0,0 +1,50 @@
+package stats
+
+import (
+	"compress/gzip"
+	"encoding/json"
+	"os"
+	"path/filepath"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/require"
+)
+
+func TestMetrics_SaveSnapshot(t *testing.T) {
+	dir := filepath.Join(t.TempDir(), "stats-history")
+	m := NewMetrics()
+	start := time.Date(2025, 1, 1, 0, 0, 0, 0, time.UTC)
+	for i := 0; i < 5; i++ {
+		m.GetHit.Store(uint32(i))
+		require.NoError(t, m.SaveSnapshot(dir, 3, start.Add(time.Duration(i)*time.Hour)))
+	}
+
+	paths, err := ListSnapshots(dir)
+	require.NoError(t, err)
+	require.Equal(t, []string{
+		filepath.Join(dir, "stats-20250101T020000Z.json.gz"),
+		filepath.Join(dir, "stats-20250101T030000Z.json.gz"),
+		filepath.Join(dir, "stats-20250101T040000Z.json.gz"),
+	}, paths)
+
+	f, err := os.Open(paths[2])
+	require.NoError(t, err)
+	defer f.Close()
+	gz, err := gzip.NewReader(f)
+	require.NoError(t, err)
+	loaded := NewMetrics()
+	require.NoError(t, json.NewDecoder(gz).Decode(loaded))
+	require.Equal(t, uint32(4), loaded.GetHit.Load())
+
+	loaded, at, err := LoadSnapshot(paths[2])
+	require.NoError(t, err)
+	require.Equal(t, uint32(4), loaded.GetHit.Load())
+	require.Equal(t, start.Add(4*time.Hour), at)
+}
+
+func TestListSnapshots_NotExist(t *testing.T) {
+	paths, err := ListSnapshots(filepath.Join(t.TempDir(), "not-exist"))
+	require.NoError(t, err)
+	require.Empty(t, paths)
+}
@@ -11,28 +11,43 @@ type BlobMetrics struct {
 	GetByLocal          atomic.Uint32 `json:"Get.ByLocal"`
 	GetByArchive        atomic.Uint32 `json:"Get.ByArchive"`
 	GetByDownload       atomic.Uint32 `json:"Get.ByDownload"`
+	GetByLocalBytes     atomic.Uint64 `json:"Get.ByLocal.Bytes"` // Bytes served from each source. Bytes served by local and archive avoided the network.
+	GetByArchiveBytes   atomic.Uint64 `json:"Get.ByArchive.Bytes"`
+	GetByDownloadBytes  atomic.Uint64 `json:"Get.ByDownload.Bytes"`
 	DownloadBytes       atomic.Uint64 `json:"Download.Bytes"`
 	UploadedFiles       atomic.Uint32 `json:"Uploaded.Files"`
 	UploadedBytes       atomic.Uint64 `json:"Uploaded.Bytes"`
+	UploadSkipExists    atomic.Uint32 `json:"Upload.SkipExists"`     // How many IfAbsent uploads are skipped because the entry exists remotely.
 	ArchiveToLocalFiles atomic.Uint32 `json:"Archive.ToLocal.Files"` // How many small blobs are copied from archive to local store.
 	ArchiveToLocalBytes atomic.Uint64 `json:"Archive.ToLocal.Bytes"`
+	NotFoundRetry       atomic.Uint32 `json:"NotFound.Retry"`    // How many times a NotFound download is retried.
+	NotFoundRetryHit    atomic.Uint32 `json:"NotFound.RetryHit"` // How many NotFound downloads turn out to be a hit after retry.
 }
 
 func (m *BlobMetrics) Clear() {
 	m.GetByLocal.Store(0)
 	m.GetByArchive.Store(0)
 	m.GetByDownload.Store(0)
+	m.GetByLocalBytes.Store(0)
+	m.GetByArchiveBytes.Store(0)
+	m.GetByDownloadBytes.Store(0)
 	m.DownloadBytes.Store(0)
 	m.UploadedFiles.Store(0)
 	m.UploadedBytes.Store(0)
+	m.UploadSkipExists.Store(0)
 	m.ArchiveToLocalFiles.Store(0)
 	m.ArchiveToLocalBytes.Store(0)
+	m.NotFoundRetry.Store(0)
+	m.NotFoundRetryHit.Store(0)
 }
 
 type BlobCompactorMetrics struct {
 	Total                atomic.Uint32 `json:"Total"` // Note: Each namespace compact will be counted as 1.
 	Success              atomic.Uint32 `json:"Success"`
 	Skip                 atomic.Uint32 `json:"Skip"`
+	SkipNoBlobs          atomic.Uint32 `json:"Skip.NoBlobs"`        // Skipped because there is no small blob in the keyspace.
+	SkipNothingNew       atomic.Uint32 `json:"Skip.NothingNew"`     // Skipped because all small blobs are already in the archive.
+	SkipBelowThreshold   atomic.Uint32 `json:"Skip.BelowThreshold"` // Skipped because new small blobs are too few.
 	Fail                 atomic.Uint32 `json:"Fail"`
 	BlobAddTotal         atomic.Uint32 `json:"SmallBlob.Add.Total"` // How many small blobs files are newly added to the archive.
 	BlobAddTotalBytes    atomic.Uint64 `json:"SmallBlob.Add.TotalBytes"`
@@ -47,6 +62,9 @@ func (m *BlobCompactorMetrics) Clear() {
 	m.Total.Store(0)
 	m.Success.Store(0)
 	m.Skip.Store(0)
+	m.SkipNoBlobs.Store(0)
+	m.SkipNothingNew.Store(0)
+	m.SkipBelowThreshold.Store(0)
 	m.Fail.Store(0)
 	m.BlobAddTotal.Store(0)
 	m.BlobAddTotalBytes.Store(0)
@@ -64,6 +82,10 @@ type BlobArchiveStoreMetrics struct {
 	DownloadSuccessBytes atomic.Uint64 `json:"Download.Success.Bytes"`
 	LoadTotal            atomic.Uint32 `json:"Load.Total"` // How many archives are loaded from local store.
 	LoadFail             atomic.Uint32 `json:"Load.Fail"`
+	PrematerializeFiles  atomic.Uint32 `json:"Prematerialize.Files"` // How many archive entries are copied to local store in advance.
+	PrematerializeBytes  atomic.Uint64 `json:"Prematerialize.Bytes"`
+	InvalidEntryNames    atomic.Uint32 `json:"InvalidEntryNames"`    // How many loaded archive entries have a name not matching its ActionID.
+	UploadSkipUnchanged  atomic.Uint32 `json:"Upload.SkipUnchanged"` // How many archive uploads are skipped because the remote archive has the same content.
 }
 
 func (m *BlobArchiveStoreMetrics) Clear() {
@@ -73,6 +95,36 @@ func (m *BlobArchiveStoreMetrics) Clear() {
 	m.DownloadSuccessBytes.Store(0)
 	m.LoadTotal.Store(0)
 	m.LoadFail.Store(0)
+	m.PrematerializeFiles.Store(0)
+	m.PrematerializeBytes.Store(0)
+	m.InvalidEntryNames.Store(0)
+	m.UploadSkipUnchanged.Store(0)
+}
+
+type BlobEgressMetrics struct {
+	UsedBytes  atomic.Uint64 `json:"Budget.UsedBytes" metric:"gauge"` // Bytes downloaded from remote in the current budget period.
+	LimitBytes atomic.Uint64 `json:"Budget.LimitBytes" metric:"gauge"`
+	Suppressed atomic.Uint32 `json:"Suppressed"` // How many downloads are suppressed because the budget is exhausted.
+}
+
+func (m *BlobEgressMetrics) Clear() {
+	m.UsedBytes.Store(0)
+	m.LimitBytes.Store(0)
+	m.Suppressed.Store(0)
+}
+
+type LocalMetrics struct {
+	OrphanRemovedFiles atomic.Uint32 `json:"Orphan.Removed.Files"` // How many output files no longer referenced by any action are removed.
+	OrphanRemovedBytes atomic.Uint64 `json:"Orphan.Removed.Bytes"`
+	GCRemovedEntries   atomic.Uint32 `json:"GC.Removed.Entries"` // How many entries are removed by GC for age or size.
+	GCRemovedBytes     atomic.Uint64 `json:"GC.Removed.Bytes"`
+}
+
+func (m *LocalMetrics) Clear() {
+	m.OrphanRemovedFiles.Store(0)
+	m.OrphanRemovedBytes.Store(0)
+	m.GCRemovedEntries.Store(0)
+	m.GCRemovedBytes.Store(0)
 }
 
 type Metrics struct {
@@ -80,12 +132,20 @@ type Metrics struct {
 	GetHit           atomic.Uint32           `json:"Get.Hit"`
 	GetMiss          atomic.Uint32           `json:"Get.Miss"`
 	GetError         atomic.Uint32           `json:"Get.Error"`
+	GetRejected      atomic.Uint32           `json:"Get.Rejected"` // Served as a miss as limits.get is exceeded
 	PutTotal         atomic.Uint32           `json:"Put.Total"`
 	PutError         atomic.Uint32           `json:"Put.Error"`
+	PutRejected      atomic.Uint32           `json:"Put.Rejected"` // Failed as limits.put is exceeded
+	PutBytes         atomic.Uint64           `json:"Put.Bytes"`    // Body bytes of Put requests, i.e. outputs compiled by builds
+	PutSize          SizeHistogram           `json:"Put.Size"`     // Body size distribution of Put requests
 	BlobOrganic      BlobMetrics             `json:"Blob.FromOrganic"`
 	BlobCompaction   BlobMetrics             `json:"Blob.FromCompaction"`
+	BlobWarm         BlobMetrics             `json:"Blob.FromWarm"` // Downloads of warm and prefetch, not of builds
 	BlobCompactor    BlobCompactorMetrics    `json:"Blob.Compactor"`
 	BlobArchiveStore BlobArchiveStoreMetrics `json:"Blob.ArchiveStore"`
+	BlobEgress       BlobEgressMetrics       `json:"Blob.Egress"`
+	Local            LocalMetrics            `json:"Local"`
+	Misses           MissCounter             `json:"Get.MissByActionID"` // Not exported as counters
 
 	// =================================================================================
 	// Fields below are only for flushing stats to disk.
@@ -103,12 +163,20 @@ func (m *Metrics) Clear() {
 	m.GetHit.Store(0)
 	m.GetMiss.Store(0)
 	m.GetError.Store(0)
+	m.GetRejected.Store(0)
 	m.PutTotal.Store(0)
 	m.PutError.Store(0)
+	m.PutRejected.Store(0)
+	m.PutBytes.Store(0)
+	m.PutSize.Clear()
 	m.BlobOrganic.Clear()
 	m.BlobCompaction.Clear()
+	m.BlobWarm.Clear()
 	m.BlobCompactor.Clear()
 	m.BlobArchiveStore.Clear()
+	m.BlobEgress.Clear()
+	m.Local.Clear()
+	m.Misses.Clear()
 }
 
 var Default = NewMetrics()
@@ -0,0 +1,148 @@
+package stats
+
+import (
+	"container/heap"
+	"encoding/hex"
+	"encoding/json"
+	"slices"
+	"strings"
+	"sync"
+)
+
+// MaxTrackedMisses is the max number of actionIDs tracked by MissCounter.
+const MaxTrackedMisses = 1000
+
+// MissCount is how often an actionID is missed, and the bytes put for it afterwards, i.e. the
+// output compiled because of the miss.
+type MissCount struct {
+	ActionID string // Hex
+	Misses   uint32
+	PutBytes uint64 `json:",omitempty"`
+}
+
+// MissCounter counts misses by actionID, so that reports can show entries missed most often.
+// At most MaxTrackedMisses actionIDs are tracked by the space-saving algorithm: when a new one
+// comes, the least missed one is evicted and the new one takes over its count plus one. Counts
+// may be overestimated this way, but an actionID missed often is never evicted by a stream of
+// actionIDs missed once, e.g. of a new build.
+type MissCounter struct {
+	mu     sync.Mutex
+	counts map[string]*missEntry
+	heap   missHeap // Entries ordered by misses, the least missed first
+}
+
+type missEntry struct {
+	MissCount
+	index int // Index in missHeap
+}
+
+type missHeap []*missEntry
+
+func (h missHeap) Len() int           { return len(h) }
+func (h missHeap) Less(i, j int) bool { return h[i].Misses < h[j].Misses }
+func (h missHeap) Swap(i, j int) {
+	h[i], h[j] = h[j], h[i]
+	h[i].index = i
+	h[j].index = j
+}
+
+func (h *missHeap) Push(x any) {
+	e := x.(*missEntry)
+	e.index = len(*h)
+	*h = append(*h, e)
+}
+
+func (h *missHeap) Pop() any {
+	old := *h
+	e := old[len(old)-1]
+	old[len(old)-1] = nil
+	*h = old[:len(old)-1]
+	return e
+}
+
+func (c *MissCounter) Record(actionID []byte) {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	if c.counts == nil {
+		c.counts = make(map[string]*missEntry)
+	}
+	id := hex.EncodeToString(actionID)
+	if e, ok := c.counts[id]; ok {
+		e.Misses++
+		heap.Fix(&c.heap, e.index)
+		return
+	}
+	if len(c.counts) >= MaxTrackedMisses {
+		// Reuse the least missed entry for the new actionID
+		e := c.heap[0]
+		delete(c.counts, e.ActionID)
+		e.MissCount = MissCount{ActionID: id, Misses: e.Misses + 1}
+		c.counts[id] = e
+		heap.Fix(&c.heap, 0)
+		return
+	}
+	e := &missEntry{MissCount: MissCount{ActionID: id, Misses: 1}}
+	c.counts[id] = e
+	heap.Push(&c.heap, e)
+}
+
+// RecordPut adds the size of a put to the actionID if it is tracked.
+func (c *MissCounter) RecordPut(actionID []byte, size int64) {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	if e, ok := c.counts[hex.EncodeToString(actionID)]; ok && size > 0 {
+		e.PutBytes += uint64(size)
+	}
+}
+
+// Top returns up to n actionIDs missed most often, then with most bytes put.
+func (c *MissCounter) Top(n int) []MissCount {
+	c.mu.Lock()
+	result := make([]MissCount, 0, len(c.counts))
+	for _, e := range c.counts {
+		result = append(result, e.MissCount)
+	}
+	c.mu.Unlock()
+	slices.SortFunc(result, func(a, b MissCount) int {
+		if a.Misses != b.Misses {
+			return int(b.Misses) - int(a.Misses)
+		}
+		if a.PutBytes != b.PutBytes {
+			if a.PutBytes > b.PutBytes {
+				return -1
+			}
+			return 1
+		}
+		return strings.Compare(a.ActionID, b.ActionID)
+	})
+	return result[:min(n, len(result))]
+}
+
+func (c *MissCounter) Clear() {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	c.counts = nil
+	c.heap = nil
+}
+
+func (c *MissCounter) MarshalJSON() ([]byte, error) {
+	return json.Marshal(c.Top(MaxTrackedMisses))
+}
+
+func (c *MissCounter) UnmarshalJSON(data []byte) error {
+	var list []MissCount
+	if err := json.Unmarshal(data, &list); err != nil {
+		return err
+	}
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	c.counts = make(map[string]*missEntry, len(list))
+	c.heap = make(missHeap, 0, len(list))
+	for _, mc := range list[:min(len(list), MaxTrackedMisses)] {
+		e := &missEntry{MissCount: mc, index: len(c.heap)}
+		c.counts[mc.ActionID] = e
+		c.heap = append(c.heap, e)
+	}
+	heap.Init(&c.heap)
+	return nil
+}
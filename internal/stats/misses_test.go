@@ -0,0 +1,79 @@
+package stats
+
+import (
+	"encoding/json"
+	"fmt"
+	"testing"
+
+	"github.com/stretchr/testify/require"
+)
+
+func TestMissCounter(t *testing.T) {
+	m := NewMetrics()
+	m.Misses.Record([]byte{0x01})
+	m.Misses.Record([]byte{0x02})
+	m.Misses.Record([]byte{0x02})
+	m.Misses.Record([]byte{0x03})
+	m.Misses.RecordPut([]byte{0x03}, 100)
+	m.Misses.RecordPut([]byte{0x04}, 100) // Not tracked
+	require.Equal(t, []MissCount{
+		{ActionID: "02", Misses: 2},
+		{ActionID: "03", Misses: 1, PutBytes: 100},
+	}, m.Misses.Top(2))
+
+	// Persisted with other stats, but not exported as counters
+	data, err := json.Marshal(m)
+	require.NoError(t, err)
+	loaded := NewMetrics()
+	require.NoError(t, json.Unmarshal(data, loaded))
+	require.Equal(t, m.Misses.Top(10), loaded.Misses.Top(10))
+	for _, c := range Counters(m) {
+		require.NotContains(t, c.Name, "MissByActionID")
+	}
+
+	m.Clear()
+	require.Empty(t, m.Misses.Top(10))
+}
+
+func TestMissCounter_Evict(t *testing.T) {
+	var c MissCounter
+	for i := range MaxTrackedMisses {
+		c.Record([]byte{byte(i >> 8), byte(i)})
+		c.Record([]byte{byte(i >> 8), byte(i)})
+	}
+	c.Record([]byte{0x00, 0x00})
+	c.RecordPut([]byte{0x00, 0x01}, 100)
+	// Evicts one of the least missed, and takes over its count
+	c.Record([]byte{0xff, 0xff})
+	top := c.Top(MaxTrackedMisses + 1)
+	require.Len(t, top, MaxTrackedMisses)
+	require.Equal(t, []MissCount{{ActionID: "0000", Misses: 3}, {ActionID: "ffff", Misses: 3}}, top[:2])
+	require.Equal(t, uint32(2), top[len(top)-1].Misses)
+
+	// An actionID missed repeatedly is tracked, even if it comes among actionIDs missed once
+	for i := range 10 * MaxTrackedMisses {
+		c.Record([]byte{0xee, byte(i >> 8), byte(i)})
+		c.Record([]byte{0xee, byte(i >> 8), byte(i), 0x01})
+		c.Record([]byte{0xdd})
+	}
+	top = c.Top(1)
+	require.Equal(t, "dd", top[0].ActionID)
+	require.GreaterOrEqual(t, top[0].Misses, uint32(10*MaxTrackedMisses))
+}
+
+func TestMissCounter_EvictAfterLoad(t *testing.T) {
+	list := make([]MissCount, 0, MaxTrackedMisses)
+	for i := range MaxTrackedMisses {
+		list = append(list, MissCount{ActionID: fmt.Sprintf("%04x", i), Misses: uint32(i + 1)})
+	}
+	data, err := json.Marshal(list)
+	require.NoError(t, err)
+	var c MissCounter
+	require.NoError(t, json.Unmarshal(data, &c))
+
+	c.Record([]byte{0xff, 0xff})
+	top := c.Top(MaxTrackedMisses)
+	require.Len(t, top, MaxTrackedMisses)
+	require.Equal(t, MissCount{ActionID: "ffff", Misses: 2}, top[len(top)-1])
+	require.NotContains(t, top, MissCount{ActionID: "0000", Misses: 1})
+}
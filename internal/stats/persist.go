@@ -57,13 +57,23 @@ func (m *Metrics) saveToFile(path string) error {
 	if err != nil {
 		return err
 	}
+	return writeFileAtomic(path, data)
+}
+
+// writeFileAtomic writes to a temporary file and renames it, so that readers never
+// observe a partially written file.
+func writeFileAtomic(path string, data []byte) error {
 	uniqueId := gonanoid.Must(8)
 	tmpPath := path + ".tmp." + uniqueId
-	err = os.WriteFile(tmpPath, data, 0644)
+	err := os.WriteFile(tmpPath, data, 0644)
 	if err != nil {
 		return err
 	}
-	return os.Rename(tmpPath, path)
+	if err := os.Rename(tmpPath, path); err != nil {
+		_ = os.Remove(tmpPath)
+		return err
+	}
+	return nil
 }
 
 func (m *Metrics) ForcePersist() {
@@ -0,0 +1,46 @@
+package stats
+
+import "time"
+
+// Rates are per second changes of counters between two snapshots of Metrics,
+// e.g. for a live dashboard polling the daemon.
+type Rates struct {
+	Gets          float64
+	Hits          float64
+	Puts          float64
+	DownloadBytes float64
+	UploadBytes   float64
+	// Hit ratio of gets within the interval, in [0, 1]. 0 if there is no Get.
+	HitRatio float64
+}
+
+// ComputeRates returns rates between prev and cur taken elapsed apart. Counters which decrease,
+// e.g. because stats are cleared in between, are treated as unchanged.
+func ComputeRates(prev, cur *Metrics, elapsed time.Duration) Rates {
+	if elapsed <= 0 {
+		return Rates{}
+	}
+	seconds := elapsed.Seconds()
+	delta := func(prev, cur uint64) float64 {
+		if cur < prev {
+			return 0
+		}
+		return float64(cur - prev)
+	}
+	gets := delta(uint64(prev.GetTotal.Load()), uint64(cur.GetTotal.Load()))
+	hits := delta(uint64(prev.GetHit.Load()), uint64(cur.GetHit.Load()))
+	r := Rates{
+		Gets: gets / seconds,
+		Hits: hits / seconds,
+		Puts: delta(uint64(prev.PutTotal.Load()), uint64(cur.PutTotal.Load())) / seconds,
+		DownloadBytes: (delta(prev.BlobOrganic.DownloadBytes.Load(), cur.BlobOrganic.DownloadBytes.Load()) +
+			delta(prev.BlobCompaction.DownloadBytes.Load(), cur.BlobCompaction.DownloadBytes.Load()) +
+			delta(prev.BlobWarm.DownloadBytes.Load(), cur.BlobWarm.DownloadBytes.Load())) / seconds,
+		UploadBytes: (delta(prev.BlobOrganic.UploadedBytes.Load(), cur.BlobOrganic.UploadedBytes.Load()) +
+			delta(prev.BlobCompaction.UploadedBytes.Load(), cur.BlobCompaction.UploadedBytes.Load())) / seconds,
+	}
+	if gets > 0 {
+		r.HitRatio = min(hits/gets, 1)
+	}
+	return r
+}
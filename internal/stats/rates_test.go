@@ -0,0 +1,40 @@
+package stats
+
+import (
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/require"
+)
+
+func TestComputeRates(t *testing.T) {
+	prev := NewMetrics()
+	prev.GetTotal.Store(10)
+	prev.GetHit.Store(5)
+	prev.PutTotal.Store(4)
+	prev.BlobOrganic.DownloadBytes.Store(100)
+
+	cur := NewMetrics()
+	cur.GetTotal.Store(30)
+	cur.GetHit.Store(20)
+	cur.PutTotal.Store(8)
+	cur.BlobOrganic.DownloadBytes.Store(300)
+	cur.BlobOrganic.UploadedBytes.Store(50)
+	cur.BlobCompaction.UploadedBytes.Store(150)
+
+	r := ComputeRates(prev, cur, 2*time.Second)
+	require.Equal(t, Rates{
+		Gets:          10,
+		Hits:          7.5,
+		Puts:          2,
+		DownloadBytes: 100,
+		UploadBytes:   100,
+		HitRatio:      0.75,
+	}, r)
+
+	// Cleared stats do not produce negative rates
+	r = ComputeRates(cur, prev, time.Second)
+	require.Equal(t, Rates{}, r)
+
+	require.Equal(t, Rates{}, ComputeRates(prev, cur, 0))
+}
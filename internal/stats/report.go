@@ -0,0 +1,71 @@
+package stats
+
+import "time"
+
+// Effectiveness summarizes how much the cache helped, derived from the counters.
+// Only organic traffic (i.e. requested by builds) is counted, compaction is excluded.
+type Effectiveness struct {
+	Gets               uint32
+	Hits               uint32
+	HitRatio           float64 // In [0, 1]. 0 if there is no Get.
+	DownloadedBytes    uint64  // Bytes of hits downloaded from remote.
+	ServedLocallyBytes uint64  // Bytes of hits served from the local store or archives, without network.
+	UploadedBytes      uint64
+	PutBytes           uint64 // Bytes put by builds, i.e. outputs compiled because of misses.
+	// Rough estimate of the build time saved, assuming each hit saves rebuildCostPerHit.
+	EstimatedTimeSaved time.Duration
+}
+
+func (m *Metrics) Effectiveness(rebuildCostPerHit time.Duration) Effectiveness {
+	e := Effectiveness{
+		Gets:               m.GetTotal.Load(),
+		Hits:               m.GetHit.Load(),
+		DownloadedBytes:    m.BlobOrganic.GetByDownloadBytes.Load(),
+		ServedLocallyBytes: m.BlobOrganic.GetByLocalBytes.Load() + m.BlobOrganic.GetByArchiveBytes.Load(),
+		UploadedBytes:      m.BlobOrganic.UploadedBytes.Load(),
+		PutBytes:           m.PutBytes.Load(),
+	}
+	e.derive(rebuildCostPerHit)
+	return e
+}
+
+func (e *Effectiveness) derive(rebuildCostPerHit time.Duration) {
+	e.HitRatio = 0
+	if e.Gets > 0 {
+		e.HitRatio = float64(e.Hits) / float64(e.Gets)
+	}
+	e.EstimatedTimeSaved = time.Duration(e.Hits) * rebuildCostPerHit
+}
+
+// Since returns the effectiveness between an earlier snapshot and e, e.g. for trends. If gets
+// went down, stats were cleared in between, and e is returned as is.
+func (e Effectiveness) Since(prev Effectiveness, rebuildCostPerHit time.Duration) Effectiveness {
+	if e.Gets < prev.Gets {
+		return e
+	}
+	sub := func(cur, prev uint64) uint64 {
+		if cur < prev {
+			return 0
+		}
+		return cur - prev
+	}
+	d := Effectiveness{
+		Gets:               e.Gets - prev.Gets,
+		Hits:               uint32(sub(uint64(e.Hits), uint64(prev.Hits))),
+		DownloadedBytes:    sub(e.DownloadedBytes, prev.DownloadedBytes),
+		ServedLocallyBytes: sub(e.ServedLocallyBytes, prev.ServedLocallyBytes),
+		UploadedBytes:      sub(e.UploadedBytes, prev.UploadedBytes),
+		PutBytes:           sub(e.PutBytes, prev.PutBytes),
+	}
+	d.derive(rebuildCostPerHit)
+	return d
+}
+
+// ServedLocallyRatio returns the fraction of served bytes which did not go through the network.
+func (e Effectiveness) ServedLocallyRatio() float64 {
+	total := e.DownloadedBytes + e.ServedLocallyBytes
+	if total == 0 {
+		return 0
+	}
+	return float64(e.ServedLocallyBytes) / float64(total)
+}
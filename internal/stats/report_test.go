@@ -0,0 +1,56 @@
+package stats
+
+import (
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/require"
+)
+
+func TestMetrics_Effectiveness(t *testing.T) {
+	m := NewMetrics()
+	e := m.Effectiveness(time.Second)
+	require.Zero(t, e.HitRatio)
+	require.Zero(t, e.ServedLocallyRatio())
+
+	m.GetTotal.Store(10)
+	m.GetHit.Store(8)
+	m.BlobOrganic.GetByDownloadBytes.Store(100)
+	m.BlobOrganic.GetByLocalBytes.Store(200)
+	m.BlobOrganic.GetByArchiveBytes.Store(100)
+	// Compaction traffic is not counted
+	m.BlobCompaction.GetByDownloadBytes.Store(1000)
+	e = m.Effectiveness(2 * time.Second)
+	require.Equal(t, uint32(10), e.Gets)
+	require.InDelta(t, 0.8, e.HitRatio, 1e-9)
+	require.Equal(t, uint64(100), e.DownloadedBytes)
+	require.Equal(t, uint64(300), e.ServedLocallyBytes)
+	require.InDelta(t, 0.75, e.ServedLocallyRatio(), 1e-9)
+	require.Equal(t, 16*time.Second, e.EstimatedTimeSaved)
+}
+
+func TestEffectiveness_Since(t *testing.T) {
+	m := NewMetrics()
+	m.GetTotal.Store(10)
+	m.GetHit.Store(8)
+	m.PutBytes.Store(100)
+	prev := m.Effectiveness(time.Second)
+
+	m.GetTotal.Store(20)
+	m.GetHit.Store(13)
+	m.PutBytes.Store(300)
+	d := m.Effectiveness(time.Second).Since(prev, time.Second)
+	require.Equal(t, uint32(10), d.Gets)
+	require.Equal(t, uint32(5), d.Hits)
+	require.InDelta(t, 0.5, d.HitRatio, 1e-9)
+	require.Equal(t, uint64(200), d.PutBytes)
+	require.Equal(t, 5*time.Second, d.EstimatedTimeSaved)
+
+	// Stats are cleared in between
+	m.Clear()
+	m.GetTotal.Store(4)
+	m.GetHit.Store(1)
+	d = m.Effectiveness(time.Second).Since(prev, time.Second)
+	require.Equal(t, uint32(4), d.Gets)
+	require.InDelta(t, 0.25, d.HitRatio, 1e-9)
+}
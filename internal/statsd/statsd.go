@@ -0,0 +1,173 @@
+package statsd
+
+import (
+	"bytes"
+	"fmt"
+	"net"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/breezewish/gscache/internal/log"
+	"github.com/breezewish/gscache/internal/stats"
+	"go.uber.org/zap"
+)
+
+// maxPacketSize keeps each UDP packet within a typical MTU, so that packets are not fragmented.
+const maxPacketSize = 1432
+
+type Config struct {
+	// StatsD (or DogStatsD) UDP address to send metrics to, e.g. localhost:8125.
+	// If not set, metrics are not emitted.
+	Addr     string        `json:"addr"`
+	Prefix   string        `json:"prefix"`
+	Interval time.Duration `json:"interval"`
+}
+
+func DefaultConfig() Config {
+	return Config{
+		Addr:     "",
+		Prefix:   "gscache.",
+		Interval: 10 * time.Second,
+	}
+}
+
+// Emitter periodically sends all counters in the Metrics as StatsD counters.
+// As metrics are cumulative, only the delta since the last flush is sent.
+type Emitter struct {
+	config  Config
+	metrics *stats.Metrics
+	conn    net.Conn
+	log     *zap.Logger
+
+	mu   sync.Mutex
+	last map[string]uint64
+
+	stopCh chan struct{}
+	doneCh chan struct{}
+}
+
+// Start starts emitting metrics in background. If Addr is not configured,
+// a nil Emitter is returned, which is safe to Stop.
+func Start(cfg Config, m *stats.Metrics) (*Emitter, error) {
+	if cfg.Addr == "" {
+		return nil, nil
+	}
+	if cfg.Interval <= 0 {
+		return nil, fmt.Errorf("statsd interval must be positive")
+	}
+	conn, err := net.Dial("udp", cfg.Addr)
+	if err != nil {
+		return nil, fmt.Errorf("failed to connect to statsd %s: %w", cfg.Addr, err)
+	}
+	e := &Emitter{
+		config:  cfg,
+		metrics: m,
+		conn:    conn,
+		log:     log.Named("statsd"),
+		last:    make(map[string]uint64),
+		stopCh:  make(chan struct{}),
+		doneCh:  make(chan struct{}),
+	}
+	// Metrics loaded from the stats file are not sent, only changes after start are sent.
+	e.collectDeltas()
+	go e.run()
+	e.log.Info("StatsD emitter started", zap.Any("config", cfg))
+	return e, nil
+}
+
+func (e *Emitter) run() {
+	defer close(e.doneCh)
+	ticker := time.NewTicker(e.config.Interval)
+	defer ticker.Stop()
+	for {
+		select {
+		case <-ticker.C:
+			e.Flush()
+		case <-e.stopCh:
+			return
+		}
+	}
+}
+
+// Stop flushes pending metrics and stops the emitter.
+func (e *Emitter) Stop() {
+	if e == nil {
+		return
+	}
+	close(e.stopCh)
+	<-e.doneCh
+	e.Flush()
+	_ = e.conn.Close()
+}
+
+// Flush sends changed counters since the last flush.
+func (e *Emitter) Flush() {
+	var packet bytes.Buffer
+	for _, line := range e.collectDeltas() {
+		if packet.Len() > 0 && packet.Len()+1+len(line) > maxPacketSize {
+			e.send(packet.Bytes())
+			packet.Reset()
+		}
+		if packet.Len() > 0 {
+			packet.WriteByte('\n')
+		}
+		packet.WriteString(line)
+	}
+	if packet.Len() > 0 {
+		e.send(packet.Bytes())
+	}
+}
+
+func (e *Emitter) send(p []byte) {
+	if _, err := e.conn.Write(p); err != nil {
+		e.log.Debug("Failed to send metrics to statsd", zap.Error(err))
+	}
+}
+
+// collectDeltas returns StatsD lines for counters changed since the last call.
+func (e *Emitter) collectDeltas() []string {
+	e.mu.Lock()
+	defer e.mu.Unlock()
+	lines := make([]string, 0)
+	for _, c := range Counters(e.metrics) {
+		name := e.config.Prefix + c.Name
+		last, seen := e.last[name]
+		e.last[name] = c.Value
+		delta := c.Value - last
+		if c.Value < last {
+			// Metrics are cleared, all current values are new.
+			delta = c.Value
+		}
+		if !seen || delta == 0 {
+			continue
+		}
+		lines = append(lines, fmt.Sprintf("%s:%d|c", name, delta))
+	}
+	return lines
+}
+
+type Counter = stats.Counter
+
+// Counters returns all counters in the Metrics, see stats.Counters. Names are sanitized
+// for StatsD.
+func Counters(m *stats.Metrics) []Counter {
+	counters := stats.Counters(m)
+	for i := range counters {
+		counters[i].Name = sanitizeName(counters[i].Name)
+	}
+	return counters
+}
+
+// sanitizeName replaces characters which have special meanings in StatsD
+// or are not commonly accepted by StatsD backends.
+func sanitizeName(name string) string {
+	return strings.Map(func(r rune) rune {
+		switch {
+		case r >= 'a' && r <= 'z', r >= 'A' && r <= 'Z', r >= '0' && r <= '9', r == '.', r == '_', r == '-':
+			return r
+		default:
+			return '_'
+		}
+	}, name)
+}
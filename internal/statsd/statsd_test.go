@@ -0,0 +1,74 @@
+package statsd
+
+import (
+	"net"
+	"sort"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/breezewish/gscache/internal/stats"
+	"github.com/stretchr/testify/require"
+)
+
+func TestCounters(t *testing.T) {
+	m := stats.NewMetrics()
+	m.GetHit.Add(3)
+	m.BlobOrganic.DownloadBytes.Add(100)
+	m.PutSize.Observe(10)
+
+	values := make(map[string]uint64)
+	for _, c := range Counters(m) {
+		values[c.Name] = c.Value
+	}
+	require.Equal(t, uint64(3), values["Get.Hit"])
+	require.Equal(t, uint64(0), values["Get.Miss"])
+	require.Equal(t, uint64(100), values["Blob.FromOrganic.Download.Bytes"])
+	require.Equal(t, uint64(1), values["Put.Size.0__1KB"])
+	require.Equal(t, uint64(0), values["Blob.Compactor.Total"])
+}
+
+func TestEmitter_SendsDeltas(t *testing.T) {
+	pc, err := net.ListenPacket("udp", "127.0.0.1:0")
+	require.NoError(t, err)
+	defer pc.Close()
+
+	m := stats.NewMetrics()
+	m.GetHit.Add(5) // Existing values are not sent
+	e, err := Start(Config{
+		Addr:     pc.LocalAddr().String(),
+		Prefix:   "test.",
+		Interval: time.Hour,
+	}, m)
+	require.NoError(t, err)
+	defer e.Stop()
+
+	m.GetHit.Add(2)
+	m.PutTotal.Add(1)
+	e.Flush()
+
+	lines := readLines(t, pc)
+	sort.Strings(lines)
+	require.Equal(t, []string{"test.Get.Hit:2|c", "test.Put.Total:1|c"}, lines)
+
+	// After clear, new values are sent as is.
+	m.Clear()
+	m.GetHit.Add(1)
+	e.Flush()
+	require.Equal(t, []string{"test.Get.Hit:1|c"}, readLines(t, pc))
+}
+
+func TestStart_Disabled(t *testing.T) {
+	e, err := Start(DefaultConfig(), stats.NewMetrics())
+	require.NoError(t, err)
+	require.Nil(t, e)
+	e.Stop()
+}
+
+func readLines(t *testing.T, pc net.PacketConn) []string {
+	buf := make([]byte, maxPacketSize)
+	require.NoError(t, pc.SetReadDeadline(time.Now().Add(5*time.Second)))
+	n, _, err := pc.ReadFrom(buf)
+	require.NoError(t, err)
+	return strings.Split(string(buf[:n]), "\n")
+}
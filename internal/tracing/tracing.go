@@ -0,0 +1,77 @@
+package tracing
+
+import (
+	"context"
+	"fmt"
+
+	"go.opentelemetry.io/otel"
+	"go.opentelemetry.io/otel/attribute"
+	"go.opentelemetry.io/otel/codes"
+	"go.opentelemetry.io/otel/exporters/otlp/otlptrace/otlptracehttp"
+	"go.opentelemetry.io/otel/sdk/resource"
+	sdktrace "go.opentelemetry.io/otel/sdk/trace"
+	semconv "go.opentelemetry.io/otel/semconv/v1.26.0"
+	"go.opentelemetry.io/otel/trace"
+)
+
+type Config struct {
+	// OTLP/HTTP endpoint (host:port) to export spans to, e.g. localhost:4318.
+	// If not set, tracing is disabled and spans are no-op.
+	Endpoint string `json:"endpoint"`
+}
+
+func DefaultConfig() Config {
+	return Config{
+		Endpoint: "",
+	}
+}
+
+var tracer = otel.Tracer("github.com/breezewish/gscache")
+
+// Setup installs a global tracer provider which exports spans to the configured endpoint.
+// The returned function must be called to flush pending spans before exit.
+func Setup(cfg Config) (func(context.Context) error, error) {
+	if cfg.Endpoint == "" {
+		return func(context.Context) error { return nil }, nil
+	}
+	exporter, err := otlptracehttp.New(context.Background(),
+		otlptracehttp.WithEndpoint(cfg.Endpoint),
+		otlptracehttp.WithInsecure())
+	if err != nil {
+		return nil, fmt.Errorf("failed to create OTLP trace exporter: %w", err)
+	}
+	provider := sdktrace.NewTracerProvider(
+		sdktrace.WithBatcher(exporter),
+		sdktrace.WithResource(resource.NewSchemaless(
+			semconv.ServiceName("gscache"),
+		)),
+	)
+	otel.SetTracerProvider(provider)
+	return provider.Shutdown, nil
+}
+
+// Start starts a span. When tracing is disabled it is almost zero-cost.
+// A nil ctx is treated as context.Background().
+func Start(ctx context.Context, name string, attrs ...attribute.KeyValue) (context.Context, trace.Span) {
+	if ctx == nil {
+		ctx = context.Background()
+	}
+	return tracer.Start(ctx, name, trace.WithAttributes(attrs...))
+}
+
+// SetAttributes sets attributes to the current span in the ctx, if any.
+func SetAttributes(ctx context.Context, attrs ...attribute.KeyValue) {
+	if ctx == nil {
+		return
+	}
+	trace.SpanFromContext(ctx).SetAttributes(attrs...)
+}
+
+// EndWithError records the error (if any) and ends the span.
+func EndWithError(span trace.Span, err error) {
+	if err != nil {
+		span.RecordError(err)
+		span.SetStatus(codes.Error, err.Error())
+	}
+	span.End()
+}
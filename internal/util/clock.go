@@ -0,0 +1,164 @@
+package util
+
+import (
+	"slices"
+	"sync"
+	"time"
+)
+
+// Clock abstracts the current time, so that time-based behaviors (e.g. sync interval,
+// inactivity shutdown) can be tested deterministically with a FakeClock.
+// Note that it is not used for measuring costs, which always use the real time.
+type Clock interface {
+	Now() time.Time
+	Since(t time.Time) time.Duration
+	// NewTimer is like time.NewTimer, but the timer fires by the time of the clock.
+	NewTimer(d time.Duration) Timer
+	// After is like time.After, but fires by the time of the clock.
+	After(d time.Duration) <-chan time.Time
+}
+
+// Timer is a timer created by Clock.NewTimer. See time.Timer.
+type Timer interface {
+	C() <-chan time.Time
+	Stop() bool
+	Reset(d time.Duration) bool
+}
+
+type realClock struct{}
+
+func (realClock) Now() time.Time                         { return time.Now() }
+func (realClock) Since(t time.Time) time.Duration        { return time.Since(t) }
+func (realClock) NewTimer(d time.Duration) Timer         { return realTimer{time.NewTimer(d)} }
+func (realClock) After(d time.Duration) <-chan time.Time { return time.After(d) }
+
+type realTimer struct {
+	t *time.Timer
+}
+
+func (t realTimer) C() <-chan time.Time        { return t.t.C }
+func (t realTimer) Stop() bool                 { return t.t.Stop() }
+func (t realTimer) Reset(d time.Duration) bool { return t.t.Reset(d) }
+
+// RealClock is the Clock backed by the system time.
+var RealClock Clock = realClock{}
+
+// ClockOrReal returns c, or RealClock if c is nil.
+func ClockOrReal(c Clock) Clock {
+	if c == nil {
+		return RealClock
+	}
+	return c
+}
+
+// FakeClock is a Clock which only moves when being told to. It is concurrent-safe.
+// Timers fire when the clock is moved past their deadlines.
+type FakeClock struct {
+	mu     sync.Mutex
+	now    time.Time
+	timers []*fakeTimer // Active timers
+}
+
+var _ Clock = (*FakeClock)(nil)
+
+func NewFakeClock(now time.Time) *FakeClock {
+	return &FakeClock{now: now}
+}
+
+func (c *FakeClock) Now() time.Time {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	return c.now
+}
+
+func (c *FakeClock) Since(t time.Time) time.Duration {
+	return c.Now().Sub(t)
+}
+
+func (c *FakeClock) NewTimer(d time.Duration) Timer {
+	t := &fakeTimer{clock: c, c: make(chan time.Time, 1)}
+	t.Reset(d)
+	return t
+}
+
+func (c *FakeClock) After(d time.Duration) <-chan time.Time {
+	return c.NewTimer(d).C()
+}
+
+// Advance moves the clock forward by d.
+func (c *FakeClock) Advance(d time.Duration) {
+	c.Set(c.Now().Add(d))
+}
+
+// Set moves the clock to t.
+func (c *FakeClock) Set(t time.Time) {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	c.now = t
+	active := c.timers[:0]
+	for _, timer := range c.timers {
+		if timer.deadline.After(t) {
+			active = append(active, timer)
+			continue
+		}
+		// Like time.Timer, the value is dropped if the previous one is not received yet
+		select {
+		case timer.c <- t:
+		default:
+		}
+	}
+	clear(c.timers[len(active):])
+	c.timers = active
+}
+
+// Timers returns the number of active timers, so that tests can wait for a timer to be
+// created before advancing the clock.
+func (c *FakeClock) Timers() int {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	return len(c.timers)
+}
+
+type fakeTimer struct {
+	clock    *FakeClock
+	c        chan time.Time
+	deadline time.Time
+}
+
+func (t *fakeTimer) C() <-chan time.Time {
+	return t.c
+}
+
+// Stop stops the timer. Like time.Timer since Go 1.23, a value not received yet is dropped.
+func (t *fakeTimer) Stop() bool {
+	t.clock.mu.Lock()
+	defer t.clock.mu.Unlock()
+	return t.stopLocked()
+}
+
+func (t *fakeTimer) Reset(d time.Duration) bool {
+	t.clock.mu.Lock()
+	defer t.clock.mu.Unlock()
+	active := t.stopLocked()
+	t.deadline = t.clock.now.Add(d)
+	if d <= 0 {
+		t.c <- t.clock.now
+		return active
+	}
+	t.clock.timers = append(t.clock.timers, t)
+	return active
+}
+
+func (t *fakeTimer) stopLocked() bool {
+	select {
+	case <-t.c:
+	default:
+	}
+	for i, timer := range t.clock.timers {
+		if timer == t {
+			t.clock.timers = slices.Delete(t.clock.timers, i, i+1)
+			return true
+		}
+	}
+	return false
+}
@@ -0,0 +1,56 @@
+package util
+
+import (
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/require"
+)
+
+func TestFakeClock(t *testing.T) {
+	start := time.Date(2025, 1, 1, 0, 0, 0, 0, time.UTC)
+	c := NewFakeClock(start)
+	require.Equal(t, start, c.Now())
+	c.Advance(time.Minute)
+	require.Equal(t, start.Add(time.Minute), c.Now())
+	require.Equal(t, time.Minute, c.Since(start))
+	c.Set(start)
+	require.Equal(t, time.Duration(0), c.Since(start))
+}
+
+func TestClockOrReal(t *testing.T) {
+	require.Equal(t, RealClock, ClockOrReal(nil))
+	c := NewFakeClock(time.Now())
+	require.Equal(t, Clock(c), ClockOrReal(c))
+}
+
+func TestFakeClockTimer(t *testing.T) {
+	c := NewFakeClock(time.Date(2025, 1, 1, 0, 0, 0, 0, time.UTC))
+	timer := c.NewTimer(time.Minute)
+	after := c.After(2 * time.Minute)
+	require.Equal(t, 2, c.Timers())
+
+	c.Advance(59 * time.Second)
+	require.Empty(t, timer.C())
+	c.Advance(time.Second)
+	require.Equal(t, c.Now(), <-timer.C())
+	require.Empty(t, after)
+	require.Equal(t, 1, c.Timers())
+
+	// Reset starts from the current time
+	require.False(t, timer.Reset(time.Minute))
+	c.Advance(time.Minute)
+	require.Equal(t, c.Now(), <-after)
+	require.Equal(t, c.Now(), <-timer.C())
+
+	// A fired value not received is dropped by Stop and Reset
+	require.False(t, timer.Reset(time.Second))
+	c.Advance(time.Second)
+	require.False(t, timer.Stop())
+	require.Empty(t, timer.C())
+	require.False(t, timer.Reset(time.Second))
+	require.True(t, timer.Stop())
+	c.Advance(time.Hour)
+	require.Empty(t, timer.C())
+	require.Equal(t, 0, c.Timers())
+}
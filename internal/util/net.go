@@ -0,0 +1,29 @@
+package util
+
+import (
+	"net"
+	"strings"
+)
+
+// IsLoopbackHost returns whether the host only refers to the same machine, e.g. "localhost",
+// "127.0.0.1", "::1" or "[::1]".
+func IsLoopbackHost(host string) bool {
+	host = strings.TrimSuffix(strings.TrimPrefix(host, "["), "]")
+	if strings.EqualFold(host, "localhost") {
+		return true
+	}
+	// Zone of IPv6 link-local addresses, e.g. "::1%lo0"
+	host, _, _ = strings.Cut(host, "%")
+	ip := net.ParseIP(host)
+	return ip != nil && ip.IsLoopback()
+}
+
+// IsLoopbackAddr is like IsLoopbackHost, but for an address with a port, e.g. "[::1]:8080".
+// An address without a port is taken as a host.
+func IsLoopbackAddr(addr string) bool {
+	host, _, err := net.SplitHostPort(addr)
+	if err != nil {
+		host = addr
+	}
+	return IsLoopbackHost(host)
+}
@@ -0,0 +1,25 @@
+package util
+
+import (
+	"testing"
+
+	"github.com/stretchr/testify/require"
+)
+
+func TestIsLoopbackHost(t *testing.T) {
+	for _, host := range []string{"localhost", "LOCALHOST", "127.0.0.1", "127.1.2.3", "::1", "[::1]", "::1%lo0", "::ffff:127.0.0.1"} {
+		require.True(t, IsLoopbackHost(host), host)
+	}
+	for _, host := range []string{"", "0.0.0.0", "::", "[::]", "192.168.1.1", "fe80::1", "example.com", "localhost.example.com"} {
+		require.False(t, IsLoopbackHost(host), host)
+	}
+}
+
+func TestIsLoopbackAddr(t *testing.T) {
+	for _, addr := range []string{"localhost:80", "127.0.0.1:8080", "[::1]:8080", "[::1]", "::1", "127.0.0.1"} {
+		require.True(t, IsLoopbackAddr(addr), addr)
+	}
+	for _, addr := range []string{":8080", "0.0.0.0:8080", "[::]:8080", "10.0.0.1:8080", "example.com:80"} {
+		require.False(t, IsLoopbackAddr(addr), addr)
+	}
+}
@@ -3,6 +3,8 @@ package util
 import (
 	"encoding/json"
 	"fmt"
+	"strconv"
+	"strings"
 
 	"github.com/TylerBrock/colorjson"
 	"github.com/breezewish/gscache/internal/log"
@@ -35,3 +37,36 @@ func PrettyPrintJSON(data any) {
 	s, _ := formatter.Marshal(m)
 	fmt.Println(string(s))
 }
+
+// FormatBytes formats a byte count in a human readable way, like "1.5MB".
+func FormatBytes(n uint64) string {
+	const unit = 1024
+	if n < unit {
+		return fmt.Sprintf("%dB", n)
+	}
+	div, exp := uint64(unit), 0
+	for m := n / unit; m >= unit && exp < 4; m /= unit {
+		div *= unit
+		exp++
+	}
+	return fmt.Sprintf("%.1f%cB", float64(n)/float64(div), "KMGTP"[exp])
+}
+
+// ParseBytes parses a human readable byte count like "512", "4KB" or "1.5MB", in the same
+// 1024 based units as FormatBytes. Units are case insensitive and the "B" suffix is optional.
+func ParseBytes(s string) (uint64, error) {
+	num := strings.ToUpper(strings.TrimSpace(s))
+	num = strings.TrimSuffix(num, "B")
+	multiplier := uint64(1)
+	if n := len(num); n > 0 {
+		if exp := strings.IndexByte("KMGTP", num[n-1]); exp >= 0 {
+			multiplier = uint64(1) << (10 * (exp + 1))
+			num = num[:n-1]
+		}
+	}
+	v, err := strconv.ParseFloat(strings.TrimSpace(num), 64)
+	if err != nil || v < 0 {
+		return 0, fmt.Errorf("invalid byte size %q", s)
+	}
+	return uint64(v * float64(multiplier)), nil
+}
@@ -0,0 +1,27 @@
+package util
+
+import (
+	"testing"
+
+	"github.com/stretchr/testify/require"
+)
+
+func TestParseBytes(t *testing.T) {
+	for s, expected := range map[string]uint64{
+		"0":      0,
+		"512":    512,
+		"512B":   512,
+		"4KB":    4 << 10,
+		"4k":     4 << 10,
+		" 1.5MB": 3 << 19,
+		"2GB":    2 << 30,
+	} {
+		v, err := ParseBytes(s)
+		require.NoError(t, err, s)
+		require.Equal(t, expected, v, s)
+	}
+	for _, s := range []string{"", "KB", "-1KB", "1XB", "abc"} {
+		_, err := ParseBytes(s)
+		require.Error(t, err, s)
+	}
+}
@@ -0,0 +1,66 @@
+package util
+
+import (
+	"net/url"
+	"reflect"
+)
+
+// RedactedValue replaces secrets, the same as url.URL.Redacted does for passwords.
+const RedactedValue = "xxxxx"
+
+// Redact returns a copy of the struct where sensitive string fields are redacted, so that it
+// can be shown or logged. Sensitive fields are marked by a tag, searched in nested structs:
+//
+//	Token string `redact:"true"` // Replaced entirely if not empty
+//	URL   string `redact:"url"`  // Credentials are replaced, see RedactURL
+func Redact[T any](v T) T {
+	rv := reflect.ValueOf(&v).Elem()
+	redactValue(rv)
+	return v
+}
+
+func redactValue(v reflect.Value) {
+	if v.Kind() != reflect.Struct {
+		return
+	}
+	t := v.Type()
+	for i := 0; i < t.NumField(); i++ {
+		field := v.Field(i)
+		if !t.Field(i).IsExported() {
+			continue
+		}
+		switch {
+		case field.Kind() == reflect.Struct:
+			redactValue(field)
+		case field.Kind() == reflect.String && field.String() != "":
+			switch t.Field(i).Tag.Get("redact") {
+			case "true":
+				field.SetString(RedactedValue)
+			case "url":
+				field.SetString(RedactURL(field.String()))
+			}
+		}
+	}
+}
+
+// RedactURL replaces the user info and query values of the URL, which may carry credentials,
+// e.g. "s3://key:secret@bucket?sig=abc" becomes "s3://xxxxx@bucket?sig=xxxxx". Scheme, host
+// and path are kept, so that it is still clear which bucket is used. The whole URL is replaced
+// if it cannot be parsed.
+func RedactURL(s string) string {
+	u, err := url.Parse(s)
+	if err != nil {
+		return RedactedValue
+	}
+	if u.User != nil {
+		u.User = url.User(RedactedValue)
+	}
+	if u.RawQuery != "" {
+		query := u.Query()
+		for key := range query {
+			query[key] = []string{RedactedValue}
+		}
+		u.RawQuery = query.Encode()
+	}
+	return u.String()
+}
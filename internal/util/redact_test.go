@@ -0,0 +1,47 @@
+package util
+
+import (
+	"testing"
+
+	"github.com/stretchr/testify/require"
+)
+
+func TestRedactURL(t *testing.T) {
+	for input, expected := range map[string]string{
+		"":                                     "",
+		"s3://bucket":                          "s3://bucket",
+		"file:///tmp/cache":                    "file:///tmp/cache",
+		"s3://key:secret@bucket/prefix":        "s3://xxxxx@bucket/prefix",
+		"azblob://container?sig=abc&sv=2020":   "azblob://container?sig=xxxxx&sv=xxxxx",
+		"https://user@host/path?token=abc#top": "https://xxxxx@host/path?token=xxxxx#top",
+		"://bad":                               RedactedValue,
+	} {
+		require.Equal(t, expected, RedactURL(input), input)
+	}
+}
+
+func TestRedact(t *testing.T) {
+	type inner struct {
+		URL   string `redact:"url"`
+		Plain string
+	}
+	type config struct {
+		Token  string `redact:"true"`
+		Empty  string `redact:"true"`
+		Inner  inner
+		Number int
+	}
+	original := config{
+		Token:  "secret",
+		Inner:  inner{URL: "s3://bucket?key=secret", Plain: "plain"},
+		Number: 1,
+	}
+	require.Equal(t, config{
+		Token:  RedactedValue,
+		Inner:  inner{URL: "s3://bucket?key=xxxxx", Plain: "plain"},
+		Number: 1,
+	}, Redact(original))
+	// The original is not modified
+	require.Equal(t, "secret", original.Token)
+	require.Equal(t, "s3://bucket?key=secret", original.Inner.URL)
+}
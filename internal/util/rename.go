@@ -0,0 +1,65 @@
+package util
+
+import (
+	"errors"
+	"fmt"
+	"io"
+	"os"
+	"path/filepath"
+	"syscall"
+
+	gonanoid "github.com/matoous/go-nanoid/v2"
+)
+
+// rename can be replaced in tests to simulate renaming across devices.
+var rename = os.Rename
+
+// RenameFile is os.Rename, but when oldPath and newPath are on different devices (EXDEV),
+// it falls back to copying oldPath to a temp file next to newPath, fsync, then renaming
+// within the target dir. So that newPath is still installed atomically. oldPath is removed
+// once the copy is installed.
+func RenameFile(oldPath, newPath string) error {
+	err := rename(oldPath, newPath)
+	if err == nil || !errors.Is(err, syscall.EXDEV) {
+		return err
+	}
+	if err := copyFileAtomic(oldPath, newPath); err != nil {
+		return fmt.Errorf("failed to copy across devices: %w", err)
+	}
+	_ = os.Remove(oldPath)
+	return nil
+}
+
+func copyFileAtomic(srcPath, dstPath string) error {
+	src, err := os.Open(srcPath)
+	if err != nil {
+		return err
+	}
+	defer src.Close()
+	info, err := src.Stat()
+	if err != nil {
+		return err
+	}
+
+	dstPathTmp := filepath.Join(filepath.Dir(dstPath), "."+filepath.Base(dstPath)+".tmp."+gonanoid.Must(8))
+	dst, err := os.OpenFile(dstPathTmp, os.O_CREATE|os.O_EXCL|os.O_WRONLY, info.Mode().Perm())
+	if err != nil {
+		return err
+	}
+	_, err = io.Copy(dst, src)
+	if err == nil {
+		err = dst.Sync()
+	}
+	if closeErr := dst.Close(); err == nil {
+		err = closeErr
+	}
+	if err == nil {
+		// Both are in the target dir, so this never crosses devices.
+		err = os.Rename(dstPathTmp, dstPath)
+	}
+	if err != nil {
+		_ = os.Remove(dstPathTmp)
+		return err
+	}
+	return nil
+}
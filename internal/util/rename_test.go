@@ -0,0 +1,67 @@
+package util
+
+import (
+	"errors"
+	"os"
+	"path/filepath"
+	"syscall"
+	"testing"
+
+	"github.com/stretchr/testify/require"
+)
+
+func stubRename(t *testing.T, fn func(oldPath, newPath string) error) {
+	original := rename
+	rename = fn
+	t.Cleanup(func() { rename = original })
+}
+
+func TestRenameFile(t *testing.T) {
+	dir := t.TempDir()
+	src := filepath.Join(dir, "src")
+	dst := filepath.Join(dir, "dst")
+	require.NoError(t, os.WriteFile(src, []byte("hello"), 0600))
+	require.NoError(t, RenameFile(src, dst))
+	require.NoFileExists(t, src)
+	data, err := os.ReadFile(dst)
+	require.NoError(t, err)
+	require.Equal(t, "hello", string(data))
+}
+
+func TestRenameFile_CrossDevice(t *testing.T) {
+	stubRename(t, func(oldPath, newPath string) error {
+		return &os.LinkError{Op: "rename", Old: oldPath, New: newPath, Err: syscall.EXDEV}
+	})
+
+	srcDir := t.TempDir()
+	dstDir := t.TempDir()
+	src := filepath.Join(srcDir, "src")
+	dst := filepath.Join(dstDir, "dst")
+	require.NoError(t, os.WriteFile(src, []byte("hello"), 0600))
+	// Existing target is replaced, like rename does.
+	require.NoError(t, os.WriteFile(dst, []byte("old"), 0644))
+
+	require.NoError(t, RenameFile(src, dst))
+	require.NoFileExists(t, src)
+	data, err := os.ReadFile(dst)
+	require.NoError(t, err)
+	require.Equal(t, "hello", string(data))
+	info, err := os.Stat(dst)
+	require.NoError(t, err)
+	require.Equal(t, os.FileMode(0600), info.Mode().Perm())
+	// No temp file is left in the target dir.
+	entries, err := os.ReadDir(dstDir)
+	require.NoError(t, err)
+	require.Len(t, entries, 1)
+}
+
+func TestRenameFile_OtherErrorNotRetried(t *testing.T) {
+	errFoo := errors.New("foo")
+	stubRename(t, func(oldPath, newPath string) error {
+		return errFoo
+	})
+	src := filepath.Join(t.TempDir(), "src")
+	require.NoError(t, os.WriteFile(src, []byte("hello"), 0600))
+	require.ErrorIs(t, RenameFile(src, filepath.Join(t.TempDir(), "dst")), errFoo)
+	require.FileExists(t, src)
+}
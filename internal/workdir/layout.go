@@ -0,0 +1,154 @@
+package workdir
+
+import (
+	"encoding/json"
+	"fmt"
+	"os"
+	"path/filepath"
+)
+
+// The work dir records the version of its layout (the structure of data/ and blobar/ and the
+// format of file names in them) in a marker file. A gscache version changing the layout bumps
+// CurrentVersion and registers a migration from the previous version, so that existing caches
+// are converted instead of silently orphaned.
+
+const (
+	// CurrentVersion is the layout version written by this gscache version.
+	CurrentVersion = 1
+	// MarkerFileName is the name of the layout marker in the work dir.
+	MarkerFileName = "layout.json"
+)
+
+// layoutEntries are the top level entries of a work dir which hold cache data. A work dir
+// without a marker but with any of them was written before the marker was introduced.
+var layoutEntries = []string{"data", "blobar"}
+
+// Migration converts the work dir from layout version From to From+1.
+type Migration struct {
+	From        int
+	Description string
+	Apply       func(dir string) error
+	// If true, the layout before the migration can still be served as is, so that a read-only
+	// work dir, which cannot be migrated, can be used.
+	ReadCompatible bool
+}
+
+// migrations must be ordered by From, and contain a migration for every version below
+// CurrentVersion.
+var migrations = []Migration{
+	{
+		From:        0,
+		Description: "record the layout version of a work dir created before layout versioning",
+		Apply:       func(dir string) error { return nil },
+		// The layout is not changed
+		ReadCompatible: true,
+	},
+}
+
+type marker struct {
+	Version int `json:"version"`
+}
+
+func markerPath(dir string) string {
+	return filepath.Join(dir, MarkerFileName)
+}
+
+// ReadVersion returns the layout version of the work dir. A work dir without cache data is
+// considered to be of CurrentVersion, and a work dir with cache data but without a marker is
+// of version 0.
+func ReadVersion(dir string) (int, error) {
+	content, err := os.ReadFile(markerPath(dir))
+	if err == nil {
+		var m marker
+		if err := json.Unmarshal(content, &m); err != nil {
+			return 0, fmt.Errorf("invalid layout marker %s: %w", markerPath(dir), err)
+		}
+		return m.Version, nil
+	}
+	if !os.IsNotExist(err) {
+		return 0, err
+	}
+	for _, name := range layoutEntries {
+		if _, err := os.Stat(filepath.Join(dir, name)); err == nil {
+			return 0, nil
+		}
+	}
+	return CurrentVersion, nil
+}
+
+func writeVersion(dir string, version int) error {
+	content, err := json.Marshal(marker{Version: version})
+	if err != nil {
+		return err
+	}
+	tmp := markerPath(dir) + ".tmp"
+	if err := os.WriteFile(tmp, content, 0644); err != nil {
+		return err
+	}
+	return os.Rename(tmp, markerPath(dir))
+}
+
+// Pending returns migrations needed to bring the work dir to CurrentVersion, in order. It fails
+// if the work dir was written by a newer gscache, which must not be opened by this version.
+func Pending(dir string) ([]Migration, error) {
+	version, err := ReadVersion(dir)
+	if err != nil {
+		return nil, err
+	}
+	if version > CurrentVersion {
+		return nil, fmt.Errorf("work dir %s has layout version %d, which is newer than %d supported by this gscache, upgrade gscache or use a different dir",
+			dir, version, CurrentVersion)
+	}
+	var pending []Migration
+	for _, m := range migrations {
+		if m.From >= version {
+			pending = append(pending, m)
+		}
+	}
+	return pending, nil
+}
+
+// Migrate applies pending migrations and records the new version after each of them, so that an
+// interrupted migration is resumed from the failed step. The work dir must be locked by the
+// caller. onApply, if not nil, is called before each migration.
+func Migrate(dir string, onApply func(m Migration)) error {
+	pending, err := Pending(dir)
+	if err != nil {
+		return err
+	}
+	for _, m := range pending {
+		if onApply != nil {
+			onApply(m)
+		}
+		if err := m.Apply(dir); err != nil {
+			return fmt.Errorf("failed to migrate work dir %s from layout version %d: %w", dir, m.From, err)
+		}
+		if err := writeVersion(dir, m.From+1); err != nil {
+			return fmt.Errorf("failed to write layout marker: %w", err)
+		}
+	}
+	if _, err := os.Stat(markerPath(dir)); os.IsNotExist(err) {
+		// A new work dir
+		return writeVersion(dir, CurrentVersion)
+	}
+	return nil
+}
+
+// CheckReadOnly checks whether a read-only work dir, which cannot be migrated, can be served
+// by this gscache version.
+func CheckReadOnly(dir string) error {
+	version, err := ReadVersion(dir)
+	if err != nil {
+		return err
+	}
+	pending, err := Pending(dir)
+	if err != nil {
+		return err
+	}
+	for _, m := range pending {
+		if !m.ReadCompatible {
+			return fmt.Errorf("read-only work dir %s has layout version %d, run `gscache migrate` on a writable copy first", dir, version)
+		}
+	}
+	return nil
+}
@@ -0,0 +1,95 @@
+package workdir
+
+import (
+	"errors"
+	"os"
+	"path/filepath"
+	"testing"
+
+	"github.com/stretchr/testify/require"
+)
+
+func TestReadVersion(t *testing.T) {
+	dir := t.TempDir()
+	version, err := ReadVersion(dir)
+	require.NoError(t, err)
+	require.Equal(t, CurrentVersion, version)
+
+	// Written before layout versioning
+	require.NoError(t, os.MkdirAll(filepath.Join(dir, "data"), 0755))
+	version, err = ReadVersion(dir)
+	require.NoError(t, err)
+	require.Equal(t, 0, version)
+
+	require.NoError(t, os.WriteFile(filepath.Join(dir, MarkerFileName), []byte(`{"version":7}`), 0644))
+	version, err = ReadVersion(dir)
+	require.NoError(t, err)
+	require.Equal(t, 7, version)
+
+	require.NoError(t, os.WriteFile(filepath.Join(dir, MarkerFileName), []byte(`garbage`), 0644))
+	_, err = ReadVersion(dir)
+	require.Error(t, err)
+}
+
+func TestMigrate(t *testing.T) {
+	dir := t.TempDir()
+	require.NoError(t, Migrate(dir, nil))
+	require.FileExists(t, filepath.Join(dir, MarkerFileName))
+	pending, err := Pending(dir)
+	require.NoError(t, err)
+	require.Empty(t, pending)
+
+	dir = t.TempDir()
+	require.NoError(t, os.MkdirAll(filepath.Join(dir, "blobar"), 0755))
+	var applied []int
+	require.NoError(t, Migrate(dir, func(m Migration) { applied = append(applied, m.From) }))
+	require.Equal(t, []int{0}, applied)
+	version, err := ReadVersion(dir)
+	require.NoError(t, err)
+	require.Equal(t, CurrentVersion, version)
+
+	// Newer than supported
+	require.NoError(t, writeVersion(dir, CurrentVersion+1))
+	require.ErrorContains(t, Migrate(dir, nil), "newer")
+}
+
+func TestMigrate_Resume(t *testing.T) {
+	original := migrations
+	defer func() { migrations = original }()
+
+	failing := true
+	migrations = []Migration{
+		{From: 0, Apply: func(dir string) error { return nil }, ReadCompatible: true},
+		{From: 1, Apply: func(dir string) error {
+			if failing {
+				return errors.New("boom")
+			}
+			return nil
+		}},
+	}
+	dir := t.TempDir()
+	require.NoError(t, os.MkdirAll(filepath.Join(dir, "data"), 0755))
+	require.ErrorContains(t, CheckReadOnly(dir), "layout version 0")
+	require.ErrorContains(t, Migrate(dir, nil), "boom")
+	version, err := ReadVersion(dir)
+	require.NoError(t, err)
+	require.Equal(t, 1, version)
+
+	failing = false
+	var applied []int
+	require.NoError(t, Migrate(dir, func(m Migration) { applied = append(applied, m.From) }))
+	require.Equal(t, []int{1}, applied)
+	version, err = ReadVersion(dir)
+	require.NoError(t, err)
+	require.Equal(t, 2, version)
+}
+
+func TestCheckReadOnly(t *testing.T) {
+	dir := t.TempDir()
+	require.NoError(t, CheckReadOnly(dir))
+	// The migration from a work dir without marker does not change the layout
+	require.NoError(t, os.MkdirAll(filepath.Join(dir, "data"), 0755))
+	require.NoError(t, CheckReadOnly(dir))
+	require.NoError(t, writeVersion(dir, CurrentVersion+1))
+	require.ErrorContains(t, CheckReadOnly(dir), "newer")
+}